@@ -0,0 +1,389 @@
+package bean
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Account is a multi currency margin account: cash balances per coin and the derivatives positions they margin.
+// Balances hold realized PnL, fees, funding and settlements in the LHS coin of each contract, as on deribit;
+// open positions carry their unrealized PnL (including option premium) through Position.PV.
+// It is safe for concurrent use
+type Account struct {
+	m         sync.Mutex
+	margin    MarginSchedule
+	balances  map[Coin]float64
+	positions map[string]Position
+	fees      map[Coin]float64
+	funding   map[Coin]float64
+	equity    TimeSeries
+}
+
+// NewAccount returns an empty account with a margin schedule
+func NewAccount(ms MarginSchedule) *Account {
+	return &Account{
+		margin:    ms,
+		balances:  make(map[Coin]float64),
+		positions: make(map[string]Position),
+		fees:      make(map[Coin]float64),
+		funding:   make(map[Coin]float64),
+	}
+}
+
+// Deposit adds an amount of a coin to the account
+func (a *Account) Deposit(c Coin, amount float64) {
+	a.m.Lock()
+	defer a.m.Unlock()
+	a.balances[c] += amount
+}
+
+// Withdraw removes an amount of a coin, failing with ErrInsufficientFunds if the balance is too small
+func (a *Account) Withdraw(c Coin, amount float64) error {
+	a.m.Lock()
+	defer a.m.Unlock()
+	if a.balances[c] < amount {
+		return fmt.Errorf("%w: withdraw %v %s, balance %v", ErrInsufficientFunds, amount, c, a.balances[c])
+	}
+	a.balances[c] -= amount
+	return nil
+}
+
+// SetBalance sets the balance of a coin, as reported by an exchange
+func (a *Account) SetBalance(c Coin, amount float64) {
+	a.m.Lock()
+	defer a.m.Unlock()
+	a.balances[c] = amount
+}
+
+func (a *Account) Balance(c Coin) float64 {
+	a.m.Lock()
+	defer a.m.Unlock()
+	return a.balances[c]
+}
+
+// Balances returns a copy of the cash balances
+func (a *Account) Balances() map[Coin]float64 {
+	a.m.Lock()
+	defer a.m.Unlock()
+	res := make(map[Coin]float64, len(a.balances))
+	for c, b := range a.balances {
+		res[c] = b
+	}
+	return res
+}
+
+// Fees returns the fees paid in a coin
+func (a *Account) Fees(c Coin) float64 {
+	a.m.Lock()
+	defer a.m.Unlock()
+	return a.fees[c]
+}
+
+// FundingPaid returns the net perpetual funding paid in a coin, negative if received
+func (a *Account) FundingPaid(c Coin) float64 {
+	a.m.Lock()
+	defer a.m.Unlock()
+	return a.funding[c]
+}
+
+// Position returns the open position on a contract
+func (a *Account) Position(name string) (Position, bool) {
+	a.m.Lock()
+	defer a.m.Unlock()
+	p, ok := a.positions[name]
+	return p, ok
+}
+
+// Positions returns the open positions sorted by contract
+func (a *Account) Positions() []Position {
+	a.m.Lock()
+	defer a.m.Unlock()
+	res := make([]Position, 0, len(a.positions))
+	for _, p := range a.positions {
+		res = append(res, p)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Before(res[j].Contract) })
+	return res
+}
+
+// realize returns the PnL in LHS coin of closing qty (signed as the position) of a position at a price
+func (p Position) realize(qty, price float64) float64 {
+	if p.IsOption() {
+		return (price - p.price) * qty
+	}
+	return (1.0/p.price - 1.0/price) * qty * p.Multiplier()
+}
+
+// Fill applies a trade of qty contracts (negative to sell) at price, option prices in LHS coin and futures in
+// RHS coin, and a fee in LHS coin. The part closing the open position realizes its PnL into the balance, the rest
+// opens or adds to it at the average entry price (harmonic for inverse futures). Returns the PnL realized
+func (a *Account) Fill(c *Contract, qty, price, fee float64) (realized float64) {
+	a.m.Lock()
+	defer a.m.Unlock()
+	coin := c.Underlying().Coin
+	a.balances[coin] -= fee
+	a.fees[coin] += fee
+
+	pos, ok := a.positions[c.Name()]
+	if !ok || pos.qty == 0 {
+		a.positions[c.Name()] = NewPosition(c, qty, price)
+		return 0
+	}
+	if pos.qty*qty < 0 {
+		closed := math.Copysign(math.Min(math.Abs(qty), math.Abs(pos.qty)), pos.qty)
+		realized = pos.realize(closed, price)
+		a.balances[coin] += realized
+		pos.qty -= closed
+		qty += closed
+	}
+	switch {
+	case qty == 0:
+	case pos.qty == 0:
+		pos.price = price
+		pos.qty = qty
+	case c.IsOption():
+		pos.price = (pos.qty*pos.price + qty*price) / (pos.qty + qty)
+		pos.qty += qty
+	default:
+		pos.price = (pos.qty + qty) / (pos.qty/pos.price + qty/price)
+		pos.qty += qty
+	}
+	if pos.qty == 0 {
+		delete(a.positions, c.Name())
+	} else {
+		a.positions[c.Name()] = pos
+	}
+	return
+}
+
+// ApplyFunding charges the funding of a perpetual at a rate over the funding interval (longs pay when positive)
+// on the position notional at the mark price, returning the amount paid in LHS coin
+func (a *Account) ApplyFunding(c *Contract, rate, mark float64) float64 {
+	a.m.Lock()
+	defer a.m.Unlock()
+	pos, ok := a.positions[c.Name()]
+	if !ok || !c.Perp() {
+		return 0
+	}
+	paid := pos.notional() / mark * rate
+	coin := c.Underlying().Coin
+	a.balances[coin] -= paid
+	a.funding[coin] += paid
+	return paid
+}
+
+// Settle closes the position on an expired contract at the settlement price, paying its settlement value and the
+// settlement fee into the balance. Returns the value settled net of the fee
+func (a *Account) Settle(c *Contract, settlePrice float64, r FeeRate) float64 {
+	a.m.Lock()
+	defer a.m.Unlock()
+	pos, ok := a.positions[c.Name()]
+	if !ok {
+		return 0
+	}
+	coin := c.Underlying().Coin
+	value := pos.SettlementValue(settlePrice)
+	fee := pos.SettlementFee(r, settlePrice)
+	a.balances[coin] += value - fee
+	a.fees[coin] += fee
+	delete(a.positions, c.Name())
+	return value - fee
+}
+
+// CoinEquity returns the equity of the account in a coin: its balance plus the unrealized PnL of the positions
+// margined in it, converted from RHS coin at their spot price
+func (a *Account) CoinEquity(asof time.Time, mkt PositionMarket, c Coin) float64 {
+	a.m.Lock()
+	defer a.m.Unlock()
+	return a.coinEquity(asof, mkt, c)
+}
+
+func (a *Account) coinEquity(asof time.Time, mkt PositionMarket, c Coin) float64 {
+	equity := a.balances[c]
+	for _, p := range a.positions {
+		if p.Underlying().Coin == c {
+			spot, fut, vol := mkt(p)
+			equity += p.PV(asof, spot, fut, vol) / spot
+		}
+	}
+	return equity
+}
+
+// Equity returns the total equity in a reporting currency, given the value of one unit of each coin in that
+// currency (e.g. {BTC: 1, USD: 1/spot} to report in BTC). Coins without a rate count as zero
+func (a *Account) Equity(asof time.Time, mkt PositionMarket, rates map[Coin]float64) float64 {
+	a.m.Lock()
+	defer a.m.Unlock()
+	var equity float64
+	for c, b := range a.balances {
+		equity += b * rates[c]
+	}
+	for _, p := range a.positions {
+		spot, fut, vol := mkt(p)
+		equity += p.PV(asof, spot, fut, vol) * rates[p.Underlying().Base]
+	}
+	return equity
+}
+
+// optionMargin is the margin of a short option in LHS coin per contract
+func (a *Account) optionMargin(asof time.Time, p Position, spot, fut, vol float64) float64 {
+	otm := math.Max(p.Strike()-spot, 0)
+	if p.CallPut() == Put {
+		otm = math.Max(spot-p.Strike(), 0)
+	}
+	price, _ := p.OptPrice(asof, spot, fut, vol)
+	return math.Max(a.margin.ShortOptionRate-otm/spot, a.margin.ShortOptionMinRate) + price/spot
+}
+
+func (a *Account) requiredMargin(asof time.Time, mkt PositionMarket, maintenance bool) map[Coin]float64 {
+	res := make(map[Coin]float64)
+	for _, p := range a.positions {
+		spot, fut, vol := mkt(p)
+		coin := p.Underlying().Coin
+		switch {
+		case p.IsOption() && p.qty < 0:
+			res[coin] += a.optionMargin(asof, p, spot, fut, vol) * -p.qty
+		case p.IsOption():
+		case maintenance:
+			res[coin] += p.MaintenanceMargin(fut, a.margin)
+		default:
+			res[coin] += p.InitialMargin(fut, a.margin)
+		}
+	}
+	return res
+}
+
+// InitialMargin returns the initial margin used per coin. Short options use the same margin for maintenance
+func (a *Account) InitialMargin(asof time.Time, mkt PositionMarket) map[Coin]float64 {
+	a.m.Lock()
+	defer a.m.Unlock()
+	return a.requiredMargin(asof, mkt, false)
+}
+
+// MaintenanceMargin returns the maintenance margin per coin
+func (a *Account) MaintenanceMargin(asof time.Time, mkt PositionMarket) map[Coin]float64 {
+	a.m.Lock()
+	defer a.m.Unlock()
+	return a.requiredMargin(asof, mkt, true)
+}
+
+// AvailableMargin returns the equity in a coin not used as initial margin
+func (a *Account) AvailableMargin(asof time.Time, mkt PositionMarket, c Coin) float64 {
+	a.m.Lock()
+	defer a.m.Unlock()
+	return a.coinEquity(asof, mkt, c) - a.requiredMargin(asof, mkt, false)[c]
+}
+
+// CheckMargin returns ErrInsufficientFunds if the account would not have the initial margin for its positions
+// after trading qty of a contract at price
+func (a *Account) CheckMargin(asof time.Time, mkt PositionMarket, c *Contract, qty, price float64) error {
+	a.m.Lock()
+	trial := &Account{margin: a.margin, balances: make(map[Coin]float64), positions: make(map[string]Position),
+		fees: make(map[Coin]float64), funding: make(map[Coin]float64)}
+	for coin, b := range a.balances {
+		trial.balances[coin] = b
+	}
+	for name, p := range a.positions {
+		trial.positions[name] = p
+	}
+	a.m.Unlock()
+
+	trial.Fill(c, qty, price, 0)
+	coin := c.Underlying().Coin
+	if avail := trial.AvailableMargin(asof, mkt, coin); avail < 0 {
+		return fmt.Errorf("%w: %v %s short of initial margin after %v %s", ErrInsufficientFunds, -avail, coin, qty, c.Name())
+	}
+	return nil
+}
+
+// MarginCall is true when the equity in a coin is below its maintenance margin
+func (a *Account) MarginCall(asof time.Time, mkt PositionMarket, c Coin) bool {
+	a.m.Lock()
+	defer a.m.Unlock()
+	return a.coinEquity(asof, mkt, c) < a.requiredMargin(asof, mkt, true)[c]
+}
+
+// RecordEquity appends a point to the equity curve, e.g. the result of Equity at each step of a backtest
+func (a *Account) RecordEquity(t time.Time, equity float64) {
+	a.m.Lock()
+	defer a.m.Unlock()
+	a.equity = append(a.equity, TimePoint{Time: t, Value: equity})
+}
+
+// EquityCurve returns a copy of the recorded equity curve
+func (a *Account) EquityCurve() TimeSeries {
+	a.m.Lock()
+	defer a.m.Unlock()
+	return append(TimeSeries(nil), a.equity...)
+}
+
+// PositionState is a position of an AccountState
+type PositionState struct {
+	Instrument string
+	Qty        float64
+	Price      float64
+}
+
+// AccountState is the content of an Account, which can be saved and restored
+type AccountState struct {
+	Balances  map[Coin]float64
+	Positions []PositionState
+	Fees      map[Coin]float64
+	Funding   map[Coin]float64
+	Equity    TimeSeries
+}
+
+// State returns a copy of the content of the account
+func (a *Account) State() AccountState {
+	a.m.Lock()
+	defer a.m.Unlock()
+	s := AccountState{
+		Balances: make(map[Coin]float64, len(a.balances)),
+		Fees:     make(map[Coin]float64, len(a.fees)),
+		Funding:  make(map[Coin]float64, len(a.funding)),
+		Equity:   append(TimeSeries(nil), a.equity...),
+	}
+	for c, x := range a.balances {
+		s.Balances[c] = x
+	}
+	for c, x := range a.fees {
+		s.Fees[c] = x
+	}
+	for c, x := range a.funding {
+		s.Funding[c] = x
+	}
+	for name, p := range a.positions {
+		s.Positions = append(s.Positions, PositionState{Instrument: name, Qty: p.qty, Price: p.price})
+	}
+	sort.Slice(s.Positions, func(i, j int) bool { return s.Positions[i].Instrument < s.Positions[j].Instrument })
+	return s
+}
+
+// Restore replaces the content of the account with a state, keeping the margin schedule. Nothing is replaced if
+// an instrument cannot be parsed
+func (a *Account) Restore(s AccountState) error {
+	positions := make(map[string]Position, len(s.Positions))
+	for _, p := range s.Positions {
+		c, err := ContractFromName(p.Instrument)
+		if err != nil {
+			return err
+		}
+		positions[c.Name()] = NewPosition(c, p.Qty, p.Price)
+	}
+	copyMap := func(m map[Coin]float64) map[Coin]float64 {
+		res := make(map[Coin]float64, len(m))
+		for c, x := range m {
+			res[c] = x
+		}
+		return res
+	}
+	a.m.Lock()
+	defer a.m.Unlock()
+	a.balances, a.fees, a.funding = copyMap(s.Balances), copyMap(s.Fees), copyMap(s.Funding)
+	a.positions = positions
+	a.equity = append(TimeSeries(nil), s.Equity...)
+	return nil
+}
@@ -0,0 +1,284 @@
+package bean
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// AlertPayload is what a condition observed when it was evaluated
+type AlertPayload struct {
+	Value     float64            // the quantity tested
+	Threshold float64            // level the value is compared with, NaN if none
+	Reference float64            // level the value moved from for MovedBy, NaN otherwise
+	Data      map[string]float64 // other figures of the condition by name, as the sides of a spread
+}
+
+// AlertCondition evaluates live data, returning what it observed and whether the alert condition holds. Conditions
+// are evaluated one at a time, without the lock of the Alerts
+type AlertCondition func() (payload AlertPayload, active bool)
+
+// Alert is a condition on live data to be told about. It fires once the condition has held for Debounce, then is
+// disarmed until the condition has stopped holding for Rearm, so a condition flickering around its threshold
+// fires once. Cooldown is the least time between two firings
+type Alert struct {
+	Name      string
+	Condition AlertCondition
+	Debounce  time.Duration
+	Rearm     time.Duration
+	Cooldown  time.Duration
+}
+
+// AlertKind is the type of an AlertEvent
+type AlertKind int
+
+const (
+	AlertFired   AlertKind = iota // the condition has held for the debounce time
+	AlertRearmed                  // the condition stopped holding and the alert can fire again
+)
+
+func (k AlertKind) String() string {
+	if k == AlertFired {
+		return "fired"
+	}
+	return "rearmed"
+}
+
+// AlertEvent is sent to the handlers of Alerts when an alert fires or is rearmed
+type AlertEvent struct {
+	Alert string
+	Kind  AlertKind
+	Time    time.Time
+	Payload AlertPayload // observed by the condition at Time
+	Since   time.Time    // when the condition started, or stopped, holding
+}
+
+type alertState struct {
+	Alert
+	armed     bool
+	since     time.Time // when the condition started holding, zero if it does not
+	clear     time.Time // when it stopped holding after firing, zero if it holds
+	lastFired time.Time
+}
+
+// Alerts checks registered alerts against live data, calling handlers and feeding channels with the events of the
+// alerts firing and rearming. Conditions are evaluated by Check, at the time of the data in backtests, or every
+// interval by Run. It is safe for concurrent use
+type Alerts struct {
+	checking sync.Mutex // serializes Check, as conditions may keep state
+	m        sync.Mutex
+	alerts   []*alertState
+	handlers []func(AlertEvent)
+	chans    []chan AlertEvent
+}
+
+// NewAlerts returns alerts with none registered
+func NewAlerts() *Alerts {
+	return new(Alerts)
+}
+
+// Add registers an alert, armed, replacing the one of the same name
+func (a *Alerts) Add(alert Alert) {
+	a.m.Lock()
+	defer a.m.Unlock()
+	s := &alertState{Alert: alert, armed: true}
+	for i, old := range a.alerts {
+		if old.Name == alert.Name {
+			a.alerts[i] = s
+			return
+		}
+	}
+	a.alerts = append(a.alerts, s)
+}
+
+// Remove unregisters an alert
+func (a *Alerts) Remove(name string) {
+	a.m.Lock()
+	defer a.m.Unlock()
+	for i, s := range a.alerts {
+		if s.Name == name {
+			a.alerts = append(a.alerts[:i], a.alerts[i+1:]...)
+			return
+		}
+	}
+}
+
+// Armed returns whether an alert is registered and can fire
+func (a *Alerts) Armed(name string) bool {
+	a.m.Lock()
+	defer a.m.Unlock()
+	for _, s := range a.alerts {
+		if s.Name == name {
+			return s.armed
+		}
+	}
+	return false
+}
+
+// OnAlert adds a handler called with the events of all alerts, in the goroutine calling Check
+func (a *Alerts) OnAlert(f func(AlertEvent)) {
+	a.m.Lock()
+	defer a.m.Unlock()
+	a.handlers = append(a.handlers, f)
+}
+
+// Events returns a channel receiving the events of all alerts. Events are dropped when its buffer is full
+func (a *Alerts) Events(buffer int) <-chan AlertEvent {
+	a.m.Lock()
+	defer a.m.Unlock()
+	ch := make(chan AlertEvent, buffer)
+	a.chans = append(a.chans, ch)
+	return ch
+}
+
+// Check evaluates the conditions of the alerts at t and sends the events of the alerts firing or rearming. The
+// conditions are evaluated without holding the lock, so slow ones do not block registration
+func (a *Alerts) Check(t time.Time) {
+	a.checking.Lock()
+	defer a.checking.Unlock()
+	a.m.Lock()
+	alerts := append([]*alertState(nil), a.alerts...)
+	a.m.Unlock()
+	payloads := make([]AlertPayload, len(alerts))
+	active := make([]bool, len(alerts))
+	for i, s := range alerts {
+		payloads[i], active[i] = s.Condition()
+	}
+
+	a.m.Lock()
+	registered := make(map[*alertState]bool, len(a.alerts))
+	for _, s := range a.alerts {
+		registered[s] = true
+	}
+	var events []AlertEvent
+	for i, s := range alerts {
+		if !registered[s] {
+			continue // removed or replaced while evaluated
+		}
+		if e, ok := s.update(t, payloads[i], active[i]); ok {
+			events = append(events, e)
+		}
+	}
+	handlers, chans := a.handlers, a.chans
+	a.m.Unlock()
+
+	for _, e := range events {
+		for _, f := range handlers {
+			f(e)
+		}
+		for _, ch := range chans {
+			select {
+			case ch <- e:
+			default:
+			}
+		}
+	}
+}
+
+// update moves the state of an alert on a condition evaluated at t, returning the event of a change
+func (s *alertState) update(t time.Time, payload AlertPayload, active bool) (AlertEvent, bool) {
+	e := AlertEvent{Alert: s.Name, Time: t, Payload: payload}
+	if active {
+		s.clear = time.Time{}
+		if s.since.IsZero() {
+			s.since = t
+		}
+		if s.armed && t.Sub(s.since) >= s.Debounce && (s.lastFired.IsZero() || t.Sub(s.lastFired) >= s.Cooldown) {
+			s.armed, s.lastFired = false, t
+			e.Kind, e.Since = AlertFired, s.since
+			return e, true
+		}
+		return e, false
+	}
+	s.since = time.Time{}
+	if s.armed {
+		return e, false
+	}
+	if s.clear.IsZero() {
+		s.clear = t
+	}
+	if t.Sub(s.clear) >= s.Rearm {
+		s.armed = true
+		e.Kind, e.Since = AlertRearmed, s.clear
+		s.clear = time.Time{}
+		return e, true
+	}
+	return e, false
+}
+
+// Run checks the alerts every interval until the context is done
+func (a *Alerts) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case t := <-ticker.C:
+			a.Check(t)
+		}
+	}
+}
+
+// level returns the payload of a value compared with a threshold
+func level(v, x float64) AlertPayload {
+	return AlertPayload{Value: v, Threshold: x, Reference: math.NaN()}
+}
+
+// Above holds while the value of f is above x. NaN values never hold
+func Above(f func() float64, x float64) AlertCondition {
+	return func() (AlertPayload, bool) {
+		v := f()
+		return level(v, x), v > x
+	}
+}
+
+// Below holds while the value of f is below x
+func Below(f func() float64, x float64) AlertCondition {
+	return func() (AlertPayload, bool) {
+		v := f()
+		return level(v, x), v < x
+	}
+}
+
+// MovedBy holds while the value of f is more than y away from a reference, the first value seen. The reference
+// moves to the value each time the condition is checked holding, so each alert is for a new move of y, as for an
+// implied vol pillar repricing. It holds for one check at a time, so is not meant to be debounced. The payload
+// has the reference moved from
+func MovedBy(f func() float64, y float64) AlertCondition {
+	ref := math.NaN()
+	return func() (AlertPayload, bool) {
+		v := f()
+		p := AlertPayload{Value: v, Threshold: y, Reference: ref}
+		if math.IsNaN(ref) {
+			ref = v
+			return p, false
+		}
+		if math.Abs(v-ref) > y {
+			ref = v
+			return p, true
+		}
+		return p, false
+	}
+}
+
+// SpreadAbove holds while the spread of a book is above x, with the best bid and ask as data
+func SpreadAbove(ob *OrderBook, x float64) AlertCondition {
+	return func() (AlertPayload, bool) {
+		p := level(ob.Spread(), x)
+		p.Data = map[string]float64{"bid": ob.BestBid().Price, "ask": ob.BestAsk().Price}
+		return p, p.Value > x
+	}
+}
+
+// DeltaBreach holds while the absolute delta of a portfolio valued in a market is beyond limit, with the other
+// greeks as data
+func DeltaBreach(p Portfolio, m *Market, limit float64) AlertCondition {
+	return func() (AlertPayload, bool) {
+		g := p.GreeksMarket(m)
+		payload := level(g.Delta, limit)
+		payload.Data = map[string]float64{"gamma": g.Gamma, "vega": g.Vega, "theta": g.Theta}
+		return payload, math.Abs(g.Delta) > limit
+	}
+}
@@ -0,0 +1,129 @@
+package arb
+
+import (
+	"bean"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// CarryParams configures the cash and carry analysis of long spot, short futures positions
+type CarryParams struct {
+	Size            float64       // coins bought spot and sold forward
+	ContractSize    float64       // USD value of a contract in the futures books, zero for books in coins
+	SpotFee         bean.FeeRate  // taker fees on both legs
+	FuturesFee      bean.FeeRate  // delivery fees apply to dated futures held to expiry
+	Horizon         time.Duration // holding period of perpetual positions, over which costs are amortized
+	FundingLookback time.Duration // funding history averaged, zero for all of it
+	MinAnnualCarry  float64       // net annualized carry above which to signal
+}
+
+// Carry is the expected return of buying spot and selling a future or perpetual, as fractions of the notional.
+// Dated futures are held to expiry and converge to spot. Perpetuals are unwound at the end of the horizon at the
+// current books, collecting the average historical funding and assuming the premium has converged by then
+type Carry struct {
+	Contract     *bean.Contract
+	Time         time.Time
+	Size         float64
+	SpotPrice    float64 // average spot buy price
+	FuturesPrice float64 // average futures sell price
+	Basis        float64 // futures over spot mid, minus one
+	FundingRate  float64 // average funding per interval, perpetuals only
+	Funding      float64 // funding collected over the horizon
+	Years        float64 // to expiry or over the horizon
+	EntryCost    float64 // slippage from the mids and taker fees
+	ExitCost     float64 // unwind slippage and taker fees for perpetuals, delivery fees for futures
+	Net          float64 // basis and funding less costs over the holding period
+	AnnualCarry  float64 // Net per year
+	Signal       bool    // AnnualCarry at or above MinAnnualCarry
+}
+
+// Analyze returns the carry of a future or perpetual as of asof given the spot and futures books and, for
+// perpetuals, the funding rates per interval. Returns an error wrapping ErrNoLiquidity if the books cannot fill Size
+func (p CarryParams) Analyze(asof time.Time, c *bean.Contract, spot, futures bean.OrderBook, funding bean.TimeSeries) (Carry, error) {
+	res := Carry{Contract: c, Time: asof, Size: p.Size}
+	if c.IsOption() {
+		return res, fmt.Errorf("%w: %s", bean.ErrInvalidInput, c.Name())
+	}
+	if spot.OrderBookCore == nil || futures.OrderBookCore == nil || !spot.Valid() || !futures.Valid() {
+		return res, fmt.Errorf("%w: %s needs two sided books", bean.ErrNoLiquidity, c.Name())
+	}
+	spotMid, futMid := spot.Mid(), futures.Mid()
+	futSize := p.Size
+	if p.ContractSize > 0 {
+		futSize = p.Size * futMid / p.ContractSize
+	}
+	spotBuy, spotSell, err := sweep(spot, p.Size)
+	if err != nil {
+		return res, fmt.Errorf("%w: spot for %s", err, c.Name())
+	}
+	futBuy, futSell, err := sweep(futures, futSize)
+	if err != nil {
+		return res, fmt.Errorf("%w: %s", err, c.Name())
+	}
+	res.SpotPrice, res.FuturesPrice = spotBuy, futSell
+	res.Basis = futMid/spotMid - 1
+	res.EntryCost = (spotBuy-spotMid)/spotMid + (futMid-futSell)/futMid + (p.SpotFee.TakerBps+p.FuturesFee.TakerBps)/1e4
+
+	if c.Perp() {
+		res.Years = p.Horizon.Hours() / 24 / 365
+		res.FundingRate = averageFunding(funding, asof, p.FundingLookback)
+		res.Funding = res.FundingRate * float64(p.Horizon) / float64(bean.FundingInterval)
+		res.ExitCost = (spotMid-spotSell)/spotMid + (futBuy-futMid)/futMid + (p.SpotFee.TakerBps+p.FuturesFee.TakerBps)/1e4
+	} else {
+		res.Years = c.ExpiryYears(asof)
+		res.ExitCost = (p.SpotFee.TakerBps + p.FuturesFee.DeliveryBps) / 1e4
+	}
+	if !(res.Years > 0) {
+		return res, fmt.Errorf("%w: %s", bean.ErrExpired, c.Name())
+	}
+	res.Net = res.Basis + res.Funding - res.EntryCost - res.ExitCost
+	res.AnnualCarry = res.Net / res.Years
+	res.Signal = res.AnnualCarry >= p.MinAnnualCarry
+	return res, nil
+}
+
+// ScanCarry analyzes each contract with a futures book against the spot book, skipping those that fail, best
+// annual carry first
+func (p CarryParams) ScanCarry(asof time.Time, spot bean.OrderBook, futures map[*bean.Contract]bean.OrderBook, funding bean.TimeSeries) []Carry {
+	var res []Carry
+	for c, ob := range futures {
+		if cr, err := p.Analyze(asof, c, spot, ob, funding); err == nil {
+			res = append(res, cr)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].AnnualCarry != res[j].AnnualCarry {
+			return res[i].AnnualCarry > res[j].AnnualCarry
+		}
+		return res[i].Contract.Name() < res[j].Contract.Name()
+	})
+	return res
+}
+
+// sweep returns the average prices of buying and selling size in a book
+func sweep(ob bean.OrderBook, size float64) (buy, sell float64, err error) {
+	b := ob.Match(bean.Order{Price: math.Inf(1), Amount: size})
+	s := ob.Match(bean.Order{Price: 0, Amount: -size})
+	if b.Amount < size*(1-1e-9) || -s.Amount < size*(1-1e-9) {
+		return math.NaN(), math.NaN(), fmt.Errorf("%w: %v wanted", bean.ErrNoLiquidity, size)
+	}
+	return b.Price, s.Price, nil
+}
+
+// averageFunding returns the average rate of the funding at or before asof within lookback
+func averageFunding(funding bean.TimeSeries, asof time.Time, lookback time.Duration) float64 {
+	sum, n := 0.0, 0
+	for _, f := range funding {
+		if f.Time.After(asof) || (lookback > 0 && !f.Time.After(asof.Add(-lookback))) || math.IsNaN(f.Value) {
+			continue
+		}
+		sum += f.Value
+		n++
+	}
+	if n == 0 {
+		return 0
+	}
+	return sum / float64(n)
+}
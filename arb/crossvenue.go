@@ -0,0 +1,134 @@
+// Package arb detects executable arbitrage opportunities in orderbooks
+package arb
+
+import (
+	"bean"
+	"math"
+	"sort"
+	"time"
+)
+
+// Opportunity is an executable arbitrage: buy Size on BuyVenue up to BuyPrice and sell it on SellVenue down to
+// SellPrice. Edge is the expected profit in the quote coin net of taker fees
+type Opportunity struct {
+	Time      time.Time
+	BuyVenue  string
+	BuyPrice  float64
+	SellVenue string
+	SellPrice float64
+	Size      float64
+	Edge      float64
+	EdgeBps   float64 // edge in basis points of the notional bought
+}
+
+// Detector finds cross venue arbitrages on the books of the same instrument
+type Detector struct {
+	fees       map[string]bean.FeeRate
+	minSize    float64
+	minEdgeBps float64
+	maxAge     time.Duration
+}
+
+// NewDetector returns a detector reporting opportunities of at least minSize and minEdgeBps
+func NewDetector(minSize, minEdgeBps float64) *Detector {
+	return &Detector{
+		fees:       make(map[string]bean.FeeRate),
+		minSize:    minSize,
+		minEdgeBps: minEdgeBps,
+	}
+}
+
+// SetFee sets the fees of a venue. Both legs are assumed to be executed as a taker
+func (d *Detector) SetFee(venue string, fee bean.FeeRate) {
+	d.fees[venue] = fee
+}
+
+// SetMaxAge ignores books older than maxAge relative to the latest book, zero to use all books
+func (d *Detector) SetMaxAge(maxAge time.Duration) {
+	d.maxAge = maxAge
+}
+
+// Detect returns the opportunities between every pair of venues, best edge first
+func (d *Detector) Detect(books map[string]bean.OrderBookT) []Opportunity {
+	var latest time.Time
+	for _, ob := range books {
+		if ob.Time.After(latest) {
+			latest = ob.Time
+		}
+	}
+	var venues []string
+	for v, ob := range books {
+		if ob.OrderBookCore == nil || (d.maxAge > 0 && latest.Sub(ob.Time) > d.maxAge) {
+			continue
+		}
+		venues = append(venues, v)
+	}
+	sort.Strings(venues)
+
+	var res []Opportunity
+	for _, buy := range venues {
+		for _, sell := range venues {
+			if buy == sell {
+				continue
+			}
+			opp, ok := d.detect(buy, books[buy], sell, books[sell])
+			if ok {
+				opp.Time = latest
+				res = append(res, opp)
+			}
+		}
+	}
+	sort.SliceStable(res, func(i, j int) bool { return res[i].Edge > res[j].Edge })
+	return res
+}
+
+// detect walks the asks of the buy venue against the bids of the sell venue while the trade is profitable after fees
+func (d *Detector) detect(buyVenue string, buyBook bean.OrderBookT, sellVenue string, sellBook bean.OrderBookT) (opp Opportunity, ok bool) {
+	buyFee := d.fees[buyVenue].TakerBps / 1e4
+	sellFee := d.fees[sellVenue].TakerBps / 1e4
+	asks := buyBook.Asks()
+	bids := sellBook.Bids()
+	ai, bi := 0, 0
+	askLeft, bidLeft := 0.0, 0.0
+	if len(asks) > 0 {
+		askLeft = asks[0].Amount
+	}
+	if len(bids) > 0 {
+		bidLeft = bids[0].Amount
+	}
+	cost := 0.0
+	for ai < len(asks) && bi < len(bids) {
+		ask, bid := asks[ai].Price, bids[bi].Price
+		unitEdge := bid*(1-sellFee) - ask*(1+buyFee)
+		if unitEdge <= 0 {
+			break
+		}
+		size := math.Min(askLeft, bidLeft)
+		opp.Size += size
+		opp.Edge += size * unitEdge
+		opp.BuyPrice = ask
+		opp.SellPrice = bid
+		cost += size * ask
+		askLeft -= size
+		bidLeft -= size
+		if askLeft <= 0 {
+			ai++
+			if ai < len(asks) {
+				askLeft = asks[ai].Amount
+			}
+		}
+		if bidLeft <= 0 {
+			bi++
+			if bi < len(bids) {
+				bidLeft = bids[bi].Amount
+			}
+		}
+	}
+	if opp.Size == 0 || opp.Size < d.minSize {
+		return opp, false
+	}
+	opp.BuyVenue = buyVenue
+	opp.SellVenue = sellVenue
+	opp.EdgeBps = opp.Edge / cost * 1e4
+	return opp, opp.EdgeBps >= d.minEdgeBps
+}
@@ -0,0 +1,179 @@
+package arb
+
+import (
+	"bean"
+	"math"
+	"sort"
+	"time"
+)
+
+// Kinds of option arbitrage packages
+const (
+	Conversion = "CONVERSION" // buy call, sell put, sell future
+	Reversal   = "REVERSAL"   // sell call, buy put, buy future
+	LongBox    = "LONG BOX"   // buy the low strike call spread and the high strike put spread
+	ShortBox   = "SHORT BOX"  // sell the low strike call spread and the high strike put spread
+)
+
+// Leg is one order of a trade package. Amount is positive to buy, option prices are in LHS coin
+type Leg struct {
+	Contract *bean.Contract
+	Price    float64
+	Amount   float64
+}
+
+// Package is a set of orders locking in an arbitrage. Edge is the profit per unit in LHS coin after taker fees,
+// TotalEdge the profit for the size available on all legs
+type Package struct {
+	Kind      string
+	Expiry    time.Time
+	Legs      []Leg
+	Size      float64
+	Edge      float64
+	TotalEdge float64
+}
+
+// ParityArbs scans the quotes of a chain for put-call parity and box spread violations after taker fees.
+// With inverse options quoted in LHS coin, parity is C - P = 1 - K/F and a box between K1 and K2 is worth
+// (K2 - K1)/F, where F is the forward of the chain for the expiry. The future leg of conversions is traded at the
+// forward. Packages are returned best edge first
+func ParityArbs(ch *bean.OptionChain, optionFee, futureFee bean.FeeRate) []Package {
+	var res []Package
+	for _, expiry := range ch.Expiries() {
+		forward := ch.Forward(expiry)
+		strikes := ch.Strikes(expiry)
+		for _, k := range strikes {
+			call, put, ok := ch.CallPut(expiry, k)
+			if !ok {
+				continue
+			}
+			res = append(res, parity(ch, expiry, forward, call, put, optionFee, futureFee)...)
+		}
+		for i, k1 := range strikes {
+			for _, k2 := range strikes[i+1:] {
+				c1, p1, ok1 := ch.CallPut(expiry, k1)
+				c2, p2, ok2 := ch.CallPut(expiry, k2)
+				if ok1 && ok2 {
+					res = append(res, box(expiry, forward, c1, p1, c2, p2, optionFee)...)
+				}
+			}
+		}
+	}
+	sort.SliceStable(res, func(i, j int) bool { return res[i].TotalEdge > res[j].TotalEdge })
+	return res
+}
+
+func quoted(prices ...float64) bool {
+	for _, p := range prices {
+		if math.IsNaN(p) || p <= 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func minSize(sizes ...float64) float64 {
+	m := math.Inf(1)
+	for _, s := range sizes {
+		m = math.Min(m, s)
+	}
+	return m
+}
+
+func optionFees(fee bean.FeeRate, prices ...float64) (total float64) {
+	for _, p := range prices {
+		total += fee.OptionFee(1, p, false)
+	}
+	return
+}
+
+func parity(ch *bean.OptionChain, expiry time.Time, forward float64, call, put bean.ContractTicker,
+	optionFee, futureFee bean.FeeRate) (res []Package) {
+	fair := 1 - call.Contract.Strike()/forward
+	fut := bean.FutContract(ch.Underlying, expiry)
+	futFee := futureFee.Fee(1, false)
+
+	if quoted(call.BestAsk, put.BestBid) {
+		edge := fair - (call.BestAsk - put.BestBid) - optionFees(optionFee, call.BestAsk, put.BestBid) - futFee
+		if edge > 0 {
+			size := minSize(call.BestAskAmount, put.BestBidAmount)
+			res = append(res, Package{
+				Kind:   Conversion,
+				Expiry: expiry,
+				Legs: []Leg{
+					{call.Contract, call.BestAsk, size},
+					{put.Contract, put.BestBid, -size},
+					{fut, forward, -size},
+				},
+				Size:      size,
+				Edge:      edge,
+				TotalEdge: edge * size,
+			})
+		}
+	}
+	if quoted(call.BestBid, put.BestAsk) {
+		edge := (call.BestBid - put.BestAsk) - fair - optionFees(optionFee, call.BestBid, put.BestAsk) - futFee
+		if edge > 0 {
+			size := minSize(call.BestBidAmount, put.BestAskAmount)
+			res = append(res, Package{
+				Kind:   Reversal,
+				Expiry: expiry,
+				Legs: []Leg{
+					{call.Contract, call.BestBid, -size},
+					{put.Contract, put.BestAsk, size},
+					{fut, forward, size},
+				},
+				Size:      size,
+				Edge:      edge,
+				TotalEdge: edge * size,
+			})
+		}
+	}
+	return
+}
+
+func box(expiry time.Time, forward float64, c1, p1, c2, p2 bean.ContractTicker, optionFee bean.FeeRate) (res []Package) {
+	fair := (c2.Contract.Strike() - c1.Contract.Strike()) / forward
+
+	if quoted(c1.BestAsk, c2.BestBid, p2.BestAsk, p1.BestBid) {
+		cost := c1.BestAsk - c2.BestBid + p2.BestAsk - p1.BestBid
+		edge := fair - cost - optionFees(optionFee, c1.BestAsk, c2.BestBid, p2.BestAsk, p1.BestBid)
+		if edge > 0 {
+			size := minSize(c1.BestAskAmount, c2.BestBidAmount, p2.BestAskAmount, p1.BestBidAmount)
+			res = append(res, Package{
+				Kind:   LongBox,
+				Expiry: expiry,
+				Legs: []Leg{
+					{c1.Contract, c1.BestAsk, size},
+					{c2.Contract, c2.BestBid, -size},
+					{p2.Contract, p2.BestAsk, size},
+					{p1.Contract, p1.BestBid, -size},
+				},
+				Size:      size,
+				Edge:      edge,
+				TotalEdge: edge * size,
+			})
+		}
+	}
+	if quoted(c1.BestBid, c2.BestAsk, p2.BestBid, p1.BestAsk) {
+		proceeds := c1.BestBid - c2.BestAsk + p2.BestBid - p1.BestAsk
+		edge := proceeds - fair - optionFees(optionFee, c1.BestBid, c2.BestAsk, p2.BestBid, p1.BestAsk)
+		if edge > 0 {
+			size := minSize(c1.BestBidAmount, c2.BestAskAmount, p2.BestBidAmount, p1.BestAskAmount)
+			res = append(res, Package{
+				Kind:   ShortBox,
+				Expiry: expiry,
+				Legs: []Leg{
+					{c1.Contract, c1.BestBid, -size},
+					{c2.Contract, c2.BestAsk, size},
+					{p2.Contract, p2.BestBid, -size},
+					{p1.Contract, p1.BestAsk, size},
+				},
+				Size:      size,
+				Edge:      edge,
+				TotalEdge: edge * size,
+			})
+		}
+	}
+	return
+}
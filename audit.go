@@ -0,0 +1,99 @@
+package bean
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// AuditKind is what an audit record is about
+type AuditKind string
+
+const (
+	AuditOrder     AuditKind = "ORDER"     // state of an order
+	AuditExecution AuditKind = "EXECUTION" // fill of an order
+)
+
+// AuditRecord is an order state transition or a fill processed by the order management layer. Seq numbers the
+// records of a log from 1
+type AuditRecord struct {
+	Seq        int64            `json:"seq"`
+	Time       time.Time        `json:"time"`
+	Kind       AuditKind        `json:"kind"`
+	Instrument string           `json:"instrument"`
+	OrderID    string           `json:"order_id"`
+	State      OrderState       `json:"state,omitempty"`
+	Order      *OrderStatus     `json:"order,omitempty"`
+	Execution  *ExecutionReport `json:"execution,omitempty"`
+}
+
+// OrderRecord returns the record of the state of an order at t
+func OrderRecord(t time.Time, s OrderStatus) AuditRecord {
+	return AuditRecord{Time: t, Kind: AuditOrder, Instrument: s.Instrument, OrderID: s.OrderID, State: s.State, Order: &s}
+}
+
+// ExecutionRecord returns the record of a fill
+func ExecutionRecord(r ExecutionReport) AuditRecord {
+	return AuditRecord{Time: r.Time, Kind: AuditExecution, Instrument: r.Instrument, OrderID: r.OrderID, Execution: &r}
+}
+
+// AuditQuery selects audit records. Empty fields match all records, times are from Start included to End excluded
+type AuditQuery struct {
+	Instrument string
+	OrderID    string
+	Start, End time.Time
+}
+
+// Match is true for the records selected
+func (q AuditQuery) Match(r AuditRecord) bool {
+	return (q.Instrument == "" || r.Instrument == q.Instrument) &&
+		(q.OrderID == "" || r.OrderID == q.OrderID) &&
+		(q.Start.IsZero() || !r.Time.Before(q.Start)) &&
+		(q.End.IsZero() || r.Time.Before(q.End))
+}
+
+// AuditLog is an append-only log of order records, such as db/store.JSONLAudit
+type AuditLog interface {
+	Append(r AuditRecord) error
+	// Query returns the records matching q in the order they were appended
+	Query(q AuditQuery) ([]AuditRecord, error)
+	Close() error
+}
+
+// Auditor records the order updates and fills of a component in an AuditLog. The first failure to append is kept
+// for the component to stop on, as orders must not go unrecorded. The zero value records nothing. It is safe for
+// concurrent use
+type Auditor struct {
+	m   sync.Mutex
+	log AuditLog
+	err error
+}
+
+// SetLog sets the log records are appended to, nil for none
+func (a *Auditor) SetLog(l AuditLog) {
+	a.m.Lock()
+	defer a.m.Unlock()
+	a.log = l
+}
+
+// Record appends a record to the log, if any
+func (a *Auditor) Record(r AuditRecord) {
+	a.m.Lock()
+	defer a.m.Unlock()
+	if a.log == nil {
+		return
+	}
+	if err := a.log.Append(r); err != nil {
+		Log().Errorf("audit: %s %s %s: %v", r.Kind, r.Instrument, r.OrderID, err)
+		if a.err == nil {
+			a.err = fmt.Errorf("audit: %w", err)
+		}
+	}
+}
+
+// Err returns the first failure to append a record, nil if none
+func (a *Auditor) Err() error {
+	a.m.Lock()
+	defer a.m.Unlock()
+	return a.err
+}
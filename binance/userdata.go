@@ -0,0 +1,554 @@
+// Package binance follows a USD-M futures account through the user data stream of its API key
+package binance
+
+import (
+	"bean"
+	"bean/event"
+	"bean/internal/ws"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultURL   = "https://fapi.binance.com"     // REST API of USD-M futures
+	DefaultWSURL = "wss://fstream.binance.com/ws" // user data streams, followed by the listen key
+)
+
+// num is a number the API writes as a string
+type num float64
+
+func (n *num) UnmarshalJSON(b []byte) error {
+	s := strings.Trim(string(b), `"`)
+	if s == "" {
+		*n = 0
+		return nil
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	*n = num(f)
+	return err
+}
+
+func msTime(ms int64) time.Time {
+	return time.Unix(0, ms*int64(time.Millisecond)).UTC()
+}
+
+// orderUpdate is the order of an ORDER_TRADE_UPDATE
+type orderUpdate struct {
+	Symbol       string `json:"s"`
+	ClientID     string `json:"c"`
+	Side         string `json:"S"`
+	Quantity     num    `json:"q"`
+	Price        num    `json:"p"`
+	AveragePrice num    `json:"ap"`
+	ExecType     string `json:"x"`
+	Status       string `json:"X"`
+	OrderID      int64  `json:"i"`
+	LastQty      num    `json:"l"`
+	FilledQty    num    `json:"z"`
+	LastPrice    num    `json:"L"`
+	FeeAsset     string `json:"N"`
+	Fee          num    `json:"n"`
+	TradeTime    int64  `json:"T"`
+	TradeID      int64  `json:"t"`
+	Maker        bool   `json:"m"`
+	RealizedPnL  num    `json:"rp"`
+}
+
+type balanceUpdate struct {
+	Asset         string `json:"a"`
+	WalletBalance num    `json:"wb"`
+}
+
+type positionUpdate struct {
+	Symbol        string `json:"s"`
+	Amount        num    `json:"pa"`
+	EntryPrice    num    `json:"ep"`
+	RealizedPnL   num    `json:"cr"`
+	UnrealizedPnL num    `json:"up"`
+	Side          string `json:"ps"` // BOTH in one-way mode, LONG or SHORT in hedge mode
+}
+
+type userEvent struct {
+	Type  string      `json:"e"`
+	Time  int64       `json:"E"`
+	Order orderUpdate `json:"o"`
+	Data  struct {
+		Reason    string           `json:"m"`
+		Balances  []balanceUpdate  `json:"B"`
+		Positions []positionUpdate `json:"P"`
+	} `json:"a"`
+}
+
+// LinearPosition is a position in a USD-M contract as reported by the exchange, in coins and quote currency
+type LinearPosition struct {
+	Symbol        string
+	Pair          bean.Pair
+	Side          string // BOTH in one-way mode, LONG or SHORT in hedge mode
+	Amount        float64
+	EntryPrice    float64
+	RealizedPnL   float64 // accumulated
+	UnrealizedPnL float64
+	Time          time.Time
+}
+
+// PairOfSymbol returns the pair of a USD-M symbol such as BTCUSDT
+func PairOfSymbol(symbol string) (bean.Pair, bool) {
+	symbol = strings.ToUpper(strings.SplitN(symbol, "_", 2)[0])
+	for _, quote := range []bean.Coin{bean.USDT, bean.USDC, bean.BUSD} {
+		if coin := strings.TrimSuffix(symbol, string(quote)); coin != symbol && coin != "" {
+			return bean.Pair{Coin: bean.Coin(coin), Base: quote}, true
+		}
+	}
+	return bean.Pair{}, false
+}
+
+// UserStream follows an account through its user data stream: fills are recorded in a Blotter, whose cash
+// accounting is that of linear contracts, wallet balances are set in an Account and positions are kept as
+// reported, starting from a snapshot of the REST API loaded on connection. Order updates are streamed as
+// OrderEvents by Events, which keeps the listen key alive and reconnects with a new one when the stream drops. The
+// snapshots are signed with the secret. With SetAudit, order updates and fills are recorded in an audit log as they
+// are received. It is safe for concurrent use
+type UserStream struct {
+	BaseURL    string
+	WSURL      string
+	HTTP       *http.Client
+	APIKey     string
+	APISecret  string
+	RecvWindow time.Duration     // validity of signed requests, 5s if zero
+	Limiter    *bean.RateLimiter // request weight, waited for before each request, nil for none
+
+	Blotter        *bean.Blotter // fills, may be nil
+	Account        *bean.Account // balances, may be nil
+	KeepAlive      time.Duration // interval of listen key renewals, which expire after an hour
+	ReconnectDelay time.Duration // first wait before reconnecting, doubled up to a minute
+
+	m         sync.Mutex
+	positions map[string]LinearPosition // by symbol and side
+	audit     bean.Auditor
+}
+
+// NewUserStream returns a stream of the production API recording fills in a blotter and balances in an account
+func NewUserStream(apiKey, apiSecret string, blotter *bean.Blotter, account *bean.Account) *UserStream {
+	limiter, _ := bean.NewExchangeRateLimiter(bean.NameBinance)
+	return &UserStream{
+		BaseURL:        DefaultURL,
+		WSURL:          DefaultWSURL,
+		HTTP:           &http.Client{Timeout: 10 * time.Second},
+		APIKey:         apiKey,
+		APISecret:      apiSecret,
+		Limiter:        limiter,
+		Blotter:        blotter,
+		Account:        account,
+		KeepAlive:      30 * time.Minute,
+		ReconnectDelay: time.Second,
+		positions:      make(map[string]LinearPosition),
+	}
+}
+
+// Request weights of the endpoints used
+const (
+	listenKeyWeight    = 1
+	accountWeight      = 5
+	positionRiskWeight = 5
+)
+
+// do sends a request of a weight once the Limiter allows it, syncing the Limiter with the weight the exchange
+// reports used
+func (s *UserStream) do(req *http.Request, weight float64) (*http.Response, error) {
+	if s.Limiter != nil {
+		if err := s.Limiter.Wait(req.Context(), weight); err != nil {
+			return nil, fmt.Errorf("binance %s: %w", req.URL.Path, err)
+		}
+	}
+	resp, err := s.HTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if used, err := strconv.ParseFloat(resp.Header.Get("X-MBX-USED-WEIGHT-1M"), 64); err == nil && s.Limiter != nil {
+		s.Limiter.Sync(used)
+	}
+	return resp, nil
+}
+
+// SetAudit sets an audit log recording the order updates and fills
+func (s *UserStream) SetAudit(a bean.AuditLog) {
+	s.audit.SetLog(a)
+}
+
+// AuditErr returns the first failure to record in the audit log
+func (s *UserStream) AuditErr() error {
+	return s.audit.Err()
+}
+
+// listenKey creates (POST) or renews (PUT) the listen key of the stream
+func (s *UserStream) listenKey(ctx context.Context, method string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, method, s.BaseURL+"/fapi/v1/listenKey", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-MBX-APIKEY", s.APIKey)
+	resp, err := s.do(req, listenKeyWeight)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	var res struct {
+		ListenKey string `json:"listenKey"`
+		Code      int    `json:"code"`
+		Msg       string `json:"msg"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return "", fmt.Errorf("binance listen key: %s: %w", resp.Status, err)
+	}
+	if res.Code < 0 || resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("binance listen key: %s %d %s", resp.Status, res.Code, res.Msg)
+	}
+	return res.ListenKey, nil
+}
+
+// Positions returns the non zero positions reported, sorted by symbol
+func (s *UserStream) Positions() []LinearPosition {
+	s.m.Lock()
+	defer s.m.Unlock()
+	res := make([]LinearPosition, 0, len(s.positions))
+	for _, p := range s.positions {
+		res = append(res, p)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Symbol != res[j].Symbol {
+			return res[i].Symbol < res[j].Symbol
+		}
+		return res[i].Side < res[j].Side
+	})
+	return res
+}
+
+// signedGet sends a GET request of a weight signed with the secret and decodes its JSON result into res
+func (s *UserStream) signedGet(ctx context.Context, path string, weight float64, res interface{}) error {
+	window := s.RecvWindow
+	if window == 0 {
+		window = 5 * time.Second
+	}
+	q := url.Values{}
+	q.Set("timestamp", strconv.FormatInt(time.Now().UnixNano()/int64(time.Millisecond), 10))
+	q.Set("recvWindow", strconv.FormatInt(int64(window/time.Millisecond), 10))
+	query := q.Encode()
+	mac := hmac.New(sha256.New, []byte(s.APISecret))
+	mac.Write([]byte(query))
+	query += "&signature=" + hex.EncodeToString(mac.Sum(nil))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.BaseURL+path+"?"+query, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-MBX-APIKEY", s.APIKey)
+	resp, err := s.do(req, weight)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Code int    `json:"code"`
+			Msg  string `json:"msg"`
+		}
+		json.NewDecoder(resp.Body).Decode(&e)
+		return fmt.Errorf("binance %s: %s %d %s", path, resp.Status, e.Code, e.Msg)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(res); err != nil {
+		return fmt.Errorf("binance %s: %w", path, err)
+	}
+	return nil
+}
+
+// snapshot fetches the wallet balances and the non zero positions of the account
+func (s *UserStream) snapshot(ctx context.Context) (map[bean.Coin]float64, []LinearPosition, error) {
+	var account struct {
+		Assets []struct {
+			Asset         string `json:"asset"`
+			WalletBalance num    `json:"walletBalance"`
+		} `json:"assets"`
+	}
+	if err := s.signedGet(ctx, "/fapi/v2/account", accountWeight, &account); err != nil {
+		return nil, nil, err
+	}
+	var risks []struct {
+		Symbol        string `json:"symbol"`
+		Amount        num    `json:"positionAmt"`
+		EntryPrice    num    `json:"entryPrice"`
+		UnrealizedPnL num    `json:"unRealizedProfit"`
+		Side          string `json:"positionSide"`
+		UpdateTime    int64  `json:"updateTime"`
+	}
+	if err := s.signedGet(ctx, "/fapi/v2/positionRisk", positionRiskWeight, &risks); err != nil {
+		return nil, nil, err
+	}
+	balances := make(map[bean.Coin]float64, len(account.Assets))
+	for _, a := range account.Assets {
+		balances[bean.Coin(a.Asset)] = float64(a.WalletBalance)
+	}
+	var positions []LinearPosition
+	for _, r := range risks {
+		if r.Amount == 0 {
+			continue
+		}
+		pair, _ := PairOfSymbol(r.Symbol)
+		positions = append(positions, LinearPosition{Symbol: r.Symbol, Pair: pair, Side: r.Side,
+			Amount: float64(r.Amount), EntryPrice: float64(r.EntryPrice), UnrealizedPnL: float64(r.UnrealizedPnL),
+			Time: msTime(r.UpdateTime)})
+	}
+	return balances, positions, nil
+}
+
+// State fetches the wallet balances and the positions of the account from the REST API, positions as pair
+// positions at their entry price, to reconcile an account and a blotter
+func (s *UserStream) State(ctx context.Context) (bean.ExchangeState, error) {
+	balances, positions, err := s.snapshot(ctx)
+	if err != nil {
+		return bean.ExchangeState{}, err
+	}
+	res := bean.ExchangeState{Time: time.Now(), Balances: balances}
+	for _, p := range positions {
+		res.PairPositions = append(res.PairPositions, bean.PairPosition{Pair: p.Pair, Qty: p.Amount, Price: p.EntryPrice})
+	}
+	return res, nil
+}
+
+// Load sets the wallet balances of the Account and the positions to a snapshot fetched from the REST API, done on
+// each connection of the stream so that updates missed or made before are not lost
+func (s *UserStream) Load(ctx context.Context) error {
+	balances, positions, err := s.snapshot(ctx)
+	if err != nil {
+		return err
+	}
+	if s.Account != nil {
+		for c, b := range balances {
+			s.Account.SetBalance(c, b)
+		}
+	}
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.positions = make(map[string]LinearPosition, len(positions))
+	for _, p := range positions {
+		s.positions[p.Symbol+" "+p.Side] = p
+	}
+	return nil
+}
+
+var _ event.Checkpointer = (*UserStream)(nil)
+
+// CheckpointState returns the positions followed
+func (s *UserStream) CheckpointState() (json.RawMessage, error) {
+	return json.Marshal(s.Positions())
+}
+
+// RestoreCheckpoint replaces the positions with a state of CheckpointState, until the snapshot of the next
+// connection
+func (s *UserStream) RestoreCheckpoint(state json.RawMessage) error {
+	var positions []LinearPosition
+	if err := json.Unmarshal(state, &positions); err != nil {
+		return err
+	}
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.positions = make(map[string]LinearPosition, len(positions))
+	for _, p := range positions {
+		s.positions[p.Symbol+" "+p.Side] = p
+	}
+	return nil
+}
+
+// Events streams the updates of our orders as OrderEvents, each fill followed by its ExecutionEvent, until the
+// context is done
+func (s *UserStream) Events(ctx context.Context) <-chan event.Event {
+	out := make(chan event.Event)
+	go func() {
+		defer close(out)
+		delay := s.ReconnectDelay
+		for resync := false; ctx.Err() == nil; resync = true {
+			connected, err := s.stream(ctx, out, resync)
+			if ctx.Err() != nil {
+				return
+			}
+			bean.Log().Warnf("binance: user data stream disconnected: %v", err)
+			if connected {
+				delay = s.ReconnectDelay
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(delay):
+			}
+			if delay *= 2; delay > time.Minute {
+				delay = time.Minute
+			}
+		}
+	}()
+	return out
+}
+
+// stream runs one listen key and connection, returning whether it connected. resync is true on reconnects,
+// counted once the snapshot is reloaded
+func (s *UserStream) stream(ctx context.Context, out chan<- event.Event, resync bool) (bool, error) {
+	key, err := s.listenKey(ctx, http.MethodPost)
+	if err != nil {
+		return false, err
+	}
+	conn, err := ws.Dial(ctx, s.WSURL+"/"+key)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	// the snapshot is taken once connected for the updates after it to be applied on top
+	if err := s.Load(ctx); err != nil {
+		return false, err
+	}
+	if resync {
+		bean.DefaultMetrics().Counter(bean.MetricBookResyncs).Inc()
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		ticker := time.NewTicker(s.KeepAlive)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				conn.Abort()
+				return
+			case <-stop:
+				return
+			case <-ticker.C:
+				if _, err := s.listenKey(ctx, http.MethodPut); err != nil {
+					bean.Log().Warnf("binance: listen key renewal failed: %v", err)
+				}
+			}
+		}
+	}()
+
+	for {
+		b, err := conn.ReadMessage()
+		if err != nil {
+			return true, err
+		}
+		var e userEvent
+		if err := json.Unmarshal(b, &e); err != nil {
+			bean.Log().Warnf("binance: bad user data message: %v", err)
+			continue
+		}
+		switch e.Type {
+		case "listenKeyExpired":
+			return true, fmt.Errorf("binance: listen key expired")
+		case "ACCOUNT_UPDATE":
+			s.onAccount(e)
+		case "ORDER_TRADE_UPDATE":
+			status, exec := s.onOrder(e)
+			s.audit.Record(bean.OrderRecord(msTime(e.Time), status))
+			if exec != nil {
+				s.audit.Record(bean.ExecutionRecord(*exec))
+			}
+			events := []event.Event{{Kind: event.OrderEvent, Time: msTime(e.Time), Instrument: status.Instrument, Order: status}}
+			if exec != nil {
+				events = append(events, event.Event{Kind: event.ExecutionEvent, Time: exec.Time, Instrument: exec.Instrument,
+					Order: status, Execution: *exec})
+			}
+			for _, ev := range events {
+				select {
+				case out <- ev:
+				case <-ctx.Done():
+					return true, ctx.Err()
+				}
+			}
+		}
+	}
+}
+
+// onOrder records the fill of an order update and returns its status, and its execution if it is a fill
+func (s *UserStream) onOrder(e userEvent) (status bean.OrderStatus, exec *bean.ExecutionReport) {
+	o := e.Order
+	status = bean.OrderStatus{
+		OrderID:      strconv.FormatInt(o.OrderID, 10),
+		PlacedTime:   msTime(e.Time),
+		Side:         bean.Side(o.Side),
+		Instrument:   o.Symbol,
+		FilledAmount: float64(o.FilledQty),
+		LeftAmount:   float64(o.Quantity - o.FilledQty),
+		PlacedPrice:  float64(o.Price),
+		Price:        float64(o.Price),
+		Msg:          o.ClientID,
+	}
+	if o.FilledQty > 0 {
+		status.Price = float64(o.AveragePrice)
+	}
+	switch o.Status {
+	case "NEW":
+		status.State = bean.ALIVE
+	case "PARTIALLY_FILLED":
+		status.State = bean.PARTIAL
+	case "FILLED":
+		status.State = bean.FILLED
+	case "REJECTED":
+		status.State = bean.REJECTED
+	default: // CANCELED, EXPIRED
+		status.State = bean.CANCELLED
+	}
+	if o.ExecType == "TRADE" && o.LastQty > 0 {
+		status.Commission, status.CommissionAsset = float64(o.Fee), bean.Coin(o.FeeAsset)
+		pair, _ := PairOfSymbol(o.Symbol)
+		liquidity := bean.LiquidityTaker
+		if o.Maker {
+			liquidity = bean.LiquidityMaker
+		}
+		exec = &bean.ExecutionReport{
+			Exchange:   bean.NameBinance,
+			OrderID:    status.OrderID,
+			ClientID:   o.ClientID,
+			ExecID:     strconv.FormatInt(o.TradeID, 10),
+			Instrument: o.Symbol,
+			Pair:       pair,
+			Side:       status.Side,
+			Price:      float64(o.LastPrice),
+			Qty:        float64(o.LastQty),
+			Fee:        float64(o.Fee),
+			FeeAsset:   bean.Coin(o.FeeAsset),
+			Liquidity:  liquidity,
+			Time:       msTime(o.TradeTime),
+		}
+		if pair != (bean.Pair{}) && s.Blotter != nil {
+			s.Blotter.AddExecution(*exec)
+		}
+	}
+	return status, exec
+}
+
+// onAccount sets the balances and positions of an account update
+func (s *UserStream) onAccount(e userEvent) {
+	if s.Account != nil {
+		for _, b := range e.Data.Balances {
+			s.Account.SetBalance(bean.Coin(b.Asset), float64(b.WalletBalance))
+		}
+	}
+	s.m.Lock()
+	defer s.m.Unlock()
+	for _, p := range e.Data.Positions {
+		key := p.Symbol + " " + p.Side
+		if p.Amount == 0 {
+			delete(s.positions, key)
+			continue
+		}
+		pair, _ := PairOfSymbol(p.Symbol)
+		s.positions[key] = LinearPosition{Symbol: p.Symbol, Pair: pair, Side: p.Side, Amount: float64(p.Amount),
+			EntryPrice: float64(p.EntryPrice), RealizedPnL: float64(p.RealizedPnL),
+			UnrealizedPnL: float64(p.UnrealizedPnL), Time: msTime(e.Time)}
+	}
+}
@@ -0,0 +1,182 @@
+package bean
+
+import (
+	"math"
+	"sort"
+	"sync"
+)
+
+// Blotter records our fills and keeps track of the resulting position, cash and fees per instrument and per pair.
+// Quantities are in Coin. Pair totals are in Base and only cover the fills priced in Base: option fills, priced in
+// Coin, are kept by instrument only. It is safe for concurrent use.
+type Blotter struct {
+	m           sync.Mutex
+	trades      TradeLogS
+	positions   map[Pair]float64 // position in Coin
+	cash        map[Pair]float64 // cash in Base generated by the trades
+	fees        map[Pair]float64 // fees paid in Base
+	turnover    map[Pair]float64 // traded value in Base
+	instruments map[string]*instrumentBook
+}
+
+// instrumentBook is the position of an instrument, in Coin, and its cash and fees in the currency of its prices
+type instrumentBook struct {
+	position, cash, fees float64
+}
+
+func NewBlotter() *Blotter {
+	return &Blotter{
+		trades:      make(TradeLogS, 0),
+		positions:   make(map[Pair]float64),
+		cash:        make(map[Pair]float64),
+		fees:        make(map[Pair]float64),
+		turnover:    make(map[Pair]float64),
+		instruments: make(map[string]*instrumentBook),
+	}
+}
+
+// instrumentOf returns the instrument of a fill, its pair if it has no symbol, and whether it is priced in Coin
+func instrumentOf(t TradeLog) (string, bool) {
+	if t.Symbol == "" {
+		return t.Pair.String(), false
+	}
+	c, err := ContractFromName(t.Symbol)
+	return t.Symbol, err == nil && c.IsOption()
+}
+
+// Add records a fill. Quantity is positive, the direction is given by Side
+func (b *Blotter) Add(t TradeLog) {
+	b.m.Lock()
+	defer b.m.Unlock()
+	b.trades = append(b.trades, t)
+	qty := math.Abs(t.Quantity)
+	if t.Side == SELL {
+		qty = -qty
+	}
+	name, inCoin := instrumentOf(t)
+	fee := 0.0
+	switch {
+	case t.CommissionAsset == t.Pair.Base && !inCoin:
+		fee = t.Commission
+	case t.CommissionAsset == t.Pair.Coin && inCoin:
+		fee = t.Commission
+	case t.CommissionAsset == t.Pair.Coin:
+		fee = t.Commission * t.Price
+	}
+	ib, ok := b.instruments[name]
+	if !ok {
+		ib = &instrumentBook{}
+		b.instruments[name] = ib
+	}
+	ib.position += qty
+	ib.cash -= qty * t.Price
+	ib.fees += fee
+	if inCoin {
+		return
+	}
+	b.positions[t.Pair] += qty
+	b.cash[t.Pair] -= qty * t.Price
+	b.turnover[t.Pair] += math.Abs(qty * t.Price)
+	b.fees[t.Pair] += fee
+}
+
+// AddExecution records a fill reported by an exchange client or a simulator
+func (b *Blotter) AddExecution(r ExecutionReport) {
+	b.Add(r.TradeLog())
+}
+
+// Trades returns a copy of the fills recorded
+func (b *Blotter) Trades() TradeLogS {
+	b.m.Lock()
+	defer b.m.Unlock()
+	return append(TradeLogS{}, b.trades...)
+}
+
+func (b *Blotter) Position(p Pair) float64 {
+	b.m.Lock()
+	defer b.m.Unlock()
+	return b.positions[p]
+}
+
+func (b *Blotter) Cash(p Pair) float64 {
+	b.m.Lock()
+	defer b.m.Unlock()
+	return b.cash[p]
+}
+
+// Fee returns the fees paid on a pair in Base. Commissions in other coins are not tracked
+func (b *Blotter) Fee(p Pair) float64 {
+	b.m.Lock()
+	defer b.m.Unlock()
+	return b.fees[p]
+}
+
+func (b *Blotter) Turnover(p Pair) float64 {
+	b.m.Lock()
+	defer b.m.Unlock()
+	return b.turnover[p]
+}
+
+// Pairs returns the pairs traded
+func (b *Blotter) Pairs() []Pair {
+	b.m.Lock()
+	defer b.m.Unlock()
+	pairs := make([]Pair, 0, len(b.positions))
+	for p := range b.positions {
+		pairs = append(pairs, p)
+	}
+	return pairs
+}
+
+// PnL returns the PnL in Base of the trades of a pair with the position marked at mark, net of fees
+func (b *Blotter) PnL(p Pair, mark float64) float64 {
+	b.m.Lock()
+	defer b.m.Unlock()
+	return b.cash[p] + b.positions[p]*mark - b.fees[p]
+}
+
+// Instruments returns the instruments traded, sorted. Fills without a symbol are under the name of their pair
+func (b *Blotter) Instruments() []string {
+	b.m.Lock()
+	defer b.m.Unlock()
+	res := make([]string, 0, len(b.instruments))
+	for name := range b.instruments {
+		res = append(res, name)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// InstrumentPosition returns the position of an instrument in Coin
+func (b *Blotter) InstrumentPosition(instrument string) float64 {
+	b.m.Lock()
+	defer b.m.Unlock()
+	if ib, ok := b.instruments[instrument]; ok {
+		return ib.position
+	}
+	return 0
+}
+
+// InstrumentPnL returns the PnL of the trades of an instrument with the position marked at mark, net of fees, in
+// the currency of its prices: Base, or Coin for options
+func (b *Blotter) InstrumentPnL(instrument string, mark float64) float64 {
+	b.m.Lock()
+	defer b.m.Unlock()
+	ib, ok := b.instruments[instrument]
+	if !ok {
+		return 0
+	}
+	return ib.cash + ib.position*mark - ib.fees
+}
+
+// Restore replaces the fills recorded with trades, recomputing the positions, cash, fees and turnover
+func (b *Blotter) Restore(trades TradeLogS) {
+	fresh := NewBlotter()
+	for _, t := range trades {
+		fresh.Add(t)
+	}
+	b.m.Lock()
+	defer b.m.Unlock()
+	b.trades, b.positions, b.cash, b.fees, b.turnover = fresh.trades, fresh.positions, fresh.cash, fresh.fees, fresh.turnover
+	b.instruments = fresh.instruments
+}
@@ -0,0 +1,58 @@
+package bean
+
+import "fmt"
+
+// DebugCrossTolerance is the fraction of the best ask the best bid may exceed it by in the checks of debug builds,
+// as feeds cross books briefly between the updates of each side
+var DebugCrossTolerance = 0.01
+
+// BookInvariantHook is called with the error of a failed check after each update of an OrderBook1 or
+// OrderBookCOW in debug builds, those built with the beandebug tag. It panics by default
+var BookInvariantHook = func(err error) {
+	panic(err)
+}
+
+// CheckInvariants returns an error wrapping ErrBookInvariant if the bids are not strictly descending, the asks
+// not strictly ascending, a price or amount is negative or NaN, or the best bid is above the best ask by more
+// than crossTolerance times the ask
+func (ob *OrderBook) CheckInvariants(crossTolerance float64) error {
+	if ob.OrderBookCore == nil {
+		return nil
+	}
+	return checkLevels(ob.Bids(), ob.Asks(), crossTolerance)
+}
+
+func checkLevels(bids, asks []Order, crossTolerance float64) error {
+	for _, side := range []struct {
+		name   string
+		levels []Order
+		desc   bool
+	}{{"bid", bids, true}, {"ask", asks, false}} {
+		for i, o := range side.levels {
+			if !(o.Price >= 0) || !(o.Amount >= 0) {
+				return fmt.Errorf("%w: %s level %d is %v", ErrBookInvariant, side.name, i, o)
+			}
+			if i == 0 {
+				continue
+			}
+			prev := side.levels[i-1].Price
+			if (side.desc && !(o.Price < prev)) || (!side.desc && !(o.Price > prev)) {
+				return fmt.Errorf("%w: %s level %d at %v after %v", ErrBookInvariant, side.name, i, o.Price, prev)
+			}
+		}
+	}
+	if len(bids) > 0 && len(asks) > 0 && bids[0].Price > asks[0].Price*(1+crossTolerance) {
+		return fmt.Errorf("%w: bid %v above ask %v", ErrBookInvariant, bids[0].Price, asks[0].Price)
+	}
+	return nil
+}
+
+// debugCheck checks the levels of a book just updated in debug builds
+func debugCheck(bids, asks []Order) {
+	if !debugBooks {
+		return
+	}
+	if err := checkLevels(bids, asks, DebugCrossTolerance); err != nil {
+		BookInvariantHook(err)
+	}
+}
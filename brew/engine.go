@@ -0,0 +1,243 @@
+package brew
+
+import (
+	. "bean"
+	"bean/stats"
+	"context"
+	"math"
+	"time"
+)
+
+// Strategy is an event driven strategy run by the Engine. Orders are placed and cancelled through the engine
+type Strategy interface {
+	OnBook(e *Engine, ob OrderBookT)    // called on each orderbook update
+	OnTrade(e *Engine, txn Transaction) // called on each market trade
+	OnTimer(e *Engine, t time.Time)     // called every timer interval
+}
+
+// Engine is an event driven backtest of a single pair. It replays orderbooks and market trades in time order,
+// calls the strategy, matches the strategy orders against the replayed market with a Matcher and records the fills
+// in a Blotter
+type Engine struct {
+	pair  Pair
+	books OrderBookTS
+	txns  Transactions
+	timer time.Duration
+	fees  FeeRate
+
+	matcher   *Matcher
+	clock     *SimClock
+	blotter   *Blotter
+	pnl       TimeSeries
+	positions TimeSeries
+}
+
+// NewEngine returns a backtest engine over the orderbooks and trades (which may be nil) of a pair.
+// The strategy timer is called every timer interval, a zero timer disables it
+func NewEngine(pair Pair, books OrderBookTS, txns Transactions, timer time.Duration) *Engine {
+	e := &Engine{
+		pair:    pair,
+		books:   books.Sort(),
+		txns:    txns.Sort(),
+		timer:   timer,
+		matcher: NewMatcher(),
+		clock:   NewSimClock(time.Time{}),
+		blotter: NewBlotter(),
+	}
+	e.matcher.OnFill(e.fill)
+	return e
+}
+
+// NewEngineFromCandles returns a backtest engine replaying a single level orderbook around the close of each candle
+func NewEngineFromCandles(pair Pair, candles OHLCVBSTS, halfSpread, size float64, timer time.Duration) *Engine {
+	books := make(OrderBookTS, len(candles))
+	for i, c := range candles {
+		ob := NewOrderBook(
+			[]Order{{Price: c.Close * (1 - halfSpread), Amount: size}},
+			[]Order{{Price: c.Close * (1 + halfSpread), Amount: size}})
+		books[i] = OrderBookT{OrderBook: ob, Time: c.End}
+	}
+	return NewEngine(pair, books, nil, timer)
+}
+
+// SetFees sets the maker and taker fees charged on fills, see FeeSchedule.Rate
+func (e *Engine) SetFees(r FeeRate) {
+	e.fees = r
+}
+
+// SetLatency sets the model of the delay of order placements and cancels reaching the book, nil for none.
+// Orders are matched from their arrival against the book of that time and can fill before their cancel arrives
+func (e *Engine) SetLatency(l LatencyModel) {
+	e.matcher.Latency = l
+}
+
+// SetSlippage sets the model of the price impact of taker fills on top of the book walked, nil for none.
+// Fills never go through the limit price of their order
+func (e *Engine) SetSlippage(s SlippageModel) {
+	e.matcher.Slippage = s
+}
+
+// SetQueueModel turns on the model of the queue position of resting orders, see Matcher
+func (e *Engine) SetQueueModel(on bool) {
+	e.matcher.Queue = on
+}
+
+// QueuePosition returns the amount resting ahead of a live order with the queue model, false if it is not live
+func (e *Engine) QueuePosition(oid string) (ahead float64, ok bool) {
+	return e.matcher.QueuePosition(oid)
+}
+
+func (e *Engine) Pair() Pair {
+	return e.pair
+}
+
+func (e *Engine) Now() time.Time {
+	return e.matcher.Now()
+}
+
+// Clock returns the clock of the backtest, which follows the replayed events. Pass it to the parts of a strategy
+// that need the time, such as rate limiters, to run them in backtest time
+func (e *Engine) Clock() Clock {
+	return e.clock
+}
+
+// advance applies the order requests arriving up to t and moves the time there
+func (e *Engine) advance(t time.Time) {
+	e.matcher.Advance(t)
+	e.clock.Set(e.matcher.Now())
+}
+
+// Book returns the latest replayed orderbook
+func (e *Engine) Book() OrderBookT {
+	return e.matcher.Book(e.pair.String())
+}
+
+func (e *Engine) Blotter() *Blotter {
+	return e.blotter
+}
+
+// PlaceOrder places a limit order, positive amount to buy. The part crossing the book when the order arrives is
+// filled as a taker, immediately without latency, the rest rests until the replayed books or trades cross it.
+// Invalid orders are ignored and get an empty id
+func (e *Engine) PlaceOrder(price, amount float64) string {
+	oid, _ := e.matcher.Place(e.pair.String(), price, amount)
+	return oid
+}
+
+// CancelOrder cancels a live order, returns false if it is not alive or already being cancelled. With latency
+// the order is cancelled once the request arrives
+func (e *Engine) CancelOrder(oid string) bool {
+	return e.matcher.Cancel(e.pair.String(), oid) == nil
+}
+
+// OpenOrders returns the status of the live orders
+func (e *Engine) OpenOrders() []OrderStatus {
+	return e.matcher.OpenOrders(e.pair.String())
+}
+
+// Run replays the market through the strategy and returns the performance summary
+func (e *Engine) Run(s Strategy) BacktestSummary {
+	summary, _ := e.RunContext(context.Background(), s)
+	return summary
+}
+
+// RunContext runs the backtest as Run does, stopping early with the context error and the summary so far if ctx
+// is cancelled or its deadline passes
+func (e *Engine) RunContext(ctx context.Context, s Strategy) (BacktestSummary, error) {
+	bi, ti := 0, 0
+	var nextTimer time.Time
+	instrument := e.pair.String()
+	for bi < len(e.books) || ti < len(e.txns) {
+		if err := ctx.Err(); err != nil {
+			return e.Summary(), err
+		}
+		// take the earliest event, books first on a tie
+		isBook := ti >= len(e.txns) || (bi < len(e.books) && !e.books[bi].Time.After(e.txns[ti].TimeStamp))
+		var t time.Time
+		if isBook {
+			t = e.books[bi].Time
+		} else {
+			t = e.txns[ti].TimeStamp
+		}
+
+		if e.timer > 0 {
+			if nextTimer.IsZero() {
+				nextTimer = t.Add(e.timer)
+			}
+			for !nextTimer.After(t) {
+				e.advance(nextTimer)
+				s.OnTimer(e, nextTimer)
+				nextTimer = nextTimer.Add(e.timer)
+			}
+		}
+
+		if isBook {
+			ob := e.books[bi]
+			bi++
+			e.matcher.OnBook(instrument, ob)
+			e.clock.Set(e.matcher.Now())
+			s.OnBook(e, ob)
+			e.mark()
+		} else {
+			txn := e.txns[ti]
+			ti++
+			e.matcher.OnTrade(instrument, txn)
+			e.clock.Set(e.matcher.Now())
+			s.OnTrade(e, txn)
+		}
+	}
+	return e.Summary(), nil
+}
+
+// fill records a fill of the matcher in the blotter, charging the fees in Base
+func (e *Engine) fill(f MatchFill) {
+	liquidity := LiquidityTaker
+	if f.Maker {
+		liquidity = LiquidityMaker
+	}
+	e.blotter.AddExecution(ExecutionReport{
+		Exchange:   NameSim,
+		OrderID:    f.Order.OrderID,
+		ExecID:     f.ExecID,
+		Instrument: f.Order.Instrument,
+		Pair:       e.pair,
+		Side:       AmountToSide(f.Amount),
+		Price:      f.Price,
+		Qty:        math.Abs(f.Amount),
+		Fee:        e.fees.Fee(f.Amount*f.Price, f.Maker),
+		FeeAsset:   e.pair.Base,
+		Liquidity:  liquidity,
+		Time:       f.Time,
+	})
+}
+
+// mark records the PnL at the mid of the current book
+func (e *Engine) mark() {
+	ob := e.Book()
+	_, _, mid := ob.BidAskMid()
+	if math.IsNaN(mid) {
+		return
+	}
+	now := e.Now()
+	e.pnl = append(e.pnl, TimePoint{Time: now, Value: e.blotter.PnL(e.pair, mid)})
+	e.positions = append(e.positions, TimePoint{Time: now, Value: e.blotter.Position(e.pair)})
+}
+
+// BacktestSummary is the performance of a backtest
+type BacktestSummary struct {
+	PnL       TimeSeries // PnL in Base marked at each orderbook
+	Positions TimeSeries // position in Coin at each orderbook
+	Trades    int
+	stats.Performance
+}
+
+// Summary returns the performance of the backtest so far
+func (e *Engine) Summary() BacktestSummary {
+	trades := e.blotter.Trades()
+	return BacktestSummary{
+		PnL:         e.pnl,
+		Positions:   e.positions,
+		Trades:      len(trades),
+		Performance: stats.Evaluate(e.pnl, trades, e.positions),
+	}
+}
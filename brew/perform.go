@@ -27,7 +27,7 @@ func PerformActions(exs *(map[string]Exchange), actions []TradeAction, sep ...ti
 				act.Params["amount"].(float64),
 			)
 			(*exs)[act.ExName].TrackOrderID(act.Pair, oid)
-			placed = append(placed, ExNameWithOID{act.ExName, act.Pair, oid, time.Now()})
+			placed = append(placed, ExNameWithOID{act.ExName, act.Pair, oid, Now()})
 			break
 		case CancelOpenOrder:
 			oid := act.Params["orderid"].(string)
@@ -35,7 +35,7 @@ func PerformActions(exs *(map[string]Exchange), actions []TradeAction, sep ...ti
 				act.Pair,
 				oid,
 			)
-			cancelled = append(cancelled, ExNameWithOID{act.ExName, act.Pair, oid, time.Now()})
+			cancelled = append(cancelled, ExNameWithOID{act.ExName, act.Pair, oid, Now()})
 			break
 		case Wait:
 			time.Sleep(time.Duration(act.Params["time"].(int)) * time.Second)
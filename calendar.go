@@ -0,0 +1,186 @@
+package bean
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// ExpiryHour is the hour at which contracts expire in ExpiryLocation. Set them before parsing contract names as
+// parsed contracts are cached
+var ExpiryHour = 8
+
+// ExpiryLocation is the time zone of ExpiryHour
+var ExpiryLocation = time.UTC
+
+// ExpiryRule is the time of day at which the contracts of an exchange expire
+type ExpiryRule struct {
+	Hour     int
+	Location *time.Location // UTC if nil
+}
+
+// On returns the expiry time on a day in UTC, normalising days out of the month as time.Date does
+func (r ExpiryRule) On(year int, month time.Month, day int) time.Time {
+	loc := r.Location
+	if loc == nil {
+		loc = time.UTC
+	}
+	return time.Date(year, month, day, r.Hour, 0, 0, 0, loc).UTC()
+}
+
+// Day returns the expiry time on the day of t in the rule location
+func (r ExpiryRule) Day(t time.Time) time.Time {
+	if r.Location != nil {
+		t = t.In(r.Location)
+	} else {
+		t = t.UTC()
+	}
+	return r.On(t.Year(), t.Month(), t.Day())
+}
+
+// addDays returns the expiry time days after the day of e, keeping the local hour across daylight saving changes
+func (r ExpiryRule) addDays(e time.Time, days int) time.Time {
+	if r.Location != nil {
+		e = e.In(r.Location)
+	} else {
+		e = e.UTC()
+	}
+	return r.On(e.Year(), e.Month(), e.Day()+days)
+}
+
+// weekday returns the day of the week of t in the rule location
+func (r ExpiryRule) weekday(t time.Time) time.Weekday {
+	if r.Location != nil {
+		return t.In(r.Location).Weekday()
+	}
+	return t.UTC().Weekday()
+}
+
+// DefaultExpiryRule returns the rule of ExpiryHour and ExpiryLocation, used for bean and deribit names
+func DefaultExpiryRule() ExpiryRule {
+	return ExpiryRule{Hour: ExpiryHour, Location: ExpiryLocation}
+}
+
+var (
+	expiryRulesLock sync.RWMutex
+	expiryRules     = make(map[string]ExpiryRule)
+)
+
+// SetExpiryRule sets the expiry time of the contracts of an exchange, used when parsing its symbols
+func SetExpiryRule(exName string, r ExpiryRule) {
+	expiryRulesLock.Lock()
+	defer expiryRulesLock.Unlock()
+	expiryRules[strings.ToUpper(exName)] = r
+}
+
+// ExchangeExpiryRule returns the expiry rule of an exchange, the default rule if none was set
+func ExchangeExpiryRule(exName string) ExpiryRule {
+	expiryRulesLock.RLock()
+	defer expiryRulesLock.RUnlock()
+	if r, ok := expiryRules[strings.ToUpper(exName)]; ok {
+		return r
+	}
+	return DefaultExpiryRule()
+}
+
+// FundingInterval is the time between perpetual funding payments, which fall at 00:00, 08:00 and 16:00 UTC
+const FundingInterval = 8 * time.Hour
+
+// ExpiryCutoff returns the expiry time on the day of t
+func ExpiryCutoff(t time.Time) time.Time {
+	return DefaultExpiryRule().Day(t)
+}
+
+// NextFunding returns the first funding time strictly after t
+func NextFunding(t time.Time) time.Time {
+	return t.UTC().Truncate(FundingInterval).Add(FundingInterval)
+}
+
+// PrevFunding returns the last funding time at or before t
+func PrevFunding(t time.Time) time.Time {
+	return t.UTC().Truncate(FundingInterval)
+}
+
+// FundingTimes returns the funding times in (from, to]
+func FundingTimes(from, to time.Time) []time.Time {
+	var res []time.Time
+	for t := NextFunding(from); !t.After(to); t = t.Add(FundingInterval) {
+		res = append(res, t)
+	}
+	return res
+}
+
+// WeekdayOnOrAfter returns the expiry time on the first given weekday on or after the day of t
+func WeekdayOnOrAfter(t time.Time, wd time.Weekday) time.Time {
+	r := DefaultExpiryRule()
+	day := r.Day(t)
+	return r.addDays(day, (int(wd)-int(r.weekday(day))+7)%7)
+}
+
+// LastWeekday returns the expiry time on the last given weekday of a month
+func LastWeekday(year int, month time.Month, wd time.Weekday) time.Time {
+	r := DefaultExpiryRule()
+	last := r.On(year, month+1, 0)
+	return r.addDays(last, -((int(r.weekday(last)) - int(wd) + 7) % 7))
+}
+
+// LastFriday returns the monthly expiry of a month
+func LastFriday(year int, month time.Month) time.Time {
+	return LastWeekday(year, month, time.Friday)
+}
+
+// NextDailyExpiry returns the first daily expiry strictly after t
+func NextDailyExpiry(t time.Time) time.Time {
+	e := ExpiryCutoff(t)
+	if !e.After(t) {
+		e = DefaultExpiryRule().addDays(e, 1)
+	}
+	return e
+}
+
+// NextWeeklyExpiry returns the first Friday expiry strictly after t
+func NextWeeklyExpiry(t time.Time) time.Time {
+	e := WeekdayOnOrAfter(t, time.Friday)
+	if !e.After(t) {
+		e = DefaultExpiryRule().addDays(e, 7)
+	}
+	return e
+}
+
+// NextMonthlyExpiry returns the first last-Friday-of-the-month expiry strictly after t
+func NextMonthlyExpiry(t time.Time) time.Time {
+	t = t.UTC()
+	for i := 0; ; i++ {
+		e := LastFriday(t.Year(), t.Month()+time.Month(i))
+		if e.After(t) {
+			return e
+		}
+	}
+}
+
+// NextQuarterlyExpiry returns the first quarterly (March, June, September, December) expiry strictly after t
+func NextQuarterlyExpiry(t time.Time) time.Time {
+	t = t.UTC()
+	for i := 0; ; i++ {
+		m := t.Month() + time.Month(i)
+		if (int(m)-1)%3 != 2 {
+			continue
+		}
+		e := LastFriday(t.Year(), m)
+		if e.After(t) {
+			return e
+		}
+	}
+}
+
+// NextExpiry returns the first expiry of a list strictly after t, false if there is none
+func NextExpiry(expiries []time.Time, t time.Time) (time.Time, bool) {
+	var next time.Time
+	found := false
+	for _, e := range expiries {
+		if e.After(t) && (!found || e.Before(next)) {
+			next, found = e, true
+		}
+	}
+	return next, found
+}
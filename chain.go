@@ -0,0 +1,117 @@
+package bean
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// OptionChain holds the quotes of the listed options of one underlying as of a time, with the spot price and
+// futures curve giving the forward of each expiry
+type OptionChain struct {
+	Underlying Pair
+	Asof       time.Time
+	Spot       float64
+	Curve      *FuturesCurve // nil to use spot as the forward of every expiry
+	Quotes     []ContractTicker
+}
+
+// NewOptionChain returns an empty chain
+func NewOptionChain(underlying Pair, asof time.Time, spotPrice float64, curve *FuturesCurve) *OptionChain {
+	return &OptionChain{
+		Underlying: underlying,
+		Asof:       asof,
+		Spot:       spotPrice,
+		Curve:      curve,
+	}
+}
+
+// Add adds or replaces the quote of an option. Other contracts are ignored
+func (ch *OptionChain) Add(t ContractTicker) {
+	if t.Contract == nil || !t.Contract.IsOption() {
+		return
+	}
+	for i := range ch.Quotes {
+		if ch.Quotes[i].Contract.Equal(t.Contract) {
+			ch.Quotes[i] = t
+			return
+		}
+	}
+	ch.Quotes = append(ch.Quotes, t)
+}
+
+// Quote returns the quote of an option in the chain
+func (ch *OptionChain) Quote(c *Contract) (ContractTicker, bool) {
+	for _, q := range ch.Quotes {
+		if q.Contract.Equal(c) {
+			return q, true
+		}
+	}
+	return ContractTicker{}, false
+}
+
+// Forward returns the forward price of an expiry
+func (ch *OptionChain) Forward(expiry time.Time) float64 {
+	if ch.Curve == nil {
+		return ch.Spot
+	}
+	return ch.Curve.Forward(expiry)
+}
+
+// Expiries returns the expiries in the chain in order
+func (ch *OptionChain) Expiries() []time.Time {
+	var res []time.Time
+	seen := make(map[time.Time]bool)
+	for _, q := range ch.Quotes {
+		e := q.Contract.Expiry()
+		if !seen[e] {
+			seen[e] = true
+			res = append(res, e)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Before(res[j]) })
+	return res
+}
+
+// Strikes returns the strikes listed for an expiry in order
+func (ch *OptionChain) Strikes(expiry time.Time) []float64 {
+	var res []float64
+	seen := make(map[float64]bool)
+	for _, q := range ch.Quotes {
+		if q.Contract.Expiry().Equal(expiry) && !seen[q.Contract.Strike()] {
+			seen[q.Contract.Strike()] = true
+			res = append(res, q.Contract.Strike())
+		}
+	}
+	sort.Float64s(res)
+	return res
+}
+
+// Expiry returns the quotes of one expiry sorted by strike, calls before puts
+func (ch *OptionChain) Expiry(expiry time.Time) []ContractTicker {
+	var res []ContractTicker
+	for _, q := range ch.Quotes {
+		if q.Contract.Expiry().Equal(expiry) {
+			res = append(res, q)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Contract.Before(res[j].Contract) })
+	return res
+}
+
+// CallPut returns the call and put quotes of a strike and expiry, false if either is missing
+func (ch *OptionChain) CallPut(expiry time.Time, strike float64) (call, put ContractTicker, ok bool) {
+	var okCall, okPut bool
+	for _, q := range ch.Quotes {
+		c := q.Contract
+		if !c.Expiry().Equal(expiry) || math.Abs(c.Strike()-strike) > 1e-9 {
+			continue
+		}
+		if c.CallPut() == Call {
+			call, okCall = q, true
+		} else {
+			put, okPut = q, true
+		}
+	}
+	return call, put, okCall && okPut
+}
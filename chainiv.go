@@ -0,0 +1,166 @@
+package bean
+
+import (
+	"math"
+	"sort"
+	"strings"
+)
+
+// IVQuality flags the problems of the quote an implied vol was solved from, zero if there are none
+type IVQuality uint8
+
+const (
+	IVNoQuote        IVQuality = 1 << iota // no bid, ask or mark
+	IVOneSided                             // only a bid or an ask, the mid IV is that of the mark or the side quoted
+	IVCrossed                              // bid above ask, the mid IV is not solved
+	IVBelowIntrinsic                       // a price at or below intrinsic, its IV is zero
+	IVNoConvergence                        // a price above the no arbitrage bound or the solver failed, its IV is NaN
+	IVExpired                              // the option has expired
+)
+
+func (q IVQuality) String() string {
+	if q == 0 {
+		return "ok"
+	}
+	var res []string
+	for i, name := range []string{"no_quote", "one_sided", "crossed", "below_intrinsic", "no_convergence", "expired"} {
+		if q&(1<<uint(i)) != 0 {
+			res = append(res, name)
+		}
+	}
+	return strings.Join(res, "|")
+}
+
+// StrikeIV is the implied vols of the quotes of one option of a chain. Prices are in LHS coin, vols NaN when
+// there is no price or the solver did not converge
+type StrikeIV struct {
+	Contract *Contract
+	Forward  float64
+	Bid      float64
+	Ask      float64
+	Mid      float64 // mid of the book, else the mark, else the side quoted
+	BidIV    float64
+	AskIV    float64
+	MidIV    float64
+	Quality  IVQuality
+}
+
+// OK is true if the mid IV was solved from a two sided book
+func (s StrikeIV) OK() bool {
+	return s.Quality == 0
+}
+
+// SolveChainIVs solves the implied vols of every option of a chain in one pass, sorted by expiry, strike then calls
+// before puts. Prices come from books by contract name when present, otherwise from the chain quotes. A positive
+// forward is used for every expiry, otherwise the chain forward of each expiry. Time to expiry and the forward are
+// computed once per expiry and the solver uses the analytic vega, so it is faster and more accurate than ImpVol
+func SolveChainIVs(ch *OptionChain, books map[string]OrderBook, forward float64) []StrikeIV {
+	var res []StrikeIV
+	for _, expiry := range ch.Expiries() {
+		fwd := forward
+		if !(fwd > 0) {
+			fwd = ch.Forward(expiry)
+		}
+		quotes := ch.Expiry(expiry)
+		if len(quotes) == 0 {
+			continue
+		}
+		years := quotes[0].Contract.ExpiryYears(ch.Asof)
+		expired := quotes[0].Contract.Expired(ch.Asof)
+		for _, q := range quotes {
+			s := StrikeIV{Contract: q.Contract, Forward: fwd, Bid: q.BestBid, Ask: q.BestAsk, Mid: math.NaN(),
+				BidIV: math.NaN(), AskIV: math.NaN(), MidIV: math.NaN()}
+			if ob, ok := books[q.Contract.Name()]; ok && ob.OrderBookCore != nil {
+				s.Bid, s.Ask = ob.BestBid().Price, ob.BestAsk().Price
+			}
+			hasBid, hasAsk := validPrice(s.Bid), validPrice(s.Ask)
+			switch {
+			case hasBid && hasAsk && s.Bid > s.Ask:
+				s.Quality |= IVCrossed
+			case hasBid && hasAsk:
+				s.Mid = (s.Bid + s.Ask) / 2
+			case validPrice(q.MarkPrice):
+				s.Mid = q.MarkPrice
+			case hasBid:
+				s.Mid = s.Bid
+			case hasAsk:
+				s.Mid = s.Ask
+			}
+			if hasBid != hasAsk {
+				s.Quality |= IVOneSided
+			}
+			if !hasBid && !hasAsk && math.IsNaN(s.Mid) {
+				s.Quality |= IVNoQuote
+			}
+			if expired || !(years > 0) || !validPrice(fwd) {
+				if expired {
+					s.Quality |= IVExpired
+				}
+				res = append(res, s)
+				continue
+			}
+			solve := func(price float64) float64 {
+				if !validPrice(price) {
+					return math.NaN()
+				}
+				vol, quality := solveForwardIV(price*fwd, fwd, q.Contract.strike, years, q.Contract.callPut)
+				s.Quality |= quality
+				return vol
+			}
+			s.BidIV, s.AskIV, s.MidIV = solve(s.Bid), solve(s.Ask), solve(s.Mid)
+			res = append(res, s)
+		}
+	}
+	sort.SliceStable(res, func(i, j int) bool { return res[i].Contract.Before(res[j].Contract) })
+	return res
+}
+
+// solveForwardIV returns the vol of an undiscounted black price in RHS coin forward value by Newton steps on the
+// analytic vega, falling back to bisection when a step leaves the bracket
+func solveForwardIV(price, forward, strike, years float64, cp CallOrPut) (float64, IVQuality) {
+	intrinsic := forwardOptionPrice(0, strike, forward, 0, cp)
+	upper := forward
+	if cp == Put {
+		upper = strike
+	}
+	if price <= intrinsic {
+		return 0, IVBelowIntrinsic
+	}
+	if price >= upper {
+		return math.NaN(), IVNoConvergence
+	}
+	sqrtT := math.Sqrt(years)
+	lo, hi := 0.0, 10.0
+	// Manaster Koehler starting point, from which Newton steps converge monotonically, or the Brenner
+	// Subrahmanyam approximation at the money
+	vol := math.Sqrt(2 * math.Abs(math.Log(forward/strike)) / years)
+	if vol < 0.01 {
+		vol = math.Sqrt(2*math.Pi/years) * price / forward
+	}
+	vol = math.Min(vol, 5)
+	for i := 0; i < 100; i++ {
+		sd := vol * sqrtT
+		d1 := math.Log(forward/strike)/sd + sd/2
+		d2 := d1 - sd
+		model := forward*cumNormDist(d1) - strike*cumNormDist(d2)
+		if cp == Put {
+			model += strike - forward
+		}
+		diff := model - price
+		if math.Abs(diff) < 1e-9*forward {
+			return vol, 0
+		}
+		if diff > 0 {
+			hi = vol
+		} else {
+			lo = vol
+		}
+		vega := forward * math.Exp(-d1*d1/2) / math.Sqrt(2*math.Pi) * sqrtT
+		next := vol - diff/vega
+		if !(next > lo && next < hi) {
+			next = (lo + hi) / 2
+		}
+		vol = next
+	}
+	return math.NaN(), IVNoConvergence
+}
@@ -0,0 +1,134 @@
+package bean
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Clock tells the time to the parts of the library that need the current time. Components such as the rate
+// limiters, the reconciler, the runners and the simulated brokers take their own clock, a SimClock in tests and
+// backtests, so that several of them can run side by side in different times
+type Clock interface {
+	Now() time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+// RealClock is the system clock
+var RealClock Clock = realClock{}
+
+// SimClock is a clock set by hand or by a backtest. It is safe for concurrent use
+type SimClock struct {
+	m       sync.RWMutex
+	t       time.Time
+	waiters []simWaiter
+}
+
+// simWaiter is a SleepUntil woken when the clock reaches at
+type simWaiter struct {
+	at   time.Time
+	wake chan struct{}
+}
+
+// NewSimClock returns a clock stopped at t
+func NewSimClock(t time.Time) *SimClock {
+	return &SimClock{t: t}
+}
+
+func (c *SimClock) Now() time.Time {
+	c.m.RLock()
+	defer c.m.RUnlock()
+	return c.t
+}
+
+func (c *SimClock) Set(t time.Time) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.t = t
+	c.wake()
+}
+
+// Advance moves the clock forward by d
+func (c *SimClock) Advance(d time.Duration) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.t = c.t.Add(d)
+	c.wake()
+}
+
+// wake releases the waiters the clock has reached
+func (c *SimClock) wake() {
+	kept := c.waiters[:0]
+	for _, w := range c.waiters {
+		if c.t.Before(w.at) {
+			kept = append(kept, w)
+		} else {
+			close(w.wake)
+		}
+	}
+	c.waiters = kept
+}
+
+// until returns a channel closed once the clock reaches t
+func (c *SimClock) until(t time.Time) <-chan struct{} {
+	c.m.Lock()
+	defer c.m.Unlock()
+	w := simWaiter{at: t, wake: make(chan struct{})}
+	if c.t.Before(t) {
+		c.waiters = append(c.waiters, w)
+	} else {
+		close(w.wake)
+	}
+	return w.wake
+}
+
+// SleepUntil blocks until clock c reaches t, returning the context error if ctx is done first. A SimClock wakes
+// its sleepers when it is set or advanced past their time, other clocks are assumed to run at the system pace
+func SleepUntil(ctx context.Context, c Clock, t time.Time) error {
+	if sim, ok := c.(*SimClock); ok {
+		select {
+		case <-sim.until(t):
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	d := t.Sub(c.Now())
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+var (
+	clockMutex sync.RWMutex
+	clock      Clock = RealClock
+)
+
+// SetClock sets the library clock, nil for the system clock. It is only the default time of the parsing of partial
+// contract names and of perpetual contracts, components take a Clock of their own
+func SetClock(c Clock) {
+	clockMutex.Lock()
+	defer clockMutex.Unlock()
+	if c == nil {
+		c = RealClock
+	}
+	clock = c
+}
+
+// Now returns the current time of the library clock
+func Now() time.Time {
+	clockMutex.RLock()
+	defer clockMutex.RUnlock()
+	return clock.Now()
+}
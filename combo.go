@@ -0,0 +1,250 @@
+package bean
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ComboKind is the strategy of an exchange listed combo, as in deribit combo names
+type ComboKind string
+
+const (
+	FutureSpread ComboKind = "FS"   // buy a future, sell another or the perpetual
+	CallSpread   ComboKind = "CS"   // buy a call, sell a call of another strike
+	PutSpread    ComboKind = "PS"   // buy a put, sell a put of another strike
+	CallRatio    ComboKind = "CSR"  // buy calls, sell a multiple of calls of another strike
+	PutRatio     ComboKind = "PSR"  // buy puts, sell a multiple of puts of another strike
+	Straddle     ComboKind = "STRD" // buy a put and a call of the same strike
+	Strangle     ComboKind = "STRG" // buy a put and a call of a higher strike
+	RiskReversal ComboKind = "RR"   // sell a put, buy a call of a higher strike
+	CallCalendar ComboKind = "CCAL" // buy a call, sell a call of the same strike and another expiry
+	PutCalendar  ComboKind = "PCAL" // buy a put, sell a put of the same strike and another expiry
+)
+
+// ComboLeg is one leg of a combo, Ratio contracts per combo, negative if sold
+type ComboLeg struct {
+	Contract *Contract
+	Ratio    float64
+}
+
+// ComboContract is an exchange listed futures spread or option combo of two legs, traded as one instrument.
+// Build it with ComboFromName or by setting the kind and legs
+type ComboContract struct {
+	name string // set by ComboFromName, the cached combos being shared
+	Kind ComboKind
+	Legs [2]ComboLeg
+}
+
+var comboCacheLock sync.Mutex
+var comboCache = make(map[string]*ComboContract)
+
+// ComboFromName parses a deribit combo name, in any case. The legs are in the order of the name:
+//
+//	BTC-FS-28JUN19_PERP            buy the 28JUN19 future, sell the perpetual
+//	BTC-CS-28JUN19-9000_10000      buy the 9000 call, sell the 10000 call (PS alike)
+//	BTC-CSR12-28JUN19-9000_10000   buy one 9000 call, sell two 10000 calls (PSR alike)
+//	BTC-STRD-28JUN19-9000          buy the 9000 call and put
+//	BTC-STRG-28JUN19-9000_10000    buy the 9000 put and the 10000 call
+//	BTC-RR-28JUN19-9000_10000      sell the 9000 put, buy the 10000 call
+//	BTC-CCAL-28JUN19_27SEP19-9000  buy the 28JUN19 call, sell the 27SEP19 call (PCAL alike)
+func ComboFromName(name string) (*ComboContract, error) {
+	comboCacheLock.Lock()
+	defer comboCacheLock.Unlock()
+	if c, ok := comboCache[name]; ok {
+		return c, nil
+	}
+	st := strings.Split(strings.ToUpper(name), "-")
+	if len(st) < 3 {
+		return nil, contractError(name, ErrBadContractFormat)
+	}
+	coin := st[0]
+	kind, ratios, err := parseComboKind(st[1])
+	if err != nil {
+		return nil, contractError(name, err)
+	}
+	leg := func(parts ...string) (*Contract, error) {
+		return ContractFromName(coin + "-" + strings.Join(parts, "-"))
+	}
+	var c1, c2 *Contract
+	switch kind {
+	case FutureSpread:
+		expiries := strings.Split(st[2], "_")
+		if len(st) != 3 || len(expiries) != 2 {
+			return nil, contractError(name, ErrBadContractFormat)
+		}
+		for i, e := range expiries {
+			if e == "PERP" {
+				expiries[i] = "PERPETUAL"
+			}
+		}
+		if c1, err = leg(expiries[0]); err == nil {
+			c2, err = leg(expiries[1])
+		}
+	case CallCalendar, PutCalendar:
+		expiries := strings.Split(st[2], "_")
+		if len(st) != 4 || len(expiries) != 2 {
+			return nil, contractError(name, ErrBadContractFormat)
+		}
+		cp := "C"
+		if kind == PutCalendar {
+			cp = "P"
+		}
+		if c1, err = leg(expiries[0], st[3], cp); err == nil {
+			c2, err = leg(expiries[1], st[3], cp)
+		}
+	case Straddle:
+		if len(st) != 4 {
+			return nil, contractError(name, ErrBadContractFormat)
+		}
+		if c1, err = leg(st[2], st[3], "C"); err == nil {
+			c2, err = leg(st[2], st[3], "P")
+		}
+	default:
+		if len(st) != 4 {
+			return nil, contractError(name, ErrBadContractFormat)
+		}
+		strikes := strings.Split(st[3], "_")
+		if len(strikes) != 2 {
+			return nil, contractError(name, ErrBadContractFormat)
+		}
+		cp1, cp2 := "C", "C"
+		switch kind {
+		case PutSpread, PutRatio:
+			cp1, cp2 = "P", "P"
+		case Strangle, RiskReversal:
+			cp1 = "P"
+		}
+		if c1, err = leg(st[2], strikes[0], cp1); err == nil {
+			c2, err = leg(st[2], strikes[1], cp2)
+		}
+	}
+	if err != nil {
+		return nil, contractError(name, ErrBadContractFormat)
+	}
+	switch kind {
+	case RiskReversal:
+		ratios[0] = -ratios[0]
+	case Straddle, Strangle:
+	default:
+		ratios[1] = -ratios[1]
+	}
+	c := &ComboContract{Kind: kind, Legs: [2]ComboLeg{{c1, ratios[0]}, {c2, ratios[1]}}}
+	c.name = c.format()
+	comboCache[name] = c
+	return c, nil
+}
+
+// parseComboKind parses the kind of a combo name and the absolute ratios of its legs, given as two digits after
+// ratio kinds (CSR12)
+func parseComboKind(s string) (ComboKind, [2]float64, error) {
+	ratios := [2]float64{1, 1}
+	kind := ComboKind(strings.TrimRight(s, "0123456789"))
+	digits := s[len(kind):]
+	switch kind {
+	case CallRatio, PutRatio:
+		if len(digits) != 2 || digits[0] == '0' || digits[1] == '0' {
+			return kind, ratios, ErrBadContractFormat
+		}
+		ratios[0], ratios[1] = float64(digits[0]-'0'), float64(digits[1]-'0')
+	case FutureSpread, CallSpread, PutSpread, Straddle, Strangle, RiskReversal, CallCalendar, PutCalendar:
+		if digits != "" {
+			return kind, ratios, ErrBadContractFormat
+		}
+	default:
+		return kind, ratios, ErrBadContractFormat
+	}
+	return kind, ratios, nil
+}
+
+// Name returns the deribit name of the combo
+func (c *ComboContract) Name() string {
+	if c.name != "" {
+		return c.name
+	}
+	return c.format()
+}
+
+// format builds the deribit name of the combo from its kind and legs
+func (c *ComboContract) format() string {
+	l1, l2 := c.Legs[0].Contract, c.Legs[1].Contract
+	kind := string(c.Kind)
+	if c.Kind == CallRatio || c.Kind == PutRatio {
+		kind += strconv.Itoa(int(math.Abs(c.Legs[0].Ratio))) + strconv.Itoa(int(math.Abs(c.Legs[1].Ratio)))
+	}
+	expiry := func(l *Contract) string {
+		if l.Perp() {
+			return "PERP"
+		}
+		return l.ExpiryStr()
+	}
+	parts := []string{string(l1.Underlying().Coin), kind}
+	switch c.Kind {
+	case FutureSpread:
+		parts = append(parts, expiry(l1)+"_"+expiry(l2))
+	case CallCalendar, PutCalendar:
+		parts = append(parts, expiry(l1)+"_"+expiry(l2), formatStrike(l1.Strike()))
+	case Straddle:
+		parts = append(parts, expiry(l1), formatStrike(l1.Strike()))
+	default:
+		parts = append(parts, expiry(l1), formatStrike(l1.Strike())+"_"+formatStrike(l2.Strike()))
+	}
+	return strings.Join(parts, "-")
+}
+
+// Underlying returns the underlying of the legs
+func (c *ComboContract) Underlying() Pair {
+	return c.Legs[0].Contract.Underlying()
+}
+
+// Expiry returns the first expiry of the legs, when the combo stops trading
+func (c *ComboContract) Expiry() time.Time {
+	e1, e2 := c.Legs[0].Contract.Expiry(), c.Legs[1].Contract.Expiry()
+	if e2.Before(e1) {
+		return e2
+	}
+	return e1
+}
+
+// IsOption is true for option combos, false for futures spreads
+func (c *ComboContract) IsOption() bool {
+	return c.Legs[0].Contract.IsOption()
+}
+
+// Price returns the price of the combo from the prices of its legs: the ratio weighted sum of the option prices
+// in LHS coin, or the difference of the futures prices in USD
+func (c *ComboContract) Price(legPrices [2]float64) float64 {
+	if !c.IsOption() {
+		return legPrices[0] - legPrices[1]
+	}
+	return c.Legs[0].Ratio*legPrices[0] + c.Legs[1].Ratio*legPrices[1]
+}
+
+// Positions returns the leg positions of qty combos, bought (negative if sold) with the legs at legPrices
+func (c *ComboContract) Positions(qty float64, legPrices [2]float64) []Position {
+	return []Position{
+		NewPosition(c.Legs[0].Contract, qty*c.Legs[0].Ratio, legPrices[0]),
+		NewPosition(c.Legs[1].Contract, qty*c.Legs[1].Ratio, legPrices[1]),
+	}
+}
+
+// PVMarket returns the PV of qty combos traded at legPrices, the sum of the PVs of the legs using the market
+func (c *ComboContract) PVMarket(m *Market, qty float64, legPrices [2]float64) float64 {
+	pv := 0.0
+	for _, p := range c.Positions(qty, legPrices) {
+		pv += p.PVMarket(m)
+	}
+	return pv
+}
+
+// GreeksMarket returns the greeks of qty combos traded at legPrices, the sum of the greeks of the legs using the
+// market
+func (c *ComboContract) GreeksMarket(m *Market, qty float64, legPrices [2]float64) Greeks {
+	var g Greeks
+	for _, p := range c.Positions(qty, legPrices) {
+		g = g.Add(p.GreeksMarket(m))
+	}
+	return g
+}
@@ -1,7 +1,8 @@
 package bean
 
 import (
-	"errors"
+	"context"
+	"fmt"
 	"math"
 	"sort"
 	"strconv"
@@ -50,6 +51,8 @@ func (c *Contract) Hash() (hash int) {
 var conCacheLock sync.Mutex
 var contractCache = make(map[string]*Contract)
 
+// ContractFromName parses a deribit style name such as BTC-27JUN25-60000-C, in any case. Strikes may be decimal
+// (ETH-27JUN25-50.5-C or 0d5) or in thousands (60k)
 func ContractFromName(name string) (*Contract, error) {
 	var expiry time.Time
 	var callPut CallOrPut
@@ -65,21 +68,14 @@ func ContractFromName(name string) (*Contract, error) {
 		return con, nil
 	}
 
-	st := strings.Split(name, "-")
+	st := strings.Split(strings.ToUpper(name), "-")
 	if len(st) < 2 {
-		return nil, errors.New("Bad contract formation")
+		return nil, contractError(name, ErrBadContractFormat)
 	}
 
-	switch st[0] {
-	case "BTC":
-		underlying = Pair{BTC, USD}
-	case "ETH":
-		underlying = Pair{ETH, USD}
-	case "BCH":
-		underlying = Pair{BCH, USD}
-	default:
-		err = errors.New("do not recognise coin")
-		return nil, err
+	underlying, err = underlyingFromCoin(st[0])
+	if err != nil {
+		return nil, contractError(name, err)
 	}
 
 	switch len(st) {
@@ -102,6 +98,8 @@ func ContractFromName(name string) (*Contract, error) {
 	case 3:
 		if st[1] == "DERIBIT" && st[2] == "INDEX" {
 			con = IndexContract(underlying)
+		} else {
+			return nil, contractError(name, ErrBadContractFormat)
 		}
 
 	case 4:
@@ -112,11 +110,10 @@ func ContractFromName(name string) (*Contract, error) {
 		}
 		//		expiry = time.Date(dt.Year(), dt.Month(), dt.Day(), 8, 0, 0, 0, time.UTC) // 8am london expiry
 
-		strikei, err := strconv.Atoi(st[2])
+		strike, err = parseStrike(st[2])
 		if err != nil {
-			return nil, err
+			return nil, contractError(name, ErrBadContractFormat)
 		}
-		strike = float64(strikei)
 
 		switch st[3] {
 		case "C":
@@ -124,8 +121,7 @@ func ContractFromName(name string) (*Contract, error) {
 		case "P":
 			callPut = Put
 		default:
-			return nil, errors.New("Need C OR P")
-
+			return nil, contractError(name, ErrBadContractFormat)
 		}
 		con = &Contract{
 			isOption:   true,
@@ -136,129 +132,116 @@ func ContractFromName(name string) (*Contract, error) {
 			strike:     strike}
 
 	default:
-		return nil, errors.New("not a good contract formation")
+		return nil, contractError(name, ErrBadContractFormat)
 	}
 
 	contractCache[name] = con
 	return con, nil
 }
 
-// strToTime converts dates in the strict format DMMMYY or DDMMMYY
-// hopefully faster than the more generic time.Parse
-func strToExpiry(s string) (t time.Time, err error) {
-	// date must be 2FEB20 or 22MAR21
+// months maps the english month abbreviations of contract names, independently of the locale
+var months = map[string]time.Month{
+	"JAN": time.January, "FEB": time.February, "MAR": time.March, "APR": time.April, "MAY": time.May, "JUN": time.June,
+	"JUL": time.July, "AUG": time.August, "SEP": time.September, "OCT": time.October, "NOV": time.November, "DEC": time.December,
+}
+
+// strToExpiry converts upper case dates in the strict format DMMMYY or DDMMMYY to the expiry time of the default
+// rule on that day, hopefully faster than the more generic time.Parse
+func strToExpiry(s string) (time.Time, error) {
+	return parseExpiryDate(s, DefaultExpiryRule())
+}
+
+// parseExpiryDate parses DMMMYY or DDMMMYY dates (2FEB20 or 22MAR21) in the 21st century, rejecting days that
+// are not in the month rather than rolling them over
+func parseExpiryDate(s string, r ExpiryRule) (time.Time, error) {
 	var daystr, monthstr, yearstr string
 	switch len(s) {
 	case 6:
-		daystr = s[0:1]
-		monthstr = s[1:4]
-		yearstr = s[4:6]
+		daystr, monthstr, yearstr = s[0:1], s[1:4], s[4:6]
 	case 7:
-		daystr = s[0:2]
-		monthstr = s[2:5]
-		yearstr = s[5:7]
+		daystr, monthstr, yearstr = s[0:2], s[2:5], s[5:7]
 	default:
-		err = errors.New("Date not recognised:" + s)
-		return
+		return time.Time{}, contractError(s, ErrBadContractFormat)
 	}
-
-	day, err := strconv.Atoi(daystr)
-	if err != nil {
-		err = errors.New("Date not recognised:" + s)
-		return
+	month, ok := months[monthstr]
+	if !ok || !digits(daystr) || !digits(yearstr) {
+		return time.Time{}, contractError(s, ErrBadContractFormat)
 	}
-	year, err := strconv.Atoi(yearstr)
-	if err != nil {
-		err = errors.New("Date not recognised:" + s)
-		return
-	}
-	var month time.Month
-	switch monthstr {
-	case "JAN":
-		month = time.January
-	case "FEB":
-		month = time.February
-	case "MAR":
-		month = time.March
-	case "APR":
-		month = time.April
-	case "MAY":
-		month = time.May
-	case "JUN":
-		month = time.June
-	case "JUL":
-		month = time.July
-	case "AUG":
-		month = time.August
-	case "SEP":
-		month = time.September
-	case "OCT":
-		month = time.October
-	case "NOV":
-		month = time.November
-	case "DEC":
-		month = time.December
-	default:
-		err = errors.New("Contract date not recognised" + s)
+	day, _ := strconv.Atoi(daystr)
+	year, _ := strconv.Atoi(yearstr)
+	year += 2000
+	if day < 1 || day > time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC).Day() {
+		return time.Time{}, contractError(s, ErrBadContractFormat)
 	}
+	return r.On(year, month, day), nil
+}
 
-	t = time.Date(year+2000, month, day, 8, 0, 0, 0, time.UTC)
-	return
+func digits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return s != ""
 }
 
 // ContractFromPartialName accepts contracts in the form
 // dec mar-10000 btc-jun-10000-c fri 2fr perp
+// Shorthand dates are relative to the library clock, see ContractFromPartialNameAt
 func ContractFromPartialName(partialName string) (*Contract, error) {
-	const example = "\nDon't understand contract\nExample JUN or 3500 or MAR-4000-C or BTC-3000-P"
-	sts := strings.Split(partialName, "-")
-	defaultExpiry, _ := time.Parse("02Jan06", "28Jun19")
-	c := Contract{
-		isOption:   false,
+	return ContractFromPartialNameAt(partialName, Now(), nil)
+}
+
+// DefaultPartialContract returns the contract ContractFromPartialName fills in: the BTC-28JUN19 future, with a
+// 5000 call strike if the name makes it an option
+func DefaultPartialContract() *Contract {
+	defaultExpiry := DefaultExpiryRule().On(2019, time.June, 28)
+	return &Contract{
 		underlying: Pair{BTC, USD},
 		expiry:     defaultExpiry,
 		delivery:   defaultExpiry,
 		callPut:    Call,
 		strike:     5000}
+}
+
+// ContractFromPartialNameAt parses a partial name as ContractFromPartialName does, resolving JUN, FRI, PERP and
+// the like relative to asof and taking the parts missing from the name from template (DefaultPartialContract if nil)
+func ContractFromPartialNameAt(partialName string, asof time.Time, template *Contract) (*Contract, error) {
+	const example = "\nDon't understand contract\nExample JUN or 3500 or MAR-4000-C or BTC-3000-P"
+	sts := strings.Split(partialName, "-")
+	if template == nil {
+		template = DefaultPartialContract()
+	}
+	c := *template
 
 	for _, s := range sts {
 		switch strings.ToUpper(s) {
 		case "PERP":
 			c.perp = true
-			n := time.Now()
-			c.expiry = n.Add(24 * time.Hour)
+			c.expiry = asof.Add(24 * time.Hour)
 			continue
 		case "INDEX":
 			c.index = true
-			n := time.Now()
-			c.expiry = n
+			c.expiry = asof
 			continue
 
 		case "JAN", "FEB", "MAR", "APR", "MAY", "JUN", "JUL", "AUG", "SEP", "OCT", "NOV", "DEC":
-			// Find the last friday of the relevant month
-			tod := time.Now()
-			mth, _ := time.Parse("Jan", strings.ToUpper(s))
-			followingMonth := mth.Month()%12 + 1 // January is 1
+			// the last friday of the month, next year for months before this one
+			tod := asof.UTC()
+			mth := months[strings.ToUpper(s)]
 			year := tod.Year()
-			if tod.Month() >= followingMonth {
-				year++ // Months before today - select next year
+			if mth < tod.Month() {
+				year++
 			}
-			dt := time.Date(year, followingMonth, 1, 8.0, 0, 0, 0, time.UTC) // first day of the following month
-			daysToAdd := -1 - (dt.Weekday()+1)%7                             // go back to the strictly previous friday
-			c.expiry = dt.Add(time.Duration(daysToAdd) * time.Hour * 24)
+			c.expiry = LastFriday(year, mth)
 			c.delivery = c.expiry
 			continue
 		case "FRI": // The next friday date. Today if a friday
-			n := time.Now()
-			tod := time.Date(n.Year(), n.Month(), n.Day(), 8, 0, 0, 0, time.UTC)
-			daysToAdd := (5 - int64(tod.Weekday())) % 7
-			c.expiry = tod.Add(time.Duration(daysToAdd) * time.Hour * 24)
+			c.expiry = WeekdayOnOrAfter(asof, time.Friday)
 			c.delivery = c.expiry
 			continue
 		case "2FR": // The following friday
-			n := time.Now()
-			tod := time.Date(n.Year(), n.Month(), n.Day(), 8, 0, 0, 0, time.UTC)
-			daysToAdd := (5-int64(tod.Weekday()))%7 + 7
-			c.expiry = tod.Add(time.Duration(daysToAdd) * time.Hour * 24)
+			c.expiry = WeekdayOnOrAfter(asof, time.Friday).AddDate(0, 0, 7)
 			c.delivery = c.expiry
 			continue
 		case "BTC":
@@ -281,23 +264,23 @@ func ContractFromPartialName(partialName string) (*Contract, error) {
 		case "":
 			continue
 		}
-		if d, err := time.Parse(ContractDateFormat, strings.ToTitle(s)); err == nil {
+		if d, err := strToExpiry(strings.ToUpper(s)); err == nil {
 			c.expiry = d
 			c.delivery = d
 			continue
 		}
-		if n, err := strconv.Atoi(s); err == nil {
-			c.strike = float64(n)
+		if k, err := parseStrike(s); err == nil {
+			c.strike = k
 			c.isOption = true
 			continue
 		}
-		return &c, errors.New("Don't recognise:" + s + example)
+		return &c, fmt.Errorf("Don't recognise:%s: %w%s", s, ErrBadContractFormat, example)
 	}
 	return &c, nil
 }
 
 func PerpContract(p Pair) *Contract {
-	n := time.Now()
+	n := Now()
 	return &Contract{
 		perp:       true,
 		expiry:     n.Add(24 * time.Hour),
@@ -306,7 +289,7 @@ func PerpContract(p Pair) *Contract {
 }
 
 func IndexContract(p Pair) *Contract {
-	n := time.Now()
+	n := Now()
 	return &Contract{
 		index:      true,
 		expiry:     n,
@@ -357,7 +340,7 @@ func (c *Contract) Name() string {
 			} else {
 				cptext = "P"
 			}
-			c.name = string(c.underlying.Coin) + "-" + c.ExpiryStr() + "-" + strconv.FormatFloat(c.strike, 'f', 0, 64) + "-" + cptext
+			c.name = string(c.underlying.Coin) + "-" + c.ExpiryStr() + "-" + formatStrike(c.strike) + "-" + cptext
 		} else {
 			if c.perp {
 				c.name = string(c.underlying.Coin) + "-PERPETUAL"
@@ -371,6 +354,53 @@ func (c *Contract) Name() string {
 	return c.name
 }
 
+// NameStyle is a way of writing contract names
+type NameStyle int
+
+const (
+	StyleBean    NameStyle = iota // Name, e.g. BTC-28JUN19-5000-C or ETH-28JUN19-50.5-C
+	StyleDeribit                  // deribit instrument names, decimal strikes with a d as in XRP-28JUN19-0d5-C
+	StyleHuman                    // for display, e.g. BTC 28 Jun 2019 5000 Call
+	StyleCompact                  // for keys and file names, e.g. BTC190628C5000, BTC190628 or BTCPERP
+)
+
+// Format returns the name of the contract in a style. The bean and deribit styles are parsed back exactly by
+// ContractFromName
+func (c *Contract) Format(style NameStyle) string {
+	coin := string(c.underlying.Coin)
+	switch style {
+	case StyleDeribit:
+		if c.isOption {
+			return coin + "-" + c.ExpiryStr() + "-" + strings.Replace(formatStrike(c.strike), ".", "d", 1) + "-" + string(c.callPut)
+		}
+	case StyleHuman:
+		expiry := c.expiry.Format("2 Jan 2006")
+		switch {
+		case c.isOption && c.callPut == Call:
+			return coin + " " + expiry + " " + formatStrike(c.strike) + " Call"
+		case c.isOption:
+			return coin + " " + expiry + " " + formatStrike(c.strike) + " Put"
+		case c.perp:
+			return coin + " Perpetual"
+		case c.index:
+			return coin + " Index"
+		}
+		return coin + " " + expiry + " Future"
+	case StyleCompact:
+		expiry := c.expiry.Format(yymmddFormat)
+		switch {
+		case c.isOption:
+			return coin + expiry + string(c.callPut) + formatStrike(c.strike)
+		case c.perp:
+			return coin + "PERP"
+		case c.index:
+			return coin + "INDEX"
+		}
+		return coin + expiry
+	}
+	return c.Name()
+}
+
 func (c Contract) Expiry() (dt time.Time) {
 	return c.expiry
 }
@@ -472,46 +502,156 @@ func (c *Contract) CallPutMirror() *Contract {
 	return &p
 }
 
-// Calculate the implied vol of a contract given its price in LHS coin value spot
-func (c Contract) ImpVol(asof time.Time, spotPrice, futPrice, optionPrice float64) float64 {
+// Calculate the implied vol of a contract given its price in LHS coin value spot.
+// Returns NaN and ErrNotAnOption, ErrExpired or ErrNoConvergence if the vol cannot be found
+func (c Contract) ImpVol(asof time.Time, spotPrice, futPrice, optionPrice float64) (float64, error) {
+	return c.ImpVolContext(context.Background(), asof, spotPrice, futPrice, optionPrice)
+}
+
+// ImpVolContext is ImpVol with a context to cancel the solver, returning the context error if it is done first
+func (c Contract) ImpVolContext(ctx context.Context, asof time.Time, spotPrice, futPrice, optionPrice float64) (float64, error) {
 	if !c.IsOption() {
-		return math.NaN()
+		return math.NaN(), contractError(c.Name(), ErrNotAnOption)
+	}
+	if err := c.checkPricingInputs(spotPrice, futPrice, 0); err != nil {
+		return math.NaN(), contractError(c.Name(), err)
+	}
+	if math.IsNaN(optionPrice) || math.IsInf(optionPrice, 0) || optionPrice < 0 {
+		return math.NaN(), contractError(c.Name(), fmt.Errorf("%w: option price %v", ErrInvalidInput, optionPrice))
 	}
 	strike := c.Strike()
 	cp := c.CallPut()
-	expiryDays := c.ExpiryDays(asof)
-	deliveryDays := expiryDays // temp
+	expiryYears := c.ExpiryYears(asof)
+	deliveryYears := expiryYears // temp
 
-	return optionImpliedVol(expiryDays, deliveryDays, strike, spotPrice, futPrice, optionPrice*spotPrice, cp)
+	vol, err := optionImpliedVol(ctx, expiryYears, deliveryYears, strike, spotPrice, futPrice, optionPrice*spotPrice, cp)
+	if err == ErrNoConvergence {
+		counter(MetricSolverFailures).Inc()
+		Log().Warnf("%s: implied vol did not converge for price %v spot %v future %v", c.Name(), optionPrice, spotPrice, futPrice)
+	}
+	if err != nil {
+		return vol, contractError(c.Name(), err)
+	}
+	return vol, nil
+}
+
+// validPrice is true for finite positive prices
+func validPrice(x float64) bool {
+	return x > 0 && !math.IsInf(x, 1)
+}
+
+// checkPricingInputs returns ErrInvalidInput unless the strike, spot and future prices are positive and finite
+// and the vol is finite and not negative
+func (c Contract) checkPricingInputs(spotPrice, futPrice, vol float64) error {
+	switch {
+	case !validPrice(c.strike):
+		return fmt.Errorf("%w: strike %v", ErrInvalidInput, c.strike)
+	case !validPrice(spotPrice):
+		return fmt.Errorf("%w: spot %v", ErrInvalidInput, spotPrice)
+	case !validPrice(futPrice):
+		return fmt.Errorf("%w: future %v", ErrInvalidInput, futPrice)
+	case math.IsNaN(vol) || math.IsInf(vol, 0) || vol < 0:
+		return fmt.Errorf("%w: vol %v", ErrInvalidInput, vol)
+	}
+	return nil
 }
 
-func (c Contract) OptPrice(asof time.Time, spotPrice, futPrice, vol float64) float64 {
+// OptPrice returns the price of the option in RHS coin value spot, or NaN and ErrNotAnOption for other contracts.
+// Returns NaN and ErrInvalidInput for non-positive prices or a negative vol. A zero vol gives the intrinsic value, as
+// does an asof at or after expiry where the forward is the settlement price (see GreeksAt)
+func (c Contract) OptPrice(asof time.Time, spotPrice, futPrice, vol float64) (float64, error) {
 	if c.IsOption() {
-		expiryDays := c.ExpiryDays(asof)
+		if err := c.checkPricingInputs(spotPrice, futPrice, vol); err != nil {
+			return math.NaN(), contractError(c.Name(), err)
+		}
+		expiryYears := c.ExpiryYears(asof)
 		strike := c.Strike()
 		cp := c.CallPut()
-		//		return (forwardOptionPrice(expiryDays, strike, futPrice, vol, cp)*spotPrice/futPrice - p.Price*spotPrice) * p.Qty
+		//		return (forwardOptionPrice(expiryYears, strike, futPrice, vol, cp)*spotPrice/futPrice - p.Price*spotPrice) * p.Qty
 		// deribit includes option price in the cash balance
-		return (forwardOptionPrice(expiryDays, strike, futPrice, vol, cp) * spotPrice / futPrice)
+		return (forwardOptionPrice(expiryYears, strike, futPrice, vol, cp) * spotPrice / futPrice), nil
 	} else {
-		return math.NaN()
+		return math.NaN(), contractError(c.Name(), ErrNotAnOption)
 	}
 }
 
 // Return the 'simple' delta computed analytically
 func (c Contract) SimpleDelta(asof time.Time, spotPrice, futPrice, vol float64) float64 {
-	expiryDays := c.ExpiryDays(asof)
+	expiryYears := c.ExpiryYears(asof)
+	if expiryYears <= 0 || vol <= 0 {
+		// intrinsic only
+		if c.callPut == Call && futPrice > c.strike {
+			return 1.0
+		}
+		if c.callPut == Put && futPrice < c.strike {
+			return -1.0
+		}
+		return 0.0
+	}
 	if c.callPut == Call {
-		return cumNormDist((math.Log(futPrice / c.strike)) / (vol * math.Sqrt(float64(expiryDays)/365.0)))
-		//		return cumNormDist((math.Log(futPrice/c.strike) + (vol*vol/2.0)*(float64(expiryDays)/365.0)) / (vol * math.Sqrt(float64(expiryDays)/365.0)))
+		return cumNormDist((math.Log(futPrice / c.strike)) / (vol * math.Sqrt(expiryYears)))
+		//		return cumNormDist((math.Log(futPrice/c.strike) + (vol*vol/2.0)*expiryYears) / (vol * math.Sqrt(expiryYears)))
 	} else { // put
-		return cumNormDist((math.Log(futPrice/c.strike))/(vol*math.Sqrt(float64(expiryDays)/365.0))) - 1.0
-		//		return cumNormDist((math.Log(futPrice/c.strike)+(vol*vol/2.0)*(float64(expiryDays)/365.0))/(vol*math.Sqrt(float64(expiryDays)/365.0))) - 1.0
+		return cumNormDist((math.Log(futPrice/c.strike))/(vol*math.Sqrt(expiryYears))) - 1.0
+		//		return cumNormDist((math.Log(futPrice/c.strike)+(vol*vol/2.0)*expiryYears)/(vol*math.Sqrt(expiryYears))) - 1.0
+	}
+}
+
+// Moneyness returns the log moneyness ln(forward/strike) of an option - positive for calls in the money,
+// NaN when the forward or the strike is not positive
+func (c Contract) Moneyness(forward float64) float64 {
+	if !c.IsOption() || forward <= 0 || c.strike <= 0 {
+		return math.NaN()
+	}
+	return math.Log(forward / c.strike)
+}
+
+// ProbITM returns the (risk neutral) probability that the option expires in the money, N(d2) for calls and N(-d2) for puts
+func (c Contract) ProbITM(asof time.Time, forward, vol float64) float64 {
+	if !c.IsOption() {
+		return math.NaN()
+	}
+	expiryYears := c.ExpiryYears(asof)
+	if expiryYears <= 0 || vol <= 0 || forward <= 0 || c.strike <= 0 {
+		// the outcome is known, or will be the current intrinsic - as in forwardOptionPrice a zero
+		// strike call is always in the money and a worthless underlying never finishes above the strike
+		if (c.callPut == Call && forward > c.strike) || (c.callPut == Put && forward < c.strike) {
+			return 1.0
+		}
+		return 0.0
+	}
+	_, d2 := d1d2(expiryYears, c.strike, forward, vol)
+	if c.callPut == Call {
+		return cumNormDist(d2)
+	}
+	return cumNormDist(-d2)
+}
+
+// ExpectedPayoff returns the undiscounted expected payoff at expiry in RHS coin (normally USD)
+func (c Contract) ExpectedPayoff(asof time.Time, forward, vol float64) float64 {
+	if !c.IsOption() {
+		return math.NaN()
+	}
+	return forwardOptionPrice(c.ExpiryYears(asof), c.strike, forward, vol, c.callPut)
+}
+
+// ExpectedPayoffITM returns the expected payoff in RHS coin given that the option expires in the money
+func (c Contract) ExpectedPayoffITM(asof time.Time, forward, vol float64) float64 {
+	prob := c.ProbITM(asof, forward, vol)
+	if prob == 0 {
+		return 0.0
 	}
+	return c.ExpectedPayoff(asof, forward, vol) / prob
 }
 
 // maths stuff now
 
+// WholeDayExpiry switches time to expiry back to the old behaviour of counting whole days between dates
+// (see DayDiff), so an option expiring later today is priced as expired and one expiring early tomorrow as if it
+// had a whole day left. Only kept for
+// reproducing historical results - leave false for fractional time to expiry from full timestamps.
+var WholeDayExpiry = false
+
 // DayDiff returns numbers of days from t1 to t2 after rounding
 func DayDiff(t1, t2 time.Time) int {
 	t1 = time.Date(t1.Year(), t1.Month(), t1.Day(), 0, 0, 0, 0, time.UTC) // remove time information and force to utc
@@ -520,60 +660,93 @@ func DayDiff(t1, t2 time.Time) int {
 }
 
 func (c Contract) ExpiryDays(now time.Time) float64 {
-	return c.Expiry().Sub(now).Hours() / 24.0 //DayDiff(now, c.Expiry())
+	if WholeDayExpiry {
+		return float64(DayDiff(now, c.Expiry()))
+	}
+	return c.Expiry().Sub(now).Hours() / 24.0
+}
+
+// ExpiryYears returns the time to expiry in years (365 day basis) used throughout the pricing functions
+func (c Contract) ExpiryYears(now time.Time) float64 {
+	return c.ExpiryDays(now) / 365.0
 }
 
 // premium expected in domestic - rhs coin value spot
-func optionImpliedVol(expiryDays, deliveryDays, strike, spot, forward, prm float64, callPut CallOrPut) (bs float64) {
+func optionImpliedVol(ctx context.Context, expiryYears, deliveryYears, strike, spot, forward, prm float64, callPut CallOrPut) (float64, error) {
 
-	if expiryDays <= 0 {
-		return math.NaN()
+	if expiryYears <= 0 {
+		return math.NaN(), ErrExpired
 	}
-	if expiryDays <= 0.1 {
-		expiryDays = 0.1
+	if expiryYears <= 0.1/365.0 {
+		expiryYears = 0.1 / 365.0
 	}
 
 	// if premium is less than intrinsic then return zero
-	floorPrm := spot / forward * forwardOptionPrice(expiryDays, strike, forward, 0.0, callPut)
+	floorPrm := spot / forward * forwardOptionPrice(expiryYears, strike, forward, 0.0, callPut)
 	if prm <= floorPrm {
-		return 0.0
+		return 0.0, nil
 	}
 
 	// newton raphson on vega and bs
-	//	guessVol := math.Sqrt(2.0*math.Pi/(float64(expiryDays)/365)) * prm / forward
+	//	guessVol := math.Sqrt(2.0*math.Pi/expiryYears) * prm / forward
 	guessVol := 1.0
 	for i := 0; i < 1000; i++ {
-		guessPrm := spot / forward * forwardOptionPrice(expiryDays, strike, forward, guessVol, callPut)
-		vega := optionVega(expiryDays, deliveryDays, strike, spot, forward, guessVol)
+		if err := ctx.Err(); err != nil {
+			return math.NaN(), err
+		}
+		guessPrm := spot / forward * forwardOptionPrice(expiryYears, strike, forward, guessVol, callPut)
+		vega := optionVega(expiryYears, deliveryYears, strike, spot, forward, guessVol)
 		vega = math.Max(vega, 0.00001*spot) // floor the vega at 1bp to avoid guesses flying off
 		guessVol = guessVol - (guessPrm-prm)/(vega*100.0)
 		guessVol = math.Max(guessVol, 0.0) // floor guess vol at zero
 		guessVol = math.Min(guessVol, 5.0) // cap guess vol at 500%
 		if math.Abs(guessPrm-prm)/forward < 0.00001 {
-			return guessVol
+			return guessVol, nil
 		}
 	}
-	return math.NaN()
+	return math.NaN(), ErrNoConvergence
 }
 
-func dF(days float64, rate float64) float64 {
-	return math.Exp(-days / 365 * rate)
+func dF(years float64, rate float64) float64 {
+	return math.Exp(-years * rate)
 }
 
-// in domestic - rhs coin forward value
-func forwardOptionPrice(expiryDays, strike, forward, vol float64, callPut CallOrPut) (prm float64) {
-	if expiryDays <= 0 {
-		vol = 0
+// in domestic - rhs coin forward value.
+// Degenerate inputs fall back to finite values rather than dividing by zero: with no time or vol left the option
+// is worth its intrinsic value, a non-positive strike makes the call worth the forward and the put nothing, and a
+// non-positive forward the call nothing and the put the strike. NaN inputs still give NaN
+func forwardOptionPrice(expiryYears, strike, forward, vol float64, callPut CallOrPut) (prm float64) {
+	switch {
+	case strike <= 0:
+		if callPut == Call {
+			return math.Max(forward, 0.0)
+		}
+		return 0.0
+	case forward <= 0:
+		if callPut == Call {
+			return 0.0
+		}
+		return strike
+	case expiryYears <= 0 || vol <= 0:
+		if callPut == Call {
+			return math.Max(forward-strike, 0.0)
+		}
+		return math.Max(strike-forward, 0.0)
 	}
 
-	d1 := (math.Log(forward/strike) + (vol*vol/2.0)*(expiryDays/365)) / (vol * math.Sqrt(expiryDays/365))
-	d2 := d1 - vol*math.Sqrt(float64(expiryDays)/365.0)
+	d1, d2 := d1d2(expiryYears, strike, forward, vol)
 
 	if callPut == Call {
 		prm = forward*cumNormDist(d1) - strike*cumNormDist(d2)
 	} else {
 		prm = -forward*cumNormDist(-d1) + strike*cumNormDist(-d2)
 	}
+	return math.Max(prm, 0.0) // rounding deep out of the money
+}
+
+func d1d2(expiryYears, strike, forward, vol float64) (d1, d2 float64) {
+	d1 = (math.Log(forward/strike) + (vol*vol/2.0)*expiryYears) / (vol * math.Sqrt(expiryYears))
+	d2 = d1 - vol*math.Sqrt(expiryYears)
 	return
 }
 
@@ -582,8 +755,40 @@ func cumNormDist(x float64) float64 {
 	return 0.5 * math.Erfc(-x/math.Sqrt2)
 }
 
-func optionVega(expiryDays, deliveryDays, strike, spot, forward, vol float64) float64 {
-	//	d1 := (math.Log(forward/strike) + (vol*vol/2.0)*(float64(expiryDays)/365)) / (vol * math.Sqrt(float64(expiryDays)/365))
-	//	return forward * cumNormDist(d1) * math.Sqrt(float64(expiryDays)/365.0) * dF(deliveryDays, domRate)
-	return spot / forward * (forwardOptionPrice(expiryDays, strike, forward, vol+0.005, Call) - forwardOptionPrice(expiryDays, strike, forward, vol-0.005, Call))
+func optionVega(expiryYears, deliveryYears, strike, spot, forward, vol float64) float64 {
+	//	d1 := (math.Log(forward/strike) + (vol*vol/2.0)*expiryYears) / (vol * math.Sqrt(expiryYears))
+	//	return forward * cumNormDist(d1) * math.Sqrt(expiryYears) * dF(deliveryYears, domRate)
+	return spot / forward * (forwardOptionPrice(expiryYears, strike, forward, vol+0.005, Call) - forwardOptionPrice(expiryYears, strike, forward, vol-0.005, Call))
+}
+
+// MarkMaxSpread is the widest bid/ask spread, relative to the mid, at which MarkFromBook uses the mid as the mark
+var MarkMaxSpread = 0.1
+
+// MarkFromBook returns a robust mark price of an option in LHS coin from its orderbook, in the way Deribit marks
+// options: the mid when the book is two-sided and tight (see MarkMaxSpread), otherwise the theoretical price at
+// fallbackVol bounded by the bid and ask that are present. The spot and forward are needed for the theoretical price
+func (c Contract) MarkFromBook(asof time.Time, spotPrice, futPrice float64, ob OrderBook, fallbackVol float64) (float64, error) {
+	if !c.IsOption() {
+		return math.NaN(), contractError(c.Name(), ErrNotAnOption)
+	}
+	bids, asks := ob.Bids(), ob.Asks()
+	if len(bids) > 0 && len(asks) > 0 {
+		bid, ask := bids[0].Price, asks[0].Price
+		mid := (bid + ask) / 2.0
+		if ask >= bid && ask-bid <= MarkMaxSpread*mid {
+			return mid, nil
+		}
+	}
+	theo, err := c.OptPrice(asof, spotPrice, futPrice, fallbackVol)
+	if err != nil {
+		return theo, err
+	}
+	mark := theo / spotPrice
+	if len(bids) > 0 {
+		mark = math.Max(mark, bids[0].Price)
+	}
+	if len(asks) > 0 {
+		mark = math.Min(mark, asks[0].Price)
+	}
+	return mark, nil
 }
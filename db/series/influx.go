@@ -0,0 +1,102 @@
+package series
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/influxdata/influxdb/client/v2"
+)
+
+// InfluxWriter writes points to an InfluxDB 1.x database with the vendored client, as db/mds does. InfluxDB 2.x
+// accepts it through its 1.x compatibility API, with the bucket as database and the token as password
+type InfluxWriter struct {
+	Database        string
+	RetentionPolicy string // empty for the default policy of the database
+
+	c client.Client
+}
+
+// NewInfluxWriter returns a writer to a database of the server at conf.Addr, e.g. http://localhost:8086
+func NewInfluxWriter(conf client.HTTPConfig, database string) (*InfluxWriter, error) {
+	c, err := client.NewHTTPClient(conf)
+	if err != nil {
+		return nil, err
+	}
+	return NewInfluxWriterClient(c, database), nil
+}
+
+// NewInfluxWriterClient returns a writer to a database with a client, such as those of db/influx.ConnectTo
+func NewInfluxWriterClient(c client.Client, database string) *InfluxWriter {
+	return &InfluxWriter{Database: database, c: c}
+}
+
+// Write writes the points in one batch. The client has no context: ctx is only checked before the write, bound
+// the write with the Timeout of the client
+func (w *InfluxWriter) Write(ctx context.Context, points []Point) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	bp, err := client.NewBatchPoints(client.BatchPointsConfig{
+		Database:        w.Database,
+		RetentionPolicy: w.RetentionPolicy,
+		Precision:       "ns",
+	})
+	if err != nil {
+		return err
+	}
+	for _, p := range points {
+		pt, err := influxPoint(p)
+		if err != nil {
+			return err
+		}
+		if pt != nil {
+			bp.AddPoint(pt)
+		}
+	}
+	if len(bp.Points()) == 0 {
+		return nil
+	}
+	if err := w.c.Write(bp); err != nil {
+		return fmt.Errorf("influx write: %w", err)
+	}
+	return nil
+}
+
+// Close closes the client
+func (w *InfluxWriter) Close() error {
+	return w.c.Close()
+}
+
+// influxPoint converts a point without its empty tags and NaN or infinite fields, which InfluxDB rejects. Points
+// without a finite field are nil
+func influxPoint(p Point) (*client.Point, error) {
+	keys := sortedKeys(p.Fields, func(v float64) bool { return !math.IsNaN(v) && !math.IsInf(v, 0) })
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	fields := make(map[string]interface{}, len(keys))
+	for _, k := range keys {
+		fields[k] = p.Fields[k]
+	}
+	tags := make(map[string]string, len(p.Tags))
+	for k, v := range p.Tags {
+		if v != "" {
+			tags[k] = v
+		}
+	}
+	return client.NewPoint(p.Measurement, tags, fields, p.Time)
+}
+
+// sortedKeys returns the keys of the fields whose values pass keep, sorted
+func sortedKeys(fields map[string]float64, keep func(float64) bool) []string {
+	keys := make([]string, 0, len(fields))
+	for k, v := range fields {
+		if keep(v) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,197 @@
+// Package series writes market data collected by bean (tickers, trades and top of book) as time series to
+// InfluxDB or TimescaleDB, batched and retried, for dashboards such as Grafana
+package series
+
+import (
+	"bean"
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// Measurement names written by the Sink
+const (
+	MeasurementTicker    = "ticker"
+	MeasurementTrade     = "trade"
+	MeasurementTopOfBook = "top_of_book"
+)
+
+// Point is one row of a series. NaN fields are not written
+type Point struct {
+	Measurement string
+	Tags        map[string]string
+	Fields      map[string]float64
+	Time        time.Time
+}
+
+// Writer writes a batch of points to a database
+type Writer interface {
+	Write(ctx context.Context, points []Point) error
+}
+
+// Sink batches points and writes them with a Writer, retrying failed batches. Points are flushed when a batch is
+// full and every flush interval while Run is running. Failed batches are kept for the next flush, up to
+// MaxBuffered points beyond which the oldest are dropped. It is safe for concurrent use
+type Sink struct {
+	BatchSize    int           // points per write
+	MaxRetries   int           // retries of a failed write before keeping the points for the next flush
+	RetryBackoff time.Duration // wait before the first retry, doubled on each retry
+	MaxBuffered  int           // points kept while the database is unavailable
+
+	w       Writer
+	m       sync.Mutex
+	buf     []Point
+	dropped int
+	flush   chan struct{}
+}
+
+// NewSink returns a sink writing batches of batchSize points with w
+func NewSink(w Writer, batchSize int) *Sink {
+	return &Sink{
+		BatchSize:    batchSize,
+		MaxRetries:   3,
+		RetryBackoff: 100 * time.Millisecond,
+		MaxBuffered:  100 * batchSize,
+		w:            w,
+		flush:        make(chan struct{}, 1),
+	}
+}
+
+// Add queues points, signalling Run to flush once a batch is full
+func (s *Sink) Add(points ...Point) {
+	s.m.Lock()
+	s.buf = append(s.buf, points...)
+	if over := len(s.buf) - s.MaxBuffered; s.MaxBuffered > 0 && over > 0 {
+		s.buf = s.buf[over:]
+		s.dropped += over
+	}
+	full := len(s.buf) >= s.BatchSize
+	s.m.Unlock()
+	if full {
+		select {
+		case s.flush <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Ticker queues the prices of a contract ticker, tagged by instrument
+func (s *Sink) Ticker(t bean.ContractTicker) {
+	s.Add(Point{
+		Measurement: MeasurementTicker,
+		Tags:        map[string]string{"instrument": t.Contract.Name()},
+		Fields: map[string]float64{
+			"bid": t.BestBid, "ask": t.BestAsk, "bid_amount": t.BestBidAmount, "ask_amount": t.BestAskAmount,
+			"last": t.LastPrice, "mark": t.MarkPrice, "index": t.IndexPrice, "open_interest": t.OpenInterest,
+			"volume": t.Volume24H},
+		Time: t.Time,
+	})
+}
+
+// Trades queues market trades, tagged by instrument and side of the taker
+func (s *Sink) Trades(instrument string, txns bean.Transactions) {
+	points := make([]Point, len(txns))
+	for i, t := range txns {
+		side := "buy"
+		if t.Maker == bean.Buyer {
+			side = "sell"
+		}
+		points[i] = Point{
+			Measurement: MeasurementTrade,
+			Tags:        map[string]string{"instrument": instrument, "side": side},
+			Fields:      map[string]float64{"price": t.Price, "amount": math.Abs(t.Amount)},
+			Time:        t.TimeStamp,
+		}
+	}
+	s.Add(points...)
+}
+
+// TopOfBook queues the best levels and mid of a book
+func (s *Sink) TopOfBook(instrument string, ob bean.OrderBookT) {
+	if ob.OrderBookCore == nil {
+		return
+	}
+	bid, ask := ob.BestBid(), ob.BestAsk()
+	_, _, mid := ob.BidAskMid()
+	s.Add(Point{
+		Measurement: MeasurementTopOfBook,
+		Tags:        map[string]string{"instrument": instrument},
+		Fields: map[string]float64{
+			"bid": bid.Price, "ask": ask.Price, "bid_amount": bid.Amount, "ask_amount": ask.Amount, "mid": mid},
+		Time: ob.Time,
+	})
+}
+
+// Pending returns the number of points waiting to be written
+func (s *Sink) Pending() int {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return len(s.buf)
+}
+
+// Dropped returns the number of points dropped as the buffer was full
+func (s *Sink) Dropped() int {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return s.dropped
+}
+
+// Flush writes the queued points in batches. A batch failing after the retries is put back in front of the queue
+// and its error returned
+func (s *Sink) Flush(ctx context.Context) error {
+	for {
+		s.m.Lock()
+		n := len(s.buf)
+		if s.BatchSize > 0 && n > s.BatchSize {
+			n = s.BatchSize
+		}
+		batch := append([]Point(nil), s.buf[:n]...)
+		s.buf = s.buf[n:]
+		s.m.Unlock()
+		if n == 0 {
+			return nil
+		}
+		if err := s.write(ctx, batch); err != nil {
+			s.m.Lock()
+			s.buf = append(batch, s.buf...)
+			s.m.Unlock()
+			return err
+		}
+	}
+}
+
+func (s *Sink) write(ctx context.Context, batch []Point) (err error) {
+	backoff := s.RetryBackoff
+	for i := 0; ; i++ {
+		if err = s.w.Write(ctx, batch); err == nil || i >= s.MaxRetries {
+			return
+		}
+		bean.Log().Warnf("series write failed, retry %d: %v", i+1, err)
+		t := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
+		}
+		backoff *= 2
+	}
+}
+
+// Run flushes every interval and whenever a batch is full until ctx is done, then flushes what is left
+func (s *Sink) Run(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return s.Flush(context.Background())
+		case <-ticker.C:
+		case <-s.flush:
+		}
+		if err := s.Flush(ctx); err != nil && ctx.Err() == nil {
+			bean.Log().Errorf("series flush failed, %d points pending: %v", s.Pending(), err)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package series
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"time"
+)
+
+var tableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// TimescaleWriter writes points to a TimescaleDB (postgres) hypertable in narrow form, one row per field with the
+// tags as JSON. No postgres driver is vendored: open db with one such as github.com/lib/pq
+type TimescaleWriter struct {
+	db    *sql.DB
+	table string
+}
+
+// NewTimescaleWriter returns a writer to table, creating it as a hypertable if needed
+func NewTimescaleWriter(ctx context.Context, db *sql.DB, table string) (*TimescaleWriter, error) {
+	if !tableName.MatchString(table) {
+		return nil, fmt.Errorf("invalid table name %q", table)
+	}
+	stmts := []string{
+		`CREATE TABLE IF NOT EXISTS ` + table + ` (
+			time TIMESTAMPTZ NOT NULL,
+			measurement TEXT NOT NULL,
+			tags JSONB NOT NULL,
+			field TEXT NOT NULL,
+			value DOUBLE PRECISION NOT NULL)`,
+		`SELECT create_hypertable('` + table + `', 'time', if_not_exists => TRUE)`,
+		`CREATE INDEX IF NOT EXISTS ` + table + `_measurement ON ` + table + ` (measurement, field, time DESC)`,
+	}
+	for _, s := range stmts {
+		if _, err := db.ExecContext(ctx, s); err != nil {
+			return nil, err
+		}
+	}
+	return &TimescaleWriter{db: db, table: table}, nil
+}
+
+// Write inserts the points in one transaction
+func (w *TimescaleWriter) Write(ctx context.Context, points []Point) error {
+	tx, err := w.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	stmt, err := tx.PrepareContext(ctx, `INSERT INTO `+w.table+` (time, measurement, tags, field, value) VALUES ($1, $2, $3, $4, $5)`)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+	for _, p := range points {
+		tags, err := json.Marshal(p.Tags)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		for _, k := range sortedKeys(p.Fields, func(v float64) bool { return !math.IsNaN(v) && !math.IsInf(v, 0) }) {
+			if _, err := stmt.ExecContext(ctx, p.Time.UTC().Format(time.RFC3339Nano), p.Measurement, string(tags), k, p.Fields[k]); err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,140 @@
+package store
+
+import (
+	"bean"
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+var _ bean.AuditLog = (*JSONLAudit)(nil)
+
+// JSONLAudit is a bean.AuditLog in a file of one JSON record per line, only ever appended to. Each record is
+// synced to disk before Append returns. A last line torn by a crash is cut off when the log is opened. It is safe
+// for concurrent use
+type JSONLAudit struct {
+	m    sync.Mutex
+	path string
+	f    *os.File
+	seq  int64
+}
+
+// OpenJSONLAudit opens or creates the audit log at path, numbering new records after the existing ones
+func OpenJSONLAudit(path string) (*JSONLAudit, error) {
+	a := &JSONLAudit{path: path}
+	if err := a.repair(); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	records, err := a.read(bean.AuditQuery{})
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if n := len(records); n > 0 {
+		a.seq = records[n-1].Seq
+	}
+	a.f, err = os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Append writes a record, numbering it
+func (a *JSONLAudit) Append(r bean.AuditRecord) error {
+	a.m.Lock()
+	defer a.m.Unlock()
+	r.Seq = a.seq + 1
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	if _, err := a.f.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	if err := a.f.Sync(); err != nil {
+		return err
+	}
+	a.seq = r.Seq
+	return nil
+}
+
+// repair truncates the file after its last complete record if the line after it is torn
+func (a *JSONLAudit) repair() error {
+	f, err := os.OpenFile(a.path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	rd := bufio.NewReader(f)
+	var good int64 // end of the last complete record
+	for {
+		line, err := rd.ReadBytes('\n')
+		if err == io.EOF {
+			if len(bytes.TrimSpace(line)) == 0 {
+				return nil
+			}
+			break // no newline: torn
+		}
+		if err != nil {
+			return err
+		}
+		var r bean.AuditRecord
+		if trimmed := bytes.TrimSpace(line); len(trimmed) > 0 && json.Unmarshal(trimmed, &r) != nil {
+			if _, err := rd.Peek(1); err != io.EOF {
+				return nil // corrupt within the log, reported by read
+			}
+			break
+		}
+		good += int64(len(line))
+	}
+	bean.Log().Warnf("audit: %s: cutting off a torn last record at byte %d", a.path, good)
+	if err := f.Truncate(good); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+func (a *JSONLAudit) Query(q bean.AuditQuery) ([]bean.AuditRecord, error) {
+	a.m.Lock()
+	defer a.m.Unlock()
+	return a.read(q)
+}
+
+// read returns the records of the file matching q
+func (a *JSONLAudit) read(q bean.AuditQuery) ([]bean.AuditRecord, error) {
+	f, err := os.Open(a.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var res []bean.AuditRecord
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; sc.Scan(); line++ {
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		var r bean.AuditRecord
+		if err := json.Unmarshal(sc.Bytes(), &r); err != nil {
+			return res, fmt.Errorf("%s line %d: %w", a.path, line, err)
+		}
+		if q.Match(r) {
+			res = append(res, r)
+		}
+	}
+	return res, sc.Err()
+}
+
+func (a *JSONLAudit) Close() error {
+	a.m.Lock()
+	defer a.m.Unlock()
+	if err := a.f.Sync(); err != nil {
+		a.f.Close()
+		return err
+	}
+	return a.f.Close()
+}
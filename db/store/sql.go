@@ -0,0 +1,226 @@
+package store
+
+import (
+	"bean"
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+const sqlSchema = `
+CREATE TABLE IF NOT EXISTS books (
+	instrument TEXT NOT NULL,
+	time INTEGER NOT NULL,
+	change_id INTEGER NOT NULL,
+	bids TEXT NOT NULL,
+	asks TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS books_time ON books (instrument, time);
+CREATE TABLE IF NOT EXISTS trades (
+	instrument TEXT NOT NULL,
+	time INTEGER NOT NULL,
+	coin TEXT NOT NULL,
+	base TEXT NOT NULL,
+	price REAL NOT NULL,
+	amount REAL NOT NULL,
+	maker INTEGER NOT NULL,
+	txn_id TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS trades_time ON trades (instrument, time);
+CREATE TABLE IF NOT EXISTS positions (
+	account TEXT NOT NULL,
+	time INTEGER NOT NULL,
+	contract TEXT NOT NULL,
+	qty REAL NOT NULL,
+	price REAL NOT NULL
+);
+CREATE INDEX IF NOT EXISTS positions_time ON positions (account, time);
+CREATE TABLE IF NOT EXISTS position_snapshots (
+	account TEXT NOT NULL,
+	time INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS position_snapshots_time ON position_snapshots (account, time);
+`
+
+var _ Storage = (*SQLStorage)(nil)
+
+// SQLStorage is a Storage in a SQL database, written for sqlite as opened by db/store/sqlite. Times are stored as
+// unix nanoseconds and book levels as JSON arrays of [price, amount]
+type SQLStorage struct {
+	db *sql.DB
+}
+
+// NewSQLStorage returns a storage in db, creating the tables if needed
+func NewSQLStorage(db *sql.DB) (*SQLStorage, error) {
+	if _, err := db.Exec(sqlSchema); err != nil {
+		return nil, err
+	}
+	return &SQLStorage{db: db}, nil
+}
+
+func levelsJSON(orders []bean.Order) (string, error) {
+	levels := make([][2]float64, len(orders))
+	for i, o := range orders {
+		levels[i] = [2]float64{o.Price, o.Amount}
+	}
+	b, err := json.Marshal(levels)
+	return string(b), err
+}
+
+func levelsFromJSON(s string) ([]bean.Order, error) {
+	var levels [][2]float64
+	if err := json.Unmarshal([]byte(s), &levels); err != nil {
+		return nil, err
+	}
+	orders := make([]bean.Order, len(levels))
+	for i, l := range levels {
+		orders[i] = bean.Order{Price: l[0], Amount: l[1]}
+	}
+	return orders, nil
+}
+
+func (s *SQLStorage) SaveBookSnapshot(instrument string, ob bean.OrderBookT) error {
+	var bids, asks []bean.Order
+	if ob.OrderBookCore != nil {
+		bids, asks = ob.Bids(), ob.Asks()
+	}
+	b, err := levelsJSON(bids)
+	if err != nil {
+		return err
+	}
+	a, err := levelsJSON(asks)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec("INSERT INTO books (instrument, time, change_id, bids, asks) VALUES (?, ?, ?, ?, ?)",
+		instrument, ob.Time.UnixNano(), ob.ChangeId, b, a)
+	return err
+}
+
+// SaveTrades saves the trades in one transaction
+func (s *SQLStorage) SaveTrades(instrument string, txns bean.Transactions) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	for _, t := range txns {
+		_, err = tx.Exec("INSERT INTO trades (instrument, time, coin, base, price, amount, maker, txn_id) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+			instrument, t.TimeStamp.UnixNano(), string(t.Pair.Coin), string(t.Pair.Base), t.Price, t.Amount, int(t.Maker), t.TxnID)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// SavePositions saves a snapshot of the positions of an account in one transaction, marked in position_snapshots
+// so that an empty one is loaded as such
+func (s *SQLStorage) SavePositions(account string, asof time.Time, positions []bean.Position) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	if _, err = tx.Exec("INSERT INTO position_snapshots (account, time) VALUES (?, ?)", account, asof.UnixNano()); err != nil {
+		tx.Rollback()
+		return err
+	}
+	for _, p := range positions {
+		_, err = tx.Exec("INSERT INTO positions (account, time, contract, qty, price) VALUES (?, ?, ?, ?, ?)",
+			account, asof.UnixNano(), p.Name(), p.Qty(), p.Price())
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *SQLStorage) LoadRange(instrument string, start, end time.Time) (r Range, err error) {
+	rows, err := s.db.Query("SELECT time, change_id, bids, asks FROM books WHERE instrument = ? AND time >= ? AND time < ? ORDER BY time",
+		instrument, start.UnixNano(), end.UnixNano())
+	if err != nil {
+		return
+	}
+	for rows.Next() {
+		var t int64
+		var ob bean.OrderBookT
+		var b, a string
+		if err = rows.Scan(&t, &ob.ChangeId, &b, &a); err != nil {
+			rows.Close()
+			return
+		}
+		var bids, asks []bean.Order
+		if bids, err = levelsFromJSON(b); err == nil {
+			asks, err = levelsFromJSON(a)
+		}
+		if err != nil {
+			rows.Close()
+			return
+		}
+		ob.OrderBook = bean.NewOrderBook(bids, asks)
+		ob.Time = time.Unix(0, t).UTC()
+		r.Books = append(r.Books, ob)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return
+	}
+
+	rows, err = s.db.Query("SELECT time, coin, base, price, amount, maker, txn_id FROM trades WHERE instrument = ? AND time >= ? AND time < ? ORDER BY time",
+		instrument, start.UnixNano(), end.UnixNano())
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var t int64
+		var tr bean.Transaction
+		var coin, base string
+		var maker int
+		if err = rows.Scan(&t, &coin, &base, &tr.Price, &tr.Amount, &maker, &tr.TxnID); err != nil {
+			return
+		}
+		tr.TimeStamp = time.Unix(0, t).UTC()
+		tr.Pair = bean.Pair{Coin: bean.Coin(coin), Base: bean.Coin(base)}
+		tr.Maker = bean.TraderType(maker)
+		r.Trades = append(r.Trades, tr)
+	}
+	err = rows.Err()
+	return
+}
+
+func (s *SQLStorage) LoadPositions(account string, asof time.Time) ([]bean.Position, time.Time, error) {
+	var t sql.NullInt64
+	// databases written before position_snapshots only have the rows of the positions
+	err := s.db.QueryRow(`SELECT MAX(time) FROM (
+		SELECT time FROM position_snapshots WHERE account = ? AND time <= ?
+		UNION ALL SELECT time FROM positions WHERE account = ? AND time <= ?)`,
+		account, asof.UnixNano(), account, asof.UnixNano()).Scan(&t)
+	if err != nil || !t.Valid {
+		return nil, time.Time{}, err
+	}
+	rows, err := s.db.Query("SELECT contract, qty, price FROM positions WHERE account = ? AND time = ?", account, t.Int64)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	defer rows.Close()
+	var res []bean.Position
+	for rows.Next() {
+		var name string
+		var qty, price float64
+		if err := rows.Scan(&name, &qty, &price); err != nil {
+			return nil, time.Time{}, err
+		}
+		c, err := bean.ContractFromName(name)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		res = append(res, bean.NewPosition(c, qty, price))
+	}
+	return res, time.Unix(0, t.Int64).UTC(), rows.Err()
+}
+
+func (s *SQLStorage) Close() error {
+	return s.db.Close()
+}
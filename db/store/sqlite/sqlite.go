@@ -0,0 +1,30 @@
+// Package sqlite opens a store.SQLStorage in a sqlite database with the vendored github.com/mattn/go-sqlite3,
+// which needs cgo. Packages only using store.Storage do not link it
+package sqlite
+
+import (
+	"bean/db/store"
+	"database/sql"
+
+	_ "github.com/mattn/go-sqlite3" // registers sqlite3
+)
+
+// Driver is the database/sql driver name Open uses, that of github.com/mattn/go-sqlite3 unless set to another
+// sqlite driver
+var Driver = "sqlite3"
+
+// Open opens or creates the sqlite database at path, ":memory:" for an in memory database
+func Open(path string) (*store.SQLStorage, error) {
+	db, err := sql.Open(Driver, path)
+	if err != nil {
+		return nil, err
+	}
+	if path == ":memory:" {
+		db.SetMaxOpenConns(1) // each connection would get its own database
+	}
+	s, err := store.NewSQLStorage(db)
+	if err != nil {
+		db.Close()
+	}
+	return s, err
+}
@@ -0,0 +1,117 @@
+// Package store is the persistence layer for recorders, blotters and backtests: book snapshots, market trades and
+// position snapshots behind a Storage interface, kept in memory or in a SQL database
+package store
+
+import (
+	"bean"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Storage saves and loads market data and positions. Instruments are contract or pair names, accounts any name
+type Storage interface {
+	SaveBookSnapshot(instrument string, ob bean.OrderBookT) error
+	SaveTrades(instrument string, txns bean.Transactions) error
+	// SavePositions saves a snapshot of the positions of an account. An empty snapshot is recorded too, as the
+	// account having no positions from then on
+	SavePositions(account string, asof time.Time, positions []bean.Position) error
+	// LoadRange returns the books and trades of an instrument from start included to end excluded, in time order
+	LoadRange(instrument string, start, end time.Time) (Range, error)
+	// LoadPositions returns the last positions of an account saved at or before asof and their time
+	LoadPositions(account string, asof time.Time) ([]bean.Position, time.Time, error)
+	Close() error
+}
+
+// Range is the market data of an instrument over a time range
+type Range struct {
+	Books  bean.OrderBookTS
+	Trades bean.Transactions
+}
+
+type positionSnapshot struct {
+	time      time.Time
+	positions []bean.Position
+}
+
+// MemStorage is a Storage kept in memory, for tests and backtests. It is safe for concurrent use
+type MemStorage struct {
+	m         sync.RWMutex
+	books     map[string]bean.OrderBookTS
+	trades    map[string]bean.Transactions
+	positions map[string][]positionSnapshot
+}
+
+func NewMemStorage() *MemStorage {
+	return &MemStorage{
+		books:     make(map[string]bean.OrderBookTS),
+		trades:    make(map[string]bean.Transactions),
+		positions: make(map[string][]positionSnapshot),
+	}
+}
+
+// SaveBookSnapshot saves a copy of the book, so later updates of ob are not seen
+func (s *MemStorage) SaveBookSnapshot(instrument string, ob bean.OrderBookT) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	ob.OrderBook = ob.Clone()
+	s.books[instrument] = append(s.books[instrument], ob)
+	return nil
+}
+
+func (s *MemStorage) SaveTrades(instrument string, txns bean.Transactions) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.trades[instrument] = append(s.trades[instrument], txns...)
+	return nil
+}
+
+func (s *MemStorage) SavePositions(account string, asof time.Time, positions []bean.Position) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	snap := positionSnapshot{time: asof, positions: append([]bean.Position(nil), positions...)}
+	s.positions[account] = append(s.positions[account], snap)
+	return nil
+}
+
+func (s *MemStorage) LoadRange(instrument string, start, end time.Time) (Range, error) {
+	s.m.RLock()
+	defer s.m.RUnlock()
+	var r Range
+	for _, ob := range s.books[instrument] {
+		if inRange(ob.Time, start, end) {
+			r.Books = append(r.Books, ob)
+		}
+	}
+	for _, t := range s.trades[instrument] {
+		if inRange(t.TimeStamp, start, end) {
+			r.Trades = append(r.Trades, t)
+		}
+	}
+	sort.SliceStable(r.Books, func(i, j int) bool { return r.Books[i].Time.Before(r.Books[j].Time) })
+	sort.SliceStable(r.Trades, func(i, j int) bool { return r.Trades[i].TimeStamp.Before(r.Trades[j].TimeStamp) })
+	return r, nil
+}
+
+func (s *MemStorage) LoadPositions(account string, asof time.Time) ([]bean.Position, time.Time, error) {
+	s.m.RLock()
+	defer s.m.RUnlock()
+	var last *positionSnapshot
+	for i, snap := range s.positions[account] {
+		if !snap.time.After(asof) && (last == nil || !snap.time.Before(last.time)) {
+			last = &s.positions[account][i]
+		}
+	}
+	if last == nil {
+		return nil, time.Time{}, nil
+	}
+	return append([]bean.Position(nil), last.positions...), last.time, nil
+}
+
+func (s *MemStorage) Close() error {
+	return nil
+}
+
+func inRange(t, start, end time.Time) bool {
+	return !t.Before(start) && t.Before(end)
+}
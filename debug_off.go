@@ -0,0 +1,6 @@
+//go:build !beandebug
+
+package bean
+
+// debugBooks enables the invariant checks of books after each update, see the beandebug build tag
+const debugBooks = false
@@ -0,0 +1,6 @@
+//go:build beandebug
+
+package bean
+
+// debugBooks enables the invariant checks of books after each update
+const debugBooks = true
@@ -0,0 +1,81 @@
+package bean
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+)
+
+// DepthProfile holds the cumulative size of each side of a book by price, from the best price outwards,
+// as plotted in depth charts
+type DepthProfile struct {
+	Mid       float64   `json:"mid"`
+	BidPrices []float64 `json:"bidPrices"`
+	BidSizes  []float64 `json:"bidSizes"` // cumulative
+	AskPrices []float64 `json:"askPrices"`
+	AskSizes  []float64 `json:"askSizes"` // cumulative
+}
+
+// DepthProfile returns the cumulative depth of up to maxLevels levels each side, all levels if maxLevels <= 0
+func (ob OrderBook) DepthProfile(maxLevels int) DepthProfile {
+	return ob.depthProfile(func(i int, price float64) bool {
+		return maxLevels <= 0 || i < maxLevels
+	})
+}
+
+// DepthProfileRange returns the cumulative depth of the levels within priceRange of the mid (0.02 for 2%).
+// The profile is empty for books without a mid
+func (ob OrderBook) DepthProfileRange(priceRange float64) DepthProfile {
+	mid := ob.Mid()
+	return ob.depthProfile(func(i int, price float64) bool {
+		return price >= mid*(1-priceRange) && price <= mid*(1+priceRange)
+	})
+}
+
+func (ob OrderBook) depthProfile(include func(level int, price float64) bool) (d DepthProfile) {
+	if ob.OrderBookCore == nil {
+		return
+	}
+	if ob.Valid() {
+		d.Mid = ob.Mid() // left at zero for one sided books as JSON has no NaN
+	}
+	cum := 0.0
+	for i, o := range ob.Bids() {
+		if !include(i, o.Price) {
+			break
+		}
+		cum += o.Amount
+		d.BidPrices = append(d.BidPrices, o.Price)
+		d.BidSizes = append(d.BidSizes, cum)
+	}
+	cum = 0.0
+	for i, o := range ob.Asks() {
+		if !include(i, o.Price) {
+			break
+		}
+		cum += o.Amount
+		d.AskPrices = append(d.AskPrices, o.Price)
+		d.AskSizes = append(d.AskSizes, cum)
+	}
+	return
+}
+
+// WriteCSV writes the profile with a header and one row per level: side, price, cumulative size
+func (d DepthProfile) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	rows := [][]string{{"Side", "Price", "CumSize"}}
+	format := func(x float64) string { return strconv.FormatFloat(x, 'f', -1, 64) }
+	for i := range d.BidPrices {
+		rows = append(rows, []string{"BID", format(d.BidPrices[i]), format(d.BidSizes[i])})
+	}
+	for i := range d.AskPrices {
+		rows = append(rows, []string{"ASK", format(d.AskPrices[i]), format(d.AskSizes[i])})
+	}
+	return cw.WriteAll(rows)
+}
+
+// WriteJSON writes the profile as a JSON object
+func (d DepthProfile) WriteJSON(w io.Writer) error {
+	return json.NewEncoder(w).Encode(d)
+}
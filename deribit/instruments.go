@@ -0,0 +1,201 @@
+// Package deribit pulls reference data from the deribit public REST API and trades with an API key over REST
+// and websocket
+package deribit
+
+import (
+	"bean"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultURL is the base url of the production API
+const DefaultURL = "https://www.deribit.com/api/v2"
+
+// Instrument is the reference data of a listed contract. TickSize is in the quote currency (LHS coin for inverse
+// contracts), ContractSize is the multiplier (USD per future contract, coin per option) and MinTradeAmount is in
+// contracts
+type Instrument struct {
+	Contract           *bean.Contract
+	Kind               string // future or option
+	TickSize           float64
+	ContractSize       float64
+	MinTradeAmount     float64
+	SettlementCurrency bean.Coin
+	QuoteCurrency      bean.Coin
+	Active             bool
+}
+
+// Spec returns the trading rules of the instrument in bean units, amounts in contracts
+func (i Instrument) Spec() bean.InstrumentSpec {
+	lot := i.MinTradeAmount
+	if i.ContractSize > 0 {
+		lot /= i.ContractSize
+	}
+	s := bean.InstrumentSpec{TickSize: i.TickSize, LotSize: lot, MinAmount: lot}
+	if !i.Contract.IsOption() {
+		s.Multiplier = i.ContractSize
+	}
+	return s
+}
+
+// instrument is the get_instruments result entry
+type instrument struct {
+	InstrumentName     string  `json:"instrument_name"`
+	Kind               string  `json:"kind"`
+	TickSize           float64 `json:"tick_size"`
+	ContractSize       float64 `json:"contract_size"`
+	MinTradeAmount     float64 `json:"min_trade_amount"`
+	SettlementCurrency string  `json:"settlement_currency"`
+	QuoteCurrency      string  `json:"quote_currency"`
+	IsActive           bool    `json:"is_active"`
+}
+
+type response struct {
+	Result []instrument `json:"result"`
+	Error  *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// InstrumentClient fetches the listed instruments and keeps the last set fetched
+type InstrumentClient struct {
+	BaseURL string
+	HTTP    *http.Client
+
+	m           sync.RWMutex
+	instruments map[string]Instrument
+	updated     time.Time
+}
+
+// NewInstrumentClient returns a client of the production API
+func NewInstrumentClient() *InstrumentClient {
+	return &InstrumentClient{
+		BaseURL:     DefaultURL,
+		HTTP:        &http.Client{Timeout: 10 * time.Second},
+		instruments: make(map[string]Instrument),
+	}
+}
+
+// Fetch returns the active instruments of a currency (BTC, ETH) and kind (future, option, or empty for both).
+// Instruments that are not bean contracts, such as combos and linear contracts, are skipped
+func (c *InstrumentClient) Fetch(ctx context.Context, currency, kind string) ([]Instrument, error) {
+	q := url.Values{"currency": {currency}, "expired": {"false"}}
+	if kind != "" {
+		q.Set("kind", kind)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.BaseURL+"/public/get_instruments?"+q.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var r response
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return nil, fmt.Errorf("deribit get_instruments: %w", err)
+	}
+	if r.Error != nil {
+		return nil, fmt.Errorf("deribit get_instruments: %d %s", r.Error.Code, r.Error.Message)
+	}
+	res := make([]Instrument, 0, len(r.Result))
+	for _, i := range r.Result {
+		con, err := bean.ContractFromName(i.InstrumentName)
+		if err != nil {
+			bean.Log().Debugf("deribit: skipping instrument %s: %v", i.InstrumentName, err)
+			continue
+		}
+		res = append(res, Instrument{
+			Contract:           con,
+			Kind:               i.Kind,
+			TickSize:           i.TickSize,
+			ContractSize:       i.ContractSize,
+			MinTradeAmount:     i.MinTradeAmount,
+			SettlementCurrency: bean.Coin(i.SettlementCurrency),
+			QuoteCurrency:      bean.Coin(i.QuoteCurrency),
+			Active:             i.IsActive,
+		})
+	}
+	return res, nil
+}
+
+// Refresh fetches the instruments of the currencies and replaces the ones held. Nothing is replaced on error
+func (c *InstrumentClient) Refresh(ctx context.Context, currencies ...string) error {
+	next := make(map[string]Instrument)
+	for _, cur := range currencies {
+		res, err := c.Fetch(ctx, cur, "")
+		if err != nil {
+			return err
+		}
+		for _, i := range res {
+			next[i.Contract.Name()] = i
+		}
+	}
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.instruments = next
+	c.updated = time.Now()
+	return nil
+}
+
+// Register registers the specs of the instruments held with bean.SetInstrumentSpec, so positions, margins and
+// fills use the listed contract multipliers
+func (c *InstrumentClient) Register() {
+	c.m.RLock()
+	defer c.m.RUnlock()
+	for name, i := range c.instruments {
+		bean.SetInstrumentSpec(name, i.Spec())
+	}
+}
+
+// Run refreshes the instruments every interval until the context is done. Failed refreshes are logged and the
+// previous instruments kept
+func (c *InstrumentClient) Run(ctx context.Context, interval time.Duration, currencies ...string) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := c.Refresh(ctx, currencies...); err != nil && ctx.Err() == nil {
+			bean.Log().Warnf("deribit: instrument refresh failed: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Instrument returns the instrument of a contract name
+func (c *InstrumentClient) Instrument(name string) (Instrument, bool) {
+	c.m.RLock()
+	defer c.m.RUnlock()
+	i, ok := c.instruments[name]
+	return i, ok
+}
+
+// Instruments returns all instruments held, sorted by contract
+func (c *InstrumentClient) Instruments() []Instrument {
+	c.m.RLock()
+	defer c.m.RUnlock()
+	res := make([]Instrument, 0, len(c.instruments))
+	for _, i := range c.instruments {
+		res = append(res, i)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Contract.Before(res[j].Contract) })
+	return res
+}
+
+// Updated returns the time of the last successful refresh
+func (c *InstrumentClient) Updated() time.Time {
+	c.m.RLock()
+	defer c.m.RUnlock()
+	return c.updated
+}
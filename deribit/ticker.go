@@ -0,0 +1,71 @@
+package deribit
+
+import (
+	"bean"
+	"encoding/json"
+	"math"
+	"time"
+)
+
+// ticker is the data of the ticker.{instrument}.{interval} channel and of public/ticker. Amounts are USD for
+// futures and coins for options, prices are null when not quoted
+type ticker struct {
+	InstrumentName string   `json:"instrument_name"`
+	Timestamp      int64    `json:"timestamp"` // ms
+	BestBidPrice   *float64 `json:"best_bid_price"`
+	BestAskPrice   *float64 `json:"best_ask_price"`
+	BestBidAmount  float64  `json:"best_bid_amount"`
+	BestAskAmount  float64  `json:"best_ask_amount"`
+	LastPrice      *float64 `json:"last_price"`
+	MarkPrice      *float64 `json:"mark_price"`
+	IndexPrice     *float64 `json:"index_price"`
+	OpenInterest   *float64 `json:"open_interest"`
+	Stats          struct {
+		Volume    *float64 `json:"volume"`     // coins
+		VolumeUSD *float64 `json:"volume_usd"` // futures only
+	} `json:"stats"`
+}
+
+func orNaN(x *float64) float64 {
+	if x == nil {
+		return math.NaN()
+	}
+	return *x
+}
+
+// ParseTicker parses the data of a ticker notification into a ContractTicker in contracts, prices not quoted
+// being NaN. Feed it to a bean.TickerRecorder for the open interest and volume series
+func ParseTicker(data []byte) (bean.ContractTicker, error) {
+	var t ticker
+	if err := json.Unmarshal(data, &t); err != nil {
+		return bean.ContractTicker{}, err
+	}
+	c, err := bean.ContractFromName(t.InstrumentName)
+	if err != nil {
+		return bean.ContractTicker{}, err
+	}
+	mult := c.Multiplier()
+	res := bean.ContractTicker{
+		Contract:      c,
+		BestBid:       orNaN(t.BestBidPrice),
+		BestAsk:       orNaN(t.BestAskPrice),
+		BestBidAmount: t.BestBidAmount / mult,
+		BestAskAmount: t.BestAskAmount / mult,
+		LastPrice:     orNaN(t.LastPrice),
+		MarkPrice:     orNaN(t.MarkPrice),
+		IndexPrice:    orNaN(t.IndexPrice),
+		OpenInterest:  orNaN(t.OpenInterest) / mult,
+		Volume24H:     orNaN(t.Stats.Volume),
+		Time:          time.Unix(0, t.Timestamp*int64(time.Millisecond)).UTC(),
+	}
+	if !c.IsOption() {
+		res.Volume24H = orNaN(t.Stats.VolumeUSD) / mult
+	}
+	if res.BestBid == 0 {
+		res.BestBid = math.NaN()
+	}
+	if res.BestAsk == 0 {
+		res.BestAsk = math.NaN()
+	}
+	return res, nil
+}
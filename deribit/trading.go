@@ -0,0 +1,896 @@
+package deribit
+
+import (
+	"bean"
+	"bean/event"
+	"bean/internal/ws"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultWSURL is the websocket endpoint of the production API
+const DefaultWSURL = "wss://www.deribit.com/ws/api/v2"
+
+// OrdersChannel is the subscription of the updates of all our orders
+const OrdersChannel = "user.orders.any.any.raw"
+
+// TradesChannel is the subscription of the fills of all our orders
+const TradesChannel = "user.trades.any.any.raw"
+
+// APIError is an error answered by the API
+type APIError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("deribit error %d: %s", e.Code, e.Message)
+}
+
+type rpcRequest struct {
+	JSONRPC string      `json:"jsonrpc"`
+	ID      int64       `json:"id"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params,omitempty"`
+}
+
+type rpcMessage struct {
+	ID     int64           `json:"id"`
+	Result json.RawMessage `json:"result"`
+	Error  *APIError       `json:"error"`
+	Method string          `json:"method"` // of notifications
+	Params struct {
+		Channel string          `json:"channel"`
+		Data    json.RawMessage `json:"data"`
+		Type    string          `json:"type"` // of heartbeats
+	} `json:"params"`
+}
+
+type authResult struct {
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int64  `json:"expires_in"` // seconds
+}
+
+// order is an order of the private API. Amounts are USD for futures and coins for options
+type order struct {
+	OrderID        string  `json:"order_id"`
+	InstrumentName string  `json:"instrument_name"`
+	Direction      string  `json:"direction"`
+	Price          float64 `json:"price"`
+	Amount         float64 `json:"amount"`
+	FilledAmount   float64 `json:"filled_amount"`
+	AveragePrice   float64 `json:"average_price"`
+	OrderState     string  `json:"order_state"`
+	Label          string  `json:"label"`
+	Commission     float64 `json:"commission"`
+	Created        int64   `json:"creation_timestamp"`    // ms
+	Updated        int64   `json:"last_update_timestamp"` // ms
+}
+
+// trade is a fill of one of our orders
+type trade struct {
+	TradeID        string  `json:"trade_id"`
+	OrderID        string  `json:"order_id"`
+	InstrumentName string  `json:"instrument_name"`
+	Direction      string  `json:"direction"`
+	Price          float64 `json:"price"`
+	Amount         float64 `json:"amount"`
+	Fee            float64 `json:"fee"`
+	FeeCurrency    string  `json:"fee_currency"`
+	Liquidity      string  `json:"liquidity"` // M or T
+	Label          string  `json:"label"`
+	Timestamp      int64   `json:"timestamp"` // ms
+}
+
+type orderResult struct {
+	Order order `json:"order"`
+}
+
+type position struct {
+	InstrumentName string  `json:"instrument_name"`
+	Size           float64 `json:"size"` // signed, USD for futures and coins for options
+	AveragePrice   float64 `json:"average_price"`
+}
+
+// AccountSummary is the account of a currency, in that currency
+type AccountSummary struct {
+	Currency          string  `json:"currency"`
+	Balance           float64 `json:"balance"`
+	Equity            float64 `json:"equity"`
+	AvailableFunds    float64 `json:"available_funds"`
+	InitialMargin     float64 `json:"initial_margin"`
+	MaintenanceMargin float64 `json:"maintenance_margin"`
+	TotalPL           float64 `json:"total_pl"`
+	DeltaTotal        float64 `json:"delta_total"`
+}
+
+// maxClosed is the number of closed orders kept by client id for the retries of their placement
+const maxClosed = 1000
+
+// lookupTimeout bounds the lookup by label of a placement failed in flight
+const lookupTimeout = 5 * time.Second
+
+// TradingClient trades with an API key: orders are placed, cancelled and queried over REST and their updates
+// streamed over a websocket, amounts being in bean contracts. Orders are labelled with client ids so that a
+// request failing in flight can be retried without placing the order twice. With SetAudit, the order updates and
+// fills are recorded in an audit log as they are received. It is safe for concurrent use
+type TradingClient struct {
+	BaseURL      string
+	WSURL        string
+	HTTP         *http.Client
+	ClientID     string
+	ClientSecret string
+
+	ReconnectDelay time.Duration     // first wait before reconnecting the stream, doubled up to a minute
+	Heartbeat      time.Duration     // interval of the stream heartbeats, zero for none
+	Limiter        *bean.RateLimiter // credits of the requests, waited for before each, nil for none
+	Clock          bean.Clock        // time of the token expiries and the states, the system clock if nil
+
+	m        sync.Mutex
+	token    string
+	expires  time.Time
+	placed   map[string]bean.OrderStatus // by client id, live and the last maxClosed closed
+	closed   []string                    // client ids of the closed orders of placed, oldest first
+	placing  map[string]chan struct{}    // client ids being placed, closed once done
+	unknown  map[string]struct{}         // client ids of placements failed in flight, looked up before a retry
+	open     map[string]bean.OrderStatus // live orders by order id
+	channels map[string]struct{}
+	conn     *ws.Conn // of the stream, nil when disconnected
+	handler  func(channel string, data json.RawMessage)
+	audit    bean.Auditor
+
+	id    int64
+	label int64
+	start int64
+}
+
+// NewTradingClient returns a client of the production API with an API key
+func NewTradingClient(clientID, clientSecret string) *TradingClient {
+	limiter, _ := bean.NewExchangeRateLimiter(bean.NameDeribit)
+	return &TradingClient{
+		BaseURL:        DefaultURL,
+		WSURL:          DefaultWSURL,
+		HTTP:           &http.Client{Timeout: 10 * time.Second},
+		ClientID:       clientID,
+		ClientSecret:   clientSecret,
+		ReconnectDelay: time.Second,
+		Heartbeat:      30 * time.Second,
+		Limiter:        limiter,
+		placed:         make(map[string]bean.OrderStatus),
+		placing:        make(map[string]chan struct{}),
+		unknown:        make(map[string]struct{}),
+		open:           make(map[string]bean.OrderStatus),
+		channels:       map[string]struct{}{OrdersChannel: {}, TradesChannel: {}},
+		start:          time.Now().Unix(),
+	}
+}
+
+// SetAudit sets an audit log recording the order updates and fills
+func (c *TradingClient) SetAudit(a bean.AuditLog) {
+	c.audit.SetLog(a)
+}
+
+// AuditErr returns the first failure to record in the audit log, after which orders are refused
+func (c *TradingClient) AuditErr() error {
+	return c.audit.Err()
+}
+
+// NewClientID returns a client id not used before by the client, to label an order
+func (c *TradingClient) NewClientID() string {
+	return fmt.Sprintf("bean-%d-%d", c.start, atomic.AddInt64(&c.label, 1))
+}
+
+func (c *TradingClient) now() time.Time {
+	if c.Clock == nil {
+		return bean.RealClock.Now()
+	}
+	return c.Clock.Now()
+}
+
+func (c *TradingClient) request(method string, params interface{}) rpcRequest {
+	return rpcRequest{JSONRPC: "2.0", ID: atomic.AddInt64(&c.id, 1), Method: method, Params: params}
+}
+
+func (c *TradingClient) authParams() map[string]interface{} {
+	return map[string]interface{}{"grant_type": "client_credentials", "client_id": c.ClientID,
+		"client_secret": c.ClientSecret}
+}
+
+// accessToken returns a token valid for a minute at least, authenticating if needed
+func (c *TradingClient) accessToken(ctx context.Context) (string, error) {
+	c.m.Lock()
+	token, expires := c.token, c.expires
+	c.m.Unlock()
+	if token != "" && expires.Sub(c.now()) > time.Minute {
+		return token, nil
+	}
+	var res authResult
+	if err := c.post(ctx, "", "public/auth", c.authParams(), &res); err != nil {
+		return "", fmt.Errorf("deribit auth: %w", err)
+	}
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.token, c.expires = res.AccessToken, c.now().Add(time.Duration(res.ExpiresIn)*time.Second)
+	return c.token, nil
+}
+
+func (c *TradingClient) post(ctx context.Context, token, method string, params, result interface{}) error {
+	if c.Limiter != nil {
+		if err := c.Limiter.Wait(ctx, bean.DeribitRequestCost); err != nil {
+			return fmt.Errorf("deribit %s: %w", method, err)
+		}
+	}
+	body, err := json.Marshal(c.request(method, params))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.BaseURL+"/"+method, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var msg rpcMessage
+	if err := json.NewDecoder(resp.Body).Decode(&msg); err != nil {
+		return fmt.Errorf("deribit %s: %s: %w", method, resp.Status, err)
+	}
+	if msg.Error != nil {
+		return msg.Error
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(msg.Result, result)
+}
+
+// call sends an authenticated request
+func (c *TradingClient) call(ctx context.Context, method string, params, result interface{}) error {
+	token, err := c.accessToken(ctx)
+	if err != nil {
+		return err
+	}
+	return c.post(ctx, token, method, params, result)
+}
+
+func currency(c *bean.Contract) string {
+	return string(c.Underlying().Coin)
+}
+
+// toStatus maps an order of the API onto an OrderStatus in contracts
+func toStatus(o order) bean.OrderStatus {
+	mult := 1.0
+	if c, err := bean.ContractFromName(o.InstrumentName); err == nil {
+		mult = c.Multiplier()
+	}
+	s := bean.OrderStatus{
+		OrderID:         o.OrderID,
+		PlacedTime:      time.Unix(0, o.Created*int64(time.Millisecond)).UTC(),
+		Side:            bean.BUY,
+		Instrument:      o.InstrumentName,
+		FilledAmount:    o.FilledAmount / mult,
+		LeftAmount:      (o.Amount - o.FilledAmount) / mult,
+		PlacedPrice:     o.Price,
+		Price:           o.Price,
+		Commission:      o.Commission,
+		CommissionAsset: bean.Coin(strings.SplitN(o.InstrumentName, "-", 2)[0]),
+	}
+	if o.Direction == "sell" {
+		s.Side = bean.SELL
+	}
+	if o.FilledAmount > 0 && o.AveragePrice > 0 {
+		s.Price = o.AveragePrice
+	}
+	switch {
+	case o.OrderState == "filled":
+		s.State = bean.FILLED
+	case o.OrderState == "cancelled":
+		s.State = bean.CANCELLED
+	case o.OrderState == "rejected":
+		s.State = bean.REJECTED
+	case o.FilledAmount > 0:
+		s.State = bean.PARTIAL
+	default:
+		s.State = bean.ALIVE
+	}
+	return s
+}
+
+// toExecution maps a trade of the API onto an ExecutionReport in contracts
+func toExecution(t trade) bean.ExecutionReport {
+	r := bean.ExecutionReport{
+		Exchange:   bean.NameDeribit,
+		OrderID:    t.OrderID,
+		ClientID:   t.Label,
+		ExecID:     t.TradeID,
+		Instrument: t.InstrumentName,
+		Side:       bean.BUY,
+		Price:      t.Price,
+		Qty:        t.Amount,
+		Fee:        t.Fee,
+		FeeAsset:   bean.Coin(t.FeeCurrency),
+		Liquidity:  bean.LiquidityTaker,
+		Time:       time.Unix(0, t.Timestamp*int64(time.Millisecond)).UTC(),
+	}
+	if c, err := bean.ContractFromName(t.InstrumentName); err == nil {
+		r.Pair = c.Underlying()
+		r.Qty /= c.Multiplier()
+	}
+	if t.Direction == "sell" {
+		r.Side = bean.SELL
+	}
+	if t.Liquidity == "M" {
+		r.Liquidity = bean.LiquidityMaker
+	}
+	return r
+}
+
+// orderTime returns the time of the last update of an order
+func orderTime(o order) time.Time {
+	ms := o.Updated
+	if ms == 0 {
+		ms = o.Created
+	}
+	return time.Unix(0, ms*int64(time.Millisecond)).UTC()
+}
+
+func live(s bean.OrderStatus) bool {
+	return s.State == bean.ALIVE || s.State == bean.PARTIAL
+}
+
+// track records and returns the last status of an order, auditing it if it changed
+func (c *TradingClient) track(o order) bean.OrderStatus {
+	s := toStatus(o)
+	c.m.Lock()
+	defer c.m.Unlock()
+	last, known := c.open[s.OrderID]
+	if !known && o.Label != "" {
+		last, known = c.placed[o.Label]
+	}
+	if !known || last != s {
+		c.audit.Record(bean.OrderRecord(orderTime(o), s))
+	}
+	if o.Label != "" {
+		prev, seen := c.placed[o.Label]
+		c.placed[o.Label] = s
+		delete(c.unknown, o.Label)
+		if !live(s) && (!seen || live(prev)) {
+			c.closed = append(c.closed, o.Label)
+			if len(c.closed) > maxClosed {
+				delete(c.placed, c.closed[0])
+				c.closed = c.closed[1:]
+			}
+		}
+	}
+	if live(s) {
+		c.open[s.OrderID] = s
+	} else {
+		delete(c.open, s.OrderID)
+	}
+	return s
+}
+
+// rejection maps an error answered to an order placement onto the bean error of its code. It returns nil for the
+// errors after which the order may still have been placed
+func rejection(instrument string, e *APIError) error {
+	var kind error
+	switch e.Code {
+	case 10009: // not_enough_funds
+		kind = bean.ErrInsufficientFunds
+	case 10013, 10014, 10015, 10016, 10017, 10018, 10037: // open orders and position size limits
+		kind = bean.ErrRiskLimit
+	case 10028, 10047: // too_many_requests, matching_engine_queue_full
+		kind = bean.ErrRateLimit
+	case 10040, 10041, -32000: // retry, settlement_in_progress, internal error
+		return nil
+	default:
+		kind = bean.ErrInvalidOrder
+	}
+	return fmt.Errorf("%w: %s: %v", kind, instrument, e)
+}
+
+// PlaceOrder places a limit order of amount contracts, positive to buy, labelled with a client id (see
+// NewClientID). An order already placed with the client id is returned instead of being placed again, so a
+// failed call can be retried with the same id: concurrent calls wait for the first and a retry after a failure in
+// flight looks the order up by its label first
+func (c *TradingClient) PlaceOrder(ctx context.Context, clientID, instrument string, price, amount float64) (bean.OrderStatus, error) {
+	con, err := bean.ContractFromName(instrument)
+	if err != nil {
+		return bean.OrderStatus{}, err
+	}
+	if err := c.audit.Err(); err != nil {
+		return bean.OrderStatus{}, err
+	}
+	done := make(chan struct{})
+	for {
+		c.m.Lock()
+		if s, ok := c.placed[clientID]; ok {
+			c.m.Unlock()
+			return s, nil
+		}
+		wait, busy := c.placing[clientID]
+		if !busy {
+			c.placing[clientID] = done
+		}
+		_, retry := c.unknown[clientID]
+		c.m.Unlock()
+		if !busy {
+			defer func() {
+				c.m.Lock()
+				delete(c.placing, clientID)
+				c.m.Unlock()
+				close(done)
+			}()
+			if retry {
+				s, ok, err := c.OrderByClientID(ctx, instrument, clientID)
+				if err != nil {
+					return bean.OrderStatus{}, err
+				}
+				if ok {
+					return s, nil
+				}
+			}
+			break
+		}
+		select {
+		case <-wait:
+		case <-ctx.Done():
+			return bean.OrderStatus{}, ctx.Err()
+		}
+	}
+
+	method := "private/buy"
+	if amount < 0 {
+		method = "private/sell"
+	}
+	params := map[string]interface{}{"instrument_name": instrument, "amount": math.Abs(amount) * con.Multiplier(),
+		"type": "limit", "price": price, "label": clientID}
+	var res orderResult
+	if err := c.call(ctx, method, params, &res); err != nil {
+		var apiErr *APIError
+		if errors.As(err, &apiErr) {
+			if rerr := rejection(instrument, apiErr); rerr != nil {
+				c.m.Lock()
+				delete(c.unknown, clientID)
+				c.m.Unlock()
+				return bean.OrderStatus{}, rerr
+			}
+		}
+		c.m.Lock()
+		c.unknown[clientID] = struct{}{}
+		c.m.Unlock()
+		// the order may have reached the exchange before the failure. If ctx is done already, the placement stays
+		// unknown and is looked up on the retry
+		lctx, cancel := context.WithTimeout(ctx, lookupTimeout)
+		s, ok, lerr := c.OrderByClientID(lctx, instrument, clientID)
+		cancel()
+		if lerr == nil && ok {
+			return s, nil
+		}
+		return bean.OrderStatus{}, err
+	}
+	return c.track(res.Order), nil
+}
+
+// placementUnknown returns whether the placement of a client id failed in flight and is to be retried
+func (c *TradingClient) placementUnknown(clientID string) bool {
+	c.m.Lock()
+	defer c.m.Unlock()
+	_, ok := c.unknown[clientID]
+	return ok
+}
+
+// OrderByClientID returns the order of a currency labelled with a client id, if any
+func (c *TradingClient) OrderByClientID(ctx context.Context, instrument, clientID string) (bean.OrderStatus, bool, error) {
+	con, err := bean.ContractFromName(instrument)
+	if err != nil {
+		return bean.OrderStatus{}, false, err
+	}
+	var res []order
+	params := map[string]interface{}{"currency": currency(con), "label": clientID}
+	if err := c.call(ctx, "private/get_order_state_by_label", params, &res); err != nil {
+		return bean.OrderStatus{}, false, err
+	}
+	for _, o := range res {
+		if o.InstrumentName == instrument {
+			return c.track(o), true, nil
+		}
+	}
+	return bean.OrderStatus{}, false, nil
+}
+
+// CancelOrder cancels an order
+func (c *TradingClient) CancelOrder(ctx context.Context, orderID string) (bean.OrderStatus, error) {
+	var res order
+	if err := c.call(ctx, "private/cancel", map[string]interface{}{"order_id": orderID}, &res); err != nil {
+		return bean.OrderStatus{}, err
+	}
+	return c.track(res), nil
+}
+
+// CancelAll cancels the orders of an instrument
+func (c *TradingClient) CancelAll(ctx context.Context, instrument string) error {
+	return c.call(ctx, "private/cancel_all_by_instrument", map[string]interface{}{"instrument_name": instrument}, nil)
+}
+
+// OpenOrders fetches the live orders of an instrument
+func (c *TradingClient) OpenOrders(ctx context.Context, instrument string) ([]bean.OrderStatus, error) {
+	var res []order
+	if err := c.call(ctx, "private/get_open_orders_by_instrument", map[string]interface{}{"instrument_name": instrument}, &res); err != nil {
+		return nil, err
+	}
+	statuses := make([]bean.OrderStatus, len(res))
+	for i, o := range res {
+		statuses[i] = c.track(o)
+	}
+	return statuses, nil
+}
+
+// Positions fetches the positions of a currency (BTC, ETH) in contracts. Instruments that are not bean
+// contracts are skipped
+func (c *TradingClient) Positions(ctx context.Context, currency string) ([]bean.Position, error) {
+	var res []position
+	if err := c.call(ctx, "private/get_positions", map[string]interface{}{"currency": currency}, &res); err != nil {
+		return nil, err
+	}
+	positions := make([]bean.Position, 0, len(res))
+	for _, p := range res {
+		con, err := bean.ContractFromName(p.InstrumentName)
+		if err != nil {
+			bean.Log().Debugf("deribit: skipping position %s: %v", p.InstrumentName, err)
+			continue
+		}
+		if p.Size == 0 {
+			continue
+		}
+		positions = append(positions, bean.NewPosition(con, p.Size/con.Multiplier(), p.AveragePrice))
+	}
+	return positions, nil
+}
+
+// AccountSummary fetches the account of a currency
+func (c *TradingClient) AccountSummary(ctx context.Context, currency string) (AccountSummary, error) {
+	var res AccountSummary
+	err := c.call(ctx, "private/get_account_summary", map[string]interface{}{"currency": currency}, &res)
+	return res, err
+}
+
+// State returns the balances and positions of the exchange in the currencies, to reconcile an account
+func (c *TradingClient) State(ctx context.Context, currencies ...string) (bean.ExchangeState, error) {
+	s := bean.ExchangeState{Time: c.now(), Balances: make(map[bean.Coin]float64)}
+	for _, cur := range currencies {
+		summary, err := c.AccountSummary(ctx, cur)
+		if err != nil {
+			return s, err
+		}
+		s.Balances[bean.Coin(cur)] = summary.Balance
+		positions, err := c.Positions(ctx, cur)
+		if err != nil {
+			return s, err
+		}
+		for _, p := range positions {
+			s.Positions = append(s.Positions, bean.PositionState{Instrument: p.Name(), Qty: p.Qty(), Price: p.Price()})
+		}
+	}
+	return s, nil
+}
+
+// StateFunc returns the state of the currencies as a bean.StateFunc for a Reconciler
+func (c *TradingClient) StateFunc(currencies ...string) bean.StateFunc {
+	return func(ctx context.Context) (bean.ExchangeState, error) {
+		return c.State(ctx, currencies...)
+	}
+}
+
+// SyncAccount replaces the balances and positions of an account with those of the exchange in the currencies,
+// keeping its fees, funding and equity history
+func (c *TradingClient) SyncAccount(ctx context.Context, acct *bean.Account, currencies ...string) error {
+	ex, err := c.State(ctx, currencies...)
+	if err != nil {
+		return err
+	}
+	s := acct.State()
+	s.Balances, s.Positions = ex.Balances, ex.Positions
+	return acct.Restore(s)
+}
+
+// Subscribe adds channels to the stream, such as user.portfolio.btc, kept over reconnections. Their
+// notifications go to the handler set by SetHandler
+func (c *TradingClient) Subscribe(channels ...string) error {
+	c.m.Lock()
+	for _, ch := range channels {
+		c.channels[ch] = struct{}{}
+	}
+	conn := c.conn
+	c.m.Unlock()
+	if conn == nil {
+		return nil
+	}
+	b, _ := json.Marshal(c.request("private/subscribe", map[string]interface{}{"channels": channels}))
+	return conn.WriteText(b)
+}
+
+// SetHandler sets the function called with the notifications of the channels other than OrdersChannel and
+// TradesChannel
+func (c *TradingClient) SetHandler(f func(channel string, data json.RawMessage)) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.handler = f
+}
+
+func (c *TradingClient) subscriptions() []string {
+	c.m.Lock()
+	defer c.m.Unlock()
+	res := make([]string, 0, len(c.channels))
+	for ch := range c.channels {
+		res = append(res, ch)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// Events streams the updates of our orders as OrderEvents and their fills as ExecutionEvents until the context is done, reconnecting, logging in and
+// resubscribing to the channels when the connection drops. It is an event.Source for live Runners
+func (c *TradingClient) Events(ctx context.Context) <-chan event.Event {
+	out := make(chan event.Event)
+	go func() {
+		defer close(out)
+		delay := c.ReconnectDelay
+		for resync := false; ctx.Err() == nil; resync = true {
+			connected, err := c.stream(ctx, out, resync)
+			if ctx.Err() != nil {
+				return
+			}
+			bean.Log().Warnf("deribit: stream disconnected: %v", err)
+			if connected {
+				delay = c.ReconnectDelay
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(delay):
+			}
+			if delay *= 2; delay > time.Minute {
+				delay = time.Minute
+			}
+		}
+	}()
+	return out
+}
+
+// stream runs one connection of the stream, returning whether it got to subscribe. resync is true on reconnects,
+// counted once resubscribed
+func (c *TradingClient) stream(ctx context.Context, out chan<- event.Event, resync bool) (bool, error) {
+	conn, err := ws.Dial(ctx, c.WSURL)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Abort()
+		case <-stop:
+		}
+	}()
+
+	write := func(req rpcRequest) error {
+		b, err := json.Marshal(req)
+		if err != nil {
+			return err
+		}
+		return conn.WriteText(b)
+	}
+	send := func(method string, params interface{}) error {
+		return write(c.request(method, params))
+	}
+	// the private channels are only granted to an authenticated connection
+	auth := c.request("public/auth", c.authParams())
+	if err := write(auth); err != nil {
+		return false, err
+	}
+	if err := awaitResponse(conn, auth.ID); err != nil {
+		return false, fmt.Errorf("deribit auth: %w", err)
+	}
+	if err := send("private/subscribe", map[string]interface{}{"channels": c.subscriptions()}); err != nil {
+		return false, err
+	}
+	if resync {
+		bean.DefaultMetrics().Counter(bean.MetricBookResyncs).Inc()
+	}
+	if c.Heartbeat > 0 {
+		if err := send("public/set_heartbeat", map[string]interface{}{"interval": int(c.Heartbeat / time.Second)}); err != nil {
+			return false, err
+		}
+	}
+	c.m.Lock()
+	c.conn = conn
+	c.m.Unlock()
+	defer func() {
+		c.m.Lock()
+		c.conn = nil
+		c.m.Unlock()
+	}()
+
+	for {
+		b, err := conn.ReadMessage()
+		if err != nil {
+			return true, err
+		}
+		var msg rpcMessage
+		if err := json.Unmarshal(b, &msg); err != nil {
+			bean.Log().Warnf("deribit: bad stream message: %v", err)
+			continue
+		}
+		switch {
+		case msg.Error != nil:
+			return true, msg.Error
+		case msg.Method == "heartbeat" && msg.Params.Type == "test_request":
+			if err := send("public/test", nil); err != nil {
+				return true, err
+			}
+		case msg.Method == "subscription" && msg.Params.Channel == OrdersChannel:
+			var o order
+			if err := json.Unmarshal(msg.Params.Data, &o); err != nil {
+				bean.Log().Warnf("deribit: bad order update: %v", err)
+				continue
+			}
+			s := c.track(o)
+			select {
+			case out <- event.Event{Kind: event.OrderEvent, Time: orderTime(o), Instrument: s.Instrument, Order: s}:
+			case <-ctx.Done():
+				return true, ctx.Err()
+			}
+		case msg.Method == "subscription" && msg.Params.Channel == TradesChannel:
+			var trades []trade
+			if err := json.Unmarshal(msg.Params.Data, &trades); err != nil {
+				bean.Log().Warnf("deribit: bad trades update: %v", err)
+				continue
+			}
+			for _, t := range trades {
+				r := toExecution(t)
+				c.audit.Record(bean.ExecutionRecord(r))
+				select {
+				case out <- event.Event{Kind: event.ExecutionEvent, Time: r.Time, Instrument: r.Instrument, Execution: r}:
+				case <-ctx.Done():
+					return true, ctx.Err()
+				}
+			}
+		case msg.Method == "subscription":
+			c.m.Lock()
+			f := c.handler
+			c.m.Unlock()
+			if f != nil {
+				f(msg.Params.Channel, msg.Params.Data)
+			}
+		}
+	}
+}
+
+// awaitResponse reads the stream up to the response to the request id, returning its error if any
+func awaitResponse(conn *ws.Conn, id int64) error {
+	for {
+		b, err := conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+		var msg rpcMessage
+		if err := json.Unmarshal(b, &msg); err != nil || msg.ID != id {
+			continue
+		}
+		if msg.Error != nil {
+			return msg.Error
+		}
+		return nil
+	}
+}
+
+// clientState is the state of the orders tracked by a TradingClient
+type clientState struct {
+	Placed  map[string]bean.OrderStatus // by client id
+	Closed  []string                    // client ids of the closed orders, oldest first
+	Unknown []string                    // client ids of the placements failed in flight
+}
+
+var _ event.Checkpointer = (*TradingClient)(nil)
+
+// CheckpointState returns the orders tracked by client id, for a restarted client not to place them again
+func (c *TradingClient) CheckpointState() (json.RawMessage, error) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	s := clientState{Placed: c.placed, Closed: c.closed}
+	for id := range c.unknown {
+		s.Unknown = append(s.Unknown, id)
+	}
+	sort.Strings(s.Unknown)
+	return json.Marshal(s)
+}
+
+// RestoreCheckpoint replaces the orders tracked with a state of CheckpointState
+func (c *TradingClient) RestoreCheckpoint(state json.RawMessage) error {
+	var s clientState
+	if err := json.Unmarshal(state, &s); err != nil {
+		return err
+	}
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.placed, c.closed = make(map[string]bean.OrderStatus, len(s.Placed)), s.Closed
+	c.open, c.unknown = make(map[string]bean.OrderStatus), make(map[string]struct{}, len(s.Unknown))
+	for id, o := range s.Placed {
+		c.placed[id] = o
+		if live(o) {
+			c.open[o.OrderID] = o
+		}
+	}
+	for _, id := range s.Unknown {
+		c.unknown[id] = struct{}{}
+	}
+	return nil
+}
+
+// OrderSink returns the client as an event.OrderSink, placing orders under fresh client ids with a timeout per
+// request, within which placements failing in flight are retried under the same id. Open orders are the live
+// orders tracked from the requests and the stream
+func (c *TradingClient) OrderSink(timeout time.Duration) event.OrderSink {
+	return orderSink{c: c, timeout: timeout}
+}
+
+type orderSink struct {
+	c       *TradingClient
+	timeout time.Duration
+}
+
+func (s orderSink) PlaceOrder(instrument string, price, amount float64) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+	id := s.c.NewClientID()
+	for {
+		st, err := s.c.PlaceOrder(ctx, id, instrument, price, amount)
+		if err == nil || !s.c.placementUnknown(id) {
+			return st.OrderID, err
+		}
+		select {
+		case <-ctx.Done():
+			return "", err
+		case <-time.After(s.c.ReconnectDelay):
+		}
+	}
+}
+
+func (s orderSink) CancelOrder(instrument, oid string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+	_, err := s.c.CancelOrder(ctx, oid)
+	return err
+}
+
+func (s orderSink) OpenOrders(instrument string) []bean.OrderStatus {
+	s.c.m.Lock()
+	defer s.c.m.Unlock()
+	var res []bean.OrderStatus
+	for _, o := range s.c.open {
+		if o.Instrument == instrument {
+			res = append(res, o)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].OrderID < res[j].OrderID })
+	return res
+}
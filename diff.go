@@ -0,0 +1,98 @@
+package bean
+
+import "time"
+
+// LevelChange is the new size of a price level on one side of a book, zero when the level is removed
+type LevelChange struct {
+	Side   Side
+	Price  float64
+	Amount float64
+}
+
+// BookPatch is a set of level changes turning one book into another
+type BookPatch []LevelChange
+
+// OrderBookPatchT is a timestamped patch of a book timeseries
+type OrderBookPatchT struct {
+	Time     time.Time
+	ChangeId int64
+	Patch    BookPatch
+}
+
+func diffSide(s Side, from, to []Order) (res BookPatch) {
+	old := make(map[float64]float64, len(from))
+	for _, o := range from {
+		old[o.Price] = o.Amount
+	}
+	for _, o := range to {
+		if amt, ok := old[o.Price]; !ok || amt != o.Amount {
+			res = append(res, LevelChange{Side: s, Price: o.Price, Amount: o.Amount})
+		}
+		delete(old, o.Price)
+	}
+	for _, o := range from {
+		if _, ok := old[o.Price]; ok {
+			res = append(res, LevelChange{Side: s, Price: o.Price, Amount: 0})
+		}
+	}
+	return
+}
+
+// Diff returns the level changes turning the book into other: new and resized levels, then removed ones
+func (ob OrderBook) Diff(other OrderBook) BookPatch {
+	return append(diffSide(BUY, ob.Bids(), other.Bids()), diffSide(SELL, ob.Asks(), other.Asks())...)
+}
+
+// ApplyPatch applies level changes to the book in place. Changed levels are replaced, so this works on any
+// OrderBookCore implementation
+func (ob OrderBook) ApplyPatch(p BookPatch) {
+	for _, c := range p {
+		o := Order{Price: c.Price, Amount: c.Amount}
+		if c.Side == BUY {
+			ob.CancelBid(o)
+			if c.Amount > 0 {
+				ob.InsertBid(o)
+			}
+		} else {
+			ob.CancelAsk(o)
+			if c.Amount > 0 {
+				ob.InsertAsk(o)
+			}
+		}
+	}
+}
+
+// Clone returns a deep copy of the book in the slice implementation
+func (ob OrderBook) Clone() OrderBook {
+	if ob.OrderBookCore == nil {
+		return EmptyOrderBook()
+	}
+	return NewOrderBook(append([]Order(nil), ob.Bids()...), append([]Order(nil), ob.Asks()...))
+}
+
+// Diffs encodes a timeseries as its first book and the patches from each book to the next
+func (obts OrderBookTS) Diffs() (first OrderBookT, patches []OrderBookPatchT) {
+	if len(obts) == 0 {
+		return
+	}
+	first = obts[0]
+	for i := 1; i < len(obts); i++ {
+		patches = append(patches, OrderBookPatchT{
+			Time:     obts[i].Time,
+			ChangeId: obts[i].ChangeId,
+			Patch:    obts[i-1].Diff(obts[i].OrderBook),
+		})
+	}
+	return
+}
+
+// OrderBookTSFromDiffs rebuilds a timeseries from its first book and patches as returned by Diffs
+func OrderBookTSFromDiffs(first OrderBookT, patches []OrderBookPatchT) OrderBookTS {
+	res := OrderBookTS{{OrderBook: first.Clone(), Time: first.Time, ChangeId: first.ChangeId}}
+	book := first.Clone()
+	for _, p := range patches {
+		book.ApplyPatch(p.Patch)
+		res = append(res, OrderBookT{OrderBook: book.Clone(), Time: p.Time, ChangeId: p.ChangeId})
+	}
+	return res
+}
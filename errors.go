@@ -0,0 +1,46 @@
+package bean
+
+import "errors"
+
+// Errors returned by contract parsing and pricing. Use errors.Is to test for them as they are normally
+// wrapped in a ContractError giving the contract concerned
+var (
+	ErrUnknownCoin       = errors.New("do not recognise coin")
+	ErrBadContractFormat = errors.New("bad contract formation")
+	ErrNoConvergence     = errors.New("implied vol did not converge")
+	ErrNotAnOption       = errors.New("contract is not an option")
+	ErrExpired           = errors.New("contract has expired")
+	ErrInvalidOrder      = errors.New("invalid order")
+	ErrInvalidTransition = errors.New("invalid order state transition")
+	ErrInvalidInput      = errors.New("invalid pricing input")
+	ErrNoIndexPrice      = errors.New("no index constituent price")
+	ErrInsufficientFunds = errors.New("insufficient balance or margin")
+	ErrRiskLimit         = errors.New("risk limit breached")
+	ErrRateLimit         = errors.New("request cost beyond rate limit capacity")
+	ErrNoLiquidity       = errors.New("not enough liquidity in the book")
+	ErrBookInvariant     = errors.New("orderbook invariant violated")
+	ErrNoRate            = errors.New("no conversion rate for coin")
+	ErrInfeasible        = errors.New("no solution meets the constraints")
+)
+
+// ContractError records the contract (or name being parsed) an error relates to
+type ContractError struct {
+	Name string
+	Err  error
+}
+
+func (e *ContractError) Error() string {
+	if e.Name == "" {
+		return e.Err.Error()
+	}
+	return e.Name + ": " + e.Err.Error()
+}
+
+// Unwrap returns the underlying error so errors.Is and errors.As can be used
+func (e *ContractError) Unwrap() error {
+	return e.Err
+}
+
+func contractError(name string, err error) error {
+	return &ContractError{Name: name, Err: err}
+}
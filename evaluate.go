@@ -161,7 +161,7 @@ func GenerateSnapshotTS(ts Transactions, initPort Portfolio) SnapshotTS {
 	return snapts
 }
 
-//GenerateSnapshot updates the portfolio status after single transaction
+//GenerateSnapshot updates the portfolio status after single transaction, net of its fee
 func GenerateSnapshot(t Transaction, p Portfolio) Snapshot {
 	var snap Snapshot
 	coin := t.Pair.Coin
@@ -169,7 +169,7 @@ func GenerateSnapshot(t Transaction, p Portfolio) Snapshot {
 	var coinChange, baseChange float64
 
 	coinChange = t.Amount
-	baseChange = t.Price * t.Amount
+	baseChange = t.Price*t.Amount + t.Fee
 
 	p.AddBalance(coin, coinChange)
 	p.RemoveBalance(base, baseChange)
@@ -0,0 +1,228 @@
+package event
+
+import (
+	"bean"
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DropPolicy is what a subscription does with an update when its queue is full
+type DropPolicy int
+
+const (
+	DropOldest DropPolicy = iota // discard the oldest queued update to make room, consumers see the latest data
+	DropNewest                   // discard the update, consumers see the data in order with gaps
+	Block                        // wait for the consumer, slowing the publisher down
+)
+
+// SubscribeOptions set what a subscription of a Conflator receives
+type SubscribeOptions struct {
+	Rate   float64    // book snapshots per second and instrument, zero for every update
+	Depth  int        // levels of each side of the books, zero for all
+	Buffer int        // events queued, at least one
+	Policy DropPolicy // when the queue is full
+}
+
+// Conflator distributes book and trade updates to subscribers consuming them at their own pace. Fast consumers such
+// as strategies get every update, slow ones such as UIs and loggers set a Rate to receive the latest book of each
+// instrument at most Rate times a second, and may ask for the top Depth levels only. Updates a subscriber has no
+// room for are handled by its DropPolicy and counted as MetricConflationDropped. Trades are never coalesced.
+// Feed it as a MarketDataHandler or with Run. It is safe for concurrent use
+type Conflator struct {
+	m    sync.RWMutex
+	subs map[*Subscription]struct{}
+}
+
+// Subscription is the queue of a subscriber of a Conflator
+type Subscription struct {
+	opts   SubscribeOptions
+	c      *Conflator
+	events chan Event
+
+	m       sync.Mutex
+	pending map[string]Event // latest book of each instrument not sent yet, for conflated subscriptions
+	order   []string         // instruments of pending in arrival order
+	closed  bool
+
+	done    chan struct{}
+	once    sync.Once
+	dropped int64
+}
+
+// NewConflator returns a conflator without subscribers
+func NewConflator() *Conflator {
+	return &Conflator{subs: make(map[*Subscription]struct{})}
+}
+
+// Subscribe adds a subscriber, to Close when done
+func (c *Conflator) Subscribe(opts SubscribeOptions) *Subscription {
+	if opts.Buffer < 1 {
+		opts.Buffer = 1
+	}
+	s := &Subscription{opts: opts, c: c, events: make(chan Event, opts.Buffer), done: make(chan struct{}),
+		pending: make(map[string]Event)}
+	c.m.Lock()
+	c.subs[s] = struct{}{}
+	c.m.Unlock()
+	if opts.Rate > 0 {
+		go s.flushEvery(time.Duration(float64(time.Second) / opts.Rate))
+	}
+	return s
+}
+
+// Subscribers returns the number of subscriptions
+func (c *Conflator) Subscribers() int {
+	c.m.RLock()
+	defer c.m.RUnlock()
+	return len(c.subs)
+}
+
+// OnBook queues a book update to the fast subscribers and replaces the pending book of the conflated ones
+func (c *Conflator) OnBook(instrument string, ob bean.OrderBookT) {
+	c.m.RLock()
+	defer c.m.RUnlock()
+	for s := range c.subs {
+		e := Event{Kind: BookEvent, Time: ob.Time, Instrument: instrument, Book: ob}
+		if s.opts.Depth > 0 || s.opts.Rate > 0 {
+			// conflated books are sent later, when the publisher may have changed them in place
+			e.Book = topLevels(ob, s.opts.Depth)
+		}
+		if s.opts.Rate > 0 {
+			s.hold(e)
+		} else {
+			s.deliver(e)
+		}
+	}
+}
+
+// OnTrade queues a trade to every subscriber
+func (c *Conflator) OnTrade(instrument string, txn bean.Transaction) {
+	c.m.RLock()
+	defer c.m.RUnlock()
+	for s := range c.subs {
+		s.deliver(Event{Kind: TradeEvent, Time: txn.TimeStamp, Instrument: instrument, Trade: txn})
+	}
+}
+
+// Run distributes the books and trades of a source until it is exhausted or the context is done
+func (c *Conflator) Run(ctx context.Context, src Source) {
+	for e := range src.Events(ctx) {
+		switch e.Kind {
+		case BookEvent:
+			c.OnBook(e.Instrument, e.Book)
+		case TradeEvent:
+			c.OnTrade(e.Instrument, e.Trade)
+		}
+	}
+}
+
+// topLevels copies up to depth levels each side of a book, all if depth <= 0
+func topLevels(ob bean.OrderBookT, depth int) bean.OrderBookT {
+	if ob.OrderBookCore == nil {
+		return ob
+	}
+	top := func(levels []bean.Order) []bean.Order {
+		if depth > 0 && len(levels) > depth {
+			levels = levels[:depth]
+		}
+		return append([]bean.Order(nil), levels...)
+	}
+	return bean.OrderBookT{OrderBook: bean.NewOrderBook(top(ob.Bids()), top(ob.Asks())), Time: ob.Time}
+}
+
+// Events returns the updates of the subscription, closed by Close
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+// Dropped returns the number of updates dropped for lack of room in the queue
+func (s *Subscription) Dropped() int64 {
+	return atomic.LoadInt64(&s.dropped)
+}
+
+// Close removes the subscription from its conflator and closes its events
+func (s *Subscription) Close() {
+	s.once.Do(func() {
+		close(s.done) // first, to release a publisher blocked on the subscription
+		s.c.m.Lock()
+		delete(s.c.subs, s)
+		s.c.m.Unlock()
+		s.m.Lock()
+		s.closed = true
+		close(s.events)
+		s.m.Unlock()
+	})
+}
+
+func (s *Subscription) hold(e Event) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if _, ok := s.pending[e.Instrument]; !ok {
+		s.order = append(s.order, e.Instrument)
+	}
+	s.pending[e.Instrument] = e
+}
+
+// flushEvery sends the pending books every interval until the subscription is closed
+func (s *Subscription) flushEvery(interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			s.m.Lock()
+			order := s.order
+			pending := s.pending
+			s.order, s.pending = nil, make(map[string]Event, len(pending))
+			s.m.Unlock()
+			for _, instrument := range order {
+				s.deliver(pending[instrument])
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
+
+func (s *Subscription) deliver(e Event) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if s.closed {
+		return
+	}
+	select {
+	case s.events <- e:
+		return
+	default:
+	}
+	switch s.opts.Policy {
+	case Block:
+		select {
+		case s.events <- e:
+		case <-s.done:
+		}
+		return
+	case DropOldest:
+		for {
+			select {
+			case s.events <- e:
+				return
+			default:
+			}
+			select {
+			case <-s.events:
+				s.drop()
+			default:
+			}
+		}
+	default:
+		s.drop()
+	}
+}
+
+func (s *Subscription) drop() {
+	atomic.AddInt64(&s.dropped, 1)
+	bean.DefaultMetrics().Counter(bean.MetricConflationDropped).Inc()
+}
@@ -0,0 +1,109 @@
+// Package event runs strategies on streams of market events, so the same strategy code is driven by live
+// connectors or by replayed history: a Source produces the events, the Runner dispatches them in order to the
+// Strategy, and orders go to an OrderSink, an exchange client live or a simulated broker in backtests
+package event
+
+import (
+	"bean"
+	"context"
+	"time"
+)
+
+// Kind is the type of an event
+type Kind int
+
+const (
+	BookEvent  Kind = iota // orderbook update
+	TradeEvent             // market trade
+	OrderEvent             // update of one of our orders
+	FundingEvent           // funding payment of a perpetual
+	ExecutionEvent         // fill of one of our orders
+)
+
+// Event is one market data or order update. Only the field of its kind is set
+type Event struct {
+	Kind       Kind
+	Time       time.Time
+	Instrument string
+	Book       bean.OrderBookT
+	Trade      bean.Transaction
+	Order      bean.OrderStatus
+	Funding    Funding
+	Execution  bean.ExecutionReport
+}
+
+// Funding is a funding payment of a perpetual at a rate over the funding interval, longs paying when positive
+type Funding struct {
+	Time time.Time
+	Rate float64
+	Mark float64 // mark price the notional is converted at
+}
+
+// MarketDataHandler receives market data
+type MarketDataHandler interface {
+	OnBook(instrument string, ob bean.OrderBookT)
+	OnTrade(instrument string, txn bean.Transaction)
+}
+
+// FundingHandler receives funding payments. Strategies and sinks implementing it are called on FundingEvents
+type FundingHandler interface {
+	OnFunding(instrument string, f Funding)
+}
+
+// ExecutionHandler receives the fills of our orders. Strategies implementing it are called on ExecutionEvents
+type ExecutionHandler interface {
+	OnExecution(r bean.ExecutionReport)
+}
+
+// OrderSink places and cancels orders. Amounts are positive to buy
+type OrderSink interface {
+	PlaceOrder(instrument string, price, amount float64) (string, error)
+	CancelOrder(instrument, oid string) error
+	OpenOrders(instrument string) []bean.OrderStatus
+}
+
+// Audited is implemented by the sinks and sources recording the order updates and fills they handle in an audit
+// log. AuditErr returns the first failure to record, after which sinks refuse new orders and Runners stop
+type Audited interface {
+	SetAudit(a bean.AuditLog)
+	AuditErr() error
+}
+
+// Strategy is an event driven strategy. Init is called once with the sink to trade through before any event
+type Strategy interface {
+	MarketDataHandler
+	Init(sink OrderSink)
+	OnOrder(status bean.OrderStatus) // fills and state changes of our orders
+	OnTimer(t time.Time)             // called every timer interval of event time
+}
+
+// Source produces events in time order until it is exhausted or the context is done, then closes the channel
+type Source interface {
+	Events(ctx context.Context) <-chan Event
+}
+
+// ChanSource is a Source reading from a channel, to plug in live connectors that push their updates to it
+type ChanSource chan Event
+
+func (s ChanSource) Events(ctx context.Context) <-chan Event {
+	out := make(chan Event)
+	go func() {
+		defer close(out)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case e, ok := <-s:
+				if !ok {
+					return
+				}
+				select {
+				case out <- e:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return out
+}
@@ -0,0 +1,58 @@
+package event
+
+import (
+	"bean"
+	"context"
+)
+
+// PaperBroker forward tests strategies on live market data without exchange keys: orders are matched by a
+// SimBroker against the real time books and trades of the connectors, fills are booked in an account and recorded
+// in a blotter. Use it as the sink of a Runner reading a live Source, or feed it with Run when the strategy trades
+// through it from elsewhere. Orders placed between market data updates are stamped with its clock
+type PaperBroker struct {
+	*SimBroker
+	blotter *bean.Blotter
+	clock   bean.Clock
+}
+
+// NewPaperBroker returns a paper broker booking fills in an account and charging fees at a rate, stamping orders
+// with a clock, the system clock if nil
+func NewPaperBroker(account *bean.Account, fees bean.FeeRate, clock bean.Clock) *PaperBroker {
+	if clock == nil {
+		clock = bean.RealClock
+	}
+	p := &PaperBroker{SimBroker: NewSimBroker(account, fees), blotter: bean.NewBlotter(), clock: clock}
+	p.SetBlotter(p.blotter)
+	return p
+}
+
+// Blotter returns the blotter of the fills
+func (p *PaperBroker) Blotter() *bean.Blotter {
+	return p.blotter
+}
+
+// PlaceOrder places a limit order on a contract, positive amount to buy, matched at once against the latest book
+func (p *PaperBroker) PlaceOrder(instrument string, price, amount float64) (string, error) {
+	p.advance(p.clock.Now())
+	return p.SimBroker.PlaceOrder(instrument, price, amount)
+}
+
+// CancelOrder cancels a live order
+func (p *PaperBroker) CancelOrder(instrument, oid string) error {
+	p.advance(p.clock.Now())
+	return p.SimBroker.CancelOrder(instrument, oid)
+}
+
+// Run matches the orders against the books and trades of a source until it is exhausted or the context is done
+func (p *PaperBroker) Run(ctx context.Context, src Source) {
+	for e := range src.Events(ctx) {
+		switch e.Kind {
+		case BookEvent:
+			p.OnBook(e.Instrument, e.Book)
+		case TradeEvent:
+			p.OnTrade(e.Instrument, e.Trade)
+		case FundingEvent:
+			p.OnFunding(e.Instrument, e.Funding)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package event
+
+import (
+	"bean"
+	"container/heap"
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ReplayMode is the pace at which a Replayer sends its events
+type ReplayMode int
+
+const (
+	ReplayFast     ReplayMode = iota // as fast as the consumer takes them
+	ReplayRealTime                   // spaced as recorded, divided by the speed
+	ReplayStep                       // one event per call to Step
+)
+
+// Replayer is a Source replaying recorded streams of books, trades and funding of several instruments, merged
+// into one time ordered stream. At the same time books come before trades, then funding, then the order the
+// streams were added in
+type Replayer struct {
+	Clock bean.Clock // paces real time replays, the system clock if nil
+
+	streams [][]Event
+	mode    ReplayMode
+	speed   float64
+
+	step chan struct{}
+	m    sync.Mutex
+	done chan struct{} // closed at the end of the current replay
+}
+
+// NewReplayer returns an empty replayer replaying as fast as possible
+func NewReplayer() *Replayer {
+	done := make(chan struct{})
+	close(done) // no replay yet
+	return &Replayer{speed: 1, step: make(chan struct{}), done: done}
+}
+
+// SetMode sets the pace of the replay, speed being the acceleration of real time replays (1 for real time).
+// Call it before Events
+func (r *Replayer) SetMode(mode ReplayMode, speed float64) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.mode = mode
+	if speed > 0 {
+		r.speed = speed
+	}
+}
+
+// addStream adds a stream sorted by time, keeping the recorded order of events at the same time
+func (r *Replayer) addStream(events []Event) {
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Time.Before(events[j].Time) })
+	r.streams = append(r.streams, events)
+}
+
+// AddBooks adds the books of an instrument
+func (r *Replayer) AddBooks(instrument string, books bean.OrderBookTS) {
+	events := make([]Event, len(books))
+	for i, ob := range books {
+		events[i] = Event{Kind: BookEvent, Time: ob.Time, Instrument: instrument, Book: ob}
+	}
+	r.addStream(events)
+}
+
+// AddTrades adds the market trades of an instrument
+func (r *Replayer) AddTrades(instrument string, txns bean.Transactions) {
+	events := make([]Event, len(txns))
+	for i, t := range txns {
+		events[i] = Event{Kind: TradeEvent, Time: t.TimeStamp, Instrument: instrument, Trade: t}
+	}
+	r.addStream(events)
+}
+
+// AddFunding adds the funding payments of a perpetual
+func (r *Replayer) AddFunding(instrument string, funding []Funding) {
+	events := make([]Event, len(funding))
+	for i, f := range funding {
+		events[i] = Event{Kind: FundingEvent, Time: f.Time, Instrument: instrument, Funding: f}
+	}
+	r.addStream(events)
+}
+
+// Len returns the number of events
+func (r *Replayer) Len() int {
+	n := 0
+	for _, s := range r.streams {
+		n += len(s)
+	}
+	return n
+}
+
+// Step releases the next event in step mode, blocking until the replay is ready for it. Returns false once the
+// replay is over, before Events is called and in the other modes
+func (r *Replayer) Step() bool {
+	r.m.Lock()
+	done, mode := r.done, r.mode
+	r.m.Unlock()
+	if mode != ReplayStep {
+		return false
+	}
+	select {
+	case r.step <- struct{}{}:
+		return true
+	case <-done:
+		return false
+	}
+}
+
+// Events merges the streams into one. Each call replays from the start
+func (r *Replayer) Events(ctx context.Context) <-chan Event {
+	out := make(chan Event)
+	r.m.Lock()
+	done := make(chan struct{})
+	r.done = done
+	p := &pacer{mode: r.mode, speed: r.speed, clock: r.Clock, step: r.step}
+	r.m.Unlock()
+	if p.clock == nil {
+		p.clock = bean.RealClock
+	}
+	go func() {
+		defer close(out)
+		defer close(done)
+		h := make(streamHeap, 0, len(r.streams))
+		for i, s := range r.streams {
+			if len(s) > 0 {
+				h = append(h, &streamCursor{events: s, order: i})
+			}
+		}
+		heap.Init(&h)
+		for h.Len() > 0 {
+			c := h[0]
+			e := c.events[c.pos]
+			if c.pos++; c.pos < len(c.events) {
+				heap.Fix(&h, 0)
+			} else {
+				heap.Pop(&h)
+			}
+			if !p.wait(ctx, e.Time) {
+				return
+			}
+			select {
+			case out <- e:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// pacer spaces the events of a replay
+type pacer struct {
+	mode  ReplayMode
+	speed float64
+	clock bean.Clock
+	step  <-chan struct{}
+	start time.Time // clock time of the first event
+	first time.Time // time of the first event
+}
+
+// wait paces the next event at t, returning false if ctx is done first
+func (p *pacer) wait(ctx context.Context, t time.Time) bool {
+	switch p.mode {
+	case ReplayStep:
+		select {
+		case <-p.step:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	case ReplayRealTime:
+		if p.start.IsZero() {
+			p.start, p.first = p.clock.Now(), t
+			return true
+		}
+		return bean.SleepUntil(ctx, p.clock, p.start.Add(time.Duration(float64(t.Sub(p.first))/p.speed))) == nil
+	}
+	return true
+}
+
+// streamCursor is the position of the replay in one stream
+type streamCursor struct {
+	events []Event
+	pos    int
+	order  int
+}
+
+type streamHeap []*streamCursor
+
+func (h streamHeap) Len() int { return len(h) }
+
+func (h streamHeap) Less(i, j int) bool {
+	a, b := h[i].events[h[i].pos], h[j].events[h[j].pos]
+	if !a.Time.Equal(b.Time) {
+		return a.Time.Before(b.Time)
+	}
+	if a.Kind != b.Kind {
+		return a.Kind < b.Kind
+	}
+	return h[i].order < h[j].order
+}
+
+func (h streamHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *streamHeap) Push(x interface{}) { *h = append(*h, x.(*streamCursor)) }
+
+func (h *streamHeap) Pop() interface{} {
+	old := *h
+	c := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return c
+}
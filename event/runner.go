@@ -0,0 +1,162 @@
+package event
+
+import (
+	"bean"
+	"context"
+	"sync"
+	"time"
+)
+
+// Runner wires a source of events and an order sink to a strategy. Time is the time of the events, so timers fire
+// at the same points of the data live and in backtests
+type Runner struct {
+	source Source
+	sink   OrderSink
+	timer  time.Duration
+	clock  *bean.SimClock
+
+	m         sync.Mutex
+	orders    []Event
+	nextTimer time.Time
+}
+
+// NewRunner returns a runner of the events of source, trading through sink, with a strategy timer every timer
+// interval (zero for none). Sinks that handle market data, such as the simulated broker, see each event before
+// the strategy so its orders are matched against the latest market
+func NewRunner(source Source, sink OrderSink, timer time.Duration) *Runner {
+	return &Runner{source: source, sink: sink, timer: timer, clock: bean.NewSimClock(time.Time{})}
+}
+
+// Clock returns the clock of the runner, which follows the time of the events dispatched. Pass it to the parts of
+// a strategy that need the time so they run in event time live and in backtests
+func (r *Runner) Clock() bean.Clock {
+	return r.clock
+}
+
+// SetAudit sets the audit log of the sink and the source that are Audited, which record the order updates and fills
+// as they handle them
+func (r *Runner) SetAudit(a bean.AuditLog) {
+	for _, x := range []interface{}{r.sink, r.source} {
+		if au, ok := x.(Audited); ok {
+			au.SetAudit(a)
+		}
+	}
+}
+
+// auditErr returns the first failure of the sink or the source to record in their audit log
+func (r *Runner) auditErr() error {
+	for _, x := range []interface{}{r.sink, r.source} {
+		if au, ok := x.(Audited); ok {
+			if err := au.AuditErr(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// OrderUpdate queues an update or an ExecutionEvent of one of the strategy's orders, sent to the strategy once the
+// current callback returns. Simulated sinks call it on fills and state changes, live connectors can instead push
+// OrderEvents and ExecutionEvents to the source
+func (r *Runner) OrderUpdate(e Event) {
+	if e.Kind != ExecutionEvent {
+		e.Kind = OrderEvent
+	}
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.orders = append(r.orders, e)
+}
+
+// Run dispatches the events to the strategy until the source is exhausted or the context is done, returning the
+// context error in the latter case. It stops with the error of an Audited sink or source failing to record
+func (r *Runner) Run(ctx context.Context, s Strategy) error {
+	if n, ok := r.sink.(interface{ SetNotify(func(Event)) }); ok {
+		n.SetNotify(r.OrderUpdate)
+	}
+	s.Init(r.sink)
+	md, _ := r.sink.(MarketDataHandler)
+	for e := range r.source.Events(ctx) {
+		if r.timer > 0 {
+			if r.NextTimer().IsZero() {
+				r.SetNextTimer(e.Time.Add(r.timer))
+			}
+			for next := r.NextTimer(); !next.After(e.Time); next = r.NextTimer() {
+				r.clock.Set(next)
+				s.OnTimer(next)
+				r.SetNextTimer(next.Add(r.timer))
+				r.flushOrders(s)
+			}
+		}
+		if e.Time.After(r.clock.Now()) {
+			r.clock.Set(e.Time)
+		}
+		switch e.Kind {
+		case BookEvent:
+			if md != nil {
+				md.OnBook(e.Instrument, e.Book)
+			}
+			r.flushOrders(s)
+			s.OnBook(e.Instrument, e.Book)
+		case TradeEvent:
+			if md != nil {
+				md.OnTrade(e.Instrument, e.Trade)
+			}
+			r.flushOrders(s)
+			s.OnTrade(e.Instrument, e.Trade)
+		case OrderEvent, ExecutionEvent:
+			r.dispatchOrder(s, e)
+		case FundingEvent:
+			if f, ok := r.sink.(FundingHandler); ok {
+				f.OnFunding(e.Instrument, e.Funding)
+			}
+			r.flushOrders(s)
+			if f, ok := s.(FundingHandler); ok {
+				f.OnFunding(e.Instrument, e.Funding)
+			}
+		}
+		r.flushOrders(s)
+		if err := r.auditErr(); err != nil {
+			return err
+		}
+	}
+	return ctx.Err()
+}
+
+// NextTimer returns the event time the strategy timer fires next, zero before the first event
+func (r *Runner) NextTimer() time.Time {
+	r.m.Lock()
+	defer r.m.Unlock()
+	return r.nextTimer
+}
+
+// SetNextTimer sets the event time the strategy timer fires next, to resume the timers of a restored state
+func (r *Runner) SetNextTimer(t time.Time) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.nextTimer = t
+}
+
+// flushOrders sends the queued order updates to the strategy
+func (r *Runner) flushOrders(s Strategy) {
+	for {
+		r.m.Lock()
+		if len(r.orders) == 0 {
+			r.m.Unlock()
+			return
+		}
+		e := r.orders[0]
+		r.orders = r.orders[1:]
+		r.m.Unlock()
+		r.dispatchOrder(s, e)
+	}
+}
+
+func (r *Runner) dispatchOrder(s Strategy, e Event) {
+	if e.Kind == ExecutionEvent {
+		if h, ok := s.(ExecutionHandler); ok {
+			h.OnExecution(e.Execution)
+		}
+		return
+	}
+	s.OnOrder(e.Order)
+}
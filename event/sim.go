@@ -0,0 +1,213 @@
+package event
+
+import (
+	"bean"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// SimBroker is an OrderSink simulating an exchange on the market data it is given with a bean.Matcher, the
+// matching of the backtest engine: orders crossing the latest book fill as takers, resting orders fill as makers
+// when a market trade goes through their price, with the latency, slippage and queue models set. Fills are booked
+// in an Account and, with SetAudit, recorded in an audit log with the order updates. It is safe for concurrent use
+type SimBroker struct {
+	m       sync.Mutex
+	account *bean.Account
+	fees    bean.FeeRate
+	matcher *bean.Matcher
+	notify  func(Event)
+	blotter *bean.Blotter
+	audit   bean.Auditor
+}
+
+// NewSimBroker returns a broker booking fills in an account and charging fees at a rate
+func NewSimBroker(account *bean.Account, fees bean.FeeRate) *SimBroker {
+	b := &SimBroker{account: account, fees: fees, matcher: bean.NewMatcher()}
+	b.matcher.OnUpdate(b.update)
+	b.matcher.OnFill(b.fill)
+	return b
+}
+
+func (b *SimBroker) Account() *bean.Account {
+	return b.account
+}
+
+// SetNotify sets the function called with every order update and ExecutionEvent of a fill, set by the Runner
+func (b *SimBroker) SetNotify(f func(Event)) {
+	b.m.Lock()
+	defer b.m.Unlock()
+	b.notify = f
+}
+
+// SetBlotter sets a blotter recording the fills
+func (b *SimBroker) SetBlotter(blotter *bean.Blotter) {
+	b.m.Lock()
+	defer b.m.Unlock()
+	b.blotter = blotter
+}
+
+// SetAudit sets an audit log recording the order updates and fills
+func (b *SimBroker) SetAudit(a bean.AuditLog) {
+	b.audit.SetLog(a)
+}
+
+// AuditErr returns the first failure to record in the audit log, after which orders are refused
+func (b *SimBroker) AuditErr() error {
+	return b.audit.Err()
+}
+
+// SetLatency sets the model of the delay of order placements and cancels reaching the book, nil for none
+func (b *SimBroker) SetLatency(l bean.LatencyModel) {
+	b.m.Lock()
+	defer b.m.Unlock()
+	b.matcher.Latency = l
+}
+
+// SetSlippage sets the model of the price impact of taker fills, nil for none
+func (b *SimBroker) SetSlippage(s bean.SlippageModel) {
+	b.m.Lock()
+	defer b.m.Unlock()
+	b.matcher.Slippage = s
+}
+
+// SetQueueModel turns on the model of the queue position of resting orders
+func (b *SimBroker) SetQueueModel(on bool) {
+	b.m.Lock()
+	defer b.m.Unlock()
+	b.matcher.Queue = on
+}
+
+// advance moves the time of the broker to t, if later, for orders placed between market data updates
+func (b *SimBroker) advance(t time.Time) {
+	b.m.Lock()
+	defer b.m.Unlock()
+	b.matcher.Advance(t)
+}
+
+// PlaceOrder places a limit order on a contract, positive amount to buy
+func (b *SimBroker) PlaceOrder(instrument string, price, amount float64) (string, error) {
+	if _, err := bean.ContractFromName(instrument); err != nil {
+		return "", err
+	}
+	if !(price > 0) {
+		return "", fmt.Errorf("%w: bad price %v", bean.ErrInvalidOrder, price)
+	}
+	if err := b.audit.Err(); err != nil {
+		return "", err
+	}
+	b.m.Lock()
+	defer b.m.Unlock()
+	return b.matcher.Place(instrument, price, amount)
+}
+
+// CancelOrder cancels a live order
+func (b *SimBroker) CancelOrder(instrument, oid string) error {
+	b.m.Lock()
+	defer b.m.Unlock()
+	return b.matcher.Cancel(instrument, oid)
+}
+
+// OpenOrders returns the live orders of an instrument
+func (b *SimBroker) OpenOrders(instrument string) []bean.OrderStatus {
+	b.m.Lock()
+	defer b.m.Unlock()
+	return b.matcher.OpenOrders(instrument)
+}
+
+// Books returns the latest book of each instrument
+func (b *SimBroker) Books() map[string]bean.OrderBookT {
+	b.m.Lock()
+	defer b.m.Unlock()
+	return b.matcher.Books()
+}
+
+// Orders returns the live orders of all instruments, by instrument and order id
+func (b *SimBroker) Orders() []bean.OrderStatus {
+	b.m.Lock()
+	defer b.m.Unlock()
+	return b.matcher.Orders()
+}
+
+// Restore replaces the books and live orders of the broker, as returned by Books and Orders. Order ids continue
+// after the largest restored. Nothing is replaced if an order is invalid
+func (b *SimBroker) Restore(books map[string]bean.OrderBookT, orders []bean.OrderStatus) error {
+	for _, s := range orders {
+		c, err := bean.ContractFromName(s.Instrument)
+		if err != nil {
+			return err
+		}
+		if _, err := bean.NewLimitOrder(s.OrderID, c, s.Side, s.PlacedPrice, s.FilledAmount+s.LeftAmount, s.PlacedTime); err != nil {
+			return err
+		}
+	}
+	b.m.Lock()
+	defer b.m.Unlock()
+	b.matcher.Restore(books, orders)
+	return nil
+}
+
+// OnBook matches the live orders of the instrument against its new book
+func (b *SimBroker) OnBook(instrument string, ob bean.OrderBookT) {
+	b.m.Lock()
+	defer b.m.Unlock()
+	b.matcher.OnBook(instrument, ob)
+}
+
+// OnTrade fills the resting orders a market trade goes through
+func (b *SimBroker) OnTrade(instrument string, txn bean.Transaction) {
+	b.m.Lock()
+	defer b.m.Unlock()
+	b.matcher.OnTrade(instrument, txn)
+}
+
+// OnFunding charges the funding of a perpetual to the account
+func (b *SimBroker) OnFunding(instrument string, f Funding) {
+	c, err := bean.ContractFromName(instrument)
+	if err != nil {
+		return
+	}
+	b.m.Lock()
+	defer b.m.Unlock()
+	b.matcher.Advance(f.Time)
+	b.account.ApplyFunding(c, f.Rate, f.Mark)
+}
+
+// fill books a fill of the matcher in the account with its fee in LHS coin, called with the lock held
+func (b *SimBroker) fill(f bean.MatchFill) {
+	c, err := bean.ContractFromName(f.Order.Instrument)
+	if err != nil {
+		return
+	}
+	size := math.Abs(f.Amount)
+	var fee float64
+	if c.IsOption() {
+		fee = b.fees.OptionFee(size, size*f.Price, f.Maker)
+	} else {
+		fee = b.fees.Fee(size*c.Multiplier()/f.Price, f.Maker)
+	}
+	b.account.Fill(c, f.Amount, f.Price, fee)
+	r := bean.ExecutionReport{Exchange: bean.NameSim, OrderID: f.Order.OrderID, ExecID: f.ExecID,
+		Instrument: f.Order.Instrument, Pair: c.Underlying(), Side: f.Order.Side, Price: f.Price, Qty: size, Fee: fee,
+		FeeAsset: c.Underlying().Coin, Liquidity: bean.LiquidityTaker, Time: f.Time}
+	if f.Maker {
+		r.Liquidity = bean.LiquidityMaker
+	}
+	if b.blotter != nil {
+		b.blotter.AddExecution(r)
+	}
+	b.audit.Record(bean.ExecutionRecord(r))
+	b.update(f.Order)
+	if b.notify != nil {
+		b.notify(Event{Kind: ExecutionEvent, Time: f.Time, Instrument: r.Instrument, Execution: r})
+	}
+}
+
+// update notifies the status of an order, called with the lock held
+func (b *SimBroker) update(s bean.OrderStatus) {
+	b.audit.Record(bean.OrderRecord(b.matcher.Now(), s))
+	if b.notify != nil {
+		b.notify(Event{Kind: OrderEvent, Time: b.matcher.Now(), Instrument: s.Instrument, Order: s})
+	}
+}
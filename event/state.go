@@ -0,0 +1,199 @@
+package event
+
+import (
+	"bean"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Book is an order book of a State
+type Book struct {
+	Time time.Time
+	Bids []bean.Order
+	Asks []bean.Order
+}
+
+// State is the runtime state of a service running a strategy on events, saved to disk so that it can restart
+// without losing its books, fills, positions, PnL, open orders and timers
+type State struct {
+	Time      time.Time // when the state was taken
+	NextTimer time.Time // when the strategy timer fires next
+	Books     map[string]Book
+	Account   *bean.AccountState         `json:",omitempty"`
+	Trades    bean.TradeLogS             // fills of the blotter
+	Orders    []bean.OrderStatus         // live orders
+	Strategy  json.RawMessage            `json:",omitempty"` // see Snapshotter
+	Parts     map[string]json.RawMessage `json:",omitempty"` // by name, see Checkpointer
+}
+
+// Snapshotter is implemented by strategies with state of their own to save, such as signals or inventory targets
+type Snapshotter interface {
+	Snapshot() (json.RawMessage, error)
+	Restore(state json.RawMessage) error
+}
+
+// Checkpointer is implemented by the sinks and sources with state of their own to save, such as the orders and
+// client ids a live trading client tracks or the positions a user data stream follows
+type Checkpointer interface {
+	CheckpointState() (json.RawMessage, error)
+	RestoreCheckpoint(state json.RawMessage) error
+}
+
+// SaveState writes a state to a JSON file, replacing it atomically so a crash never leaves a partial state
+func SaveState(path string, s State) error {
+	b, err := json.MarshalIndent(s, "", " ")
+	if err != nil {
+		return fmt.Errorf("encoding state: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// LoadState reads a state written by SaveState. The error wraps fs.ErrNotExist if there is none, on a first start
+func LoadState(path string) (State, error) {
+	var s State
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return s, err
+	}
+	if err := json.Unmarshal(b, &s); err != nil {
+		return s, fmt.Errorf("decoding state %s: %w", path, err)
+	}
+	return s, nil
+}
+
+// Checkpoint takes and restores the State of the parts of a service, nil parts being skipped. The account is the
+// broker's if there is a broker. Live clients go in Parts under a name of their own. Take states between events,
+// when the runner is not calling the strategy
+type Checkpoint struct {
+	Runner   *Runner
+	Broker   *SimBroker
+	Account  *bean.Account
+	Blotter  *bean.Blotter
+	Strategy Strategy                // saved if it is a Snapshotter
+	Parts    map[string]Checkpointer // such as a deribit.TradingClient or a binance.UserStream
+}
+
+func (c Checkpoint) account() *bean.Account {
+	if c.Broker != nil {
+		return c.Broker.Account()
+	}
+	return c.Account
+}
+
+// State returns the state of the parts as of t
+func (c Checkpoint) State(t time.Time) (State, error) {
+	s := State{Time: t}
+	if c.Runner != nil {
+		s.NextTimer = c.Runner.NextTimer()
+	}
+	if c.Broker != nil {
+		s.Books = make(map[string]Book)
+		for name, ob := range c.Broker.Books() {
+			book := Book{Time: ob.Time}
+			if ob.OrderBookCore != nil {
+				book.Bids, book.Asks = ob.Bids(), ob.Asks()
+			}
+			s.Books[name] = book
+		}
+		s.Orders = c.Broker.Orders()
+	}
+	if a := c.account(); a != nil {
+		as := a.State()
+		s.Account = &as
+	}
+	if c.Blotter != nil {
+		s.Trades = c.Blotter.Trades()
+	}
+	if sn, ok := c.Strategy.(Snapshotter); ok {
+		raw, err := sn.Snapshot()
+		if err != nil {
+			return s, fmt.Errorf("strategy snapshot: %w", err)
+		}
+		s.Strategy = raw
+	}
+	for name, p := range c.Parts {
+		raw, err := p.CheckpointState()
+		if err != nil {
+			return s, fmt.Errorf("%s state: %w", name, err)
+		}
+		if s.Parts == nil {
+			s.Parts = make(map[string]json.RawMessage, len(c.Parts))
+		}
+		s.Parts[name] = raw
+	}
+	return s, nil
+}
+
+// Restore puts the parts back in a state, before the runner is started
+func (c Checkpoint) Restore(s State) error {
+	if c.Broker != nil {
+		books := make(map[string]bean.OrderBookT, len(s.Books))
+		for name, b := range s.Books {
+			books[name] = bean.OrderBookT{OrderBook: bean.NewOrderBook(b.Bids, b.Asks), Time: b.Time}
+		}
+		if err := c.Broker.Restore(books, s.Orders); err != nil {
+			return err
+		}
+	}
+	if a := c.account(); a != nil && s.Account != nil {
+		if err := a.Restore(*s.Account); err != nil {
+			return err
+		}
+	}
+	if c.Blotter != nil {
+		c.Blotter.Restore(s.Trades)
+	}
+	if c.Runner != nil {
+		c.Runner.SetNextTimer(s.NextTimer)
+	}
+	if sn, ok := c.Strategy.(Snapshotter); ok && len(s.Strategy) > 0 {
+		if err := sn.Restore(s.Strategy); err != nil {
+			return fmt.Errorf("strategy restore: %w", err)
+		}
+	}
+	for name, p := range c.Parts {
+		if raw, ok := s.Parts[name]; ok {
+			if err := p.RestoreCheckpoint(raw); err != nil {
+				return fmt.Errorf("%s restore: %w", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Save writes the state of the parts as of t to a file, see SaveState
+func (c Checkpoint) Save(path string, t time.Time) error {
+	s, err := c.State(t)
+	if err != nil {
+		return err
+	}
+	return SaveState(path, s)
+}
+
+// Load restores the parts from a file written by Save, returning the time the state was taken
+func (c Checkpoint) Load(path string) (time.Time, error) {
+	s, err := LoadState(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return s.Time, c.Restore(s)
+}
@@ -43,6 +43,7 @@ const (
 	NamePROBIT   = "PROBIT"
 	NameCoinone  = "COINONE"
 	NameInfiBTC  = "INFIBTC"
+	NameOKX      = "OKX"
 
 	BEANEX = "BEANEX"
 )
@@ -26,8 +26,12 @@ type Simulator struct {
 	myTransactions []Transaction
 	oid            int
 	myPortfolio    Portfolio
+	fees           *FeeSchedule // spot fees charged on fills, none if nil
 }
 
+// simFees are the fees of exchanges without spot fees in the fee schedule
+var simFees = FeeRate{MakerBps: 10, TakerBps: 10}
+
 func NewSimulator(exName string, pairs []Pair, dbhost, dbport string, start, end time.Time, initPortfolio Portfolio) Simulator {
 	// create MDS
 	// mds := mds.NewMDS(exName, dbhost, dbport)
@@ -35,7 +39,7 @@ func NewSimulator(exName string, pairs []Pair, dbhost, dbport string, start, end
 	// mds := bean.NewRPCMDSConnC("tcp", dbhost+":"+dbport)
 	// get historical data
 	obts := make(map[Pair]OrderBookTS, len(pairs))
-	fmt.Println(exName)
+	Log().Infof("simulator %s: loading market data from %v to %v", exName, start, end)
 	mds, err := mds.ConnectService()
 	if err != nil {
 		panic("failed connecting to MDS" + err.Error())
@@ -49,9 +53,13 @@ func NewSimulator(exName string, pairs []Pair, dbhost, dbport string, start, end
 	for _, p := range pairs {
 		txn[p], _ = mds.GetTransactions2(exName, p, start, end)
 	}
-	// construct exSim
+	return NewSimulatorWithData(exName, obts, txn, start, initPortfolio)
+}
+
+// NewSimulatorWithData returns a simulator replaying the orderbooks and transactions given by pair from start
+func NewSimulatorWithData(exName string, obts map[Pair]OrderBookTS, txn map[Pair]Transactions, start time.Time, initPortfolio Portfolio) Simulator {
 	myOrders := make(map[Pair]([]simOrder))
-	for _, p := range pairs {
+	for p := range obts {
 		myOrders[p] = make([]simOrder, 0)
 	}
 	return Simulator{
@@ -65,6 +73,20 @@ func NewSimulator(exName string, pairs []Pair, dbhost, dbport string, start, end
 	}
 }
 
+// SetFeeSchedule sets the fees charged on fills, the spot fees of the simulated exchange in the schedule or 10bp
+// if it has none. No fees are charged without a schedule
+func (sim *Simulator) SetFeeSchedule(fs *FeeSchedule) {
+	sim.fees = fs
+}
+
+// feeRate returns the spot fees of the exchange in the schedule, 10bp if it has none
+func (sim Simulator) feeRate() FeeRate {
+	if r, ok := sim.fees.Lookup(sim.exName, ClassSpot); ok {
+		return r
+	}
+	return simFees
+}
+
 // reset simulator, keep ob and txn history, reset orders, and trades
 func (sim *Simulator) Reset(start time.Time, initPortfolio Portfolio) {
 	// clear my orders
@@ -108,6 +130,12 @@ func (sim *Simulator) SetTime(t time.Time) {
 
 				fillAmount := obFill.Amount + txnFill
 				fillPrice := (obFill.Price*obFill.Amount + myOrder.price*txnFill) / fillAmount
+				// the book fill takes liquidity, the fill against later trades provides it
+				fee := 0.0
+				if sim.fees != nil {
+					rate := sim.feeRate()
+					fee = rate.Fee(obFill.Amount*obFill.Price, false) + rate.Fee(txnFill*myOrder.price, true)
+				}
 
 				// determine if recentTxn crosses the order - updated for partial fills
 				if fillAmount != 0.0 {
@@ -128,7 +156,7 @@ func (sim *Simulator) SetTime(t time.Time) {
 						sim.myPortfolio.SetLockedBalance(p.Coin, currentLockedCoin-math.Abs(fillAmount))
 					}
 					sim.myPortfolio.AddBalance(p.Coin, fillAmount)
-					sim.myPortfolio.AddBalance(p.Base, -fillAmount*fillPrice)
+					sim.myPortfolio.AddBalance(p.Base, -fillAmount*fillPrice-fee)
 					newTxn := Transaction{
 						Pair:      p,
 						Price:     fillPrice,
@@ -136,6 +164,7 @@ func (sim *Simulator) SetTime(t time.Time) {
 						TimeStamp: sim.now,
 						Maker:     maker,
 						TxnID:     fmt.Sprint(len(sim.myTransactions)),
+						Fee:       fee,
 					}
 					sim.myTransactions = append(sim.myTransactions, newTxn)
 
@@ -285,10 +314,9 @@ func (sim Simulator) GetAccountOrders(pair Pair) []OrderStatus {
 	return sim.GetMyOrders(pair)
 }
 
-// TODO: Consider shift the fee to constructor and member variable
 func (sim Simulator) GetMakerFee(pair Pair) float64 {
-	return 0.001
+	return sim.feeRate().MakerBps / 1e4
 }
 func (sim Simulator) GetTakerFee(pair Pair) float64 {
-	return 0.001
+	return sim.feeRate().TakerBps / 1e4
 }
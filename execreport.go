@@ -0,0 +1,77 @@
+package bean
+
+import "time"
+
+// Liquidity tells whether a fill added liquidity to the book or took it
+type Liquidity string
+
+const (
+	LiquidityMaker Liquidity = "MAKER"
+	LiquidityTaker Liquidity = "TAKER"
+)
+
+// NameSim is the exchange of the fills of simulators
+const NameSim = "SIM"
+
+// ExecutionReport is a fill of one of our orders in the same form whichever exchange client or simulator reports
+// it, so that the blotter and analytics do not depend on where fills come from. Qty is positive, in the amount
+// units of the orders of the exchange (see CoinQty), and Fee is in FeeAsset
+type ExecutionReport struct {
+	Exchange   string // NameDeribit, NameBinance, NameSim...
+	OrderID    string // exchange id of the order
+	ClientID   string // our label of the order, if any
+	ExecID     string // exchange id of the fill
+	Instrument string
+	Pair       Pair
+	Side       Side
+	Price      float64
+	Qty        float64
+	Fee        float64
+	FeeAsset   Coin
+	Liquidity  Liquidity
+	Time       time.Time
+}
+
+// SignedQty returns the quantity, negative for sells
+func (r ExecutionReport) SignedQty() float64 {
+	if r.Side == SELL {
+		return -r.Qty
+	}
+	return r.Qty
+}
+
+// CoinQty returns the quantity in coins of the pair. Contracts of bean instruments are converted with their
+// multiplier, futures and perpetuals being worth a USD amount each, other instruments trade in coins
+func (r ExecutionReport) CoinQty() float64 {
+	return CoinAmount(r.Instrument, r.Qty, r.Price)
+}
+
+// CoinAmount converts an amount of an instrument traded at price to coins, as ExecutionReport.CoinQty
+func CoinAmount(instrument string, amount, price float64) float64 {
+	c, err := ContractFromName(instrument)
+	switch {
+	case err != nil:
+		return amount
+	case c.IsOption():
+		return amount * c.Multiplier()
+	case price > 0:
+		return amount * c.Multiplier() / price
+	}
+	return 0
+}
+
+// TradeLog returns the fill as a TradeLog of a quantity in coins
+func (r ExecutionReport) TradeLog() TradeLog {
+	return TradeLog{
+		OrderID:         r.OrderID,
+		Pair:            r.Pair,
+		Symbol:          r.Instrument,
+		Price:           r.Price,
+		Quantity:        r.CoinQty(),
+		Commission:      r.Fee,
+		CommissionAsset: r.FeeAsset,
+		Time:            r.Time,
+		Side:            r.Side,
+		TxnID:           r.ExecID,
+	}
+}
@@ -0,0 +1,202 @@
+// Package execution slices parent orders into child orders over time or size
+package execution
+
+import (
+	"bean"
+	"math"
+	"time"
+)
+
+// ChildOrder is a limit order sent by an execution algo. Amount is positive to buy
+type ChildOrder struct {
+	Time   time.Time
+	Price  float64
+	Amount float64
+}
+
+// Algo is an execution algorithm working a parent order
+type Algo interface {
+	Next(now time.Time, ob bean.OrderBook) (ChildOrder, bool) // Next returns the child order to send now, if any
+	OnFill(amount float64)                                    // OnFill records a (signed) fill of a child order
+	Remaining() float64                                       // Remaining returns the signed amount left to execute
+	Done() bool
+}
+
+// boundedPrice returns the price needed to execute amount in the book, capped at maxSlippage from the mid.
+// The amount is reduced to the liquidity available inside the cap
+func boundedPrice(ob bean.OrderBook, amount, maxSlippage float64) (price, size float64, ok bool) {
+	if !ob.Valid() || amount == 0 {
+		return
+	}
+	mid := ob.Mid()
+	var stack []bean.Order
+	var bound float64
+	if amount > 0 {
+		price, _ = ob.AskIn(math.Abs(amount))
+		bound = mid * (1 + maxSlippage)
+		price = math.Min(price, bound)
+		stack = ob.Asks()
+	} else {
+		price, _ = ob.BidIn(math.Abs(amount))
+		bound = mid * (1 - maxSlippage)
+		price = math.Max(price, bound)
+		stack = ob.Bids()
+	}
+	available := 0.0
+	for _, o := range stack {
+		if (amount > 0 && o.Price > price) || (amount < 0 && o.Price < price) {
+			break
+		}
+		available += o.Amount
+	}
+	if available == 0 {
+		return
+	}
+	size = math.Copysign(math.Min(math.Abs(amount), available), amount)
+	return price, size, true
+}
+
+// TWAP slices a parent order in equal parts over a time window
+type TWAP struct {
+	amount      float64
+	start, end  time.Time
+	slices      int
+	maxSlippage float64
+	executed    float64
+	sent        int
+}
+
+// NewTWAP returns a TWAP executing amount (positive to buy) in slices between start and end.
+// Each child order is priced to take liquidity but no further than maxSlippage (e.g. 0.001) from the mid
+func NewTWAP(amount float64, start, end time.Time, slices int, maxSlippage float64) *TWAP {
+	if slices < 1 {
+		slices = 1
+	}
+	return &TWAP{
+		amount:      amount,
+		start:       start,
+		end:         end,
+		slices:      slices,
+		maxSlippage: maxSlippage,
+	}
+}
+
+// Next sends a child order when a new slice is due. Amounts not executed in earlier slices are caught up
+func (a *TWAP) Next(now time.Time, ob bean.OrderBook) (ChildOrder, bool) {
+	if a.Done() || now.Before(a.start) {
+		return ChildOrder{}, false
+	}
+	interval := a.end.Sub(a.start) / time.Duration(a.slices)
+	due := a.slices
+	if interval > 0 {
+		due = int(now.Sub(a.start)/interval) + 1
+	}
+	if due > a.slices {
+		due = a.slices
+	}
+	if due <= a.sent {
+		return ChildOrder{}, false
+	}
+	a.sent = due
+	target := a.amount*float64(due)/float64(a.slices) - a.executed
+	price, size, ok := boundedPrice(ob, target, a.maxSlippage)
+	if !ok {
+		return ChildOrder{}, false
+	}
+	return ChildOrder{Time: now, Price: price, Amount: size}, true
+}
+
+func (a *TWAP) OnFill(amount float64) {
+	a.executed += amount
+}
+
+func (a *TWAP) Remaining() float64 {
+	return a.amount - a.executed
+}
+
+func (a *TWAP) Done() bool {
+	return math.Abs(a.Remaining()) < 1e-12
+}
+
+// Iceberg shows only a small part of the parent order at a time, sending the next child once the previous is filled
+type Iceberg struct {
+	amount      float64
+	display     float64
+	limit       float64
+	maxSlippage float64
+	executed    float64
+	working     float64 // amount of the child order in the market
+}
+
+// NewIceberg returns an iceberg executing amount (positive to buy) in children of at most display size, never paying
+// through limit (NaN for no limit) nor more than maxSlippage from the mid
+func NewIceberg(amount, display, limit, maxSlippage float64) *Iceberg {
+	return &Iceberg{
+		amount:      amount,
+		display:     math.Abs(display),
+		limit:       limit,
+		maxSlippage: maxSlippage,
+	}
+}
+
+func (a *Iceberg) Next(now time.Time, ob bean.OrderBook) (ChildOrder, bool) {
+	if a.Done() || math.Abs(a.working) > 1e-12 {
+		return ChildOrder{}, false
+	}
+	rem := a.Remaining()
+	target := math.Copysign(math.Min(math.Abs(rem), a.display), rem)
+	price, size, ok := boundedPrice(ob, target, a.maxSlippage)
+	if !ok {
+		return ChildOrder{}, false
+	}
+	if !math.IsNaN(a.limit) {
+		if (target > 0 && price > a.limit) || (target < 0 && price < a.limit) {
+			price = a.limit
+			size = target
+		}
+	}
+	a.working = size
+	return ChildOrder{Time: now, Price: price, Amount: size}, true
+}
+
+func (a *Iceberg) OnFill(amount float64) {
+	a.executed += amount
+	a.working -= amount
+}
+
+// Release frees the current child order (e.g. after it is cancelled) so the next one can be sent
+func (a *Iceberg) Release() {
+	a.working = 0
+}
+
+func (a *Iceberg) Remaining() float64 {
+	return a.amount - a.executed
+}
+
+func (a *Iceberg) Done() bool {
+	return math.Abs(a.Remaining()) < 1e-12
+}
+
+// Simulate works an algo over recorded orderbooks, filling each child order immediately against the book
+// with OrderBook.Match. Unfilled parts of child orders are treated as cancelled
+func Simulate(a Algo, obts bean.OrderBookTS) []ChildOrder {
+	var fills []ChildOrder
+	for _, ob := range obts {
+		child, ok := a.Next(ob.Time, ob.OrderBook)
+		if !ok {
+			continue
+		}
+		fill := ob.Match(bean.Order{Price: child.Price, Amount: child.Amount})
+		if fill.Amount != 0 {
+			a.OnFill(fill.Amount)
+			fills = append(fills, ChildOrder{Time: ob.Time, Price: fill.Price, Amount: fill.Amount})
+		}
+		if ice, isIceberg := a.(*Iceberg); isIceberg {
+			ice.Release()
+		}
+		if a.Done() {
+			break
+		}
+	}
+	return fills
+}
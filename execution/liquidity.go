@@ -0,0 +1,168 @@
+package execution
+
+import (
+	"bean"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// VenueLiquidity is the liquidity of an instrument on a venue: the spread and the depth within the band of its
+// latest book, and the trades over the window before the report. Amounts are in coins whatever the unit of the
+// venue, so that inverse contracts worth USD each compare with linear ones
+type VenueLiquidity struct {
+	Instrument string
+	Venue      string
+	Time       time.Time // of the book
+	Spread     float64
+	SpreadBps  float64 // of the mid
+	BidDepth   float64 // coins of the bids within the band of the mid
+	AskDepth   float64
+	Turnover   float64 // notional traded, coins times price: USD of inverse contracts, coins of option premiums
+	Trades     int
+}
+
+// Depth returns the coins of both sides within the band
+func (l VenueLiquidity) Depth() float64 {
+	return l.BidDepth + l.AskDepth
+}
+
+type liquidityKey struct {
+	instrument, venue string
+}
+
+// LiquiditySummary keeps the latest books and the recent trades of instruments on several venues, to compare
+// their liquidity for routing and monitoring. It is safe for concurrent use
+type LiquiditySummary struct {
+	DepthBps float64       // band either side of the mid the depth is summed over
+	Window   time.Duration // of the turnover
+	// Coins converts an amount of an instrument on a venue at a price to coins, bean.CoinAmount if nil
+	Coins func(instrument, venue string, amount, price float64) float64
+
+	m      sync.Mutex
+	books  map[liquidityKey]bean.OrderBookT
+	trades map[liquidityKey]bean.Transactions
+}
+
+// NewLiquiditySummary returns a summary of the depth within depthBps of the mid and the turnover over window
+func NewLiquiditySummary(depthBps float64, window time.Duration) *LiquiditySummary {
+	return &LiquiditySummary{
+		DepthBps: depthBps,
+		Window:   window,
+		books:    make(map[liquidityKey]bean.OrderBookT),
+		trades:   make(map[liquidityKey]bean.Transactions),
+	}
+}
+
+// SetBook sets the latest book of an instrument on a venue
+func (s *LiquiditySummary) SetBook(instrument, venue string, ob bean.OrderBookT) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.books[liquidityKey{instrument, venue}] = ob
+}
+
+// OnTrade records a trade of an instrument on a venue. Trades older than the window before the latest one are
+// discarded
+func (s *LiquiditySummary) OnTrade(instrument, venue string, t bean.Transaction) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	k := liquidityKey{instrument, venue}
+	ts := append(s.trades[k], t)
+	from := t.TimeStamp.Add(-s.Window)
+	i := 0
+	for i < len(ts) && ts[i].TimeStamp.Before(from) {
+		i++
+	}
+	s.trades[k] = ts[i:]
+}
+
+// Report returns the liquidity of every instrument and venue as of now, by instrument and deepest venue first.
+// Venues without a book have a NaN spread and no depth
+func (s *LiquiditySummary) Report(now time.Time) []VenueLiquidity {
+	s.m.Lock()
+	keys := make(map[liquidityKey]bool)
+	for k := range s.books {
+		keys[k] = true
+	}
+	for k := range s.trades {
+		keys[k] = true
+	}
+	var res []VenueLiquidity
+	for k := range keys {
+		res = append(res, s.venueLiquidity(k, now))
+	}
+	s.m.Unlock()
+	sortLiquidity(res)
+	return res
+}
+
+// Top returns the liquidity of an instrument on its n deepest venues as of now, all of them for n <= 0
+func (s *LiquiditySummary) Top(instrument string, n int, now time.Time) []VenueLiquidity {
+	var res []VenueLiquidity
+	for _, l := range s.Report(now) {
+		if l.Instrument == instrument {
+			res = append(res, l)
+		}
+	}
+	if n > 0 && len(res) > n {
+		res = res[:n]
+	}
+	return res
+}
+
+// sortLiquidity orders by instrument, then by depth, tighter spread and venue name
+func sortLiquidity(res []VenueLiquidity) {
+	sort.Slice(res, func(i, j int) bool {
+		a, b := res[i], res[j]
+		switch {
+		case a.Instrument != b.Instrument:
+			return a.Instrument < b.Instrument
+		case a.Depth() != b.Depth():
+			return a.Depth() > b.Depth()
+		case a.SpreadBps != b.SpreadBps && !math.IsNaN(a.SpreadBps) && !math.IsNaN(b.SpreadBps):
+			return a.SpreadBps < b.SpreadBps
+		}
+		return a.Venue < b.Venue
+	})
+}
+
+func (s *LiquiditySummary) coins(k liquidityKey, amount, price float64) float64 {
+	if s.Coins != nil {
+		return s.Coins(k.instrument, k.venue, amount, price)
+	}
+	return bean.CoinAmount(k.instrument, amount, price)
+}
+
+func (s *LiquiditySummary) venueLiquidity(k liquidityKey, now time.Time) VenueLiquidity {
+	l := VenueLiquidity{Instrument: k.instrument, Venue: k.venue, Spread: math.NaN(), SpreadBps: math.NaN()}
+	if ob, ok := s.books[k]; ok && ob.OrderBookCore != nil {
+		l.Time = ob.Time
+		if ob.Valid() {
+			mid := ob.Mid()
+			l.Spread = ob.Spread()
+			l.SpreadBps = l.Spread / mid * 1e4
+			band := mid * s.DepthBps / 1e4
+			for _, o := range ob.Bids() {
+				if o.Price < mid-band {
+					break
+				}
+				l.BidDepth += s.coins(k, o.Amount, o.Price)
+			}
+			for _, o := range ob.Asks() {
+				if o.Price > mid+band {
+					break
+				}
+				l.AskDepth += s.coins(k, o.Amount, o.Price)
+			}
+		}
+	}
+	from := now.Add(-s.Window)
+	for _, t := range s.trades[k] {
+		if t.TimeStamp.After(from) && !t.TimeStamp.After(now) {
+			l.Turnover += s.coins(k, math.Abs(t.Amount), t.Price) * t.Price
+			l.Trades++
+		}
+	}
+	return l
+}
@@ -0,0 +1,112 @@
+package execution
+
+import (
+	"bean"
+	"math"
+	"sort"
+	"sync"
+)
+
+// VenueOrder is the part of a routed order sent to one venue. Price is the limit price (the worst level taken),
+// AvgPrice the expected average fill price and Fee the expected taker fee in the quote coin
+type VenueOrder struct {
+	Venue    string
+	Price    float64
+	Amount   float64
+	AvgPrice float64
+	Fee      float64
+}
+
+// Router splits orders across the books of the same pair on several venues. It is safe for concurrent use
+type Router struct {
+	m     sync.RWMutex
+	books map[string]bean.OrderBook
+	fees  map[string]bean.FeeRate
+}
+
+func NewRouter() *Router {
+	return &Router{
+		books: make(map[string]bean.OrderBook),
+		fees:  make(map[string]bean.FeeRate),
+	}
+}
+
+// SetBook sets the latest orderbook of a venue
+func (r *Router) SetBook(venue string, ob bean.OrderBook) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.books[venue] = ob
+}
+
+// SetFee sets the fees of a venue, taker fees are included in the cost of routing
+func (r *Router) SetFee(venue string, fee bean.FeeRate) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.fees[venue] = fee
+}
+
+type venueLevel struct {
+	venue string
+	order bean.Order
+	cost  float64 // price per unit including fees, negated for sells so lower is always better
+}
+
+// Route returns the split of amount (positive to buy) across venues with the lowest total cost including fees,
+// taking the best levels of all books in turn. Returns the amount that could not be routed for lack of depth
+func (r *Router) Route(amount float64) (orders []VenueOrder, unfilled float64) {
+	r.m.RLock()
+	var levels []venueLevel
+	for venue, ob := range r.books {
+		fee := r.fees[venue].TakerBps / 1e4
+		stack := ob.Asks()
+		if amount < 0 {
+			stack = ob.Bids()
+		}
+		for _, o := range stack {
+			if amount > 0 {
+				levels = append(levels, venueLevel{venue, o, o.Price * (1 + fee)})
+			} else {
+				levels = append(levels, venueLevel{venue, o, -o.Price * (1 - fee)})
+			}
+		}
+	}
+	fees := make(map[string]bean.FeeRate, len(r.fees))
+	for k, v := range r.fees {
+		fees[k] = v
+	}
+	r.m.RUnlock()
+
+	sort.SliceStable(levels, func(i, j int) bool {
+		if levels[i].cost == levels[j].cost {
+			return levels[i].venue < levels[j].venue
+		}
+		return levels[i].cost < levels[j].cost
+	})
+
+	byVenue := make(map[string]*VenueOrder)
+	var venues []string
+	left := math.Abs(amount)
+	for _, l := range levels {
+		if left <= 0 {
+			break
+		}
+		take := math.Min(left, l.order.Amount)
+		vo, ok := byVenue[l.venue]
+		if !ok {
+			vo = &VenueOrder{Venue: l.venue}
+			byVenue[l.venue] = vo
+			venues = append(venues, l.venue)
+		}
+		filled := math.Abs(vo.Amount)
+		vo.AvgPrice = (vo.AvgPrice*filled + l.order.Price*take) / (filled + take)
+		vo.Amount = math.Copysign(filled+take, amount)
+		vo.Price = l.order.Price
+		left -= take
+	}
+	for _, v := range venues {
+		vo := byVenue[v]
+		vo.Fee = fees[v].Fee(vo.Amount*vo.AvgPrice, false)
+		orders = append(orders, *vo)
+	}
+	return orders, math.Copysign(left, amount)
+}
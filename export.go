@@ -0,0 +1,208 @@
+package bean
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// CSVColumn describes a column of an exported CSV file. Type is one of string, float64 and datetime (RFC3339 UTC),
+// matching the pandas dtypes to read it with
+type CSVColumn struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	Unit string `json:"unit,omitempty"`
+}
+
+// CSVSchema is the ordered list of columns of an export
+type CSVSchema []CSVColumn
+
+// WriteJSON writes the schema as a JSON array, to be kept next to the CSV file
+func (s CSVSchema) WriteJSON(w io.Writer) error {
+	return json.NewEncoder(w).Encode(s)
+}
+
+func (s CSVSchema) header() []string {
+	res := make([]string, len(s))
+	for i, c := range s {
+		res[i] = c.Name
+	}
+	return res
+}
+
+// PositionsSchema is the schema of WritePositionsCSV
+var PositionsSchema = CSVSchema{
+	{"instrument", "string", ""},
+	{"underlying", "string", ""},
+	{"expiry", "datetime", ""},
+	{"strike", "float64", "rhs"},
+	{"call_put", "string", ""},
+	{"qty", "float64", "contracts"},
+	{"entry", "float64", "lhs"},
+	{"spot", "float64", "rhs"},
+	{"future", "float64", "rhs"},
+	{"vol", "float64", ""},
+	{"pv", "float64", "rhs"},
+	{"delta", "float64", "lhs"},
+	{"gamma", "float64", "lhs per 1%"},
+	{"vega", "float64", "rhs per vol point"},
+	{"theta", "float64", "rhs per day"},
+}
+
+// GreeksLadderSchema is the schema of WriteGreeksLadderCSV
+var GreeksLadderSchema = CSVSchema{
+	{"expiry", "datetime", ""},
+	{"pv", "float64", "rhs"},
+	{"delta", "float64", "lhs"},
+	{"gamma", "float64", "lhs per 1%"},
+	{"vega", "float64", "rhs per vol point"},
+	{"theta", "float64", "rhs per day"},
+}
+
+// VolSurfaceSchema is the schema of WriteVolSurfaceCSV
+var VolSurfaceSchema = CSVSchema{
+	{"expiry", "datetime", ""},
+	{"years", "float64", "years"},
+	{"strike", "float64", "rhs"},
+	{"forward", "float64", "rhs"},
+	{"vol", "float64", ""},
+}
+
+// FootprintSchema is the schema of WriteFootprintCSV
+var FootprintSchema = CSVSchema{
+	{"start", "datetime", ""},
+	{"end", "datetime", ""},
+	{"price", "float64", "rhs"},
+	{"bid", "float64", "lhs"},
+	{"ask", "float64", "lhs"},
+	{"delta", "float64", "lhs"},
+}
+
+// csvFloat formats floats for pandas, with NaN and infinities left empty
+func csvFloat(x float64) string {
+	if math.IsNaN(x) || math.IsInf(x, 0) {
+		return ""
+	}
+	return strconv.FormatFloat(x, 'g', -1, 64)
+}
+
+func csvTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// contractExpiry is the expiry of dated contracts, zero for perpetuals and indices
+func contractExpiry(c *Contract) time.Time {
+	if c.Perp() || c.Index() {
+		return time.Time{}
+	}
+	return c.Expiry()
+}
+
+// WritePositionsCSV writes one row per position with its market and greeks, see PositionsSchema
+func WritePositionsCSV(w io.Writer, positions []Position, asof time.Time, mkt PositionMarket) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(PositionsSchema.header()); err != nil {
+		return err
+	}
+	for _, p := range positions {
+		spot, fut, vol := mkt(p)
+		g := p.Greeks(asof, spot, fut, vol)
+		strike, cp := math.NaN(), ""
+		if p.IsOption() {
+			strike, cp = p.Strike(), string(p.CallPut())
+		}
+		err := cw.Write([]string{p.Name(), p.Underlying().String(), csvTime(contractExpiry(p.Contract)),
+			csvFloat(strike), cp, csvFloat(p.Qty()), csvFloat(p.Price()), csvFloat(spot), csvFloat(fut),
+			csvFloat(vol), csvFloat(g.PV), csvFloat(g.Delta), csvFloat(g.Gamma), csvFloat(g.Vega), csvFloat(g.Theta)})
+		if err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteGreeksLadderCSV writes the greeks of the positions summed by expiry, in expiry order with perpetuals and
+// indices (no expiry) first, see GreeksLadderSchema
+func WriteGreeksLadderCSV(w io.Writer, positions []Position, asof time.Time, mkt PositionMarket) error {
+	ladder := make(map[time.Time]Greeks)
+	for _, p := range positions {
+		spot, fut, vol := mkt(p)
+		e := contractExpiry(p.Contract)
+		ladder[e] = ladder[e].Add(p.Greeks(asof, spot, fut, vol))
+	}
+	expiries := make([]time.Time, 0, len(ladder))
+	for e := range ladder {
+		expiries = append(expiries, e)
+	}
+	sort.Slice(expiries, func(i, j int) bool { return expiries[i].Before(expiries[j]) })
+
+	cw := csv.NewWriter(w)
+	if err := cw.Write(GreeksLadderSchema.header()); err != nil {
+		return err
+	}
+	for _, e := range expiries {
+		g := ladder[e]
+		err := cw.Write([]string{csvTime(e), csvFloat(g.PV), csvFloat(g.Delta), csvFloat(g.Gamma), csvFloat(g.Vega),
+			csvFloat(g.Theta)})
+		if err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteVolSurfaceCSV writes the vols of a surface on a grid of expiries and strikes in long format (one row per
+// point), see VolSurfaceSchema. forward gives the forward of each expiry, e.g. FuturesCurve.Forward
+func WriteVolSurfaceCSV(w io.Writer, vs VolSurface, asof time.Time, expiries []time.Time, strikes []float64,
+	forward func(time.Time) float64) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(VolSurfaceSchema.header()); err != nil {
+		return err
+	}
+	for _, e := range expiries {
+		f := forward(e)
+		years := e.Sub(asof).Hours() / 24.0 / 365.0
+		for _, k := range strikes {
+			err := cw.Write([]string{csvTime(e), csvFloat(years), csvFloat(k), csvFloat(f), csvFloat(vs.Vol(e, k, f))})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteFootprintCSV writes the volume of footprint bars in long format (one row per bar and price level), see
+// FootprintSchema. A VolumeProfile is written as the Levels of one bar
+func WriteFootprintCSV(w io.Writer, bars []FootprintBar) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(FootprintSchema.header()); err != nil {
+		return err
+	}
+	for _, b := range bars {
+		for _, v := range b.Levels {
+			err := cw.Write([]string{csvTime(b.Start), csvTime(b.End), csvFloat(v.Price), csvFloat(v.Bid),
+				csvFloat(v.Ask), csvFloat(v.Delta())})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteFootprintJSON writes footprint bars as a JSON array
+func WriteFootprintJSON(w io.Writer, bars []FootprintBar) error {
+	return json.NewEncoder(w).Encode(bars)
+}
@@ -0,0 +1,180 @@
+package bean
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// FairValueMethod is how a FairValue smooths its observations
+type FairValueMethod int
+
+const (
+	FairValueEWMA   FairValueMethod = iota // move by a fixed fraction of each observation's deviation
+	FairValueKalman                        // random walk Kalman filter weighting observations by their variance
+)
+
+// FairValueSource is where an observation of the fair value comes from
+type FairValueSource int
+
+const (
+	SourceBook      FairValueSource = iota // microprice of the book
+	SourceTrade                            // price of a trade
+	SourceReference                        // reference price of another venue or index
+)
+
+// FairValueParams configures a FairValue. Weights and variances are per source, a zero weight (EWMA) or
+// variance (Kalman) ignores the source
+type FairValueParams struct {
+	Method FairValueMethod
+
+	// EWMA: a new observation moves the value by Alpha times the weight of its source, Alpha in (0, 1]
+	Alpha           float64
+	BookWeight      float64
+	TradeWeight     float64
+	ReferenceWeight float64
+
+	// Kalman: variance of the fair value per second and of each source's observations, in price squared
+	ProcessVar   float64
+	BookVar      float64
+	TradeVar     float64
+	ReferenceVar float64
+
+	MaxDeviation    float64       // ignore observations further than this fraction from the value, zero for no limit
+	MaxRejections   int           // reset the value to the observation after this many outliers in a row, zero never
+	MaxReferenceAge time.Duration // ignore reference prices older than this before the latest observation, zero for no limit
+}
+
+// DefaultFairValueParams returns an EWMA mostly following the microprice
+func DefaultFairValueParams() FairValueParams {
+	return FairValueParams{
+		Method:          FairValueEWMA,
+		Alpha:           0.5,
+		BookWeight:      1,
+		TradeWeight:     0.3,
+		ReferenceWeight: 0.2,
+		MaxDeviation:    0.05,
+		MaxRejections:   10,
+	}
+}
+
+// FairValue is a smoothed internal reference price of an instrument built from its book, its trades and a
+// reference price from other venues, for quoting and arbitrage. It is safe for concurrent use
+type FairValue struct {
+	FairValueParams
+
+	m        sync.Mutex
+	value    float64
+	variance float64
+	time     time.Time
+	rejected int // outliers in a row
+}
+
+// NewFairValue returns an estimator with params p and no value yet
+func NewFairValue(p FairValueParams) *FairValue {
+	return &FairValue{FairValueParams: p, value: math.NaN(), variance: math.NaN()}
+}
+
+// OnBook updates the value with the microprice of a book and returns it
+func (f *FairValue) OnBook(t time.Time, ob *OrderBook) float64 {
+	if ob == nil || ob.OrderBookCore == nil {
+		return f.Value()
+	}
+	return f.Observe(t, ob.MicroPrice(), SourceBook)
+}
+
+// OnTrade updates the value with the price of a trade and returns it
+func (f *FairValue) OnTrade(tr Transaction) float64 {
+	return f.Observe(tr.TimeStamp, tr.Price, SourceTrade)
+}
+
+// OnReference updates the value with a reference price observed at t and returns it. Its age is measured from the
+// time of the latest observation, so replays filter as live runs do
+func (f *FairValue) OnReference(t time.Time, price float64) float64 {
+	return f.Observe(t, price, SourceReference)
+}
+
+// Observe updates the value with a price from a source and returns it. Invalid prices, outliers and stale reference
+// prices are ignored, the first valid price of a weighted source sets the value. After MaxRejections outliers in a
+// row the value is reset to the last of them, as the price has moved rather than printed a bad tick
+func (f *FairValue) Observe(t time.Time, price float64, src FairValueSource) float64 {
+	f.m.Lock()
+	defer f.m.Unlock()
+	weight, variance := f.source(src)
+	if !validPrice(price) || (f.Method == FairValueKalman && !(variance > 0)) || (f.Method == FairValueEWMA && !(weight > 0)) {
+		return f.value
+	}
+	if src == SourceReference && f.MaxReferenceAge > 0 && f.time.Sub(t) > f.MaxReferenceAge {
+		return f.value
+	}
+	if math.IsNaN(f.value) {
+		f.value, f.variance, f.time = price, variance, t
+		return f.value
+	}
+	if f.MaxDeviation > 0 && math.Abs(price/f.value-1) > f.MaxDeviation {
+		if f.rejected++; f.MaxRejections <= 0 || f.rejected < f.MaxRejections {
+			return f.value
+		}
+		f.value, f.variance, f.rejected = price, variance, 0
+		if t.After(f.time) {
+			f.time = t
+		}
+		return f.value
+	}
+	f.rejected = 0
+	switch f.Method {
+	case FairValueKalman:
+		if dt := t.Sub(f.time).Seconds(); dt > 0 {
+			f.variance += f.ProcessVar * dt
+		}
+		k := f.variance / (f.variance + variance)
+		f.value += k * (price - f.value)
+		f.variance *= 1 - k
+	default:
+		f.value += math.Min(1, f.Alpha*weight) * (price - f.value)
+	}
+	if t.After(f.time) {
+		f.time = t
+	}
+	return f.value
+}
+
+func (f *FairValue) source(src FairValueSource) (weight, variance float64) {
+	switch src {
+	case SourceBook:
+		return f.BookWeight, f.BookVar
+	case SourceTrade:
+		return f.TradeWeight, f.TradeVar
+	case SourceReference:
+		return f.ReferenceWeight, f.ReferenceVar
+	}
+	return 0, 0
+}
+
+// Value returns the current fair value, NaN before the first observation
+func (f *FairValue) Value() float64 {
+	f.m.Lock()
+	defer f.m.Unlock()
+	return f.value
+}
+
+// Variance returns the Kalman variance of the value in price squared, as of the last observation
+func (f *FairValue) Variance() float64 {
+	f.m.Lock()
+	defer f.m.Unlock()
+	return f.variance
+}
+
+// Time returns the time of the latest observation used
+func (f *FairValue) Time() time.Time {
+	f.m.Lock()
+	defer f.m.Unlock()
+	return f.time
+}
+
+// Reset forgets the value
+func (f *FairValue) Reset() {
+	f.m.Lock()
+	defer f.m.Unlock()
+	f.value, f.variance, f.time, f.rejected = math.NaN(), math.NaN(), time.Time{}, 0
+}
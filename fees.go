@@ -0,0 +1,134 @@
+package bean
+
+import (
+	"math"
+	"strings"
+	"sync"
+)
+
+// InstrumentClass groups instruments charged the same fees
+type InstrumentClass string
+
+const (
+	ClassSpot   InstrumentClass = "SPOT"
+	ClassFuture InstrumentClass = "FUTURE"
+	ClassPerp   InstrumentClass = "PERPETUAL"
+	ClassOption InstrumentClass = "OPTION"
+)
+
+// ContractClass returns the instrument class of a contract
+func ContractClass(c *Contract) InstrumentClass {
+	switch {
+	case c.IsOption():
+		return ClassOption
+	case c.Perp():
+		return ClassPerp
+	case c.Index():
+		return ClassSpot
+	default:
+		return ClassFuture
+	}
+}
+
+// FeeRate holds the fees of an instrument class in basis points of the traded notional. For options the notional is
+// that of the underlying and the fee is capped at OptionCap times the premium, as on deribit.
+// Negative maker fees are rebates
+type FeeRate struct {
+	MakerBps      float64
+	TakerBps      float64
+	SettlementBps float64 // charged on expiry of options
+	DeliveryBps   float64 // charged on delivery of futures
+	OptionCap     float64 // cap on option fees as a proportion of the premium, zero for no cap
+}
+
+// Fee returns the trading fee on a notional, a maker fee if maker is true
+func (r FeeRate) Fee(notional float64, maker bool) float64 {
+	if maker {
+		return math.Abs(notional) * r.MakerBps / 1e4
+	}
+	return math.Abs(notional) * r.TakerBps / 1e4
+}
+
+// OptionFee returns the trading fee on an option given the underlying notional and the premium traded
+func (r FeeRate) OptionFee(underlyingNotional, premium float64, maker bool) float64 {
+	fee := r.Fee(underlyingNotional, maker)
+	if r.OptionCap > 0 {
+		fee = math.Min(fee, r.OptionCap*math.Abs(premium))
+	}
+	return fee
+}
+
+// ExpiryFee returns the settlement or delivery fee of a contract on the notional at expiry
+func (r FeeRate) ExpiryFee(c *Contract, notional float64) float64 {
+	if c.IsOption() {
+		return math.Abs(notional) * r.SettlementBps / 1e4
+	}
+	return math.Abs(notional) * r.DeliveryBps / 1e4
+}
+
+// FeeSchedule holds the fee rates per exchange and instrument class. It is safe for concurrent use
+type FeeSchedule struct {
+	m     sync.RWMutex
+	rates map[string]map[InstrumentClass]FeeRate
+}
+
+func NewFeeSchedule() *FeeSchedule {
+	return &FeeSchedule{rates: make(map[string]map[InstrumentClass]FeeRate)}
+}
+
+// DefaultFeeSchedule returns the standard (lowest tier) fees of the main exchanges
+func DefaultFeeSchedule() *FeeSchedule {
+	fs := NewFeeSchedule()
+	fs.Set(NameDeribit, ClassFuture, FeeRate{MakerBps: 0, TakerBps: 5, DeliveryBps: 2.5})
+	fs.Set(NameDeribit, ClassPerp, FeeRate{MakerBps: 0, TakerBps: 5})
+	fs.Set(NameDeribit, ClassOption, FeeRate{MakerBps: 3, TakerBps: 3, SettlementBps: 1.5, OptionCap: 0.125})
+	fs.Set(NameBinance, ClassSpot, FeeRate{MakerBps: 10, TakerBps: 10})
+	fs.Set(NameBinance, ClassFuture, FeeRate{MakerBps: 2, TakerBps: 5})
+	fs.Set(NameBinance, ClassPerp, FeeRate{MakerBps: 2, TakerBps: 5})
+	fs.Set(NameBinance, ClassOption, FeeRate{MakerBps: 3, TakerBps: 3, SettlementBps: 1.5, OptionCap: 0.1})
+	fs.Set(NameOKX, ClassSpot, FeeRate{MakerBps: 8, TakerBps: 10})
+	fs.Set(NameOKX, ClassFuture, FeeRate{MakerBps: 2, TakerBps: 5})
+	fs.Set(NameOKX, ClassPerp, FeeRate{MakerBps: 2, TakerBps: 5})
+	fs.Set(NameOKX, ClassOption, FeeRate{MakerBps: 2, TakerBps: 3, OptionCap: 0.07})
+	return fs
+}
+
+func (fs *FeeSchedule) Set(exName string, class InstrumentClass, r FeeRate) {
+	fs.m.Lock()
+	defer fs.m.Unlock()
+	exName = strings.ToUpper(exName)
+	if _, ok := fs.rates[exName]; !ok {
+		fs.rates[exName] = make(map[InstrumentClass]FeeRate)
+	}
+	fs.rates[exName][class] = r
+}
+
+// Rate returns the fees of an exchange and instrument class, zero fees if none are set
+func (fs *FeeSchedule) Rate(exName string, class InstrumentClass) FeeRate {
+	r, _ := fs.Lookup(exName, class)
+	return r
+}
+
+// Lookup returns the fees of an exchange and instrument class, false if none are set
+func (fs *FeeSchedule) Lookup(exName string, class InstrumentClass) (FeeRate, bool) {
+	if fs == nil {
+		return FeeRate{}, false
+	}
+	fs.m.RLock()
+	defer fs.m.RUnlock()
+	r, ok := fs.rates[strings.ToUpper(exName)][class]
+	return r, ok
+}
+
+// ContractRate returns the fees of a contract on an exchange
+func (fs *FeeSchedule) ContractRate(exName string, c *Contract) FeeRate {
+	return fs.Rate(exName, ContractClass(c))
+}
+
+// MatchWithFee matches an order against the orderbook as Match does, and also returns the taker fee on the fill
+// in the quote coin
+func (ob OrderBook) MatchWithFee(placedOrder Order, r FeeRate) (fill Order, fee float64) {
+	fill = ob.Match(placedOrder)
+	fee = r.Fee(fill.Amount*fill.Price, false)
+	return
+}
@@ -0,0 +1,235 @@
+package bean
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// PriceVolume is the volume traded at a price level, split by the side of the book the aggressor hit: Bid for
+// sells, Ask for buys
+type PriceVolume struct {
+	Price float64 `json:"price"`
+	Bid   float64 `json:"bid"`
+	Ask   float64 `json:"ask"`
+}
+
+func (v PriceVolume) Total() float64 {
+	return v.Bid + v.Ask
+}
+
+// Delta returns the buying less the selling volume
+func (v PriceVolume) Delta() float64 {
+	return v.Ask - v.Bid
+}
+
+// VolumeProfile is the volume at price levels, in increasing order of prices
+type VolumeProfile []PriceVolume
+
+// Volume returns the total volume of the profile
+func (p VolumeProfile) Volume() float64 {
+	sum := 0.0
+	for _, v := range p {
+		sum += v.Total()
+	}
+	return sum
+}
+
+// Delta returns the buying less the selling volume of the profile
+func (p VolumeProfile) Delta() float64 {
+	sum := 0.0
+	for _, v := range p {
+		sum += v.Delta()
+	}
+	return sum
+}
+
+// POC returns the point of control, the price of the level of most volume and the lowest one on a tie. NaN for an
+// empty profile
+func (p VolumeProfile) POC() float64 {
+	poc, best := math.NaN(), -1.0
+	for _, v := range p {
+		if v.Total() > best {
+			poc, best = v.Price, v.Total()
+		}
+	}
+	return poc
+}
+
+// ValueArea returns the range of prices around the point of control holding a share of the volume, e.g. 0.7,
+// growing it one level at a time towards the side of more volume. NaN for an empty profile
+func (p VolumeProfile) ValueArea(share float64) (low, high float64) {
+	if len(p) == 0 {
+		return math.NaN(), math.NaN()
+	}
+	poc := 0
+	for i, v := range p {
+		if v.Total() > p[poc].Total() {
+			poc = i
+		}
+	}
+	lo, hi := poc, poc
+	target, sum := share*p.Volume(), p[poc].Total()
+	for sum < target && (lo > 0 || hi < len(p)-1) {
+		below, above := -1.0, -1.0
+		if lo > 0 {
+			below = p[lo-1].Total()
+		}
+		if hi < len(p)-1 {
+			above = p[hi+1].Total()
+		}
+		if above > below {
+			hi++
+			sum += above
+		} else {
+			lo--
+			sum += below
+		}
+	}
+	return p[lo].Price, p[hi].Price
+}
+
+// FootprintBar is the trades of a time bucket: their prices and the volume at each price level by side
+type FootprintBar struct {
+	Start  time.Time     `json:"start"`
+	End    time.Time     `json:"end"`
+	Open   float64       `json:"open"`
+	High   float64       `json:"high"`
+	Low    float64       `json:"low"`
+	Close  float64       `json:"close"`
+	Trades int           `json:"trades"`
+	Levels VolumeProfile `json:"levels"`
+}
+
+// footprintBuilder is the open bar of a Footprint
+type footprintBuilder struct {
+	bar    FootprintBar
+	levels map[Ticks]*PriceVolume
+}
+
+func (b *footprintBuilder) close() FootprintBar {
+	bar := b.bar
+	bar.Levels = make(VolumeProfile, 0, len(b.levels))
+	for _, v := range b.levels {
+		bar.Levels = append(bar.Levels, *v)
+	}
+	sort.Slice(bar.Levels, func(i, j int) bool { return bar.Levels[i].Price < bar.Levels[j].Price })
+	return bar
+}
+
+// Footprint aggregates a trade tape into footprint bars of a time interval, the volume of each bar at price levels
+// of a scale by side, and into the volume profile of the whole tape. A bar closes on the first trade after its
+// end or on Flush. It is safe for concurrent use
+type Footprint struct {
+	Interval time.Duration
+	Scale    PriceScale // price levels, NewPriceScale(10) for levels 10 apart
+
+	m       sync.Mutex
+	bars    []FootprintBar
+	open    *footprintBuilder
+	profile map[Ticks]*PriceVolume
+	onBar   []func(FootprintBar)
+}
+
+// NewFootprint returns a footprint of bars of interval with price levels width apart, whole units for a width of
+// zero
+func NewFootprint(interval time.Duration, width float64) *Footprint {
+	return &Footprint{Interval: interval, Scale: NewPriceScale(width), profile: make(map[Ticks]*PriceVolume)}
+}
+
+// OnBar registers a function called with each bar as it closes
+func (f *Footprint) OnBar(fn func(FootprintBar)) {
+	f.m.Lock()
+	defer f.m.Unlock()
+	f.onBar = append(f.onBar, fn)
+}
+
+// OnTrade adds a trade. Trades before the open bar are ignored
+func (f *Footprint) OnTrade(t Transaction) {
+	f.m.Lock()
+	start := t.TimeStamp.Truncate(f.Interval)
+	if f.open != nil && start.Before(f.open.bar.Start) {
+		f.m.Unlock()
+		return
+	}
+	var closed []FootprintBar
+	if f.open != nil && start.After(f.open.bar.Start) {
+		closed = append(closed, f.closeBar())
+	}
+	if f.open == nil {
+		f.open = &footprintBuilder{
+			bar:    FootprintBar{Start: start, End: start.Add(f.Interval), Open: t.Price, High: t.Price, Low: t.Price},
+			levels: make(map[Ticks]*PriceVolume),
+		}
+	}
+	b := &f.open.bar
+	b.High, b.Low, b.Close = math.Max(b.High, t.Price), math.Min(b.Low, t.Price), t.Price
+	b.Trades++
+	ticks := f.Scale.Ticks(t.Price)
+	for _, levels := range []map[Ticks]*PriceVolume{f.open.levels, f.profile} {
+		v, ok := levels[ticks]
+		if !ok {
+			v = &PriceVolume{Price: f.Scale.Price(ticks)}
+			levels[ticks] = v
+		}
+		if t.Maker == Buyer {
+			v.Bid += math.Abs(t.Amount)
+		} else {
+			v.Ask += math.Abs(t.Amount)
+		}
+	}
+	fns := f.onBar
+	f.m.Unlock()
+	for _, bar := range closed {
+		for _, fn := range fns {
+			fn(bar)
+		}
+	}
+}
+
+// closeBar moves the open bar to the closed ones and returns it
+func (f *Footprint) closeBar() FootprintBar {
+	bar := f.open.close()
+	f.bars = append(f.bars, bar)
+	f.open = nil
+	return bar
+}
+
+// Flush closes the open bar, if any
+func (f *Footprint) Flush() {
+	f.m.Lock()
+	if f.open == nil {
+		f.m.Unlock()
+		return
+	}
+	bar := f.closeBar()
+	fns := f.onBar
+	f.m.Unlock()
+	for _, fn := range fns {
+		fn(bar)
+	}
+}
+
+// Bars returns the closed bars and the open one, in time order
+func (f *Footprint) Bars() []FootprintBar {
+	f.m.Lock()
+	defer f.m.Unlock()
+	res := append([]FootprintBar(nil), f.bars...)
+	if f.open != nil {
+		res = append(res, f.open.close())
+	}
+	return res
+}
+
+// Profile returns the volume profile of all the trades added
+func (f *Footprint) Profile() VolumeProfile {
+	f.m.Lock()
+	defer f.m.Unlock()
+	res := make(VolumeProfile, 0, len(f.profile))
+	for _, v := range f.profile {
+		res = append(res, *v)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Price < res[j].Price })
+	return res
+}
@@ -0,0 +1,175 @@
+package bean
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// FuturesCurve holds the dated futures and the perpetual of one underlying with the spot price, and interpolates
+// a forward for any date. Annualized basis is continuously compounded: ln(F/S)/T
+type FuturesCurve struct {
+	underlying Pair
+	asof       time.Time
+	spot       float64
+	perp       float64
+	futures    []curvePoint // dated futures sorted by expiry
+}
+
+type curvePoint struct {
+	contract *Contract
+	price    float64
+}
+
+// CurveTenor describes one dated future on the curve
+type CurveTenor struct {
+	Contract    *Contract
+	Price       float64
+	Years       float64
+	Basis       float64 // F - S
+	AnnualBasis float64 // ln(F/S)/T
+}
+
+// NewFuturesCurve returns a curve with only the spot price. The perpetual is NaN until set
+func NewFuturesCurve(underlying Pair, asof time.Time, spotPrice float64) *FuturesCurve {
+	return &FuturesCurve{
+		underlying: underlying,
+		asof:       asof,
+		spot:       spotPrice,
+		perp:       math.NaN(),
+	}
+}
+
+func (fc *FuturesCurve) Underlying() Pair {
+	return fc.underlying
+}
+
+func (fc *FuturesCurve) Asof() time.Time {
+	return fc.asof
+}
+
+func (fc *FuturesCurve) Spot() float64 {
+	return fc.spot
+}
+
+// Perp returns the perpetual price, NaN if not set
+func (fc *FuturesCurve) Perp() float64 {
+	return fc.perp
+}
+
+// SetFuture sets the price of a dated future or the perpetual. Expired futures are ignored
+func (fc *FuturesCurve) SetFuture(c *Contract, price float64) {
+	if c.Perp() {
+		fc.perp = price
+		return
+	}
+	if !c.IsFuture() || !c.Expiry().After(fc.asof) {
+		return
+	}
+	for i, p := range fc.futures {
+		if p.contract.Expiry().Equal(c.Expiry()) {
+			fc.futures[i].price = price
+			return
+		}
+	}
+	fc.futures = append(fc.futures, curvePoint{c, price})
+	sort.Slice(fc.futures, func(i, j int) bool {
+		return fc.futures[i].contract.Expiry().Before(fc.futures[j].contract.Expiry())
+	})
+}
+
+// Futures returns the dated futures on the curve by expiry
+func (fc *FuturesCurve) Futures() []*Contract {
+	cons := make([]*Contract, len(fc.futures))
+	for i, p := range fc.futures {
+		cons[i] = p.contract
+	}
+	return cons
+}
+
+// Price returns the price of a future on the curve (or the perpetual)
+func (fc *FuturesCurve) Price(c *Contract) (float64, bool) {
+	if c.Perp() {
+		return fc.perp, !math.IsNaN(fc.perp)
+	}
+	for _, p := range fc.futures {
+		if p.contract.Expiry().Equal(c.Expiry()) {
+			return p.price, true
+		}
+	}
+	return math.NaN(), false
+}
+
+func (fc *FuturesCurve) years(t time.Time) float64 {
+	return t.Sub(fc.asof).Hours() / 24.0 / 365.0
+}
+
+// Tenors returns the basis of each dated future on the curve
+func (fc *FuturesCurve) Tenors() []CurveTenor {
+	tenors := make([]CurveTenor, len(fc.futures))
+	for i, p := range fc.futures {
+		years := fc.years(p.contract.Expiry())
+		tenors[i] = CurveTenor{
+			Contract:    p.contract,
+			Price:       p.price,
+			Years:       years,
+			Basis:       p.price - fc.spot,
+			AnnualBasis: math.Log(p.price/fc.spot) / years,
+		}
+	}
+	return tenors
+}
+
+// AnnualBasis returns the annualized basis of the curve to a date, interpolated between futures
+func (fc *FuturesCurve) AnnualBasis(t time.Time) float64 {
+	years := fc.years(t)
+	if years <= 0 || len(fc.futures) == 0 {
+		return 0
+	}
+	return math.Log(fc.Forward(t)/fc.spot) / years
+}
+
+// Forward returns the forward price for delivery at t. The total log basis ln(F/S) is interpolated linearly in
+// time between spot (at asof) and the futures, and extrapolated at the annualized basis of the last future.
+// Returns spot when there are no dated futures
+func (fc *FuturesCurve) Forward(t time.Time) float64 {
+	years := fc.years(t)
+	if years <= 0 || len(fc.futures) == 0 {
+		return fc.spot
+	}
+	prevYears, prevLog := 0.0, 0.0
+	for _, p := range fc.futures {
+		y := fc.years(p.contract.Expiry())
+		l := math.Log(p.price / fc.spot)
+		if years <= y {
+			return fc.spot * math.Exp(prevLog+(l-prevLog)*(years-prevYears)/(y-prevYears))
+		}
+		prevYears, prevLog = y, l
+	}
+	return fc.spot * math.Exp(prevLog/prevYears*years)
+}
+
+// CalendarSpread returns the price of buying the far future and selling the near one
+func (fc *FuturesCurve) CalendarSpread(near, far *Contract) float64 {
+	return fc.Forward(far.Expiry()) - fc.Forward(near.Expiry())
+}
+
+// RollDown returns the change in price of a future over horizon if the curve is unchanged, i.e. the future then
+// trades at today's forward for its shorter remaining tenor
+func (fc *FuturesCurve) RollDown(c *Contract, horizon time.Duration) float64 {
+	price, ok := fc.Price(c)
+	if !ok {
+		price = fc.Forward(c.Expiry())
+	}
+	return fc.Forward(c.Expiry().Add(-horizon)) - price
+}
+
+// OptPriceCurve returns the price of an option in RHS coin value spot using the forward of the curve for its expiry
+func (c Contract) OptPriceCurve(fc *FuturesCurve, vol float64) (float64, error) {
+	return c.OptPrice(fc.Asof(), fc.Spot(), fc.Forward(c.Expiry()), vol)
+}
+
+// ImpVolCurve returns the implied vol of an option given its price in LHS coin using the forward of the curve
+func (c Contract) ImpVolCurve(fc *FuturesCurve, optionPrice float64) (float64, error) {
+	return c.ImpVol(fc.Asof(), fc.Spot(), fc.Forward(c.Expiry()), optionPrice)
+}
@@ -0,0 +1,102 @@
+package bean
+
+import (
+	"fmt"
+	"math"
+)
+
+// GEXPoint is the gamma exposure of the dealers at a spot level: the change of their delta for a 1% move of spot,
+// in quote currency. Positive exposure has dealers selling rallies and buying dips to hedge, damping moves
+type GEXPoint struct {
+	Spot  float64
+	Calls float64
+	Puts  float64
+}
+
+func (p GEXPoint) Total() float64 {
+	return p.Calls + p.Puts
+}
+
+// GEXProfile is the gamma exposure of the dealers across spot levels, in increasing order of spot
+type GEXProfile []GEXPoint
+
+// GammaExposure returns the gamma exposure of the dealers to the open interest of the options of the chain at spot
+// levels, by the usual convention of dealers long the calls and short the puts. Forwards move with spot, vols stay
+// at those of the market for each strike
+func (ch *OptionChain) GammaExposure(m *Market, spots []float64) (GEXProfile, error) {
+	asof, spot := m.Asof(), m.Spot(ch.Underlying)
+	if !validPrice(spot) {
+		return nil, fmt.Errorf("%w: no %s spot price", ErrInvalidInput, ch.Underlying)
+	}
+	type quote struct {
+		Position
+		forward, vol, sign float64
+	}
+	var quotes []quote
+	for _, q := range ch.Quotes {
+		c := q.Contract
+		if math.IsNaN(q.OpenInterest) || q.OpenInterest == 0 || !c.Expiry().After(asof) {
+			continue
+		}
+		sign := 1.0
+		if c.CallPut() == Put {
+			sign = -1
+		}
+		quotes = append(quotes, quote{Position: NewPosition(c, q.OpenInterest, 0), forward: m.Forward(c),
+			vol: m.Vol(c), sign: sign})
+	}
+	res := make(GEXProfile, len(spots))
+	for i, s := range spots {
+		res[i].Spot = s
+		for _, q := range quotes {
+			gex := q.sign * q.Gamma(asof, s, q.forward*s/spot, q.vol) * s
+			if q.sign > 0 {
+				res[i].Calls += gex
+			} else {
+				res[i].Puts += gex
+			}
+		}
+	}
+	return res, nil
+}
+
+// SpotLevels returns n spot levels evenly spaced within a fraction either side of spot, for GammaExposure
+func SpotLevels(spot, width float64, n int) []float64 {
+	if n < 2 {
+		return []float64{spot}
+	}
+	res := make([]float64, n)
+	for i := range res {
+		res[i] = spot * (1 - width + 2*width*float64(i)/float64(n-1))
+	}
+	return res
+}
+
+// ZeroGamma returns the spot level where the total exposure changes sign, interpolated linearly between the
+// levels of the profile, the first one going up. NaN if it does not change sign
+func (p GEXProfile) ZeroGamma() float64 {
+	for i := 1; i < len(p); i++ {
+		a, b := p[i-1].Total(), p[i].Total()
+		if a == 0 {
+			return p[i-1].Spot
+		}
+		if a*b < 0 || b == 0 {
+			return p[i-1].Spot + (p[i].Spot-p[i-1].Spot)*a/(a-b)
+		}
+	}
+	return math.NaN()
+}
+
+// At returns the point of the profile nearest a spot level, false for an empty profile
+func (p GEXProfile) At(spot float64) (GEXPoint, bool) {
+	if len(p) == 0 {
+		return GEXPoint{}, false
+	}
+	best := p[0]
+	for _, q := range p[1:] {
+		if math.Abs(q.Spot-spot) < math.Abs(best.Spot-spot) {
+			best = q
+		}
+	}
+	return best, true
+}
@@ -0,0 +1,198 @@
+package bean
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// GreekName names a greek of Greeks
+type GreekName string
+
+const (
+	GreekDelta GreekName = "DELTA"
+	GreekGamma GreekName = "GAMMA"
+	GreekVega  GreekName = "VEGA"
+	GreekTheta GreekName = "THETA"
+)
+
+// Of returns the greek of g, false for an unknown name
+func (n GreekName) Of(g Greeks) (float64, bool) {
+	switch n {
+	case GreekDelta:
+		return g.Delta, true
+	case GreekGamma:
+		return g.Gamma, true
+	case GreekVega:
+		return g.Vega, true
+	case GreekTheta:
+		return g.Theta, true
+	}
+	return 0, false
+}
+
+// GreekTarget bounds a greek of the hedged portfolio
+type GreekTarget struct {
+	Greek    GreekName
+	Min, Max float64
+}
+
+// Neutral returns the target of a greek within band either side of zero
+func Neutral(greek GreekName, band float64) GreekTarget {
+	return GreekTarget{Greek: greek, Min: -band, Max: band}
+}
+
+// Tradable is a contract that can be traded to hedge, at the prices of its book
+type Tradable struct {
+	Contract *Contract
+	Book     OrderBook
+}
+
+// HedgeTrade is a trade proposed by a GreekOptimizer, in contracts
+type HedgeTrade struct {
+	Contract *Contract
+	Amount   float64 // positive to buy
+	Price    float64 // average price walking the book
+	Cost     float64 // against the mid and fees, in RHS coin
+}
+
+// HedgePlan is the cheapest set of trades bringing the greeks of a portfolio within targets
+type HedgePlan struct {
+	Trades []HedgeTrade // by contract
+	Cost   float64      // total, in RHS coin
+	Before Greeks
+	After  Greeks // with the PV of Before less the cost
+}
+
+func (p HedgePlan) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "cost %.4f delta %.4f -> %.4f vega %.4f -> %.4f\n", p.Cost, p.Before.Delta, p.After.Delta,
+		p.Before.Vega, p.After.Vega)
+	for _, t := range p.Trades {
+		fmt.Fprintf(&b, "%s %+g @ %g cost %.4f\n", t.Contract.Name(), t.Amount, t.Price, t.Cost)
+	}
+	return b.String()
+}
+
+// GreekOptimizer finds the cheapest trades in tradable contracts that bring the greeks of a portfolio within
+// targets. Each level of the books is a variable of a linear program whose cost is the premium paid over the mid
+// plus fees, and whose greeks are the bumped greeks of the contract in the market
+type GreekOptimizer struct {
+	Depth int     // levels of each book side used, zero for all
+	Fees  FeeRate // taker fees, on the underlying notional for options and the contract value for futures
+}
+
+// NewGreekOptimizer returns an optimizer using depth levels of the books and charging taker fees
+func NewGreekOptimizer(depth int, fees FeeRate) *GreekOptimizer {
+	return &GreekOptimizer{Depth: depth, Fees: fees}
+}
+
+// hedgeLevel is a level of a book that can be bought or sold
+type hedgeLevel struct {
+	tradable int
+	sign     float64 // 1 to buy at an ask, -1 to sell at a bid
+	price    float64
+	size     float64
+	cost     float64 // per contract
+}
+
+// Optimize returns the cheapest trades bringing the greeks of the portfolio valued in the market within the
+// targets. Fails with ErrInfeasible if the books cannot meet the targets and ErrInvalidInput for unknown greeks
+// or contracts the market cannot value
+func (o *GreekOptimizer) Optimize(port Portfolio, m *Market, tradables []Tradable, targets ...GreekTarget) (HedgePlan, error) {
+	asof := m.Asof()
+	plan := HedgePlan{Before: port.GreeksMarket(m)}
+	for _, t := range targets {
+		if _, ok := t.Greek.Of(Greeks{}); !ok {
+			return plan, fmt.Errorf("%w: unknown greek %q", ErrInvalidInput, t.Greek)
+		}
+	}
+
+	greeks := make([]Greeks, len(tradables))
+	var levels []hedgeLevel
+	for i, tr := range tradables {
+		if tr.Book.OrderBookCore == nil {
+			continue
+		}
+		_, _, mid := tr.Book.BidAskMid()
+		spot, fut, vol := m.Params(NewPosition(tr.Contract, 1, mid))
+		if !validPrice(mid) || !validPrice(spot) || !validPrice(fut) {
+			return plan, fmt.Errorf("%w: no price for %s", ErrInvalidInput, tr.Contract.Name())
+		}
+		greeks[i] = NewPosition(tr.Contract, 1, mid).Greeks(asof, spot, fut, vol)
+		notional := tr.Contract.Multiplier()
+		if tr.Contract.IsOption() {
+			notional = spot
+		}
+		fee := o.Fees.Fee(notional, false)
+		side := func(orders []Order, sign float64) {
+			for d, l := range orders {
+				if o.Depth > 0 && d >= o.Depth {
+					break
+				}
+				// premium over the mid: the value of trading at the mid less that of trading at the level
+				cost := NewPosition(tr.Contract, sign, mid).PV(asof, spot, fut, vol) -
+					NewPosition(tr.Contract, sign, l.Price).PV(asof, spot, fut, vol)
+				levels = append(levels, hedgeLevel{tradable: i, sign: sign, price: l.Price, size: l.Amount, cost: cost + fee})
+			}
+		}
+		side(tr.Book.Asks(), 1)
+		side(tr.Book.Bids(), -1)
+	}
+
+	// minimize the cost of the amounts traded at each level, within the level sizes and the greek targets
+	c := make([]float64, len(levels))
+	var A [][]float64
+	var b []float64
+	for j, l := range levels {
+		c[j] = l.cost
+		row := make([]float64, len(levels))
+		row[j] = 1
+		A, b = append(A, row), append(b, l.size)
+	}
+	for _, t := range targets {
+		current, _ := t.Greek.Of(plan.Before)
+		upper, lower := make([]float64, len(levels)), make([]float64, len(levels))
+		for j, l := range levels {
+			g, _ := t.Greek.Of(greeks[l.tradable])
+			upper[j], lower[j] = l.sign*g, -l.sign*g
+		}
+		A, b = append(A, upper, lower), append(b, t.Max-current, current-t.Min)
+	}
+	x, err := solveLP(c, A, b)
+	if err != nil {
+		return plan, err
+	}
+
+	trades := make(map[int]*HedgeTrade)
+	notional := make(map[int]float64)
+	for j, l := range levels {
+		if x[j] < 1e-9 {
+			continue
+		}
+		tr, ok := trades[l.tradable]
+		if !ok {
+			tr = &HedgeTrade{Contract: tradables[l.tradable].Contract}
+			trades[l.tradable] = tr
+		}
+		tr.Amount += l.sign * x[j]
+		tr.Cost += l.cost * x[j]
+		notional[l.tradable] += l.sign * x[j] * l.price
+	}
+	plan.After = plan.Before
+	for i, tr := range trades {
+		if math.Abs(tr.Amount) < 1e-9 {
+			continue
+		}
+		tr.Price = notional[i] / tr.Amount
+		plan.Trades = append(plan.Trades, *tr)
+		plan.Cost += tr.Cost
+		g := greeks[i].Scale(tr.Amount)
+		g.PV = 0
+		plan.After = plan.After.Add(g)
+	}
+	plan.After.PV -= plan.Cost
+	sort.Slice(plan.Trades, func(i, j int) bool { return plan.Trades[i].Contract.Before(plan.Trades[j].Contract) })
+	return plan, nil
+}
@@ -0,0 +1,268 @@
+package bean
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Greeks holds the value and risk of a contract or position in the same units as the Position functions:
+// PV, Vega (per vol point) and Theta (per day) in RHS coin spot value, Delta in LHS coin and Gamma as the change
+// in Delta for a 1% move in the underlying
+type Greeks struct {
+	PV    float64
+	Delta float64
+	Gamma float64
+	Vega  float64
+	Theta float64
+}
+
+// Add returns the sum of two sets of greeks
+func (g Greeks) Add(g2 Greeks) Greeks {
+	return Greeks{
+		PV:    g.PV + g2.PV,
+		Delta: g.Delta + g2.Delta,
+		Gamma: g.Gamma + g2.Gamma,
+		Vega:  g.Vega + g2.Vega,
+		Theta: g.Theta + g2.Theta,
+	}
+}
+
+// Scale returns the greeks multiplied by qty
+func (g Greeks) Scale(qty float64) Greeks {
+	return Greeks{
+		PV:    g.PV * qty,
+		Delta: g.Delta * qty,
+		Gamma: g.Gamma * qty,
+		Vega:  g.Vega * qty,
+		Theta: g.Theta * qty,
+	}
+}
+
+// Expired is true at or after the expiry of the contract, when options are worth their settled intrinsic value
+func (c Contract) Expired(asof time.Time) bool {
+	return !asof.Before(c.expiry)
+}
+
+// Intrinsic returns the intrinsic value of one option in RHS coin at a settlement price, NaN for other contracts
+func (c Contract) Intrinsic(settlePrice float64) float64 {
+	switch {
+	case !c.isOption:
+		return math.NaN()
+	case c.callPut == Call:
+		return math.Max(settlePrice-c.strike, 0.0)
+	}
+	return math.Max(c.strike-settlePrice, 0.0)
+}
+
+// SettledGreeks returns the greeks of one unit of an expired option settled at settlePrice. The intrinsic value
+// is paid in LHS coin, so the PV (RHS coin) moves with spot from the settlement price and the delta is the coin
+// amount paid. Gamma, vega and theta are zero
+func (c Contract) SettledGreeks(spotPrice, settlePrice float64) Greeks {
+	if !c.isOption || !validPrice(settlePrice) {
+		return Greeks{math.NaN(), math.NaN(), math.NaN(), math.NaN(), math.NaN()}
+	}
+	coins := c.Intrinsic(settlePrice) / settlePrice
+	return Greeks{PV: coins * spotPrice, Delta: coins}
+}
+
+// GreeksAt returns the greeks of a position with the errors of the pricing: NaN greeks and ErrInvalidInput for
+// invalid prices or vol, and for options at or after expiry the greeks settled at futPrice with ErrExpired, the
+// option PV being its intrinsic value. The premium paid is included as in PV
+func (p Position) GreeksAt(asof time.Time, spotPrice, futPrice, vol float64) (Greeks, error) {
+	if !p.IsOption() {
+		return p.Greeks(asof, spotPrice, futPrice, vol), nil
+	}
+	if err := p.checkPricingInputs(spotPrice, futPrice, vol); err != nil {
+		return Greeks{math.NaN(), math.NaN(), math.NaN(), math.NaN(), math.NaN()}, contractError(p.Name(), err)
+	}
+	if p.Expired(asof) {
+		g := p.SettledGreeks(spotPrice, futPrice).Scale(p.qty)
+		g.PV -= p.price * spotPrice * p.qty
+		g.Delta -= p.price * p.qty
+		return g, contractError(p.Name(), ErrExpired)
+	}
+	return p.Greeks(asof, spotPrice, futPrice, vol), nil
+}
+
+// pricingCache holds the intermediate values of the analytic black formula for one contract
+type pricingCache struct {
+	revision    int64
+	spot        float64
+	forward     float64
+	vol         float64
+	expiryYears float64
+	sqrtT       float64
+	d1          float64
+	d2          float64
+	nd1         float64 // cumulative normal of d1
+	nd2         float64 // cumulative normal of d2
+	pdfd1       float64 // normal density at d1
+}
+
+// GreeksEngine computes analytic greeks of options, caching the pricing intermediates (d1, d2 and their normal
+// distribution values) per contract for the current market revision. Calling Update moves the engine to a new market
+// revision and invalidates the cache, so recomputing a book of greeks between market updates is cheap.
+type GreeksEngine struct {
+	m        sync.Mutex
+	asof     time.Time
+	revision int64
+	cache    map[string]*pricingCache
+	market   int64 // revision of the market last synced
+}
+
+// NewGreeksEngine returns an engine pricing as of asof
+func NewGreeksEngine(asof time.Time) *GreeksEngine {
+	return &GreeksEngine{
+		asof:     asof,
+		revision: 1,
+		cache:    make(map[string]*pricingCache),
+	}
+}
+
+// Update sets a new pricing time and market revision, invalidating all cached values
+func (ge *GreeksEngine) Update(asof time.Time) {
+	ge.m.Lock()
+	defer ge.m.Unlock()
+	ge.asof = asof
+	ge.revision++
+	ge.cache = make(map[string]*pricingCache)
+}
+
+// Sync updates the engine to the pricing time of a market if the market changed since the last Sync, returning
+// true if the cache was invalidated. Comparing revisions is cheap so it can be called before every use
+func (ge *GreeksEngine) Sync(m *Market) bool {
+	rev, asof := m.Revision(), m.Asof()
+	ge.m.Lock()
+	defer ge.m.Unlock()
+	if rev == ge.market && asof.Equal(ge.asof) {
+		return false
+	}
+	ge.market = rev
+	ge.asof = asof
+	ge.revision++
+	ge.cache = make(map[string]*pricingCache)
+	return true
+}
+
+// Revision returns the current market revision of the engine
+func (ge *GreeksEngine) Revision() int64 {
+	ge.m.Lock()
+	defer ge.m.Unlock()
+	return ge.revision
+}
+
+// Asof returns the pricing time of the engine
+func (ge *GreeksEngine) Asof() time.Time {
+	ge.m.Lock()
+	defer ge.m.Unlock()
+	return ge.asof
+}
+
+// intermediates returns the cached pricing values for a contract, recomputing them if the market has moved
+func (ge *GreeksEngine) intermediates(c *Contract, spotPrice, futPrice, vol float64) *pricingCache {
+	name := c.Name()
+	ge.m.Lock()
+	defer ge.m.Unlock()
+	pc, ok := ge.cache[name]
+	if ok && pc.revision == ge.revision && pc.spot == spotPrice && pc.forward == futPrice && pc.vol == vol {
+		return pc
+	}
+	pc = &pricingCache{
+		revision:    ge.revision,
+		spot:        spotPrice,
+		forward:     futPrice,
+		vol:         vol,
+		expiryYears: c.ExpiryYears(ge.asof),
+	}
+	if pc.expiryYears > 0 && vol > 0 {
+		pc.sqrtT = math.Sqrt(pc.expiryYears)
+		pc.d1, pc.d2 = d1d2(pc.expiryYears, c.Strike(), futPrice, vol)
+		pc.nd1 = cumNormDist(pc.d1)
+		pc.nd2 = cumNormDist(pc.d2)
+		pc.pdfd1 = math.Exp(-pc.d1*pc.d1/2.0) / math.Sqrt(2.0*math.Pi)
+	}
+	ge.cache[name] = pc
+	return pc
+}
+
+// Greeks returns the analytic greeks of one unit of an option contract. Values are consistent with OptPrice
+func (ge *GreeksEngine) Greeks(c *Contract, spotPrice, futPrice, vol float64) (g Greeks) {
+	if !c.IsOption() {
+		return Greeks{math.NaN(), math.NaN(), math.NaN(), math.NaN(), math.NaN()}
+	}
+	if c.Expired(ge.Asof()) {
+		return c.SettledGreeks(spotPrice, futPrice)
+	}
+	pc := ge.intermediates(c, spotPrice, futPrice, vol)
+	strike := c.Strike()
+	if pc.expiryYears <= 0 || vol <= 0 {
+		// intrinsic only
+		if c.CallPut() == Call {
+			g.PV = math.Max(futPrice-strike, 0.0) * spotPrice / futPrice
+			if futPrice > strike {
+				g.Delta = 1.0
+			}
+		} else {
+			g.PV = math.Max(strike-futPrice, 0.0) * spotPrice / futPrice
+			if futPrice < strike {
+				g.Delta = -1.0
+			}
+		}
+		return
+	}
+	if c.CallPut() == Call {
+		g.PV = (futPrice*pc.nd1 - strike*pc.nd2) * spotPrice / futPrice
+		g.Delta = pc.nd1
+	} else {
+		g.PV = (-futPrice*(1.0-pc.nd1) + strike*(1.0-pc.nd2)) * spotPrice / futPrice
+		g.Delta = pc.nd1 - 1.0
+	}
+	g.Gamma = 0.01 * pc.pdfd1 / (vol * pc.sqrtT)
+	g.Vega = 0.01 * spotPrice * pc.pdfd1 * pc.sqrtT
+	g.Theta = -spotPrice * pc.pdfd1 * vol / (2.0 * pc.sqrtT) / 365.0
+	return
+}
+
+// Vanna returns the analytic change in delta of one unit of an option for a 1 vol point rise in vol,
+// consistent with Position.Vanna
+func (ge *GreeksEngine) Vanna(c *Contract, spotPrice, futPrice, vol float64) float64 {
+	if !c.IsOption() {
+		return math.NaN()
+	}
+	pc := ge.intermediates(c, spotPrice, futPrice, vol)
+	if pc.expiryYears <= 0 || vol <= 0 {
+		return 0.0
+	}
+	return -0.01 * pc.pdfd1 * pc.d2 / vol
+}
+
+// Volga returns the analytic change in vega of one unit of an option for a 1 vol point rise in vol,
+// consistent with Position.Volga
+func (ge *GreeksEngine) Volga(c *Contract, spotPrice, futPrice, vol float64) float64 {
+	if !c.IsOption() {
+		return math.NaN()
+	}
+	pc := ge.intermediates(c, spotPrice, futPrice, vol)
+	if pc.expiryYears <= 0 || vol <= 0 {
+		return 0.0
+	}
+	return 0.0001 * spotPrice * pc.sqrtT * pc.pdfd1 * pc.d1 * pc.d2 / vol
+}
+
+// PositionGreeks returns the greeks of a position, including the premium paid as Position.PV does.
+// Futures positions are not cached and use the Position functions directly
+func (ge *GreeksEngine) PositionGreeks(p Position, spotPrice, futPrice, vol float64) Greeks {
+	if !p.IsOption() {
+		asof := ge.Asof()
+		return Greeks{
+			PV:    p.PV(asof, spotPrice, futPrice, vol),
+			Delta: p.Delta(asof, spotPrice, futPrice, vol),
+			Gamma: p.Gamma(asof, spotPrice, futPrice, vol),
+		}
+	}
+	g := ge.Greeks(p.Contract, spotPrice, futPrice, vol).Scale(p.Qty())
+	g.PV -= p.Price() * spotPrice * p.Qty()
+	g.Delta -= p.Price() * p.Qty()
+	return g
+}
@@ -0,0 +1,102 @@
+package bean
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// CashBucket is the bucket of the spot delta in BucketDelta and GreeksReport, the other buckets being expiries
+const CashBucket = "CASH"
+
+// GreeksKey is a bucket of a GreeksReport: an underlying and CashBucket or an expiry (see Contract.ExpiryStr)
+type GreeksKey struct {
+	Underlying Pair
+	Bucket     string
+}
+
+// GreeksReport holds the greeks of a portfolio by underlying and bucket in one reporting currency, so that books
+// on several underlyings add up. Delta and Gamma are the value in the reporting currency of the lhs coin exposures,
+// PV, Vega and Theta the value of the rhs coin ones. The CashBucket of an underlying only has a Delta
+type GreeksReport map[GreeksKey]Greeks
+
+// GreeksByUnderlying returns the greeks of all positions by underlying and bucket, the delta being split into its
+// spot and forward parts as in BucketDelta. rates is the value of one unit of each coin in the reporting currency,
+// see Market.ConversionRates. Fails with ErrNoRate if a coin of the positions has none
+func (p *portfolio) GreeksByUnderlying(asof time.Time, mkt PositionMarket, rates map[Coin]float64) (GreeksReport, error) {
+	res := make(GreeksReport)
+	for _, pos := range p.positions {
+		u := pos.Underlying()
+		coinRate, ok := rates[u.Coin]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrNoRate, u.Coin)
+		}
+		baseRate, ok := rates[u.Base]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrNoRate, u.Base)
+		}
+		spot, fut, vol := mkt(pos)
+		g := pos.Greeks(asof, spot, fut, vol)
+		buckets := pos.BucketDelta(asof, spot, fut, vol)
+
+		cash := GreeksKey{Underlying: u, Bucket: CashBucket}
+		cg := res[cash]
+		cg.Delta += buckets[CashBucket] * coinRate
+		res[cash] = cg
+
+		expiry := GreeksKey{Underlying: u, Bucket: pos.ExpiryStr()}
+		res[expiry] = res[expiry].Add(Greeks{
+			PV:    g.PV * baseRate,
+			Delta: buckets[pos.ExpiryStr()] * coinRate,
+			Gamma: g.Gamma * coinRate,
+			Vega:  g.Vega * baseRate,
+			Theta: g.Theta * baseRate,
+		})
+	}
+	return res, nil
+}
+
+// Total returns the greeks summed over all underlyings and buckets
+func (r GreeksReport) Total() (g Greeks) {
+	for _, b := range r {
+		g = g.Add(b)
+	}
+	return
+}
+
+// Underlying returns the greeks of an underlying summed over its buckets
+func (r GreeksReport) Underlying(p Pair) (g Greeks) {
+	for k, b := range r {
+		if k.Underlying == p {
+			g = g.Add(b)
+		}
+	}
+	return
+}
+
+// ConversionRates returns the value of one unit of each coin in report, chaining the spot prices of the market
+// (ETH in BTC through ETH-USD and BTC-USD). Coins not connected to report by spot prices are left out
+func (m *Market) ConversionRates(report Coin) map[Coin]float64 {
+	m.m.RLock()
+	defer m.m.RUnlock()
+	rates := map[Coin]float64{report: 1}
+	for changed := true; changed; {
+		changed = false
+		for p, s := range m.spots {
+			if math.IsNaN(s) || s <= 0 {
+				continue
+			}
+			coin, okCoin := rates[p.Coin]
+			base, okBase := rates[p.Base]
+			switch {
+			case okBase && !okCoin:
+				rates[p.Coin] = s * base
+				changed = true
+			case okCoin && !okBase:
+				rates[p.Base] = coin / s
+				changed = true
+			}
+		}
+	}
+	return rates
+}
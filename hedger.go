@@ -0,0 +1,124 @@
+package bean
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+// DeltaHedger simulates delta hedging an options portfolio along a price path by trading the underlying in its
+// orderbook. Rehedges happen every Interval, or when the absolute net delta exceeds Band (in LHS coin), or both
+type DeltaHedger struct {
+	Interval time.Duration // rehedge period, zero to hedge only on the band
+	Band     float64       // net delta that triggers a rehedge, zero to hedge only periodically
+	Vol      float64       // vol the options are valued and hedged at
+	Fees     FeeRate       // taker fees on the hedge trades
+}
+
+// HedgeReport compares the PnL of the hedged portfolio with the PnL explained by theta and gamma.
+// All values are in RHS coin
+type HedgeReport struct {
+	PnL         TimeSeries // cumulative hedged PnL including costs
+	Delta       TimeSeries // net delta after each step in LHS coin
+	Hedges      int        // number of hedge trades
+	HedgeVolume float64    // absolute amount traded in LHS coin
+	Costs       float64    // spread paid against mid plus fees
+	ThetaPnL    float64    // sum of theta over each step
+	GammaPnL    float64    // sum of 1/2 gamma dS^2 over each step
+	OptionPnL   float64    // change in value of the portfolio positions
+	HedgePnL    float64    // PnL of the hedge before costs
+}
+
+// HedgedPnL is the total PnL of the hedged portfolio
+func (r HedgeReport) HedgedPnL() float64 {
+	return r.OptionPnL + r.HedgePnL - r.Costs
+}
+
+// TheoreticalPnL is the PnL expected from theta and gamma with continuous costless hedging
+func (r HedgeReport) TheoreticalPnL() float64 {
+	return r.ThetaPnL + r.GammaPnL
+}
+
+// NewDeltaHedger returns a hedger rehedging every interval or beyond band, valuing options at vol
+func NewDeltaHedger(interval time.Duration, band, vol float64) *DeltaHedger {
+	return &DeltaHedger{Interval: interval, Band: band, Vol: vol}
+}
+
+// Run simulates hedging the positions of the portfolio along the books of the underlying. The mid of each book
+// is used as spot and forward price. The portfolio is not modified
+func (h *DeltaHedger) Run(port Portfolio, path OrderBookTS) HedgeReport {
+	r, _ := h.RunContext(context.Background(), port, path)
+	return r
+}
+
+// RunContext runs the simulation as Run does, stopping early with the context error and the report so far if ctx
+// is cancelled or its deadline passes
+func (h *DeltaHedger) RunContext(ctx context.Context, port Portfolio, path OrderBookTS) (r HedgeReport, err error) {
+	mkt := func(spot float64) PositionMarket {
+		return func(p Position) (float64, float64, float64) {
+			return spot, spot, h.Vol
+		}
+	}
+	var hedge, prevSpot float64
+	var prev Greeks
+	var lastHedge time.Time
+	started := false
+	for _, ob := range path {
+		if err = ctx.Err(); err != nil {
+			return
+		}
+		if !ob.Valid() {
+			continue
+		}
+		spot := ob.Mid()
+		g := port.Greeks(ob.Time, mkt(spot))
+		if started {
+			dS := spot - prevSpot
+			days := ob.Time.Sub(lastTime(r.PnL)).Hours() / 24.0
+			r.ThetaPnL += prev.Theta * days
+			r.GammaPnL += 0.5 * prev.Gamma * dS * dS / (0.01 * prevSpot)
+			r.OptionPnL += g.PV - prev.PV
+			r.HedgePnL += hedge * dS
+		}
+
+		net := g.Delta + hedge
+		due := !started ||
+			(h.Interval > 0 && ob.Time.Sub(lastHedge) >= h.Interval) ||
+			(h.Band > 0 && math.Abs(net) > h.Band)
+		if due && net != 0 {
+			limit := 0.0
+			if net < 0 {
+				limit = math.MaxFloat64
+			}
+			fill, fee := ob.MatchWithFee(Order{Price: limit, Amount: -net}, h.Fees)
+			if fill.Amount != 0 {
+				hedge += fill.Amount
+				r.Hedges++
+				r.HedgeVolume += math.Abs(fill.Amount)
+				r.Costs += fill.Amount*(fill.Price-spot) + fee
+			}
+			lastHedge = ob.Time
+		}
+
+		r.PnL = append(r.PnL, TimePoint{Time: ob.Time, Value: r.HedgedPnL()})
+		r.Delta = append(r.Delta, TimePoint{Time: ob.Time, Value: g.Delta + hedge})
+		prev, prevSpot, started = g, spot, true
+	}
+	return
+}
+
+// RunCandles simulates hedging on candles, trading at the close with halfSpread (e.g. 0.0005) either side
+func (h *DeltaHedger) RunCandles(port Portfolio, candles OHLCVBSTS, halfSpread float64) HedgeReport {
+	path := make(OrderBookTS, len(candles))
+	for i, c := range candles {
+		ob := NewOrderBook(
+			[]Order{{Price: c.Close * (1 - halfSpread), Amount: math.MaxFloat64}},
+			[]Order{{Price: c.Close * (1 + halfSpread), Amount: math.MaxFloat64}})
+		path[i] = OrderBookT{OrderBook: ob, Time: c.End}
+	}
+	return h.Run(port, path)
+}
+
+func lastTime(ts TimeSeries) time.Time {
+	return ts[len(ts)-1].Time
+}
@@ -0,0 +1,142 @@
+package bean
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// HiddenLevel is the liquidity executed at one price level of one side against what the book displayed there
+type HiddenLevel struct {
+	Side      Side // side of the book the trades hit, BUY for bids
+	Price     float64
+	Trades    int
+	Executed  float64 // traded amount
+	Displayed float64 // displayed amount before the trades, summed over the book snapshots hit
+	Hidden    float64 // executed beyond the displayed amount
+}
+
+// HiddenLiquidity estimates the hidden (iceberg or undisplayed) liquidity of a market from trades executing more
+// than the book displayed at their level
+type HiddenLiquidity struct {
+	Levels []HiddenLevel // by side then price, best first
+	// hidden amount per displayed amount on the levels traded, by side, zero if nothing was displayed
+	BidRatio float64
+	AskRatio float64
+}
+
+type hiddenKey struct {
+	book  int
+	side  Side
+	price float64
+}
+
+// EstimateHiddenLiquidity compares the trades with the amount displayed at their price in the last book at or
+// before each of them. Books and trades must be sorted by time. Trades with a buyer maker hit the bids, the others
+// lift the asks. Trades before the first book are ignored
+func EstimateHiddenLiquidity(books OrderBookTS, trades Transactions) HiddenLiquidity {
+	executed := make(map[hiddenKey]float64)
+	count := make(map[hiddenKey]int)
+	stamps := make([]time.Time, len(trades))
+	for j, t := range trades {
+		stamps[j] = t.TimeStamp
+	}
+	index := books.AsOfIndex(stamps)
+	for j, t := range trades {
+		i := index[j]
+		if i < 0 {
+			continue
+		}
+		side := SELL
+		if t.Maker == Buyer {
+			side = BUY
+		}
+		k := hiddenKey{book: i, side: side, price: t.Price}
+		executed[k] += math.Abs(t.Amount)
+		count[k]++
+	}
+
+	levels := make(map[hiddenKey]*HiddenLevel)
+	var displayed, hidden [2]float64
+	for k, amount := range executed {
+		shown := displayedAt(books[k.book].OrderBook, k.side, k.price)
+		lk := hiddenKey{side: k.side, price: k.price}
+		l, ok := levels[lk]
+		if !ok {
+			l = &HiddenLevel{Side: k.side, Price: k.price}
+			levels[lk] = l
+		}
+		l.Trades += count[k]
+		l.Executed += amount
+		l.Displayed += shown
+		l.Hidden += math.Max(0, amount-shown)
+		s := sideIndex(k.side)
+		displayed[s] += shown
+		hidden[s] += math.Max(0, amount-shown)
+	}
+
+	var res HiddenLiquidity
+	for _, l := range levels {
+		res.Levels = append(res.Levels, *l)
+	}
+	sort.Slice(res.Levels, func(i, j int) bool {
+		a, b := res.Levels[i], res.Levels[j]
+		if a.Side != b.Side {
+			return a.Side == BUY
+		}
+		if a.Side == BUY {
+			return a.Price > b.Price
+		}
+		return a.Price < b.Price
+	})
+	res.BidRatio = hiddenRatio(hidden[0], displayed[0])
+	res.AskRatio = hiddenRatio(hidden[1], displayed[1])
+	return res
+}
+
+func sideIndex(s Side) int {
+	if s == BUY {
+		return 0
+	}
+	return 1
+}
+
+func hiddenRatio(hidden, displayed float64) float64 {
+	if displayed == 0 {
+		return 0
+	}
+	return hidden / displayed
+}
+
+// displayedAt returns the amount a book shows at a price on a side
+func displayedAt(ob OrderBook, side Side, price float64) float64 {
+	if ob.OrderBookCore == nil {
+		return 0
+	}
+	levels := ob.Asks()
+	if side == BUY {
+		levels = ob.Bids()
+	}
+	for _, o := range levels {
+		if o.Price == price {
+			return o.Amount
+		}
+	}
+	return 0
+}
+
+// Enrich returns a copy of a book with each level scaled up by the hidden ratio of its side, the depth expected
+// to be executable, for routing and execution sizing
+func (h HiddenLiquidity) Enrich(ob OrderBook) OrderBook {
+	if ob.OrderBookCore == nil {
+		return EmptyOrderBook()
+	}
+	scale := func(levels []Order, ratio float64) []Order {
+		res := make([]Order, len(levels))
+		for i, o := range levels {
+			res[i] = Order{Price: o.Price, Amount: o.Amount * (1 + ratio)}
+		}
+		return res
+	}
+	return NewOrderBook(scale(ob.Bids(), h.BidRatio), scale(ob.Asks(), h.AskRatio))
+}
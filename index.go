@@ -0,0 +1,125 @@
+package bean
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// IndexPrice computes a composite spot index from the prices of several exchanges, as derivatives exchanges do
+// for their mark and funding prices. Each exchange's price is the median of its ticks over the last Window, which
+// removes single print spikes, then exchanges further than MaxDeviation from the median of those prices are dropped
+// and the index is the weighted average of the others (weights renormalised)
+type IndexPrice struct {
+	Pair         Pair
+	Window       time.Duration // ticks older than this are ignored
+	MaxDeviation float64       // relative distance to the median beyond which an exchange is dropped, e.g. 0.02
+
+	m       sync.Mutex
+	weights map[string]float64
+	ticks   map[string][]indexTick
+}
+
+type indexTick struct {
+	time  time.Time
+	price float64
+}
+
+// NewIndexPrice returns an index over exchanges with the given weights
+func NewIndexPrice(p Pair, weights map[string]float64, window time.Duration, maxDeviation float64) *IndexPrice {
+	ix := &IndexPrice{
+		Pair:         p,
+		Window:       window,
+		MaxDeviation: maxDeviation,
+		weights:      make(map[string]float64),
+		ticks:        make(map[string][]indexTick),
+	}
+	for ex, w := range weights {
+		ix.weights[ex] = w
+	}
+	return ix
+}
+
+// SetWeight sets the weight of an exchange, zero removes it from the index
+func (ix *IndexPrice) SetWeight(exName string, w float64) {
+	ix.m.Lock()
+	defer ix.m.Unlock()
+	ix.weights[exName] = w
+}
+
+// Update records a price of an exchange. Ticks older than the window of the latest one are discarded
+func (ix *IndexPrice) Update(exName string, price float64, t time.Time) {
+	if !validPrice(price) {
+		return
+	}
+	ix.m.Lock()
+	defer ix.m.Unlock()
+	ticks := append(ix.ticks[exName], indexTick{time: t, price: price})
+	i := 0
+	for i < len(ticks) && ticks[i].time.Before(t.Add(-ix.Window)) {
+		i++
+	}
+	ix.ticks[exName] = ticks[i:]
+}
+
+func median(xs []float64) float64 {
+	s := append([]float64(nil), xs...)
+	sort.Float64s(s)
+	n := len(s)
+	if n%2 == 1 {
+		return s[n/2]
+	}
+	return (s[n/2-1] + s[n/2]) / 2
+}
+
+// Constituents returns the price of each exchange with ticks in the window ending at asof
+func (ix *IndexPrice) Constituents(asof time.Time) map[string]float64 {
+	ix.m.Lock()
+	defer ix.m.Unlock()
+	res := make(map[string]float64)
+	for ex, ticks := range ix.ticks {
+		if ix.weights[ex] <= 0 {
+			continue
+		}
+		var prices []float64
+		for _, t := range ticks {
+			if !t.time.After(asof) && !t.time.Before(asof.Add(-ix.Window)) {
+				prices = append(prices, t.price)
+			}
+		}
+		if len(prices) > 0 {
+			res[ex] = median(prices)
+		}
+	}
+	return res
+}
+
+// Price returns the index as of a time, or NaN and ErrNoIndexPrice if no exchange has a price in the window
+func (ix *IndexPrice) Price(asof time.Time) (float64, error) {
+	prices := ix.Constituents(asof)
+	if len(prices) == 0 {
+		return math.NaN(), fmt.Errorf("%w: %v at %v", ErrNoIndexPrice, ix.Pair, asof)
+	}
+	all := make([]float64, 0, len(prices))
+	for _, p := range prices {
+		all = append(all, p)
+	}
+	med := median(all)
+
+	ix.m.Lock()
+	defer ix.m.Unlock()
+	var sum, weights float64
+	for ex, p := range prices {
+		if ix.MaxDeviation > 0 && math.Abs(p/med-1) > ix.MaxDeviation {
+			continue
+		}
+		sum += ix.weights[ex] * p
+		weights += ix.weights[ex]
+	}
+	if weights == 0 {
+		return med, nil
+	}
+	return sum / weights, nil
+}
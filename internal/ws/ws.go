@@ -0,0 +1,341 @@
+// Package ws implements minimal RFC 6455 websocket connections on the standard library, for the fan-out server and
+// the exchange streams: text messages, fragmentation, ping, pong and close, with write deadlines and keepalive pings.
+// Extensions and subprotocols are not negotiated
+package ws
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xA
+
+	acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	maxMessage = 16 << 20
+
+	// DefaultWriteTimeout is the write deadline of the frames of new connections, see SetWriteTimeout
+	DefaultWriteTimeout = 10 * time.Second
+)
+
+// ErrProtocol is returned on handshakes and frames breaking the protocol
+var ErrProtocol = errors.New("websocket protocol error")
+
+// Conn is a websocket connection. Writes are safe for concurrent use, reads are not
+type Conn struct {
+	conn   net.Conn
+	br     *bufio.Reader
+	client bool // client frames are masked, server frames are not
+
+	wm           sync.Mutex
+	writeTimeout time.Duration
+	readTimeout  int64 // time.Duration set by KeepAlive, accessed atomically
+	closeOnce    sync.Once
+	closed       chan struct{}
+}
+
+func newConn(conn net.Conn, br *bufio.Reader, client bool) *Conn {
+	return &Conn{conn: conn, br: br, client: client, writeTimeout: DefaultWriteTimeout, closed: make(chan struct{})}
+}
+
+func acceptKey(key string) string {
+	h := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+func headerContains(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, s := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(s), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Upgrade completes the opening handshake of a websocket request, replying with an error if it is not one
+func Upgrade(w http.ResponseWriter, r *http.Request) (*Conn, error) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if r.Method != http.MethodGet || !headerContains(r.Header, "Connection", "upgrade") ||
+		!headerContains(r.Header, "Upgrade", "websocket") || r.Header.Get("Sec-WebSocket-Version") != "13" || key == "" {
+		http.Error(w, "websocket upgrade expected", http.StatusBadRequest)
+		return nil, ErrProtocol
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "connection cannot be upgraded", http.StatusInternalServerError)
+		return nil, ErrProtocol
+	}
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n", acceptKey(key))
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return newConn(conn, rw.Reader, false), nil
+}
+
+// Dial opens a websocket to a ws:// or wss:// url
+func Dial(ctx context.Context, rawurl string) (*Conn, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	host := u.Host
+	if u.Port() == "" {
+		if u.Scheme == "wss" {
+			host += ":443"
+		} else {
+			host += ":80"
+		}
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", host)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "wss":
+		conn = tls.Client(conn, &tls.Config{ServerName: u.Hostname()})
+	case "ws":
+	default:
+		conn.Close()
+		return nil, fmt.Errorf("%w: scheme %s", ErrProtocol, u.Scheme)
+	}
+	nonce := make([]byte, 16)
+	rand.Read(nonce)
+	key := base64.StdEncoding.EncodeToString(nonce)
+	req := &http.Request{Method: http.MethodGet, URL: u, Host: u.Host, Header: http.Header{
+		"Upgrade":               {"websocket"},
+		"Connection":            {"Upgrade"},
+		"Sec-Websocket-Key":     {key},
+		"Sec-Websocket-Version": {"13"},
+	}}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+		defer conn.SetDeadline(time.Time{})
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Sec-WebSocket-Accept") != acceptKey(key) {
+		conn.Close()
+		return nil, fmt.Errorf("%w: handshake answered %s", ErrProtocol, resp.Status)
+	}
+	return newConn(conn, br, true), nil
+}
+
+// SetWriteTimeout sets the deadline of each frame written, zero for none. A peer not reading for that long fails
+// the write instead of blocking the writer
+func (c *Conn) SetWriteTimeout(d time.Duration) {
+	c.wm.Lock()
+	defer c.wm.Unlock()
+	c.writeTimeout = d
+}
+
+// KeepAlive pings the peer every interval until the connection is closed, and fails the reads when nothing, not even
+// a pong, is received for two intervals
+func (c *Conn) KeepAlive(interval time.Duration) {
+	atomic.StoreInt64(&c.readTimeout, int64(2*interval))
+	c.conn.SetReadDeadline(time.Now().Add(2 * interval))
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-c.closed:
+				return
+			case <-ticker.C:
+				if err := c.writeFrame(opPing, nil); err != nil {
+					c.Abort()
+					return
+				}
+			}
+		}
+	}()
+}
+
+func (c *Conn) writeFrame(op byte, payload []byte) error {
+	c.wm.Lock()
+	defer c.wm.Unlock()
+	if c.writeTimeout > 0 {
+		c.conn.SetWriteDeadline(time.Now().Add(c.writeTimeout))
+	}
+	header := make([]byte, 2, 14)
+	header[0] = 0x80 | op
+	n := len(payload)
+	switch {
+	case n < 126:
+		header[1] = byte(n)
+	case n <= 0xFFFF:
+		header[1] = 126
+		header = append(header, 0, 0)
+		binary.BigEndian.PutUint16(header[2:], uint16(n))
+	default:
+		header[1] = 127
+		header = append(header, make([]byte, 8)...)
+		binary.BigEndian.PutUint64(header[2:], uint64(n))
+	}
+	if c.client {
+		header[1] |= 0x80
+		mask := make([]byte, 4)
+		rand.Read(mask)
+		header = append(header, mask...)
+		masked := make([]byte, n)
+		for i := range payload {
+			masked[i] = payload[i] ^ mask[i%4]
+		}
+		payload = masked
+	}
+	if _, err := c.conn.Write(header); err != nil {
+		return err
+	}
+	_, err := c.conn.Write(payload)
+	return err
+}
+
+// WriteText sends a text message
+func (c *Conn) WriteText(b []byte) error {
+	return c.writeFrame(opText, b)
+}
+
+// ReadMessage returns the next text or binary message, answering pings on the way. Returns io.EOF once the peer
+// closes the connection
+func (c *Conn) ReadMessage() ([]byte, error) {
+	var msg []byte
+	started := false
+	for {
+		fin, op, payload, err := c.readFrame()
+		if err != nil {
+			return nil, err
+		}
+		switch op {
+		case opPing:
+			if err := c.writeFrame(opPong, payload); err != nil {
+				return nil, err
+			}
+			continue
+		case opPong:
+			continue
+		case opClose:
+			c.writeFrame(opClose, nil)
+			return nil, io.EOF
+		case opText, opBinary:
+			if started {
+				return nil, ErrProtocol
+			}
+			started = true
+		case opContinuation:
+			if !started {
+				return nil, ErrProtocol
+			}
+		default:
+			return nil, ErrProtocol
+		}
+		if len(msg)+len(payload) > maxMessage {
+			return nil, fmt.Errorf("%w: message over %d bytes", ErrProtocol, maxMessage)
+		}
+		msg = append(msg, payload...)
+		if fin {
+			return msg, nil
+		}
+	}
+}
+
+func (c *Conn) readFrame() (fin bool, op byte, payload []byte, err error) {
+	var head [2]byte
+	if _, err = io.ReadFull(c.br, head[:]); err != nil {
+		return
+	}
+	if d := atomic.LoadInt64(&c.readTimeout); d > 0 {
+		// any frame, a pong included, shows the peer alive
+		c.conn.SetReadDeadline(time.Now().Add(time.Duration(d)))
+	}
+	fin, op = head[0]&0x80 != 0, head[0]&0x0F
+	masked := head[1]&0x80 != 0
+	if masked == c.client {
+		// clients must mask their frames and servers must not
+		err = fmt.Errorf("%w: frame masking %v", ErrProtocol, masked)
+		return
+	}
+	n := uint64(head[1] & 0x7F)
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(c.br, ext[:]); err != nil {
+			return
+		}
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(c.br, ext[:]); err != nil {
+			return
+		}
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	if n > maxMessage {
+		err = fmt.Errorf("%w: frame over %d bytes", ErrProtocol, maxMessage)
+		return
+	}
+	var mask [4]byte
+	if masked {
+		if _, err = io.ReadFull(c.br, mask[:]); err != nil {
+			return
+		}
+	}
+	payload = make([]byte, n)
+	if _, err = io.ReadFull(c.br, payload); err != nil {
+		return
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return
+}
+
+// Abort closes the connection without a close frame, when the peer cannot be written to
+func (c *Conn) Abort() error {
+	c.closeOnce.Do(func() { close(c.closed) })
+	return c.conn.Close()
+}
+
+// Close sends a close frame, within the write timeout, and closes the connection
+func (c *Conn) Close() error {
+	c.closeOnce.Do(func() { close(c.closed) })
+	c.writeFrame(opClose, nil)
+	return c.conn.Close()
+}
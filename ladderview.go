@@ -0,0 +1,195 @@
+package bean
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+)
+
+// LadderView renders an order book as a vertical price ladder for terminal monitors: asks above bids, depth bars
+// scaled to the largest level shown, our resting orders and fills marked at their levels and the last trade
+// indicated. Feed it with SetOrders, SetBlotter and OnTrade and call Render with each book snapshot. It is safe for
+// concurrent use
+type LadderView struct {
+	Depth int // levels shown on each side
+	Width int // characters of the largest depth bar
+
+	m        sync.Mutex
+	orders   OrderBook // our resting orders
+	fills    map[float64]float64
+	position float64
+	last     Transaction
+	prev     float64 // price of the trade before last
+}
+
+// NewLadderView returns a view of depth levels a side with bars up to width characters
+func NewLadderView(depth, width int) *LadderView {
+	return &LadderView{Depth: depth, Width: width, fills: make(map[float64]float64), prev: math.NaN()}
+}
+
+// SetOrders replaces our resting orders marked on the ladder, the ones left open by an exchange or broker
+func (v *LadderView) SetOrders(orders []OrderStatus) {
+	var open []OrderStatus
+	for _, o := range orders {
+		if o.LeftAmount > 0 && (o.State == ALIVE || o.State == PARTIAL) {
+			o.Price = o.PlacedPrice
+			open = append(open, o)
+		}
+	}
+	v.m.Lock()
+	defer v.m.Unlock()
+	v.orders = OrderStatusToOrderBook(open)
+}
+
+// SetBlotter marks the fills of a pair recorded in the blotter at their prices and shows its position
+func (v *LadderView) SetBlotter(b *Blotter, p Pair) {
+	fills := make(map[float64]float64)
+	for _, t := range b.Trades() {
+		if t.Pair != p {
+			continue
+		}
+		qty := math.Abs(t.Quantity)
+		if t.Side == SELL {
+			qty = -qty
+		}
+		fills[t.Price] += qty
+	}
+	position := b.Position(p)
+	v.m.Lock()
+	defer v.m.Unlock()
+	v.fills, v.position = fills, position
+}
+
+// OnTrade records the last trade of the market
+func (v *LadderView) OnTrade(tr Transaction) {
+	v.m.Lock()
+	defer v.m.Unlock()
+	if v.last.Price > 0 {
+		v.prev = v.last.Price
+	}
+	v.last = tr
+}
+
+// Render draws the ladder of a book snapshot, one line per level with the best ask and bid either side of a line
+// giving the spread and the last trade. Our orders are marked < with their amount, our net fills at a price with
+// a + or - and the last trade price with * followed by an up or down arrow
+func (v *LadderView) Render(ob OrderBook) string {
+	v.m.Lock()
+	defer v.m.Unlock()
+	const row = "%-12s %12s %*s %12s %-*s %-12s %s"
+	var b strings.Builder
+	put := func(format string, a ...interface{}) {
+		b.WriteString(strings.TrimRight(fmt.Sprintf(format, a...), " ") + "\n")
+	}
+	width := v.Width
+	if width < 1 {
+		width = 1
+	}
+	put(row, "MINE", "BID", width, "", "PRICE", width, "", "ASK", "")
+	if ob.OrderBookCore == nil {
+		return b.String()
+	}
+	bids, asks := ob.Bids(), ob.Asks()
+	if len(bids) > v.Depth {
+		bids = bids[:v.Depth]
+	}
+	if len(asks) > v.Depth {
+		asks = asks[:v.Depth]
+	}
+	largest := 0.0
+	for _, o := range append(append([]Order{}, bids...), asks...) {
+		largest = math.Max(largest, o.Amount)
+	}
+	bar := func(amount float64) string {
+		if !(largest > 0) {
+			return ""
+		}
+		n := int(math.Round(amount / largest * float64(width)))
+		if n == 0 && amount > 0 {
+			n = 1
+		}
+		return strings.Repeat("█", n)
+	}
+	own := func(orders []Order, price float64) string {
+		amount := 0.0
+		for _, o := range orders {
+			if math.Abs(o.Price-price) < 1e-10 {
+				amount += o.Amount
+			}
+		}
+		if amount == 0 {
+			return ""
+		}
+		return "<" + formatNumber(amount)
+	}
+	marks := func(price float64) string {
+		var m []string
+		if f := v.fills[price]; f > 0 {
+			m = append(m, "+"+formatNumber(f))
+		} else if f < 0 {
+			m = append(m, formatNumber(f))
+		}
+		if v.last.Price == price {
+			m = append(m, "*"+v.direction())
+		}
+		return joinNonEmpty(m...)
+	}
+	var myBids, myAsks []Order
+	if v.orders.OrderBookCore != nil {
+		myBids, myAsks = v.orders.Bids(), v.orders.Asks()
+	}
+	for i := len(asks) - 1; i >= 0; i-- {
+		a := asks[i]
+		put(row, "", "", width, "", formatNumber(a.Price), width, bar(a.Amount),
+			formatNumber(a.Amount), joinNonEmpty(own(myAsks, a.Price), marks(a.Price)))
+	}
+	put("%s", v.summary(&ob))
+	for _, o := range bids {
+		put(row, joinNonEmpty(own(myBids, o.Price), marks(o.Price)), formatNumber(o.Amount), width, bar(o.Amount),
+			formatNumber(o.Price), width, "", "", "")
+	}
+	return b.String()
+}
+
+// joinNonEmpty joins the non empty strings with spaces
+func joinNonEmpty(s ...string) string {
+	var res []string
+	for _, x := range s {
+		if x != "" {
+			res = append(res, x)
+		}
+	}
+	return strings.Join(res, " ")
+}
+
+// summary is the line between the asks and the bids
+func (v *LadderView) summary(ob *OrderBook) string {
+	parts := []string{"---"}
+	if ob.Valid() {
+		parts = append(parts, "spread "+formatNumber(ob.Spread()))
+	}
+	if v.last.Price > 0 {
+		side := "buy"
+		if v.last.Maker == Buyer {
+			side = "sell"
+		}
+		parts = append(parts, fmt.Sprintf("last %s %s %s %s", formatNumber(v.last.Price), v.direction(), side,
+			formatNumber(math.Abs(v.last.Amount))))
+	}
+	if v.position != 0 {
+		parts = append(parts, "position "+formatNumber(v.position))
+	}
+	return strings.Join(append(parts, "---"), " ")
+}
+
+// direction is an arrow giving the move of the last trade price
+func (v *LadderView) direction() string {
+	switch {
+	case v.last.Price > v.prev:
+		return "↑"
+	case v.last.Price < v.prev:
+		return "↓"
+	}
+	return "="
+}
@@ -0,0 +1,222 @@
+package bean
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// orderTransitions lists the states an order can move to from each state
+var orderTransitions = map[OrderState][]OrderState{
+	ALIVE:   {PARTIAL, FILLED, CANCELLED, REJECTED},
+	PARTIAL: {PARTIAL, FILLED, CANCELLED},
+}
+
+// CanTransition reports whether an order may move from one state to another. FILLED, CANCELLED and REJECTED are final
+func CanTransition(from, to OrderState) bool {
+	for _, s := range orderTransitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// LimitOrder is a managed limit order on a contract, tracking its fills and state. It is safe for concurrent use
+type LimitOrder struct {
+	m            sync.Mutex
+	id           string
+	contract     *Contract
+	side         Side
+	price        float64
+	size         float64 // always positive, see side
+	filled       float64
+	avgFillPrice float64
+	state        OrderState
+	created      time.Time
+	updated      time.Time
+	msg          string
+}
+
+// NewLimitOrder returns a live order after validating its parameters
+func NewLimitOrder(id string, c *Contract, side Side, price, size float64, t time.Time) (*LimitOrder, error) {
+	o := &LimitOrder{
+		id:       id,
+		contract: c,
+		side:     side,
+		price:    price,
+		size:     size,
+		state:    ALIVE,
+		created:  t,
+		updated:  t,
+	}
+	if err := o.validate(); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+func (o *LimitOrder) validate() error {
+	switch {
+	case o.id == "":
+		return fmt.Errorf("%w: missing order id", ErrInvalidOrder)
+	case o.contract == nil:
+		return fmt.Errorf("%w: missing contract", ErrInvalidOrder)
+	case o.side != BUY && o.side != SELL:
+		return fmt.Errorf("%w: bad side %v", ErrInvalidOrder, o.side)
+	case math.IsNaN(o.price) || math.IsInf(o.price, 0) || o.price <= 0:
+		return fmt.Errorf("%w: bad price %v", ErrInvalidOrder, o.price)
+	case math.IsNaN(o.size) || math.IsInf(o.size, 0) || o.size <= 0:
+		return fmt.Errorf("%w: bad size %v", ErrInvalidOrder, o.size)
+	}
+	return nil
+}
+
+func (o *LimitOrder) ID() string {
+	return o.id
+}
+
+func (o *LimitOrder) Contract() *Contract {
+	return o.contract
+}
+
+func (o *LimitOrder) Side() Side {
+	return o.side
+}
+
+func (o *LimitOrder) Price() float64 {
+	return o.price
+}
+
+func (o *LimitOrder) Size() float64 {
+	return o.size
+}
+
+// Amount returns the signed size, positive for a buy, as used by OrderBook.Match and the Exchange interface
+func (o *LimitOrder) Amount() float64 {
+	if o.side == SELL {
+		return -o.size
+	}
+	return o.size
+}
+
+func (o *LimitOrder) Created() time.Time {
+	return o.created
+}
+
+func (o *LimitOrder) Filled() float64 {
+	o.m.Lock()
+	defer o.m.Unlock()
+	return o.filled
+}
+
+// Remaining returns the size left to fill
+func (o *LimitOrder) Remaining() float64 {
+	o.m.Lock()
+	defer o.m.Unlock()
+	return o.size - o.filled
+}
+
+// AvgFillPrice returns the average price of the fills, NaN if not filled
+func (o *LimitOrder) AvgFillPrice() float64 {
+	o.m.Lock()
+	defer o.m.Unlock()
+	if o.filled == 0 {
+		return math.NaN()
+	}
+	return o.avgFillPrice
+}
+
+func (o *LimitOrder) State() OrderState {
+	o.m.Lock()
+	defer o.m.Unlock()
+	return o.state
+}
+
+func (o *LimitOrder) Updated() time.Time {
+	o.m.Lock()
+	defer o.m.Unlock()
+	return o.updated
+}
+
+// Msg returns the reason given when the order was rejected
+func (o *LimitOrder) Msg() string {
+	o.m.Lock()
+	defer o.m.Unlock()
+	return o.msg
+}
+
+// Live is true while the order can still be filled
+func (o *LimitOrder) Live() bool {
+	s := o.State()
+	return s == ALIVE || s == PARTIAL
+}
+
+func (o *LimitOrder) transition(to OrderState, t time.Time) error {
+	if !CanTransition(o.state, to) {
+		return fmt.Errorf("%w: order %s from %s to %s", ErrInvalidTransition, o.id, o.state, to)
+	}
+	o.state = to
+	o.updated = t
+	return nil
+}
+
+// Fill records a fill of size at price, moving the order to PARTIAL or FILLED
+func (o *LimitOrder) Fill(size, price float64, t time.Time) error {
+	o.m.Lock()
+	defer o.m.Unlock()
+	if size <= 0 || size > o.size-o.filled+1e-12 {
+		return fmt.Errorf("%w: fill of %v on order %s with %v left", ErrInvalidOrder, size, o.id, o.size-o.filled)
+	}
+	to := PARTIAL
+	if o.size-o.filled-size <= 1e-12 {
+		to = FILLED
+	}
+	if err := o.transition(to, t); err != nil {
+		return err
+	}
+	o.avgFillPrice = (o.avgFillPrice*o.filled + price*size) / (o.filled + size)
+	o.filled += size
+	return nil
+}
+
+// Cancel cancels the unfilled part of the order
+func (o *LimitOrder) Cancel(t time.Time) error {
+	o.m.Lock()
+	defer o.m.Unlock()
+	return o.transition(CANCELLED, t)
+}
+
+// Reject marks a new order as rejected by the exchange with the reason given
+func (o *LimitOrder) Reject(msg string, t time.Time) error {
+	o.m.Lock()
+	defer o.m.Unlock()
+	if err := o.transition(REJECTED, t); err != nil {
+		return err
+	}
+	o.msg = msg
+	return nil
+}
+
+// Status returns the order as an OrderStatus
+func (o *LimitOrder) Status() OrderStatus {
+	o.m.Lock()
+	defer o.m.Unlock()
+	price := o.price
+	if o.filled > 0 {
+		price = o.avgFillPrice
+	}
+	return OrderStatus{
+		OrderID:      o.id,
+		PlacedTime:   o.created,
+		Side:         o.side,
+		Instrument:   o.contract.Name(),
+		FilledAmount: o.filled,
+		LeftAmount:   o.size - o.filled,
+		PlacedPrice:  o.price,
+		Price:        price,
+		State:        o.state,
+		Msg:          o.msg,
+	}
+}
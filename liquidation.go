@@ -0,0 +1,72 @@
+package bean
+
+import "math"
+
+// ValuationMode selects the prices positions are valued at from their orderbooks
+type ValuationMode int
+
+const (
+	// MidValuation values positions at the mid of the book
+	MidValuation ValuationMode = iota
+	// LiquidationValuation values positions at the worst price hit when closing the whole position in the book
+	// (BidIn for longs, AskIn for shorts), a conservative haircut for large positions in thin markets
+	LiquidationValuation
+)
+
+func (m ValuationMode) String() string {
+	switch m {
+	case MidValuation:
+		return "MID"
+	case LiquidationValuation:
+		return "LIQUIDATION"
+	}
+	return "UNKNOWN"
+}
+
+// ClosePrice returns the price the position is valued at in its book, NaN if the book has no prices on the side
+// needed. Option prices are in LHS coin, futures in RHS coin as quoted in the book
+func (p Position) ClosePrice(ob OrderBook, mode ValuationMode) float64 {
+	if mode == MidValuation {
+		if !ob.Valid() {
+			return math.NaN()
+		}
+		return ob.Mid()
+	}
+	size := math.Abs(p.qty)
+	var price float64
+	if p.qty >= 0 {
+		price, _ = ob.BidIn(size)
+	} else {
+		price, _ = ob.AskIn(size)
+	}
+	return price
+}
+
+// BookValue returns the value of the position in RHS coin value spot, consistent with PV, using prices from its book.
+// When the book is too thin for the position the rest is valued at the last level in the book
+func (p Position) BookValue(spotPrice float64, ob OrderBook, mode ValuationMode) float64 {
+	price := p.ClosePrice(ob, mode)
+	if p.IsOption() {
+		return (price - p.price) * spotPrice * p.qty
+	}
+	return (1.0/p.price - 1.0/price) * spotPrice * p.qty * p.Multiplier()
+}
+
+// LiquidityCost returns the value lost by closing the position in its book rather than at mid, in RHS coin value spot
+func (p Position) LiquidityCost(spotPrice float64, ob OrderBook) float64 {
+	return p.BookValue(spotPrice, ob, MidValuation) - p.BookValue(spotPrice, ob, LiquidationValuation)
+}
+
+// BookValue returns the value of all positions from their books keyed by contract name. With LiquidationValuation
+// this is the liquidity adjusted PnL of the portfolio. Returns NaN if a position has no book or spot price
+func (p *portfolio) BookValue(books map[string]OrderBook, spots map[Pair]float64, mode ValuationMode) (value float64) {
+	for _, pos := range p.positions {
+		ob, ok := books[pos.Name()]
+		spot, okSpot := spots[pos.Underlying()]
+		if !ok || !okSpot {
+			return math.NaN()
+		}
+		value += pos.BookValue(spot, ob, mode)
+	}
+	return
+}
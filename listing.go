@@ -0,0 +1,159 @@
+package bean
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// StrikeTier gives the strike grid listed for expiries up to MaxDays away (zero for any tenor): strikes are
+// multiples of Step times spot, rounded to a round number, within Range of spot either side (0.5 for +/-50%)
+type StrikeTier struct {
+	MaxDays int
+	Step    float64
+	Range   float64
+}
+
+// ExchangeListingRules describes the expiries and strikes an exchange lists on an underlying
+type ExchangeListingRules struct {
+	ExpiryHour  int          // hour of expiry in UTC
+	Dailies     int          // number of daily expiries listed
+	Weeklies    int          // number of weekly expiries
+	WeeklyDay   time.Weekday // day of the weekly expiries, monthlies and quarterlies are on the last one of the month
+	Monthlies   int
+	Quarterlies int
+	Futures     bool         // list a future on every weekly, monthly and quarterly expiry and a perpetual
+	StrikeTiers []StrikeTier // by increasing MaxDays
+}
+
+// DeribitListingRules returns the listing rules of deribit options on BTC and ETH
+func DeribitListingRules() ExchangeListingRules {
+	return ExchangeListingRules{
+		ExpiryHour:  8,
+		Dailies:     3,
+		Weeklies:    3,
+		WeeklyDay:   time.Friday,
+		Monthlies:   3,
+		Quarterlies: 4,
+		Futures:     true,
+		StrikeTiers: []StrikeTier{
+			{MaxDays: 3, Step: 0.005, Range: 0.1},
+			{MaxDays: 30, Step: 0.02, Range: 0.3},
+			{MaxDays: 0, Step: 0.05, Range: 0.75},
+		},
+	}
+}
+
+// niceStep rounds a strike step to 1, 2, 2.5 or 5 times a power of ten
+func niceStep(x float64) float64 {
+	if x <= 0 {
+		return 0
+	}
+	p := math.Pow(10, math.Floor(math.Log10(x)))
+	for _, m := range []float64{1, 2, 2.5, 5, 10} {
+		if x <= m*p*1.2 {
+			return m * p
+		}
+	}
+	return 10 * p
+}
+
+// ListingExpiries returns the expiries listed after asof, in order
+func (r ExchangeListingRules) ListingExpiries(asof time.Time) []time.Time {
+	asof = asof.UTC()
+	seen := make(map[time.Time]bool)
+	var res []time.Time
+	add := func(t time.Time) {
+		if t.After(asof) && !seen[t] {
+			seen[t] = true
+			res = append(res, t)
+		}
+	}
+	day := time.Date(asof.Year(), asof.Month(), asof.Day(), r.ExpiryHour, 0, 0, 0, time.UTC)
+	if !day.After(asof) {
+		day = day.AddDate(0, 0, 1)
+	}
+	for i := 0; i < r.Dailies; i++ {
+		add(day.AddDate(0, 0, i))
+	}
+	weekly := day
+	for weekly.Weekday() != r.WeeklyDay {
+		weekly = weekly.AddDate(0, 0, 1)
+	}
+	for i := 0; i < r.Weeklies; i++ {
+		add(weekly.AddDate(0, 0, 7*i))
+	}
+	monthly := func(year int, month time.Month) time.Time {
+		t := LastWeekday(year, month, r.WeeklyDay)
+		return time.Date(t.Year(), t.Month(), t.Day(), r.ExpiryHour, 0, 0, 0, time.UTC)
+	}
+	for i, n := 0, 0; n < r.Monthlies; i++ {
+		t := monthly(asof.Year(), asof.Month()+time.Month(i))
+		if t.After(asof) {
+			add(t)
+			n++
+		}
+	}
+	for i, n := 0, 0; n < r.Quarterlies; i++ {
+		m := asof.Month() + time.Month(i)
+		if (int(m)-1)%3 != 2 {
+			continue
+		}
+		t := monthly(asof.Year(), m)
+		if t.After(asof) {
+			add(t)
+			n++
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Before(res[j]) })
+	return res
+}
+
+// Strikes returns the strikes listed for an expiry given the spot price
+func (r ExchangeListingRules) Strikes(asof, expiry time.Time, spotPrice float64) []float64 {
+	if len(r.StrikeTiers) == 0 || spotPrice <= 0 {
+		return nil
+	}
+	days := expiry.Sub(asof).Hours() / 24.0
+	tier := r.StrikeTiers[len(r.StrikeTiers)-1]
+	for _, t := range r.StrikeTiers {
+		if t.MaxDays == 0 || days <= float64(t.MaxDays) {
+			tier = t
+			break
+		}
+	}
+	step := niceStep(tier.Step * spotPrice)
+	var res []float64
+	for k := math.Ceil(spotPrice*(1-tier.Range)/step) * step; k <= spotPrice*(1+tier.Range); k += step {
+		if k > 0 {
+			res = append(res, math.Round(k/step)*step)
+		}
+	}
+	return res
+}
+
+// ListListedContracts returns the contracts an exchange would list on an underlying as of a time given the spot
+// price: a call and a put on each strike of each expiry, then the futures and perpetual if the rules list them
+func ListListedContracts(underlying Pair, asof time.Time, spotPrice float64, rules ExchangeListingRules) []*Contract {
+	var res []*Contract
+	expiries := rules.ListingExpiries(asof)
+	for _, expiry := range expiries {
+		for _, k := range rules.Strikes(asof, expiry, spotPrice) {
+			res = append(res, OptContract(underlying, expiry, k, Call), OptContract(underlying, expiry, k, Put))
+		}
+	}
+	if rules.Futures {
+		dailies := make(map[time.Time]bool)
+		day := asof.UTC()
+		for i := 0; i < rules.Dailies+1; i++ {
+			dailies[time.Date(day.Year(), day.Month(), day.Day()+i, rules.ExpiryHour, 0, 0, 0, time.UTC)] = true
+		}
+		for _, expiry := range expiries {
+			if expiry.Weekday() == rules.WeeklyDay || !dailies[expiry] {
+				res = append(res, FutContract(underlying, expiry))
+			}
+		}
+		res = append(res, PerpContract(underlying))
+	}
+	return res
+}
@@ -121,3 +121,29 @@ func Printf(format string, v ...interface{}) {
 func Ctx(ctx context.Context) *zerolog.Logger {
 	return zerolog.Ctx(ctx)
 }
+
+// BeanLogger adapts a zerolog logger to the bean.Logger interface, e.g. bean.SetLogger(logger.Bean())
+type BeanLogger struct {
+	zerolog.Logger
+}
+
+// Bean returns the global logger as a bean.Logger
+func Bean() BeanLogger {
+	return BeanLogger{Logger}
+}
+
+func (l BeanLogger) Debugf(format string, args ...interface{}) {
+	l.Logger.Debug().Msgf(format, args...)
+}
+
+func (l BeanLogger) Infof(format string, args ...interface{}) {
+	l.Logger.Info().Msgf(format, args...)
+}
+
+func (l BeanLogger) Warnf(format string, args ...interface{}) {
+	l.Logger.Warn().Msgf(format, args...)
+}
+
+func (l BeanLogger) Errorf(format string, args ...interface{}) {
+	l.Logger.Error().Msgf(format, args...)
+}
@@ -0,0 +1,41 @@
+package bean
+
+import "sync"
+
+// Logger receives the log messages of the library. The default discards everything, use SetLogger to plug in a
+// real logger (see logger.Bean for a zerolog adapter)
+type Logger interface {
+	Debugf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Debugf(format string, args ...interface{}) {}
+func (nopLogger) Infof(format string, args ...interface{})  {}
+func (nopLogger) Warnf(format string, args ...interface{})  {}
+func (nopLogger) Errorf(format string, args ...interface{}) {}
+
+var (
+	logMutex sync.RWMutex
+	logger   Logger = nopLogger{}
+)
+
+// SetLogger sets the logger used by the library, nil to discard log messages
+func SetLogger(l Logger) {
+	logMutex.Lock()
+	defer logMutex.Unlock()
+	if l == nil {
+		l = nopLogger{}
+	}
+	logger = l
+}
+
+// Log returns the logger used by the library
+func Log() Logger {
+	logMutex.RLock()
+	defer logMutex.RUnlock()
+	return logger
+}
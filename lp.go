@@ -0,0 +1,142 @@
+package bean
+
+import (
+	"fmt"
+	"math"
+)
+
+const lpEps = 1e-9
+
+// solveLP minimizes c.x subject to A x <= b and x >= 0 with the two phase simplex method and Bland's rule.
+// Fails with ErrInfeasible when no x meets the constraints
+func solveLP(c []float64, A [][]float64, b []float64) ([]float64, error) {
+	m, n := len(A), len(c)
+	artificials := 0
+	for _, v := range b {
+		if v < 0 {
+			artificials++
+		}
+	}
+	// columns: the variables, a slack per row, an artificial per row of negative bound, then the bounds
+	cols := n + m + artificials
+	t := make([][]float64, m)
+	basis := make([]int, m)
+	k := n + m
+	for i := range A {
+		row := make([]float64, cols+1)
+		sign := 1.0
+		if b[i] < 0 {
+			sign = -1
+		}
+		for j := 0; j < n; j++ {
+			row[j] = sign * A[i][j]
+		}
+		row[n+i] = sign
+		row[cols] = sign * b[i]
+		basis[i] = n + i
+		if b[i] < 0 {
+			row[k], basis[i] = 1, k
+			k++
+		}
+		t[i] = row
+	}
+
+	if artificials > 0 {
+		cost := make([]float64, cols)
+		for j := n + m; j < cols; j++ {
+			cost[j] = 1
+		}
+		if err := simplex(t, basis, cost); err != nil {
+			return nil, err
+		}
+		infeasibility := 0.0
+		for i, j := range basis {
+			infeasibility += cost[j] * t[i][cols]
+		}
+		if infeasibility > 1e-7 {
+			return nil, fmt.Errorf("%w: constraints violated by %g", ErrInfeasible, infeasibility)
+		}
+		for i, j := range basis {
+			if j < n+m {
+				continue
+			}
+			for e := 0; e < n+m; e++ {
+				if math.Abs(t[i][e]) > lpEps {
+					pivot(t, basis, i, e)
+					break
+				}
+			}
+		}
+		for i := range t {
+			for j := n + m; j < cols; j++ {
+				t[i][j] = 0
+			}
+		}
+	}
+
+	cost := make([]float64, cols)
+	copy(cost, c)
+	if err := simplex(t, basis, cost); err != nil {
+		return nil, err
+	}
+	x := make([]float64, n)
+	for i, j := range basis {
+		if j < n {
+			x[j] = t[i][cols]
+		}
+	}
+	return x, nil
+}
+
+// simplex pivots the tableau to the minimum of cost from a feasible basis
+func simplex(t [][]float64, basis []int, cost []float64) error {
+	cols := len(cost)
+	for iter := 0; iter < 50*(cols+len(t)); iter++ {
+		enter := -1
+		for j := 0; j < cols && enter < 0; j++ {
+			reduced := cost[j]
+			for i, bj := range basis {
+				reduced -= cost[bj] * t[i][j]
+			}
+			if reduced < -lpEps {
+				enter = j
+			}
+		}
+		if enter < 0 {
+			return nil
+		}
+		leave := -1
+		best := math.Inf(1)
+		for i := range t {
+			if t[i][enter] <= lpEps {
+				continue
+			}
+			ratio := t[i][cols] / t[i][enter]
+			if ratio < best-lpEps || (ratio < best+lpEps && leave >= 0 && basis[i] < basis[leave]) {
+				best, leave = ratio, i
+			}
+		}
+		if leave < 0 {
+			return fmt.Errorf("%w: unbounded objective", ErrInvalidInput)
+		}
+		pivot(t, basis, leave, enter)
+	}
+	return fmt.Errorf("%w: simplex did not converge", ErrNoConvergence)
+}
+
+func pivot(t [][]float64, basis []int, row, col int) {
+	p := t[row][col]
+	for j := range t[row] {
+		t[row][j] /= p
+	}
+	for i := range t {
+		if i == row || t[i][col] == 0 {
+			continue
+		}
+		f := t[i][col]
+		for j := range t[i] {
+			t[i][j] -= f * t[row][j]
+		}
+	}
+	basis[row] = col
+}
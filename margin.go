@@ -0,0 +1,99 @@
+package bean
+
+import "math"
+
+// MarginSchedule gives the margin requirements of futures as proportions of the notional at the mark price.
+// LiquidationFee is charged on liquidation, so a position is liquidated when its equity falls to the maintenance
+// margin plus that fee
+type MarginSchedule struct {
+	InitialRate     float64
+	MaintenanceRate float64
+	LiquidationFee  float64
+
+	// short options margin per contract in LHS coin: ShortOptionRate less the out of the money amount (relative to
+	// spot), floored at ShortOptionMinRate, plus the option price. Long options need no margin
+	ShortOptionRate    float64
+	ShortOptionMinRate float64
+}
+
+// DeribitMarginSchedule returns the base margin requirements of deribit BTC and ETH futures
+func DeribitMarginSchedule() MarginSchedule {
+	return MarginSchedule{InitialRate: 0.01, MaintenanceRate: 0.005, ShortOptionRate: 0.15, ShortOptionMinRate: 0.1}
+}
+
+// notional returns the number of USD of a futures position, see Contract.Multiplier
+func (p Position) notional() float64 {
+	return p.qty * p.Multiplier()
+}
+
+// MaintenanceMargin returns the maintenance margin of a futures position in LHS coin at a mark price
+func (p Position) MaintenanceMargin(mark float64, ms MarginSchedule) float64 {
+	return math.Abs(p.notional()) / mark * ms.MaintenanceRate
+}
+
+// InitialMargin returns the initial margin of a futures position in LHS coin at a mark price
+func (p Position) InitialMargin(mark float64, ms MarginSchedule) float64 {
+	return math.Abs(p.notional()) / mark * ms.InitialRate
+}
+
+// inverseLiquidation solves balance + pnl(M) = rate * |notional| / M for the mark M of an inverse future, where
+// pnl(M) = notional * (1/entry - 1/M). Returns +Inf for shorts the balance covers at any price
+func (p Position) inverseLiquidation(balance, rate float64) float64 {
+	if p.IsOption() || p.qty == 0 || !validPrice(p.price) {
+		return math.NaN()
+	}
+	n := p.notional()
+	liq := (n + rate*math.Abs(n)) / (balance + n/p.price)
+	if liq <= 0 || math.IsNaN(liq) {
+		return math.Inf(1)
+	}
+	return liq
+}
+
+// LiquidationPrice returns the mark price at which an inverse future or perpetual is liquidated given the margin
+// balance in LHS coin backing it (isolated margin, excluding the position's own PnL), as computed by deribit and
+// binance coin margined futures. Returns NaN for options and flat positions and +Inf for shorts that cannot be
+// liquidated
+func (p Position) LiquidationPrice(balance float64, ms MarginSchedule) float64 {
+	return p.inverseLiquidation(balance, ms.MaintenanceRate+ms.LiquidationFee)
+}
+
+// BankruptcyPrice returns the mark price at which the equity backing the position is zero
+func (p Position) BankruptcyPrice(balance float64) float64 {
+	return p.inverseLiquidation(balance, 0)
+}
+
+// DistanceToLiquidation returns the relative move of the mark against the position that triggers liquidation,
+// e.g. 0.2 when a long is liquidated 20% below the mark. It is negative if the position is already liquidated
+func (p Position) DistanceToLiquidation(mark, balance float64, ms MarginSchedule) float64 {
+	liq := p.LiquidationPrice(balance, ms)
+	if p.qty > 0 {
+		return 1 - liq/mark
+	}
+	return liq/mark - 1
+}
+
+// NearLiquidation is true when the mark is within threshold (relative) of the liquidation price
+func (p Position) NearLiquidation(mark, balance float64, ms MarginSchedule, threshold float64) bool {
+	return p.DistanceToLiquidation(mark, balance, ms) < threshold
+}
+
+// LinearLiquidationPrice returns the liquidation price of a linear (USDT margined) future of qty coins
+// (negative for shorts) entered at entry with balance RHS coin of margin, as computed by binance:
+// balance + qty * (M - entry) = rate * |qty| * M. Returns NaN for flat positions and zero for longs that
+// cannot be liquidated
+func LinearLiquidationPrice(qty, entry, balance float64, ms MarginSchedule) float64 {
+	return linearLiquidation(qty, entry, balance, ms.MaintenanceRate+ms.LiquidationFee)
+}
+
+// LinearBankruptcyPrice returns the price at which a linear future's margin is exhausted
+func LinearBankruptcyPrice(qty, entry, balance float64) float64 {
+	return linearLiquidation(qty, entry, balance, 0)
+}
+
+func linearLiquidation(qty, entry, balance, rate float64) float64 {
+	if qty == 0 {
+		return math.NaN()
+	}
+	return math.Max((qty*entry-balance)/(qty-rate*math.Abs(qty)), 0)
+}
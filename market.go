@@ -0,0 +1,325 @@
+package bean
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// VolSurface gives the implied vol of an option by expiry and strike. The forward is given to allow
+// surfaces parameterised by moneyness
+type VolSurface interface {
+	Vol(expiry time.Time, strike, forward float64) float64
+}
+
+// FlatVol is a vol surface with the same vol for all expiries and strikes
+type FlatVol float64
+
+func (v FlatVol) Vol(expiry time.Time, strike, forward float64) float64 {
+	return float64(v)
+}
+
+// Revisioned is implemented by market data keeping a counter increased on every change, so values derived from
+// it can be cached until the counter moves rather than compared or recomputed
+type Revisioned interface {
+	Revision() int64
+}
+
+// revisionOf returns the revision of v, zero if it does not keep one
+func revisionOf(v interface{}) int64 {
+	if r, ok := v.(Revisioned); ok {
+		return r.Revision()
+	}
+	return 0
+}
+
+type volKey struct {
+	expiry          time.Time
+	strike, forward float64
+}
+
+// CachedVolSurface memoizes the vols of a surface, which may be expensive to interpolate, until the revision of
+// the surface changes. Surfaces that are not Revisioned are assumed never to change. It is safe for concurrent use
+type CachedVolSurface struct {
+	surface  VolSurface
+	m        sync.Mutex
+	revision int64
+	cache    map[volKey]float64
+}
+
+// NewCachedVolSurface returns a caching wrapper of vs
+func NewCachedVolSurface(vs VolSurface) *CachedVolSurface {
+	return &CachedVolSurface{surface: vs, revision: revisionOf(vs), cache: make(map[volKey]float64)}
+}
+
+func (c *CachedVolSurface) Vol(expiry time.Time, strike, forward float64) float64 {
+	rev := revisionOf(c.surface)
+	k := volKey{expiry: expiry, strike: strike, forward: forward}
+	c.m.Lock()
+	defer c.m.Unlock()
+	if rev != c.revision {
+		c.revision = rev
+		c.cache = make(map[volKey]float64)
+	}
+	if v, ok := c.cache[k]; ok {
+		return v
+	}
+	v := c.surface.Vol(expiry, strike, forward)
+	c.cache[k] = v
+	return v
+}
+
+// Revision returns the revision of the wrapped surface
+func (c *CachedVolSurface) Revision() int64 {
+	return revisionOf(c.surface)
+}
+
+// Market is a snapshot of the market data needed to value positions: spot prices per pair, futures prices per
+// underlying, vol surfaces and perpetual funding rates. It is safe for concurrent use. Every change increases its
+// revision, see Revision
+type Market struct {
+	m        sync.RWMutex
+	asof     time.Time
+	rev      int64          // counter of the changes
+	asofRev  int64          // rev of the last change of asof
+	revs     map[Pair]int64 // rev of the last change of each underlying
+	volRevs  map[Pair]int64 // revisions of the vol surfaces as of their last change seen
+	spots    map[Pair]float64
+	futures  map[Pair]map[string]futurePrice // futures prices of each underlying keyed by contract name
+	vols     map[Pair]VolSurface
+	fundings map[Pair]float64
+}
+
+type futurePrice struct {
+	contract *Contract
+	price    float64
+}
+
+// NewMarket returns an empty market as of asof
+func NewMarket(asof time.Time) *Market {
+	return &Market{
+		asof:     asof,
+		revs:     make(map[Pair]int64),
+		volRevs:  make(map[Pair]int64),
+		spots:    make(map[Pair]float64),
+		futures:  make(map[Pair]map[string]futurePrice),
+		vols:     make(map[Pair]VolSurface),
+		fundings: make(map[Pair]float64),
+	}
+}
+
+func (m *Market) Asof() time.Time {
+	m.m.RLock()
+	defer m.m.RUnlock()
+	return m.asof
+}
+
+func (m *Market) SetAsof(asof time.Time) {
+	m.m.Lock()
+	defer m.m.Unlock()
+	m.asof = asof
+	m.rev++
+	m.asofRev = m.rev
+}
+
+// changed records a change of an underlying, called with the lock held
+func (m *Market) changed(p Pair) {
+	m.rev++
+	m.revs[p] = m.rev
+}
+
+func (m *Market) SetSpot(p Pair, price float64) {
+	m.m.Lock()
+	defer m.m.Unlock()
+	m.spots[p] = price
+	m.changed(p)
+}
+
+// SetFuture sets the price of a dated future or perpetual
+func (m *Market) SetFuture(c *Contract, price float64) {
+	m.m.Lock()
+	defer m.m.Unlock()
+	under := c.Underlying()
+	if _, ok := m.futures[under]; !ok {
+		m.futures[under] = make(map[string]futurePrice)
+	}
+	m.futures[under][c.Name()] = futurePrice{contract: c, price: price}
+	m.changed(under)
+}
+
+func (m *Market) SetVolSurface(p Pair, vs VolSurface) {
+	m.m.Lock()
+	defer m.m.Unlock()
+	m.vols[p] = vs
+	m.volRevs[p] = revisionOf(vs)
+	m.changed(p)
+}
+
+// SetFunding sets the funding rate of the perpetual on an underlying
+func (m *Market) SetFunding(p Pair, rate float64) {
+	m.m.Lock()
+	defer m.m.Unlock()
+	m.fundings[p] = rate
+	m.changed(p)
+}
+
+// Revision returns a counter increased by every change of the market, including changes of Revisioned vol
+// surfaces, so values computed from the market are stale once it differs from the one they were computed at
+func (m *Market) Revision() int64 {
+	m.m.Lock()
+	defer m.m.Unlock()
+	for p := range m.vols {
+		m.syncVol(p)
+	}
+	return m.rev
+}
+
+// syncVol records a change of the surface of an underlying since it was last seen, called with the lock held
+func (m *Market) syncVol(p Pair) {
+	vs, ok := m.vols[p]
+	if !ok {
+		return
+	}
+	if r := revisionOf(vs); r != m.volRevs[p] {
+		m.volRevs[p] = r
+		m.changed(p)
+	}
+}
+
+// UnderlyingRevision returns a counter increased by every change of the market affecting an underlying: its
+// prices, surface or funding and the pricing time
+func (m *Market) UnderlyingRevision(p Pair) int64 {
+	m.m.Lock()
+	defer m.m.Unlock()
+	m.syncVol(p)
+	if m.asofRev > m.revs[p] {
+		return m.asofRev
+	}
+	return m.revs[p]
+}
+
+// Spot returns the spot price of a pair, NaN if not in the market
+func (m *Market) Spot(p Pair) float64 {
+	m.m.RLock()
+	defer m.m.RUnlock()
+	if s, ok := m.spots[p]; ok {
+		return s
+	}
+	return math.NaN()
+}
+
+// Funding returns the funding rate of the perpetual on an underlying, zero if not in the market
+func (m *Market) Funding(p Pair) float64 {
+	m.m.RLock()
+	defer m.m.RUnlock()
+	return m.fundings[p]
+}
+
+// Forward returns the forward price for the delivery of a contract: the price of the future with the same expiry,
+// the perpetual price for perpetuals, the forward interpolated on the futures curve for other expiries,
+// or spot when no future is available
+func (m *Market) Forward(c *Contract) float64 {
+	m.m.RLock()
+	defer m.m.RUnlock()
+	under := c.Underlying()
+	for _, fp := range m.futures[under] {
+		if (c.Perp() && fp.contract.Perp()) || (!c.Perp() && !fp.contract.Perp() && fp.contract.Expiry().Equal(c.Expiry())) {
+			return fp.price
+		}
+	}
+	if s, ok := m.spots[under]; ok {
+		if c.Perp() || c.Index() {
+			return s
+		}
+		return m.curve(under).Forward(c.Expiry())
+	}
+	return math.NaN()
+}
+
+// Curve returns the futures curve of an underlying built from the spot and futures prices in the market
+func (m *Market) Curve(p Pair) *FuturesCurve {
+	m.m.RLock()
+	defer m.m.RUnlock()
+	return m.curve(p)
+}
+
+func (m *Market) curve(p Pair) *FuturesCurve {
+	spot, ok := m.spots[p]
+	if !ok {
+		spot = math.NaN()
+	}
+	fc := NewFuturesCurve(p, m.asof, spot)
+	for _, fp := range m.futures[p] {
+		fc.SetFuture(fp.contract, fp.price)
+	}
+	return fc
+}
+
+// Vol returns the implied vol of an option from the surface of its underlying, NaN if there is no surface
+func (m *Market) Vol(c *Contract) float64 {
+	forward := m.Forward(c)
+	m.m.RLock()
+	vs, ok := m.vols[c.Underlying()]
+	m.m.RUnlock()
+	if !ok {
+		return math.NaN()
+	}
+	return vs.Vol(c.Expiry(), c.Strike(), forward)
+}
+
+// Params returns the spot, future and vol used to value a position. It can be used as a PositionMarket
+func (m *Market) Params(p Position) (spotPrice, futPrice, vol float64) {
+	spotPrice = m.Spot(p.Underlying())
+	futPrice = m.Forward(p.Contract)
+	vol = 0.0
+	if p.IsOption() {
+		vol = m.Vol(p.Contract)
+	}
+	return
+}
+
+// OptPriceMarket returns the price of an option in RHS coin value spot using the market
+func (c Contract) OptPriceMarket(m *Market) (float64, error) {
+	spotPrice := m.Spot(c.Underlying())
+	return c.OptPrice(m.Asof(), spotPrice, m.Forward(&c), m.Vol(&c))
+}
+
+// ImpVolMarket returns the implied vol of an option given its price in LHS coin using the market
+func (c Contract) ImpVolMarket(m *Market, optionPrice float64) (float64, error) {
+	return c.ImpVol(m.Asof(), m.Spot(c.Underlying()), m.Forward(&c), optionPrice)
+}
+
+func (p Position) PVMarket(m *Market) float64 {
+	spot, fut, vol := m.Params(p)
+	return p.PV(m.Asof(), spot, fut, vol)
+}
+
+func (p Position) DeltaMarket(m *Market) float64 {
+	spot, fut, vol := m.Params(p)
+	return p.Delta(m.Asof(), spot, fut, vol)
+}
+
+func (p Position) GammaMarket(m *Market) float64 {
+	spot, fut, vol := m.Params(p)
+	return p.Gamma(m.Asof(), spot, fut, vol)
+}
+
+func (p Position) VegaMarket(m *Market) float64 {
+	spot, fut, vol := m.Params(p)
+	return p.Vega(m.Asof(), spot, fut, vol)
+}
+
+func (p Position) ThetaMarket(m *Market) float64 {
+	spot, fut, vol := m.Params(p)
+	return p.Theta(m.Asof(), spot, fut, vol)
+}
+
+func (p Position) BucketDeltaMarket(m *Market) map[string]float64 {
+	spot, fut, vol := m.Params(p)
+	return p.BucketDelta(m.Asof(), spot, fut, vol)
+}
+
+func (p Position) GreeksMarket(m *Market) Greeks {
+	spot, fut, vol := m.Params(p)
+	return p.Greeks(m.Asof(), spot, fut, vol)
+}
@@ -0,0 +1,426 @@
+package bean
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// MatchFill is a fill of an order of a Matcher
+type MatchFill struct {
+	ExecID string      // numbers the fills of the matcher from 1
+	Order  OrderStatus // after the fill
+	Price  float64
+	Amount float64 // signed, positive for buys
+	Maker  bool
+	Time   time.Time
+}
+
+// Matcher simulates the matching of our limit orders on the books and trades of an exchange, the core of the
+// backtest engine and the simulated brokers. Orders crossing the book when they arrive fill as takers walking it,
+// moved by the slippage model, and the rest rests until the books or the trades cross it, filling as a maker.
+// Placements and cancels reach the book after the delay of the latency model. With the queue model a resting
+// order starts behind the size displayed at its price and fills on trades at its price only once they have
+// consumed the queue ahead, which shrinks to the displayed size when it drops. A price beyond the depth of a
+// truncated book keeps the last queue known. Trades through the price fill it as without the model.
+// Time only moves forward, with the market data and Advance. It is not safe for concurrent use
+type Matcher struct {
+	Latency  LatencyModel  // nil for none
+	Slippage SlippageModel // nil for none
+	Queue    bool
+
+	now      time.Time
+	books    map[string]OrderBookT
+	orders   map[string][]*matchOrder // working orders by instrument, in placement order
+	oid      int
+	fills    int
+	onUpdate func(OrderStatus)
+	onFill   func(MatchFill)
+}
+
+type matchOrder struct {
+	status   OrderStatus
+	amount   float64   // signed amount left, positive buy
+	arrival  time.Time // when the order reaches the book, the order is pending before
+	cancelAt time.Time // when its cancel reaches the book, zero if not cancelled
+	ahead    float64   // amount resting ahead at its price, with the queue model
+}
+
+// working is true until the order is filled or cancelled
+func (o *matchOrder) working() bool {
+	return o.status.State == ALIVE || o.status.State == PARTIAL
+}
+
+// NewMatcher returns a matcher without latency, slippage nor queue model
+func NewMatcher() *Matcher {
+	return &Matcher{books: make(map[string]OrderBookT), orders: make(map[string][]*matchOrder)}
+}
+
+// OnUpdate sets the function called with the status of the orders placed and cancelled
+func (m *Matcher) OnUpdate(f func(OrderStatus)) {
+	m.onUpdate = f
+}
+
+// OnFill sets the function called with each fill
+func (m *Matcher) OnFill(f func(MatchFill)) {
+	m.onFill = f
+}
+
+func (m *Matcher) Now() time.Time {
+	return m.now
+}
+
+// Book returns the latest book of an instrument
+func (m *Matcher) Book(instrument string) OrderBookT {
+	return m.books[instrument]
+}
+
+// Books returns the latest book of each instrument
+func (m *Matcher) Books() map[string]OrderBookT {
+	res := make(map[string]OrderBookT, len(m.books))
+	for name, ob := range m.books {
+		res[name] = ob
+	}
+	return res
+}
+
+// Advance applies the requests arriving up to t and moves the time to t, if later
+func (m *Matcher) Advance(t time.Time) {
+	m.arrive(t)
+	m.setNow(t)
+}
+
+func (m *Matcher) setNow(t time.Time) {
+	if t.After(m.now) {
+		m.now = t
+	}
+}
+
+func (m *Matcher) delay() time.Duration {
+	if m.Latency == nil {
+		return 0
+	}
+	if d := m.Latency.Delay(); d > 0 {
+		return d
+	}
+	return 0
+}
+
+// Place places a limit order on an instrument, positive amount to buy, and returns its id. The part crossing the
+// book when the order arrives fills at once as a taker
+func (m *Matcher) Place(instrument string, price, amount float64) (string, error) {
+	if math.IsNaN(price) || math.IsInf(price, 0) || price < 0 {
+		return "", fmt.Errorf("%w: bad price %v", ErrInvalidOrder, price)
+	}
+	if math.IsNaN(amount) || math.IsInf(amount, 0) || amount == 0 {
+		return "", fmt.Errorf("%w: bad amount %v", ErrInvalidOrder, amount)
+	}
+	m.oid++
+	o := &matchOrder{
+		status: OrderStatus{
+			OrderID:     strconv.Itoa(m.oid),
+			PlacedTime:  m.now,
+			Side:        AmountToSide(amount),
+			Instrument:  instrument,
+			LeftAmount:  math.Abs(amount),
+			PlacedPrice: price,
+			Price:       price,
+			State:       ALIVE,
+		},
+		amount:  amount,
+		arrival: m.now.Add(m.delay()),
+	}
+	m.orders[instrument] = append(m.orders[instrument], o)
+	m.update(o)
+	if !o.arrival.After(m.now) {
+		m.join(o)
+	}
+	m.prune(instrument)
+	return o.status.OrderID, nil
+}
+
+// Cancel cancels a working order, once the request arrives with latency. Orders filled, cancelled or already
+// being cancelled are ErrInvalidOrder
+func (m *Matcher) Cancel(instrument, oid string) error {
+	for _, o := range m.orders[instrument] {
+		if o.status.OrderID != oid || !o.working() || !o.cancelAt.IsZero() {
+			continue
+		}
+		o.cancelAt = m.now.Add(m.delay())
+		if !o.cancelAt.After(m.now) {
+			m.cancel(o)
+			m.prune(instrument)
+		}
+		return nil
+	}
+	return fmt.Errorf("%w: no live order %s on %s", ErrInvalidOrder, oid, instrument)
+}
+
+func (m *Matcher) cancel(o *matchOrder) {
+	o.status.State = CANCELLED
+	m.update(o)
+}
+
+// OpenOrders returns the working orders of an instrument, including those still on their way to the book
+func (m *Matcher) OpenOrders(instrument string) []OrderStatus {
+	var res []OrderStatus
+	for _, o := range m.orders[instrument] {
+		res = append(res, o.status)
+	}
+	return res
+}
+
+// Orders returns the working orders of all instruments, by instrument and order id in placement order
+func (m *Matcher) Orders() []OrderStatus {
+	var res []OrderStatus
+	for _, orders := range m.orders {
+		for _, o := range orders {
+			res = append(res, o.status)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Instrument != res[j].Instrument {
+			return res[i].Instrument < res[j].Instrument
+		}
+		return orderIDLess(res[i].OrderID, res[j].OrderID)
+	})
+	return res
+}
+
+// orderIDLess compares numeric ids by value, so that "9" comes before "10", others as strings
+func orderIDLess(a, b string) bool {
+	ia, errA := strconv.Atoi(a)
+	ib, errB := strconv.Atoi(b)
+	if errA != nil || errB != nil {
+		return a < b
+	}
+	return ia < ib
+}
+
+// QueuePosition returns the amount resting ahead of a live order with the queue model, false if it is not live
+func (m *Matcher) QueuePosition(oid string) (ahead float64, ok bool) {
+	for _, orders := range m.orders {
+		for _, o := range orders {
+			if o.status.OrderID == oid && m.live(o) {
+				return o.ahead, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// Restore replaces the books and working orders, as returned by Books and Orders, the orders having arrived.
+// Order ids continue after the largest restored and the time after the latest book
+func (m *Matcher) Restore(books map[string]OrderBookT, orders []OrderStatus) {
+	m.books = make(map[string]OrderBookT, len(books))
+	for name, ob := range books {
+		m.books[name] = ob
+		m.setNow(ob.Time)
+	}
+	m.orders = make(map[string][]*matchOrder)
+	for _, s := range orders {
+		if s.State != ALIVE && s.State != PARTIAL {
+			continue
+		}
+		amount := s.LeftAmount
+		if s.Side == SELL {
+			amount = -amount
+		}
+		m.orders[s.Instrument] = append(m.orders[s.Instrument], &matchOrder{status: s, amount: amount, arrival: s.PlacedTime})
+		if id, err := strconv.Atoi(s.OrderID); err == nil && id > m.oid {
+			m.oid = id
+		}
+	}
+}
+
+// OnBook applies the requests arriving up to the book, then matches the orders of the instrument against it
+func (m *Matcher) OnBook(instrument string, ob OrderBookT) {
+	m.Advance(ob.Time)
+	m.books[instrument] = ob
+	for _, o := range m.orders[instrument] {
+		m.matchBook(o, true)
+		if size, shown := m.displayed(o); m.Queue && m.live(o) && shown {
+			o.ahead = math.Min(o.ahead, size)
+		}
+	}
+	m.prune(instrument)
+}
+
+// OnTrade applies the requests arriving up to the trade, then fills the resting orders of the instrument it
+// goes through
+func (m *Matcher) OnTrade(instrument string, txn Transaction) {
+	m.Advance(txn.TimeStamp)
+	for _, o := range m.orders[instrument] {
+		m.matchTrade(o, txn)
+	}
+	m.prune(instrument)
+}
+
+// arrive applies the order requests arriving at or before t in time order, matching the orders placed against
+// the current book
+func (m *Matcher) arrive(t time.Time) {
+	for {
+		var next *matchOrder
+		var at time.Time
+		cancel := false
+		for _, orders := range m.orders {
+			for _, o := range orders {
+				if !o.working() {
+					continue
+				}
+				if o.arrival.After(m.now) && !o.arrival.After(t) && (next == nil || o.arrival.Before(at)) {
+					next, at, cancel = o, o.arrival, false
+				}
+				if !o.cancelAt.IsZero() && !o.cancelAt.After(t) && !o.arrival.After(m.now) &&
+					(next == nil || o.cancelAt.Before(at)) {
+					next, at, cancel = o, o.cancelAt, true
+				}
+			}
+		}
+		if next == nil {
+			return
+		}
+		m.setNow(at) // a cancel overtaken by its order applies on the order arrival
+		if cancel {
+			m.cancel(next)
+		} else {
+			m.join(next)
+		}
+		m.prune(next.status.Instrument)
+	}
+}
+
+// live is true for working orders arrived at the book
+func (m *Matcher) live(o *matchOrder) bool {
+	return o.working() && !o.arrival.After(m.now)
+}
+
+// join queues an order arriving at the book behind the size displayed at its price
+func (m *Matcher) join(o *matchOrder) {
+	m.matchBook(o, false)
+	if m.Queue && m.live(o) {
+		o.ahead, _ = m.displayed(o)
+	}
+}
+
+// displayed returns the size of the book of an order at its price, on its side. It is not shown when the price is
+// beyond the levels of the book, which may be truncated
+func (m *Matcher) displayed(o *matchOrder) (size float64, shown bool) {
+	ob := m.books[o.status.Instrument]
+	if ob.OrderBookCore == nil {
+		return 0, false
+	}
+	levels := ob.Bids()
+	if o.amount < 0 {
+		levels = ob.Asks()
+	}
+	for _, l := range levels {
+		if samePrice(l.Price, o.status.PlacedPrice) {
+			size += l.Amount
+			shown = true
+		}
+	}
+	if n := len(levels); n > 0 && !shown {
+		worst := levels[n-1].Price
+		shown = (o.amount > 0 && worst < o.status.PlacedPrice) || (o.amount < 0 && worst > o.status.PlacedPrice)
+	}
+	return size, shown
+}
+
+func samePrice(a, b float64) bool {
+	return math.Abs(a-b) <= 1e-9*math.Max(math.Abs(a), math.Abs(b))
+}
+
+// matchBook fills a live order against the book of its instrument: as a taker moved by the slippage model when
+// it arrives, as a maker when it rests and a later book crosses it
+func (m *Matcher) matchBook(o *matchOrder, maker bool) {
+	ob := m.books[o.status.Instrument]
+	if !m.live(o) || ob.OrderBookCore == nil {
+		return
+	}
+	fill := ob.Match(Order{Price: o.status.PlacedPrice, Amount: o.amount})
+	if fill.Amount == 0 {
+		return
+	}
+	if m.Slippage != nil && !maker {
+		fill.Price = m.Slippage.Slip(ob, fill.Price, fill.Amount)
+		if fill.Amount > 0 {
+			fill.Price = math.Min(fill.Price, o.status.PlacedPrice)
+		} else {
+			fill.Price = math.Max(fill.Price, o.status.PlacedPrice)
+		}
+	}
+	m.fill(o, fill.Price, fill.Amount, maker)
+}
+
+// matchTrade fills a resting order against a market trade through its price, as a maker. With the queue model
+// trades at its price hitting its side fill it once they have consumed the queue ahead
+func (m *Matcher) matchTrade(o *matchOrder, txn Transaction) {
+	if !m.live(o) {
+		return
+	}
+	amount := Transactions{txn}.Fill(o.status.PlacedPrice, o.amount)
+	if m.Queue && amount == 0 && samePrice(txn.Price, o.status.PlacedPrice) &&
+		(o.amount > 0) == (txn.Maker == Buyer) {
+		size := math.Abs(txn.Amount)
+		consumed := math.Min(size, o.ahead)
+		o.ahead -= consumed
+		amount = math.Copysign(math.Min(size-consumed, math.Abs(o.amount)), o.amount)
+	}
+	if amount != 0 {
+		m.fill(o, o.status.PlacedPrice, amount, true)
+	}
+}
+
+func (m *Matcher) fill(o *matchOrder, price, amount float64, maker bool) {
+	if math.Abs(amount) > math.Abs(o.amount) {
+		amount = o.amount
+	}
+	filled := o.status.FilledAmount
+	o.status.Price = (o.status.Price*filled + price*math.Abs(amount)) / (filled + math.Abs(amount))
+	if filled == 0 {
+		o.status.Price = price
+	}
+	o.status.FilledAmount += math.Abs(amount)
+	o.amount -= amount
+	o.status.LeftAmount = math.Abs(o.amount)
+	if o.status.LeftAmount < 1e-12 {
+		o.status.LeftAmount, o.amount = 0, 0
+		o.status.State = FILLED
+	} else {
+		o.status.State = PARTIAL
+	}
+	m.fills++
+	if m.onFill != nil {
+		m.onFill(MatchFill{ExecID: strconv.Itoa(m.fills), Order: o.status, Price: price, Amount: amount, Maker: maker,
+			Time: m.now})
+	}
+}
+
+func (m *Matcher) update(o *matchOrder) {
+	if m.onUpdate != nil {
+		m.onUpdate(o.status)
+	}
+}
+
+// prune drops the orders of an instrument filled or cancelled, so that each event only goes through the orders
+// still working
+func (m *Matcher) prune(instrument string) {
+	orders := m.orders[instrument]
+	working := orders[:0]
+	for _, o := range orders {
+		if o.working() {
+			working = append(working, o)
+		}
+	}
+	for i := len(working); i < len(orders); i++ {
+		orders[i] = nil
+	}
+	if len(working) == 0 {
+		delete(m.orders, instrument)
+		return
+	}
+	m.orders[instrument] = working
+}
@@ -0,0 +1,87 @@
+package bean
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// LatencyModel draws the delay between the strategy sending an order request and the request reaching the book
+type LatencyModel interface {
+	Delay() time.Duration
+}
+
+// ConstantLatency delays every request by the same duration
+type ConstantLatency time.Duration
+
+func (l ConstantLatency) Delay() time.Duration {
+	return time.Duration(l)
+}
+
+type uniformLatency struct {
+	min, max time.Duration
+	rng      *rand.Rand
+}
+
+// NewUniformLatency draws delays uniformly between min and max, from a seeded generator so backtests repeat
+func NewUniformLatency(min, max time.Duration, seed int64) LatencyModel {
+	return &uniformLatency{min: min, max: max, rng: rand.New(rand.NewSource(seed))}
+}
+
+func (l *uniformLatency) Delay() time.Duration {
+	if l.max <= l.min {
+		return l.min
+	}
+	return l.min + time.Duration(l.rng.Int63n(int64(l.max-l.min)+1))
+}
+
+type logNormalLatency struct {
+	median time.Duration
+	sigma  float64
+	rng    *rand.Rand
+}
+
+// NewLogNormalLatency draws delays from a log-normal distribution of a median and log standard deviation, the
+// fat right tail of exchange round trips
+func NewLogNormalLatency(median time.Duration, sigma float64, seed int64) LatencyModel {
+	return &logNormalLatency{median: median, sigma: sigma, rng: rand.New(rand.NewSource(seed))}
+}
+
+func (l *logNormalLatency) Delay() time.Duration {
+	return time.Duration(float64(l.median) * math.Exp(l.sigma*l.rng.NormFloat64()))
+}
+
+// SlippageModel returns the price a taker fill of amount (positive buy) is executed at, given its average price
+// walking the book
+type SlippageModel interface {
+	Slip(ob OrderBookT, price, amount float64) float64
+}
+
+// FixedSlippage worsens taker fills by a number of basis points
+type FixedSlippage float64
+
+func (s FixedSlippage) Slip(ob OrderBookT, price, amount float64) float64 {
+	return price * (1 + math.Copysign(float64(s)/1e4, amount))
+}
+
+// SquareRootImpact worsens taker fills by k*sqrt(amount/depth) in relative price, depth being the amount shown on
+// the side of the book taken. Fills against an empty side are not moved
+type SquareRootImpact float64
+
+func (s SquareRootImpact) Slip(ob OrderBookT, price, amount float64) float64 {
+	if ob.OrderBookCore == nil {
+		return price
+	}
+	levels := ob.Asks()
+	if amount < 0 {
+		levels = ob.Bids()
+	}
+	depth := 0.0
+	for _, o := range levels {
+		depth += o.Amount
+	}
+	if depth <= 0 {
+		return price
+	}
+	return price * (1 + math.Copysign(float64(s)*math.Sqrt(math.Abs(amount)/depth), amount))
+}
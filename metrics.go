@@ -0,0 +1,186 @@
+package bean
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// Names of the metrics recorded by the library
+const (
+	MetricBookUpdates    = "bean_book_updates_total"    // inserts, cancels and edits of orderbook levels
+	MetricBookResyncs    = "bean_book_resyncs_total"    // streams resubscribed and resynchronised after a reconnect
+	MetricBookRepairs    = "bean_book_repairs_total"    // levels removed to uncross orderbooks
+	MetricSolverFailures = "bean_solver_failures_total" // implied vol solves that did not converge
+
+	MetricRateLimitRequests  = "bean_ratelimit_requests_total"  // requests checked against a RateLimiter
+	MetricRateLimitThrottled = "bean_ratelimit_throttled_total" // requests refused or delayed by a RateLimiter
+
+	MetricFanoutDropped     = "bean_fanout_dropped_total"     // messages not queued to fan-out clients too slow to keep up
+	MetricConflationDropped = "bean_conflation_dropped_total" // updates not queued to conflator subscribers too slow to keep up
+
+	MetricReconcileBreaks = "bean_reconcile_breaks_total" // differences found between exchange and local state
+)
+
+// Counter is a monotonically increasing count. A nil counter ignores updates
+type Counter struct {
+	v int64
+}
+
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+func (c *Counter) Add(n int64) {
+	if c != nil {
+		atomic.AddInt64(&c.v, n)
+	}
+}
+
+func (c *Counter) Value() int64 {
+	if c == nil {
+		return 0
+	}
+	return atomic.LoadInt64(&c.v)
+}
+
+// Gauge is a value that can go up and down. A nil gauge ignores updates
+type Gauge struct {
+	bits uint64
+}
+
+func (g *Gauge) Set(v float64) {
+	if g != nil {
+		atomic.StoreUint64(&g.bits, math.Float64bits(v))
+	}
+}
+
+func (g *Gauge) Value() float64 {
+	if g == nil {
+		return 0
+	}
+	return math.Float64frombits(atomic.LoadUint64(&g.bits))
+}
+
+// Metrics is a registry of named counters and gauges. It is safe for concurrent use
+type Metrics struct {
+	m        sync.Mutex
+	counters map[string]*Counter
+	gauges   map[string]*Gauge
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		counters: make(map[string]*Counter),
+		gauges:   make(map[string]*Gauge),
+	}
+}
+
+// Counter returns the counter of a name, creating it if needed. Returns nil on a nil registry
+func (r *Metrics) Counter(name string) *Counter {
+	if r == nil {
+		return nil
+	}
+	r.m.Lock()
+	defer r.m.Unlock()
+	c, ok := r.counters[name]
+	if !ok {
+		c = new(Counter)
+		r.counters[name] = c
+	}
+	return c
+}
+
+// Gauge returns the gauge of a name, creating it if needed. Returns nil on a nil registry
+func (r *Metrics) Gauge(name string) *Gauge {
+	if r == nil {
+		return nil
+	}
+	r.m.Lock()
+	defer r.m.Unlock()
+	g, ok := r.gauges[name]
+	if !ok {
+		g = new(Gauge)
+		r.gauges[name] = g
+	}
+	return g
+}
+
+// Snapshot returns the current value of all metrics by name, none on a nil registry
+func (r *Metrics) Snapshot() map[string]float64 {
+	if r == nil {
+		return map[string]float64{}
+	}
+	r.m.Lock()
+	defer r.m.Unlock()
+	res := make(map[string]float64, len(r.counters)+len(r.gauges))
+	for k, c := range r.counters {
+		res[k] = float64(c.Value())
+	}
+	for k, g := range r.gauges {
+		res[k] = g.Value()
+	}
+	return res
+}
+
+// WritePrometheus writes all metrics in the Prometheus text exposition format, nothing on a nil registry
+func (r *Metrics) WritePrometheus(w io.Writer) error {
+	if r == nil {
+		return nil
+	}
+	r.m.Lock()
+	var lines []string
+	for k, c := range r.counters {
+		lines = append(lines, fmt.Sprintf("# TYPE %s counter\n%s %d\n", k, k, c.Value()))
+	}
+	for k, g := range r.gauges {
+		lines = append(lines, fmt.Sprintf("# TYPE %s gauge\n%s %g\n", k, k, g.Value()))
+	}
+	r.m.Unlock()
+	sort.Strings(lines)
+	for _, l := range lines {
+		if _, err := io.WriteString(w, l); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// libraryCounters are the counters the library records, looked up once per registry as they are hit on every
+// book update
+var libraryCounters = []string{MetricBookUpdates, MetricBookResyncs, MetricBookRepairs, MetricSolverFailures, MetricRateLimitRequests,
+	MetricRateLimitThrottled, MetricFanoutDropped, MetricConflationDropped, MetricReconcileBreaks}
+
+// registry is the registry set by SetMetrics with the counters of the library, read without locking
+type registry struct {
+	metrics  *Metrics
+	counters map[string]*Counter
+}
+
+var metrics atomic.Value // registry
+
+// SetMetrics sets the registry the library records its metrics in, nil to stop recording
+func SetMetrics(r *Metrics) {
+	reg := registry{metrics: r, counters: make(map[string]*Counter, len(libraryCounters))}
+	for _, name := range libraryCounters {
+		reg.counters[name] = r.Counter(name)
+	}
+	metrics.Store(reg)
+}
+
+// DefaultMetrics returns the registry set by SetMetrics, nil if none
+func DefaultMetrics() *Metrics {
+	reg, _ := metrics.Load().(registry)
+	return reg.metrics
+}
+
+func counter(name string) *Counter {
+	reg, _ := metrics.Load().(registry)
+	if c, ok := reg.counters[name]; ok {
+		return c
+	}
+	return reg.metrics.Counter(name)
+}
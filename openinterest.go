@@ -0,0 +1,208 @@
+package bean
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// TickerRecorder keeps the tickers of contracts from exchange ticker feeds, for the series of their open interest
+// and volume and the option chains of their last quotes. It is safe for concurrent use
+type TickerRecorder struct {
+	Window time.Duration // history kept per contract, zero to keep all
+
+	m       sync.Mutex
+	tickers map[string]ContractTickerTS
+}
+
+// NewTickerRecorder returns a recorder keeping a window of history
+func NewTickerRecorder(window time.Duration) *TickerRecorder {
+	return &TickerRecorder{Window: window, tickers: make(map[string]ContractTickerTS)}
+}
+
+// OnTicker records a ticker. Tickers older than the last one of their contract are ignored
+func (r *TickerRecorder) OnTicker(t ContractTicker) {
+	if t.Contract == nil {
+		return
+	}
+	name := t.Contract.Name()
+	r.m.Lock()
+	defer r.m.Unlock()
+	ts := r.tickers[name]
+	if n := len(ts); n > 0 && t.Time.Before(ts[n-1].Time) {
+		return
+	}
+	ts = append(ts, t)
+	if r.Window > 0 {
+		from := t.Time.Add(-r.Window)
+		i := sort.Search(len(ts), func(i int) bool { return !ts[i].Time.Before(from) })
+		ts = append(ts[:0:0], ts[i:]...)
+	}
+	r.tickers[name] = ts
+}
+
+// Tickers returns a copy of the tickers recorded for a contract, in time order
+func (r *TickerRecorder) Tickers(name string) ContractTickerTS {
+	r.m.Lock()
+	defer r.m.Unlock()
+	return append(ContractTickerTS(nil), r.tickers[name]...)
+}
+
+// Last returns the last ticker of a contract
+func (r *TickerRecorder) Last(name string) (ContractTicker, bool) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	ts := r.tickers[name]
+	if len(ts) == 0 {
+		return ContractTicker{}, false
+	}
+	return ts[len(ts)-1], true
+}
+
+// OpenInterest returns the series of open interest of a contract
+func (r *TickerRecorder) OpenInterest(name string) TimeSeries {
+	return r.Tickers(name).OpenInterests()
+}
+
+// Volume returns the series of 24 hour volume of a contract
+func (r *TickerRecorder) Volume(name string) TimeSeries {
+	return r.Tickers(name).Volumes()
+}
+
+// Chain returns the chain of the last tickers of the options of an underlying not expired at asof
+func (r *TickerRecorder) Chain(underlying Pair, asof time.Time, spotPrice float64, curve *FuturesCurve) *OptionChain {
+	ch := NewOptionChain(underlying, asof, spotPrice, curve)
+	r.m.Lock()
+	defer r.m.Unlock()
+	for _, ts := range r.tickers {
+		t := ts[len(ts)-1]
+		if t.Contract.IsOption() && t.Contract.Underlying() == underlying && t.Contract.Expiry().After(asof) {
+			ch.Add(t)
+		}
+	}
+	return ch
+}
+
+// StrikeOI is the open interest of the calls and puts of a strike, in contracts
+type StrikeOI struct {
+	Strike float64
+	Calls  float64
+	Puts   float64
+}
+
+func (s StrikeOI) Total() float64 {
+	return s.Calls + s.Puts
+}
+
+// OIDistribution is the open interest of a chain by strike, in increasing order of strikes
+type OIDistribution []StrikeOI
+
+// OpenInterest returns the distribution of the open interest of an expiry of the chain, of all expiries for the
+// zero time. Quotes without open interest count as zero
+func (ch *OptionChain) OpenInterest(expiry time.Time) OIDistribution {
+	strikes := make(map[float64]*StrikeOI)
+	for _, q := range ch.Quotes {
+		c := q.Contract
+		if !expiry.IsZero() && !c.Expiry().Equal(expiry) {
+			continue
+		}
+		s, ok := strikes[c.Strike()]
+		if !ok {
+			s = &StrikeOI{Strike: c.Strike()}
+			strikes[c.Strike()] = s
+		}
+		if math.IsNaN(q.OpenInterest) {
+			continue
+		}
+		if c.CallPut() == Call {
+			s.Calls += q.OpenInterest
+		} else {
+			s.Puts += q.OpenInterest
+		}
+	}
+	res := make(OIDistribution, 0, len(strikes))
+	for _, s := range strikes {
+		res = append(res, *s)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Strike < res[j].Strike })
+	return res
+}
+
+// Calls returns the open interest of the calls
+func (d OIDistribution) Calls() float64 {
+	sum := 0.0
+	for _, s := range d {
+		sum += s.Calls
+	}
+	return sum
+}
+
+// Puts returns the open interest of the puts
+func (d OIDistribution) Puts() float64 {
+	sum := 0.0
+	for _, s := range d {
+		sum += s.Puts
+	}
+	return sum
+}
+
+// PutCallRatio returns the open interest of the puts over that of the calls, NaN without calls
+func (d OIDistribution) PutCallRatio() float64 {
+	calls := d.Calls()
+	if calls == 0 {
+		return math.NaN()
+	}
+	return d.Puts() / calls
+}
+
+// WeightedStrike returns the average strike of the calls, puts and all options weighted by open interest, NaN
+// where there is none
+func (d OIDistribution) WeightedStrike() (calls, puts, all float64) {
+	var wc, wp float64
+	for _, s := range d {
+		calls += s.Strike * s.Calls
+		puts += s.Strike * s.Puts
+		wc += s.Calls
+		wp += s.Puts
+	}
+	all = (calls + puts) / (wc + wp)
+	return calls / wc, puts / wp, all
+}
+
+// Pain returns the intrinsic value paid to the option holders by the writers if the options settle at a price,
+// in quote currency per contract of underlying
+func (d OIDistribution) Pain(settlePrice float64) float64 {
+	pain := 0.0
+	for _, s := range d {
+		pain += s.Calls*math.Max(settlePrice-s.Strike, 0) + s.Puts*math.Max(s.Strike-settlePrice, 0)
+	}
+	return pain
+}
+
+// MaxPain returns the strike at which the options would pay their holders the least at settlement, the lowest
+// one on a tie. NaN for a distribution without open interest
+func (d OIDistribution) MaxPain() float64 {
+	best, strike := math.Inf(1), math.NaN()
+	if d.Calls()+d.Puts() == 0 {
+		return strike
+	}
+	for _, s := range d {
+		if pain := d.Pain(s.Strike); pain < best {
+			best, strike = pain, s.Strike
+		}
+	}
+	return strike
+}
+
+// MaxPain returns the strike of an expiry at which its options would pay their holders the least at settlement,
+// failing with ErrInvalidInput if the expiry has no open interest
+func (ch *OptionChain) MaxPain(expiry time.Time) (float64, error) {
+	strike := ch.OpenInterest(expiry).MaxPain()
+	if math.IsNaN(strike) {
+		return strike, fmt.Errorf("%w: no %s open interest expiring %s", ErrInvalidInput, ch.Underlying,
+			expiry.Format(time.RFC3339))
+	}
+	return strike, nil
+}
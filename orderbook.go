@@ -98,37 +98,75 @@ func (ob *OrderBook) Mid() float64 {
 	return (ob.BestBid().Price + ob.BestAsk().Price) / 2.0
 }
 
+// MicroPrice is the mid weighted by the opposite sizes of the top of book, leaning towards the side more likely
+// to be taken out. NaN if a side is empty
+func (ob *OrderBook) MicroPrice() float64 {
+	bid, ask := ob.BestBid(), ob.BestAsk()
+	if math.IsNaN(bid.Price) || math.IsNaN(ask.Price) {
+		return math.NaN()
+	}
+	if bid.Amount+ask.Amount <= 0 {
+		return (bid.Price + ask.Price) / 2.0
+	}
+	return (bid.Price*ask.Amount + ask.Price*bid.Amount) / (bid.Amount + ask.Amount)
+}
+
 // Compare two orderbooks. Equal if the best bid and best offer hasn't changed
 func (ob1 *OrderBook) Equal(ob2 *OrderBook) bool {
 	return (!ob1.Valid() && !ob2.Valid()) ||
 		(ob1.BestBid() == ob2.BestBid() && ob1.BestAsk() == ob2.BestAsk())
 }
 
-// filter out orders with amount less than the Coin minimum trading amount
-// assuming ob is sorted
-func (ob *OrderBook) Denoise(pair Pair) *OrderBook {
-	var bids []Order
-	var asks []Order
-	minimumAmount := pair.MinimumTradingAmount()
-	carryAmount := 0.0
-	for _, b := range ob.Bids() {
-		if b.Amount+carryAmount < minimumAmount {
-			carryAmount += b.Amount
-		} else {
-			b.Amount += carryAmount
-			bids = append(bids, b)
-		}
-	}
+// DenoisePolicy is what Denoise does with the levels below its thresholds
+type DenoisePolicy int
 
-	for _, b := range ob.Asks() {
-		if b.Amount+carryAmount < minimumAmount {
-			carryAmount += b.Amount
-		} else {
-			b.Amount += carryAmount
-			asks = append(asks, b)
+const (
+	DenoiseCarry DenoisePolicy = iota // add their amount to the next level away from the touch that passes
+	DenoiseDrop                       // remove them
+	DenoiseVWAP                       // merge them into the next level that passes, at the average price
+)
+
+// DenoiseRule is the smallest level kept on one side of a book. A level is small if its amount, with any carried
+// into it, is below MinAmount or its notional in RHS coin below MinNotional. Zero thresholds keep every level
+type DenoiseRule struct {
+	MinAmount   float64
+	MinNotional float64
+	Policy      DenoisePolicy
+}
+
+// apply denoises a side sorted from the touch. Amounts left over at the end of the side are dropped
+func (r DenoiseRule) apply(orders []Order) []Order {
+	var res []Order
+	var amount, value float64 // carried
+	for _, o := range orders {
+		if r.Policy == DenoiseDrop {
+			amount, value = 0, 0
+		}
+		total, notional := amount+o.Amount, value+o.Price*o.Amount
+		if total < r.MinAmount || notional < r.MinNotional {
+			amount, value = total, notional
+			continue
 		}
+		o.Amount = total
+		if r.Policy == DenoiseVWAP {
+			o.Price = notional / total
+		}
+		res = append(res, o)
+		amount, value = 0, 0
 	}
-	ob2 := NewOrderBook(bids, asks)
+	return res
+}
+
+// Denoise filters out orders with amount less than the Coin minimum trading amount, carrying their amount to the
+// next level of the same side, assuming ob is sorted
+func (ob *OrderBook) Denoise(pair Pair) *OrderBook {
+	rule := DenoiseRule{MinAmount: pair.MinimumTradingAmount(), Policy: DenoiseCarry}
+	return ob.DenoiseWith(rule, rule)
+}
+
+// DenoiseWith filters out the small levels of each side with its own rule, assuming ob is sorted
+func (ob *OrderBook) DenoiseWith(bids, asks DenoiseRule) *OrderBook {
+	ob2 := NewOrderBook(bids.apply(ob.Bids()), asks.apply(ob.Asks()))
 	return &ob2
 }
 
@@ -150,13 +188,8 @@ func (ob OrderBook) Scale(scaler float64) OrderBook {
 
 // OrderBook display
 func (ob OrderBook) ShowBrief() string {
-	msg := ""
-	if ob.Valid() {
-		msg = fmt.Sprint("depth:", len(ob.Asks()), " bestBid:", ob.BestBid().Price, " bestAsk:", ob.BestAsk().Price)
-	} else {
-		msg = fmt.Sprint("empty orderbook")
-	}
-	fmt.Println(msg)
+	msg := ob.Brief()
+	Log().Infof("%s", msg)
 	return msg
 }
 
@@ -225,10 +258,9 @@ func (ob OrderBook) PrettyPrintD(maxLevel int, myob OrderBook) string {
 	return res
 }
 
-// ShowBrief prints a summary of the orderbook.
+// ShowBrief logs a summary of the orderbook.
 func (ob OrderBookT) ShowBrief() {
-	ob.OrderBook.ShowBrief()
-	fmt.Println("Timestamp: " + ob.Time.Local().Format(time.ANSIC))
+	Log().Infof("%s timestamp: %s", ob.Brief(), ob.Time.Local().Format(time.ANSIC))
 }
 
 // OrderBookTS display
@@ -257,6 +289,39 @@ func (obts OrderBookTS) GetOrderBook(t time.Time) *OrderBookT {
 	return ob
 }
 
+// AsOfIndex returns the index of the last book at or before each timestamp, -1 for timestamps before the first
+// book, assuming the obts is sorted. Sorted timestamps are aligned in a single forward pass, others are sorted first
+func (obts OrderBookTS) AsOfIndex(timestamps []time.Time) []int {
+	order := make([]int, len(timestamps))
+	for i := range order {
+		order[i] = i
+	}
+	if !sort.SliceIsSorted(timestamps, func(i, j int) bool { return timestamps[i].Before(timestamps[j]) }) {
+		sort.SliceStable(order, func(i, j int) bool { return timestamps[order[i]].Before(timestamps[order[j]]) })
+	}
+	res := make([]int, len(timestamps))
+	j := -1
+	for _, i := range order {
+		for j+1 < len(obts) && !obts[j+1].Time.After(timestamps[i]) {
+			j++
+		}
+		res[i] = j
+	}
+	return res
+}
+
+// AsOf returns the last book at or before each timestamp, e.g. the book each trade of a tape was executed against,
+// assuming the obts is sorted. Books before the first one have no OrderBookCore. The books share the levels of obts
+func (obts OrderBookTS) AsOf(timestamps []time.Time) OrderBookTS {
+	res := make(OrderBookTS, len(timestamps))
+	for i, j := range obts.AsOfIndex(timestamps) {
+		if j >= 0 {
+			res[i] = obts[j]
+		}
+	}
+	return res
+}
+
 // PriceIn returns the worst bid and worst ask that need to be hit in the orderbook in order to execute a requested size
 // Also returns the total size available at that price (may be more than requested size)
 // If orderstack does not have sufficient liquidity, then it returns the size available
@@ -299,6 +364,62 @@ func priceInAmount(requiredAmount float64, stack []Order) (price, available floa
 	return
 }
 
+// averageIn returns the average price of filling size against a stack, NaN if it holds less than size
+func averageIn(size float64, stack []Order) float64 {
+	left, value := size, 0.0
+	for _, ord := range stack {
+		take := math.Min(left, ord.Amount)
+		value += take * ord.Price
+		left -= take
+		if left <= size*1e-12 {
+			return value / size
+		}
+	}
+	return math.NaN()
+}
+
+// WeightedMid is the mid of the average prices of selling and buying size in the book, the price a size
+// sensitive strategy can trade around. It is the mid for sizes within the top of book and NaN if a side cannot
+// fill size, see BidIn and AskIn for the liquidity available
+func (ob OrderBook) WeightedMid(size float64) float64 {
+	if ob.OrderBookCore == nil || !(size > 0) {
+		return math.NaN()
+	}
+	return (averageIn(size, ob.Bids()) + averageIn(size, ob.Asks())) / 2.0
+}
+
+// EffectiveSpread is the cost of a round trip of size in the book, the average price of buying it less that of
+// selling it. Divide by WeightedMid for the spread as a fraction. NaN if a side cannot fill size
+func (ob OrderBook) EffectiveSpread(size float64) float64 {
+	if ob.OrderBookCore == nil || !(size > 0) {
+		return math.NaN()
+	}
+	return averageIn(size, ob.Asks()) - averageIn(size, ob.Bids())
+}
+
+// Bucketize aggregates the levels of the book into price bands of a width, e.g. $10 buckets for heatmaps or to
+// compare the liquidity of venues quoting on different ticks. Each band is keyed by its edge on the far side from
+// the mid, bids rounded down and asks up, so the book stays uncrossed. The book is copied as is for a width that is
+// not positive
+func (ob OrderBook) Bucketize(bandWidth float64) OrderBook {
+	if ob.OrderBookCore == nil {
+		return EmptyOrderBook()
+	}
+	band := func(levels []Order, round func(price, tick float64) float64) []Order {
+		var res []Order
+		for _, o := range levels {
+			price := round(o.Price, bandWidth)
+			if n := len(res); n > 0 && res[n-1].Price == price {
+				res[n-1].Amount += o.Amount
+				continue
+			}
+			res = append(res, Order{Price: price, Amount: o.Amount})
+		}
+		return res
+	}
+	return NewOrderBook(band(ob.Bids(), RoundDownToTick), band(ob.Asks(), RoundUpToTick))
+}
+
 // SBRatio ... sell / buy ratio, alpha in (0, 1]
 func (ob OrderBook) SBRatio(alpha float64) float64 {
 	var sell float64
@@ -403,16 +524,19 @@ func (ob OrderBook) CumPctOB() CumPctOB {
 
 // Match ... Takes a placed order and matches against the existing orderbook.
 // If it can be filled then the filled amount and rate are returned
-// Orders (aggressor) are filled at the orderbook (market maker) rate
+// Orders (aggressor) are filled at the orderbook (market maker) rate. The walk stops at the limit price or once filled.
+// Prices are compared in ticks on books keyed by a PriceScale
 func (ob OrderBook) Match(placedOrder Order) Order {
+	scale := ob.PriceScale()
 	fillCounterAmount := 0.0
 	fillAmount := 0.0
 	if placedOrder.Amount > 0.0 {
 		for _, o := range ob.Asks() {
-			if o.Price <= placedOrder.Price {
-				fillCounterAmount += math.Min(placedOrder.Amount-fillAmount, o.Amount) * o.Price
-				fillAmount += math.Min(placedOrder.Amount-fillAmount, o.Amount)
+			if scale.Compare(o.Price, placedOrder.Price) > 0 || fillAmount >= placedOrder.Amount {
+				break
 			}
+			fillCounterAmount += math.Min(placedOrder.Amount-fillAmount, o.Amount) * o.Price
+			fillAmount += math.Min(placedOrder.Amount-fillAmount, o.Amount)
 		}
 		if fillAmount > 0.0 {
 			return Order{Price: fillCounterAmount / fillAmount, Amount: fillAmount}
@@ -421,10 +545,11 @@ func (ob OrderBook) Match(placedOrder Order) Order {
 		}
 	} else {
 		for _, o := range ob.Bids() {
-			if o.Price >= placedOrder.Price {
-				fillCounterAmount += math.Min(-placedOrder.Amount-fillAmount, o.Amount) * o.Price
-				fillAmount += math.Min(-placedOrder.Amount-fillAmount, o.Amount)
+			if scale.Compare(o.Price, placedOrder.Price) < 0 || fillAmount >= -placedOrder.Amount {
+				break
 			}
+			fillCounterAmount += math.Min(-placedOrder.Amount-fillAmount, o.Amount) * o.Price
+			fillAmount += math.Min(-placedOrder.Amount-fillAmount, o.Amount)
 		}
 		if fillAmount > 0.0 {
 			return Order{Price: fillCounterAmount / fillAmount, Amount: -fillAmount}
@@ -434,6 +559,14 @@ func (ob OrderBook) Match(placedOrder Order) Order {
 	}
 }
 
+// PriceScale returns the scale the core keys its levels by, the zero scale of exact prices if it has none
+func (ob OrderBook) PriceScale() PriceScale {
+	if s, ok := ob.OrderBookCore.(interface{ PriceScale() PriceScale }); ok {
+		return s.PriceScale()
+	}
+	return PriceScale{}
+}
+
 func AmountToSide(amt float64) Side {
 	if amt < 0.0 {
 		return SELL
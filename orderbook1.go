@@ -8,9 +8,10 @@ import (
 
 // OrderBook1 is an implementation of the OrderBookCore interface. Bids and asks are stored as lists of orders
 type OrderBook1 struct {
-	bids []Order
-	asks []Order
-	m    sync.Mutex
+	bids  []Order
+	asks  []Order
+	scale PriceScale // levels are keyed by tick when it has one
+	m     sync.Mutex
 }
 
 // Bids retrieves a list of bid orders from the orderbook
@@ -34,80 +35,151 @@ func NewOrderBook(bids, asks []Order) OrderBook {
 	return OrderBook{&ob}
 }
 
+// NewOrderBookTick returns an order book keyed by the ticks of a tick size, so that updates find their level
+// whatever float error their prices carry. Prices are rounded to the tick and levels at the same tick merged.
+// Without a positive tick it is NewOrderBook
+func NewOrderBookTick(bids, asks []Order, tick float64) OrderBook {
+	scale := NewPriceScale(tick)
+	if scale.tick == 0 {
+		return NewOrderBook(bids, asks)
+	}
+	ob := OrderBook1{bids: scale.merge(bids), asks: scale.merge(asks), scale: scale}.Sort()
+	return OrderBook{&ob}
+}
+
+// PriceScale returns the scale levels are keyed by, the zero scale for exact prices
+func (ob *OrderBook1) PriceScale() PriceScale {
+	return ob.scale
+}
+
+// merge rounds the prices of orders to the tick and adds up the amounts of those at the same tick
+func (s PriceScale) merge(orders []Order) []Order {
+	res := make([]Order, 0, len(orders))
+	index := make(map[Ticks]int, len(orders))
+	for _, o := range orders {
+		t := s.Ticks(o.Price)
+		if i, ok := index[t]; ok {
+			res[i].Amount += o.Amount
+			continue
+		}
+		index[t] = len(res)
+		res = append(res, Order{Price: s.Round(o.Price), Amount: o.Amount})
+	}
+	return res
+}
+
+// findLevel returns the index of a price in a sorted side (descending for bids) and whether the level exists.
+// The levels of a scale with a tick are rounded to it, so their ticks are exact keys
+func findLevel(levels []Order, price float64, desc bool, s PriceScale) (int, bool) {
+	if s.tick == 0 {
+		i := sort.Search(len(levels), func(i int) bool {
+			if desc {
+				return levels[i].Price <= price
+			}
+			return levels[i].Price >= price
+		})
+		return i, i < len(levels) && levels[i].Price == price
+	}
+	return searchTicks(len(levels), func(i int) Ticks { return s.Ticks(levels[i].Price) }, s.Ticks(price), desc)
+}
+
+// searchTicks returns the index of a tick in n levels sorted by tick (descending for bids) and whether it is there
+func searchTicks(n int, ticks func(i int) Ticks, t Ticks, desc bool) (int, bool) {
+	i := sort.Search(n, func(i int) bool {
+		if desc {
+			return ticks(i) <= t
+		}
+		return ticks(i) >= t
+	})
+	return i, i < n && ticks(i) == t
+}
+
+// insertLevel places an order at its sorted position, or sets the amount of an existing level in place
+func insertLevel(levels *[]Order, order Order, desc bool, s PriceScale) (tob bool) {
+	order.Price = s.Round(order.Price)
+	i, ok := findLevel(*levels, order.Price, desc, s)
+	if ok {
+		(*levels)[i].Amount = order.Amount
+		return i == 0
+	}
+	*levels = append(*levels, Order{})
+	copy((*levels)[i+1:], (*levels)[i:])
+	(*levels)[i] = order
+	return i == 0
+}
+
+func cancelLevel(levels *[]Order, order Order, desc bool, s PriceScale) (tob bool) {
+	i, ok := findLevel(*levels, order.Price, desc, s)
+	if !ok {
+		return false
+	}
+	*levels = append((*levels)[:i], (*levels)[i+1:]...)
+	return i == 0
+}
+
+func editLevel(levels []Order, order Order, desc bool, s PriceScale) (tob bool) {
+	if i, ok := findLevel(levels, order.Price, desc, s); ok {
+		levels[i].Amount = order.Amount
+	}
+	return
+}
+
 // InsertBid adds a new order into the orderbook. Returns true if the top of book price has changed
 func (ob *OrderBook1) InsertBid(order Order) (tob bool) {
 	ob.m.Lock()
+	counter(MetricBookUpdates).Inc()
 	defer ob.m.Unlock()
-	ob.bids = append(ob.bids, order)
-	ob.Sort()
-	return order.Price == ob.bids[0].Price
+	defer ob.debugCheck()
+	return insertLevel(&ob.bids, order, true, ob.scale)
 }
 
 // InsertAsk adds a new order into the orderbook. Returns true if the top of book price has changed
 func (ob *OrderBook1) InsertAsk(order Order) (tob bool) {
 	ob.m.Lock()
+	counter(MetricBookUpdates).Inc()
 	defer ob.m.Unlock()
-	ob.asks = append(ob.asks, order)
-	ob.Sort()
-	return order.Price == ob.asks[0].Price
+	defer ob.debugCheck()
+	return insertLevel(&ob.asks, order, false, ob.scale)
 }
 
 // CancelBid deletes an order from the orderbook. Returns true if the top of book price has changed
 func (ob *OrderBook1) CancelBid(order Order) (tob bool) {
 	ob.m.Lock()
+	counter(MetricBookUpdates).Inc()
 	defer ob.m.Unlock()
-	for i := range ob.bids {
-		if ob.bids[i].Price == order.Price {
-			ob.bids = append(ob.bids[:i], ob.bids[i+1:]...)
-			if i == 0 {
-				tob = true
-			}
-			break
-		}
-	}
-	return
+	defer ob.debugCheck()
+	return cancelLevel(&ob.bids, order, true, ob.scale)
 }
 
 // CancelAsk deletes an order from the orderbook. Returns true if the top of book price has changed
 func (ob *OrderBook1) CancelAsk(order Order) (tob bool) {
 	ob.m.Lock()
+	counter(MetricBookUpdates).Inc()
 	defer ob.m.Unlock()
-	for i := range ob.asks {
-		if ob.asks[i].Price == order.Price {
-			ob.asks = append(ob.asks[:i], ob.asks[i+1:]...)
-			if i == 0 {
-				tob = true
-			}
-			break
-		}
-	}
-	return
+	defer ob.debugCheck()
+	return cancelLevel(&ob.asks, order, false, ob.scale)
 }
 
 // EditBid replaces an order at a particular level with another. Returns true if the top of book has changed
 func (ob *OrderBook1) EditBid(order Order) (tob bool) {
 	ob.m.Lock()
+	counter(MetricBookUpdates).Inc()
 	defer ob.m.Unlock()
-	for i := range ob.bids {
-		if ob.bids[i].Price == order.Price {
-			ob.bids[i].Amount = order.Amount
-			break
-		}
-	}
-	return
+	defer ob.debugCheck()
+	return editLevel(ob.bids, order, true, ob.scale)
 }
 
 // EditAsk replaces an order at a particular level with another. Returns true if the top of book has changed
 func (ob *OrderBook1) EditAsk(order Order) (tob bool) {
 	ob.m.Lock()
+	counter(MetricBookUpdates).Inc()
 	defer ob.m.Unlock()
-	for i := range ob.asks {
-		if ob.asks[i].Price == order.Price {
-			ob.asks[i].Amount = order.Amount
-			break
-		}
-	}
-	return
+	defer ob.debugCheck()
+	return editLevel(ob.asks, order, false, ob.scale)
+}
+
+func (ob *OrderBook1) debugCheck() {
+	debugCheck(ob.bids, ob.asks)
 }
 
 func (ob *OrderBook1) BestBid() Order {
@@ -0,0 +1,318 @@
+package bean
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// L3Order is an individual resting order of an order-by-order (level 3) book
+type L3Order struct {
+	ID     string
+	Side   Side
+	Price  float64
+	Amount float64
+}
+
+type l3Level struct {
+	price  float64
+	ticks  Ticks     // key of the level on a scale with a tick
+	orders []L3Order // in time priority
+}
+
+func (l *l3Level) amount() (amt float64) {
+	for _, o := range l.orders {
+		amt += o.Amount
+	}
+	return
+}
+
+// OrderBook3 is an implementation of the OrderBookCore interface tracking individual orders by ID at each price,
+// so cancels remove the right order and the queue ahead of an order is known. The OrderBookCore functions work on
+// the aggregated (level 2) view: inserts add anonymous orders, cancels remove whole levels and edits resize a
+// level from the back of its queue
+type OrderBook3 struct {
+	m     sync.Mutex
+	bids  []*l3Level // best (highest) first
+	asks  []*l3Level // best (lowest) first
+	index map[string]L3Order
+	anon  int
+	scale PriceScale // levels are keyed by tick when it has one
+}
+
+func NewOrderBook3() *OrderBook3 {
+	return NewOrderBook3Tick(0)
+}
+
+// NewOrderBook3Tick returns an empty book keyed by the ticks of a tick size, order prices being rounded to it
+func NewOrderBook3Tick(tick float64) *OrderBook3 {
+	return &OrderBook3{index: make(map[string]L3Order), scale: NewPriceScale(tick)}
+}
+
+// PriceScale returns the scale levels are keyed by, the zero scale for exact prices
+func (ob *OrderBook3) PriceScale() PriceScale {
+	return ob.scale
+}
+
+func (ob *OrderBook3) side(s Side) *[]*l3Level {
+	if s == BUY {
+		return &ob.bids
+	}
+	return &ob.asks
+}
+
+// find returns the position of the level of a price in a side, and whether it exists
+func (ob *OrderBook3) find(s Side, price float64) (int, bool) {
+	levels := *ob.side(s)
+	if ob.scale.Tick() != 0 {
+		return searchTicks(len(levels), func(i int) Ticks { return levels[i].ticks }, ob.scale.Ticks(price), s == BUY)
+	}
+	i := sort.Search(len(levels), func(i int) bool {
+		if s == BUY {
+			return levels[i].price <= price
+		}
+		return levels[i].price >= price
+	})
+	return i, i < len(levels) && levels[i].price == price
+}
+
+func (ob *OrderBook3) add(o L3Order) (tob bool) {
+	o.Price = ob.scale.Round(o.Price)
+	levels := ob.side(o.Side)
+	i, ok := ob.find(o.Side, o.Price)
+	if !ok {
+		*levels = append(*levels, nil)
+		copy((*levels)[i+1:], (*levels)[i:])
+		(*levels)[i] = &l3Level{price: o.Price, ticks: ob.scale.Ticks(o.Price)}
+	}
+	(*levels)[i].orders = append((*levels)[i].orders, o)
+	ob.index[o.ID] = o
+	return i == 0
+}
+
+func (ob *OrderBook3) remove(id string) (tob bool) {
+	o, ok := ob.index[id]
+	if !ok {
+		return false
+	}
+	delete(ob.index, id)
+	levels := ob.side(o.Side)
+	i, ok := ob.find(o.Side, o.Price)
+	if !ok {
+		return false
+	}
+	l := (*levels)[i]
+	for j := range l.orders {
+		if l.orders[j].ID == id {
+			l.orders = append(l.orders[:j], l.orders[j+1:]...)
+			break
+		}
+	}
+	if len(l.orders) == 0 {
+		*levels = append((*levels)[:i], (*levels)[i+1:]...)
+	}
+	return i == 0
+}
+
+// AddOrder adds an order at the back of the queue of its price. Returns true if the top of book has changed
+func (ob *OrderBook3) AddOrder(o L3Order) (tob bool) {
+	ob.m.Lock()
+	defer ob.m.Unlock()
+	counter(MetricBookUpdates).Inc()
+	if _, exists := ob.index[o.ID]; exists {
+		ob.remove(o.ID)
+	}
+	return ob.add(o)
+}
+
+// RemoveOrder removes an order by ID, on a cancel or a full fill. Returns true if the top of book has changed
+func (ob *OrderBook3) RemoveOrder(id string) (tob bool) {
+	ob.m.Lock()
+	defer ob.m.Unlock()
+	counter(MetricBookUpdates).Inc()
+	return ob.remove(id)
+}
+
+// ModifyOrder changes the amount of an order. Reductions (and partial fills) keep the order's place in the queue,
+// increases send it to the back as on most exchanges. Returns false if the order is unknown
+func (ob *OrderBook3) ModifyOrder(id string, amount float64) bool {
+	ob.m.Lock()
+	defer ob.m.Unlock()
+	counter(MetricBookUpdates).Inc()
+	o, ok := ob.index[id]
+	if !ok {
+		return false
+	}
+	if amount <= 0 {
+		ob.remove(id)
+		return true
+	}
+	if amount > o.Amount {
+		ob.remove(id)
+		o.Amount = amount
+		ob.add(o)
+		return true
+	}
+	i, _ := ob.find(o.Side, o.Price)
+	l := (*ob.side(o.Side))[i]
+	for j := range l.orders {
+		if l.orders[j].ID == id {
+			l.orders[j].Amount = amount
+		}
+	}
+	o.Amount = amount
+	ob.index[id] = o
+	return true
+}
+
+// Order returns a resting order by ID
+func (ob *OrderBook3) Order(id string) (L3Order, bool) {
+	ob.m.Lock()
+	defer ob.m.Unlock()
+	o, ok := ob.index[id]
+	return o, ok
+}
+
+// QueuePosition returns the amount resting ahead of an order at its price, false if the order is unknown
+func (ob *OrderBook3) QueuePosition(id string) (ahead float64, ok bool) {
+	ob.m.Lock()
+	defer ob.m.Unlock()
+	o, ok := ob.index[id]
+	if !ok {
+		return 0, false
+	}
+	i, _ := ob.find(o.Side, o.Price)
+	for _, q := range (*ob.side(o.Side))[i].orders {
+		if q.ID == id {
+			break
+		}
+		ahead += q.Amount
+	}
+	return ahead, true
+}
+
+// LevelOrders returns the orders resting at a price in time priority
+func (ob *OrderBook3) LevelOrders(s Side, price float64) []L3Order {
+	ob.m.Lock()
+	defer ob.m.Unlock()
+	i, ok := ob.find(s, price)
+	if !ok {
+		return nil
+	}
+	return append([]L3Order(nil), (*ob.side(s))[i].orders...)
+}
+
+func aggregate(levels []*l3Level) []Order {
+	res := make([]Order, len(levels))
+	for i, l := range levels {
+		res[i] = Order{Price: l.price, Amount: l.amount()}
+	}
+	return res
+}
+
+// Bids returns the aggregated bid levels
+func (ob *OrderBook3) Bids() []Order {
+	ob.m.Lock()
+	defer ob.m.Unlock()
+	return aggregate(ob.bids)
+}
+
+// Asks returns the aggregated ask levels
+func (ob *OrderBook3) Asks() []Order {
+	ob.m.Lock()
+	defer ob.m.Unlock()
+	return aggregate(ob.asks)
+}
+
+func (ob *OrderBook3) insert(s Side, order Order) (tob bool) {
+	ob.m.Lock()
+	defer ob.m.Unlock()
+	counter(MetricBookUpdates).Inc()
+	return ob.addAnonymous(s, order)
+}
+
+func (ob *OrderBook3) addAnonymous(s Side, order Order) (tob bool) {
+	ob.anon++
+	return ob.add(L3Order{ID: "L2-" + strconv.Itoa(ob.anon), Side: s, Price: order.Price, Amount: order.Amount})
+}
+
+func (ob *OrderBook3) cancel(s Side, order Order) (tob bool) {
+	ob.m.Lock()
+	defer ob.m.Unlock()
+	counter(MetricBookUpdates).Inc()
+	i, ok := ob.find(s, order.Price)
+	if !ok {
+		return false
+	}
+	levels := ob.side(s)
+	for _, o := range (*levels)[i].orders {
+		delete(ob.index, o.ID)
+	}
+	*levels = append((*levels)[:i], (*levels)[i+1:]...)
+	return i == 0
+}
+
+// edit sets the aggregated amount of a level, trimming the newest orders or adding an anonymous one at the back
+func (ob *OrderBook3) edit(s Side, order Order) (tob bool) {
+	ob.m.Lock()
+	defer ob.m.Unlock()
+	counter(MetricBookUpdates).Inc()
+	i, ok := ob.find(s, order.Price)
+	if !ok {
+		return false
+	}
+	l := (*ob.side(s))[i]
+	diff := order.Amount - l.amount()
+	if diff > 0 {
+		ob.addAnonymous(s, Order{Price: order.Price, Amount: diff})
+		return false
+	}
+	kept := l.orders[:0]
+	for j := len(l.orders) - 1; j >= 0; j-- {
+		cut := math.Min(l.orders[j].Amount, -diff)
+		l.orders[j].Amount -= cut
+		diff += cut
+	}
+	for _, o := range l.orders {
+		if o.Amount > 0 {
+			kept = append(kept, o)
+			ob.index[o.ID] = o
+		} else {
+			delete(ob.index, o.ID)
+		}
+	}
+	l.orders = kept
+	if len(l.orders) == 0 {
+		levels := ob.side(s)
+		*levels = append((*levels)[:i], (*levels)[i+1:]...)
+		return i == 0
+	}
+	return false
+}
+
+func (ob *OrderBook3) InsertBid(order Order) bool { return ob.insert(BUY, order) }
+func (ob *OrderBook3) InsertAsk(order Order) bool { return ob.insert(SELL, order) }
+func (ob *OrderBook3) CancelBid(order Order) bool { return ob.cancel(BUY, order) }
+func (ob *OrderBook3) CancelAsk(order Order) bool { return ob.cancel(SELL, order) }
+func (ob *OrderBook3) EditBid(order Order) bool   { return ob.edit(BUY, order) }
+func (ob *OrderBook3) EditAsk(order Order) bool   { return ob.edit(SELL, order) }
+
+func (ob *OrderBook3) best(levels []*l3Level) Order {
+	if len(levels) == 0 {
+		return Order{Price: math.NaN(), Amount: 0.0}
+	}
+	return Order{Price: levels[0].price, Amount: levels[0].amount()}
+}
+
+func (ob *OrderBook3) BestBid() Order {
+	ob.m.Lock()
+	defer ob.m.Unlock()
+	return ob.best(ob.bids)
+}
+
+func (ob *OrderBook3) BestAsk() Order {
+	ob.m.Lock()
+	defer ob.m.Unlock()
+	return ob.best(ob.asks)
+}
@@ -0,0 +1,135 @@
+package bean
+
+import (
+	"math"
+	"sync"
+	"sync/atomic"
+)
+
+// bookSnapshot is an immutable state of an OrderBookCOW. Its slices are never written once published
+type bookSnapshot struct {
+	bids  []Order
+	asks  []Order
+	scale PriceScale
+}
+
+var emptySnapshot = &bookSnapshot{}
+
+func (s *bookSnapshot) Bids() []Order { return s.bids }
+func (s *bookSnapshot) Asks() []Order { return s.asks }
+
+// PriceScale returns the scale of the book the snapshot was taken from
+func (s *bookSnapshot) PriceScale() PriceScale { return s.scale }
+
+func (s *bookSnapshot) BestBid() Order {
+	if len(s.bids) > 0 {
+		return s.bids[0]
+	}
+	return Order{Price: math.NaN(), Amount: 0.0}
+}
+
+func (s *bookSnapshot) BestAsk() Order {
+	if len(s.asks) > 0 {
+		return s.asks[0]
+	}
+	return Order{Price: math.NaN(), Amount: 0.0}
+}
+
+func (s *bookSnapshot) readOnly() bool {
+	panic("orderbook snapshots are read only, update the OrderBookCOW they were taken from")
+}
+
+func (s *bookSnapshot) InsertBid(Order) bool { return s.readOnly() }
+func (s *bookSnapshot) InsertAsk(Order) bool { return s.readOnly() }
+func (s *bookSnapshot) CancelBid(Order) bool { return s.readOnly() }
+func (s *bookSnapshot) CancelAsk(Order) bool { return s.readOnly() }
+func (s *bookSnapshot) EditBid(Order) bool   { return s.readOnly() }
+func (s *bookSnapshot) EditAsk(Order) bool   { return s.readOnly() }
+
+// OrderBookCOW is a copy-on-write implementation of the OrderBookCore interface for books read concurrently,
+// e.g. by several strategies while a feed updates them. Writers copy the side they change and publish the new
+// state with an atomic pointer swap (RCU style), so readers never lock and a snapshot never changes under them
+type OrderBookCOW struct {
+	m     sync.Mutex // serializes writers
+	snap  atomic.Pointer[bookSnapshot]
+	scale PriceScale // levels are keyed by tick when it has one
+}
+
+// NewOrderBookCOW returns a copy-on-write book populated by bids and asks
+func NewOrderBookCOW(bids, asks []Order) *OrderBookCOW {
+	return NewOrderBookCOWTick(bids, asks, 0)
+}
+
+// NewOrderBookCOWTick returns a copy-on-write book keyed by the ticks of a tick size, as NewOrderBookTick
+func NewOrderBookCOWTick(bids, asks []Order, tick float64) *OrderBookCOW {
+	sorted := NewOrderBookTick(append([]Order(nil), bids...), append([]Order(nil), asks...), tick)
+	ob := &OrderBookCOW{scale: sorted.PriceScale()}
+	ob.snap.Store(&bookSnapshot{bids: sorted.Bids(), asks: sorted.Asks(), scale: ob.scale})
+	return ob
+}
+
+// PriceScale returns the scale levels are keyed by, the zero scale for exact prices
+func (ob *OrderBookCOW) PriceScale() PriceScale {
+	return ob.scale
+}
+
+func (ob *OrderBookCOW) load() *bookSnapshot {
+	if s := ob.snap.Load(); s != nil {
+		return s
+	}
+	return emptySnapshot
+}
+
+// Snapshot returns the current state of the book. It is safe to read from any goroutine and is not affected by
+// later updates; writing to it panics
+func (ob *OrderBookCOW) Snapshot() OrderBook {
+	return OrderBook{ob.load()}
+}
+
+// update applies a change to a copy of one side of the book and publishes the result
+func (ob *OrderBookCOW) update(s Side, apply func(levels *[]Order) bool) (tob bool) {
+	ob.m.Lock()
+	defer ob.m.Unlock()
+	counter(MetricBookUpdates).Inc()
+	old := ob.load()
+	next := *old
+	next.scale = ob.scale
+	levels := &next.asks
+	if s == BUY {
+		levels = &next.bids
+	}
+	*levels = append(make([]Order, 0, len(*levels)+1), *levels...)
+	tob = apply(levels)
+	debugCheck(next.bids, next.asks)
+	ob.snap.Store(&next)
+	return
+}
+
+func (ob *OrderBookCOW) Bids() []Order  { return ob.load().bids }
+func (ob *OrderBookCOW) Asks() []Order  { return ob.load().asks }
+func (ob *OrderBookCOW) BestBid() Order { return ob.load().BestBid() }
+func (ob *OrderBookCOW) BestAsk() Order { return ob.load().BestAsk() }
+
+func (ob *OrderBookCOW) InsertBid(order Order) bool {
+	return ob.update(BUY, func(l *[]Order) bool { return insertLevel(l, order, true, ob.scale) })
+}
+
+func (ob *OrderBookCOW) InsertAsk(order Order) bool {
+	return ob.update(SELL, func(l *[]Order) bool { return insertLevel(l, order, false, ob.scale) })
+}
+
+func (ob *OrderBookCOW) CancelBid(order Order) bool {
+	return ob.update(BUY, func(l *[]Order) bool { return cancelLevel(l, order, true, ob.scale) })
+}
+
+func (ob *OrderBookCOW) CancelAsk(order Order) bool {
+	return ob.update(SELL, func(l *[]Order) bool { return cancelLevel(l, order, false, ob.scale) })
+}
+
+func (ob *OrderBookCOW) EditBid(order Order) bool {
+	return ob.update(BUY, func(l *[]Order) bool { return editLevel(*l, order, true, ob.scale) })
+}
+
+func (ob *OrderBookCOW) EditAsk(order Order) bool {
+	return ob.update(SELL, func(l *[]Order) bool { return editLevel(*l, order, false, ob.scale) })
+}
@@ -0,0 +1,147 @@
+package bean
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// PinStrike is the exposure of the expiring options to the settlement price landing near one strike
+type PinStrike struct {
+	Strike       float64
+	Calls        float64 // contracts held, negative if short
+	Puts         float64
+	OpenInterest float64 // calls and puts of the chain, zero if not quoted
+	Distance     float64 // strike over forward minus one
+	Delta        float64 // of the expiring options with the forward at the strike, in LHS coin
+	Gamma        float64 // of the expiring options with the forward at the strike
+	DeltaJump    float64 // change of the delta at expiry as settlement crosses the strike upwards, in LHS coin
+	Probability  float64 // of settling within the pin width of the strike
+	PinExposure  float64 // expected size of the delta jump, |DeltaJump| times Probability
+}
+
+// PinRiskReport is the settlement risk of the options of an underlying expiring next
+type PinRiskReport struct {
+	Underlying Pair
+	Asof       time.Time
+	Expiry     time.Time
+	Days       float64
+	Spot       float64
+	Forward    float64
+	Delta      float64 // of the expiring options
+	Gamma      float64
+	Strikes    []PinStrike // in increasing order
+	// sum of the strike pin exposures, the delta likely to be left unhedged by the settlement
+	PinExposure float64
+}
+
+// NewPinRiskReport reports the pin risk of the positions in options of the chain underlying that expire next. The
+// strikes listed in the chain or held within strikeRange of the forward (a fraction, zero for all) are reported,
+// pinWidth being the fraction of a strike around it counted as pinned. Prices, forwards and vols come from the market
+func NewPinRiskReport(m *Market, positions []Position, ch *OptionChain, strikeRange, pinWidth float64) (PinRiskReport, error) {
+	asof := m.Asof()
+	r := PinRiskReport{Underlying: ch.Underlying, Asof: asof}
+	var expiring []Position
+	for _, p := range positions {
+		if p.IsOption() && p.Underlying() == ch.Underlying && p.Expiry().After(asof) &&
+			(r.Expiry.IsZero() || !p.Expiry().After(r.Expiry)) {
+			if !p.Expiry().Equal(r.Expiry) {
+				r.Expiry, expiring = p.Expiry(), nil
+			}
+			expiring = append(expiring, p)
+		}
+	}
+	if len(expiring) == 0 {
+		return r, fmt.Errorf("%w: no %s option position expiring after %s", ErrInvalidInput, ch.Underlying, asof.Format(time.RFC3339))
+	}
+	ref := expiring[0].Contract
+	r.Days = ref.ExpiryDays(asof)
+	r.Spot = m.Spot(ch.Underlying)
+	r.Forward = m.Forward(ref)
+	if !validPrice(r.Spot) || !validPrice(r.Forward) {
+		return r, fmt.Errorf("%w: no %s price", ErrInvalidInput, ch.Underlying)
+	}
+
+	strikes := make(map[float64]*PinStrike)
+	strike := func(k float64) *PinStrike {
+		s, ok := strikes[k]
+		if !ok {
+			s = &PinStrike{Strike: k, Distance: k/r.Forward - 1}
+			strikes[k] = s
+		}
+		return s
+	}
+	for _, k := range ch.Strikes(r.Expiry) {
+		strike(k)
+	}
+	for _, q := range ch.Expiry(r.Expiry) {
+		if !math.IsNaN(q.OpenInterest) {
+			strike(q.Contract.Strike()).OpenInterest += q.OpenInterest
+		}
+	}
+	for _, p := range expiring {
+		s := strike(p.Strike())
+		if p.CallPut() == Call {
+			s.Calls += p.Qty()
+		} else {
+			s.Puts += p.Qty()
+		}
+		vol := m.Vol(p.Contract)
+		r.Delta += p.Delta(asof, r.Spot, r.Forward, vol)
+		r.Gamma += p.Gamma(asof, r.Spot, r.Forward, vol)
+	}
+
+	years := r.Days / 365
+	for k, s := range strikes {
+		if strikeRange > 0 && math.Abs(s.Distance) > strikeRange {
+			continue
+		}
+		spot := r.Spot * k / r.Forward
+		for _, p := range expiring {
+			vol := m.Vol(p.Contract)
+			s.Delta += p.Delta(asof, spot, k, vol)
+			s.Gamma += p.Gamma(asof, spot, k, vol)
+		}
+		// long calls gain a unit of delta above the strike, long puts lose one below it
+		s.DeltaJump = s.Calls + s.Puts
+		vol := m.Vol(OptContract(ch.Underlying, r.Expiry, k, Call))
+		s.Probability = settleProbability(r.Forward, k*(1-pinWidth), k*(1+pinWidth), vol, years)
+		s.PinExposure = math.Abs(s.DeltaJump) * s.Probability
+		r.PinExposure += s.PinExposure
+		r.Strikes = append(r.Strikes, *s)
+	}
+	sort.Slice(r.Strikes, func(i, j int) bool { return r.Strikes[i].Strike < r.Strikes[j].Strike })
+	return r, nil
+}
+
+// settleProbability is the lognormal probability of settling between lo and hi given the forward
+func settleProbability(forward, lo, hi, vol, years float64) float64 {
+	if !(vol > 0) || !(years > 0) {
+		if forward >= lo && forward <= hi {
+			return 1
+		}
+		return 0
+	}
+	below := func(x float64) float64 {
+		if x <= 0 {
+			return 0
+		}
+		sd := vol * math.Sqrt(years)
+		return cumNormDist((math.Log(x/forward) + sd*sd/2) / sd)
+	}
+	return below(hi) - below(lo)
+}
+
+func (r PinRiskReport) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s pin risk as of %s, expiry %s in %.2f days, spot %.2f forward %.2f delta %.4f gamma %.6f exposure %.4f\n",
+		r.Underlying, r.Asof.Format(time.RFC3339), r.Expiry.Format(time.RFC3339), r.Days, r.Spot, r.Forward, r.Delta, r.Gamma, r.PinExposure)
+	fmt.Fprintf(&b, "%10s %8s %8s %8s %8s %10s %10s %8s %8s %8s\n", "strike", "dist", "calls", "puts", "oi", "delta", "gamma", "jump", "prob", "pin")
+	for _, s := range r.Strikes {
+		fmt.Fprintf(&b, "%10.2f %7.2f%% %8.2f %8.2f %8.0f %10.4f %10.6f %8.2f %8.4f %8.4f\n", s.Strike, s.Distance*100,
+			s.Calls, s.Puts, s.OpenInterest, s.Delta, s.Gamma, s.DeltaJump, s.Probability, s.PinExposure)
+	}
+	return b.String()
+}
@@ -2,8 +2,12 @@ package bean
 
 import (
 	util "bean/utils"
+	"errors"
 	"fmt"
+	"runtime"
 	"sort"
+	"sync"
+	"time"
 )
 
 // note that portfolio algebra does not carry locked portfolio, only clone() does
@@ -26,6 +30,17 @@ type Portfolio interface {
 	AddPosition(Position)
 	SetPositions([]Position)
 	Positions() []Position
+	Settle(time.Time, map[Pair]float64) ([]Position, error)
+	SetFeeSchedule(*FeeSchedule, string)
+	Greeks(time.Time, PositionMarket) Greeks
+	GreeksParallel(time.Time, PositionMarket, int) Greeks
+	GreeksMarket(*Market) Greeks
+	BookValue(map[string]OrderBook, map[Pair]float64, ValuationMode) float64
+	VaR(time.Time, PositionMarket, float64, int, map[Pair][]float64, VaRMethod) VaRResult
+	Stress(time.Time, PositionMarket, ...Scenario) []ScenarioResult
+	RhoBuckets(time.Time, PositionMarket) map[string]float64
+	VannaVolgaBuckets(time.Time, PositionMarket, bool) map[string]VannaVolga
+	GreeksByUnderlying(time.Time, PositionMarket, map[Coin]float64) (GreeksReport, error)
 	ShowBrief()
 }
 
@@ -34,6 +49,8 @@ type portfolio struct {
 	balances       map[Coin]float64 // total balance of each coin
 	lockedBalances map[Coin]float64 // locked blance by exchange, used to calculate the free blance for placing order
 	positions      []Position
+	fees           *FeeSchedule // fees charged on settlement, none if nil
+	exName         string       // exchange of the positions, used to find the fees
 }
 
 func NewPortfolio(bal ...interface{}) Portfolio {
@@ -215,6 +232,138 @@ func (p *portfolio) SetPositions(ps []Position) {
 	}
 }
 
+// SetFeeSchedule sets the fees charged when positions held on exName are settled
+func (p *portfolio) SetFeeSchedule(fs *FeeSchedule, exName string) {
+	p.fees = fs
+	p.exName = exName
+}
+
+// Settle expires the options and futures whose delivery is at or before asof, using the settlement (delivery index) price
+// of their underlying. As on deribit, the settled value is paid in the LHS coin and added to the balance, and the
+// positions are removed from the live set. Settlement and delivery fees are charged if a fee schedule is set.
+// Positions without a settlement price are left live and reported in the error.
+func (p *portfolio) Settle(asof time.Time, settlementPrices map[Pair]float64) (settled []Position, err error) {
+	live := make([]Position, 0, len(p.positions))
+	var missing []string
+	for _, pos := range p.positions {
+		if pos.Perp() || pos.Index() || pos.Delivery().After(asof) {
+			live = append(live, pos)
+			continue
+		}
+		settlePrice, ok := settlementPrices[pos.Underlying()]
+		if !ok || settlePrice <= 0 {
+			live = append(live, pos)
+			missing = append(missing, pos.Name())
+			continue
+		}
+		fee := pos.SettlementFee(p.fees.ContractRate(p.exName, pos.Contract), settlePrice)
+		p.AddBalance(pos.Underlying().Coin, pos.SettlementValue(settlePrice)-fee)
+		settled = append(settled, pos)
+	}
+	p.positions = live
+	if len(missing) > 0 {
+		err = errors.New("no settlement price for expired contracts: " + fmt.Sprint(missing))
+	}
+	return
+}
+
+// PositionMarket returns the market parameters used to value a position
+type PositionMarket func(Position) (spotPrice, futPrice, vol float64)
+
+// Greeks returns the PV and greeks of all positions, valued one after the other
+func (p *portfolio) Greeks(asof time.Time, mkt PositionMarket) (g Greeks) {
+	for _, pos := range p.positions {
+		spot, fut, vol := mkt(pos)
+		g = g.Add(pos.Greeks(asof, spot, fut, vol))
+	}
+	return
+}
+
+// GreeksParallel returns the same result as Greeks but values the positions over a pool of workers.
+// The number of workers is capped at GOMAXPROCS, workers <= 0 uses GOMAXPROCS.
+// mkt is called concurrently so must be safe for concurrent use.
+func (p *portfolio) GreeksParallel(asof time.Time, mkt PositionMarket, workers int) (g Greeks) {
+	maxWorkers := runtime.GOMAXPROCS(0)
+	if workers <= 0 || workers > maxWorkers {
+		workers = maxWorkers
+	}
+	if workers > len(p.positions) {
+		workers = len(p.positions)
+	}
+
+	results := make([]Greeks, len(p.positions))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				spot, fut, vol := mkt(p.positions[i])
+				results[i] = p.positions[i].Greeks(asof, spot, fut, vol)
+			}
+		}()
+	}
+	for i := range p.positions {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	// aggregate in position order so the result is identical to the sequential one
+	for _, r := range results {
+		g = g.Add(r)
+	}
+	return
+}
+
+// RhoBuckets returns the rho of the positions summed by expiry (see Contract.ExpiryStr)
+func (p *portfolio) RhoBuckets(asof time.Time, mkt PositionMarket) map[string]float64 {
+	res := make(map[string]float64)
+	for _, pos := range p.positions {
+		if pos.Perp() || pos.Index() {
+			continue
+		}
+		spot, fut, vol := mkt(pos)
+		res[pos.ExpiryStr()] += pos.Rho(asof, spot, fut, vol)
+	}
+	return res
+}
+
+// VannaVolga holds the second order vol greeks of a set of positions
+type VannaVolga struct {
+	Vanna float64 // change in delta (lhs coin) per vol point
+	Volga float64 // change in vega (rhs coin) per vol point
+}
+
+// VannaVolgaBuckets returns the vanna and volga of the option positions summed by expiry (see Contract.ExpiryStr),
+// computed analytically if analytic is true or else by bumping the vol
+func (p *portfolio) VannaVolgaBuckets(asof time.Time, mkt PositionMarket, analytic bool) map[string]VannaVolga {
+	res := make(map[string]VannaVolga)
+	ge := NewGreeksEngine(asof)
+	for _, pos := range p.positions {
+		if !pos.IsOption() {
+			continue
+		}
+		spot, fut, vol := mkt(pos)
+		b := res[pos.ExpiryStr()]
+		if analytic {
+			b.Vanna += ge.Vanna(pos.Contract, spot, fut, vol) * pos.Qty()
+			b.Volga += ge.Volga(pos.Contract, spot, fut, vol) * pos.Qty()
+		} else {
+			b.Vanna += pos.Vanna(asof, spot, fut, vol)
+			b.Volga += pos.Volga(asof, spot, fut, vol)
+		}
+		res[pos.ExpiryStr()] = b
+	}
+	return res
+}
+
+// GreeksMarket returns the PV and greeks of all positions valued in the market
+func (p *portfolio) GreeksMarket(m *Market) Greeks {
+	return p.Greeks(m.Asof(), m.Params)
+}
+
 /*
 // Log - log to logger, note that we do not log locked balance since it's only for exchange
 func (p portfolio) Log(msg string) {
@@ -1,6 +1,9 @@
 package bean
 
-import "time"
+import (
+	"math"
+	"time"
+)
 
 type Position struct {
 	*Contract
@@ -41,40 +44,77 @@ func NewPosition(c *Contract, qty, price float64) Position {
 
 // Calculate the price of a contract given market parameters. Price is in RHS coin value spot
 // Discounting assumes zero interest rate on LHS coin (normally BTC) which is deribit standard. Note USD rates float and are generally negative.
+// Options at or after expiry are worth their intrinsic value at futPrice, see GreeksAt
 func (p Position) PV(asof time.Time, spotPrice, futPrice, vol float64) float64 {
 	if p.IsOption() {
 		/*		return p.Con.OptPrice(asof, spotPrice, futPrice, vol) * p.Qty*/
-		return p.OptPrice(asof, spotPrice, futPrice, vol)*p.qty - p.price*spotPrice*p.qty
+		optPrice, _ := p.OptPrice(asof, spotPrice, futPrice, vol)
+		return optPrice*p.qty - p.price*spotPrice*p.qty
 	} else {
-		return (1.0/p.price - 1.0/futPrice) * spotPrice * p.qty * 10.0
+		return (1.0/p.price - 1.0/futPrice) * spotPrice * p.qty * p.Multiplier()
 	}
 }
 
-// in rhs coin spot value
+// in rhs coin spot value, per vol point with a central ±0.5 point bump. See VegaWith for other conventions
 func (p Position) Vega(asof time.Time, spotPrice, futPrice, vol float64) float64 {
-	return p.PV(asof, spotPrice, futPrice, vol+0.005) - p.PV(asof, spotPrice, futPrice, vol-0.005)
+	return p.VegaWith(asof, spotPrice, futPrice, vol, DefaultVega)
 }
 
-//in lhs coin spot value
+// VegaOptions sets how vega is computed: the vol bump (0.005 for half a vol point), a one-sided (up) bump instead of
+// a central difference, and whether vega is quoted per vol point (0.01 absolute) or per 1% of the vol itself
+type VegaOptions struct {
+	Bump     float64
+	OneSided bool
+	Relative bool
+}
+
+// DefaultVega is the convention used by Vega and Greeks
+var DefaultVega = VegaOptions{Bump: 0.005}
+
+// VegaWith returns the vega in rhs coin spot value using the given bump and units
+func (p Position) VegaWith(asof time.Time, spotPrice, futPrice, vol float64, opts VegaOptions) float64 {
+	h := opts.Bump
+	if h <= 0 {
+		h = DefaultVega.Bump
+	}
+	unit := 0.01
+	if opts.Relative {
+		unit = 0.01 * vol
+	}
+	if opts.OneSided {
+		return (p.PV(asof, spotPrice, futPrice, vol+h) - p.PV(asof, spotPrice, futPrice, vol)) * unit / h
+	}
+	return (p.PV(asof, spotPrice, futPrice, vol+h) - p.PV(asof, spotPrice, futPrice, vol-h)) * unit / (2 * h)
+}
+
+// in lhs coin spot value. Options at or after expiry have the delta of the coins they settle for, see SettledGreeks
 func (p Position) Delta(asof time.Time, spotPrice, futPrice, vol float64) float64 {
+	if p.IsOption() && p.Expired(asof) {
+		return (p.SettledGreeks(spotPrice, futPrice).Delta - p.price) * p.qty
+	}
 	deltaFiat := (p.PV(asof, spotPrice*1.005, futPrice*1.005, vol) - p.PV(asof, spotPrice*0.995, futPrice*0.995, vol)) * 100.0
 
 	return deltaFiat / spotPrice
 }
 
+// BucketDelta splits the delta, in lhs coin spot value, into the CashBucket sensitivity to spot and the sensitivity
+// to the forward of the expiry of the position
 func (p Position) BucketDelta(asof time.Time, spotPrice, futPrice, vol float64) map[string]float64 {
 	totdelta := (p.PV(asof, spotPrice*1.005, futPrice*1.005, vol) - p.PV(asof, spotPrice*0.995, futPrice*0.995, vol)) * 100.0
 	spotDelta := (p.PV(asof, spotPrice*1.005, futPrice, vol) - p.PV(asof, spotPrice*0.995, futPrice, vol)) * 100.0
 
 	delta := make(map[string]float64)
-	delta["CASH"] = spotDelta / spotPrice
+	delta[CashBucket] = spotDelta / spotPrice
 	delta[p.ExpiryStr()] = (totdelta - spotDelta) / spotPrice
 
 	return delta
 }
 
-//in lhs coin spot value
+// in lhs coin spot value, zero for options at or after expiry
 func (p Position) Gamma(asof time.Time, spotPrice, futPrice, vol float64) float64 {
+	if p.IsOption() && p.Expired(asof) {
+		return 0.0
+	}
 	gammaFiat := p.Delta(asof, spotPrice*1.005, futPrice*1.005, vol) - p.Delta(asof, spotPrice*0.995, futPrice*0.995, vol)
 
 	return gammaFiat
@@ -84,3 +124,68 @@ func (p Position) Gamma(asof time.Time, spotPrice, futPrice, vol float64) float6
 func (p Position) Theta(asof time.Time, spotPrice, futPrice, vol float64) float64 {
 	return p.PV(asof.Add(24*time.Hour), spotPrice, futPrice, vol) - p.PV(asof, spotPrice, futPrice, vol)
 }
+
+// Vanna returns the change in Delta (lhs coin) for a 1 vol point rise in vol, with a central ±0.5 point bump
+func (p Position) Vanna(asof time.Time, spotPrice, futPrice, vol float64) float64 {
+	return p.Delta(asof, spotPrice, futPrice, vol+0.005) - p.Delta(asof, spotPrice, futPrice, vol-0.005)
+}
+
+// Volga returns the change in Vega (rhs coin spot value) for a 1 vol point rise in vol
+func (p Position) Volga(asof time.Time, spotPrice, futPrice, vol float64) float64 {
+	return p.Vega(asof, spotPrice, futPrice, vol+0.005) - p.Vega(asof, spotPrice, futPrice, vol-0.005)
+}
+
+// Rho returns the change in PV, in rhs coin spot value, for a 1% rise in the rate the forward is implied at
+// (the basis ln(F/S)/T, see FuturesCurve) with spot unchanged. Perpetuals and the index carry no rate exposure
+func (p Position) Rho(asof time.Time, spotPrice, futPrice, vol float64) float64 {
+	if p.Perp() || p.Index() {
+		return 0.0
+	}
+	years := p.ExpiryYears(asof)
+	if years <= 0 {
+		return 0.0
+	}
+	bump := math.Exp(0.005 * years)
+	return p.PV(asof, spotPrice, futPrice*bump, vol) - p.PV(asof, spotPrice, futPrice/bump, vol)
+}
+
+// Greeks returns the PV and bumped greeks of the position
+func (p Position) Greeks(asof time.Time, spotPrice, futPrice, vol float64) Greeks {
+	return Greeks{
+		PV:    p.PV(asof, spotPrice, futPrice, vol),
+		Delta: p.Delta(asof, spotPrice, futPrice, vol),
+		Gamma: p.Gamma(asof, spotPrice, futPrice, vol),
+		Vega:  p.Vega(asof, spotPrice, futPrice, vol),
+		Theta: p.Theta(asof, spotPrice, futPrice, vol),
+	}
+}
+
+// SettlementValue is the realized PnL of the position at delivery in LHS coin, given the settlement price.
+// Options pay their intrinsic value less the premium paid, futures the difference to the entry price.
+func (p Position) SettlementValue(settlePrice float64) float64 {
+	if p.IsOption() {
+		var intrinsic float64
+		if p.CallPut() == Call {
+			intrinsic = math.Max(settlePrice-p.Strike(), 0.0)
+		} else {
+			intrinsic = math.Max(p.Strike()-settlePrice, 0.0)
+		}
+		return (intrinsic/settlePrice - p.price) * p.qty
+	} else {
+		return (1.0/p.price - 1.0/settlePrice) * p.qty * p.Multiplier()
+	}
+}
+
+// SettlementFee is the settlement (options) or delivery (futures) fee in LHS coin given the settlement price.
+// Option fees are capped at the option cap times the settled value
+func (p Position) SettlementFee(r FeeRate, settlePrice float64) float64 {
+	if p.IsOption() {
+		fee := r.ExpiryFee(p.Contract, p.qty)
+		if r.OptionCap > 0 {
+			fee = math.Min(fee, r.OptionCap*math.Abs(p.SettlementValue(settlePrice)+p.price*p.qty))
+		}
+		return fee
+	} else {
+		return r.ExpiryFee(p.Contract, p.qty*p.Multiplier()/settlePrice)
+	}
+}
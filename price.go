@@ -0,0 +1,81 @@
+package bean
+
+import "math"
+
+// Ticks is a price as a whole number of ticks. Unlike float prices it compares exactly: 0.1+0.2 and 0.3 are both
+// 3 ticks of 0.1
+type Ticks int64
+
+// PriceScale converts prices to and from the ticks of an instrument. The zero scale has no tick: it compares prices
+// exactly, leaves them unrounded and counts whole units as ticks
+type PriceScale struct {
+	tick float64
+}
+
+// NewPriceScale returns the scale of a tick size, the zero scale if tick is not positive
+func NewPriceScale(tick float64) PriceScale {
+	if !(tick > 0) {
+		return PriceScale{}
+	}
+	return PriceScale{tick: tick}
+}
+
+// PriceScale returns the scale of the tick size of the instrument. Prices of larger TickSteps are multiples of it
+func (s InstrumentSpec) PriceScale() PriceScale {
+	return NewPriceScale(s.TickSize)
+}
+
+// Tick returns the tick size, zero for the zero scale
+func (s PriceScale) Tick() float64 {
+	return s.tick
+}
+
+// Ticks returns the nearest number of ticks of a price
+func (s PriceScale) Ticks(price float64) Ticks {
+	if s.tick == 0 {
+		return Ticks(math.Round(price))
+	}
+	return Ticks(math.Round(price / s.tick))
+}
+
+// Price returns the price of a number of ticks, the closest float to the decimal multiple of the tick
+func (s PriceScale) Price(t Ticks) float64 {
+	if s.tick == 0 {
+		return float64(t)
+	}
+	return cleanMultiple(float64(t), s.tick)
+}
+
+// Round returns a price rounded to the nearest tick, unchanged for the zero scale
+func (s PriceScale) Round(price float64) float64 {
+	if s.tick == 0 {
+		return price
+	}
+	return s.Price(s.Ticks(price))
+}
+
+// Compare returns -1, 0 or 1 as price a is below, at the same tick as or above price b
+func (s PriceScale) Compare(a, b float64) int {
+	if s.tick != 0 {
+		ta, tb := s.Ticks(a), s.Ticks(b)
+		switch {
+		case ta < tb:
+			return -1
+		case ta > tb:
+			return 1
+		}
+		return 0
+	}
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// Equal is true for prices at the same tick
+func (s PriceScale) Equal(a, b float64) bool {
+	return s.Compare(a, b) == 0
+}
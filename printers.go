@@ -0,0 +1,94 @@
+package bean
+
+import (
+	util "bean/utils"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LadderDepth is the number of levels shown by OrderBook.String
+var LadderDepth = 5
+
+func (c *Contract) String() string {
+	return c.Name()
+}
+
+func (p Position) String() string {
+	return fmt.Sprintf("%s %v@%v", p.Name(), p.qty, p.price)
+}
+
+func (o Order) String() string {
+	return fmt.Sprintf("%v@%v", o.Amount, o.Price)
+}
+
+// String renders the top LadderDepth levels of the book as a ladder
+func (ob OrderBook) String() string {
+	return ob.Ladder(LadderDepth)
+}
+
+// Brief returns a one line summary of the book
+func (ob OrderBook) Brief() string {
+	if ob.OrderBookCore == nil || !ob.Valid() {
+		return "empty orderbook"
+	}
+	return fmt.Sprint("depth:", len(ob.Asks()), " bestBid:", ob.BestBid().Price, " bestAsk:", ob.BestAsk().Price)
+}
+
+func formatNumber(x float64) string {
+	return strconv.FormatFloat(x, 'f', -1, 64)
+}
+
+// Ladder renders up to depth levels of the book in fixed width columns, bids on the left and asks on the right
+func (ob OrderBook) Ladder(depth int) string {
+	const row = "%14s %14s | %-14s %s\n"
+	var b strings.Builder
+	fmt.Fprintf(&b, row, "AMT", "BID", "ASK", "AMT")
+	if ob.OrderBookCore == nil {
+		return b.String()
+	}
+	bids, asks := ob.Bids(), ob.Asks()
+	n := util.MinOf(util.MaxOf(len(bids), len(asks)), depth)
+	for i := 0; i < n; i++ {
+		v := make([]string, 4)
+		if i < len(bids) {
+			v[0] = formatNumber(bids[i].Amount)
+			v[1] = formatNumber(bids[i].Price)
+		}
+		if i < len(asks) {
+			v[2] = formatNumber(asks[i].Price)
+			v[3] = formatNumber(asks[i].Amount)
+		}
+		fmt.Fprintf(&b, row, v[0], v[1], v[2], v[3])
+	}
+	return b.String()
+}
+
+func (ob OrderBookT) String() string {
+	return ob.Time.Format(time.RFC3339Nano) + "\n" + ob.OrderBook.String()
+}
+
+// PositionsTable renders positions with their quantity, entry price, mark price, PnL (PV) and greeks valued in mkt.
+// Marks are in LHS coin for options and RHS coin for futures, as the entry prices
+func PositionsTable(positions []Position, asof time.Time, mkt PositionMarket) string {
+	const head = "%-24s %10s %12s %12s %14s %10s %10s %12s %12s\n"
+	const row = "%-24s %10.4g %12.6g %12.6g %14.2f %10.4f %10.4f %12.2f %12.2f\n"
+	var b strings.Builder
+	fmt.Fprintf(&b, head, "CONTRACT", "QTY", "ENTRY", "MARK", "PNL", "DELTA", "GAMMA", "VEGA", "THETA")
+	var total Greeks
+	for _, p := range positions {
+		spot, fut, vol := mkt(p)
+		mark := fut
+		if p.IsOption() {
+			price, _ := p.OptPrice(asof, spot, fut, vol)
+			mark = price / spot
+		}
+		g := p.Greeks(asof, spot, fut, vol)
+		total = total.Add(g)
+		fmt.Fprintf(&b, row, p.Name(), p.qty, p.price, mark, g.PV, g.Delta, g.Gamma, g.Vega, g.Theta)
+	}
+	fmt.Fprintf(&b, "%-24s %10s %12s %12s %14.2f %10.4f %10.4f %12.2f %12.2f\n",
+		"TOTAL", "", "", "", total.PV, total.Delta, total.Gamma, total.Vega, total.Theta)
+	return b.String()
+}
@@ -0,0 +1,205 @@
+package bean
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// QualityIssueKind is a type of problem found in recorded market data
+type QualityIssueKind string
+
+const (
+	IssueOutOfOrder  QualityIssueKind = "out_of_order"  // time or change id going backwards
+	IssueSequenceGap QualityIssueKind = "sequence_gap"  // change ids skipped
+	IssueStale       QualityIssueKind = "stale"         // no update for longer than MaxStale
+	IssueCrossed     QualityIssueKind = "crossed"       // best bid above best ask, a negative spread
+	IssueLocked      QualityIssueKind = "locked"        // best bid equal to best ask
+	IssueOneSided    QualityIssueKind = "one_sided"     // a side of the book is empty
+	IssueBadLevel    QualityIssueKind = "bad_level"     // a level or trade with a non positive or NaN price or amount
+	IssueDuplicate   QualityIssueKind = "duplicate"     // a trade id seen before
+	IssuePriceJump   QualityIssueKind = "price_jump"    // a trade away from the previous one by more than MaxJump
+	IssueUnsorted    QualityIssueKind = "unsorted_book" // levels not sorted best first
+)
+
+// QualityIssue is a problem at an index of a recorded series
+type QualityIssue struct {
+	Kind   QualityIssueKind
+	Series string // books or trades
+	Index  int
+	Time   time.Time
+	Detail string
+}
+
+func (i QualityIssue) String() string {
+	return fmt.Sprintf("%s %s[%d] %s: %s", i.Time.Format(time.RFC3339Nano), i.Series, i.Index, i.Kind, i.Detail)
+}
+
+// QualityReport is the result of a QualityCheck scan
+type QualityReport struct {
+	Books      int
+	Trades     int
+	Start, End time.Time
+	StaleTime  time.Duration // total time of the stale periods
+	Issues     []QualityIssue
+}
+
+// Count returns the number of issues of a kind
+func (r QualityReport) Count(kind QualityIssueKind) int {
+	n := 0
+	for _, i := range r.Issues {
+		if i.Kind == kind {
+			n++
+		}
+	}
+	return n
+}
+
+// OK is true if no issue was found
+func (r QualityReport) OK() bool {
+	return len(r.Issues) == 0
+}
+
+// String summarises the report with the count of each kind of issue
+func (r QualityReport) String() string {
+	counts := make(map[QualityIssueKind]int)
+	for _, i := range r.Issues {
+		counts[i.Kind]++
+	}
+	kinds := make([]string, 0, len(counts))
+	for k, n := range counts {
+		kinds = append(kinds, fmt.Sprintf("%s=%d", k, n))
+	}
+	sort.Strings(kinds)
+	return fmt.Sprintf("%d books, %d trades from %s to %s, stale %s, %d issues %s", r.Books, r.Trades,
+		r.Start.Format(time.RFC3339), r.End.Format(time.RFC3339), r.StaleTime, len(r.Issues), strings.Join(kinds, " "))
+}
+
+// QualityCheck scans recorded books and trades for problems that would distort a backtest
+type QualityCheck struct {
+	MaxStale         time.Duration // longest expected time without an update, zero not to check
+	ConsecutiveIds   bool          // change ids of the books must increase by one, otherwise only increase
+	MaxJump          float64       // largest relative move between consecutive trades, zero not to check
+	AllowLocked      bool          // do not report books with equal best bid and ask
+	AllowOneSided    bool          // do not report books with an empty side
+	CheckAllLevels   bool          // check every level and their order, not only the top of book
+	MaxIssuesPerKind int           // stop recording issues of a kind beyond this number, zero for no limit
+}
+
+// Scan checks the books and trades of one instrument, either of which may be nil
+func (q QualityCheck) Scan(books OrderBookTS, trades Transactions) QualityReport {
+	r := QualityReport{Books: len(books), Trades: len(trades)}
+	counts := make(map[QualityIssueKind]int)
+	add := func(kind QualityIssueKind, series string, i int, t time.Time, format string, args ...interface{}) {
+		counts[kind]++
+		if q.MaxIssuesPerKind > 0 && counts[kind] > q.MaxIssuesPerKind {
+			return
+		}
+		r.Issues = append(r.Issues, QualityIssue{Kind: kind, Series: series, Index: i, Time: t, Detail: fmt.Sprintf(format, args...)})
+	}
+	span := func(t time.Time) {
+		if r.Start.IsZero() || t.Before(r.Start) {
+			r.Start = t
+		}
+		if t.After(r.End) {
+			r.End = t
+		}
+	}
+	stale := func(series string, i int, prev, t time.Time) {
+		if gap := t.Sub(prev); q.MaxStale > 0 && gap > q.MaxStale {
+			r.StaleTime += gap
+			add(IssueStale, series, i, t, "no update for %s", gap)
+		}
+	}
+
+	for i := range books {
+		ob := &books[i]
+		span(ob.Time)
+		if i > 0 {
+			prev := &books[i-1]
+			if ob.Time.Before(prev.Time) {
+				add(IssueOutOfOrder, "books", i, ob.Time, "time before previous %s", prev.Time.Format(time.RFC3339Nano))
+			} else {
+				stale("books", i, prev.Time, ob.Time)
+			}
+			if ob.ChangeId != 0 && prev.ChangeId != 0 {
+				switch {
+				case ob.ChangeId <= prev.ChangeId:
+					add(IssueOutOfOrder, "books", i, ob.Time, "change id %d after %d", ob.ChangeId, prev.ChangeId)
+				case q.ConsecutiveIds && ob.ChangeId != prev.ChangeId+1:
+					add(IssueSequenceGap, "books", i, ob.Time, "change id %d after %d, %d missing", ob.ChangeId, prev.ChangeId, ob.ChangeId-prev.ChangeId-1)
+				}
+			}
+		}
+		q.checkBook(ob, func(kind QualityIssueKind, format string, args ...interface{}) {
+			add(kind, "books", i, ob.Time, format, args...)
+		})
+	}
+
+	seen := make(map[string]bool)
+	for i, t := range trades {
+		span(t.TimeStamp)
+		if !validPrice(t.Price) || t.Amount == 0 || math.IsNaN(t.Amount) {
+			add(IssueBadLevel, "trades", i, t.TimeStamp, "trade %v at %v", t.Amount, t.Price)
+		}
+		if t.TxnID != "" {
+			if seen[t.TxnID] {
+				add(IssueDuplicate, "trades", i, t.TimeStamp, "trade id %s", t.TxnID)
+			}
+			seen[t.TxnID] = true
+		}
+		if i == 0 {
+			continue
+		}
+		prev := trades[i-1]
+		if t.TimeStamp.Before(prev.TimeStamp) {
+			add(IssueOutOfOrder, "trades", i, t.TimeStamp, "time before previous %s", prev.TimeStamp.Format(time.RFC3339Nano))
+		} else {
+			stale("trades", i, prev.TimeStamp, t.TimeStamp)
+		}
+		if q.MaxJump > 0 && validPrice(prev.Price) && validPrice(t.Price) && math.Abs(t.Price/prev.Price-1) > q.MaxJump {
+			add(IssuePriceJump, "trades", i, t.TimeStamp, "price %v after %v", t.Price, prev.Price)
+		}
+	}
+	sort.SliceStable(r.Issues, func(i, j int) bool { return r.Issues[i].Time.Before(r.Issues[j].Time) })
+	return r
+}
+
+// checkBook reports the problems of a single book
+func (q QualityCheck) checkBook(ob *OrderBookT, add func(QualityIssueKind, string, ...interface{})) {
+	if ob.OrderBookCore == nil {
+		add(IssueOneSided, "no book")
+		return
+	}
+	bid, ask := ob.BestBid(), ob.BestAsk()
+	hasBid, hasAsk := !math.IsNaN(bid.Price), !math.IsNaN(ask.Price)
+	if (!hasBid || !hasAsk) && !q.AllowOneSided {
+		add(IssueOneSided, "bid %v ask %v", bid.Price, ask.Price)
+	}
+	if hasBid && hasAsk {
+		if bid.Price > ask.Price {
+			add(IssueCrossed, "bid %v above ask %v", bid.Price, ask.Price)
+		} else if bid.Price == ask.Price && !q.AllowLocked {
+			add(IssueLocked, "bid and ask at %v", bid.Price)
+		}
+	}
+	bids, asks := []Order{bid}, []Order{ask}
+	if q.CheckAllLevels {
+		bids, asks = ob.Bids(), ob.Asks()
+	}
+	for side, levels := range [][]Order{bids, asks} {
+		for i, o := range levels {
+			if math.IsNaN(o.Price) && len(levels) == 1 {
+				continue // empty side
+			}
+			if !validPrice(o.Price) || !(o.Amount > 0) {
+				add(IssueBadLevel, "level %v@%v", o.Amount, o.Price)
+			}
+			if i > 0 && ((side == 0 && o.Price >= levels[i-1].Price) || (side == 1 && o.Price <= levels[i-1].Price)) {
+				add(IssueUnsorted, "level %v after %v", o.Price, levels[i-1].Price)
+			}
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package bean
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// QuoteParams are the widths, skew, sizes and requote rules of a Quoter. Widths, spacing and skew are fractions
+// of the theoretical value, prices and sizes are in the units of the contract book (LHS coin for options)
+type QuoteParams struct {
+	HalfWidth    float64 // half spread around theo
+	MinHalfWidth float64 // floor on the half spread in price units
+	Levels       int     // quotes per side, at least one
+	LevelSpacing float64 // distance between levels
+	Size         float64 // size of the top level
+	SizeGrowth   float64 // size multiplier from one level to the next, zero or one for flat sizes
+	TickSize     float64 // bids are rounded down and asks up to the tick, zero for no rounding
+
+	SkewPerUnit  float64 // shift of both sides per unit of inventory, down when long
+	MaxInventory float64 // no bids above, no asks below minus, zero for no limit
+
+	MinInterval      time.Duration // minimum time between two requotes of a contract
+	RequoteThreshold float64       // move in theo that triggers a requote
+}
+
+// Quote is a two sided ladder of quotes on a contract, best levels first
+type Quote struct {
+	Contract  *Contract
+	Time      time.Time
+	Theo      float64
+	Inventory float64
+	Bids      []Order
+	Asks      []Order
+}
+
+// Quoter generates two sided quotes around a theoretical value, skewed by inventory, and decides when a contract
+// needs requoting on top of book changes. It is safe for concurrent use
+type Quoter struct {
+	QuoteParams
+
+	m      sync.Mutex
+	quotes map[string]quoterState
+}
+
+type quoterState struct {
+	quote    Quote
+	bid, ask float64 // top of book at the last requote
+	pending  bool    // a requote was rate limited
+}
+
+// NewQuoter returns a quoter with params p
+func NewQuoter(p QuoteParams) *Quoter {
+	return &Quoter{QuoteParams: p, quotes: make(map[string]quoterState)}
+}
+
+// TheoPrice returns the theoretical value of a contract in its book price units: the forward for futures and the
+// option price in LHS coin for options
+func TheoPrice(c *Contract, asof time.Time, spot, forward, vol float64) (float64, error) {
+	if !c.IsOption() {
+		return forward, nil
+	}
+	p, err := c.OptPrice(asof, spot, forward, vol)
+	return p / spot, err
+}
+
+// Quote returns the ladder around theo for an inventory in contract units, without recording it
+func (q *Quoter) Quote(c *Contract, asof time.Time, theo, inventory float64) Quote {
+	res := Quote{Contract: c, Time: asof, Theo: theo, Inventory: inventory}
+	if !(theo > 0) || math.IsInf(theo, 0) {
+		return res
+	}
+	half := math.Max(q.HalfWidth*theo, q.MinHalfWidth)
+	mid := theo * (1 - q.SkewPerUnit*inventory)
+	levels := q.Levels
+	if levels < 1 {
+		levels = 1
+	}
+	size := q.Size
+	for i := 0; i < levels; i++ {
+		offset := half + float64(i)*q.LevelSpacing*theo
+		bid := q.roundDown(mid - offset)
+		ask := q.roundUp(mid + offset)
+		if bid > 0 && (q.MaxInventory == 0 || inventory < q.MaxInventory) {
+			res.Bids = append(res.Bids, Order{Price: bid, Amount: size})
+		}
+		if q.MaxInventory == 0 || inventory > -q.MaxInventory {
+			res.Asks = append(res.Asks, Order{Price: ask, Amount: size})
+		}
+		if q.SizeGrowth > 0 {
+			size *= q.SizeGrowth
+		}
+	}
+	return res
+}
+
+// OnBook is called on each book update of a contract with the current theo and inventory. It returns a new quote
+// and true when the contract must be requoted: on the first call, when the top of book, the inventory or, beyond
+// RequoteThreshold, the theo changed. Requotes closer than MinInterval to the previous one are held back until
+// the next update after the interval. Quotes are kept from crossing the book
+func (q *Quoter) OnBook(c *Contract, ob OrderBookT, theo, inventory float64) (Quote, bool) {
+	bid, ask, _ := ob.BidAskMid()
+	q.m.Lock()
+	defer q.m.Unlock()
+	name := c.Name()
+	st, ok := q.quotes[name]
+	changed := !ok || st.pending ||
+		!sameFloat(bid, st.bid) || !sameFloat(ask, st.ask) ||
+		inventory != st.quote.Inventory ||
+		math.Abs(theo-st.quote.Theo) > q.RequoteThreshold*math.Abs(st.quote.Theo)
+	if !changed {
+		return st.quote, false
+	}
+	if ok && ob.Time.Sub(st.quote.Time) < q.MinInterval {
+		st.pending = true
+		q.quotes[name] = st
+		return st.quote, false
+	}
+	quote := q.Quote(c, ob.Time, theo, inventory)
+	q.unCross(&quote, bid, ask)
+	q.quotes[name] = quoterState{quote: quote, bid: bid, ask: ask}
+	return quote, true
+}
+
+// Last returns the last quote of a contract returned by OnBook
+func (q *Quoter) Last(c *Contract) (Quote, bool) {
+	q.m.Lock()
+	defer q.m.Unlock()
+	st, ok := q.quotes[c.Name()]
+	return st.quote, ok
+}
+
+// Reset forgets the quote of a contract, so the next book update requotes it
+func (q *Quoter) Reset(c *Contract) {
+	q.m.Lock()
+	defer q.m.Unlock()
+	delete(q.quotes, c.Name())
+}
+
+// unCross moves bids below the best ask and asks above the best bid by a tick, dropping bids that end non positive
+func (q *Quoter) unCross(quote *Quote, bestBid, bestAsk float64) {
+	tick := q.TickSize
+	bids := quote.Bids[:0]
+	for _, o := range quote.Bids {
+		if !math.IsNaN(bestAsk) && o.Price >= bestAsk {
+			o.Price = q.roundDown(bestAsk - tick)
+		}
+		if o.Price > 0 {
+			bids = append(bids, o)
+		}
+	}
+	quote.Bids = bids
+	for i, o := range quote.Asks {
+		if !math.IsNaN(bestBid) && o.Price <= bestBid {
+			quote.Asks[i].Price = q.roundUp(bestBid + tick)
+		}
+	}
+}
+
+func (q *Quoter) roundDown(p float64) float64 {
+	return RoundDownToTick(p, q.TickSize)
+}
+
+func (q *Quoter) roundUp(p float64) float64 {
+	return RoundUpToTick(p, q.TickSize)
+}
+
+// sameFloat compares floats treating NaNs as equal
+func sameFloat(a, b float64) bool {
+	return a == b || (math.IsNaN(a) && math.IsNaN(b))
+}
@@ -0,0 +1,163 @@
+package bean
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Request budgets of the exchanges, see NewExchangeRateLimiter
+const (
+	BinanceWeightPerMinute = 1200  // request weight per minute window
+	DeribitCredits         = 50000 // maximum non matching engine credits
+	DeribitCreditRefill    = 10000 // credits refilled per second
+	DeribitRequestCost     = 500   // credits of a non matching engine request
+)
+
+// RateLimiter tracks the request budget of an exchange connection, which connectors and execution algos consult
+// before sending. The budget is either a bucket of credits refilled continuously up to a burst capacity (deribit
+// credits) or a weight reset at the start of each window (binance). It is safe for concurrent use
+type RateLimiter struct {
+	Capacity float64       // burst budget
+	Refill   float64       // budget refilled per second, for buckets
+	Window   time.Duration // the budget resets to Capacity at each multiple of Window if non zero
+	Clock    Clock         // time of the budget, the system clock if nil
+
+	m         sync.Mutex
+	available float64
+	last      time.Time // time of the last refill
+}
+
+// NewRateLimiter returns a credit bucket of burst capacity refilled at refill per second
+func NewRateLimiter(capacity, refill float64) *RateLimiter {
+	return &RateLimiter{Capacity: capacity, Refill: refill, available: capacity}
+}
+
+// NewWindowRateLimiter returns a weight budget of capacity reset at the start of each window
+func NewWindowRateLimiter(capacity float64, window time.Duration) *RateLimiter {
+	return &RateLimiter{Capacity: capacity, Window: window, available: capacity}
+}
+
+// NewExchangeRateLimiter returns the limiter of the public request budget of an exchange
+func NewExchangeRateLimiter(exName string) (*RateLimiter, error) {
+	switch strings.ToUpper(exName) {
+	case NameBinance:
+		return NewWindowRateLimiter(BinanceWeightPerMinute, time.Minute), nil
+	case NameDeribit:
+		return NewRateLimiter(DeribitCredits, DeribitCreditRefill), nil
+	}
+	return nil, errors.New("no rate limits for exchange " + exName)
+}
+
+func (r *RateLimiter) now() time.Time {
+	if r.Clock == nil {
+		return RealClock.Now()
+	}
+	return r.Clock.Now()
+}
+
+// refill brings the budget up to now, called with the lock held. The budget starts full at the first call and a
+// clock going backwards refills nothing
+func (r *RateLimiter) refill(now time.Time) {
+	if r.last.IsZero() {
+		r.last = now
+	}
+	if now.Before(r.last) {
+		return
+	}
+	if r.Window > 0 {
+		if !now.Truncate(r.Window).Equal(r.last.Truncate(r.Window)) {
+			r.available = r.Capacity
+		}
+	} else if dt := now.Sub(r.last).Seconds(); dt > 0 {
+		r.available = math.Min(r.Capacity, r.available+dt*r.Refill)
+	}
+	r.last = now
+}
+
+// Available returns the budget left now
+func (r *RateLimiter) Available() float64 {
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.refill(r.now())
+	return r.available
+}
+
+// Allow takes cost from the budget and returns true if enough is left, otherwise leaves it and returns false
+func (r *RateLimiter) Allow(cost float64) bool {
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.refill(r.now())
+	counter(MetricRateLimitRequests).Inc()
+	if cost > r.available {
+		counter(MetricRateLimitThrottled).Inc()
+		return false
+	}
+	r.available -= cost
+	return true
+}
+
+// Delay returns how long until cost is available, zero if it is now and a negative duration if it never will be
+// as cost is beyond the capacity
+func (r *RateLimiter) Delay(cost float64) time.Duration {
+	r.m.Lock()
+	defer r.m.Unlock()
+	now := r.now()
+	r.refill(now)
+	return r.delay(now, cost)
+}
+
+func (r *RateLimiter) delay(now time.Time, cost float64) time.Duration {
+	switch {
+	case cost <= r.available:
+		return 0
+	case cost > r.Capacity:
+		return -1
+	case r.Window > 0:
+		return now.Truncate(r.Window).Add(r.Window).Sub(now)
+	case r.Refill <= 0:
+		return -1
+	}
+	return time.Duration(math.Ceil((cost - r.available) / r.Refill * float64(time.Second)))
+}
+
+// Wait blocks until cost is available and takes it, returning the context error if ctx is done first or
+// ErrRateLimit if cost can never be available. It sleeps in real time so is meant for live connections
+func (r *RateLimiter) Wait(ctx context.Context, cost float64) error {
+	for {
+		r.m.Lock()
+		now := r.now()
+		r.refill(now)
+		d := r.delay(now, cost)
+		if d == 0 {
+			r.available -= cost
+			r.m.Unlock()
+			counter(MetricRateLimitRequests).Inc()
+			return nil
+		}
+		r.m.Unlock()
+		if d < 0 {
+			return ErrRateLimit
+		}
+		counter(MetricRateLimitThrottled).Inc()
+		t := time.NewTimer(d)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// Sync sets the budget used so far, as reported by the exchange (binance X-MBX-USED-WEIGHT header, deribit
+// remaining credits as Capacity minus used)
+func (r *RateLimiter) Sync(used float64) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.refill(r.now())
+	r.available = math.Max(0, r.Capacity-used)
+}
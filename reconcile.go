@@ -0,0 +1,250 @@
+package bean
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// PairPosition is a position in coins on a pair, as recorded by a Blotter
+type PairPosition struct {
+	Pair  Pair
+	Qty   float64
+	Price float64 // entry or mark price adjustments are recorded at
+}
+
+// ExchangeState is the account of an exchange as it reports it. It must cover the whole account reconciled:
+// balances and positions missing from it are taken as zero
+type ExchangeState struct {
+	Time          time.Time
+	Balances      map[Coin]float64 // compared with the balances of an Account
+	Positions     []PositionState  // in contracts, compared with the positions of an Account
+	PairPositions []PairPosition   // compared with the positions of a Blotter
+}
+
+// StateFunc fetches the state of an exchange account
+type StateFunc func(ctx context.Context) (ExchangeState, error)
+
+// BreakKind is what a break is about
+type BreakKind string
+
+const (
+	BreakBalance      BreakKind = "BALANCE"       // balance of a coin of the Account
+	BreakPosition     BreakKind = "POSITION"      // position on an instrument of the Account
+	BreakPairPosition BreakKind = "PAIR_POSITION" // position on a pair of the Blotter
+)
+
+// Break is a difference between the local and the exchange state above the tolerance of a Reconciler
+type Break struct {
+	Kind     BreakKind
+	Key      string // coin, instrument or pair
+	Local    float64
+	Exchange float64
+	Adopted  bool // the local state was set to the exchange state
+	Time     time.Time
+}
+
+// Diff returns the exchange quantity minus the local one
+func (b Break) Diff() float64 {
+	return b.Exchange - b.Local
+}
+
+func (b Break) String() string {
+	return fmt.Sprintf("%s %s: local %g, exchange %g", b.Kind, b.Key, b.Local, b.Exchange)
+}
+
+// Reconciler compares the state reported by an exchange with the local Account and Blotter kept from our fills,
+// reporting the breaks above a tolerance. With Adopt the local state is set to the exchange state on breaks,
+// Blotter positions being adjusted by a fill at the reported price. It is safe for concurrent use
+type Reconciler struct {
+	Fetch     StateFunc
+	Account   *Account // balances and positions, may be nil
+	Blotter   *Blotter // pair positions, may be nil
+	Tolerance float64  // absolute difference under which quantities agree
+	Adopt     bool
+	Clock     Clock // time of the states fetched without one, the system clock if nil
+
+	m        sync.Mutex
+	handlers []func([]Break)
+	last     []Break
+	lastTime time.Time
+}
+
+// NewReconciler returns a reconciler of an account and a blotter, either of which may be nil
+func NewReconciler(fetch StateFunc, account *Account, blotter *Blotter, tolerance float64) *Reconciler {
+	return &Reconciler{Fetch: fetch, Account: account, Blotter: blotter, Tolerance: tolerance}
+}
+
+// OnBreaks adds a handler called with the breaks of each reconciliation finding some
+func (r *Reconciler) OnBreaks(f func([]Break)) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.handlers = append(r.handlers, f)
+}
+
+// Last returns the breaks of the last reconciliation and its time, zero if none ran
+func (r *Reconciler) Last() ([]Break, time.Time) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	return append([]Break(nil), r.last...), r.lastTime
+}
+
+// Reconcile fetches the exchange state and returns the breaks with the local state, sorted by kind and key
+func (r *Reconciler) Reconcile(ctx context.Context) ([]Break, error) {
+	s, err := r.Fetch(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("reconcile: %w", err)
+	}
+	if s.Time.IsZero() {
+		s.Time = RealClock.Now()
+		if r.Clock != nil {
+			s.Time = r.Clock.Now()
+		}
+	}
+	var breaks []Break
+	if r.Account != nil {
+		b, err := r.reconcileAccount(s)
+		if err != nil {
+			return nil, fmt.Errorf("reconcile: %w", err)
+		}
+		breaks = append(breaks, b...)
+	}
+	if r.Blotter != nil {
+		breaks = append(breaks, r.reconcileBlotter(s)...)
+	}
+	sort.Slice(breaks, func(i, j int) bool {
+		if breaks[i].Kind != breaks[j].Kind {
+			return breaks[i].Kind < breaks[j].Kind
+		}
+		return breaks[i].Key < breaks[j].Key
+	})
+
+	counter(MetricReconcileBreaks).Add(int64(len(breaks)))
+	for _, b := range breaks {
+		Log().Warnf("reconcile: break %v", b)
+	}
+	r.m.Lock()
+	r.last, r.lastTime = breaks, s.Time
+	handlers := append([]func([]Break){}, r.handlers...)
+	r.m.Unlock()
+	if len(breaks) > 0 {
+		for _, f := range handlers {
+			f(append([]Break(nil), breaks...))
+		}
+	}
+	return breaks, nil
+}
+
+func (r *Reconciler) differ(local, exchange float64) bool {
+	return math.Abs(exchange-local) > r.Tolerance
+}
+
+func (r *Reconciler) reconcileAccount(s ExchangeState) ([]Break, error) {
+	var breaks []Break
+	local := r.Account.Balances()
+	coins := make(map[Coin]struct{})
+	for c := range local {
+		coins[c] = struct{}{}
+	}
+	for c := range s.Balances {
+		coins[c] = struct{}{}
+	}
+	for c := range coins {
+		if r.differ(local[c], s.Balances[c]) {
+			breaks = append(breaks, Break{Kind: BreakBalance, Key: string(c), Local: local[c], Exchange: s.Balances[c],
+				Adopted: r.Adopt, Time: s.Time})
+		}
+	}
+
+	exchange := make(map[string]PositionState, len(s.Positions))
+	for _, p := range s.Positions {
+		c, err := ContractFromName(p.Instrument)
+		if err != nil {
+			return nil, err
+		}
+		p.Instrument = c.Name()
+		exchange[p.Instrument] = p
+	}
+	positions := make(map[string]Position)
+	for _, p := range r.Account.Positions() {
+		positions[p.Name()] = p
+	}
+	names := make(map[string]struct{})
+	for name := range positions {
+		names[name] = struct{}{}
+	}
+	for name := range exchange {
+		names[name] = struct{}{}
+	}
+	for name := range names {
+		if r.differ(positions[name].Qty(), exchange[name].Qty) {
+			breaks = append(breaks, Break{Kind: BreakPosition, Key: name, Local: positions[name].Qty(),
+				Exchange: exchange[name].Qty, Adopted: r.Adopt, Time: s.Time})
+		}
+	}
+
+	if r.Adopt && len(breaks) > 0 {
+		state := r.Account.State()
+		state.Balances = s.Balances
+		state.Positions = state.Positions[:0]
+		for _, p := range exchange {
+			if p.Qty != 0 {
+				state.Positions = append(state.Positions, p)
+			}
+		}
+		if err := r.Account.Restore(state); err != nil {
+			return nil, err
+		}
+	}
+	return breaks, nil
+}
+
+func (r *Reconciler) reconcileBlotter(s ExchangeState) []Break {
+	var breaks []Break
+	exchange := make(map[Pair]PairPosition, len(s.PairPositions))
+	for _, p := range s.PairPositions {
+		q := exchange[p.Pair]
+		q.Pair, q.Qty, q.Price = p.Pair, q.Qty+p.Qty, p.Price
+		exchange[p.Pair] = q
+	}
+	pairs := make(map[Pair]struct{})
+	for _, p := range r.Blotter.Pairs() {
+		pairs[p] = struct{}{}
+	}
+	for p := range exchange {
+		pairs[p] = struct{}{}
+	}
+	for p := range pairs {
+		local, ex := r.Blotter.Position(p), exchange[p]
+		if !r.differ(local, ex.Qty) {
+			continue
+		}
+		breaks = append(breaks, Break{Kind: BreakPairPosition, Key: p.String(), Local: local, Exchange: ex.Qty,
+			Adopted: r.Adopt, Time: s.Time})
+		if r.Adopt {
+			diff := ex.Qty - local
+			r.Blotter.Add(TradeLog{OrderID: "reconcile", Pair: p, Symbol: p.String(), Price: ex.Price,
+				Quantity: math.Abs(diff), Time: s.Time, Side: AmountToSide(diff)})
+		}
+	}
+	return breaks
+}
+
+// Run reconciles at each interval until the context is done, logging the fetch errors
+func (r *Reconciler) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := r.Reconcile(ctx); err != nil {
+				Log().Warnf("%v", err)
+			}
+		}
+	}
+}
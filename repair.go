@@ -0,0 +1,72 @@
+package bean
+
+// Crossed is true when the best bid is above the best ask, so Mid and Spread are meaningless
+func (ob *OrderBook) Crossed() bool {
+	return ob.Valid() && ob.BestBid().Price > ob.BestAsk().Price
+}
+
+// Locked is true when the best bid and ask are at the same price
+func (ob *OrderBook) Locked() bool {
+	return ob.Valid() && ob.BestBid().Price == ob.BestAsk().Price
+}
+
+// RepairPolicy is how a crossed book is repaired
+type RepairPolicy int
+
+const (
+	RepairNone      RepairPolicy = iota // leave the book crossed
+	RepairDropStale                     // drop the levels of the side not just updated that cross the fresh side
+	RepairTrim                          // drop the smaller of the crossing best levels until the book is uncrossed
+)
+
+// Repair uncrosses the book in place with a policy, fresh being the side last updated (empty if unknown, in
+// which case RepairDropStale trims). Locked books are left alone. Returns the number of levels removed
+func (ob OrderBook) Repair(policy RepairPolicy, fresh Side) int {
+	if policy == RepairNone || ob.OrderBookCore == nil || !ob.Crossed() {
+		return 0
+	}
+	removed := 0
+	switch {
+	case policy == RepairDropStale && fresh == BUY:
+		bid := ob.BestBid().Price
+		for ask := ob.BestAsk(); ask.Price <= bid; ask = ob.BestAsk() {
+			ob.CancelAsk(ask)
+			removed++
+		}
+	case policy == RepairDropStale && fresh == SELL:
+		ask := ob.BestAsk().Price
+		for bid := ob.BestBid(); bid.Price >= ask; bid = ob.BestBid() {
+			ob.CancelBid(bid)
+			removed++
+		}
+	default:
+		for ob.Crossed() {
+			bid, ask := ob.BestBid(), ob.BestAsk()
+			if bid.Amount < ask.Amount {
+				ob.CancelBid(bid)
+			} else {
+				ob.CancelAsk(ask)
+			}
+			removed++
+		}
+	}
+	counter(MetricBookRepairs).Add(int64(removed))
+	Log().Warnf("repaired crossed book removing %d levels", removed)
+	return removed
+}
+
+// ApplyPatchRepair applies a patch as ApplyPatch does then repairs the book if it is crossed, the fresh side
+// being the one the patch changed if it changed a single side. Returns the number of levels removed
+func (ob OrderBook) ApplyPatchRepair(p BookPatch, policy RepairPolicy) int {
+	ob.ApplyPatch(p)
+	var fresh Side
+	for i, c := range p {
+		if i == 0 {
+			fresh = c.Side
+		} else if c.Side != fresh {
+			fresh = ""
+			break
+		}
+	}
+	return ob.Repair(policy, fresh)
+}
@@ -0,0 +1,171 @@
+package bean
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// RiskBucket groups the positions on an underlying, all expiries if Expiry is zero. Perpetuals and the index only
+// count in the all expiries bucket
+type RiskBucket struct {
+	Underlying Pair
+	Expiry     time.Time
+}
+
+func (b RiskBucket) String() string {
+	if b.Expiry.IsZero() {
+		return b.Underlying.String()
+	}
+	return b.Underlying.String() + " " + b.Expiry.Format(ContractDateFormat)
+}
+
+// RiskLimit caps the absolute greeks of a bucket, in the units of Greeks. Zero means no limit
+type RiskLimit struct {
+	Delta float64
+	Gamma float64
+	Vega  float64
+}
+
+// RiskLimits are the limits of each bucket
+type RiskLimits map[RiskBucket]RiskLimit
+
+// SetUnderlying sets the limit on all the positions of an underlying
+func (l RiskLimits) SetUnderlying(p Pair, limit RiskLimit) {
+	l[RiskBucket{Underlying: p}] = limit
+}
+
+// SetExpiry sets the limit on the positions of an underlying expiring at expiry
+func (l RiskLimits) SetExpiry(p Pair, expiry time.Time, limit RiskLimit) {
+	l[RiskBucket{Underlying: p, Expiry: expiry}] = limit
+}
+
+// riskBuckets returns the buckets a contract counts in
+func riskBuckets(c *Contract) []RiskBucket {
+	all := RiskBucket{Underlying: c.Underlying()}
+	if expiry := contractExpiry(c); !expiry.IsZero() {
+		return []RiskBucket{all, {Underlying: c.Underlying(), Expiry: expiry}}
+	}
+	return []RiskBucket{all}
+}
+
+// RiskChecker checks proposed orders against limits on the post trade greeks of a portfolio. Orders that would
+// breach a limit are resized to the largest quantity within it or, with RejectOnly, rejected. Orders reducing
+// a bucket already beyond its limit are allowed
+type RiskChecker struct {
+	Limits     RiskLimits
+	Market     PositionMarket
+	RejectOnly bool
+}
+
+// NewRiskChecker returns a checker of limits valuing the positions with mkt
+func NewRiskChecker(limits RiskLimits, mkt PositionMarket) *RiskChecker {
+	return &RiskChecker{Limits: limits, Market: mkt}
+}
+
+// Exposures returns the greeks of the positions in each bucket
+func (r *RiskChecker) Exposures(asof time.Time, positions []Position) map[RiskBucket]Greeks {
+	res := make(map[RiskBucket]Greeks)
+	for _, p := range positions {
+		spot, fut, vol := r.Market(p)
+		g := p.Greeks(asof, spot, fut, vol)
+		for _, b := range riskBuckets(p.Contract) {
+			res[b] = res[b].Add(g)
+		}
+	}
+	return res
+}
+
+// unitGreeks returns the greeks of buying one contract at the forward for futures and at no premium for options
+func (r *RiskChecker) unitGreeks(asof time.Time, c *Contract) Greeks {
+	pos := NewPosition(c, 1, 0)
+	spot, fut, vol := r.Market(pos)
+	if !c.IsOption() {
+		pos = NewPosition(c, 1, fut)
+	}
+	return pos.Greeks(asof, spot, fut, vol)
+}
+
+// Check returns the quantity of an order on c that can be traded within the limits, positive to buy. It is qty
+// if the post trade greeks are within limits, a smaller quantity of the same sign if the order was resized, or
+// zero and an error wrapping ErrRiskLimit naming the breach if the order is rejected
+func (r *RiskChecker) Check(asof time.Time, positions []Position, c *Contract, qty float64) (float64, error) {
+	return r.check(asof, r.Exposures(asof, positions), c, qty)
+}
+
+func (r *RiskChecker) check(asof time.Time, exposures map[RiskBucket]Greeks, c *Contract, qty float64) (float64, error) {
+	if qty == 0 {
+		return 0, nil
+	}
+	unit := r.unitGreeks(asof, c)
+	frac, breach := 1.0, ""
+	for _, b := range riskBuckets(c) {
+		limit, ok := r.Limits[b]
+		if !ok {
+			continue
+		}
+		e := exposures[b]
+		for _, l := range []struct {
+			name             string
+			limit, exp, unit float64
+		}{
+			{"delta", limit.Delta, e.Delta, unit.Delta},
+			{"gamma", limit.Gamma, e.Gamma, unit.Gamma},
+			{"vega", limit.Vega, e.Vega, unit.Vega},
+		} {
+			if f := limitFraction(l.limit, l.exp, l.unit*qty); f < frac {
+				frac = f
+				breach = fmt.Sprintf("%s %s %.4g + %.4g over limit %.4g", b, l.name, l.exp, l.unit*qty, l.limit)
+			}
+		}
+	}
+	if frac >= 1 {
+		return qty, nil
+	}
+	if frac <= 0 || r.RejectOnly {
+		return 0, fmt.Errorf("%w: %s %v of %s: %s", ErrRiskLimit, AmountToSide(qty), math.Abs(qty), c.Name(), breach)
+	}
+	return qty * frac, nil
+}
+
+// limitFraction returns the largest fraction in [0, 1] of a change d to an exposure keeping it within limit, or
+// no further beyond it than it is already
+func limitFraction(limit, exposure, d float64) float64 {
+	if limit <= 0 || d == 0 || math.IsNaN(d) {
+		return 1
+	}
+	lo, hi := math.Min(-limit, exposure), math.Max(limit, exposure)
+	var f float64
+	if d > 0 {
+		f = (hi - exposure) / d
+	} else {
+		f = (lo - exposure) / d
+	}
+	return math.Max(0, math.Min(1, f))
+}
+
+// LimitQuote resizes the levels of a quote so that each side, if filled down to any level, stays within the
+// limits. Levels beyond the limits are dropped
+func (r *RiskChecker) LimitQuote(asof time.Time, positions []Position, q Quote) Quote {
+	exposures := r.Exposures(asof, positions)
+	limitSide := func(orders []Order, sign float64) []Order {
+		var res []Order
+		var cum float64
+		for _, o := range orders {
+			allowed, err := r.check(asof, exposures, q.Contract, sign*(cum+o.Amount))
+			size := math.Abs(allowed) - cum
+			if err != nil || size <= 0 {
+				break
+			}
+			res = append(res, Order{Price: o.Price, Amount: size})
+			cum += size
+			if size < o.Amount {
+				break
+			}
+		}
+		return res
+	}
+	q.Bids = limitSide(q.Bids, 1)
+	q.Asks = limitSide(q.Asks, -1)
+	return q
+}
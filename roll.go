@@ -0,0 +1,112 @@
+package bean
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// RollTrade closes a position on an expiring contract and opens the same quantity on the next expiry. Prices are
+// the worst levels hit in the books (NaN without a book), Cost the value lost to the spreads of both legs in RHS coin
+// value spot (NaN if a book is missing)
+type RollTrade struct {
+	From       *Contract
+	To         *Contract
+	Qty        float64 // signed quantity of the position rolled
+	ClosePrice float64
+	OpenPrice  float64
+	Cost       float64
+	// RollYield is the annualised carry earned by holding the rolled position, ln(F2/F1) over the time between the
+	// expiries for shorts and its negative for longs, from the futures curve. NaN for options
+	RollYield float64
+}
+
+// RollPlan is the set of roll trades proposed for the positions expiring soon
+type RollPlan struct {
+	Asof      time.Time
+	Trades    []RollTrade
+	TotalCost float64 // NaN if the cost of a trade is unknown
+}
+
+// NewRollPlan proposes rolling the futures and options of positions expiring within days of asof to the next
+// expiry: futures to the next future of the curve, options to the same type at the nearest strike of the next
+// expiry of the chain (the chain may be nil for futures only portfolios). Books are keyed by contract name and
+// spots by underlying. Positions with no later contract to roll to are left out
+func NewRollPlan(asof time.Time, positions []Position, days float64, curve *FuturesCurve, ch *OptionChain,
+	books map[string]OrderBook, spots map[Pair]float64) RollPlan {
+	plan := RollPlan{Asof: asof}
+	for _, p := range positions {
+		if p.Perp() || p.Index() || p.qty == 0 || p.ExpiryDays(asof) > days || !p.Expiry().After(asof) {
+			continue
+		}
+		var to *Contract
+		if p.IsOption() {
+			to = rollOption(p.Contract, ch)
+		} else {
+			to = rollFuture(p.Contract, curve)
+		}
+		if to == nil {
+			continue
+		}
+		t := RollTrade{From: p.Contract, To: to, Qty: p.qty, ClosePrice: math.NaN(), OpenPrice: math.NaN(),
+			Cost: math.NaN(), RollYield: math.NaN()}
+		from, fok := books[p.Name()]
+		next, nok := books[to.Name()]
+		if fok && from.OrderBookCore != nil {
+			t.ClosePrice = p.ClosePrice(from, LiquidationValuation)
+		}
+		// opening qty costs as much as closing -qty
+		open := NewPosition(to, -p.qty, 1)
+		if nok && next.OrderBookCore != nil {
+			t.OpenPrice = open.ClosePrice(next, LiquidationValuation)
+		}
+		if fok && nok && from.OrderBookCore != nil && next.OrderBookCore != nil {
+			spot := spots[p.Underlying()]
+			t.Cost = NewPosition(p.Contract, p.qty, 1).LiquidityCost(spot, from) + open.LiquidityCost(spot, next)
+		}
+		if !p.IsOption() && curve != nil {
+			f1, ok1 := curve.Price(p.Contract)
+			f2, ok2 := curve.Price(to)
+			dt := to.Expiry().Sub(p.Expiry()).Hours() / 24.0 / 365.0
+			if ok1 && ok2 && dt > 0 {
+				t.RollYield = -math.Copysign(math.Log(f2/f1)/dt, p.qty)
+			}
+		}
+		plan.Trades = append(plan.Trades, t)
+		plan.TotalCost += t.Cost
+	}
+	return plan
+}
+
+func rollFuture(c *Contract, curve *FuturesCurve) *Contract {
+	if curve == nil {
+		return nil
+	}
+	for _, f := range curve.Futures() {
+		if f.Expiry().After(c.Expiry()) {
+			return f
+		}
+	}
+	return nil
+}
+
+func rollOption(c *Contract, ch *OptionChain) *Contract {
+	if ch == nil {
+		return nil
+	}
+	expiries := ch.Expiries()
+	i := sort.Search(len(expiries), func(i int) bool { return expiries[i].After(c.Expiry()) })
+	if i == len(expiries) {
+		return nil
+	}
+	var best *Contract
+	for _, q := range ch.Expiry(expiries[i]) {
+		if q.Contract.CallPut() != c.CallPut() {
+			continue
+		}
+		if best == nil || math.Abs(q.Contract.Strike()-c.Strike()) < math.Abs(best.Strike()-c.Strike()) {
+			best = q.Contract
+		}
+	}
+	return best
+}
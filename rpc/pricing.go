@@ -0,0 +1,80 @@
+package bean
+
+import (
+	"bean/server"
+	"fmt"
+	"net/rpc"
+)
+
+//////////////////////////////////////////////////////////////
+// the RPC pricing daemon, serving the pricing and the live books of a server.Server
+// to the RPC clients as the HTTP routes of the server do
+type RPCPricingD struct {
+	srv *server.Server
+}
+
+func NewRPCPricingD(srv *server.Server) *RPCPricingD {
+	return &RPCPricingD{srv}
+}
+
+// Register adds the pricing methods to an RPC server, e.g. rpc.DefaultServer before rpc.HandleHTTP
+func (d *RPCPricingD) Register(s *rpc.Server) error {
+	return s.RegisterName("RPCPricingD", d)
+}
+
+func (d *RPCPricingD) Price(req server.PricingRequest, res *server.PricingResponse) (err error) {
+	*res, err = d.srv.Price(req)
+	return
+}
+
+func (d *RPCPricingD) Instruments(_ struct{}, res *[]string) error {
+	*res = d.srv.Instruments()
+	return nil
+}
+
+func (d *RPCPricingD) Book(instrument string, res *server.BookResponse) error {
+	var ok bool
+	if *res, ok = d.srv.Book(instrument); !ok {
+		return fmt.Errorf("unknown instrument %s", instrument)
+	}
+	return nil
+}
+
+//////////////////////////////////////////////////////////////
+// the RPC pricing client instance
+type RPCPricingC struct {
+	client *rpc.Client
+}
+
+func NewRPCPricingC(network, address string) (RPCPricingC, error) {
+	client, err := rpc.DialHTTP(network, address)
+	if err != nil {
+		return RPCPricingC{}, err
+	}
+	return RPCPricingC{client}, nil
+}
+
+// Price prices an option at the requested vol, or at the vol implied by the requested price if it is set
+func (c RPCPricingC) Price(req server.PricingRequest) (server.PricingResponse, error) {
+	var res server.PricingResponse
+	err := c.client.Call("RPCPricingD.Price", req, &res)
+	return res, err
+}
+
+// Instruments returns the names of the books served in order
+func (c RPCPricingC) Instruments() ([]string, error) {
+	var res []string
+	err := c.client.Call("RPCPricingD.Instruments", struct{}{}, &res)
+	return res, err
+}
+
+// Book returns a snapshot of the book of an instrument
+func (c RPCPricingC) Book(instrument string) (server.BookResponse, error) {
+	var res server.BookResponse
+	err := c.client.Call("RPCPricingD.Book", instrument, &res)
+	return res, err
+}
+
+func (c RPCPricingC) Close() error {
+	return c.client.Close()
+}
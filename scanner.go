@@ -0,0 +1,109 @@
+package bean
+
+import (
+	"math"
+	"sort"
+)
+
+// ScanResult is the screening of one option of a chain against a benchmark vol. Vols are annualised, the spread
+// is relative to the mid. VolPremium is the mid implied vol over the benchmark; CheapBy and RichBy measure the
+// edge at executable prices: benchmark less ask vol and bid vol less benchmark
+type ScanResult struct {
+	Contract     *Contract
+	MidVol       float64
+	BidVol       float64
+	AskVol       float64
+	Benchmark    float64
+	VolPremium   float64
+	CheapBy      float64
+	RichBy       float64
+	Spread       float64
+	OpenInterest float64
+}
+
+// Scanner screens an option chain for options that are cheap or rich against a benchmark vol surface, either a
+// fitted surface or a FlatVol at realized vol. Options with spreads wider than MaxSpread or open interest below
+// MinOpenInterest are skipped
+type Scanner struct {
+	Benchmark       VolSurface
+	MaxSpread       float64 // relative to mid, zero for no limit
+	MinOpenInterest float64
+}
+
+func NewScanner(benchmark VolSurface, maxSpread, minOpenInterest float64) *Scanner {
+	return &Scanner{Benchmark: benchmark, MaxSpread: maxSpread, MinOpenInterest: minOpenInterest}
+}
+
+// Scan returns the results of the options passing the filters, ordered by vol premium (cheapest first).
+// Options quoted one-sided only have NaN vols on the missing side
+func (s *Scanner) Scan(ch *OptionChain) []ScanResult {
+	var res []ScanResult
+	for _, q := range ch.Quotes {
+		mid := q.Mid()
+		if math.IsNaN(mid) || mid <= 0 {
+			continue
+		}
+		spread := q.Spread() / mid
+		if s.MaxSpread > 0 && (math.IsNaN(spread) || spread > s.MaxSpread) {
+			continue
+		}
+		if s.MinOpenInterest > 0 && !(q.OpenInterest >= s.MinOpenInterest) {
+			continue
+		}
+		c := q.Contract
+		forward := ch.Forward(c.Expiry())
+		r := ScanResult{
+			Contract:     c,
+			MidVol:       s.vol(ch, c, forward, mid),
+			BidVol:       s.vol(ch, c, forward, q.BestBid),
+			AskVol:       s.vol(ch, c, forward, q.BestAsk),
+			Benchmark:    s.Benchmark.Vol(c.Expiry(), c.Strike(), forward),
+			Spread:       spread,
+			OpenInterest: q.OpenInterest,
+		}
+		if math.IsNaN(r.MidVol) {
+			continue
+		}
+		r.VolPremium = r.MidVol - r.Benchmark
+		r.CheapBy = r.Benchmark - r.AskVol
+		r.RichBy = r.BidVol - r.Benchmark
+		res = append(res, r)
+	}
+	sort.SliceStable(res, func(i, j int) bool { return res[i].VolPremium < res[j].VolPremium })
+	return res
+}
+
+func (s *Scanner) vol(ch *OptionChain, c *Contract, forward, price float64) float64 {
+	if math.IsNaN(price) || price <= 0 {
+		return math.NaN()
+	}
+	vol, err := c.ImpVol(ch.Asof, ch.Spot, forward, price)
+	if err != nil {
+		return math.NaN()
+	}
+	return vol
+}
+
+// Cheap returns the options that can be bought below the benchmark vol, best first
+func (s *Scanner) Cheap(ch *OptionChain) []ScanResult {
+	var res []ScanResult
+	for _, r := range s.Scan(ch) {
+		if r.CheapBy > 0 {
+			res = append(res, r)
+		}
+	}
+	sort.SliceStable(res, func(i, j int) bool { return res[i].CheapBy > res[j].CheapBy })
+	return res
+}
+
+// Rich returns the options that can be sold above the benchmark vol, best first
+func (s *Scanner) Rich(ch *OptionChain) []ScanResult {
+	var res []ScanResult
+	for _, r := range s.Scan(ch) {
+		if r.RichBy > 0 {
+			res = append(res, r)
+		}
+	}
+	sort.SliceStable(res, func(i, j int) bool { return res[i].RichBy > res[j].RichBy })
+	return res
+}
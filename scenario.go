@@ -0,0 +1,146 @@
+package bean
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Scenario is a stress of the market over a horizon, applied to the underlyings listed or to all of them
+type Scenario struct {
+	Name        string
+	Description string
+	Spot        float64       // relative move of spot and futures, -0.3 for a 30% fall
+	Vol         float64       // absolute move of implied vols, 0.4 for 40 vol points
+	Basis       float64       // relative change of the basis ln(F/S) of futures, -1 for futures collapsing to spot
+	Funding     float64       // funding rate paid by perpetual longs per FundingInterval over the horizon
+	Horizon     time.Duration // time passing, for the decay of options and the funding paid
+	Underlyings []Pair        // shocked underlyings, all if empty
+}
+
+// minScenarioVol floors the shocked vols so that vol crushes keep options priceable
+const minScenarioVol = 0.01
+
+// Shocks returns whether a scenario moves an underlying
+func (s Scenario) Shocks(p Pair) bool {
+	if len(s.Underlyings) == 0 {
+		return true
+	}
+	for _, u := range s.Underlyings {
+		if u == p {
+			return true
+		}
+	}
+	return false
+}
+
+// Market returns the market parameters of the positions after the shock
+func (s Scenario) Market(mkt PositionMarket) PositionMarket {
+	return func(p Position) (float64, float64, float64) {
+		spot, fut, vol := mkt(p)
+		if !s.Shocks(p.Underlying()) {
+			return spot, fut, vol
+		}
+		shocked := spot * (1 + s.Spot)
+		fut = shocked * math.Exp(math.Log(fut/spot)*(1+s.Basis))
+		if p.IsOption() {
+			vol = math.Max(vol+s.Vol, minScenarioVol)
+		}
+		return shocked, fut, vol
+	}
+}
+
+// ScenarioResult is the PnL of positions in a scenario in RHS coin spot value, including the funding paid, with
+// the PnL of each position in the order of the positions
+type ScenarioResult struct {
+	Scenario  string
+	PnL       float64
+	Funding   float64 // part of the PnL paid as funding
+	Positions []float64
+}
+
+// PnL revalues positions in the scenario as of asof plus its horizon
+func (s Scenario) PnL(asof time.Time, positions []Position, mkt PositionMarket) ScenarioResult {
+	res := ScenarioResult{Scenario: s.Name, Positions: make([]float64, len(positions))}
+	shocked := s.Market(mkt)
+	later := asof.Add(s.Horizon)
+	periods := float64(s.Horizon) / float64(FundingInterval)
+	for i, p := range positions {
+		spot, fut, vol := mkt(p)
+		base := p.PV(asof, spot, fut, vol)
+		spot, fut, vol = shocked(p)
+		pnl := p.PV(later, spot, fut, vol) - base
+		if p.Perp() && s.Shocks(p.Underlying()) {
+			funding := p.notional() * s.Funding * periods * spot / fut
+			pnl -= funding
+			res.Funding -= funding
+		}
+		res.Positions[i] = pnl
+		res.PnL += pnl
+	}
+	return res
+}
+
+// Stress returns the PnL of the positions in each scenario, the registered ones if none are given
+func (p *portfolio) Stress(asof time.Time, mkt PositionMarket, scenarios ...Scenario) []ScenarioResult {
+	if len(scenarios) == 0 {
+		scenarios = Scenarios()
+	}
+	res := make([]ScenarioResult, len(scenarios))
+	for i, s := range scenarios {
+		res[i] = s.PnL(asof, p.positions, mkt)
+	}
+	return res
+}
+
+var (
+	scenariosLock sync.RWMutex
+	scenarios     = make(map[string]Scenario)
+)
+
+// Preset scenarios, registered at start so that risk reports share the same stresses
+var presetScenarios = []Scenario{
+	{Name: "crash-1d", Description: "one day crash: spot -30%, vols +40 points", Spot: -0.3, Vol: 0.4, Horizon: 24 * time.Hour},
+	{Name: "black-thursday", Description: "12 March 2020: spot -39%, vols +80 points, futures to spot, shorts paid",
+		Spot: -0.39, Vol: 0.8, Basis: -1, Funding: -0.003, Horizon: 24 * time.Hour},
+	{Name: "rally-1d", Description: "one day rally: spot +20%, vols +20 points", Spot: 0.2, Vol: 0.2, Horizon: 24 * time.Hour},
+	{Name: "vol-spike", Description: "vols +30 points with spot -10% in a day", Spot: -0.1, Vol: 0.3, Horizon: 24 * time.Hour},
+	{Name: "vol-crush", Description: "vols -20 points over a week", Vol: -0.2, Horizon: 7 * 24 * time.Hour},
+	{Name: "funding-spike", Description: "funding at 0.3% every 8 hours for 3 days", Funding: 0.003, Horizon: 72 * time.Hour},
+	{Name: "basis-collapse", Description: "futures converge to spot in a day", Basis: -1, Horizon: 24 * time.Hour},
+	{Name: "basis-blowout", Description: "futures basis doubles in a day", Basis: 1, Horizon: 24 * time.Hour},
+}
+
+func init() {
+	for _, s := range presetScenarios {
+		RegisterScenario(s)
+	}
+}
+
+// RegisterScenario adds a scenario to the library, replacing the one of the same name
+func RegisterScenario(s Scenario) {
+	scenariosLock.Lock()
+	defer scenariosLock.Unlock()
+	scenarios[s.Name] = s
+}
+
+// ScenarioByName returns a registered scenario
+func ScenarioByName(name string) (Scenario, bool) {
+	scenariosLock.RLock()
+	defer scenariosLock.RUnlock()
+	s, ok := scenarios[name]
+	return s, ok
+}
+
+// Scenarios returns the registered scenarios sorted by name
+func Scenarios() []Scenario {
+	scenariosLock.RLock()
+	defer scenariosLock.RUnlock()
+	res := make([]Scenario, 0, len(scenarios))
+	for _, s := range scenarios {
+		res = append(res, s)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Name < res[j].Name })
+	return res
+}
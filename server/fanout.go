@@ -0,0 +1,414 @@
+package server
+
+import (
+	"bean"
+	"bean/event"
+	"bean/internal/ws"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Topics of the fan-out messages are the kind of data and the instrument, as book.BTC-PERPETUAL. Subscriptions
+// may end with * to match a prefix, as book.* or *
+const (
+	TopicBook   = "book"
+	TopicTrade  = "trade"
+	TopicTicker = "ticker"
+)
+
+// Topic returns the topic of a kind of data of an instrument
+func Topic(kind, instrument string) string {
+	return kind + "." + instrument
+}
+
+// Request is sent by fan-out clients to change their subscriptions, Op being subscribe or unsubscribe
+type Request struct {
+	Op     string   `json:"op"`
+	Topics []string `json:"topics"`
+}
+
+// Message is a normalized market data update sent to fan-out clients. Only the fields of its kind are set.
+// Replies to requests have an Op, the topics subscribed after it or an Error
+type Message struct {
+	Topic      string            `json:"topic,omitempty"`
+	Instrument string            `json:"instrument,omitempty"`
+	Time       time.Time         `json:"time"`
+	Bids       []bean.Order      `json:"bids,omitempty"`
+	Asks       []bean.Order      `json:"asks,omitempty"`
+	Trade      *bean.Transaction `json:"trade,omitempty"`
+	Ticker     *Ticker           `json:"ticker,omitempty"`
+
+	Op     string   `json:"op,omitempty"`
+	Topics []string `json:"topics,omitempty"`
+	Error  string   `json:"error,omitempty"`
+}
+
+// Float is a float64 written as null in JSON when it is NaN or infinite
+type Float float64
+
+func (f Float) MarshalJSON() ([]byte, error) {
+	if math.IsNaN(float64(f)) || math.IsInf(float64(f), 0) {
+		return []byte("null"), nil
+	}
+	return json.Marshal(float64(f))
+}
+
+func (f *Float) UnmarshalJSON(b []byte) error {
+	if string(b) == "null" {
+		*f = Float(math.NaN())
+		return nil
+	}
+	return json.Unmarshal(b, (*float64)(f))
+}
+
+// Ticker is a bean.ContractTicker on the wire, prices not known being null
+type Ticker struct {
+	BestBid       Float `json:"bestBid"`
+	BestAsk       Float `json:"bestAsk"`
+	BestBidAmount Float `json:"bestBidAmount"`
+	BestAskAmount Float `json:"bestAskAmount"`
+	LastPrice     Float `json:"lastPrice"`
+	MarkPrice     Float `json:"markPrice"`
+	IndexPrice    Float `json:"indexPrice"`
+	OpenInterest  Float `json:"openInterest"`
+	Volume24H     Float `json:"volume24h"`
+}
+
+// Fanout rebroadcasts normalized books, trades and tickers over websocket to the clients subscribed to their
+// topics, so several local processes share one upstream exchange connection. Each client has a buffer of
+// Buffer messages, a client too slow to keep up is disconnected and its message counted as MetricFanoutDropped,
+// so it resubscribes rather than silently missing updates. Clients are pinged every PingInterval.
+// Feed it with OnBook, OnTrade and OnTicker from a connector or with Run from an event.Source, and mount it as the
+// handler of a websocket path such as /v1/stream. It is safe for concurrent use
+type Fanout struct {
+	Buffer       int           // messages queued per client
+	Depth        int           // levels of the books sent, zero for all
+	PingInterval time.Duration // keepalive of the clients, zero for none
+
+	m       sync.RWMutex
+	clients map[*fanoutClient]struct{}
+}
+
+type fanoutClient struct {
+	conn    *ws.Conn
+	send    chan []byte
+	done    chan struct{} // closed when the client disconnects
+	stopped chan struct{} // closed when the writer stops
+	drop    sync.Once
+
+	m      sync.RWMutex
+	topics map[string]struct{}
+}
+
+// NewFanout returns a fan-out without clients, pinging them every 30 seconds
+func NewFanout(buffer, depth int) *Fanout {
+	return &Fanout{Buffer: buffer, Depth: depth, PingInterval: 30 * time.Second,
+		clients: make(map[*fanoutClient]struct{})}
+}
+
+// Clients returns the number of connected clients
+func (f *Fanout) Clients() int {
+	f.m.RLock()
+	defer f.m.RUnlock()
+	return len(f.clients)
+}
+
+// OnBook sends a book snapshot to the subscribers of its topic
+func (f *Fanout) OnBook(instrument string, ob bean.OrderBookT) {
+	msg := Message{Topic: Topic(TopicBook, instrument), Instrument: instrument, Time: ob.Time}
+	if ob.OrderBookCore != nil {
+		msg.Bids, msg.Asks = ob.Bids(), ob.Asks()
+		if f.Depth > 0 && len(msg.Bids) > f.Depth {
+			msg.Bids = msg.Bids[:f.Depth]
+		}
+		if f.Depth > 0 && len(msg.Asks) > f.Depth {
+			msg.Asks = msg.Asks[:f.Depth]
+		}
+	}
+	f.Publish(msg)
+}
+
+// OnTrade sends a market trade to the subscribers of its topic
+func (f *Fanout) OnTrade(instrument string, txn bean.Transaction) {
+	f.Publish(Message{Topic: Topic(TopicTrade, instrument), Instrument: instrument, Time: txn.TimeStamp, Trade: &txn})
+}
+
+// OnTicker sends a ticker to the subscribers of the topic of its contract
+func (f *Fanout) OnTicker(t bean.ContractTicker) {
+	instrument := t.Contract.Name()
+	f.Publish(Message{Topic: Topic(TopicTicker, instrument), Instrument: instrument, Time: t.Time, Ticker: &Ticker{
+		BestBid: Float(t.BestBid), BestAsk: Float(t.BestAsk), BestBidAmount: Float(t.BestBidAmount),
+		BestAskAmount: Float(t.BestAskAmount), LastPrice: Float(t.LastPrice), MarkPrice: Float(t.MarkPrice),
+		IndexPrice: Float(t.IndexPrice), OpenInterest: Float(t.OpenInterest), Volume24H: Float(t.Volume24H),
+	}})
+}
+
+// Run sends the books and trades of a source until it is exhausted or the context is done
+func (f *Fanout) Run(ctx context.Context, src event.Source) {
+	for e := range src.Events(ctx) {
+		switch e.Kind {
+		case event.BookEvent:
+			f.OnBook(e.Instrument, e.Book)
+		case event.TradeEvent:
+			f.OnTrade(e.Instrument, e.Trade)
+		}
+	}
+}
+
+// Publish sends a message to the clients subscribed to its topic, encoding it once
+func (f *Fanout) Publish(msg Message) {
+	var b []byte
+	f.m.RLock()
+	defer f.m.RUnlock()
+	for c := range f.clients {
+		if !c.subscribed(msg.Topic) {
+			continue
+		}
+		if b == nil {
+			var err error
+			if b, err = json.Marshal(msg); err != nil {
+				bean.Log().Warnf("fanout: cannot encode %s: %v", msg.Topic, err)
+				return
+			}
+		}
+		select {
+		case c.send <- b:
+		default:
+			bean.DefaultMetrics().Counter(bean.MetricFanoutDropped).Inc()
+			c.disconnect()
+		}
+	}
+}
+
+// ServeHTTP upgrades a request to a websocket and serves the client until it disconnects. Clients send Requests
+// to subscribe to topics and receive the Messages of their topics
+func (f *Fanout) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	conn, err := ws.Upgrade(w, r)
+	if err != nil {
+		return
+	}
+	if f.PingInterval > 0 {
+		conn.KeepAlive(f.PingInterval)
+	}
+	buffer := f.Buffer
+	if buffer < 1 {
+		buffer = 1
+	}
+	c := &fanoutClient{conn: conn, send: make(chan []byte, buffer), done: make(chan struct{}),
+		stopped: make(chan struct{}), topics: make(map[string]struct{})}
+	f.m.Lock()
+	f.clients[c] = struct{}{}
+	f.m.Unlock()
+	defer func() {
+		f.m.Lock()
+		delete(f.clients, c)
+		f.m.Unlock()
+		close(c.done)
+		conn.Close()
+	}()
+	go c.write()
+	for {
+		b, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		var req Request
+		reply := Message{Time: time.Now()}
+		if err := json.Unmarshal(b, &req); err != nil {
+			reply.Op, reply.Error = "error", err.Error()
+		} else {
+			reply.Op = req.Op
+			reply.Topics, err = c.update(req)
+			if err != nil {
+				reply.Error = err.Error()
+			}
+		}
+		if b, err = json.Marshal(reply); err == nil {
+			select {
+			case c.send <- b:
+			case <-c.stopped:
+				return
+			}
+		}
+	}
+}
+
+// disconnect drops a client that cannot keep up, its reads failing end ServeHTTP
+func (c *fanoutClient) disconnect() {
+	c.drop.Do(func() {
+		bean.Log().Warnf("fanout: dropping a slow client")
+		c.conn.Abort()
+	})
+}
+
+// write sends the queued messages until the client is done, the writes failing after the write timeout of the
+// connection when the client stops reading
+func (c *fanoutClient) write() {
+	defer close(c.stopped)
+	for {
+		select {
+		case b := <-c.send:
+			if err := c.conn.WriteText(b); err != nil {
+				c.disconnect()
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// update applies a subscription request and returns the topics subscribed, sorted
+func (c *fanoutClient) update(req Request) ([]string, error) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	switch req.Op {
+	case "subscribe":
+		for _, t := range req.Topics {
+			c.topics[t] = struct{}{}
+		}
+	case "unsubscribe":
+		for _, t := range req.Topics {
+			delete(c.topics, t)
+		}
+	default:
+		return nil, fmt.Errorf("unknown op %q", req.Op)
+	}
+	res := make([]string, 0, len(c.topics))
+	for t := range c.topics {
+		res = append(res, t)
+	}
+	sort.Strings(res)
+	return res, nil
+}
+
+func (c *fanoutClient) subscribed(topic string) bool {
+	c.m.RLock()
+	defer c.m.RUnlock()
+	if _, ok := c.topics[topic]; ok {
+		return true
+	}
+	for t := range c.topics {
+		if strings.HasSuffix(t, "*") && strings.HasPrefix(topic, t[:len(t)-1]) {
+			return true
+		}
+	}
+	return false
+}
+
+// FanoutClient receives the messages of a Fanout server, for a strategy process sharing its upstream connection
+type FanoutClient struct {
+	conn     *ws.Conn
+	messages chan Message
+	err      error
+}
+
+// DialFanout connects to the websocket url of a Fanout and subscribes to topics
+func DialFanout(ctx context.Context, url string, topics ...string) (*FanoutClient, error) {
+	conn, err := ws.Dial(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	c := &FanoutClient{conn: conn, messages: make(chan Message, 256)}
+	if len(topics) > 0 {
+		if err := c.Subscribe(topics...); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	go c.read()
+	return c, nil
+}
+
+func (c *FanoutClient) request(op string, topics []string) error {
+	b, _ := json.Marshal(Request{Op: op, Topics: topics})
+	return c.conn.WriteText(b)
+}
+
+// Subscribe adds topics to the subscriptions, the server confirming with a Message of Op subscribe
+func (c *FanoutClient) Subscribe(topics ...string) error {
+	return c.request("subscribe", topics)
+}
+
+// Unsubscribe removes topics from the subscriptions
+func (c *FanoutClient) Unsubscribe(topics ...string) error {
+	return c.request("unsubscribe", topics)
+}
+
+func (c *FanoutClient) read() {
+	defer close(c.messages)
+	for {
+		b, err := c.conn.ReadMessage()
+		if err != nil {
+			c.err = err
+			return
+		}
+		var msg Message
+		if err := json.Unmarshal(b, &msg); err != nil {
+			bean.Log().Warnf("fanout client: bad message: %v", err)
+			continue
+		}
+		c.messages <- msg
+	}
+}
+
+// Err returns the error that ended the connection once Messages is closed, io.EOF if the server closed it
+func (c *FanoutClient) Err() error {
+	return c.err
+}
+
+// Messages returns the messages received, closed when the connection is. Read either these or Events
+func (c *FanoutClient) Messages() <-chan Message {
+	return c.messages
+}
+
+// Events converts the books and trades received into events, so strategies run on the shared feed with an
+// event.Runner. Closes the connection when the context is done
+func (c *FanoutClient) Events(ctx context.Context) <-chan event.Event {
+	out := make(chan event.Event)
+	go func() {
+		defer close(out)
+		defer c.Close()
+		for {
+			var msg Message
+			var ok bool
+			select {
+			case msg, ok = <-c.messages:
+				if !ok {
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+			e := event.Event{Time: msg.Time, Instrument: msg.Instrument}
+			switch {
+			case strings.HasPrefix(msg.Topic, TopicBook+"."):
+				e.Kind = event.BookEvent
+				e.Book = bean.OrderBookT{OrderBook: bean.NewOrderBook(msg.Bids, msg.Asks), Time: msg.Time}
+			case strings.HasPrefix(msg.Topic, TopicTrade+".") && msg.Trade != nil:
+				e.Kind = event.TradeEvent
+				e.Trade = *msg.Trade
+			default:
+				continue
+			}
+			select {
+			case out <- e:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// Close closes the connection
+func (c *FanoutClient) Close() error {
+	return c.conn.Close()
+}
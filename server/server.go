@@ -0,0 +1,197 @@
+// Package server exposes bean's option pricing and live order books over HTTP with JSON bodies, for clients
+// that are not written in Go, and fans normalized market data out over websocket to local processes. Go clients
+// query the same Server over net/rpc with the RPCPricingD service of the rpc package. Only the standard library is
+// used, gRPC is not vendored
+package server
+
+import (
+	"bean"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PricingRequest is the body of the pricing endpoints. Asof defaults to now, Price is the option price in LHS coin
+// and is only used to solve for the implied vol
+type PricingRequest struct {
+	Instrument string    `json:"instrument"`
+	Asof       time.Time `json:"asof"`
+	Spot       float64   `json:"spot"`
+	Future     float64   `json:"future"`
+	Vol        float64   `json:"vol"`
+	Price      float64   `json:"price,omitempty"`
+}
+
+// PricingResponse holds the price in both coins, the vol used and the greeks of one unit of the instrument
+type PricingResponse struct {
+	Instrument string  `json:"instrument"`
+	Price      float64 `json:"price"`     // RHS coin
+	PriceCoin  float64 `json:"priceCoin"` // LHS coin
+	Vol        float64 `json:"vol"`
+	Delta      float64 `json:"delta"`
+	Gamma      float64 `json:"gamma"`
+	Vega       float64 `json:"vega"`
+	Theta      float64 `json:"theta"`
+}
+
+// BookResponse is an order book snapshot
+type BookResponse struct {
+	Instrument string       `json:"instrument"`
+	Bids       []bean.Order `json:"bids"`
+	Asks       []bean.Order `json:"asks"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// Server answers pricing queries and serves snapshots of the books registered with SetBook
+type Server struct {
+	m     sync.RWMutex
+	books map[string]*bean.OrderBookCOW
+	now   func() time.Time
+}
+
+// New returns a server without books
+func New() *Server {
+	return &Server{books: make(map[string]*bean.OrderBookCOW), now: time.Now}
+}
+
+// SetBook registers the live book of an instrument. The feed keeps updating it, requests read snapshots
+func (s *Server) SetBook(instrument string, ob *bean.OrderBookCOW) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.books[instrument] = ob
+}
+
+// Instruments returns the names of the registered books in order
+func (s *Server) Instruments() []string {
+	s.m.RLock()
+	defer s.m.RUnlock()
+	res := make([]string, 0, len(s.books))
+	for name := range s.books {
+		res = append(res, name)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// Book returns a snapshot of the book of an instrument
+func (s *Server) Book(instrument string) (BookResponse, bool) {
+	s.m.RLock()
+	ob, ok := s.books[instrument]
+	s.m.RUnlock()
+	if !ok {
+		return BookResponse{}, false
+	}
+	snap := ob.Snapshot()
+	return BookResponse{Instrument: instrument, Bids: snap.Bids(), Asks: snap.Asks()}, true
+}
+
+// Price prices an option at the requested vol, or at the vol implied by the requested price if it is set
+func (s *Server) Price(req PricingRequest) (res PricingResponse, err error) {
+	c, err := bean.ContractFromName(req.Instrument)
+	if err != nil {
+		return
+	}
+	asof := req.Asof
+	if asof.IsZero() {
+		asof = s.now()
+	}
+	vol := req.Vol
+	if req.Price > 0 {
+		if vol, err = c.ImpVol(asof, req.Spot, req.Future, req.Price); err != nil {
+			return
+		}
+	}
+	price, err := c.OptPrice(asof, req.Spot, req.Future, vol)
+	if err != nil {
+		return
+	}
+	g := bean.NewPosition(c, 1, 0).Greeks(asof, req.Spot, req.Future, vol)
+	return PricingResponse{
+		Instrument: c.Name(),
+		Price:      price,
+		PriceCoin:  price / req.Spot,
+		Vol:        vol,
+		Delta:      g.Delta,
+		Gamma:      g.Gamma,
+		Vega:       g.Vega,
+		Theta:      g.Theta,
+	}, nil
+}
+
+// Handler returns the HTTP routes of the server:
+//
+//	POST /v1/price          PricingRequest -> PricingResponse
+//	GET  /v1/books          list of instruments
+//	GET  /v1/books/{name}   BookResponse
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/price", s.handlePrice)
+	mux.HandleFunc("/v1/books", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, s.Instruments())
+	})
+	mux.HandleFunc("/v1/books/", s.handleBook)
+	return mux
+}
+
+func (s *Server) handlePrice(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSON(w, http.StatusMethodNotAllowed, errorResponse{"use POST"})
+		return
+	}
+	var req PricingRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{err.Error()})
+		return
+	}
+	res, err := s.Price(req)
+	if err != nil {
+		status := http.StatusUnprocessableEntity
+		if errors.Is(err, bean.ErrInvalidInput) {
+			status = http.StatusBadRequest
+		}
+		writeJSON(w, status, errorResponse{err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (s *Server) handleBook(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/v1/books/")
+	res, ok := s.Book(name)
+	if !ok {
+		writeJSON(w, http.StatusNotFound, errorResponse{"unknown instrument " + name})
+		return
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+// writeJSON encodes a response, with NaN (e.g. greeks of expired contracts) written as null
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	if res, ok := v.(PricingResponse); ok {
+		v = nanToNull(res)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func nanToNull(res PricingResponse) map[string]interface{} {
+	out := map[string]interface{}{"instrument": res.Instrument}
+	for k, x := range map[string]float64{"price": res.Price, "priceCoin": res.PriceCoin, "vol": res.Vol,
+		"delta": res.Delta, "gamma": res.Gamma, "vega": res.Vega, "theta": res.Theta} {
+		if math.IsNaN(x) || math.IsInf(x, 0) {
+			out[k] = nil
+		} else {
+			out[k] = x
+		}
+	}
+	return out
+}
@@ -0,0 +1,171 @@
+package bean
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// SmileMetrics summarizes the smile of an expiry of a vol surface: the at the money forward vol, the 25 delta risk
+// reversal (call vol less put vol) and butterfly (average wing vol less the at the money vol)
+type SmileMetrics struct {
+	Time    time.Time
+	Expiry  time.Time
+	Forward float64
+	ATM     float64
+	Call25  float64 // vol of the 25 delta call
+	Put25   float64 // vol of the -25 delta put
+	RR25    float64
+	BF25    float64
+}
+
+// DeltaStrike returns the strike of an option of forward delta delta on a surface, between 0 and 1 for calls and
+// -1 and 0 for puts, and its vol. The strike is solved by fixed point as the vol depends on it
+func DeltaStrike(vs VolSurface, asof, expiry time.Time, forward, delta float64) (strike, vol float64) {
+	years := expiry.Sub(asof).Hours() / 24 / 365
+	if !(years > 0) || !validPrice(forward) || delta == 0 || math.Abs(delta) >= 1 {
+		return math.NaN(), math.NaN()
+	}
+	// N(d1) of the call of the same strike
+	if delta < 0 {
+		delta += 1
+	}
+	z := math.Sqrt2 * math.Erfinv(2*delta-1)
+	strike = forward
+	for i := 0; i < 50; i++ {
+		vol = vs.Vol(expiry, strike, forward)
+		if !(vol > 0) {
+			return math.NaN(), math.NaN()
+		}
+		sd := vol * math.Sqrt(years)
+		next := forward * math.Exp(sd*sd/2-sd*z)
+		if math.Abs(next-strike) < 1e-9*forward {
+			return next, vs.Vol(expiry, next, forward)
+		}
+		strike = next
+	}
+	return strike, vs.Vol(expiry, strike, forward)
+}
+
+// SkewMetrics returns the smile metrics of an expiry of a surface. Fails with ErrInvalidInput for an expiry not
+// after asof or a surface without vols at the strikes
+func SkewMetrics(vs VolSurface, asof, expiry time.Time, forward float64) (SmileMetrics, error) {
+	m := SmileMetrics{Time: asof, Expiry: expiry, Forward: forward}
+	if !expiry.After(asof) || !validPrice(forward) {
+		return m, fmt.Errorf("%w: no smile expiring %s at forward %v", ErrInvalidInput, expiry.Format(time.RFC3339),
+			forward)
+	}
+	m.ATM = vs.Vol(expiry, forward, forward)
+	_, m.Call25 = DeltaStrike(vs, asof, expiry, forward, 0.25)
+	_, m.Put25 = DeltaStrike(vs, asof, expiry, forward, -0.25)
+	if !(m.ATM > 0) || !(m.Call25 > 0) || !(m.Put25 > 0) {
+		return m, fmt.Errorf("%w: no vols expiring %s", ErrInvalidInput, expiry.Format(time.RFC3339))
+	}
+	m.RR25 = m.Call25 - m.Put25
+	m.BF25 = (m.Call25+m.Put25)/2 - m.ATM
+	return m, nil
+}
+
+// SmileMetricsTS is a time series of smile metrics
+type SmileMetricsTS []SmileMetrics
+
+func (ts SmileMetricsTS) series(f func(SmileMetrics) float64) TimeSeries {
+	res := make(TimeSeries, len(ts))
+	for i, m := range ts {
+		res[i] = TimePoint{Time: m.Time, Value: f(m)}
+	}
+	return res
+}
+
+// ATMs returns the series of at the money vols
+func (ts SmileMetricsTS) ATMs() TimeSeries {
+	return ts.series(func(m SmileMetrics) float64 { return m.ATM })
+}
+
+// RiskReversals returns the series of 25 delta risk reversals
+func (ts SmileMetricsTS) RiskReversals() TimeSeries {
+	return ts.series(func(m SmileMetrics) float64 { return m.RR25 })
+}
+
+// Butterflies returns the series of 25 delta butterflies
+func (ts SmileMetricsTS) Butterflies() TimeSeries {
+	return ts.series(func(m SmileMetrics) float64 { return m.BF25 })
+}
+
+// SkewRichness is how rich the latest smile metrics of a tenor are against their history, in standard deviations
+type SkewRichness struct {
+	Tenor time.Duration
+	ATM   float64
+	RR25  float64
+	BF25  float64
+}
+
+// SkewTracker keeps the smile metrics of constant tenors, the skew and term structure of a surface over time. It
+// is safe for concurrent use
+type SkewTracker struct {
+	Tenors []time.Duration
+
+	m      sync.Mutex
+	series map[time.Duration]SmileMetricsTS
+}
+
+// NewSkewTracker returns a tracker of the tenors, e.g. 7, 30 and 90 days
+func NewSkewTracker(tenors ...time.Duration) *SkewTracker {
+	tenors = append([]time.Duration(nil), tenors...)
+	sort.Slice(tenors, func(i, j int) bool { return tenors[i] < tenors[j] })
+	return &SkewTracker{Tenors: tenors, series: make(map[time.Duration]SmileMetricsTS)}
+}
+
+// Update records the smile metrics of each tenor of a surface as of asof, the forwards interpolated on the
+// curve. Returns the first failure, the other tenors are still recorded
+func (t *SkewTracker) Update(vs VolSurface, asof time.Time, curve *FuturesCurve) error {
+	var err error
+	t.m.Lock()
+	defer t.m.Unlock()
+	for _, tenor := range t.Tenors {
+		expiry := asof.Add(tenor)
+		m, e := SkewMetrics(vs, asof, expiry, curve.Forward(expiry))
+		if e != nil {
+			if err == nil {
+				err = e
+			}
+			continue
+		}
+		t.series[tenor] = append(t.series[tenor], m)
+	}
+	return err
+}
+
+// Metrics returns a copy of the smile metrics recorded for a tenor, in time order
+func (t *SkewTracker) Metrics(tenor time.Duration) SmileMetricsTS {
+	t.m.Lock()
+	defer t.m.Unlock()
+	return append(SmileMetricsTS(nil), t.series[tenor]...)
+}
+
+// TermStructure returns the latest at the money vol of each tenor, NaN for tenors not recorded
+func (t *SkewTracker) TermStructure() []float64 {
+	t.m.Lock()
+	defer t.m.Unlock()
+	res := make([]float64, len(t.Tenors))
+	for i, tenor := range t.Tenors {
+		res[i] = math.NaN()
+		if ts := t.series[tenor]; len(ts) > 0 {
+			res[i] = ts[len(ts)-1].ATM
+		}
+	}
+	return res
+}
+
+// Richness returns the z-scores of the latest smile metrics of a tenor over a window of records
+func (t *SkewTracker) Richness(tenor time.Duration, window int) SkewRichness {
+	ts := t.Metrics(tenor)
+	return SkewRichness{
+		Tenor: tenor,
+		ATM:   ts.ATMs().ZScore(window),
+		RR25:  ts.RiskReversals().ZScore(window),
+		BF25:  ts.Butterflies().ZScore(window),
+	}
+}
@@ -0,0 +1,234 @@
+package bean
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// RoundToTick returns price rounded to the nearest multiple of tick, unchanged if tick is not positive
+func RoundToTick(price, tick float64) float64 {
+	if !(tick > 0) {
+		return price
+	}
+	return cleanMultiple(math.Round(price/tick), tick)
+}
+
+// RoundDownToTick returns price rounded down to a multiple of tick, for bids
+func RoundDownToTick(price, tick float64) float64 {
+	if !(tick > 0) {
+		return price
+	}
+	return cleanMultiple(math.Floor(price/tick+1e-9), tick)
+}
+
+// RoundUpToTick returns price rounded up to a multiple of tick, for asks
+func RoundUpToTick(price, tick float64) float64 {
+	if !(tick > 0) {
+		return price
+	}
+	return cleanMultiple(math.Ceil(price/tick-1e-9), tick)
+}
+
+// RoundToLot returns size rounded towards zero to a multiple of lot, so an order is never increased
+func RoundToLot(size, lot float64) float64 {
+	if !(lot > 0) {
+		return size
+	}
+	return cleanMultiple(math.Trunc(size/lot+math.Copysign(1e-9, size)), lot)
+}
+
+// cleanMultiple returns n*step with the decimals of step, so 3*0.1 is 0.3 rather than 0.30000000000000004
+func cleanMultiple(n, step float64) float64 {
+	v := n * step
+	decimals := 0
+	if s := strconv.FormatFloat(step, 'f', -1, 64); strings.IndexByte(s, '.') >= 0 {
+		decimals = len(s) - strings.IndexByte(s, '.') - 1
+	}
+	r, err := strconv.ParseFloat(strconv.FormatFloat(v, 'f', decimals, 64), 64)
+	if err != nil {
+		return v
+	}
+	return r
+}
+
+// onMultiple is true if x is a multiple of step within rounding errors
+func onMultiple(x, step float64) bool {
+	if !(step > 0) {
+		return true
+	}
+	n := x / step
+	return math.Abs(n-math.Round(n)) < 1e-6
+}
+
+// TickStep is the tick size of prices at or above a level, for instruments with price dependent ticks
+type TickStep struct {
+	Above float64
+	Tick  float64
+}
+
+// InstrumentSpec is the trading rules of an instrument in the units of bean orders: prices as quoted in the
+// book and amounts in contracts
+type InstrumentSpec struct {
+	TickSize    float64    // price increment, zero for none
+	TickSteps   []TickStep // larger ticks above price levels, in increasing order of Above
+	LotSize     float64    // amount increment, zero for none
+	MinAmount   float64    // smallest absolute amount, zero for no minimum
+	MaxAmount   float64    // largest absolute amount, zero for no maximum
+	MinNotional float64    // smallest price times absolute amount, zero for no minimum
+	Multiplier  float64    // USD value of a future or perpetual contract, zero for the default. Options are in coin
+}
+
+// Tick returns the tick size at a price
+func (s InstrumentSpec) Tick(price float64) float64 {
+	tick := s.TickSize
+	for _, step := range s.TickSteps {
+		if price >= step.Above {
+			tick = step.Tick
+		}
+	}
+	return tick
+}
+
+// RoundPrice rounds a price to the tick, down for buys and up for sells so the order is never more aggressive
+func (s InstrumentSpec) RoundPrice(price, amount float64) float64 {
+	if amount < 0 {
+		return RoundUpToTick(price, s.Tick(price))
+	}
+	return RoundDownToTick(price, s.Tick(price))
+}
+
+// RoundAmount rounds an amount towards zero to the lot size
+func (s InstrumentSpec) RoundAmount(amount float64) float64 {
+	return RoundToLot(amount, s.LotSize)
+}
+
+// Validate returns an error wrapping ErrInvalidOrder if a limit order at price for amount (positive to buy) would
+// be rejected by the exchange
+func (s InstrumentSpec) Validate(price, amount float64) error {
+	size := math.Abs(amount)
+	switch {
+	case !validPrice(price):
+		return fmt.Errorf("%w: price %v", ErrInvalidOrder, price)
+	case amount == 0 || math.IsNaN(amount) || math.IsInf(amount, 0):
+		return fmt.Errorf("%w: amount %v", ErrInvalidOrder, amount)
+	case !onMultiple(price, s.Tick(price)):
+		return fmt.Errorf("%w: price %v not a multiple of tick %v", ErrInvalidOrder, price, s.Tick(price))
+	case !onMultiple(size, s.LotSize):
+		return fmt.Errorf("%w: amount %v not a multiple of lot %v", ErrInvalidOrder, amount, s.LotSize)
+	case s.MinAmount > 0 && size < s.MinAmount*(1-1e-9):
+		return fmt.Errorf("%w: amount %v below minimum %v", ErrInvalidOrder, amount, s.MinAmount)
+	case s.MaxAmount > 0 && size > s.MaxAmount*(1+1e-9):
+		return fmt.Errorf("%w: amount %v above maximum %v", ErrInvalidOrder, amount, s.MaxAmount)
+	case s.MinNotional > 0 && price*size < s.MinNotional*(1-1e-9):
+		return fmt.Errorf("%w: notional %v below minimum %v", ErrInvalidOrder, price*size, s.MinNotional)
+	}
+	return nil
+}
+
+// DeribitSpec returns the usual deribit rules of a contract: futures in 10 USD contracts with a 0.5 USD tick on
+// BTC and 1 USD contracts with a 0.05 tick on ETH, options in lots of 0.1 BTC or 1 ETH quoted in coin with a
+// 0.0005 tick, 0.0001 below 0.005
+func DeribitSpec(c *Contract) InstrumentSpec {
+	if c.IsOption() {
+		lot := 0.1
+		if c.Underlying().Coin == ETH {
+			lot = 1
+		}
+		return InstrumentSpec{TickSize: 0.0001, TickSteps: []TickStep{{Above: 0.005, Tick: 0.0005}}, LotSize: lot, MinAmount: lot}
+	}
+	tick, multiplier := 0.5, 10.0
+	if c.Underlying().Coin == ETH {
+		tick, multiplier = 0.05, 1.0
+	}
+	return InstrumentSpec{TickSize: tick, LotSize: 1, MinAmount: 1, Multiplier: multiplier}
+}
+
+var (
+	specsLock sync.RWMutex
+	specs     = make(map[string]InstrumentSpec)
+)
+
+// SetInstrumentSpec registers the spec of an instrument, giving its contract multiplier and the rules orders are
+// validated against by default
+func SetInstrumentSpec(instrument string, s InstrumentSpec) {
+	specsLock.Lock()
+	defer specsLock.Unlock()
+	specs[instrument] = s
+}
+
+// InstrumentSpecOf returns the spec registered for a contract, DeribitSpec if there is none
+func InstrumentSpecOf(c *Contract) InstrumentSpec {
+	specsLock.RLock()
+	s, ok := specs[c.Name()]
+	specsLock.RUnlock()
+	if ok {
+		return s
+	}
+	return DeribitSpec(c)
+}
+
+// Multiplier returns the USD value of one future or perpetual contract from the spec of the contract, used to value
+// positions, margins and fills. Options are one as their amounts are in coin
+func (c *Contract) Multiplier() float64 {
+	if c.IsOption() {
+		return 1
+	}
+	if m := InstrumentSpecOf(c).Multiplier; m > 0 {
+		return m
+	}
+	return DeribitSpec(c).Multiplier
+}
+
+// OrderValidator checks orders against the spec of their instrument before they are sent. It is safe for
+// concurrent use
+type OrderValidator struct {
+	m     sync.RWMutex
+	specs map[string]InstrumentSpec
+	// Default returns the spec of instruments without one set, nil to reject them
+	Default func(c *Contract) InstrumentSpec
+}
+
+// NewOrderValidator returns a validator falling back to the registered specs, see InstrumentSpecOf
+func NewOrderValidator() *OrderValidator {
+	return &OrderValidator{specs: make(map[string]InstrumentSpec), Default: InstrumentSpecOf}
+}
+
+// SetSpec sets the spec of an instrument
+func (v *OrderValidator) SetSpec(instrument string, s InstrumentSpec) {
+	v.m.Lock()
+	defer v.m.Unlock()
+	v.specs[instrument] = s
+}
+
+// Spec returns the spec of an instrument
+func (v *OrderValidator) Spec(instrument string) (InstrumentSpec, error) {
+	v.m.RLock()
+	s, ok := v.specs[instrument]
+	v.m.RUnlock()
+	if ok {
+		return s, nil
+	}
+	if v.Default == nil {
+		return s, fmt.Errorf("%w: no spec for %s", ErrInvalidOrder, instrument)
+	}
+	c, err := ContractFromName(instrument)
+	if err != nil {
+		return s, err
+	}
+	return v.Default(c), nil
+}
+
+// Validate checks a limit order on an instrument, see InstrumentSpec.Validate
+func (v *OrderValidator) Validate(instrument string, price, amount float64) error {
+	s, err := v.Spec(instrument)
+	if err != nil {
+		return err
+	}
+	if err := s.Validate(price, amount); err != nil {
+		return contractError(instrument, err)
+	}
+	return nil
+}
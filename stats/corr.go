@@ -0,0 +1,114 @@
+package stats
+
+import (
+	"bean"
+	"math"
+	"sort"
+)
+
+// CovMatrix is a covariance matrix of the returns of coins, indexed in the order of Coins
+type CovMatrix struct {
+	Coins bean.Coins
+	Cov   [][]float64
+}
+
+// LogReturns returns the period to period log returns of a price timeseries
+func LogReturns(ts bean.TimeSeries) []float64 {
+	if len(ts) < 2 {
+		return nil
+	}
+	res := make([]float64, len(ts)-1)
+	for i := 1; i < len(ts); i++ {
+		res[i-1] = math.Log(ts[i].Value / ts[i-1].Value)
+	}
+	return res
+}
+
+// Covariance returns the covariance matrix of return series aligned in time, over their common (latest) length.
+// lambda in (0, 1) weights the returns exponentially (EWMA, e.g. 0.94) with the latest return weighted most,
+// lambda = 0 weights them equally
+func Covariance(returns map[bean.Coin][]float64, lambda float64) CovMatrix {
+	var coins bean.Coins
+	n := -1
+	for c, rets := range returns {
+		coins = append(coins, c)
+		if n < 0 || len(rets) < n {
+			n = len(rets)
+		}
+	}
+	sort.Slice(coins, func(i, j int) bool { return coins[i] < coins[j] })
+	aligned := make([][]float64, len(coins))
+	for i, c := range coins {
+		rets := returns[c]
+		aligned[i] = rets[len(rets)-n:]
+	}
+	return CovMatrix{Coins: coins, Cov: bean.Covariance(aligned, lambda)}
+}
+
+// RollingCovariance returns the covariance matrices over a moving window of returns, one per period from the
+// window-th return onwards
+func RollingCovariance(returns map[bean.Coin][]float64, window int, lambda float64) []CovMatrix {
+	n := -1
+	for _, rets := range returns {
+		if n < 0 || len(rets) < n {
+			n = len(rets)
+		}
+	}
+	var res []CovMatrix
+	for end := window; end <= n; end++ {
+		w := make(map[bean.Coin][]float64, len(returns))
+		for c, rets := range returns {
+			off := len(rets) - n
+			w[c] = rets[off+end-window : off+end]
+		}
+		res = append(res, Covariance(w, lambda))
+	}
+	return res
+}
+
+// Index returns the position of a coin in the matrix, -1 if not present
+func (m CovMatrix) Index(c bean.Coin) int {
+	for i, coin := range m.Coins {
+		if coin == c {
+			return i
+		}
+	}
+	return -1
+}
+
+// Get returns the covariance between the returns of two coins, NaN if either is not in the matrix
+func (m CovMatrix) Get(c1, c2 bean.Coin) float64 {
+	i, j := m.Index(c1), m.Index(c2)
+	if i < 0 || j < 0 {
+		return math.NaN()
+	}
+	return m.Cov[i][j]
+}
+
+// Vol returns the standard deviation of the returns of a coin
+func (m CovMatrix) Vol(c bean.Coin) float64 {
+	return math.Sqrt(m.Get(c, c))
+}
+
+// Correlation returns the correlation matrix
+func (m CovMatrix) Correlation() [][]float64 {
+	corr := make([][]float64, len(m.Cov))
+	for i := range m.Cov {
+		corr[i] = make([]float64, len(m.Cov))
+		for j := range m.Cov {
+			corr[i][j] = m.Cov[i][j] / math.Sqrt(m.Cov[i][i]*m.Cov[j][j])
+		}
+	}
+	return corr
+}
+
+// Corr returns the correlation between the returns of two coins
+func (m CovMatrix) Corr(c1, c2 bean.Coin) float64 {
+	return m.Get(c1, c2) / (m.Vol(c1) * m.Vol(c2))
+}
+
+// HedgeRatio returns the amount of hedge coin that minimises the variance of one unit of coin:
+// cov(coin, hedge)/var(hedge), in value terms
+func (m CovMatrix) HedgeRatio(coin, hedge bean.Coin) float64 {
+	return m.Get(coin, hedge) / m.Get(hedge, hedge)
+}
@@ -0,0 +1,151 @@
+package stats
+
+import (
+	"bean"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// FlowWindow holds the order flow features of one trailing window
+type FlowWindow struct {
+	OFI             float64 // order flow imbalance of the top of book, in book amounts
+	SignedVolume    float64 // buyer initiated minus seller initiated traded amount
+	Volume          float64 // traded amount
+	BidDepletion    float64 // decrease of the best bid queue at an unchanged price, per second
+	AskDepletion    float64 // decrease of the best ask queue at an unchanged price, per second
+	TradeIntensity  float64 // trades per second
+	UpdateIntensity float64 // book updates per second
+}
+
+func (w FlowWindow) values() []float64 {
+	return []float64{w.OFI, w.SignedVolume, w.Volume, w.BidDepletion, w.AskDepletion, w.TradeIntensity, w.UpdateIntensity}
+}
+
+var flowNames = []string{"ofi", "signed_volume", "volume", "bid_depletion", "ask_depletion", "trade_intensity", "update_intensity"}
+
+// FlowFeatures are the features at a sample time over each of the configured windows
+type FlowFeatures struct {
+	Time    time.Time
+	Windows []FlowWindow
+}
+
+// Vector returns the features window after window, in the order of FlowFeatureNames
+func (f FlowFeatures) Vector() []float64 {
+	res := make([]float64, 0, len(f.Windows)*len(flowNames))
+	for _, w := range f.Windows {
+		res = append(res, w.values()...)
+	}
+	return res
+}
+
+// FlowFeatureNames returns the column names of the vectors, suffixed by their window such as ofi_1m0s
+func FlowFeatureNames(windows []time.Duration) []string {
+	var res []string
+	for _, w := range windows {
+		for _, n := range flowNames {
+			res = append(res, fmt.Sprintf("%s_%s", n, w))
+		}
+	}
+	return res
+}
+
+// flowEvent is the contribution of one book update or trade to the features
+type flowEvent struct {
+	t                   time.Time
+	ofi, bidDep, askDep float64
+	signed, volume      float64
+	trade, update       float64
+}
+
+// OrderFlowFeatures samples the order flow features every step over trailing windows, from the first step after
+// the first event to the last event. A window at t covers the events after t-window up to t included. Books and
+// trades must be sorted by time, trades with a buyer maker are seller initiated
+func OrderFlowFeatures(books bean.OrderBookTS, trades bean.Transactions, step time.Duration, windows ...time.Duration) []FlowFeatures {
+	var events []flowEvent
+	for i := 1; i < len(books); i++ {
+		prev, cur := &books[i-1], &books[i]
+		if prev.OrderBookCore == nil || cur.OrderBookCore == nil {
+			continue
+		}
+		pb, pa, b, a := prev.BestBid(), prev.BestAsk(), cur.BestBid(), cur.BestAsk()
+		e := flowEvent{t: cur.Time, update: 1}
+		e.ofi = ofi(pb, pa, b, a)
+		if b.Price == pb.Price && b.Amount < pb.Amount {
+			e.bidDep = pb.Amount - b.Amount
+		}
+		if a.Price == pa.Price && a.Amount < pa.Amount {
+			e.askDep = pa.Amount - a.Amount
+		}
+		events = append(events, e)
+	}
+	for _, t := range trades {
+		amount := math.Abs(t.Amount)
+		signed := amount
+		if t.Maker == bean.Buyer {
+			signed = -amount
+		}
+		events = append(events, flowEvent{t: t.TimeStamp, signed: signed, volume: amount, trade: 1})
+	}
+	if len(events) == 0 || step <= 0 {
+		return nil
+	}
+	sort.SliceStable(events, func(i, j int) bool { return events[i].t.Before(events[j].t) })
+
+	// cumulative sums so each window is a difference
+	cum := make([]flowEvent, len(events)+1)
+	for i, e := range events {
+		c := cum[i]
+		cum[i+1] = flowEvent{t: e.t, ofi: c.ofi + e.ofi, bidDep: c.bidDep + e.bidDep, askDep: c.askDep + e.askDep,
+			signed: c.signed + e.signed, volume: c.volume + e.volume, trade: c.trade + e.trade, update: c.update + e.update}
+	}
+	// upTo returns the number of events at or before t
+	upTo := func(t time.Time) int {
+		return sort.Search(len(events), func(i int) bool { return events[i].t.After(t) })
+	}
+
+	var res []FlowFeatures
+	last := events[len(events)-1].t
+	for t := events[0].t.Truncate(step).Add(step); !t.After(last.Truncate(step).Add(step)); t = t.Add(step) {
+		f := FlowFeatures{Time: t, Windows: make([]FlowWindow, len(windows))}
+		hi := cum[upTo(t)]
+		for i, w := range windows {
+			lo := cum[upTo(t.Add(-w))]
+			secs := w.Seconds()
+			f.Windows[i] = FlowWindow{
+				OFI:             hi.ofi - lo.ofi,
+				SignedVolume:    hi.signed - lo.signed,
+				Volume:          hi.volume - lo.volume,
+				BidDepletion:    (hi.bidDep - lo.bidDep) / secs,
+				AskDepletion:    (hi.askDep - lo.askDep) / secs,
+				TradeIntensity:  (hi.trade - lo.trade) / secs,
+				UpdateIntensity: (hi.update - lo.update) / secs,
+			}
+		}
+		res = append(res, f)
+	}
+	return res
+}
+
+// ofi is the order flow imbalance of a top of book update (Cont, Kukanov and Stoikov): bid size added at or above
+// the previous best bid minus ask size added at or below the previous best ask
+func ofi(pb, pa, b, a bean.Order) float64 {
+	var e float64
+	if b.Price >= pb.Price {
+		e += b.Amount
+	}
+	if b.Price <= pb.Price {
+		e -= pb.Amount
+	}
+	if a.Price <= pa.Price {
+		e -= a.Amount
+	}
+	if a.Price >= pa.Price {
+		e += pa.Amount
+	}
+	if math.IsNaN(e) {
+		return 0
+	}
+	return e
+}
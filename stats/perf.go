@@ -0,0 +1,169 @@
+// Package stats computes performance and risk statistics from bean timeseries
+package stats
+
+import (
+	"bean"
+	"math"
+	"time"
+
+	"github.com/gonum/stat"
+)
+
+const year = 365 * 24 * time.Hour
+
+// Changes returns the period to period changes of a PnL or equity timeseries
+func Changes(ts bean.TimeSeries) []float64 {
+	if len(ts) < 2 {
+		return nil
+	}
+	res := make([]float64, len(ts)-1)
+	for i := 1; i < len(ts); i++ {
+		res[i-1] = ts[i].Value - ts[i-1].Value
+	}
+	return res
+}
+
+// PeriodsPerYear returns the number of sampling periods per year implied by the average interval of the timeseries
+func PeriodsPerYear(ts bean.TimeSeries) float64 {
+	if len(ts) < 2 {
+		return math.NaN()
+	}
+	interval := ts[len(ts)-1].Time.Sub(ts[0].Time) / time.Duration(len(ts)-1)
+	if interval <= 0 {
+		return math.NaN()
+	}
+	return float64(year) / float64(interval)
+}
+
+// Sharpe returns the annualised ratio of the mean to the sample standard deviation of the changes of the
+// timeseries, as TradestatPort.Sharpe does
+func Sharpe(ts bean.TimeSeries) float64 {
+	ch := Changes(ts)
+	if len(ch) < 2 {
+		return math.NaN()
+	}
+	m, sd := stat.MeanStdDev(ch, nil)
+	if sd == 0 {
+		return math.NaN()
+	}
+	return m / sd * math.Sqrt(PeriodsPerYear(ts))
+}
+
+// Sortino returns the annualised ratio of the mean to the downside deviation of the changes of the timeseries
+func Sortino(ts bean.TimeSeries) float64 {
+	ch := Changes(ts)
+	if len(ch) == 0 {
+		return math.NaN()
+	}
+	down := 0.0
+	for _, c := range ch {
+		if c < 0 {
+			down += c * c
+		}
+	}
+	if down == 0 {
+		return math.NaN()
+	}
+	return stat.Mean(ch, nil) / math.Sqrt(down/float64(len(ch))) * math.Sqrt(PeriodsPerYear(ts))
+}
+
+// MaxDrawdown returns the largest fall from a running peak of the timeseries, with the times of the peak and trough
+func MaxDrawdown(ts bean.TimeSeries) (drawdown float64, peak, trough time.Time) {
+	if len(ts) == 0 {
+		return
+	}
+	runningPeak := ts[0]
+	for _, p := range ts {
+		if p.Value > runningPeak.Value {
+			runningPeak = p
+		}
+		if runningPeak.Value-p.Value > drawdown {
+			drawdown = runningPeak.Value - p.Value
+			peak = runningPeak.Time
+			trough = p.Time
+		}
+	}
+	return
+}
+
+// HitRate returns the proportion of non-zero changes which are gains
+func HitRate(ts bean.TimeSeries) float64 {
+	wins, moves := 0, 0
+	for _, c := range Changes(ts) {
+		if c != 0 {
+			moves++
+			if c > 0 {
+				wins++
+			}
+		}
+	}
+	if moves == 0 {
+		return math.NaN()
+	}
+	return float64(wins) / float64(moves)
+}
+
+// Turnover returns the traded value of the trades in the quote coin
+func Turnover(trades bean.TradeLogS) float64 {
+	res := 0.0
+	for _, t := range trades {
+		res += math.Abs(t.Quantity * t.Price)
+	}
+	return res
+}
+
+// Exposure summarises a position timeseries: the proportion of time with a position, the time weighted average
+// absolute position and the largest absolute position
+func Exposure(positions bean.TimeSeries) (timeInMarket, avgAbs, maxAbs float64) {
+	if len(positions) < 2 {
+		if len(positions) == 1 {
+			maxAbs = math.Abs(positions[0].Value)
+		}
+		return
+	}
+	total := positions[len(positions)-1].Time.Sub(positions[0].Time).Seconds()
+	for i := 0; i < len(positions)-1; i++ {
+		dt := positions[i+1].Time.Sub(positions[i].Time).Seconds()
+		pos := math.Abs(positions[i].Value)
+		if pos != 0 {
+			timeInMarket += dt
+		}
+		avgAbs += pos * dt
+		maxAbs = math.Max(maxAbs, pos)
+	}
+	maxAbs = math.Max(maxAbs, math.Abs(positions[len(positions)-1].Value))
+	if total > 0 {
+		timeInMarket /= total
+		avgAbs /= total
+	}
+	return
+}
+
+// Performance gathers the statistics of a PnL or equity timeseries
+type Performance struct {
+	PnL          float64
+	Sharpe       float64
+	Sortino      float64
+	MaxDrawdown  float64
+	HitRate      float64
+	Turnover     float64
+	TimeInMarket float64
+	AvgExposure  float64
+	MaxExposure  float64
+}
+
+// Evaluate computes the performance statistics of a PnL timeseries, its trades and position timeseries.
+// trades and positions may be nil
+func Evaluate(pnl bean.TimeSeries, trades bean.TradeLogS, positions bean.TimeSeries) Performance {
+	var perf Performance
+	if len(pnl) > 0 {
+		perf.PnL = pnl[len(pnl)-1].Value - pnl[0].Value
+	}
+	perf.Sharpe = Sharpe(pnl)
+	perf.Sortino = Sortino(pnl)
+	perf.MaxDrawdown, _, _ = MaxDrawdown(pnl)
+	perf.HitRate = HitRate(pnl)
+	perf.Turnover = Turnover(trades)
+	perf.TimeInMarket, perf.AvgExposure, perf.MaxExposure = Exposure(positions)
+	return perf
+}
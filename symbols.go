@@ -0,0 +1,186 @@
+package bean
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SymbolMapper translates between the instrument symbols of an exchange and bean contracts.
+// Bean contract names follow the deribit convention, e.g. BTC-27JUN25-60000-C
+type SymbolMapper interface {
+	Symbol(c *Contract) (string, error)        // Symbol returns the exchange symbol of a contract
+	Contract(symbol string) (*Contract, error) // Contract parses an exchange symbol
+}
+
+// NewSymbolMapper returns the symbol mapper for an exchange
+func NewSymbolMapper(exName string) (SymbolMapper, error) {
+	switch strings.ToUpper(exName) {
+	case NameDeribit:
+		return deribitSymbols{}, nil
+	case NameOKX:
+		return okxSymbols{}, nil
+	case NameBinance:
+		return binanceSymbols{}, nil
+	default:
+		return nil, errors.New("no symbol mapping for exchange " + exName)
+	}
+}
+
+// ContractFromSymbol converts the symbol of an exchange into a contract
+func ContractFromSymbol(exName, symbol string) (*Contract, error) {
+	sm, err := NewSymbolMapper(exName)
+	if err != nil {
+		return nil, err
+	}
+	return sm.Contract(symbol)
+}
+
+// SymbolFromContract converts a contract into the symbol of an exchange
+func SymbolFromContract(exName string, c *Contract) (string, error) {
+	sm, err := NewSymbolMapper(exName)
+	if err != nil {
+		return "", err
+	}
+	return sm.Symbol(c)
+}
+
+// deribit symbols are the bean contract names
+type deribitSymbols struct{}
+
+func (deribitSymbols) Symbol(c *Contract) (string, error) {
+	return c.Name(), nil
+}
+
+func (deribitSymbols) Contract(symbol string) (*Contract, error) {
+	return ContractFromName(symbol)
+}
+
+// okx symbols: BTC-USD-250627-60000-C, BTC-USD-250627 and BTC-USD-SWAP
+type okxSymbols struct{}
+
+const yymmddFormat = "060102"
+
+func (okxSymbols) Symbol(c *Contract) (string, error) {
+	under := string(c.Underlying().Coin) + "-" + string(c.Underlying().Base)
+	switch {
+	case c.IsOption():
+		return under + "-" + c.Expiry().Format(yymmddFormat) + "-" + formatStrike(c.Strike()) + "-" + string(c.CallPut()), nil
+	case c.Perp():
+		return under + "-SWAP", nil
+	case c.IsFuture():
+		return under + "-" + c.Expiry().Format(yymmddFormat), nil
+	}
+	return "", contractError(c.Name(), ErrBadContractFormat)
+}
+
+func (okxSymbols) Contract(symbol string) (*Contract, error) {
+	st := strings.Split(strings.ToUpper(symbol), "-")
+	if len(st) != 3 && len(st) != 5 {
+		return nil, contractError(symbol, ErrBadContractFormat)
+	}
+	under, err := underlyingFromCoin(st[0])
+	if err != nil {
+		return nil, contractError(symbol, err)
+	}
+	if st[2] == "SWAP" {
+		return PerpContract(under), nil
+	}
+	return contractFromParts(symbol, ExchangeExpiryRule(NameOKX), under, st[2], st[3:])
+}
+
+// binance symbols: options BTC-250627-60000-C, coin margined futures BTCUSD_250627 and BTCUSD_PERP
+type binanceSymbols struct{}
+
+func (binanceSymbols) Symbol(c *Contract) (string, error) {
+	coin := string(c.Underlying().Coin)
+	switch {
+	case c.IsOption():
+		return coin + "-" + c.Expiry().Format(yymmddFormat) + "-" + formatStrike(c.Strike()) + "-" + string(c.CallPut()), nil
+	case c.Perp():
+		return coin + string(c.Underlying().Base) + "_PERP", nil
+	case c.IsFuture():
+		return coin + string(c.Underlying().Base) + "_" + c.Expiry().Format(yymmddFormat), nil
+	}
+	return "", contractError(c.Name(), ErrBadContractFormat)
+}
+
+func (binanceSymbols) Contract(symbol string) (*Contract, error) {
+	symbol = strings.ToUpper(symbol)
+	if st := strings.Split(symbol, "_"); len(st) == 2 {
+		if !strings.HasSuffix(st[0], string(USD)) {
+			return nil, contractError(symbol, ErrBadContractFormat)
+		}
+		under, err := underlyingFromCoin(strings.TrimSuffix(st[0], string(USD)))
+		if err != nil {
+			return nil, contractError(symbol, err)
+		}
+		if st[1] == "PERP" {
+			return PerpContract(under), nil
+		}
+		return contractFromParts(symbol, ExchangeExpiryRule(NameBinance), under, st[1], nil)
+	}
+	st := strings.Split(symbol, "-")
+	if len(st) != 4 {
+		return nil, contractError(symbol, ErrBadContractFormat)
+	}
+	under, err := underlyingFromCoin(st[0])
+	if err != nil {
+		return nil, contractError(symbol, err)
+	}
+	return contractFromParts(symbol, ExchangeExpiryRule(NameBinance), under, st[1], st[2:])
+}
+
+// contractFromParts builds a future (no option parts) or an option (strike and C/P) from a yymmdd expiry, expiring
+// at the time of day of rule
+func contractFromParts(symbol string, rule ExpiryRule, under Pair, yymmdd string, option []string) (*Contract, error) {
+	dt, err := time.Parse(yymmddFormat, yymmdd)
+	if err != nil {
+		return nil, contractError(symbol, ErrBadContractFormat)
+	}
+	expiry := rule.On(dt.Year(), dt.Month(), dt.Day())
+	if len(option) == 0 {
+		return FutContract(under, expiry), nil
+	}
+	strike, err := parseStrike(option[0])
+	if err != nil {
+		return nil, contractError(symbol, ErrBadContractFormat)
+	}
+	switch CallOrPut(option[1]) {
+	case Call, Put:
+		return OptContract(under, expiry, strike, CallOrPut(option[1])), nil
+	}
+	return nil, contractError(symbol, ErrBadContractFormat)
+}
+
+// underlyingFromCoin returns the USD pair of the coins with listed derivatives
+func underlyingFromCoin(coin string) (Pair, error) {
+	switch Coin(coin) {
+	case BTC, ETH, BCH:
+		return Pair{Coin(coin), USD}, nil
+	}
+	return Pair{}, ErrUnknownCoin
+}
+
+// formatStrike formats a strike with as many decimals as needed to parse back exactly
+func formatStrike(strike float64) string {
+	return strconv.FormatFloat(strike, 'f', -1, 64)
+}
+
+// parseStrike parses positive strikes such as 60000, 50.5, 0d5 (deribit decimal), 60k or 1.5K
+func parseStrike(s string) (float64, error) {
+	exp := ""
+	if n := len(s); n > 1 && (s[n-1] == 'k' || s[n-1] == 'K') {
+		s, exp = s[:n-1], "e3" // scale in decimal so 1.1k is exactly 1100
+	}
+	s = strings.Replace(strings.Replace(s, "d", ".", 1), "D", ".", 1)
+	if s == "" || strings.Trim(s, "0123456789.") != "" || strings.Count(s, ".") > 1 {
+		return 0, ErrBadContractFormat
+	}
+	strike, err := strconv.ParseFloat(s+exp, 64)
+	if err != nil || strike <= 0 {
+		return 0, ErrBadContractFormat
+	}
+	return strike, nil
+}
@@ -0,0 +1,84 @@
+package test
+
+import (
+	"testing"
+	"time"
+
+	"bean"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAlerts(t *testing.T) {
+	ob := bean.NewOrderBook([]bean.Order{{Price: 99, Amount: 1}}, []bean.Order{{Price: 100, Amount: 1}})
+	a := bean.NewAlerts()
+	a.Add(bean.Alert{Name: "wide", Condition: bean.SpreadAbove(&ob, 2), Debounce: 2 * time.Second, Rearm: time.Second})
+	var got []bean.AlertEvent
+	a.OnAlert(func(e bean.AlertEvent) { got = append(got, e) })
+	events := a.Events(10)
+
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(s int) time.Time { return t0.Add(time.Duration(s) * time.Second) }
+	a.Check(at(0))
+	ob.InsertAsk(bean.Order{Price: 98.5, Amount: 1})
+	ob.CancelAsk(bean.Order{Price: 98.5})
+	ob.CancelAsk(bean.Order{Price: 100})
+	ob.InsertAsk(bean.Order{Price: 105, Amount: 1})
+	a.Check(at(1))
+	a.Check(at(2))
+	assert.Empty(t, got, "debounced")
+	a.Check(at(3))
+	assert.Len(t, got, 1)
+	assert.Equal(t, bean.AlertFired, got[0].Kind)
+	assert.Equal(t, 6.0, got[0].Payload.Value)
+	assert.Equal(t, 2.0, got[0].Payload.Threshold)
+	assert.Equal(t, map[string]float64{"bid": 99, "ask": 105}, got[0].Payload.Data)
+	assert.Equal(t, at(1), got[0].Since)
+	assert.False(t, a.Armed("wide"))
+	a.Check(at(10))
+	assert.Len(t, got, 1, "fires once while the condition holds")
+
+	ob.CancelAsk(bean.Order{Price: 105})
+	ob.InsertAsk(bean.Order{Price: 100, Amount: 1})
+	a.Check(at(11))
+	assert.Len(t, got, 1)
+	a.Check(at(12))
+	assert.Len(t, got, 2)
+	assert.Equal(t, bean.AlertRearmed, got[1].Kind)
+	assert.True(t, a.Armed("wide"))
+	assert.Len(t, events, 2)
+
+	vol := 0.5
+	moved := bean.MovedBy(func() float64 { return vol }, 0.02)
+	_, ok := moved()
+	assert.False(t, ok)
+	vol = 0.53
+	p, ok := moved()
+	assert.True(t, ok)
+	assert.Equal(t, 0.53, p.Value)
+	assert.Equal(t, 0.5, p.Reference)
+	_, ok = moved()
+	assert.False(t, ok)
+
+	a.Remove("wide")
+	assert.False(t, a.Armed("wide"))
+
+	// a slow condition does not hold the alerts locked
+	evaluating, release := make(chan struct{}), make(chan struct{})
+	a.Add(bean.Alert{Name: "slow", Condition: func() (bean.AlertPayload, bool) {
+		close(evaluating)
+		<-release
+		return bean.AlertPayload{Value: 1}, true
+	}})
+	done := make(chan struct{})
+	go func() {
+		a.Check(at(20))
+		close(done)
+	}()
+	<-evaluating
+	assert.True(t, a.Armed("slow"))
+	a.Add(bean.Alert{Name: "other", Condition: bean.Above(func() float64 { return 0 }, 1)})
+	close(release)
+	<-done
+	assert.Len(t, got, 3)
+	assert.Equal(t, "slow", got[2].Alert)
+}
@@ -0,0 +1,109 @@
+package test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"bean"
+	"bean/arb"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestArbDetector(t *testing.T) {
+	now := time.Date(2019, 5, 1, 0, 0, 0, 0, time.UTC)
+	books := map[string]bean.OrderBookT{
+		"A": {OrderBook: bean.NewOrderBook(
+			[]bean.Order{{Price: 99, Amount: 1}},
+			[]bean.Order{{Price: 100, Amount: 1}, {Price: 100.5, Amount: 2}}), Time: now},
+		"B": {OrderBook: bean.NewOrderBook(
+			[]bean.Order{{Price: 101, Amount: 2}, {Price: 100.2, Amount: 5}},
+			[]bean.Order{{Price: 102, Amount: 1}}), Time: now},
+	}
+	d := arb.NewDetector(0.5, 0)
+	opps := d.Detect(books)
+	assert.Equal(t, 1, len(opps))
+	assert.Equal(t, "A", opps[0].BuyVenue)
+	assert.Equal(t, "B", opps[0].SellVenue)
+	assert.Equal(t, 2.0, opps[0].Size)
+	assert.Equal(t, 100.5, opps[0].BuyPrice)
+	assert.InDelta(t, 1.5, opps[0].Edge, 1e-9)
+
+	// 30bps taker fees on each leg leave only the first level
+	d.SetFee("A", bean.FeeRate{TakerBps: 30})
+	d.SetFee("B", bean.FeeRate{TakerBps: 30})
+	opps = d.Detect(books)
+	assert.Equal(t, 1, len(opps))
+	assert.Equal(t, 1.0, opps[0].Size)
+
+	d = arb.NewDetector(5, 0)
+	assert.Equal(t, 0, len(d.Detect(books)))
+}
+
+func TestParityArbs(t *testing.T) {
+	asof := time.Date(2019, 5, 1, 8, 0, 0, 0, time.UTC)
+	ch := testChain(asof, 5000, 0.8)
+	assert.Equal(t, 0, len(arb.ParityArbs(ch, bean.FeeRate{}, bean.FeeRate{})))
+
+	call, put, _ := ch.CallPut(ch.Expiries()[0], 5000)
+	put.BestBid = put.BestAsk + 0.01
+	ch.Add(put)
+	pkgs := arb.ParityArbs(ch, bean.FeeRate{}, bean.FeeRate{})
+	assert.True(t, len(pkgs) > 0)
+	assert.Equal(t, arb.Conversion, pkgs[0].Kind)
+	assert.Equal(t, call.Contract, pkgs[0].Legs[0].Contract)
+	assert.True(t, pkgs[0].Edge > 0 && pkgs[0].Edge < 0.01)
+
+	// fees larger than the mispricing remove it
+	assert.Equal(t, 0, len(arb.ParityArbs(ch, bean.FeeRate{TakerBps: 50}, bean.FeeRate{TakerBps: 5})))
+}
+
+func TestCarry(t *testing.T) {
+	asof := time.Date(2019, 3, 28, 8, 0, 0, 0, time.UTC)
+	spot := bean.NewOrderBook([]bean.Order{{Price: 4990, Amount: 10}}, []bean.Order{{Price: 5010, Amount: 10}})
+	fut := bean.NewOrderBook([]bean.Order{{Price: 5090, Amount: 1000}}, []bean.Order{{Price: 5110, Amount: 1000}})
+	jun, _ := bean.ContractFromName("BTC-28JUN19")
+	perp, _ := bean.ContractFromName("BTC-PERPETUAL")
+	p := arb.CarryParams{
+		Size:            1,
+		ContractSize:    10,
+		SpotFee:         bean.FeeRate{TakerBps: 10},
+		FuturesFee:      bean.FeeRate{TakerBps: 5, DeliveryBps: 2.5},
+		Horizon:         30 * 24 * time.Hour,
+		FundingLookback: 24 * time.Hour,
+		MinAnnualCarry:  0.1,
+	}
+	entry := 10.0/5000 + 10.0/5100 + 0.0015
+
+	c, err := p.Analyze(asof, jun, spot, fut, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 5010.0, c.SpotPrice)
+	assert.Equal(t, 5090.0, c.FuturesPrice)
+	assert.InDelta(t, 0.02, c.Basis, 1e-12)
+	assert.InDelta(t, entry, c.EntryCost, 1e-12)
+	assert.InDelta(t, 0.00125, c.ExitCost, 1e-12)
+	assert.InDelta(t, (0.02-entry-0.00125)*365/92, c.AnnualCarry, 1e-9)
+	assert.False(t, c.Signal)
+
+	funding := bean.TimeSeries{
+		{Time: asof.Add(-48 * time.Hour), Value: 0.01}, // outside the lookback
+		{Time: asof.Add(-16 * time.Hour), Value: 0.0001},
+		{Time: asof.Add(-8 * time.Hour), Value: 0.0003},
+		{Time: asof, Value: 0.0002},
+	}
+	c, err = p.Analyze(asof, perp, spot, fut, funding)
+	assert.NoError(t, err)
+	assert.InDelta(t, 0.0002, c.FundingRate, 1e-12)
+	assert.InDelta(t, 0.018, c.Funding, 1e-12)
+	assert.InDelta(t, entry, c.ExitCost, 1e-12)
+	assert.InDelta(t, (0.038-2*entry)*365/30, c.AnnualCarry, 1e-9)
+	assert.True(t, c.Signal)
+
+	all := p.ScanCarry(asof, spot, map[*bean.Contract]bean.OrderBook{jun: fut, perp: fut}, funding)
+	assert.Len(t, all, 2)
+	assert.Equal(t, perp, all[0].Contract)
+
+	p.Size = 20
+	_, err = p.Analyze(asof, jun, spot, fut, nil)
+	assert.True(t, errors.Is(err, bean.ErrNoLiquidity))
+}
@@ -0,0 +1,321 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"bean"
+	"bean/brew"
+	"bean/exchange"
+	"github.com/stretchr/testify/assert"
+)
+
+// buyOnce lifts the offer on the first book and sells it back on the timer
+type buyOnce struct {
+	bought, sold bool
+}
+
+func (s *buyOnce) OnBook(e *brew.Engine, ob bean.OrderBookT) {
+	if !s.bought {
+		e.PlaceOrder(ob.BestAsk().Price, 1.0)
+		s.bought = true
+	}
+}
+
+func (s *buyOnce) OnTrade(e *brew.Engine, txn bean.Transaction) {}
+
+func (s *buyOnce) OnTimer(e *brew.Engine, t time.Time) {
+	if s.bought && !s.sold {
+		e.PlaceOrder(0, -1.0)
+		s.sold = true
+	}
+}
+
+func TestEngine(t *testing.T) {
+	start := time.Date(2019, 5, 1, 0, 0, 0, 0, time.UTC)
+	var candles bean.OHLCVBSTS
+	for i := 0; i < 10; i++ {
+		candles = append(candles, bean.OHLCVBS{Close: 100 + float64(i), End: start.Add(time.Duration(i) * time.Minute)})
+	}
+	pair := bean.Pair{Coin: bean.BTC, Base: bean.USDT}
+	e := brew.NewEngineFromCandles(pair, candles, 0.001, 10, 5*time.Minute)
+	res := e.Run(&buyOnce{})
+	assert.Equal(t, 2, res.Trades)
+	assert.Equal(t, 0.0, e.Blotter().Position(pair))
+	assert.InDelta(t, 104*0.999-100*1.001, res.PnL[len(res.PnL)-1].Value, 1e-9)
+	assert.Equal(t, 10, len(res.PnL))
+}
+
+// lifter buys at a limit on the first book and cancels on the second if still open
+type lifter struct {
+	limit  float64
+	oid    string
+	books  int
+	cancel bool
+}
+
+func (s *lifter) OnBook(e *brew.Engine, ob bean.OrderBookT) {
+	s.books++
+	switch s.books {
+	case 1:
+		s.oid = e.PlaceOrder(s.limit, 1)
+	case 2:
+		if s.cancel {
+			e.CancelOrder(s.oid)
+		}
+	}
+}
+
+func (s *lifter) OnTrade(e *brew.Engine, txn bean.Transaction) {}
+func (s *lifter) OnTimer(e *brew.Engine, t time.Time)          {}
+
+func TestEngineLatencySlippage(t *testing.T) {
+	start := time.Date(2019, 5, 1, 0, 0, 0, 0, time.UTC)
+	var candles bean.OHLCVBSTS
+	for i := 0; i < 5; i++ {
+		candles = append(candles, bean.OHLCVBS{Close: 100 + float64(i), End: start.Add(time.Duration(i) * time.Minute)})
+	}
+	pair := bean.Pair{Coin: bean.BTC, Base: bean.USDT}
+	run := func(s *lifter, latency bean.LatencyModel, slippage bean.SlippageModel) bean.TradeLogS {
+		e := brew.NewEngineFromCandles(pair, candles, 0.001, 10, 0)
+		e.SetLatency(latency)
+		e.SetSlippage(slippage)
+		e.Run(s)
+		return e.Blotter().Trades()
+	}
+
+	trades := run(&lifter{limit: 110}, nil, nil)
+	if assert.Len(t, trades, 1) {
+		assert.InDelta(t, 100*1.001, trades[0].Price, 1e-9)
+		assert.Equal(t, start, trades[0].Time)
+	}
+	// the order reaches the book 90s later and lifts the offer of the second book
+	trades = run(&lifter{limit: 110}, bean.ConstantLatency(90*time.Second), nil)
+	if assert.Len(t, trades, 1) {
+		assert.InDelta(t, 101*1.001, trades[0].Price, 1e-9)
+		assert.Equal(t, start.Add(90*time.Second), trades[0].Time)
+	}
+	// a cancel sent on the second book arrives after the order and is too late
+	trades = run(&lifter{limit: 110, cancel: true}, bean.ConstantLatency(90*time.Second), nil)
+	assert.Len(t, trades, 1)
+	// an order resting below the offers is cancelled once its cancel arrives
+	trades = run(&lifter{limit: 100, cancel: true}, bean.ConstantLatency(30*time.Second), nil)
+	assert.Empty(t, trades)
+
+	trades = run(&lifter{limit: 110}, nil, bean.FixedSlippage(10))
+	if assert.Len(t, trades, 1) {
+		assert.InDelta(t, 100*1.001*1.001, trades[0].Price, 1e-9)
+	}
+	trades = run(&lifter{limit: 100.15}, nil, bean.SquareRootImpact(0.1))
+	if assert.Len(t, trades, 1) {
+		assert.Equal(t, 100.15, trades[0].Price, "capped at the limit")
+	}
+	assert.InDelta(t, 100*(1-0.1*0.5), bean.SquareRootImpact(0.1).Slip(bean.OrderBookT{OrderBook: bean.NewOrderBook(
+		[]bean.Order{{Price: 99, Amount: 4}}, []bean.Order{{Price: 101, Amount: 4}})}, 100, -1), 1e-9)
+
+	l := bean.NewUniformLatency(time.Millisecond, 5*time.Millisecond, 1)
+	for i := 0; i < 100; i++ {
+		d := l.Delay()
+		assert.True(t, d >= time.Millisecond && d <= 5*time.Millisecond)
+	}
+	assert.True(t, bean.NewLogNormalLatency(time.Millisecond, 0.5, 1).Delay() > 0)
+}
+
+// joiner joins the best bid on the first book
+type joiner struct {
+	oid   string
+	ahead []float64
+}
+
+func (s *joiner) OnBook(e *brew.Engine, ob bean.OrderBookT) {
+	if s.oid == "" {
+		s.oid = e.PlaceOrder(ob.BestBid().Price, 2)
+	}
+	ahead, _ := e.QueuePosition(s.oid)
+	s.ahead = append(s.ahead, ahead)
+}
+
+func (s *joiner) OnTrade(e *brew.Engine, txn bean.Transaction) {
+	ahead, _ := e.QueuePosition(s.oid)
+	s.ahead = append(s.ahead, ahead)
+}
+
+func (s *joiner) OnTimer(e *brew.Engine, t time.Time) {}
+
+func TestEngineQueuePosition(t *testing.T) {
+	t0 := time.Date(2019, 5, 1, 0, 0, 0, 0, time.UTC)
+	at := func(s int) time.Time { return t0.Add(time.Duration(s) * time.Second) }
+	book := func(s int, bidSize float64) bean.OrderBookT {
+		return bean.OrderBookT{OrderBook: bean.NewOrderBook([]bean.Order{{Price: 99, Amount: bidSize}},
+			[]bean.Order{{Price: 101, Amount: 5}}), Time: at(s)}
+	}
+	books := bean.OrderBookTS{book(0, 10), book(2, 5)} // cancels ahead
+	txns := bean.Transactions{
+		{Price: 99, Amount: 4, TimeStamp: at(1), Maker: bean.Buyer},  // consumes the queue
+		{Price: 99, Amount: 3, TimeStamp: at(3), Maker: bean.Seller}, // hits the offers' side
+		{Price: 99, Amount: 6, TimeStamp: at(4), Maker: bean.Buyer},  // 5 ahead left, then 1 filled
+		{Price: 98, Amount: 5, TimeStamp: at(5), Maker: bean.Buyer},  // through the price
+	}
+	pair := bean.Pair{Coin: bean.BTC, Base: bean.USDT}
+
+	e := brew.NewEngine(pair, books, txns, 0)
+	e.SetQueueModel(true)
+	s := &joiner{}
+	e.Run(s)
+	assert.Equal(t, []float64{10, 6, 5, 5, 0, 0}, s.ahead)
+	trades := e.Blotter().Trades()
+	if assert.Len(t, trades, 2) {
+		assert.Equal(t, 1.0, trades[0].Quantity)
+		assert.Equal(t, at(4), trades[0].Time)
+		assert.Equal(t, 1.0, trades[1].Quantity)
+		assert.Equal(t, 99.0, trades[1].Price)
+	}
+
+	// without the model only the trade through the price fills
+	e = brew.NewEngine(pair, books, txns, 0)
+	e.Run(&joiner{})
+	if trades := e.Blotter().Trades(); assert.Len(t, trades, 1) {
+		assert.Equal(t, 2.0, trades[0].Quantity)
+	}
+}
+
+func TestEngineQueueTruncatedBook(t *testing.T) {
+	t0 := time.Date(2019, 5, 1, 0, 0, 0, 0, time.UTC)
+	at := func(s int) time.Time { return t0.Add(time.Duration(s) * time.Second) }
+	book := func(s int, bids ...bean.Order) bean.OrderBookT {
+		return bean.OrderBookT{OrderBook: bean.NewOrderBook(bids, []bean.Order{{Price: 101, Amount: 5}}), Time: at(s)}
+	}
+	books := bean.OrderBookTS{
+		book(0, bean.Order{Price: 99, Amount: 10}),
+		book(2, bean.Order{Price: 100, Amount: 3}),                                  // 99 beyond the single level shown
+		book(4, bean.Order{Price: 99, Amount: 2}, bean.Order{Price: 98, Amount: 1}), // shown again, cancels ahead
+	}
+	txns := bean.Transactions{{Price: 99, Amount: 4, TimeStamp: at(3), Maker: bean.Buyer}}
+	e := brew.NewEngine(bean.Pair{Coin: bean.BTC, Base: bean.USDT}, books, txns, 0)
+	e.SetQueueModel(true)
+	s := &joiner{}
+	e.Run(s)
+	assert.Equal(t, []float64{10, 10, 6, 2}, s.ahead)
+	assert.Empty(t, e.Blotter().Trades())
+}
+
+// canceller cancels the backtest on its n-th book
+type canceller struct {
+	n, books int
+	cancel   context.CancelFunc
+}
+
+func (s *canceller) OnBook(e *brew.Engine, ob bean.OrderBookT) {
+	s.books++
+	if s.books == s.n {
+		s.cancel()
+	}
+}
+
+func (s *canceller) OnTrade(e *brew.Engine, txn bean.Transaction) {}
+func (s *canceller) OnTimer(e *brew.Engine, t time.Time)          {}
+
+func TestEngineRunContext(t *testing.T) {
+	start := time.Date(2019, 5, 1, 0, 0, 0, 0, time.UTC)
+	var candles bean.OHLCVBSTS
+	for i := 0; i < 10; i++ {
+		candles = append(candles, bean.OHLCVBS{Close: 100 + float64(i), End: start.Add(time.Duration(i) * time.Minute)})
+	}
+	pair := bean.Pair{Coin: bean.BTC, Base: bean.USDT}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := &canceller{n: 3, cancel: cancel}
+	res, err := brew.NewEngineFromCandles(pair, candles, 0.001, 10, 5*time.Minute).RunContext(ctx, s)
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.Equal(t, 3, s.books, "no book replayed after the cancellation")
+	assert.Equal(t, 3, len(res.PnL), "summary of the books replayed")
+
+	res, err = brew.NewEngineFromCandles(pair, candles, 0.001, 10, 5*time.Minute).RunContext(context.Background(), &buyOnce{})
+	assert.NoError(t, err)
+	assert.Equal(t, 10, len(res.PnL))
+	assert.Equal(t, 2, res.Trades)
+}
+
+func TestEngineRestingOrderFillsAsMaker(t *testing.T) {
+	t0 := time.Date(2019, 5, 1, 0, 0, 0, 0, time.UTC)
+	books := bean.OrderBookTS{
+		{OrderBook: bean.NewOrderBook([]bean.Order{{Price: 99, Amount: 10}}, []bean.Order{{Price: 101, Amount: 5}}), Time: t0},
+		// the offer drops through the resting bid
+		{OrderBook: bean.NewOrderBook([]bean.Order{{Price: 98, Amount: 10}}, []bean.Order{{Price: 98.5, Amount: 5}}), Time: t0.Add(time.Second)},
+	}
+	pair := bean.Pair{Coin: bean.BTC, Base: bean.USDT}
+	e := brew.NewEngine(pair, books, nil, 0)
+	e.SetFees(bean.FeeRate{MakerBps: 1, TakerBps: 5})
+	e.Run(&joiner{})
+	if trades := e.Blotter().Trades(); assert.Len(t, trades, 1) {
+		assert.Equal(t, 98.5, trades[0].Price)
+		assert.InDelta(t, 2*98.5*1e-4, trades[0].Commission, 1e-12, "maker fee")
+	}
+
+	m := bean.NewMatcher()
+	var fills []bean.MatchFill
+	m.OnFill(func(f bean.MatchFill) { fills = append(fills, f) })
+	m.OnBook("BTC-USDT", books[0])
+	m.Place("BTC-USDT", 101, 1) // crosses on arrival
+	m.Place("BTC-USDT", 99, 2)
+	m.OnBook("BTC-USDT", books[1])
+	if assert.Len(t, fills, 2) {
+		assert.False(t, fills[0].Maker, "taker on arrival")
+		assert.True(t, fills[1].Maker, "maker when resting")
+		assert.Equal(t, 2.0, fills[1].Amount)
+	}
+}
+
+func TestMatcherOrdersInPlacementOrder(t *testing.T) {
+	m := bean.NewMatcher()
+	for i := 0; i < 11; i++ {
+		m.Place("BTC-USDT", 90+float64(i)/10, 1)
+	}
+	orders := m.Orders()
+	if assert.Len(t, orders, 11) {
+		for i, o := range orders {
+			assert.Equal(t, strconv.Itoa(i+1), o.OrderID)
+		}
+	}
+}
+
+func TestSimulatorFees(t *testing.T) {
+	t0 := time.Date(2019, 5, 1, 0, 0, 0, 0, time.UTC)
+	pair := bean.Pair{Coin: bean.BTC, Base: bean.USDT}
+	books := map[bean.Pair]bean.OrderBookTS{pair: {{OrderBook: bean.NewOrderBook(
+		[]bean.Order{{Price: 99, Amount: 5}}, []bean.Order{{Price: 101, Amount: 5}}), Time: t0}}}
+	lift := func(exName string, fs *bean.FeeSchedule) (exchange.Simulator, bean.Transaction) {
+		sim := exchange.NewSimulatorWithData(exName, books, nil, t0, bean.NewPortfolio(map[bean.Coin]float64{bean.USDT: 1000}))
+		sim.SetFeeSchedule(fs)
+		sim.PlaceLimitOrder(pair, 101, 1)
+		sim.SetTime(t0.Add(time.Minute))
+		trades := sim.GetTrades()
+		assert.Len(t, trades, 1)
+		return sim, trades[0]
+	}
+
+	// no fees are charged without a schedule
+	sim, trade := lift(bean.NameBinance, nil)
+	assert.Equal(t, 0.0, trade.Fee)
+	assert.Equal(t, 1000-101.0, sim.GetPortfolio().Balance(bean.USDT))
+	assert.Equal(t, 0.001, sim.GetTakerFee(pair))
+
+	// configured exchanges pay their taker fee on the book fill
+	fs := bean.NewFeeSchedule()
+	fs.Set(bean.NameBinance, bean.ClassSpot, bean.FeeRate{MakerBps: 2, TakerBps: 5})
+	sim, trade = lift(bean.NameBinance, fs)
+	assert.InDelta(t, 101*5e-4, trade.Fee, 1e-12)
+	assert.InDelta(t, 1000-101-101*5e-4, sim.GetPortfolio().Balance(bean.USDT), 1e-9)
+	assert.Equal(t, 2e-4, sim.GetMakerFee(pair))
+	assert.Equal(t, 5e-4, sim.GetTakerFee(pair))
+
+	// others fall back to 10bp
+	sim, trade = lift("FCOIN", fs)
+	assert.InDelta(t, 101*1e-3, trade.Fee, 1e-12)
+	assert.Equal(t, 0.001, sim.GetMakerFee(pair))
+	assert.Equal(t, 0.001, sim.GetTakerFee(pair))
+}
@@ -0,0 +1,255 @@
+package test
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"bean"
+	"bean/binance"
+	"bean/event"
+	"bean/internal/ws"
+	"github.com/stretchr/testify/assert"
+)
+
+const binanceFill = `{"e":"ORDER_TRADE_UPDATE","E":1700000000000,"T":1700000000000,"o":{"s":"BTCUSDT","c":"mm-1","S":"BUY",` +
+	`"o":"LIMIT","q":"0.010","p":"30000","ap":"30000","x":"TRADE","X":"PARTIALLY_FILLED","i":42,"l":"0.004","z":"0.004",` +
+	`"L":"30000","N":"USDT","n":"0.024","T":1700000000000,"t":7,"m":true,"rp":"0"}}`
+
+const binanceAccount = `{"e":"ACCOUNT_UPDATE","E":1700000000001,"T":1700000000001,"a":{"m":"ORDER",` +
+	`"B":[{"a":"USDT","wb":"999.976","cw":"999.976","bc":"0"}],` +
+	`"P":[{"s":"BTCUSDT","pa":"0.004","ep":"30000","cr":"0","up":"0.4","mt":"cross","iw":"0","ps":"BOTH"}]}}`
+
+func TestBinanceUserStream(t *testing.T) {
+	var m sync.Mutex
+	keys, renewals := 0, 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/fapi/v2/account", "/fapi/v2/positionRisk":
+			// snapshots of before and after the first connection
+			m.Lock()
+			defer m.Unlock()
+			switch {
+			case r.URL.Path == "/fapi/v2/account" && keys < 2:
+				w.Write([]byte(`{"assets":[{"asset":"USDT","walletBalance":"1000"}]}`))
+			case r.URL.Path == "/fapi/v2/account":
+				w.Write([]byte(`{"assets":[{"asset":"USDT","walletBalance":"999.976"}]}`))
+			case keys < 2:
+				w.Write([]byte(`[{"symbol":"ETHUSDT","positionAmt":"1","entryPrice":"2000","unRealizedProfit":"5",` +
+					`"positionSide":"BOTH","updateTime":1690000000000}]`))
+			default:
+				w.Write([]byte(`[{"symbol":"BTCUSDT","positionAmt":"0.004","entryPrice":"30000","unRealizedProfit":"0.4",` +
+					`"positionSide":"BOTH","updateTime":1700000000001}]`))
+			}
+			return
+		}
+		if r.URL.Path == "/fapi/v1/listenKey" {
+			assert.Equal(t, "key", r.Header.Get("X-MBX-APIKEY"))
+			m.Lock()
+			defer m.Unlock()
+			if r.Method == http.MethodPut {
+				renewals++
+				w.Write([]byte(`{}`))
+				return
+			}
+			keys++
+			fmt.Fprintf(w, `{"listenKey":"lk%d"}`, keys)
+			return
+		}
+		conn, err := ws.Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		switch r.URL.Path {
+		case "/ws/lk1":
+			conn.WriteText([]byte(binanceFill))
+			conn.WriteText([]byte(binanceAccount))
+			time.Sleep(30 * time.Millisecond) // let the key be renewed
+			conn.WriteText([]byte(`{"e":"listenKeyExpired","E":1700000000002}`))
+		case "/ws/lk2":
+			conn.WriteText([]byte(strings.Replace(strings.Replace(binanceFill, `"x":"TRADE"`, `"x":"CANCELED"`, 1),
+				`"X":"PARTIALLY_FILLED"`, `"X":"CANCELED"`, 1)))
+		}
+		conn.ReadMessage()
+	}))
+	defer srv.Close()
+
+	blotter := bean.NewBlotter()
+	acct := bean.NewAccount(bean.DeribitMarginSchedule())
+	s := binance.NewUserStream("key", "secret", blotter, acct)
+	s.BaseURL = srv.URL
+	s.WSURL = "ws://" + strings.TrimPrefix(srv.URL, "http://") + "/ws"
+	s.KeepAlive = 10 * time.Millisecond
+	s.ReconnectDelay = time.Millisecond
+	metrics := bean.NewMetrics()
+	bean.SetMetrics(metrics)
+	defer bean.SetMetrics(nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := s.Events(ctx)
+
+	next := func() event.Event {
+		select {
+		case e := <-events:
+			return e
+		case <-time.After(5 * time.Second):
+			t.Fatal("no order update")
+		}
+		return event.Event{}
+	}
+	e := next()
+	// the stream waits on the execution of the fill, before the account update
+	assert.Equal(t, 1000.0, acct.Balance(bean.USDT), "loaded on connection")
+	if positions := s.Positions(); assert.Len(t, positions, 1, "loaded on connection") {
+		assert.Equal(t, "ETHUSDT", positions[0].Symbol)
+		assert.Equal(t, 1.0, positions[0].Amount)
+	}
+	assert.Equal(t, event.OrderEvent, e.Kind)
+	assert.Equal(t, "BTCUSDT", e.Instrument)
+	assert.Equal(t, "42", e.Order.OrderID)
+	assert.Equal(t, bean.PARTIAL, e.Order.State)
+	assert.InDelta(t, 0.006, e.Order.LeftAmount, 1e-12)
+	e = next()
+	assert.Equal(t, event.ExecutionEvent, e.Kind)
+	assert.Equal(t, bean.NameBinance, e.Execution.Exchange)
+	assert.Equal(t, "42", e.Execution.OrderID)
+	assert.Equal(t, 0.004, e.Execution.Qty)
+	assert.Equal(t, bean.LiquidityMaker, e.Execution.Liquidity)
+	e = next()
+	assert.Equal(t, bean.CANCELLED, e.Order.State, "after a new listen key")
+	assert.Equal(t, int64(1), metrics.Counter(bean.MetricBookResyncs).Value(), "reloaded on reconnect")
+
+	btc := bean.Pair{Coin: bean.BTC, Base: bean.USDT}
+	assert.Len(t, blotter.Trades(), 1)
+	assert.Equal(t, 0.004, blotter.Position(btc))
+	assert.InDelta(t, 0.4-0.024, blotter.PnL(btc, 30100), 1e-9) // net of the fee
+	assert.Equal(t, 999.976, acct.Balance(bean.USDT))
+	positions := s.Positions()
+	if assert.Len(t, positions, 1) {
+		assert.Equal(t, btc, positions[0].Pair)
+		assert.Equal(t, 0.4, positions[0].UnrealizedPnL)
+	}
+	m.Lock()
+	defer m.Unlock()
+	assert.Equal(t, 2, keys)
+	assert.True(t, renewals > 0)
+
+	// the positions are saved with a checkpoint
+	path := filepath.Join(t.TempDir(), "state.json")
+	assert.NoError(t, event.Checkpoint{Parts: map[string]event.Checkpointer{"binance": s}}.Save(path, time.Now()))
+	restarted := binance.NewUserStream("key", "secret", nil, nil)
+	_, err := event.Checkpoint{Parts: map[string]event.Checkpointer{"binance": restarted}}.Load(path)
+	assert.NoError(t, err)
+	assert.Equal(t, positions, restarted.Positions())
+
+	pair, ok := binance.PairOfSymbol("ETHUSDC")
+	assert.True(t, ok)
+	assert.Equal(t, bean.Pair{Coin: bean.ETH, Base: bean.USDC}, pair)
+	_, ok = binance.PairOfSymbol("USDT")
+	assert.False(t, ok)
+}
+
+func TestBinanceUserStreamState(t *testing.T) {
+	var m sync.Mutex
+	position, balance := "0.01", "1000"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/fapi/v1/listenKey":
+			w.Write([]byte(`{"listenKey":"lk"}`))
+			return
+		case "/fapi/v2/account", "/fapi/v2/positionRisk":
+			assert.Equal(t, "key", r.Header.Get("X-MBX-APIKEY"))
+			q := r.URL.RawQuery
+			i := strings.Index(q, "&signature=")
+			mac := hmac.New(sha256.New, []byte("secret"))
+			if i >= 0 {
+				mac.Write([]byte(q[:i]))
+			}
+			if i < 0 || hex.EncodeToString(mac.Sum(nil)) != q[i+len("&signature="):] {
+				w.WriteHeader(http.StatusUnauthorized)
+				w.Write([]byte(`{"code":-1022,"msg":"Signature for this request is not valid."}`))
+				return
+			}
+			m.Lock()
+			defer m.Unlock()
+			w.Header().Set("X-MBX-USED-WEIGHT-1M", "1190")
+			if r.URL.Path == "/fapi/v2/account" {
+				fmt.Fprintf(w, `{"assets":[{"asset":"USDT","walletBalance":"%s"},{"asset":"BNB","walletBalance":"0"}]}`, balance)
+				return
+			}
+			fmt.Fprintf(w, `[{"symbol":"BTCUSDT","positionAmt":"%s","entryPrice":"29000","unRealizedProfit":"10",`+
+				`"positionSide":"BOTH","updateTime":1700000000000},{"symbol":"ETHUSDT","positionAmt":"0.000",`+
+				`"entryPrice":"0","unRealizedProfit":"0","positionSide":"BOTH","updateTime":0}]`, position)
+			return
+		}
+		conn, err := ws.Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.WriteText([]byte(binanceAccount))
+		conn.WriteText([]byte(binanceFill))
+		conn.ReadMessage()
+	}))
+	defer srv.Close()
+
+	blotter := bean.NewBlotter()
+	acct := bean.NewAccount(bean.DeribitMarginSchedule())
+	s := binance.NewUserStream("key", "secret", blotter, acct)
+	s.BaseURL = srv.URL
+	s.WSURL = "ws://" + strings.TrimPrefix(srv.URL, "http://") + "/ws"
+	s.Limiter.Clock = bean.NewSimClock(date("2019-06-01 10:00"))
+
+	// the account had a position before the blotter started recording
+	btc := bean.Pair{Coin: bean.BTC, Base: bean.USDT}
+	state, err := s.State(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, map[bean.Coin]float64{bean.USDT: 1000, bean.BNB: 0}, state.Balances)
+	assert.Equal(t, []bean.PairPosition{{Pair: btc, Qty: 0.01, Price: 29000}}, state.PairPositions)
+	assert.Equal(t, 10.0, s.Limiter.Available(), "synced with the weight used")
+	r := bean.NewReconciler(s.State, acct, blotter, 1e-9)
+	r.Adopt = true
+	breaks, err := r.Reconcile(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, breaks, 2, "the balance and the position are adopted")
+	assert.Equal(t, 0.01, blotter.Position(btc))
+
+	audit := &failingAudit{n: 100}
+	s.SetAudit(audit)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := s.Events(ctx)
+	for _, kind := range []event.Kind{event.OrderEvent, event.ExecutionEvent} {
+		select {
+		case e := <-events:
+			assert.Equal(t, kind, e.Kind)
+		case <-time.After(5 * time.Second):
+			t.Fatal("no order update")
+		}
+	}
+	if assert.Len(t, audit.recs, 2, "the order update and the fill") {
+		assert.Equal(t, bean.AuditOrder, audit.recs[0].Kind)
+		assert.Equal(t, bean.AuditExecution, audit.recs[1].Kind)
+	}
+	m.Lock()
+	position, balance = "0.014", "999.976"
+	m.Unlock()
+	breaks, err = r.Reconcile(context.Background())
+	assert.NoError(t, err)
+	assert.Empty(t, breaks)
+	assert.Equal(t, 0.014, blotter.Position(btc))
+
+	s.APISecret = "wrong"
+	_, err = s.State(context.Background())
+	assert.Error(t, err)
+}
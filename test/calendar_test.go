@@ -0,0 +1,115 @@
+package test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"bean"
+	"github.com/stretchr/testify/assert"
+)
+
+func date(s string) time.Time {
+	t, err := time.Parse("2006-01-02 15:04", s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestCalendar(t *testing.T) {
+	assert.Equal(t, date("2019-05-01 16:00"), bean.NextFunding(date("2019-05-01 09:30")))
+	assert.Equal(t, date("2019-05-02 00:00"), bean.NextFunding(date("2019-05-01 16:00")))
+	assert.Equal(t, date("2019-05-01 08:00"), bean.PrevFunding(date("2019-05-01 09:30")))
+	assert.Equal(t, 3, len(bean.FundingTimes(date("2019-05-01 00:00"), date("2019-05-02 00:00"))))
+
+	assert.Equal(t, date("2019-05-31 08:00"), bean.LastFriday(2019, time.May))
+	assert.Equal(t, date("2019-06-28 08:00"), bean.LastFriday(2019, time.June))
+	assert.Equal(t, date("2019-05-03 08:00"), bean.WeekdayOnOrAfter(date("2019-05-03 12:00"), time.Friday))
+	assert.Equal(t, date("2019-05-10 08:00"), bean.WeekdayOnOrAfter(date("2019-05-04 12:00"), time.Friday))
+
+	assert.Equal(t, date("2019-05-02 08:00"), bean.NextDailyExpiry(date("2019-05-01 08:00")))
+	assert.Equal(t, date("2019-05-10 08:00"), bean.NextWeeklyExpiry(date("2019-05-03 08:00")))
+	assert.Equal(t, date("2019-06-28 08:00"), bean.NextMonthlyExpiry(date("2019-05-31 09:00")))
+	assert.Equal(t, date("2019-09-27 08:00"), bean.NextQuarterlyExpiry(date("2019-06-28 08:00")))
+	assert.Equal(t, date("2020-03-27 08:00"), bean.NextQuarterlyExpiry(date("2019-12-28 08:00")))
+
+	next, ok := bean.NextExpiry([]time.Time{date("2019-06-28 08:00"), date("2019-05-31 08:00")}, date("2019-05-01 08:00"))
+	assert.True(t, ok)
+	assert.Equal(t, date("2019-05-31 08:00"), next)
+}
+
+func TestSimClock(t *testing.T) {
+	sat := date("2019-06-15 12:00") // a saturday
+	clock := bean.NewSimClock(sat)
+	bean.SetClock(clock)
+	defer bean.SetClock(nil)
+
+	assert.Equal(t, sat, bean.Now())
+	perp := bean.PerpContract(bean.Pair{Coin: bean.BTC, Base: bean.USD})
+	assert.Equal(t, sat.Add(24*time.Hour), perp.Expiry())
+	fri, err := bean.ContractFromPartialName("FRI")
+	assert.NoError(t, err)
+	assert.Equal(t, date("2019-06-21 08:00"), fri.Expiry())
+
+	clock.Advance(7 * 24 * time.Hour)
+	fri, _ = bean.ContractFromPartialName("FRI")
+	assert.Equal(t, date("2019-06-28 08:00"), fri.Expiry())
+	jun, _ := bean.ContractFromPartialName("JUN")
+	assert.Equal(t, date("2019-06-28 08:00"), jun.Expiry())
+}
+
+func TestContractFromPartialNameAt(t *testing.T) {
+	asof := date("2019-07-03 10:00") // a wednesday
+	c, err := bean.ContractFromPartialNameAt("JUN", asof, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, date("2020-06-26 08:00"), c.Expiry())
+	c, _ = bean.ContractFromPartialNameAt("FRI", asof, nil)
+	assert.Equal(t, date("2019-07-05 08:00"), c.Expiry())
+	c, _ = bean.ContractFromPartialNameAt("2FR", asof, nil)
+	assert.Equal(t, date("2019-07-12 08:00"), c.Expiry())
+
+	c, _ = bean.ContractFromPartialNameAt("4000-P", asof, nil)
+	assert.Equal(t, "BTC-28JUN19-4000-P", c.Name())
+
+	template, _ := bean.ContractFromName("ETH-27SEP19")
+	c, err = bean.ContractFromPartialNameAt("200-C", asof, template)
+	assert.NoError(t, err)
+	assert.Equal(t, "ETH-27SEP19-200-C", c.Name())
+	c, _ = bean.ContractFromPartialNameAt("", asof, template)
+	assert.Equal(t, "ETH-27SEP19", c.Name())
+}
+
+func TestExpiryRules(t *testing.T) {
+	for _, name := range []string{"BTC-31FEB19", "BTC-0JUN19", "BTC-+1JUN19", "BTC-28JUX19", "BTC-28JUN1X", "BTC-128JUN19"} {
+		_, err := bean.ContractFromName(name)
+		assert.True(t, errors.Is(err, bean.ErrBadContractFormat), name)
+	}
+	c, err := bean.ContractFromName("btc-29feb20")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2020, 2, 29, 8, 0, 0, 0, time.UTC), c.Expiry())
+
+	hkt := time.FixedZone("HKT", 8*3600)
+	r := bean.ExpiryRule{Hour: 16, Location: hkt}
+	assert.Equal(t, time.Date(2019, 6, 28, 8, 0, 0, 0, time.UTC), r.On(2019, time.June, 28))
+	assert.Equal(t, time.Date(2019, 6, 28, 8, 0, 0, 0, time.UTC), r.Day(time.Date(2019, 6, 27, 20, 0, 0, 0, time.UTC)))
+
+	bean.SetExpiryRule(bean.NameOKX, r)
+	c, err = bean.ContractFromSymbol(bean.NameOKX, "BTC-USD-190628")
+	bean.SetExpiryRule(bean.NameOKX, bean.ExpiryRule{Hour: 8})
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2019, 6, 28, 8, 0, 0, 0, time.UTC), c.Expiry())
+	c, _ = bean.ContractFromSymbol(bean.NameBinance, "BTCUSD_190628")
+	assert.Equal(t, time.Date(2019, 6, 28, 8, 0, 0, 0, time.UTC), c.Expiry())
+
+	hour, loc := bean.ExpiryHour, bean.ExpiryLocation
+	bean.ExpiryHour, bean.ExpiryLocation = 17, hkt
+	defer func() { bean.ExpiryHour, bean.ExpiryLocation = hour, loc }()
+	c, err = bean.ContractFromName("BTC-26DEC42")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2042, 12, 26, 9, 0, 0, 0, time.UTC), c.Expiry())
+	// the last friday of june 2019 at 17:00 in Hong Kong
+	assert.Equal(t, time.Date(2019, 6, 28, 9, 0, 0, 0, time.UTC), bean.LastFriday(2019, time.June))
+	// 09:30 UTC on an expiry friday is past 17:00 in Hong Kong
+	assert.Equal(t, time.Date(2019, 7, 5, 9, 0, 0, 0, time.UTC), bean.NextWeeklyExpiry(time.Date(2019, 6, 28, 9, 30, 0, 0, time.UTC)))
+}
@@ -0,0 +1,228 @@
+package test
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"bean"
+	"github.com/stretchr/testify/assert"
+)
+
+// testChain returns a chain of one expiry with calls and puts quoted 1% either side of their price at vol
+func testChain(asof time.Time, spot, vol float64) *bean.OptionChain {
+	btc := bean.Pair{Coin: bean.BTC, Base: bean.USD}
+	expiry := asof.AddDate(0, 0, 30)
+	ch := bean.NewOptionChain(btc, asof, spot, nil)
+	for _, strike := range []float64{4000, 5000, 6000} {
+		for _, cp := range []bean.CallOrPut{bean.Call, bean.Put} {
+			c := bean.OptContract(btc, expiry, strike, cp)
+			price, _ := c.OptPrice(asof, spot, spot, vol)
+			price /= spot
+			ch.Add(bean.ContractTicker{Contract: c, BestBid: price * 0.99, BestAsk: price * 1.01,
+				BestBidAmount: 10, BestAskAmount: 10, OpenInterest: strike / 100})
+		}
+	}
+	return ch
+}
+
+func TestScanner(t *testing.T) {
+	asof := time.Date(2019, 5, 1, 8, 0, 0, 0, time.UTC)
+	ch := testChain(asof, 5000, 0.8)
+	assert.Equal(t, 1, len(ch.Expiries()))
+	assert.Equal(t, []float64{4000, 5000, 6000}, ch.Strikes(ch.Expiries()[0]))
+
+	s := bean.NewScanner(bean.FlatVol(0.6), 0.05, 45)
+	res := s.Scan(ch)
+	assert.Equal(t, 4, len(res))
+	for _, r := range res {
+		assert.InDelta(t, 0.8, r.MidVol, 0.01)
+		assert.InDelta(t, 0.2, r.VolPremium, 0.01)
+	}
+	assert.Equal(t, 0, len(s.Cheap(ch)))
+	assert.Equal(t, 4, len(s.Rich(ch)))
+}
+
+func TestSolveChainIVs(t *testing.T) {
+	asof := time.Date(2019, 5, 1, 8, 0, 0, 0, time.UTC)
+	ch := testChain(asof, 5000, 0.8)
+	res := bean.SolveChainIVs(ch, nil, 0)
+	assert.Len(t, res, 6)
+	for _, s := range res {
+		assert.True(t, s.OK(), s.Quality.String())
+		assert.InDelta(t, 0.8, s.MidIV, 0.01)
+		assert.True(t, s.BidIV < s.MidIV && s.MidIV < s.AskIV)
+		vol, err := s.Contract.ImpVol(asof, 5000, 5000, s.Mid)
+		assert.NoError(t, err)
+		assert.InDelta(t, vol, s.MidIV, 1e-3)
+	}
+	assert.Equal(t, 4000.0, res[0].Contract.Strike())
+	assert.Equal(t, bean.Call, res[0].Contract.CallPut())
+
+	// books override the chain quotes
+	c4000, p6000, c6000, p5000 := res[0].Contract, res[5].Contract, res[4].Contract, res[3].Contract
+	books := map[string]bean.OrderBook{
+		c4000.Name(): bean.NewOrderBook([]bean.Order{{Price: 0.3, Amount: 1}}, []bean.Order{{Price: 0.25, Amount: 1}}),
+		p6000.Name(): bean.NewOrderBook([]bean.Order{{Price: 0.0001, Amount: 1}}, nil),
+		c6000.Name(): bean.NewOrderBook(nil, []bean.Order{{Price: 1.5, Amount: 1}}),
+		p5000.Name(): bean.NewOrderBook(nil, nil),
+	}
+	res = bean.SolveChainIVs(ch, books, 0)
+	assert.Equal(t, bean.IVCrossed, res[0].Quality)
+	assert.True(t, math.IsNaN(res[0].MidIV))
+	assert.Equal(t, bean.IVOneSided|bean.IVBelowIntrinsic, res[5].Quality)
+	assert.Equal(t, 0.0, res[5].MidIV)
+	assert.Equal(t, bean.IVOneSided|bean.IVNoConvergence, res[4].Quality)
+	assert.Equal(t, "one_sided|no_convergence", res[4].Quality.String())
+	assert.Equal(t, bean.IVNoQuote, res[3].Quality)
+
+	ch.Asof = ch.Expiries()[0]
+	assert.Equal(t, bean.IVExpired, bean.SolveChainIVs(ch, nil, 5000)[1].Quality)
+}
+
+func BenchmarkSolveChainIVs(b *testing.B) {
+	ch := testChain(time.Date(2019, 5, 1, 8, 0, 0, 0, time.UTC), 5000, 0.8)
+	for i := 0; i < b.N; i++ {
+		bean.SolveChainIVs(ch, nil, 0)
+	}
+}
+
+func BenchmarkChainImpVol(b *testing.B) {
+	asof := time.Date(2019, 5, 1, 8, 0, 0, 0, time.UTC)
+	ch := testChain(asof, 5000, 0.8)
+	for i := 0; i < b.N; i++ {
+		for _, q := range ch.Quotes {
+			for _, p := range []float64{q.BestBid, q.BestAsk, (q.BestBid + q.BestAsk) / 2} {
+				q.Contract.ImpVol(asof, 5000, 5000, p)
+			}
+		}
+	}
+}
+
+func TestOpenInterest(t *testing.T) {
+	asof := time.Date(2019, 5, 1, 8, 0, 0, 0, time.UTC)
+	ch := testChain(asof, 5000, 0.8)
+	d := ch.OpenInterest(ch.Expiries()[0])
+	assert.Equal(t, bean.OIDistribution{{Strike: 4000, Calls: 40, Puts: 40},
+		{Strike: 5000, Calls: 50, Puts: 50}, {Strike: 6000, Calls: 60, Puts: 60}}, d)
+	assert.Equal(t, d, ch.OpenInterest(time.Time{}))
+	assert.Empty(t, ch.OpenInterest(asof))
+	assert.Equal(t, 150.0, d.Calls())
+	assert.Equal(t, 1.0, d.PutCallRatio())
+	calls, puts, all := d.WeightedStrike()
+	assert.InDelta(t, 770000/150.0, calls, 1e-9)
+	assert.InDelta(t, calls, puts, 1e-9)
+	assert.InDelta(t, calls, all, 1e-9)
+	assert.Equal(t, 170000.0, d.Pain(4000))
+	assert.Equal(t, 100000.0, d.Pain(5000))
+	assert.Equal(t, 5000.0, d.MaxPain())
+	assert.True(t, math.IsNaN(bean.OIDistribution{{Strike: 1}}.MaxPain()))
+
+	r := bean.NewTickerRecorder(time.Hour)
+	c := ch.Quotes[0].Contract
+	for i, oi := range []float64{10, 20, 30} {
+		r.OnTicker(bean.ContractTicker{Contract: c, OpenInterest: oi, Volume24H: oi / 2, Time: asof.Add(time.Duration(i) * 40 * time.Minute)})
+	}
+	r.OnTicker(bean.ContractTicker{Contract: c, OpenInterest: 5, Time: asof}) // stale
+	oi := r.OpenInterest(c.Name())
+	assert.Len(t, oi, 2, "an hour kept")
+	assert.Equal(t, 30.0, oi[1].Value)
+	assert.Equal(t, 15.0, r.Volume(c.Name())[1].Value)
+	last, ok := r.Last(c.Name())
+	assert.True(t, ok)
+	assert.Equal(t, 30.0, last.OpenInterest)
+	chain := r.Chain(ch.Underlying, asof, 5000, nil)
+	assert.Len(t, chain.Quotes, 1)
+	assert.Empty(t, r.Chain(ch.Underlying, c.Expiry(), 5000, nil).Quotes, "expired")
+}
+
+func TestGammaExposure(t *testing.T) {
+	asof := time.Date(2019, 5, 1, 8, 0, 0, 0, time.UTC)
+	ch := testChain(asof, 5000, 0.8)
+	strike, err := ch.MaxPain(ch.Expiries()[0])
+	assert.NoError(t, err)
+	assert.Equal(t, 5000.0, strike)
+	_, err = ch.MaxPain(asof)
+	assert.True(t, errors.Is(err, bean.ErrInvalidInput))
+
+	// puts held below spot and calls above
+	ch = bean.NewOptionChain(ch.Underlying, asof, 5000, nil)
+	expiry := asof.AddDate(0, 0, 30)
+	ch.Add(bean.ContractTicker{Contract: bean.OptContract(ch.Underlying, expiry, 4000, bean.Put), OpenInterest: 100})
+	ch.Add(bean.ContractTicker{Contract: bean.OptContract(ch.Underlying, expiry, 6000, bean.Call), OpenInterest: 100})
+	ch.Add(bean.ContractTicker{Contract: bean.OptContract(ch.Underlying, expiry, 6000, bean.Put), OpenInterest: math.NaN()})
+	m := bean.NewMarket(asof)
+	m.SetSpot(ch.Underlying, 5000)
+	m.SetVolSurface(ch.Underlying, bean.FlatVol(0.8))
+	spots := bean.SpotLevels(5000, 0.3, 13)
+	assert.Equal(t, 3500.0, spots[0])
+	assert.Equal(t, 5000.0, spots[6])
+	gex, err := ch.GammaExposure(m, spots)
+	assert.NoError(t, err)
+	assert.Len(t, gex, 13)
+	for _, p := range gex {
+		assert.True(t, p.Calls > 0 && p.Puts < 0)
+	}
+	// put gamma dominates on the way down, call gamma on the way up
+	assert.True(t, gex[0].Total() < 0)
+	assert.True(t, gex[12].Total() > 0)
+	zero := gex.ZeroGamma()
+	assert.InDelta(t, 5000, zero, 250)
+	at, ok := gex.At(5010)
+	assert.True(t, ok)
+	assert.Equal(t, 5000.0, at.Spot)
+	assert.True(t, math.IsNaN(bean.GEXProfile{{Spot: 1, Calls: 1}, {Spot: 2, Calls: 2}}.ZeroGamma()))
+
+	_, err = ch.GammaExposure(bean.NewMarket(asof), spots)
+	assert.True(t, errors.Is(err, bean.ErrInvalidInput))
+}
+
+func TestVolIndex(t *testing.T) {
+	asof := time.Date(2019, 5, 1, 8, 0, 0, 0, time.UTC)
+	btc := bean.Pair{Coin: bean.BTC, Base: bean.USD}
+	ch := bean.NewOptionChain(btc, asof, 5000, nil)
+	near, far := asof.AddDate(0, 0, 20), asof.AddDate(0, 0, 50)
+	for _, e := range []struct {
+		expiry time.Time
+		vol    float64
+	}{{near, 0.6}, {far, 0.8}} {
+		for strike := 1000.0; strike <= 20000; strike += 100 {
+			for _, cp := range []bean.CallOrPut{bean.Call, bean.Put} {
+				c := bean.OptContract(btc, e.expiry, strike, cp)
+				price, _ := c.OptPrice(asof, 5000, 5000, e.vol)
+				price /= 5000
+				// deep out of the money options have no bid
+				bid := price * 0.99
+				if price < 1e-5 {
+					bid = 0
+				}
+				ch.Add(bean.ContractTicker{Contract: c, BestBid: bid, BestAsk: price*1.01 + 1e-5})
+			}
+		}
+	}
+
+	// the variance swap of a flat smile is the square of its vol
+	v, err := ch.VarianceStrike(near)
+	assert.NoError(t, err)
+	assert.Equal(t, 5000.0, v.K0)
+	assert.True(t, v.Strikes > 20)
+	assert.InDelta(t, 0.6, v.Vol(), 0.01)
+	v, err = ch.VarianceStrike(far)
+	assert.NoError(t, err)
+	assert.InDelta(t, 0.8, v.Vol(), 0.01)
+
+	// 30 days is a third of the way in time from the near to the far expiry
+	vol, err := ch.VolIndex(bean.VolIndexTenor)
+	assert.NoError(t, err)
+	want := math.Sqrt((0.36*20*2/3 + 0.64*50/3) / 30)
+	assert.InDelta(t, want, vol, 0.01)
+	vol, err = ch.VolIndex(10 * 24 * time.Hour)
+	assert.NoError(t, err)
+	assert.InDelta(t, 0.6, vol, 0.01)
+
+	_, err = ch.VarianceStrike(asof.AddDate(0, 0, 40))
+	assert.True(t, errors.Is(err, bean.ErrInvalidInput))
+	_, err = bean.NewOptionChain(btc, asof, 5000, nil).VolIndex(bean.VolIndexTenor)
+	assert.True(t, errors.Is(err, bean.ErrInvalidInput))
+}
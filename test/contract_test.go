@@ -0,0 +1,392 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+	"testing/quick"
+	"time"
+
+	"bean"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestContractErrors(t *testing.T) {
+	_, err := bean.ContractFromName("XYZ-28JUN19")
+	assert.True(t, errors.Is(err, bean.ErrUnknownCoin))
+	_, err = bean.ContractFromName("BTC-28JUN19-5000-X")
+	assert.True(t, errors.Is(err, bean.ErrBadContractFormat))
+	var cerr *bean.ContractError
+	assert.True(t, errors.As(err, &cerr))
+	assert.Equal(t, "BTC-28JUN19-5000-X", cerr.Name)
+
+	fut, _ := bean.ContractFromName("BTC-28JUN19")
+	asof := time.Date(2019, 5, 1, 8, 0, 0, 0, time.UTC)
+	_, err = fut.ImpVol(asof, 5500, 5600, 0.1)
+	assert.True(t, errors.Is(err, bean.ErrNotAnOption))
+	_, err = fut.OptPrice(asof, 5500, 5600, 0.8)
+	assert.True(t, errors.Is(err, bean.ErrNotAnOption))
+
+	opt, _ := bean.ContractFromName("BTC-28JUN19-6000-C")
+	prc, err := opt.OptPrice(asof, 5500, 5600, 0.8)
+	assert.Nil(t, err)
+	vol, err := opt.ImpVol(asof, 5500, 5600, prc/5500)
+	assert.Nil(t, err)
+	assert.InDelta(t, 0.8, vol, 1e-3)
+	_, err = opt.ImpVol(asof.AddDate(1, 0, 0), 5500, 5600, 0.1)
+	assert.True(t, errors.Is(err, bean.ErrExpired))
+}
+
+func TestSymbolMapper(t *testing.T) {
+	symbols := map[string][]string{
+		bean.NameDeribit: {"BTC-27JUN25-60000-C", "BTC-27JUN25", "BTC-PERPETUAL"},
+		bean.NameOKX:     {"BTC-USD-250627-60000-C", "BTC-USD-250627", "BTC-USD-SWAP"},
+		bean.NameBinance: {"BTC-250627-60000-C", "BTCUSD_250627", "BTCUSD_PERP"},
+	}
+	for ex, syms := range symbols {
+		for i, sym := range syms {
+			c, err := bean.ContractFromSymbol(ex, sym)
+			assert.Nil(t, err)
+			assert.Equal(t, symbols[bean.NameDeribit][i], c.Name())
+			back, err := bean.SymbolFromContract(ex, c)
+			assert.Nil(t, err)
+			assert.Equal(t, sym, back)
+		}
+	}
+}
+
+func TestStrikeFormats(t *testing.T) {
+	names := map[string]string{
+		"BTC-27JUN25-60000-C":    "BTC-27JUN25-60000-C",
+		"btc-27jun25-60k-c":      "BTC-27JUN25-60000-C",
+		"BTC-27JUN25-1.5K-P":     "BTC-27JUN25-1500-P",
+		"ETH-27JUN25-50.5-C":     "ETH-27JUN25-50.5-C",
+		"ETH-27JUN25-0d5-P":      "ETH-27JUN25-0.5-P",
+		"BTC-27JUN25-60000.25-C": "BTC-27JUN25-60000.25-C",
+	}
+	for in, name := range names {
+		c, err := bean.ContractFromName(in)
+		if assert.NoError(t, err, in) {
+			assert.Equal(t, name, c.Name())
+			back, err := bean.ContractFromName(c.Name())
+			assert.NoError(t, err)
+			assert.Equal(t, c.Strike(), back.Strike())
+		}
+	}
+	for _, bad := range []string{"BTC-27JUN25-0-C", "BTC-27JUN25-1e3-C", "BTC-27JUN25-NaN-C", "BTC-27JUN25-1.2.3-C", "BTC-27JUN25-k-C"} {
+		_, err := bean.ContractFromName(bad)
+		assert.True(t, errors.Is(err, bean.ErrBadContractFormat), bad)
+	}
+	c, err := bean.ContractFromPartialName("eth-27sep19-60.5k-p")
+	assert.NoError(t, err)
+	assert.Equal(t, "ETH-27SEP19-60500-P", c.Name())
+}
+
+func TestFuturesCurve(t *testing.T) {
+	asof := time.Date(2019, 5, 1, 8, 0, 0, 0, time.UTC)
+	btc := bean.Pair{Coin: bean.BTC, Base: bean.USD}
+	fc := bean.NewFuturesCurve(btc, asof, 5000)
+	jun := bean.FutContract(btc, asof.AddDate(0, 0, 73))
+	sep := bean.FutContract(btc, asof.AddDate(0, 0, 146))
+	fc.SetFuture(sep, 5000*math.Exp(0.2*146/365.0))
+	fc.SetFuture(jun, 5000*math.Exp(0.1*73/365.0))
+
+	tenors := fc.Tenors()
+	assert.Equal(t, jun, tenors[0].Contract)
+	assert.InDelta(t, 0.1, tenors[0].AnnualBasis, 1e-9)
+	assert.InDelta(t, 0.2, tenors[1].AnnualBasis, 1e-9)
+	assert.InDelta(t, 5000*math.Exp(0.1*36.5/365.0), fc.Forward(asof.AddDate(0, 0, 36).Add(12*time.Hour)), 1e-6)
+	assert.InDelta(t, fc.Forward(sep.Expiry())-fc.Forward(jun.Expiry()), fc.CalendarSpread(jun, sep), 1e-9)
+	assert.True(t, fc.RollDown(sep, 73*24*time.Hour) < 0)
+
+	opt := bean.OptContract(btc, asof.AddDate(0, 0, 100), 5500, bean.Call)
+	p1, _ := opt.OptPriceCurve(fc, 0.8)
+	p2, _ := opt.OptPrice(asof, 5000, fc.Forward(opt.Expiry()), 0.8)
+	assert.Equal(t, p1, p2)
+}
+
+func TestRollPlan(t *testing.T) {
+	btc := bean.Pair{Coin: bean.BTC, Base: bean.USD}
+	asof := time.Date(2019, 6, 25, 8, 0, 0, 0, time.UTC)
+	jun := bean.FutContract(btc, time.Date(2019, 6, 28, 8, 0, 0, 0, time.UTC))
+	sep := bean.FutContract(btc, time.Date(2019, 9, 27, 8, 0, 0, 0, time.UTC))
+	curve := bean.NewFuturesCurve(btc, asof, 10000)
+	curve.SetFuture(jun, 10010)
+	curve.SetFuture(sep, 10300)
+
+	books := map[string]bean.OrderBook{
+		jun.Name(): bean.NewOrderBook([]bean.Order{{Price: 10009, Amount: 5000}}, []bean.Order{{Price: 10011, Amount: 5000}}),
+		sep.Name(): bean.NewOrderBook([]bean.Order{{Price: 10298, Amount: 5000}}, []bean.Order{{Price: 10302, Amount: 5000}}),
+	}
+	positions := []bean.Position{
+		bean.NewPosition(jun, 1000, 9000),
+		bean.NewPosition(sep, 100, 9000),                  // not expiring
+		bean.NewPosition(bean.PerpContract(btc), 1, 9000), // never rolled
+	}
+	plan := bean.NewRollPlan(asof, positions, 7, curve, nil, books, map[bean.Pair]float64{btc: 10000})
+	assert.Len(t, plan.Trades, 1)
+	tr := plan.Trades[0]
+	assert.Equal(t, sep.Name(), tr.To.Name())
+	assert.Equal(t, 10009.0, tr.ClosePrice)
+	assert.Equal(t, 10302.0, tr.OpenPrice)
+	assert.True(t, tr.Cost > 0)
+	assert.InDelta(t, plan.TotalCost, tr.Cost, 1e-12)
+	assert.InDelta(t, -math.Log(10300/10010.0)/(91/365.0), tr.RollYield, 1e-12)
+}
+
+func TestInstrumentSpec(t *testing.T) {
+	assert.Equal(t, 0.3, bean.RoundToTick(0.31, 0.1))
+	assert.Equal(t, 8000.5, bean.RoundToTick(8000.3, 0.5))
+	assert.Equal(t, 0.0035, bean.RoundDownToTick(0.00359, 0.0005))
+	assert.Equal(t, 0.004, bean.RoundUpToTick(0.00351, 0.0005))
+	assert.Equal(t, 0.7, bean.RoundToLot(0.79, 0.1))
+	assert.Equal(t, -0.7, bean.RoundToLot(-0.79, 0.1))
+	assert.Equal(t, 0.3, bean.RoundToLot(0.3, 0.1))
+
+	v := bean.NewOrderValidator()
+	assert.NoError(t, v.Validate("BTC-28JUN19", 8000.5, -20))
+	assert.NoError(t, v.Validate("BTC-28JUN19-8000-C", 0.0035, 0.3))
+	assert.NoError(t, v.Validate("BTC-28JUN19-8000-C", 0.0041, 0.3), "small tick below 0.005")
+	for _, bad := range []struct {
+		instrument    string
+		price, amount float64
+	}{
+		{"BTC-28JUN19", 8000.3, 10},
+		{"BTC-28JUN19", 8000, 0},
+		{"BTC-28JUN19-8000-C", 0.0051, 1},
+		{"BTC-28JUN19-8000-C", 0.01, 0.25},
+		{"ETH-28JUN19-200-P", 0.01, 0.5},
+	} {
+		err := v.Validate(bad.instrument, bad.price, bad.amount)
+		assert.True(t, errors.Is(err, bean.ErrInvalidOrder), "%v", bad)
+	}
+
+	spec := bean.InstrumentSpec{TickSize: 0.01, LotSize: 0.001, MinNotional: 10, MaxAmount: 5}
+	v.SetSpec("BTC-PERPETUAL", spec)
+	assert.Error(t, v.Validate("BTC-PERPETUAL", 100, 0.05))
+	assert.Error(t, v.Validate("BTC-PERPETUAL", 100, 6))
+	assert.NoError(t, v.Validate("BTC-PERPETUAL", 100.01, 0.1))
+	assert.Equal(t, 100.01, spec.RoundPrice(100.019, 1))
+	assert.Equal(t, 100.02, spec.RoundPrice(100.011, -1))
+}
+
+func TestContractFormat(t *testing.T) {
+	c, _ := bean.ContractFromName("ETH-5JUL19-50.5-P")
+	assert.Equal(t, "ETH-5JUL19-50.5-P", c.Format(bean.StyleBean))
+	assert.Equal(t, "ETH-5JUL19-50d5-P", c.Format(bean.StyleDeribit))
+	assert.Equal(t, "ETH 5 Jul 2019 50.5 Put", c.Format(bean.StyleHuman))
+	assert.Equal(t, "ETH190705P50.5", c.Format(bean.StyleCompact))
+	f, _ := bean.ContractFromName("BTC-27SEP19")
+	assert.Equal(t, "BTC 27 Sep 2019 Future", f.Format(bean.StyleHuman))
+	assert.Equal(t, "BTC190927", f.Format(bean.StyleCompact))
+	p, _ := bean.ContractFromName("BTC-PERPETUAL")
+	assert.Equal(t, "BTC-PERPETUAL", p.Format(bean.StyleDeribit))
+	assert.Equal(t, "BTCPERP", p.Format(bean.StyleCompact))
+}
+
+func TestContractNameRoundTrip(t *testing.T) {
+	pairs := []bean.Pair{{Coin: bean.BTC, Base: bean.USD}, {Coin: bean.ETH, Base: bean.USD}}
+	start := time.Date(2000, 1, 1, 8, 0, 0, 0, time.UTC)
+	roundTrip := func(coin bool, days uint16, strike uint32, decimals uint8, kind uint8) bool {
+		under := pairs[0]
+		if coin {
+			under = pairs[1]
+		}
+		expiry := start.AddDate(0, 0, int(days)%36500)
+		k := float64(strike%10000000+1) / math.Pow10(int(decimals%5))
+		var c *bean.Contract
+		switch kind % 5 {
+		case 0:
+			c = bean.OptContract(under, expiry, k, bean.Call)
+		case 1:
+			c = bean.OptContract(under, expiry, k, bean.Put)
+		case 2:
+			c = bean.FutContract(under, expiry)
+		case 3:
+			c = bean.PerpContract(under)
+		default:
+			c = bean.IndexContract(under)
+		}
+		for _, style := range []bean.NameStyle{bean.StyleBean, bean.StyleDeribit} {
+			name := c.Format(style)
+			parsed, err := bean.ContractFromName(name)
+			if err != nil || parsed.Name() != c.Name() || parsed.Index() != c.Index() {
+				t.Logf("%s parsed as %v: %v", name, parsed, err)
+				return false
+			}
+			if !c.Index() && !parsed.Equal(c) {
+				t.Logf("%s parsed as %s expiring %s", name, parsed.Name(), parsed.Expiry())
+				return false
+			}
+		}
+		return true
+	}
+	assert.NoError(t, quick.Check(roundTrip, &quick.Config{MaxCount: 2000}))
+}
+
+func TestComboContract(t *testing.T) {
+	for name, ratios := range map[string][2]float64{
+		"BTC-FS-28JUN19_PERP":           {1, -1},
+		"BTC-CS-28JUN19-9000_10000":     {1, -1},
+		"BTC-PSR12-28JUN19-9000_8000":   {1, -2},
+		"BTC-STRD-28JUN19-9000":         {1, 1},
+		"BTC-STRG-28JUN19-8000_10000":   {1, 1},
+		"BTC-RR-28JUN19-8000_10000":     {-1, 1},
+		"BTC-CCAL-28JUN19_27SEP19-9000": {1, -1},
+	} {
+		c, err := bean.ComboFromName(name)
+		if !assert.NoError(t, err, name) {
+			continue
+		}
+		assert.Equal(t, name, c.Name())
+		assert.Equal(t, ratios, [2]float64{c.Legs[0].Ratio, c.Legs[1].Ratio}, name)
+	}
+	rr, _ := bean.ComboFromName("btc-rr-28jun19-8000_10000")
+	assert.Equal(t, "BTC-28JUN19-8000-P", rr.Legs[0].Contract.Name())
+	assert.Equal(t, "BTC-28JUN19-10000-C", rr.Legs[1].Contract.Name())
+	built := bean.ComboContract{Kind: bean.RiskReversal, Legs: rr.Legs}
+	assert.Equal(t, "BTC-RR-28JUN19-8000_10000", built.Name())
+	for _, name := range []string{"BTC-XX-28JUN19-9000", "BTC-CSR-28JUN19-9000_10000", "BTC-CS-28JUN19-9000", "BTC-FS-28JUN19"} {
+		_, err := bean.ComboFromName(name)
+		assert.True(t, errors.Is(err, bean.ErrBadContractFormat), name)
+	}
+
+	// PV and greeks are the sums of the legs
+	m := bean.NewMarket(time.Date(2019, 5, 1, 8, 0, 0, 0, time.UTC))
+	m.SetSpot(rr.Underlying(), 9000)
+	m.SetVolSurface(rr.Underlying(), bean.FlatVol(0.8))
+	assert.InDelta(t, 0.03, rr.Price([2]float64{0.02, 0.05}), 1e-12)
+	legs := rr.Positions(2, [2]float64{0.02, 0.05})
+	assert.Equal(t, -2.0, legs[0].Qty())
+	g := rr.GreeksMarket(m, 2, [2]float64{0.02, 0.05})
+	assert.InDelta(t, legs[0].PVMarket(m)+legs[1].PVMarket(m), rr.PVMarket(m, 2, [2]float64{0.02, 0.05}), 1e-9)
+	assert.InDelta(t, legs[0].DeltaMarket(m)+legs[1].DeltaMarket(m), g.Delta, 1e-9)
+	assert.True(t, g.Delta > 0)
+}
+
+func TestImpVolContext(t *testing.T) {
+	asof := time.Date(2019, 5, 1, 8, 0, 0, 0, time.UTC)
+	btc := bean.Pair{Coin: bean.BTC, Base: bean.USD}
+	call := bean.OptContract(btc, asof.AddDate(0, 0, 30), 5000, bean.Call)
+	price, _ := call.OptPrice(asof, 5000, 5000, 0.8)
+	price /= 5000
+
+	vol, err := call.ImpVolContext(context.Background(), asof, 5000, 5000, price)
+	assert.NoError(t, err)
+	assert.InDelta(t, 0.8, vol, 1e-6)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	vol, err = call.ImpVolContext(ctx, asof, 5000, 5000, price)
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.True(t, math.IsNaN(vol))
+}
+
+func TestProbITM(t *testing.T) {
+	asof := time.Date(2019, 5, 1, 8, 0, 0, 0, time.UTC)
+	expiry := asof.AddDate(0, 0, 30)
+	btc := bean.Pair{Coin: bean.BTC, Base: bean.USD}
+	tests := []struct {
+		name    string
+		strike  float64
+		cp      bean.CallOrPut
+		forward float64
+		prob    float64
+		delta   float64
+	}{
+		{"atm call", 5000, bean.Call, 5000, 0.5, 0.05},
+		{"atm put", 5000, bean.Put, 5000, 0.5, 0.05},
+		{"deep itm call", 1000, bean.Call, 5000, 1, 1e-6},
+		{"deep itm put", 20000, bean.Put, 5000, 1, 1e-6},
+		{"deep otm call", 20000, bean.Call, 5000, 0, 1e-6},
+		{"deep otm put", 1000, bean.Put, 5000, 0, 1e-6},
+		{"zero strike call", 0, bean.Call, 5000, 1, 0},
+		{"zero strike put", 0, bean.Put, 5000, 0, 0},
+		{"zero forward call", 5000, bean.Call, 0, 0, 0},
+		{"negative forward put", 5000, bean.Put, -1, 1, 0},
+	}
+	for _, tt := range tests {
+		c := bean.OptContract(btc, expiry, tt.strike, tt.cp)
+		prob := c.ProbITM(asof, tt.forward, 0.8)
+		assert.False(t, math.IsNaN(prob), tt.name)
+		assert.InDelta(t, tt.prob, prob, tt.delta, tt.name)
+		if tt.forward > 0 && tt.strike > 0 {
+			// the undiscounted expected payoff is the black scholes price grossed up by spot/forward
+			spot := 0.98 * tt.forward
+			price, err := c.OptPrice(asof, spot, tt.forward, 0.8)
+			assert.NoError(t, err, tt.name)
+			assert.InDelta(t, price*tt.forward/spot, c.ExpectedPayoff(asof, tt.forward, 0.8), 1e-6, tt.name)
+		} else {
+			assert.True(t, math.IsNaN(c.Moneyness(tt.forward)), tt.name)
+		}
+	}
+
+	// calls and puts on the same strike partition the outcomes
+	call := bean.OptContract(btc, expiry, 5500, bean.Call)
+	put := bean.OptContract(btc, expiry, 5500, bean.Put)
+	assert.InDelta(t, 1.0, call.ProbITM(asof, 5000, 0.8)+put.ProbITM(asof, 5000, 0.8), 1e-12)
+	assert.True(t, call.ProbITM(asof, 5000, 0.8) < 0.5, "an otm call is less likely than not to finish in the money")
+}
+
+func TestMarkFromBook(t *testing.T) {
+	asof := time.Date(2019, 5, 1, 8, 0, 0, 0, time.UTC)
+	btc := bean.Pair{Coin: bean.BTC, Base: bean.USD}
+	call := bean.OptContract(btc, asof.AddDate(0, 0, 30), 5000, bean.Call)
+	theo, _ := call.OptPrice(asof, 5000, 5000, 0.8)
+	theo /= 5000
+	book := func(bids, asks []bean.Order) bean.OrderBook { return bean.NewOrderBook(bids, asks) }
+	level := func(price float64) []bean.Order { return []bean.Order{{Price: price, Amount: 1}} }
+
+	tests := []struct {
+		name string
+		ob   bean.OrderBook
+		mark float64
+	}{
+		{"tight book marks at the mid", book(level(0.090), level(0.095)), 0.0925},
+		{"wide book above theo clamps to the bid", book(level(0.15), level(0.30)), 0.15},
+		{"wide book below theo clamps to the ask", book(level(0.01), level(0.05)), 0.05},
+		{"wide book around theo marks at theo", book(level(0.05), level(0.15)), theo},
+		{"bid only above theo", book(level(0.15), nil), 0.15},
+		{"bid only below theo", book(level(0.01), nil), theo},
+		{"ask only below theo", book(nil, level(0.05)), 0.05},
+		{"empty book marks at theo", book(nil, nil), theo},
+	}
+	for _, tt := range tests {
+		mark, err := call.MarkFromBook(asof, 5000, 5000, tt.ob, 0.8)
+		assert.NoError(t, err, tt.name)
+		assert.InDelta(t, tt.mark, mark, 1e-12, tt.name)
+	}
+
+	fut := bean.FutContract(btc, asof.AddDate(0, 0, 30))
+	mark, err := fut.MarkFromBook(asof, 5000, 5000, book(level(4990), level(5010)), 0.8)
+	assert.True(t, errors.Is(err, bean.ErrNotAnOption))
+	assert.True(t, math.IsNaN(mark))
+}
+
+func TestWholeDayExpiry(t *testing.T) {
+	defer func(whole bool) { bean.WholeDayExpiry = whole }(bean.WholeDayExpiry)
+	btc := bean.Pair{Coin: bean.BTC, Base: bean.USD}
+	expiry := time.Date(2019, 5, 31, 8, 0, 0, 0, time.UTC)
+	call := bean.OptContract(btc, expiry, 5000, bean.Call)
+	sameDay := expiry.Add(-6 * time.Hour)
+	dayBefore := expiry.Add(-12 * time.Hour)
+
+	bean.WholeDayExpiry = false
+	assert.InDelta(t, 0.25/365, call.ExpiryYears(sameDay), 1e-12)
+	assert.InDelta(t, 0.5/365, call.ExpiryYears(dayBefore), 1e-12)
+	fractional, err := call.OptPrice(sameDay, 5000, 5000, 0.8)
+	assert.NoError(t, err)
+	assert.True(t, fractional > 0, "an atm option has time value until it expires")
+
+	bean.WholeDayExpiry = true
+	assert.Equal(t, 0.0, call.ExpiryYears(sameDay), "expiring later today counts as expired")
+	assert.Equal(t, 1.0/365, call.ExpiryYears(dayBefore), "expiring early tomorrow counts as a whole day")
+	whole, err := call.OptPrice(sameDay, 5000, 5000, 0.8)
+	assert.NoError(t, err)
+	assert.Equal(t, 0.0, whole, "priced at intrinsic")
+	wholeDay, _ := call.OptPrice(dayBefore, 5000, 5000, 0.8)
+	bean.WholeDayExpiry = false
+	halfDay, _ := call.OptPrice(dayBefore, 5000, 5000, 0.8)
+	assert.InDelta(t, math.Sqrt2*halfDay, wholeDay, 0.01*wholeDay, "atm time value grows with the root of time")
+}
@@ -0,0 +1,353 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"bean"
+	"bean/deribit"
+	"bean/event"
+	"bean/internal/ws"
+	"github.com/stretchr/testify/assert"
+)
+
+const instrumentsJSON = `{"jsonrpc":"2.0","result":[
+{"tick_size":0.0005,"settlement_currency":"BTC","quote_currency":"BTC","option_type":"call","min_trade_amount":0.1,"kind":"option","is_active":true,"instrument_name":"BTC-28JUN19-9000-C","contract_size":1.0,"base_currency":"BTC"},
+{"tick_size":0.5,"settlement_currency":"BTC","quote_currency":"USD","min_trade_amount":10,"kind":"future","is_active":true,"instrument_name":"BTC-PERPETUAL","contract_size":10,"base_currency":"BTC"},
+{"tick_size":0.5,"settlement_currency":"BTC","quote_currency":"USD","min_trade_amount":10,"kind":"future_combo","is_active":true,"instrument_name":"BTC-FS-28JUN19_PERP","contract_size":10,"base_currency":"BTC"}
+]}`
+
+func TestDeribitInstruments(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/public/get_instruments", r.URL.Path)
+		if r.URL.Query().Get("currency") != "BTC" {
+			w.Write([]byte(`{"error":{"code":10001,"message":"invalid currency"}}`))
+			return
+		}
+		w.Write([]byte(instrumentsJSON))
+	}))
+	defer srv.Close()
+
+	c := deribit.NewInstrumentClient()
+	c.BaseURL = srv.URL
+	assert.NoError(t, c.Refresh(context.Background(), "BTC"))
+	assert.Len(t, c.Instruments(), 2)
+	opt, ok := c.Instrument("BTC-28JUN19-9000-C")
+	assert.True(t, ok)
+	assert.True(t, opt.Contract.IsOption())
+	assert.Equal(t, 0.0005, opt.TickSize)
+	assert.Equal(t, bean.BTC, opt.SettlementCurrency)
+	perp, _ := c.Instrument("BTC-PERPETUAL")
+	assert.Equal(t, 10.0, perp.ContractSize)
+	assert.False(t, c.Updated().IsZero())
+
+	assert.Error(t, c.Refresh(context.Background(), "BTC", "XYZ"))
+	assert.Len(t, c.Instruments(), 2) // kept on error
+}
+
+// fakeDeribit answers the private API from a set of orders, failing the first order placement after taking it
+type fakeDeribit struct {
+	t      *testing.T
+	m      sync.Mutex
+	orders []map[string]interface{}
+	buys   int
+	sells  int
+	subs   [][]string // channels subscribed by each stream connection
+	auths  int        // of the REST API
+	early  int        // subscriptions sent before the stream was authenticated
+}
+
+func (f *fakeDeribit) reply(w http.ResponseWriter, result interface{}) {
+	json.NewEncoder(w).Encode(map[string]interface{}{"jsonrpc": "2.0", "result": result})
+}
+
+func (f *fakeDeribit) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/ws" {
+		f.stream(w, r)
+		return
+	}
+	var req struct {
+		Method string                 `json:"method"`
+		Params map[string]interface{} `json:"params"`
+	}
+	assert.NoError(f.t, json.NewDecoder(r.Body).Decode(&req))
+	assert.Equal(f.t, "/"+req.Method, r.URL.Path)
+	if req.Method != "public/auth" {
+		assert.Equal(f.t, "Bearer token", r.Header.Get("Authorization"))
+	}
+	f.m.Lock()
+	defer f.m.Unlock()
+	switch req.Method {
+	case "public/auth":
+		f.auths++
+		f.reply(w, map[string]interface{}{"access_token": "token", "expires_in": 900})
+	case "private/buy":
+		f.buys++
+		o := map[string]interface{}{"order_id": "ETH-1", "instrument_name": req.Params["instrument_name"],
+			"direction": "buy", "price": req.Params["price"], "amount": req.Params["amount"], "filled_amount": 0,
+			"order_state": "open", "label": req.Params["label"], "creation_timestamp": 1700000000000}
+		f.orders = append(f.orders, o)
+		panic(http.ErrAbortHandler) // lost in flight
+	case "private/sell":
+		f.sells++
+		json.NewEncoder(w).Encode(map[string]interface{}{"error": map[string]interface{}{"code": 10009, "message": "not_enough_funds"}})
+	case "private/get_order_state_by_label":
+		var res []map[string]interface{}
+		for _, o := range f.orders {
+			if o["label"] == req.Params["label"] {
+				res = append(res, o)
+			}
+		}
+		f.reply(w, res)
+	case "private/cancel":
+		o := f.orders[0]
+		o["order_state"] = "cancelled"
+		f.reply(w, o)
+	case "private/get_positions":
+		f.reply(w, []map[string]interface{}{
+			{"instrument_name": "BTC-PERPETUAL", "size": -2000, "average_price": 10000},
+			{"instrument_name": "BTC-28JUN19-9000-C", "size": 1.5, "average_price": 0.05},
+		})
+	case "private/get_account_summary":
+		f.reply(w, map[string]interface{}{"currency": "BTC", "balance": 2.5, "equity": 2.6})
+	default:
+		json.NewEncoder(w).Encode(map[string]interface{}{"error": map[string]interface{}{"code": 11050, "message": "bad_request"}})
+	}
+}
+
+// stream authenticates after a delay, sends an order update on each connection then drops it
+func (f *fakeDeribit) stream(w http.ResponseWriter, r *http.Request) {
+	conn, err := ws.Upgrade(w, r)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	authed := false
+	for {
+		b, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		var req struct {
+			ID     int64  `json:"id"`
+			Method string `json:"method"`
+			Params struct {
+				Channels []string `json:"channels"`
+			} `json:"params"`
+		}
+		json.Unmarshal(b, &req)
+		if req.Method == "public/auth" {
+			go func(id int64) {
+				time.Sleep(20 * time.Millisecond)
+				f.m.Lock()
+				authed = true
+				f.m.Unlock()
+				conn.WriteText([]byte(`{"jsonrpc":"2.0","id":` + strconv.FormatInt(id, 10) +
+					`,"result":{"access_token":"token","expires_in":900}}`))
+			}(req.ID)
+			continue
+		}
+		if req.Method != "private/subscribe" {
+			continue
+		}
+		f.m.Lock()
+		if !authed {
+			f.early++
+		}
+		f.subs = append(f.subs, req.Params.Channels)
+		n := len(f.subs)
+		f.m.Unlock()
+		conn.WriteText([]byte(`{"jsonrpc":"2.0","method":"subscription","params":{"channel":"user.orders.any.any.raw","data":` +
+			`{"order_id":"BTC-` + string(rune('0'+n)) + `","instrument_name":"BTC-PERPETUAL","direction":"sell","price":10000,` +
+			`"amount":100,"filled_amount":50,"average_price":10001,"order_state":"open","creation_timestamp":1699999990000,` +
+			`"last_update_timestamp":1700000000000}}}`))
+		conn.WriteText([]byte(`{"jsonrpc":"2.0","method":"subscription","params":{"channel":"user.trades.any.any.raw","data":` +
+			`[{"trade_id":"T` + string(rune('0'+n)) + `","order_id":"BTC-` + string(rune('0'+n)) + `","instrument_name":"BTC-PERPETUAL",` +
+			`"direction":"sell","price":10001,"amount":50,"fee":0.0000025,"fee_currency":"BTC","liquidity":"M","timestamp":1700000000000}]}}`))
+		return
+	}
+}
+
+func TestDeribitTrading(t *testing.T) {
+	fake := &fakeDeribit{t: t}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	c := deribit.NewTradingClient("id", "secret")
+	c.BaseURL = srv.URL
+	c.WSURL = "ws://" + strings.TrimPrefix(srv.URL, "http://") + "/ws"
+	c.ReconnectDelay = 10 * time.Millisecond
+	ctx := context.Background()
+
+	id := c.NewClientID()
+	assert.NotEqual(t, id, c.NewClientID())
+	s, err := c.PlaceOrder(ctx, id, "ETH-PERPETUAL", 2000, 3)
+	assert.NoError(t, err, "found by its label after the failure")
+	assert.Equal(t, "ETH-1", s.OrderID)
+	assert.Equal(t, 3.0, s.LeftAmount)
+	assert.Equal(t, bean.ALIVE, s.State)
+	again, err := c.PlaceOrder(ctx, id, "ETH-PERPETUAL", 2000, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, s, again)
+	assert.Equal(t, 1, fake.buys, "placed once")
+	assert.Len(t, c.OrderSink(time.Second).OpenOrders("ETH-PERPETUAL"), 1)
+
+	// a restarted client keeps the orders it tracked
+	path := filepath.Join(t.TempDir(), "state.json")
+	assert.NoError(t, event.Checkpoint{Parts: map[string]event.Checkpointer{"deribit": c}}.Save(path, time.Now()))
+	restarted := deribit.NewTradingClient("id", "secret")
+	_, err = event.Checkpoint{Parts: map[string]event.Checkpointer{"deribit": restarted}}.Load(path)
+	assert.NoError(t, err)
+	again, err = restarted.PlaceOrder(ctx, id, "ETH-PERPETUAL", 2000, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, s, again, "not placed again")
+	assert.Len(t, restarted.OrderSink(time.Second).OpenOrders("ETH-PERPETUAL"), 1)
+
+	// concurrent calls with a client id wait for the first
+	id = c.NewClientID()
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := c.PlaceOrder(ctx, id, "ETH-PERPETUAL", 2000, 1)
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 2, fake.buys, "placed once")
+	_, err = c.PlaceOrder(ctx, c.NewClientID(), "ETH-PERPETUAL", 2000, -1)
+	assert.True(t, errors.Is(err, bean.ErrInsufficientFunds), "mapped by code")
+	assert.Equal(t, 1, fake.sells, "a rejection is not looked up")
+
+	s, err = c.CancelOrder(ctx, "ETH-1")
+	assert.NoError(t, err)
+	assert.Equal(t, bean.CANCELLED, s.State)
+	assert.Empty(t, c.OrderSink(time.Second).OpenOrders("ETH-PERPETUAL"))
+	_, err = c.OpenOrders(ctx, "ETH-PERPETUAL")
+	var apiErr *deribit.APIError
+	assert.True(t, errors.As(err, &apiErr))
+
+	positions, err := c.Positions(ctx, "BTC")
+	assert.NoError(t, err)
+	assert.Len(t, positions, 2)
+	assert.Equal(t, -200.0, positions[0].Qty())
+	assert.Equal(t, 1.5, positions[1].Qty())
+	acct := bean.NewAccount(bean.DeribitMarginSchedule())
+	assert.NoError(t, c.SyncAccount(ctx, acct, "BTC"))
+	assert.Equal(t, 2.5, acct.Balance(bean.BTC))
+	pos, ok := acct.Position("BTC-PERPETUAL")
+	assert.True(t, ok)
+	assert.Equal(t, -200.0, pos.Qty())
+
+	// the stream reconnects and resubscribes
+	metrics := bean.NewMetrics()
+	bean.SetMetrics(metrics)
+	defer bean.SetMetrics(nil)
+	assert.NoError(t, c.Subscribe("user.portfolio.btc"))
+	sctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	events := c.Events(sctx)
+	for i := 1; i <= 2; i++ {
+		select {
+		case e := <-events:
+			assert.Equal(t, event.OrderEvent, e.Kind)
+			assert.Equal(t, time.Unix(1700000000, 0).UTC(), e.Time, "of the exchange")
+			assert.Equal(t, "BTC-"+string(rune('0'+i)), e.Order.OrderID)
+			assert.Equal(t, bean.PARTIAL, e.Order.State)
+			assert.Equal(t, 5.0, e.Order.FilledAmount)
+			assert.Equal(t, 10001.0, e.Order.Price)
+		case <-time.After(5 * time.Second):
+			t.Fatal("no order update")
+		}
+		select {
+		case e := <-events:
+			assert.Equal(t, event.ExecutionEvent, e.Kind)
+			assert.Equal(t, "T"+string(rune('0'+i)), e.Execution.ExecID)
+			assert.Equal(t, bean.NameDeribit, e.Execution.Exchange)
+			assert.Equal(t, bean.SELL, e.Execution.Side)
+			assert.Equal(t, 5.0, e.Execution.Qty)
+			assert.Equal(t, -5.0, e.Execution.SignedQty())
+			assert.Equal(t, bean.LiquidityMaker, e.Execution.Liquidity)
+			assert.Equal(t, bean.Pair{Coin: bean.BTC, Base: bean.USD}, e.Execution.Pair)
+		case <-time.After(5 * time.Second):
+			t.Fatal("no execution")
+		}
+	}
+	assert.True(t, metrics.Counter(bean.MetricBookResyncs).Value() >= 1, "resubscribed on reconnect")
+	// requests wait for their credits
+	c.Limiter = bean.NewRateLimiter(2*bean.DeribitRequestCost, 0)
+	c.Limiter.Clock = bean.NewSimClock(date("2019-06-01 10:00"))
+	for i := 0; i < 2; i++ {
+		_, err = c.AccountSummary(ctx, "BTC")
+		assert.NoError(t, err)
+	}
+	_, err = c.AccountSummary(ctx, "BTC")
+	assert.True(t, errors.Is(err, bean.ErrRateLimit))
+
+	fake.m.Lock()
+	defer fake.m.Unlock()
+	assert.Equal(t, []string{"user.orders.any.any.raw", "user.portfolio.btc", "user.trades.any.any.raw"}, fake.subs[0])
+	assert.Equal(t, fake.subs[0], fake.subs[1])
+	assert.Equal(t, 0, fake.early, "subscribed once authenticated")
+}
+
+func TestDeribitTokenExpiry(t *testing.T) {
+	fake := &fakeDeribit{t: t}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	c := deribit.NewTradingClient("id", "secret")
+	c.BaseURL = srv.URL
+	clock := bean.NewSimClock(date("2019-06-01 10:00"))
+	c.Clock = clock
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		_, err := c.AccountSummary(ctx, "BTC")
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, 1, fake.auths, "the token is reused")
+	clock.Advance(13 * time.Minute)
+	_, err := c.AccountSummary(ctx, "BTC")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, fake.auths, "valid for two more minutes")
+	clock.Advance(time.Minute + time.Second)
+	_, err = c.AccountSummary(ctx, "BTC")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, fake.auths, "renewed within a minute of its expiry")
+
+	s, err := c.State(ctx, "BTC")
+	assert.NoError(t, err)
+	assert.Equal(t, clock.Now(), s.Time, "of the client clock")
+}
+
+func TestDeribitTicker(t *testing.T) {
+	tk, err := deribit.ParseTicker([]byte(`{"instrument_name":"BTC-PERPETUAL","timestamp":1700000000000,` +
+		`"best_bid_price":36999.5,"best_ask_price":37000,"best_bid_amount":1000,"best_ask_amount":500,"last_price":37000,` +
+		`"mark_price":36999.8,"index_price":36990,"open_interest":500000000,"stats":{"volume":9000,"volume_usd":330000000}}`))
+	assert.NoError(t, err)
+	assert.Equal(t, "BTC-PERPETUAL", tk.Contract.Name())
+	assert.Equal(t, 100.0, tk.BestBidAmount)
+	assert.Equal(t, 5e7, tk.OpenInterest)
+	assert.Equal(t, 3.3e7, tk.Volume24H)
+	assert.Equal(t, time.Unix(1700000000, 0).UTC(), tk.Time)
+
+	tk, err = deribit.ParseTicker([]byte(`{"instrument_name":"BTC-28JUN19-9000-C","timestamp":1,"best_bid_price":0,` +
+		`"best_ask_price":0.01,"last_price":null,"open_interest":12.5,"stats":{"volume":3}}`))
+	assert.NoError(t, err)
+	assert.True(t, math.IsNaN(tk.BestBid))
+	assert.True(t, math.IsNaN(tk.LastPrice))
+	assert.Equal(t, 12.5, tk.OpenInterest)
+	assert.Equal(t, 3.0, tk.Volume24H)
+	_, err = deribit.ParseTicker([]byte(`{"instrument_name":"nope"}`))
+	assert.Error(t, err)
+}
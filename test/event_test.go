@@ -0,0 +1,457 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"bean"
+	"bean/db/store"
+	"bean/event"
+	"github.com/stretchr/testify/assert"
+)
+
+// bookTaker buys once when the ask falls below a level and records what it sees
+type bookTaker struct {
+	sink   event.OrderSink
+	level  float64
+	placed bool
+	books  int
+	timers int
+	fills  []bean.OrderStatus
+}
+
+func (s *bookTaker) Init(sink event.OrderSink) { s.sink = sink }
+func (s *bookTaker) OnTimer(t time.Time)       { s.timers++ }
+func (s *bookTaker) OnTrade(instrument string, txn bean.Transaction) {}
+
+func (s *bookTaker) OnBook(instrument string, ob bean.OrderBookT) {
+	s.books++
+	if !s.placed && ob.BestAsk().Price < s.level {
+		s.placed = true
+		s.sink.PlaceOrder(instrument, ob.BestAsk().Price, 100)
+		s.sink.PlaceOrder(instrument, ob.BestBid().Price-10, 100) // rests
+	}
+}
+
+func (s *bookTaker) OnOrder(status bean.OrderStatus) {
+	if status.State == bean.FILLED {
+		s.fills = append(s.fills, status)
+	}
+}
+
+// execTaker is a bookTaker also receiving the execution reports of its fills
+type execTaker struct {
+	bookTaker
+	execs []bean.ExecutionReport
+}
+
+func (s *execTaker) OnExecution(r bean.ExecutionReport) { s.execs = append(s.execs, r) }
+
+func TestRunner(t *testing.T) {
+	t0 := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	perp := "BTC-PERPETUAL"
+	var books bean.OrderBookTS
+	for i, mid := range []float64{10000, 9900, 9950, 9990} {
+		books = append(books, bean.OrderBookT{
+			OrderBook: bean.NewOrderBook([]bean.Order{{Price: mid - 1, Amount: 1000}}, []bean.Order{{Price: mid + 1, Amount: 1000}}),
+			Time:      t0.Add(time.Duration(i) * time.Minute),
+		})
+	}
+	trades := bean.Transactions{{Price: 9880, Amount: 500, TimeStamp: t0.Add(150 * time.Second), Maker: bean.Buyer}}
+	rp := event.NewReplayer()
+	rp.AddBooks(perp, books)
+	rp.AddTrades(perp, trades)
+	assert.Equal(t, 5, rp.Len())
+
+	acct := bean.NewAccount(bean.DeribitMarginSchedule())
+	acct.Deposit(bean.BTC, 1)
+	broker := event.NewSimBroker(acct, bean.FeeRate{TakerBps: 5})
+	s := &bookTaker{level: 9950}
+	r := event.NewRunner(rp, broker, time.Minute)
+	assert.NoError(t, r.Run(context.Background(), s))
+
+	assert.Equal(t, 4, s.books)
+	assert.Equal(t, 3, s.timers)
+	assert.Len(t, s.fills, 2)
+	assert.Equal(t, 9901.0, s.fills[0].Price) // taker at the ask
+	assert.Equal(t, 9889.0, s.fills[1].Price) // maker through the trade
+	pos, ok := acct.Position(perp)
+	assert.True(t, ok)
+	assert.Equal(t, 200.0, pos.Qty())
+	assert.InDelta(t, 5e-4*1000/9901.0, acct.Fees(bean.BTC), 1e-12)
+	assert.Empty(t, broker.OpenOrders(perp))
+
+	// fills are also reported as executions to the strategies handling them
+	acct = bean.NewAccount(bean.DeribitMarginSchedule())
+	acct.Deposit(bean.BTC, 1)
+	es := &execTaker{bookTaker: bookTaker{level: 9950}}
+	assert.NoError(t, event.NewRunner(rp, event.NewSimBroker(acct, bean.FeeRate{TakerBps: 5}), time.Minute).Run(context.Background(), es))
+	assert.Len(t, es.fills, 2)
+	if assert.Len(t, es.execs, 2) {
+		assert.Equal(t, bean.NameSim, es.execs[0].Exchange)
+		assert.Equal(t, es.fills[0].OrderID, es.execs[0].OrderID)
+		assert.Equal(t, bean.LiquidityTaker, es.execs[0].Liquidity)
+		assert.Equal(t, bean.LiquidityMaker, es.execs[1].Liquidity)
+		assert.Equal(t, 9889.0, es.execs[1].Price)
+		assert.Equal(t, 100.0, es.execs[1].Qty)
+	}
+
+	// a cancelled run stops with the context error
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Error(t, event.NewRunner(rp, event.NewSimBroker(acct, bean.FeeRate{}), 0).Run(ctx, &bookTaker{}))
+}
+
+// failingAudit is an AuditLog failing once it holds n records
+type failingAudit struct {
+	n    int
+	recs []bean.AuditRecord
+}
+
+func (a *failingAudit) Append(r bean.AuditRecord) error {
+	if len(a.recs) == a.n {
+		return errors.New("disk full")
+	}
+	a.recs = append(a.recs, r)
+	return nil
+}
+
+func (a *failingAudit) Query(q bean.AuditQuery) ([]bean.AuditRecord, error) { return a.recs, nil }
+func (a *failingAudit) Close() error                                          { return nil }
+
+func TestRunnerAudit(t *testing.T) {
+	t0 := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	perp := "BTC-PERPETUAL"
+	rp := event.NewReplayer()
+	for i, mid := range []float64{10000, 9900, 9950, 9990} {
+		rp.AddBooks(perp, bean.OrderBookTS{{
+			OrderBook: bean.NewOrderBook([]bean.Order{{Price: mid - 1, Amount: 1000}}, []bean.Order{{Price: mid + 1, Amount: 1000}}),
+			Time:      t0.Add(time.Duration(i) * time.Minute),
+		}})
+	}
+	rp.AddTrades(perp, bean.Transactions{{Price: 9880, Amount: 500, TimeStamp: t0.Add(150 * time.Second), Maker: bean.Buyer}})
+
+	// the broker records the order updates and fills it sends
+	audit, err := store.OpenJSONLAudit(filepath.Join(t.TempDir(), "audit.jsonl"))
+	assert.NoError(t, err)
+	defer audit.Close()
+	acct := bean.NewAccount(bean.DeribitMarginSchedule())
+	acct.Deposit(bean.BTC, 1)
+	es := &execTaker{bookTaker: bookTaker{level: 9950}}
+	r := event.NewRunner(rp, event.NewSimBroker(acct, bean.FeeRate{TakerBps: 5}), time.Minute)
+	r.SetAudit(audit)
+	assert.NoError(t, r.Run(context.Background(), es))
+	if assert.Len(t, es.execs, 2) {
+		recs, err := audit.Query(bean.AuditQuery{OrderID: es.execs[1].OrderID})
+		assert.NoError(t, err)
+		if assert.Len(t, recs, 3) {
+			assert.Equal(t, bean.ALIVE, recs[0].State)
+			assert.Equal(t, bean.AuditExecution, recs[1].Kind)
+			assert.Equal(t, es.execs[1], *recs[1].Execution)
+			assert.Equal(t, bean.FILLED, recs[2].State)
+		}
+	}
+
+	// a failure to record stops the run and the orders
+	acct = bean.NewAccount(bean.DeribitMarginSchedule())
+	acct.Deposit(bean.BTC, 1)
+	broker := event.NewSimBroker(acct, bean.FeeRate{})
+	r = event.NewRunner(rp, broker, time.Minute)
+	r.SetAudit(&failingAudit{n: 1})
+	err = r.Run(context.Background(), &bookTaker{level: 9950})
+	assert.EqualError(t, err, "audit: disk full")
+	_, err = broker.PlaceOrder(perp, 9000, 1)
+	assert.Equal(t, broker.AuditErr(), err)
+}
+
+// clockReader reads the time of a clock on each book and timer
+type clockReader struct {
+	bookTaker
+	clock bean.Clock
+	times []time.Time
+}
+
+func (s *clockReader) OnBook(instrument string, ob bean.OrderBookT) { s.times = append(s.times, s.clock.Now()) }
+func (s *clockReader) OnTimer(t time.Time)                         { s.times = append(s.times, s.clock.Now()) }
+
+func TestRunnerClock(t *testing.T) {
+	t0 := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	rp := event.NewReplayer()
+	rp.AddBooks("BTC-PERPETUAL", bean.OrderBookTS{{Time: t0}, {Time: t0.Add(90 * time.Second)}})
+	acct := bean.NewAccount(bean.DeribitMarginSchedule())
+	r := event.NewRunner(rp, event.NewSimBroker(acct, bean.FeeRate{}), time.Minute)
+	s := &clockReader{clock: r.Clock()}
+	assert.NoError(t, r.Run(context.Background(), s))
+	assert.Equal(t, []time.Time{t0, t0.Add(time.Minute), t0.Add(90 * time.Second)}, s.times)
+}
+
+func TestSimBrokerLatencySlippage(t *testing.T) {
+	t0 := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	perp := "BTC-PERPETUAL"
+	book := func(s int, ask float64) bean.OrderBookT {
+		return bean.OrderBookT{OrderBook: bean.NewOrderBook([]bean.Order{{Price: ask - 2, Amount: 1000}},
+			[]bean.Order{{Price: ask, Amount: 1000}}), Time: t0.Add(time.Duration(s) * time.Second)}
+	}
+	acct := bean.NewAccount(bean.DeribitMarginSchedule())
+	acct.Deposit(bean.BTC, 1)
+	broker := event.NewSimBroker(acct, bean.FeeRate{})
+	broker.SetLatency(bean.ConstantLatency(time.Second))
+	broker.SetSlippage(bean.FixedSlippage(10))
+	var execs []bean.ExecutionReport
+	broker.SetNotify(func(e event.Event) {
+		if e.Kind == event.ExecutionEvent {
+			execs = append(execs, e.Execution)
+		}
+	})
+	broker.OnBook(perp, book(0, 10000))
+	_, err := broker.PlaceOrder(perp, 10100, 100)
+	assert.NoError(t, err)
+	assert.Empty(t, execs, "on its way to the book")
+	assert.Len(t, broker.OpenOrders(perp), 1)
+
+	// the order arrives before the next book and lifts the offer of the time, moved by the slippage
+	broker.OnBook(perp, book(2, 10050))
+	if assert.Len(t, execs, 1) {
+		assert.InDelta(t, 10000*1.001, execs[0].Price, 1e-9)
+		assert.Equal(t, t0.Add(time.Second), execs[0].Time)
+	}
+	assert.Empty(t, broker.OpenOrders(perp))
+}
+
+func TestReplayerMerge(t *testing.T) {
+	t0 := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	book := func(s int) bean.OrderBookT {
+		return bean.OrderBookT{Time: t0.Add(time.Duration(s) * time.Second), OrderBook: bean.NewOrderBook(
+			[]bean.Order{{Price: 9999, Amount: 1000}}, []bean.Order{{Price: 10001, Amount: 1000}})}
+	}
+	rp := event.NewReplayer()
+	rp.AddBooks("BTC-PERPETUAL", bean.OrderBookTS{book(2), book(0)})
+	rp.AddFunding("BTC-PERPETUAL", []event.Funding{{Time: t0.Add(time.Second), Rate: 0.001, Mark: 10000}})
+	rp.AddTrades("ETH-PERPETUAL", bean.Transactions{{Price: 200, Amount: 1, TimeStamp: t0.Add(time.Second)}})
+	rp.AddBooks("ETH-PERPETUAL", bean.OrderBookTS{book(1)})
+
+	var got []string
+	for e := range rp.Events(context.Background()) {
+		got = append(got, e.Time.Format("05")+" "+e.Instrument+" "+[]string{"book", "trade", "order", "funding"}[e.Kind])
+	}
+	assert.Equal(t, []string{
+		"00 BTC-PERPETUAL book",
+		"01 ETH-PERPETUAL book",
+		"01 ETH-PERPETUAL trade",
+		"01 BTC-PERPETUAL funding",
+		"02 BTC-PERPETUAL book",
+	}, got)
+
+	// funding reaches the simulated account
+	acct := bean.NewAccount(bean.DeribitMarginSchedule())
+	acct.Deposit(bean.BTC, 1)
+	perp, _ := bean.ContractFromName("BTC-PERPETUAL")
+	acct.Fill(perp, 1000, 10000, 0)
+	broker := event.NewSimBroker(acct, bean.FeeRate{})
+	assert.NoError(t, event.NewRunner(rp, broker, 0).Run(context.Background(), &bookTaker{}))
+	assert.InDelta(t, 1000*10/10000.0*0.001, acct.FundingPaid(bean.BTC), 1e-12)
+
+	// step mode releases one event per step, and steps outside a replay do not block
+	assert.False(t, rp.Step())
+	rp.SetMode(event.ReplayStep, 0)
+	assert.False(t, rp.Step())
+	events := rp.Events(context.Background())
+	for i := 0; i < rp.Len(); i++ {
+		assert.True(t, rp.Step())
+		<-events
+	}
+	assert.False(t, rp.Step())
+
+	// real time at 20x spaces the 2 seconds over 100ms of the replay clock
+	clock := bean.NewSimClock(t0)
+	rp.Clock = clock
+	rp.SetMode(event.ReplayRealTime, 20)
+	events = rp.Events(context.Background())
+	next := func() (event.Event, bool) {
+		select {
+		case e := <-events:
+			return e, true
+		case <-time.After(20 * time.Millisecond):
+			return event.Event{}, false
+		}
+	}
+	e, ok := next()
+	assert.True(t, ok && e.Time.Equal(t0))
+	clock.Advance(49 * time.Millisecond)
+	_, ok = next()
+	assert.False(t, ok, "one second in is 50ms away")
+	clock.Advance(time.Millisecond)
+	for i := 0; i < 3; i++ {
+		e, ok = next()
+		assert.True(t, ok && e.Time.Equal(t0.Add(time.Second)))
+	}
+	clock.Advance(50 * time.Millisecond)
+	e, ok = next()
+	assert.True(t, ok && e.Time.Equal(t0.Add(2*time.Second)))
+	_, ok = <-events
+	assert.False(t, ok)
+}
+
+// counterStrategy keeps a count as its own state
+type counterStrategy struct {
+	bookTaker
+	count int
+}
+
+func (s *counterStrategy) Snapshot() (json.RawMessage, error) { return json.Marshal(s.count) }
+func (s *counterStrategy) Restore(state json.RawMessage) error {
+	return json.Unmarshal(state, &s.count)
+}
+
+func TestCheckpoint(t *testing.T) {
+	t0 := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	perp := "BTC-PERPETUAL"
+	ob := bean.OrderBookT{
+		OrderBook: bean.NewOrderBook([]bean.Order{{Price: 9999, Amount: 1000}}, []bean.Order{{Price: 10001, Amount: 1000}}),
+		Time:      t0,
+	}
+	acct := bean.NewAccount(bean.DeribitMarginSchedule())
+	acct.Deposit(bean.BTC, 1)
+	broker := event.NewSimBroker(acct, bean.FeeRate{})
+	broker.OnBook(perp, ob)
+	broker.PlaceOrder(perp, 10001, 100)
+	oid, _ := broker.PlaceOrder(perp, 9900, 200)
+	broker.OnTrade(perp, bean.Transaction{Price: 9890, Amount: 50, TimeStamp: t0.Add(time.Second), Maker: bean.Buyer})
+	blotter := bean.NewBlotter()
+	blotter.Add(bean.TradeLog{Pair: bean.Pair{Coin: bean.BTC, Base: bean.USD}, Price: 10001, Quantity: 0.01, Side: bean.BUY})
+	runner := event.NewRunner(event.ChanSource(nil), broker, time.Minute)
+	runner.SetNextTimer(t0.Add(time.Minute))
+	cp := event.Checkpoint{Runner: runner, Broker: broker, Blotter: blotter, Strategy: &counterStrategy{count: 7}}
+
+	path := filepath.Join(t.TempDir(), "state.json")
+	_, err := event.LoadState(path)
+	assert.True(t, errors.Is(err, fs.ErrNotExist))
+	assert.NoError(t, cp.Save(path, t0.Add(time.Second)))
+
+	// a restarted service picks up where it stopped
+	acct2 := bean.NewAccount(bean.DeribitMarginSchedule())
+	broker2 := event.NewSimBroker(acct2, bean.FeeRate{})
+	strat2 := &counterStrategy{}
+	cp2 := event.Checkpoint{Runner: event.NewRunner(event.ChanSource(nil), broker2, time.Minute), Broker: broker2,
+		Blotter: bean.NewBlotter(), Strategy: strat2}
+	asof, err := cp2.Load(path)
+	assert.NoError(t, err)
+	assert.True(t, asof.Equal(t0.Add(time.Second)))
+	assert.Equal(t, 7, strat2.count)
+	assert.True(t, cp2.Runner.NextTimer().Equal(t0.Add(time.Minute)))
+	assert.Equal(t, acct.State(), acct2.State())
+	assert.Equal(t, blotter.Position(bean.Pair{Coin: bean.BTC, Base: bean.USD}), cp2.Blotter.Position(bean.Pair{Coin: bean.BTC, Base: bean.USD}))
+	orders := broker2.Orders()
+	if assert.Len(t, orders, 1) {
+		assert.Equal(t, oid, orders[0].OrderID)
+		assert.Equal(t, 50.0, orders[0].FilledAmount)
+		assert.Equal(t, 150.0, orders[0].LeftAmount)
+	}
+	assert.Equal(t, 10001.0, broker2.Books()[perp].BestAsk().Price)
+
+	// the restored order keeps filling and new ids do not collide
+	broker2.OnTrade(perp, bean.Transaction{Price: 9890, Amount: 150, TimeStamp: t0.Add(2 * time.Second), Maker: bean.Buyer})
+	assert.Empty(t, broker2.Orders())
+	pos, _ := acct2.Position(perp)
+	assert.Equal(t, 300.0, pos.Qty())
+	next, _ := broker2.PlaceOrder(perp, 9000, 10)
+	assert.NotEqual(t, oid, next)
+}
+
+func TestConflator(t *testing.T) {
+	c := event.NewConflator()
+	fast := c.Subscribe(event.SubscribeOptions{Buffer: 100})
+	slow := c.Subscribe(event.SubscribeOptions{Rate: 20, Depth: 1, Buffer: 10})
+	lossy := c.Subscribe(event.SubscribeOptions{Buffer: 2, Policy: event.DropNewest})
+	assert.Equal(t, 3, c.Subscribers())
+
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 50; i++ {
+		ob := bean.NewOrderBook([]bean.Order{{Price: 100 + float64(i), Amount: 1}, {Price: 90, Amount: 1}},
+			[]bean.Order{{Price: 200, Amount: 1}})
+		c.OnBook("BTC-PERPETUAL", bean.OrderBookT{OrderBook: ob, Time: t0.Add(time.Duration(i) * time.Millisecond)})
+	}
+	assert.Len(t, fast.Events(), 50)
+	assert.Len(t, lossy.Events(), 2)
+	assert.Equal(t, int64(48), lossy.Dropped())
+
+	select {
+	case e := <-slow.Events():
+		assert.Equal(t, event.BookEvent, e.Kind)
+		assert.Equal(t, 149.0, e.Book.BestBid().Price)
+		assert.Len(t, e.Book.Bids(), 1)
+	case <-time.After(time.Second):
+		t.Fatal("no conflated book")
+	}
+	assert.Len(t, slow.Events(), 0)
+
+	c.OnTrade("BTC-PERPETUAL", bean.Transaction{Price: 150, Amount: 1, TimeStamp: t0})
+	e := <-slow.Events()
+	assert.Equal(t, event.TradeEvent, e.Kind)
+
+	for _, s := range []*event.Subscription{fast, slow, lossy} {
+		s.Close()
+	}
+	assert.Equal(t, 0, c.Subscribers())
+	_, ok := <-slow.Events()
+	assert.False(t, ok)
+}
+
+func TestPaperBroker(t *testing.T) {
+	perp, call := "BTC-PERPETUAL", "BTC-28JUN19-9000-C"
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := bean.NewSimClock(t0.Add(time.Minute))
+
+	acct := bean.NewAccount(bean.DeribitMarginSchedule())
+	acct.Deposit(bean.BTC, 1)
+	paper := event.NewPaperBroker(acct, bean.FeeRate{}, clock)
+	feed := func(events ...event.Event) {
+		live := make(event.ChanSource, len(events))
+		for _, e := range events {
+			live <- e
+		}
+		close(live)
+		paper.Run(context.Background(), live)
+	}
+	feed(event.Event{Kind: event.BookEvent, Time: t0, Instrument: perp, Book: bean.OrderBookT{
+		OrderBook: bean.NewOrderBook([]bean.Order{{Price: 9999, Amount: 1000}}, []bean.Order{{Price: 10001, Amount: 1000}}),
+		Time:      t0,
+	}}, event.Event{Kind: event.BookEvent, Time: t0, Instrument: call, Book: bean.OrderBookT{
+		OrderBook: bean.NewOrderBook([]bean.Order{{Price: 0.04, Amount: 10}}, []bean.Order{{Price: 0.05, Amount: 10}}),
+		Time:      t0,
+	}})
+
+	_, err := paper.PlaceOrder(perp, 10001, 100)
+	assert.NoError(t, err)
+	_, err = paper.PlaceOrder(call, 0.05, 2)
+	assert.NoError(t, err)
+	oid, _ := paper.PlaceOrder(perp, 9900, 200)
+	feed(event.Event{Kind: event.TradeEvent, Time: t0.Add(2 * time.Minute), Instrument: perp,
+		Trade: bean.Transaction{Price: 9890, Amount: 50, TimeStamp: t0.Add(2 * time.Minute), Maker: bean.Buyer}})
+
+	trades := paper.Blotter().Trades()
+	if assert.Len(t, trades, 3) {
+		assert.Equal(t, 10001.0, trades[0].Price)
+		assert.Equal(t, t0.Add(time.Minute), trades[0].Time)
+		assert.Equal(t, call, trades[1].Symbol)
+		assert.Equal(t, oid, trades[2].OrderID)
+		assert.InDelta(t, 50*10/9900.0, trades[2].Quantity, 1e-12, "in coins")
+	}
+	blotter := paper.Blotter()
+	assert.Equal(t, []string{call, perp}, blotter.Instruments())
+	assert.InDelta(t, 100*10/10001.0+50*10/9900.0, blotter.InstrumentPosition(perp), 1e-12)
+	assert.Equal(t, 2.0, blotter.InstrumentPosition(call))
+	assert.InDelta(t, 2*(0.06-0.05), blotter.InstrumentPnL(call, 0.06), 1e-12, "in coins")
+	assert.InDelta(t, 100*10/10001.0+50*10/9900.0, blotter.Position(bean.Pair{Coin: bean.BTC, Base: bean.USD}), 1e-12,
+		"options are not in the pair totals")
+	pos, ok := acct.Position(perp)
+	assert.True(t, ok)
+	assert.Equal(t, 150.0, pos.Qty())
+	assert.NoError(t, paper.CancelOrder(perp, oid))
+	assert.Empty(t, paper.OpenOrders(perp))
+}
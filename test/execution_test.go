@@ -0,0 +1,117 @@
+package test
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"bean"
+	"bean/execution"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTWAPAndIceberg(t *testing.T) {
+	start := time.Date(2019, 5, 1, 0, 0, 0, 0, time.UTC)
+	var obts bean.OrderBookTS
+	for i := 0; i < 60; i++ {
+		ob := bean.NewOrderBook(
+			[]bean.Order{{Price: 99, Amount: 1}, {Price: 98, Amount: 5}},
+			[]bean.Order{{Price: 101, Amount: 1}, {Price: 102, Amount: 5}})
+		obts = append(obts, bean.OrderBookT{OrderBook: ob, Time: start.Add(time.Duration(i) * time.Minute)})
+	}
+
+	twap := execution.NewTWAP(4, start, start.Add(40*time.Minute), 4, 0.015)
+	fills := execution.Simulate(twap, obts)
+	assert.Equal(t, 4, len(fills))
+	assert.True(t, twap.Done())
+	for _, f := range fills {
+		assert.Equal(t, 1.0, f.Amount)
+		assert.Equal(t, 101.0, f.Price)
+	}
+
+	// slippage bound of 1.1% keeps the iceberg on the first level only
+	ice := execution.NewIceberg(-3, 2, math.NaN(), 0.011)
+	fills = execution.Simulate(ice, obts)
+	assert.Equal(t, 3, len(fills))
+	assert.True(t, ice.Done())
+	assert.Equal(t, 99.0, fills[0].Price)
+}
+
+func TestRouter(t *testing.T) {
+	r := execution.NewRouter()
+	r.SetBook("A", bean.NewOrderBook(nil, []bean.Order{{Price: 100, Amount: 1}, {Price: 103, Amount: 5}}))
+	r.SetBook("B", bean.NewOrderBook(nil, []bean.Order{{Price: 101, Amount: 2}, {Price: 102, Amount: 5}}))
+	r.SetFee("B", bean.FeeRate{TakerBps: 50})
+
+	// B at 101 costs 101.505 with fees, so A's 103 level is only reached after B's 102 level (102.51)
+	orders, unfilled := r.Route(4)
+	assert.Equal(t, 0.0, unfilled)
+	assert.Equal(t, 2, len(orders))
+	assert.Equal(t, "A", orders[0].Venue)
+	assert.Equal(t, 1.0, orders[0].Amount)
+	assert.Equal(t, "B", orders[1].Venue)
+	assert.Equal(t, 3.0, orders[1].Amount)
+	assert.Equal(t, 102.0, orders[1].Price)
+	assert.InDelta(t, (2*101.0+102)/3, orders[1].AvgPrice, 1e-9)
+
+	_, unfilled = r.Route(20)
+	assert.Equal(t, 7.0, unfilled)
+}
+
+func TestLiquiditySummary(t *testing.T) {
+	t0 := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := execution.NewLiquiditySummary(50, time.Hour)
+	s.SetBook("BTC-USDT", "A", bean.OrderBookT{Time: t0, OrderBook: bean.NewOrderBook(
+		[]bean.Order{{Price: 99.9, Amount: 1}, {Price: 99.6, Amount: 2}, {Price: 99, Amount: 10}},
+		[]bean.Order{{Price: 100.1, Amount: 1}, {Price: 100.4, Amount: 3}, {Price: 101, Amount: 10}})})
+	s.SetBook("BTC-USDT", "B", bean.OrderBookT{Time: t0, OrderBook: bean.NewOrderBook(
+		[]bean.Order{{Price: 99.5, Amount: 2}}, []bean.Order{{Price: 100.5, Amount: 2}})})
+	s.SetBook("ETH-USDT", "A", bean.OrderBookT{Time: t0, OrderBook: bean.NewOrderBook(
+		[]bean.Order{{Price: 9.99, Amount: 5}}, []bean.Order{{Price: 10.01, Amount: 5}})})
+	s.OnTrade("BTC-USDT", "B", bean.Transaction{Price: 100, Amount: -2, TimeStamp: t0.Add(-2 * time.Hour)})
+	s.OnTrade("BTC-USDT", "B", bean.Transaction{Price: 100, Amount: 3, TimeStamp: t0.Add(-30 * time.Minute)})
+	s.OnTrade("BTC-USDT", "B", bean.Transaction{Price: 101, Amount: -1, TimeStamp: t0})
+	s.OnTrade("BTC-USDT", "C", bean.Transaction{Price: 100, Amount: 1, TimeStamp: t0})
+
+	r := s.Report(t0)
+	assert.Len(t, r, 4)
+	assert.Equal(t, []string{"A", "B", "C", "A"}, []string{r[0].Venue, r[1].Venue, r[2].Venue, r[3].Venue})
+	a := r[0]
+	assert.Equal(t, "BTC-USDT", a.Instrument)
+	assert.InDelta(t, 0.2, a.Spread, 1e-9)
+	assert.InDelta(t, 20, a.SpreadBps, 1e-9)
+	// 50bps of 100 reaches 99.5 and 100.5
+	assert.Equal(t, 3.0, a.BidDepth)
+	assert.Equal(t, 4.0, a.AskDepth)
+	b := r[1]
+	assert.Equal(t, 4.0, b.Depth())
+	assert.Equal(t, 2, b.Trades)
+	assert.Equal(t, 401.0, b.Turnover)
+	assert.True(t, math.IsNaN(r[2].Spread))
+	assert.Equal(t, 1, r[2].Trades)
+
+	top := s.Top("BTC-USDT", 2, t0)
+	assert.Len(t, top, 2)
+	assert.Equal(t, "B", top[1].Venue)
+	assert.Len(t, s.Top("ETH-USDT", 0, t0), 1)
+	// the trades fall out of the window
+	assert.Equal(t, 0, s.Top("BTC-USDT", 0, t0.Add(2*time.Hour))[1].Trades)
+
+	// deribit perpetual amounts are contracts of 10 USD, converted to coins to rank against a linear venue
+	s = execution.NewLiquiditySummary(50, time.Hour)
+	s.SetBook("BTC-PERPETUAL", "deribit", bean.OrderBookT{Time: t0, OrderBook: bean.NewOrderBook(
+		[]bean.Order{{Price: 10000, Amount: 5000}}, []bean.Order{{Price: 10010, Amount: 5000}})})
+	s.SetBook("BTC-PERPETUAL", "linear", bean.OrderBookT{Time: t0, OrderBook: bean.NewOrderBook(
+		[]bean.Order{{Price: 10000, Amount: 6}}, []bean.Order{{Price: 10010, Amount: 6}})})
+	s.Coins = func(instrument, venue string, amount, price float64) float64 {
+		if venue == "linear" {
+			return amount
+		}
+		return bean.CoinAmount(instrument, amount, price)
+	}
+	s.OnTrade("BTC-PERPETUAL", "deribit", bean.Transaction{Price: 10000, Amount: 2000, TimeStamp: t0})
+	r = s.Report(t0)
+	assert.Equal(t, "linear", r[0].Venue)
+	assert.InDelta(t, 5+50000/10010.0, r[1].Depth(), 1e-9)
+	assert.InDelta(t, 20000, r[1].Turnover, 1e-9)
+}
@@ -0,0 +1,50 @@
+package test
+
+import (
+	"bytes"
+	"encoding/csv"
+	"testing"
+	"time"
+
+	"bean"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCSVExports(t *testing.T) {
+	asof := time.Date(2019, 5, 1, 8, 0, 0, 0, time.UTC)
+	btc := bean.Pair{Coin: bean.BTC, Base: bean.USD}
+	expiry := time.Date(2019, 5, 31, 8, 0, 0, 0, time.UTC)
+	positions := []bean.Position{
+		bean.NewPosition(bean.OptContract(btc, expiry, 5000, bean.Call), 2, 0.05),
+		bean.NewPosition(bean.OptContract(btc, expiry, 4500, bean.Put), -1, 0.02),
+		bean.NewPosition(bean.PerpContract(btc), -1000, 5000),
+	}
+	mkt := func(bean.Position) (float64, float64, float64) { return 5000, 5050, 0.8 }
+
+	var b bytes.Buffer
+	assert.NoError(t, bean.WritePositionsCSV(&b, positions, asof, mkt))
+	rows, err := csv.NewReader(&b).ReadAll()
+	assert.NoError(t, err)
+	assert.Len(t, rows, 4)
+	assert.Len(t, rows[0], len(bean.PositionsSchema))
+	assert.Equal(t, "2019-05-31T08:00:00Z", rows[1][2])
+	assert.Equal(t, "", rows[3][2]) // perpetual has no expiry
+	assert.Equal(t, "", rows[3][3])
+
+	b.Reset()
+	assert.NoError(t, bean.WriteGreeksLadderCSV(&b, positions, asof, mkt))
+	rows, _ = csv.NewReader(&b).ReadAll()
+	assert.Equal(t, []string{"expiry", "pv", "delta", "gamma", "vega", "theta"}, rows[0])
+	assert.Len(t, rows, 3)
+	assert.Equal(t, "", rows[1][0])
+
+	b.Reset()
+	assert.NoError(t, bean.WriteVolSurfaceCSV(&b, bean.FlatVol(0.7), asof, []time.Time{expiry}, []float64{4000, 5000},
+		func(time.Time) float64 { return 5050 }))
+	rows, _ = csv.NewReader(&b).ReadAll()
+	assert.Equal(t, []string{"2019-05-31T08:00:00Z", "0.0821917808219178", "5000", "5050", "0.7"}, rows[2])
+
+	b.Reset()
+	assert.NoError(t, bean.VolSurfaceSchema.WriteJSON(&b))
+	assert.Contains(t, b.String(), `{"name":"expiry","type":"datetime"}`)
+}
@@ -0,0 +1,70 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"bean"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFootprint(t *testing.T) {
+	t0 := time.Date(2019, 5, 1, 8, 0, 0, 0, time.UTC)
+	trade := func(sec int, price, amount float64, maker bean.TraderType) bean.Transaction {
+		return bean.Transaction{Price: price, Amount: amount, TimeStamp: t0.Add(time.Duration(sec) * time.Second),
+			Maker: maker}
+	}
+	f := bean.NewFootprint(time.Minute, 10)
+	var closed []bean.FootprintBar
+	f.OnBar(func(b bean.FootprintBar) { closed = append(closed, b) })
+
+	f.OnTrade(trade(1, 5001, 2, bean.Seller)) // buy at 5000
+	f.OnTrade(trade(5, 5012, 1, bean.Seller)) // buy at 5010
+	f.OnTrade(trade(30, 4998, 3, bean.Buyer)) // sell at 5000
+	assert.Empty(t, closed)
+	f.OnTrade(trade(70, 5020, 4, bean.Buyer))
+	f.OnTrade(trade(10, 4000, 4, bean.Buyer)) // in a closed bar
+	assert.Len(t, closed, 1)
+
+	bar := closed[0]
+	assert.Equal(t, t0, bar.Start)
+	assert.Equal(t, t0.Add(time.Minute), bar.End)
+	assert.Equal(t, 3, bar.Trades)
+	assert.Equal(t, []float64{5001, 5012, 4998, 4998}, []float64{bar.Open, bar.High, bar.Low, bar.Close})
+	assert.Equal(t, bean.VolumeProfile{{Price: 5000, Bid: 3, Ask: 2}, {Price: 5010, Ask: 1}}, bar.Levels)
+	assert.Equal(t, 6.0, bar.Levels.Volume())
+	assert.Equal(t, 0.0, bar.Levels.Delta())
+	assert.Equal(t, 5000.0, bar.Levels.POC())
+
+	bars := f.Bars()
+	assert.Len(t, bars, 2)
+	assert.Equal(t, bean.VolumeProfile{{Price: 5020, Bid: 4}}, bars[1].Levels)
+	f.Flush()
+	assert.Len(t, closed, 2)
+
+	profile := f.Profile()
+	assert.Equal(t, bean.VolumeProfile{{Price: 5000, Bid: 3, Ask: 2}, {Price: 5010, Ask: 1}, {Price: 5020, Bid: 4}},
+		profile)
+	// 5000 holds half the volume, the area grows up as there is nothing below
+	low, high := profile.ValueArea(0.5)
+	assert.Equal(t, []float64{5000, 5000}, []float64{low, high})
+	low, high = profile.ValueArea(0.7)
+	assert.Equal(t, []float64{5000, 5020}, []float64{low, high})
+
+	var buf bytes.Buffer
+	assert.NoError(t, bean.WriteFootprintCSV(&buf, f.Bars()))
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Equal(t, "start,end,price,bid,ask,delta", lines[0])
+	assert.Equal(t, "2019-05-01T08:00:00Z,2019-05-01T08:01:00Z,5000,3,2,-1", lines[1])
+	assert.Len(t, lines, 4)
+
+	buf.Reset()
+	assert.NoError(t, bean.WriteFootprintJSON(&buf, f.Bars()))
+	var decoded []bean.FootprintBar
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &decoded))
+	assert.Equal(t, f.Bars()[0].Levels, decoded[0].Levels)
+	assert.True(t, decoded[1].Start.Equal(t0.Add(time.Minute)))
+}
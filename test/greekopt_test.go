@@ -0,0 +1,64 @@
+package test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"bean"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGreekOptimizer(t *testing.T) {
+	asof := time.Date(2019, 5, 1, 8, 0, 0, 0, time.UTC)
+	call, _ := bean.ContractFromName("BTC-28JUN19-5500-C")
+	put, _ := bean.ContractFromName("BTC-28JUN19-5000-P")
+	perp, _ := bean.ContractFromName("BTC-PERPETUAL")
+	m := bean.NewMarket(asof)
+	m.SetSpot(call.Underlying(), 5500)
+	m.SetVolSurface(call.Underlying(), bean.FlatVol(0.8))
+
+	// short calls: short delta and vega
+	p := bean.NewPortfolio()
+	p.AddPosition(bean.NewPosition(call, -10, 0.1))
+	perpBook := bean.NewOrderBook(
+		[]bean.Order{{Price: 5499.5, Amount: 10000}, {Price: 5495, Amount: 100000}},
+		[]bean.Order{{Price: 5500.5, Amount: 10000}, {Price: 5505, Amount: 100000}})
+	// the put quoted either side of its model price, in coins
+	mid := bean.NewPosition(put, 1, 0).PVMarket(m) / 5500
+	putBook := bean.NewOrderBook([]bean.Order{{Price: mid - 0.0025, Amount: 50}}, []bean.Order{{Price: mid + 0.0025, Amount: 50}})
+	tradables := []bean.Tradable{
+		{Contract: put, Book: putBook},
+		{Contract: perp, Book: perpBook},
+	}
+	o := bean.NewGreekOptimizer(0, bean.FeeRate{TakerBps: 5})
+
+	// delta alone is hedged in the perpetual, the cheapest delta
+	plan, err := o.Optimize(p, m, tradables, bean.Neutral(bean.GreekDelta, 0.01))
+	assert.NoError(t, err)
+	assert.True(t, plan.Before.Delta < -1)
+	assert.InDelta(t, 0, plan.After.Delta, 0.01+1e-9)
+	assert.Len(t, plan.Trades, 1)
+	assert.Equal(t, perp, plan.Trades[0].Contract)
+	assert.True(t, plan.Trades[0].Amount > 0)
+	assert.True(t, plan.Cost > 0)
+	assert.InDelta(t, plan.Before.PV-plan.Cost, plan.After.PV, 1e-9)
+
+	// vega needs the put bought, its delta offset in the perpetual
+	plan, err = o.Optimize(p, m, tradables, bean.Neutral(bean.GreekDelta, 0.01),
+		bean.GreekTarget{Greek: bean.GreekVega, Min: -1, Max: 1})
+	assert.NoError(t, err)
+	assert.Len(t, plan.Trades, 2)
+	assert.Equal(t, put, plan.Trades[0].Contract)
+	assert.True(t, plan.Trades[0].Amount > 0)
+	assert.InDelta(t, 0, plan.After.Delta, 0.01+1e-9)
+	assert.InDelta(t, 0, plan.After.Vega, 1+1e-9)
+
+	// too few puts to flatten vega
+	tradables[0].Book = bean.NewOrderBook([]bean.Order{{Price: 0.01, Amount: 1}}, []bean.Order{{Price: 0.02, Amount: 1}})
+	_, err = o.Optimize(p, m, tradables, bean.Neutral(bean.GreekVega, 0.01))
+	assert.True(t, errors.Is(err, bean.ErrInfeasible))
+
+	_, err = o.Optimize(p, m, tradables, bean.Neutral("RHO", 1))
+	assert.True(t, errors.Is(err, bean.ErrInvalidInput))
+}
@@ -0,0 +1,255 @@
+package test
+
+import (
+	"math"
+	"errors"
+	"testing"
+	"time"
+
+	"bean"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGreeksEngine(t *testing.T) {
+	asof := time.Date(2019, 5, 1, 8, 0, 0, 0, time.UTC)
+	expiry := time.Date(2019, 6, 28, 8, 0, 0, 0, time.UTC)
+	ge := bean.NewGreeksEngine(asof)
+	for _, cp := range []bean.CallOrPut{bean.Call, bean.Put} {
+		c := bean.OptContract(bean.Pair{Coin: bean.BTC, Base: bean.USD}, expiry, 6000, cp)
+		p := bean.NewPosition(c, 2.0, 0.05)
+		g := ge.PositionGreeks(p, 5500, 5600, 0.8)
+		assert.InDelta(t, p.PV(asof, 5500, 5600, 0.8), g.PV, 1e-6)
+		assert.InDelta(t, p.Delta(asof, 5500, 5600, 0.8), g.Delta, 1e-3)
+		assert.InDelta(t, p.Gamma(asof, 5500, 5600, 0.8), g.Gamma, 1e-3)
+		assert.InDelta(t, p.Vega(asof, 5500, 5600, 0.8), g.Vega, 1e-2)
+		assert.InDelta(t, p.Theta(asof, 5500, 5600, 0.8), g.Theta, 0.5)
+	}
+
+	rev := ge.Revision()
+	ge.Update(asof.Add(time.Hour))
+	assert.Equal(t, rev+1, ge.Revision())
+}
+
+// countingSurface is a flat surface counting its lookups, with a revision bumped by Set
+type countingSurface struct {
+	vol, calls float64
+	rev        int64
+}
+
+func (s *countingSurface) Vol(expiry time.Time, strike, forward float64) float64 {
+	s.calls++
+	return s.vol
+}
+
+func (s *countingSurface) Revision() int64 { return s.rev }
+
+func (s *countingSurface) Set(vol float64) {
+	s.vol = vol
+	s.rev++
+}
+
+func TestMarketRevision(t *testing.T) {
+	asof := time.Date(2019, 5, 1, 8, 0, 0, 0, time.UTC)
+	btc, eth := bean.Pair{Coin: bean.BTC, Base: bean.USD}, bean.Pair{Coin: bean.ETH, Base: bean.USD}
+	surface := &countingSurface{vol: 0.5}
+	cached := bean.NewCachedVolSurface(surface)
+	m := bean.NewMarket(asof)
+	m.SetSpot(btc, 5000)
+	m.SetVolSurface(btc, cached)
+
+	rev, btcRev, ethRev := m.Revision(), m.UnderlyingRevision(btc), m.UnderlyingRevision(eth)
+	m.SetSpot(eth, 200)
+	assert.True(t, m.Revision() > rev)
+	assert.Equal(t, btcRev, m.UnderlyingRevision(btc))
+	assert.True(t, m.UnderlyingRevision(eth) > ethRev)
+
+	c := bean.OptContract(btc, time.Date(2019, 6, 28, 8, 0, 0, 0, time.UTC), 6000, bean.Call)
+	assert.Equal(t, 0.5, m.Vol(c))
+	assert.Equal(t, 0.5, m.Vol(c))
+	assert.Equal(t, 1.0, surface.calls)
+
+	ge := bean.NewGreeksEngine(asof)
+	assert.True(t, ge.Sync(m))
+	assert.False(t, ge.Sync(m))
+	// a change of the surface alone is seen by the market, the cache and the engine
+	rev = m.Revision()
+	surface.Set(0.6)
+	assert.True(t, m.Revision() > rev)
+	assert.True(t, m.UnderlyingRevision(btc) > btcRev)
+	assert.Equal(t, 0.6, m.Vol(c))
+	assert.Equal(t, 2.0, surface.calls)
+	assert.True(t, ge.Sync(m))
+	m.SetAsof(asof.Add(time.Hour))
+	assert.True(t, ge.Sync(m))
+	assert.Equal(t, asof.Add(time.Hour), ge.Asof())
+
+	// replacing a surface by one of a lower revision is a change too
+	rev, btcRev = m.Revision(), m.UnderlyingRevision(btc)
+	m.SetVolSurface(btc, &countingSurface{vol: 0.7})
+	assert.True(t, m.Revision() > rev)
+	assert.True(t, m.UnderlyingRevision(btc) > btcRev)
+	assert.True(t, ge.Sync(m))
+}
+
+func optionBook(n int) []bean.Position {
+	expiry := time.Date(2019, 6, 28, 8, 0, 0, 0, time.UTC)
+	posns := make([]bean.Position, n)
+	for i := range posns {
+		c := bean.OptContract(bean.Pair{Coin: bean.BTC, Base: bean.USD}, expiry, float64(3000+10*i), bean.Call)
+		posns[i] = bean.NewPosition(c, 1.0, 0.01)
+	}
+	return posns
+}
+
+func BenchmarkGreeksEngineCached(b *testing.B) {
+	asof := time.Date(2019, 5, 1, 8, 0, 0, 0, time.UTC)
+	posns := optionBook(500)
+	ge := bean.NewGreeksEngine(asof)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var tot bean.Greeks
+		for _, p := range posns {
+			tot = tot.Add(ge.PositionGreeks(p, 5500, 5600, 0.8))
+		}
+		if math.IsNaN(tot.PV) {
+			b.Fatal("NaN PV")
+		}
+	}
+}
+
+func BenchmarkGreeksEngineUpdate(b *testing.B) {
+	asof := time.Date(2019, 5, 1, 8, 0, 0, 0, time.UTC)
+	posns := optionBook(500)
+	ge := bean.NewGreeksEngine(asof)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ge.Update(asof)
+		for _, p := range posns {
+			ge.PositionGreeks(p, 5500, 5600, 0.8)
+		}
+	}
+}
+
+func BenchmarkPositionGreeksBumped(b *testing.B) {
+	asof := time.Date(2019, 5, 1, 8, 0, 0, 0, time.UTC)
+	posns := optionBook(500)
+	for i := 0; i < b.N; i++ {
+		for _, p := range posns {
+			p.PV(asof, 5500, 5600, 0.8)
+			p.Delta(asof, 5500, 5600, 0.8)
+			p.Gamma(asof, 5500, 5600, 0.8)
+			p.Vega(asof, 5500, 5600, 0.8)
+			p.Theta(asof, 5500, 5600, 0.8)
+		}
+	}
+}
+
+func TestVegaOptions(t *testing.T) {
+	asof := time.Date(2019, 5, 1, 8, 0, 0, 0, time.UTC)
+	btc := bean.Pair{Coin: bean.BTC, Base: bean.USD}
+	p := bean.NewPosition(bean.OptContract(btc, asof.AddDate(0, 0, 30), 5500, bean.Call), 10, 0.05)
+	vega := p.Vega(asof, 5000, 5050, 0.8)
+	assert.InDelta(t, vega, p.VegaWith(asof, 5000, 5050, 0.8, bean.VegaOptions{Bump: 0.0001}), 1e-3*vega)
+	assert.InDelta(t, vega, p.VegaWith(asof, 5000, 5050, 0.8, bean.VegaOptions{Bump: 0.0001, OneSided: true}), 1e-2*vega)
+	assert.InDelta(t, 0.8*vega, p.VegaWith(asof, 5000, 5050, 0.8, bean.VegaOptions{Bump: 0.005, Relative: true}), 1e-9)
+}
+
+func TestVannaVolga(t *testing.T) {
+	asof := time.Date(2019, 5, 1, 8, 0, 0, 0, time.UTC)
+	btc := bean.Pair{Coin: bean.BTC, Base: bean.USD}
+	p := bean.NewPortfolio()
+	p.AddPosition(bean.NewPosition(bean.OptContract(btc, asof.AddDate(0, 0, 30), 6000, bean.Call), 10, 0.02))
+	p.AddPosition(bean.NewPosition(bean.OptContract(btc, asof.AddDate(0, 0, 30), 4000, bean.Put), -5, 0.02))
+	mkt := func(pos bean.Position) (float64, float64, float64) {
+		return 5000, 5050, 0.8
+	}
+	analytic := p.VannaVolgaBuckets(asof, mkt, true)
+	bumped := p.VannaVolgaBuckets(asof, mkt, false)
+	assert.Equal(t, 1, len(analytic))
+	for k, a := range analytic {
+		assert.InDelta(t, a.Vanna, bumped[k].Vanna, 1e-3*math.Abs(a.Vanna))
+		assert.InDelta(t, a.Volga, bumped[k].Volga, 1e-2*math.Abs(a.Volga))
+	}
+}
+
+func TestPinRiskReport(t *testing.T) {
+	asof := time.Date(2019, 6, 27, 8, 0, 0, 0, time.UTC)
+	names := []string{"BTC-28JUN19-5000-C", "BTC-28JUN19-5000-P", "BTC-28JUN19-5500-C", "BTC-27SEP19-5000-C"}
+	positions, err := bean.PositionsFromNames(names, []float64{10, -5, -3, 100}, []float64{0.01, 0.01, 0.01, 0.01})
+	assert.NoError(t, err)
+	under := positions[0].Underlying()
+	m := bean.NewMarket(asof)
+	m.SetSpot(under, 5000)
+	m.SetVolSurface(under, bean.FlatVol(0.5))
+
+	ch := bean.NewOptionChain(under, asof, 5000, nil)
+	for _, q := range []struct {
+		name string
+		oi   float64
+	}{{"BTC-28JUN19-4500-P", 100}, {"BTC-28JUN19-5000-C", 300}, {"BTC-28JUN19-5000-P", 200}, {"BTC-28JUN19-6000-C", 50}} {
+		c, _ := bean.ContractFromName(q.name)
+		ch.Add(bean.ContractTicker{Contract: c, OpenInterest: q.oi})
+	}
+
+	r, err := bean.NewPinRiskReport(m, positions, ch, 0.15, 0.01)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2019, 6, 28, 8, 0, 0, 0, time.UTC), r.Expiry)
+	assert.InDelta(t, 1.0, r.Days, 1e-9)
+	assert.Equal(t, 5000.0, r.Forward)
+	if assert.Len(t, r.Strikes, 3) {
+		low, atm, high := r.Strikes[0], r.Strikes[1], r.Strikes[2]
+		assert.Equal(t, 4500.0, low.Strike)
+		assert.Equal(t, 100.0, low.OpenInterest)
+		assert.Equal(t, 0.0, low.DeltaJump)
+		assert.Equal(t, 10.0, atm.Calls)
+		assert.Equal(t, -5.0, atm.Puts)
+		assert.Equal(t, 500.0, atm.OpenInterest)
+		assert.Equal(t, 5.0, atm.DeltaJump)
+		assert.True(t, atm.Gamma > 0)
+		assert.Equal(t, -3.0, high.DeltaJump)
+		assert.True(t, high.Gamma < 0)
+		assert.True(t, atm.Probability > high.Probability && high.Probability > 0)
+		assert.InDelta(t, atm.PinExposure+high.PinExposure, r.PinExposure, 1e-12)
+	}
+	assert.NotEmpty(t, r.String())
+
+	_, err = bean.NewPinRiskReport(m, positions[3:], bean.NewOptionChain(bean.Pair{Coin: bean.ETH, Base: bean.USD}, asof, 200, nil), 0, 0.01)
+	assert.True(t, errors.Is(err, bean.ErrInvalidInput))
+}
+
+func TestExpiredGreeks(t *testing.T) {
+	expiry := time.Date(2019, 6, 28, 8, 0, 0, 0, time.UTC)
+	c := bean.OptContract(bean.Pair{Coin: bean.BTC, Base: bean.USD}, expiry, 5000, bean.Call)
+	p := bean.NewPosition(c, 2, 0.05)
+	assert.False(t, c.Expired(expiry.Add(-time.Second)))
+	assert.True(t, c.Expired(expiry))
+	assert.Equal(t, 1000.0, c.Intrinsic(6000))
+
+	price, err := c.OptPrice(expiry, 6000, 6000, 0.8)
+	assert.NoError(t, err)
+	assert.Equal(t, 1000.0, price)
+
+	g, err := p.GreeksAt(expiry, 6000, 6000, 0.8)
+	assert.True(t, errors.Is(err, bean.ErrExpired))
+	assert.InDelta(t, 1400.0, g.PV, 1e-9)
+	assert.InDelta(t, p.PV(expiry, 6000, 6000, 0.8), g.PV, 1e-9)
+	assert.InDelta(t, (1.0/6-0.05)*2, g.Delta, 1e-12)
+	assert.Equal(t, bean.Greeks{PV: g.PV, Delta: g.Delta}, g)
+	assert.InDelta(t, g.Delta, p.Delta(expiry, 6000, 6000, 0.8), 1e-12)
+	assert.Equal(t, 0.0, p.Gamma(expiry, 6000, 6000, 0.8))
+	assert.Equal(t, 0.0, p.Vega(expiry, 6000, 6000, 0.8))
+	assert.Equal(t, 0.0, p.Theta(expiry, 6000, 6000, 0.8))
+
+	ge := bean.NewGreeksEngine(expiry.Add(time.Hour))
+	assert.InDelta(t, g.Delta, ge.PositionGreeks(p, 6000, 6000, 0.8).Delta, 1e-12)
+	// out of the money options settle for nothing
+	put := bean.NewPosition(bean.OptContract(c.Underlying(), expiry, 5000, bean.Put), 1, 0)
+	assert.Equal(t, bean.Greeks{}, ge.PositionGreeks(put, 6000, 6000, 0.8))
+
+	g, err = p.GreeksAt(expiry.Add(-24*time.Hour), 6000, 6000, 0.8)
+	assert.NoError(t, err)
+	assert.True(t, g.Gamma > 0)
+	g, err = p.GreeksAt(expiry.Add(-24*time.Hour), 0, 6000, 0.8)
+	assert.True(t, errors.Is(err, bean.ErrInvalidInput))
+	assert.True(t, math.IsNaN(g.Delta))
+}
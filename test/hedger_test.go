@@ -0,0 +1,65 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"bean"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeltaHedger(t *testing.T) {
+	start := time.Date(2019, 5, 1, 8, 0, 0, 0, time.UTC)
+	btc := bean.Pair{Coin: bean.BTC, Base: bean.USD}
+	call := bean.OptContract(btc, start.AddDate(0, 0, 30), 5000, bean.Call)
+	p := bean.NewPortfolio()
+	p.AddPosition(bean.NewPosition(call, 10, 0.05))
+
+	// oscillating path realizing roughly the 60% hedging vol
+	var candles bean.OHLCVBSTS
+	for i := 0; i < 24*5; i++ {
+		price := 5000 * math.Exp(0.6*math.Sqrt(1.0/365.0/24.0)*math.Pow(-1, float64(i)))
+		candles = append(candles, bean.OHLCVBS{Close: price, End: start.Add(time.Duration(i) * time.Hour)})
+	}
+	h := bean.NewDeltaHedger(time.Hour, 0, 0.6)
+	r := h.RunCandles(p, candles, 0)
+	assert.Equal(t, len(candles), len(r.PnL))
+	assert.Equal(t, 0.0, r.Costs)
+	assert.True(t, r.GammaPnL > 0 && r.ThetaPnL < 0)
+	assert.InDelta(t, r.TheoreticalPnL(), r.HedgedPnL(), 0.2*math.Abs(r.GammaPnL))
+
+	h.Fees = bean.FeeRate{TakerBps: 5}
+	r2 := h.RunCandles(p, candles, 0.0005)
+	assert.True(t, r2.Costs > 0)
+	assert.True(t, r2.HedgedPnL() < r.HedgedPnL())
+}
+
+func TestDeltaHedgerRunContext(t *testing.T) {
+	start := time.Date(2019, 5, 1, 8, 0, 0, 0, time.UTC)
+	btc := bean.Pair{Coin: bean.BTC, Base: bean.USD}
+	call := bean.OptContract(btc, start.AddDate(0, 0, 30), 5000, bean.Call)
+	p := bean.NewPortfolio()
+	p.AddPosition(bean.NewPosition(call, 10, 0.05))
+	var path bean.OrderBookTS
+	for i := 0; i < 24; i++ {
+		price := 5000 + 10*float64(i)
+		path = append(path, bean.OrderBookT{Time: start.Add(time.Duration(i) * time.Hour), OrderBook: bean.NewOrderBook(
+			[]bean.Order{{Price: price - 1, Amount: 100}}, []bean.Order{{Price: price + 1, Amount: 100}})})
+	}
+	h := bean.NewDeltaHedger(time.Hour, 0, 0.6)
+
+	r, err := h.RunContext(context.Background(), p, path)
+	assert.NoError(t, err)
+	assert.Equal(t, h.Run(p, path), r)
+	assert.Equal(t, len(path), len(r.PnL))
+
+	ctx, cancel := context.WithTimeout(context.Background(), -time.Second)
+	defer cancel()
+	r, err = h.RunContext(ctx, p, path)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	assert.Empty(t, r.PnL)
+	assert.Equal(t, 0, r.Hedges)
+}
@@ -0,0 +1,38 @@
+package test
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"bean"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIndexPrice(t *testing.T) {
+	btc := bean.Pair{Coin: bean.BTC, Base: bean.USD}
+	t0 := time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC)
+	ix := bean.NewIndexPrice(btc, map[string]float64{"A": 1, "B": 1, "C": 2, "D": 1}, time.Minute, 0.02)
+
+	_, err := ix.Price(t0)
+	assert.True(t, errors.Is(err, bean.ErrNoIndexPrice))
+
+	ix.Update("A", 100, t0)
+	ix.Update("A", 150, t0.Add(time.Second)) // spike
+	ix.Update("A", 101, t0.Add(2*time.Second))
+	ix.Update("B", 102, t0)
+	ix.Update("C", 100.5, t0)
+	ix.Update("D", 90, t0) // outlier
+	asof := t0.Add(5 * time.Second)
+	assert.Equal(t, 101.0, ix.Constituents(asof)["A"])
+	p, err := ix.Price(asof)
+	assert.NoError(t, err)
+	assert.InDelta(t, (101+102+2*100.5)/4, p, 1e-9)
+
+	// stale prices drop out
+	ix.Update("B", 103, t0.Add(2*time.Minute))
+	p, _ = ix.Price(t0.Add(2 * time.Minute))
+	assert.Equal(t, 103.0, p)
+	assert.False(t, math.IsNaN(p))
+}
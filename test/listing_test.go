@@ -0,0 +1,64 @@
+package test
+
+import (
+	"testing"
+	"time"
+
+	"bean"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestListListedContracts(t *testing.T) {
+	// a Wednesday at the expiry hour, so the first daily is the next day
+	asof := time.Date(2019, 5, 1, 8, 0, 0, 0, time.UTC)
+	rules := bean.DeribitListingRules()
+	at := func(month time.Month, day int) time.Time { return time.Date(2019, month, day, 8, 0, 0, 0, time.UTC) }
+
+	expiries := rules.ListingExpiries(asof)
+	assert.Equal(t, []time.Time{
+		at(time.May, 2), at(time.May, 3), at(time.May, 4), // dailies
+		at(time.May, 10), at(time.May, 17), // weeklies, the first is also a daily
+		at(time.May, 31), at(time.June, 28), at(time.July, 26), // monthlies
+		at(time.September, 27), at(time.December, 27), time.Date(2020, 3, 27, 8, 0, 0, 0, time.UTC), // quarterlies
+	}, expiries)
+
+	// strike grids widen and coarsen with the tenor
+	short := rules.Strikes(asof, at(time.May, 2), 5000)
+	assert.Equal(t, 41, len(short))
+	assert.Equal(t, 4500.0, short[0])
+	assert.Equal(t, 5500.0, short[len(short)-1])
+	assert.Equal(t, 25.0, short[1]-short[0])
+	month := rules.Strikes(asof, at(time.May, 31), 5000)
+	assert.Equal(t, 3500.0, month[0])
+	assert.Equal(t, 100.0, month[1]-month[0])
+	long := rules.Strikes(asof, at(time.December, 27), 5000)
+	assert.Equal(t, 1250.0, long[0])
+	assert.Equal(t, 8750.0, long[len(long)-1])
+	assert.Equal(t, 250.0, long[1]-long[0])
+
+	btc := bean.Pair{Coin: bean.BTC, Base: bean.USD}
+	contracts := bean.ListListedContracts(btc, asof, 5000, rules)
+	names := make(map[string]bool)
+	options, futures := 0, 0
+	for _, c := range contracts {
+		assert.False(t, names[c.Name()], "%s listed twice", c.Name())
+		names[c.Name()] = true
+		if c.IsOption() {
+			options++
+		} else if !c.Perp() {
+			futures++
+		}
+	}
+	strikes := 0
+	for _, expiry := range expiries {
+		strikes += len(rules.Strikes(asof, expiry, 5000))
+	}
+	assert.Equal(t, 2*strikes, options, "a call and a put on every strike")
+	assert.Equal(t, 9, futures, "futures on all but the dailies which are not Fridays")
+	assert.False(t, names[bean.FutContract(btc, at(time.May, 2)).Name()])
+	assert.True(t, names[bean.FutContract(btc, at(time.May, 3)).Name()])
+	assert.True(t, contracts[len(contracts)-1].Perp())
+
+	rules.Futures = false
+	assert.Equal(t, options, len(bean.ListListedContracts(btc, asof, 5000, rules)))
+}
@@ -0,0 +1,45 @@
+package test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"bean"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimitOrderLifecycle(t *testing.T) {
+	now := time.Date(2019, 5, 1, 0, 0, 0, 0, time.UTC)
+	c, _ := bean.ContractFromName("BTC-PERPETUAL")
+
+	_, err := bean.NewLimitOrder("1", c, bean.BUY, 5000, -1, now)
+	assert.True(t, errors.Is(err, bean.ErrInvalidOrder))
+
+	o, err := bean.NewLimitOrder("1", c, bean.SELL, 5000, 10, now)
+	assert.Nil(t, err)
+	assert.Equal(t, -10.0, o.Amount())
+	assert.Nil(t, o.Fill(4, 5000, now))
+	assert.Equal(t, bean.PARTIAL, o.State())
+	assert.Nil(t, o.Fill(6, 5010, now))
+	assert.Equal(t, bean.FILLED, o.State())
+	assert.InDelta(t, 5006, o.AvgFillPrice(), 1e-9)
+	assert.True(t, errors.Is(o.Cancel(now), bean.ErrInvalidTransition))
+
+	o, _ = bean.NewLimitOrder("2", c, bean.BUY, 5000, 10, now)
+	assert.True(t, errors.Is(o.Fill(11, 5000, now), bean.ErrInvalidOrder))
+	assert.Nil(t, o.Cancel(now))
+	assert.False(t, o.Live())
+	assert.True(t, errors.Is(o.Reject("late", now), bean.ErrInvalidTransition))
+}
+
+func TestExecutionReportCoinQty(t *testing.T) {
+	btc := bean.Pair{Coin: bean.BTC, Base: bean.USD}
+	perp := bean.ExecutionReport{Instrument: "BTC-PERPETUAL", Pair: btc, Side: bean.SELL, Price: 10000, Qty: 100}
+	assert.InDelta(t, 0.1, perp.CoinQty(), 1e-12, "100 contracts of 10 USD")
+	assert.InDelta(t, 0.1, perp.TradeLog().Quantity, 1e-12)
+	option := bean.ExecutionReport{Instrument: "BTC-27JUN25-60000-C", Pair: btc, Price: 0.05, Qty: 2}
+	assert.Equal(t, 2.0, option.CoinQty())
+	linear := bean.ExecutionReport{Instrument: "BTCUSDT", Pair: bean.Pair{Coin: bean.BTC, Base: bean.USDT}, Price: 30000, Qty: 0.004}
+	assert.Equal(t, 0.004, linear.CoinQty())
+}
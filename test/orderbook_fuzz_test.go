@@ -0,0 +1,117 @@
+package test
+
+import (
+	"errors"
+	"math/rand"
+	"sort"
+	"testing"
+	"testing/quick"
+
+	"bean"
+	"github.com/stretchr/testify/assert"
+)
+
+// refBook is the reference implementation the books are checked against: a map of amount by price per side
+type refBook struct {
+	bids, asks map[float64]float64
+}
+
+func (r refBook) levels(side map[float64]float64, desc bool) []bean.Order {
+	res := make([]bean.Order, 0, len(side))
+	for p, a := range side {
+		res = append(res, bean.Order{Price: p, Amount: a})
+	}
+	sort.Slice(res, func(i, j int) bool { return (res[i].Price > res[j].Price) == desc })
+	return res
+}
+
+// runBookOps applies operations of three bytes (kind, price, amount) to a book and the reference, failing on
+// the first difference or broken invariant
+func runBookOps(t *testing.T, core bean.OrderBookCore, ops []byte) bool {
+	ob := bean.OrderBook{OrderBookCore: core}
+	ref := refBook{bids: make(map[float64]float64), asks: make(map[float64]float64)}
+	for i := 0; i+2 < len(ops); i += 3 {
+		bid := ops[i]%2 == 0
+		side, price := ref.asks, 100+float64(ops[i+1]%30)
+		if bid {
+			side, price = ref.bids, 70+float64(ops[i+1]%30)
+		}
+		o := bean.Order{Price: price, Amount: float64(ops[i+2]) / 8}
+		_, exists := side[price]
+		var tob, wantTob bool
+		switch ops[i] % 6 / 2 {
+		case 0:
+			if bid {
+				tob = ob.InsertBid(o)
+			} else {
+				tob = ob.InsertAsk(o)
+			}
+			side[price] = o.Amount
+			best := ref.levels(side, bid)[0].Price
+			wantTob = best == price
+		case 1:
+			if exists {
+				wantTob = ref.levels(side, bid)[0].Price == price
+			}
+			if bid {
+				tob = ob.CancelBid(o)
+			} else {
+				tob = ob.CancelAsk(o)
+			}
+			delete(side, price)
+		default:
+			if bid {
+				ob.EditBid(o)
+			} else {
+				ob.EditAsk(o)
+			}
+			if exists {
+				side[price] = o.Amount
+			}
+		}
+		if tob != wantTob {
+			t.Errorf("op %d %v on %v: top of book change %v, want %v", i/3, ops[i]%6, o, tob, wantTob)
+			return false
+		}
+		if err := ob.CheckInvariants(0); err != nil {
+			t.Error(err)
+			return false
+		}
+		for _, got := range [][2][]bean.Order{{ref.levels(ref.bids, true), ob.Bids()}, {ref.levels(ref.asks, false), ob.Asks()}} {
+			if len(got[0]) != len(got[1]) || (len(got[0]) > 0 && !assert.Equal(t, got[0], got[1])) {
+				t.Errorf("op %d: levels %v, want %v", i/3, got[1], got[0])
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func FuzzOrderBookOps(f *testing.F) {
+	f.Add([]byte{0, 5, 8, 1, 5, 8, 0, 6, 16, 2, 5, 0, 4, 6, 3})
+	f.Add([]byte{1, 0, 1, 1, 29, 2, 5, 29, 0, 3, 0, 0, 1, 0, 0})
+	f.Fuzz(func(t *testing.T, ops []byte) {
+		runBookOps(t, new(bean.OrderBook1), ops)
+		runBookOps(t, bean.NewOrderBookCOW(nil, nil), ops)
+	})
+}
+
+func TestOrderBookProperties(t *testing.T) {
+	cfg := &quick.Config{MaxCount: 200, Rand: rand.New(rand.NewSource(1))}
+	assert.NoError(t, quick.Check(func(ops []byte) bool { return runBookOps(t, new(bean.OrderBook1), ops) }, cfg))
+	assert.NoError(t, quick.Check(func(ops []byte) bool { return runBookOps(t, bean.NewOrderBookCOW(nil, nil), ops) }, cfg))
+}
+
+func TestOrderBookInvariants(t *testing.T) {
+	ok := bean.NewOrderBook([]bean.Order{{Price: 99, Amount: 1}, {Price: 98, Amount: 0}}, []bean.Order{{Price: 100, Amount: 2}})
+	assert.NoError(t, ok.CheckInvariants(0))
+	for _, ob := range []bean.OrderBook{
+		bean.NewOrderBook([]bean.Order{{Price: 99, Amount: 1}, {Price: 99, Amount: 2}}, nil),
+		bean.NewOrderBook(nil, []bean.Order{{Price: 100, Amount: -1}}),
+		bean.NewOrderBook([]bean.Order{{Price: 101, Amount: 1}}, []bean.Order{{Price: 100, Amount: 1}}),
+	} {
+		assert.True(t, errors.Is(ob.CheckInvariants(0), bean.ErrBookInvariant), ob.String())
+	}
+	crossed := bean.NewOrderBook([]bean.Order{{Price: 100.5, Amount: 1}}, []bean.Order{{Price: 100, Amount: 1}})
+	assert.NoError(t, crossed.CheckInvariants(0.01))
+}
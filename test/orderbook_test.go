@@ -0,0 +1,479 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"bean"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOrderBook3(t *testing.T) {
+	ob := bean.NewOrderBook3()
+	ob.AddOrder(bean.L3Order{ID: "a", Side: bean.BUY, Price: 100, Amount: 1})
+	ob.AddOrder(bean.L3Order{ID: "b", Side: bean.BUY, Price: 100, Amount: 2})
+	ob.AddOrder(bean.L3Order{ID: "c", Side: bean.BUY, Price: 101, Amount: 0.5})
+	ob.AddOrder(bean.L3Order{ID: "d", Side: bean.SELL, Price: 102, Amount: 3})
+
+	assert.Equal(t, bean.Order{Price: 101, Amount: 0.5}, ob.BestBid())
+	assert.Equal(t, []bean.Order{{Price: 101, Amount: 0.5}, {Price: 100, Amount: 3}}, ob.Bids())
+
+	ahead, ok := ob.QueuePosition("b")
+	assert.True(t, ok)
+	assert.Equal(t, 1.0, ahead)
+
+	// a reduction keeps priority, an increase goes to the back
+	ob.ModifyOrder("a", 0.5)
+	ahead, _ = ob.QueuePosition("b")
+	assert.Equal(t, 0.5, ahead)
+	ob.ModifyOrder("a", 4)
+	ahead, _ = ob.QueuePosition("b")
+	assert.Equal(t, 0.0, ahead)
+
+	assert.True(t, ob.RemoveOrder("c"))
+	assert.Equal(t, bean.Order{Price: 100, Amount: 6}, ob.BestBid())
+	_, ok = ob.Order("c")
+	assert.False(t, ok)
+
+	// level 2 updates resize the level from the back of the queue
+	ob.EditBid(bean.Order{Price: 100, Amount: 1})
+	assert.Equal(t, []bean.L3Order{{ID: "b", Side: bean.BUY, Price: 100, Amount: 1}}, ob.LevelOrders(bean.BUY, 100))
+	ob.InsertAsk(bean.Order{Price: 101.5, Amount: 1})
+	assert.Equal(t, 101.5, ob.BestAsk().Price)
+	ob.CancelBid(bean.Order{Price: 100})
+	assert.Empty(t, ob.Bids())
+	_, ok = ob.Order("b")
+	assert.False(t, ok)
+
+	book := bean.OrderBook{OrderBookCore: ob}
+	assert.False(t, book.Valid())
+}
+
+func TestOrderBookCOW(t *testing.T) {
+	ob := bean.NewOrderBookCOW([]bean.Order{{Price: 99, Amount: 1}, {Price: 99.5, Amount: 1}}, []bean.Order{{Price: 100.5, Amount: 2}})
+	snap := ob.Snapshot()
+	assert.True(t, ob.InsertBid(bean.Order{Price: 100, Amount: 3}))
+	assert.False(t, ob.CancelBid(bean.Order{Price: 99}))
+	ob.EditAsk(bean.Order{Price: 100.5, Amount: 1})
+
+	// the earlier snapshot is unchanged
+	assert.Equal(t, []bean.Order{{Price: 99.5, Amount: 1}, {Price: 99, Amount: 1}}, snap.Bids())
+	assert.Equal(t, 2.0, snap.BestAsk().Amount)
+	assert.Equal(t, []bean.Order{{Price: 100, Amount: 3}, {Price: 99.5, Amount: 1}}, ob.Bids())
+	book := bean.OrderBook{OrderBookCore: ob}
+	assert.Equal(t, 100.25, book.Mid())
+	assert.Panics(t, func() { snap.InsertBid(bean.Order{Price: 1, Amount: 1}) })
+
+	var wg sync.WaitGroup
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				s := ob.Snapshot()
+				if bids := s.Bids(); len(bids) > 1 && bids[0].Price <= bids[1].Price {
+					t.Error("unsorted snapshot")
+				}
+			}
+		}()
+	}
+	for i := 0; i < 1000; i++ {
+		ob.InsertBid(bean.Order{Price: 90 + float64(i%20)*0.25, Amount: 1})
+		ob.CancelBid(bean.Order{Price: 90 + float64((i+10)%20)*0.25})
+	}
+	wg.Wait()
+}
+
+func benchBook(levels int) bean.OrderBook {
+	bids := make([]bean.Order, levels)
+	asks := make([]bean.Order, levels)
+	for i := range bids {
+		bids[i] = bean.Order{Price: 100 - 0.5*float64(i+1), Amount: 1}
+		asks[i] = bean.Order{Price: 100 + 0.5*float64(i+1), Amount: 1}
+	}
+	return bean.NewOrderBook(bids, asks)
+}
+
+func TestOrderBook1Updates(t *testing.T) {
+	ob := benchBook(3)
+	assert.True(t, ob.InsertBid(bean.Order{Price: 99.75, Amount: 2}))
+	assert.False(t, ob.InsertBid(bean.Order{Price: 98.75, Amount: 2}))
+	assert.False(t, ob.InsertBid(bean.Order{Price: 99, Amount: 3})) // existing level updated in place
+	assert.Equal(t, []float64{99.75, 99.5, 99, 98.75, 98.5}, prices(ob.Bids()))
+	assert.Equal(t, 3.0, ob.Bids()[2].Amount)
+	assert.True(t, ob.InsertAsk(bean.Order{Price: 100.25, Amount: 1}))
+	assert.False(t, ob.CancelAsk(bean.Order{Price: 101}))
+	assert.Equal(t, []float64{100.25, 100.5, 101.5}, prices(ob.Asks()))
+	assert.True(t, ob.CancelBid(bean.Order{Price: 99.75}))
+	ob.EditAsk(bean.Order{Price: 100.5, Amount: 5})
+	assert.Equal(t, 5.0, ob.Asks()[1].Amount)
+	assert.Equal(t, bean.Order{Price: 100.25, Amount: 1}, ob.Match(bean.Order{Price: 100.3, Amount: 2}))
+}
+
+func prices(levels []bean.Order) (res []float64) {
+	for _, o := range levels {
+		res = append(res, o.Price)
+	}
+	return
+}
+
+func BenchmarkOrderBookUpdate(b *testing.B) {
+	ob := benchBook(100)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		p := 99.25 - 0.5*float64(i%50)
+		ob.CancelBid(bean.Order{Price: p})
+		ob.InsertBid(bean.Order{Price: p, Amount: 2})
+		ob.EditBid(bean.Order{Price: p, Amount: 1})
+	}
+}
+
+func BenchmarkOrderBookMatch(b *testing.B) {
+	ob := benchBook(100)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ob.Match(bean.Order{Price: 110, Amount: 5})
+		ob.Match(bean.Order{Price: 90, Amount: -5})
+	}
+}
+
+func BenchmarkOrderBookPriceIn(b *testing.B) {
+	ob := benchBook(100)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ob.PriceIn(10)
+	}
+}
+
+func TestOrderBookDiff(t *testing.T) {
+	a := bean.NewOrderBook([]bean.Order{{Price: 99, Amount: 1}, {Price: 98, Amount: 2}}, []bean.Order{{Price: 101, Amount: 1}})
+	b := bean.NewOrderBook([]bean.Order{{Price: 99, Amount: 3}, {Price: 97, Amount: 1}}, []bean.Order{{Price: 101, Amount: 1}, {Price: 100.5, Amount: 2}})
+	patch := a.Diff(b)
+	assert.ElementsMatch(t, bean.BookPatch{
+		{Side: bean.BUY, Price: 99, Amount: 3},
+		{Side: bean.BUY, Price: 97, Amount: 1},
+		{Side: bean.BUY, Price: 98, Amount: 0},
+		{Side: bean.SELL, Price: 100.5, Amount: 2},
+	}, patch)
+	assert.Empty(t, b.Diff(b))
+
+	c := a.Clone()
+	c.ApplyPatch(patch)
+	assert.Equal(t, b.Bids(), c.Bids())
+	assert.Equal(t, b.Asks(), c.Asks())
+	assert.Equal(t, 2.0, a.Bids()[1].Amount) // clone is independent
+
+	t0 := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	ts := bean.OrderBookTS{{OrderBook: a, Time: t0}, {OrderBook: b, Time: t0.Add(time.Second), ChangeId: 2}}
+	first, patches := ts.Diffs()
+	back := bean.OrderBookTSFromDiffs(first, patches)
+	assert.Len(t, back, 2)
+	assert.Equal(t, b.Bids(), back[1].Bids())
+	assert.Equal(t, int64(2), back[1].ChangeId)
+}
+
+func TestOrderBookRepair(t *testing.T) {
+	book := func() bean.OrderBook {
+		return bean.NewOrderBook(
+			[]bean.Order{{Price: 100, Amount: 1}, {Price: 99, Amount: 2}},
+			[]bean.Order{{Price: 101, Amount: 3}, {Price: 102, Amount: 4}})
+	}
+	ob := book()
+	assert.False(t, ob.Crossed())
+	assert.Equal(t, 0, ob.Repair(bean.RepairTrim, ""))
+
+	// a fresh bid through the stale asks removes them
+	patch := bean.BookPatch{{Side: bean.BUY, Price: 101.5, Amount: 5}}
+	assert.Equal(t, 0, ob.ApplyPatchRepair(patch, bean.RepairNone))
+	assert.True(t, ob.Crossed())
+	ob = book()
+	assert.Equal(t, 1, ob.ApplyPatchRepair(patch, bean.RepairDropStale))
+	assert.False(t, ob.Crossed())
+	assert.Equal(t, bean.Order{Price: 101.5, Amount: 5}, ob.BestBid())
+	assert.Equal(t, bean.Order{Price: 102, Amount: 4}, ob.BestAsk())
+
+	// a fresh ask removes the bids at or above it
+	ob = book()
+	assert.Equal(t, 1, ob.ApplyPatchRepair(bean.BookPatch{{Side: bean.SELL, Price: 99.5, Amount: 1}}, bean.RepairDropStale))
+	assert.Equal(t, 99.0, ob.BestBid().Price)
+
+	// trimming drops the smaller crossing level
+	ob = bean.NewOrderBook([]bean.Order{{Price: 102, Amount: 1}, {Price: 100, Amount: 5}}, []bean.Order{{Price: 101, Amount: 3}})
+	assert.Equal(t, 1, ob.Repair(bean.RepairTrim, ""))
+	assert.False(t, ob.Crossed())
+	assert.Equal(t, 100.0, ob.BestBid().Price)
+	assert.Equal(t, 101.0, ob.BestAsk().Price)
+
+	locked := bean.NewOrderBook([]bean.Order{{Price: 100, Amount: 1}}, []bean.Order{{Price: 100, Amount: 1}})
+	assert.True(t, locked.Locked())
+	assert.Equal(t, 0, locked.Repair(bean.RepairTrim, ""))
+}
+
+func TestHiddenLiquidity(t *testing.T) {
+	t0 := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	books := bean.OrderBookTS{
+		{Time: t0, OrderBook: bean.NewOrderBook(
+			[]bean.Order{{Price: 99, Amount: 2}, {Price: 98, Amount: 5}}, []bean.Order{{Price: 101, Amount: 4}})},
+		{Time: t0.Add(time.Minute), OrderBook: bean.NewOrderBook(
+			[]bean.Order{{Price: 99, Amount: 2}}, []bean.Order{{Price: 101, Amount: 4}})},
+	}
+	trades := bean.Transactions{
+		{Price: 100, Amount: 1, TimeStamp: t0.Add(-time.Second)}, // before the books
+		{Price: 99, Amount: 2, TimeStamp: t0.Add(10 * time.Second), Maker: bean.Buyer},
+		{Price: 99, Amount: 3, TimeStamp: t0.Add(20 * time.Second), Maker: bean.Buyer}, // 3 beyond the 2 shown
+		{Price: 101, Amount: 3, TimeStamp: t0.Add(30 * time.Second), Maker: bean.Seller},
+		{Price: 99, Amount: 3, TimeStamp: t0.Add(70 * time.Second), Maker: bean.Buyer}, // 1 beyond
+	}
+	h := bean.EstimateHiddenLiquidity(books, trades)
+	assert.Len(t, h.Levels, 2)
+	bid := h.Levels[0]
+	assert.Equal(t, bean.BUY, bid.Side)
+	assert.Equal(t, 3, bid.Trades)
+	assert.Equal(t, 8.0, bid.Executed)
+	assert.Equal(t, 4.0, bid.Displayed)
+	assert.Equal(t, 4.0, bid.Hidden)
+	assert.Equal(t, 1.0, h.BidRatio)
+	assert.Equal(t, 0.0, h.AskRatio)
+
+	ob := h.Enrich(books[0].OrderBook)
+	assert.Equal(t, bean.Order{Price: 99, Amount: 4}, ob.BestBid())
+	assert.Equal(t, bean.Order{Price: 101, Amount: 4}, ob.BestAsk())
+}
+
+func TestLadderView(t *testing.T) {
+	btc := bean.Pair{Coin: bean.BTC, Base: bean.USDT}
+	ob := bean.NewOrderBook([]bean.Order{{Price: 99, Amount: 4}, {Price: 98, Amount: 1}, {Price: 97, Amount: 9}},
+		[]bean.Order{{Price: 101, Amount: 2}, {Price: 102, Amount: 8}})
+	v := bean.NewLadderView(2, 8)
+	v.SetOrders([]bean.OrderStatus{
+		{Side: bean.BUY, PlacedPrice: 99, LeftAmount: 1, State: bean.ALIVE},
+		{Side: bean.SELL, PlacedPrice: 102, LeftAmount: 3, State: bean.PARTIAL},
+		{Side: bean.SELL, PlacedPrice: 101, LeftAmount: 0, State: bean.FILLED},
+	})
+	b := bean.NewBlotter()
+	b.Add(bean.TradeLog{Pair: btc, Price: 101, Quantity: 0.5, Side: bean.BUY})
+	v.SetBlotter(b, btc)
+	v.OnTrade(bean.Transaction{Pair: btc, Price: 100, Amount: 1})
+	v.OnTrade(bean.Transaction{Pair: btc, Price: 99, Amount: 1, Maker: bean.Buyer})
+
+	lines := strings.Split(strings.TrimSuffix(v.Render(ob), "\n"), "\n")
+	assert.Len(t, lines, 6)
+	assert.Equal(t, []string{"102", "████████", "8", "<3"}, strings.Fields(lines[1]))
+	assert.Equal(t, []string{"101", "██", "2", "+0.5"}, strings.Fields(lines[2]))
+	assert.Equal(t, "--- spread 2 last 99 ↓ sell 1 position 0.5 ---", lines[3])
+	assert.Equal(t, []string{"<1", "*↓", "4", "████", "99"}, strings.Fields(lines[4]))
+	assert.NotContains(t, v.Render(ob), "97")
+	assert.Len(t, strings.Split(v.Render(bean.OrderBook{}), "\n"), 2)
+}
+
+func TestOrderBookWeightedMid(t *testing.T) {
+	ob := bean.NewOrderBook([]bean.Order{{Price: 99, Amount: 1}, {Price: 97, Amount: 3}},
+		[]bean.Order{{Price: 100, Amount: 2}, {Price: 101, Amount: 2}})
+	assert.InDelta(t, 99.5, ob.WeightedMid(1), 1e-9)
+	assert.InDelta(t, 1.0, ob.EffectiveSpread(1), 1e-9)
+	// bids average (99+97)/2, asks 100
+	assert.InDelta(t, (98+100)/2.0, ob.WeightedMid(2), 1e-9)
+	assert.InDelta(t, 2.0, ob.EffectiveSpread(2), 1e-9)
+	assert.True(t, math.IsNaN(ob.WeightedMid(5)))
+	assert.True(t, math.IsNaN(ob.EffectiveSpread(0)))
+	assert.True(t, math.IsNaN(bean.EmptyOrderBook().WeightedMid(1)))
+}
+
+func TestOrderBookTick(t *testing.T) {
+	a, b := 0.1, 0.2
+	price := a + b // 0.30000000000000004
+	ob := bean.NewOrderBook([]bean.Order{{Price: 0.3, Amount: 1}}, nil)
+	assert.False(t, ob.CancelBid(bean.Order{Price: price}), "missed with float keys")
+	assert.Len(t, ob.Bids(), 1)
+
+	ob = bean.NewOrderBookTick([]bean.Order{{Price: 0.3, Amount: 1}, {Price: price, Amount: 2}, {Price: 0.2, Amount: 1}},
+		[]bean.Order{{Price: 0.4, Amount: 1}}, 0.1)
+	assert.Equal(t, []bean.Order{{Price: 0.3, Amount: 3}, {Price: 0.2, Amount: 1}}, ob.Bids())
+	assert.Equal(t, 0.1, ob.PriceScale().Tick())
+	ob.InsertAsk(bean.Order{Price: 0.1 * 5, Amount: 4})
+	assert.Equal(t, 0.5, ob.Asks()[1].Price)
+	assert.True(t, ob.CancelBid(bean.Order{Price: price}))
+	assert.Equal(t, 0.2, ob.BestBid().Price)
+	// matching compares ticks too
+	assert.Equal(t, 5.0, ob.Match(bean.Order{Price: 0.1*3 + 0.2, Amount: 5}).Amount)
+
+	// the copy-on-write and level 3 books key their levels by tick as well
+	cow := bean.NewOrderBookCOWTick([]bean.Order{{Price: 0.3, Amount: 1}, {Price: price, Amount: 2}}, nil, 0.1)
+	assert.Equal(t, []bean.Order{{Price: 0.3, Amount: 3}}, cow.Bids())
+	cow.InsertBid(bean.Order{Price: price, Amount: 4})
+	assert.Equal(t, []bean.Order{{Price: 0.3, Amount: 4}}, cow.Bids())
+	snap := cow.Snapshot()
+	assert.True(t, cow.CancelBid(bean.Order{Price: price}))
+	assert.Empty(t, cow.Bids())
+	assert.Equal(t, 0.1, snap.PriceScale().Tick())
+	l3 := bean.NewOrderBook3Tick(0.1)
+	l3.AddOrder(bean.L3Order{ID: "a", Side: bean.BUY, Price: 0.3, Amount: 1})
+	l3.AddOrder(bean.L3Order{ID: "b", Side: bean.BUY, Price: price, Amount: 2})
+	assert.Equal(t, []bean.Order{{Price: 0.3, Amount: 3}}, l3.Bids())
+	ahead, _ := l3.QueuePosition("b")
+	assert.Equal(t, 1.0, ahead)
+	assert.Len(t, l3.LevelOrders(bean.BUY, price), 2)
+	assert.True(t, l3.CancelBid(bean.Order{Price: price}))
+	assert.Empty(t, l3.Bids())
+
+	s := bean.NewPriceScale(0.01)
+	assert.Equal(t, bean.Ticks(1234), s.Ticks(12.34))
+	assert.Equal(t, 12.34, s.Price(1234))
+	assert.Equal(t, 0.3, bean.NewPriceScale(0.1).Round(price))
+	assert.True(t, bean.NewPriceScale(0.1).Equal(price, 0.3))
+	assert.False(t, bean.PriceScale{}.Equal(price, 0.3))
+	assert.Equal(t, -1, s.Compare(12.33, 12.34))
+	assert.Equal(t, 0.5, bean.InstrumentSpec{TickSize: 0.5}.PriceScale().Tick())
+}
+
+func TestOrderBookBucketize(t *testing.T) {
+	ob := bean.NewOrderBook([]bean.Order{{Price: 9999.5, Amount: 1}, {Price: 9995, Amount: 2}, {Price: 9990, Amount: 3},
+		{Price: 9981, Amount: 1}}, []bean.Order{{Price: 10000.5, Amount: 1}, {Price: 10010, Amount: 2}, {Price: 10011, Amount: 4}})
+	b := ob.Bucketize(10)
+	assert.Equal(t, []bean.Order{{Price: 9990, Amount: 6}, {Price: 9980, Amount: 1}}, b.Bids())
+	assert.Equal(t, []bean.Order{{Price: 10010, Amount: 3}, {Price: 10020, Amount: 4}}, b.Asks())
+	assert.Len(t, ob.Bids(), 4, "unchanged")
+	assert.Equal(t, ob.Asks(), ob.Bucketize(0).Asks())
+	assert.Empty(t, bean.OrderBook{}.Bucketize(10).Bids())
+}
+
+func TestOrderBookTSAsOf(t *testing.T) {
+	t0 := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	var books bean.OrderBookTS
+	for i := 0; i < 5; i++ {
+		books = append(books, bean.OrderBookT{Time: t0.Add(time.Duration(i) * time.Minute), OrderBook: bean.NewOrderBook(
+			[]bean.Order{{Price: 99 + float64(i), Amount: 1}}, []bean.Order{{Price: 101 + float64(i), Amount: 1}})})
+	}
+	at := func(s int) time.Time { return t0.Add(time.Duration(s) * time.Second) }
+	stamps := []time.Time{at(-1), at(0), at(30), at(60), at(61), at(600)}
+	assert.Equal(t, []int{-1, 0, 0, 1, 1, 4}, books.AsOfIndex(stamps))
+
+	// unsorted timestamps give the same books in their own order
+	assert.Equal(t, []int{4, -1, 1, 0}, books.AsOfIndex([]time.Time{at(600), at(-1), at(61), at(30)}))
+
+	res := books.AsOf(stamps)
+	assert.Len(t, res, len(stamps))
+	assert.Nil(t, res[0].OrderBookCore)
+	assert.Equal(t, 100.0, res[4].BestBid().Price)
+	assert.Equal(t, books[4].Time, res[5].Time)
+	for i, s := range stamps[1:] {
+		assert.Equal(t, books.GetOrderBook(s.Add(time.Nanosecond)).Time, res[i+1].Time)
+	}
+	assert.Nil(t, bean.OrderBookTS{}.AsOf(stamps)[5].OrderBookCore)
+}
+
+func TestOrderBookDenoise(t *testing.T) {
+	ob := bean.NewOrderBook(
+		[]bean.Order{{Price: 100, Amount: 1}, {Price: 99, Amount: 3}, {Price: 98, Amount: 0.5}, {Price: 97, Amount: 0.5}},
+		[]bean.Order{{Price: 101, Amount: 1.5}, {Price: 102, Amount: 1}, {Price: 103, Amount: 4}})
+
+	// leftovers at the end of the bids are not carried into the asks
+	carry := bean.DenoiseRule{MinAmount: 2}
+	d := ob.DenoiseWith(carry, carry)
+	assert.Equal(t, []bean.Order{{Price: 99, Amount: 4}}, d.Bids())
+	assert.Equal(t, []bean.Order{{Price: 102, Amount: 2.5}, {Price: 103, Amount: 4}}, d.Asks())
+
+	drop := bean.DenoiseRule{MinAmount: 2, Policy: bean.DenoiseDrop}
+	d = ob.DenoiseWith(drop, drop)
+	assert.Equal(t, []bean.Order{{Price: 99, Amount: 3}}, d.Bids())
+	assert.Equal(t, []bean.Order{{Price: 103, Amount: 4}}, d.Asks())
+
+	vwap := bean.DenoiseRule{MinAmount: 2, Policy: bean.DenoiseVWAP}
+	d = ob.DenoiseWith(vwap, vwap)
+	assert.Equal(t, []bean.Order{{Price: 99.25, Amount: 4}}, d.Bids())
+	assert.Equal(t, []bean.Order{{Price: 101.4, Amount: 2.5}, {Price: 103, Amount: 4}}, d.Asks())
+
+	// per side notional thresholds, the zero rule keeps every level
+	d = ob.DenoiseWith(bean.DenoiseRule{}, bean.DenoiseRule{MinNotional: 200, Policy: bean.DenoiseDrop})
+	assert.Equal(t, ob.Bids(), d.Bids())
+	assert.Equal(t, []bean.Order{{Price: 103, Amount: 4}}, d.Asks())
+
+	btc := bean.Pair{Coin: bean.BTC, Base: bean.USDT}
+	small := bean.NewOrderBook([]bean.Order{{Price: 5000, Amount: 0.001}, {Price: 4999, Amount: 0.0015}},
+		[]bean.Order{{Price: 5001, Amount: 0.001}, {Price: 5002, Amount: 0.001}})
+	d = small.Denoise(btc)
+	assert.Equal(t, 4999.0, d.BestBid().Price)
+	assert.InDelta(t, 0.0025, d.BestBid().Amount, 1e-12)
+	assert.Equal(t, 5002.0, d.BestAsk().Price)
+	assert.InDelta(t, 0.002, d.BestAsk().Amount, 1e-12)
+}
+
+func TestOrderBookMetrics(t *testing.T) {
+	ob := bean.NewOrderBook([]bean.Order{{Price: 99, Amount: 1}}, []bean.Order{{Price: 101, Amount: 1}})
+	ob.InsertBid(bean.Order{Price: 98, Amount: 1}) // no registry
+	m := bean.NewMetrics()
+	bean.SetMetrics(m)
+	defer bean.SetMetrics(nil)
+	ob.InsertBid(bean.Order{Price: 97, Amount: 1})
+	ob.CancelBid(bean.Order{Price: 98})
+	assert.Equal(t, int64(2), m.Counter(bean.MetricBookUpdates).Value())
+
+	// a new registry counts from zero
+	m2 := bean.NewMetrics()
+	bean.SetMetrics(m2)
+	ob.InsertAsk(bean.Order{Price: 102, Amount: 1})
+	assert.Equal(t, int64(2), m.Counter(bean.MetricBookUpdates).Value())
+	assert.Equal(t, int64(1), m2.Counter(bean.MetricBookUpdates).Value())
+}
+
+func TestMetricsNilRegistry(t *testing.T) {
+	bean.SetMetrics(nil)
+	r := bean.DefaultMetrics()
+	assert.Empty(t, r.Snapshot())
+	var buf bytes.Buffer
+	assert.NoError(t, r.WritePrometheus(&buf))
+	assert.Empty(t, buf.String())
+	r.Counter(bean.MetricBookResyncs).Inc()
+
+	m := bean.NewMetrics()
+	m.Counter(bean.MetricBookResyncs).Add(2)
+	assert.NoError(t, m.WritePrometheus(&buf))
+	assert.Equal(t, "# TYPE bean_book_resyncs_total counter\nbean_book_resyncs_total 2\n", buf.String())
+	assert.Equal(t, map[string]float64{bean.MetricBookResyncs: 2}, m.Snapshot())
+}
+
+func TestDepthProfile(t *testing.T) {
+	ob := bean.NewOrderBook(
+		[]bean.Order{{Price: 99, Amount: 1}, {Price: 98, Amount: 2}, {Price: 90, Amount: 5}},
+		[]bean.Order{{Price: 101, Amount: 3}, {Price: 102, Amount: 1}})
+
+	d := ob.DepthProfile(0)
+	assert.Equal(t, 100.0, d.Mid)
+	assert.Equal(t, []float64{99, 98, 90}, d.BidPrices)
+	assert.Equal(t, []float64{1, 3, 8}, d.BidSizes, "cumulative from the best price")
+	assert.Equal(t, []float64{101, 102}, d.AskPrices)
+	assert.Equal(t, []float64{3, 4}, d.AskSizes)
+
+	d = ob.DepthProfile(2)
+	assert.Equal(t, []float64{99, 98}, d.BidPrices)
+	assert.Equal(t, []float64{101, 102}, d.AskPrices)
+
+	d = ob.DepthProfileRange(0.05)
+	assert.Equal(t, []float64{99, 98}, d.BidPrices, "90 is beyond 5% of the mid")
+	assert.Equal(t, []float64{1, 3}, d.BidSizes)
+
+	// one sided books have no mid
+	oneSided := bean.NewOrderBook([]bean.Order{{Price: 99, Amount: 1}}, nil).DepthProfile(0)
+	assert.Equal(t, 0.0, oneSided.Mid)
+	assert.Equal(t, []float64{1}, oneSided.BidSizes)
+	assert.Empty(t, oneSided.AskPrices)
+
+	var buf bytes.Buffer
+	assert.NoError(t, ob.DepthProfile(2).WriteCSV(&buf))
+	assert.Equal(t, "Side,Price,CumSize\nBID,99,1\nBID,98,3\nASK,101,3\nASK,102,4\n", buf.String())
+
+	buf.Reset()
+	assert.NoError(t, ob.DepthProfile(0).WriteJSON(&buf))
+	var decoded bean.DepthProfile
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &decoded))
+	assert.Equal(t, ob.DepthProfile(0), decoded)
+	assert.True(t, strings.Contains(buf.String(), `"bidSizes":[1,3,8]`))
+}
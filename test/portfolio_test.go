@@ -1,8 +1,11 @@
 package test
 
 import (
+	"errors"
+	"math"
 	"reflect"
 	"testing"
+	"time"
 
 	"bean"
 	"github.com/stretchr/testify/assert"
@@ -28,3 +31,415 @@ func TestPorfolio(t *testing.T) {
 		assert.True(t, reflect.DeepEqual(p.Filter(Coins{BTC, ETH}), portfolio{map[Coin]float64{BTC: 1, ETH: 2}}))
 	*/
 }
+
+func TestPortfolioGreeksParallel(t *testing.T) {
+	asof := time.Date(2019, 5, 1, 8, 0, 0, 0, time.UTC)
+	p := bean.NewPortfolio()
+	p.SetPositions(optionBook(300))
+	fut, _ := bean.ContractFromName("BTC-28JUN19")
+	p.AddPosition(bean.NewPosition(fut, -100, 5400))
+
+	mkt := func(pos bean.Position) (float64, float64, float64) {
+		return 5500, 5600, 0.6 + pos.Strike()/100000
+	}
+	seq := p.Greeks(asof, mkt)
+	for _, workers := range []int{0, 1, 3, 1000} {
+		assert.Equal(t, seq, p.GreeksParallel(asof, mkt, workers))
+	}
+}
+
+func TestPortfolioVaR(t *testing.T) {
+	asof := time.Date(2019, 5, 1, 8, 0, 0, 0, time.UTC)
+	btc := bean.Pair{Coin: bean.BTC, Base: bean.USD}
+	p := bean.NewPortfolio()
+	fut := bean.FutContract(btc, asof.AddDate(0, 0, 58))
+	p.AddPosition(bean.NewPosition(fut, 1000, 5000))
+	p.AddPosition(bean.NewPosition(bean.PerpContract(btc), -500, 5000))
+	mkt := func(pos bean.Position) (float64, float64, float64) {
+		return 5000, 5000, 0.8
+	}
+
+	// returns evenly spread over a normal distribution with 4% daily vol
+	n := 2000
+	rets := make([]float64, n)
+	for i := range rets {
+		rets[i] = 0.04 * math.Sqrt2 * math.Erfinv(2*(float64(i)+0.5)/float64(n)-1)
+	}
+	returns := map[bean.Pair][]float64{btc: rets}
+
+	hist := p.VaR(asof, mkt, 0.99, 1, returns, bean.HistoricalVaR)
+	param := p.VaR(asof, mkt, 0.99, 1, returns, bean.DeltaGammaVaR)
+	assert.InDelta(t, hist.VaR, param.VaR, 0.05*param.VaR)
+	assert.True(t, hist.ES > hist.VaR && param.ES > param.VaR)
+	assert.InDelta(t, hist.VaR, hist.VaRContribution[0]+hist.VaRContribution[1], 1e-9)
+	assert.True(t, hist.VaRContribution[1] < 0)
+	assert.InDelta(t, param.VaR, param.VaRContribution[0]+param.VaRContribution[1], 1e-9)
+}
+
+func TestLiquidationPrice(t *testing.T) {
+	btc := bean.Pair{Coin: bean.BTC, Base: bean.USD}
+	ms := bean.MarginSchedule{InitialRate: 0.01, MaintenanceRate: 0.005}
+
+	// $10,000 long at 10000 with 0.1 BTC of margin
+	long := bean.NewPosition(bean.PerpContract(btc), 1000, 10000)
+	liq := long.LiquidationPrice(0.1, ms)
+	assert.InDelta(t, 10000*1.005/1.1, liq, 1e-6)
+	equity := 0.1 + long.PV(time.Now(), liq, liq, 0)/liq
+	assert.InDelta(t, long.MaintenanceMargin(liq, ms), equity, 1e-12)
+	assert.InDelta(t, 10000/1.1, long.BankruptcyPrice(0.1), 1e-6)
+	assert.InDelta(t, 1-liq/10000, long.DistanceToLiquidation(10000, 0.1, ms), 1e-12)
+	assert.False(t, long.NearLiquidation(10000, 0.1, ms, 0.05))
+	assert.True(t, long.NearLiquidation(9500, 0.1, ms, 0.05))
+
+	short := bean.NewPosition(bean.PerpContract(btc), -1000, 10000)
+	assert.InDelta(t, 10000*0.995/0.9, short.LiquidationPrice(0.1, ms), 1e-6)
+	assert.True(t, math.IsInf(short.LiquidationPrice(1.5, ms), 1)) // fully collateralised
+	assert.True(t, math.IsNaN(bean.NewPosition(bean.PerpContract(btc), 0, 10000).LiquidationPrice(1, ms)))
+
+	// linear: 1 BTC long at 10000 with 1000 USDT
+	assert.InDelta(t, 9000/0.995, bean.LinearLiquidationPrice(1, 10000, 1000, ms), 1e-6)
+	assert.InDelta(t, 11000/1.005, bean.LinearLiquidationPrice(-1, 10000, 1000, ms), 1e-6)
+	assert.Equal(t, 9000.0, bean.LinearBankruptcyPrice(1, 10000, 1000))
+}
+
+func TestAccount(t *testing.T) {
+	btc := bean.Pair{Coin: bean.BTC, Base: bean.USD}
+	asof := time.Date(2019, 5, 1, 8, 0, 0, 0, time.UTC)
+	perp := bean.PerpContract(btc)
+	call := bean.OptContract(btc, time.Date(2019, 5, 31, 8, 0, 0, 0, time.UTC), 6000, bean.Call)
+	a := bean.NewAccount(bean.DeribitMarginSchedule())
+	a.Deposit(bean.BTC, 1)
+	assert.Error(t, a.Withdraw(bean.BTC, 2))
+
+	// buy $10,000 of perp at 5000 and 5000 more at 10000 then sell half at 8000
+	a.Fill(perp, 1000, 5000, 0.001)
+	a.Fill(perp, 500, 10000, 0)
+	pos, _ := a.Position(perp.Name())
+	assert.InDelta(t, 15000/2.5, pos.Price(), 1e-9) // harmonic average
+	realized := a.Fill(perp, -750, 8000, 0)
+	assert.InDelta(t, 7500*(1/6000.0-1/8000.0), realized, 1e-12)
+	assert.InDelta(t, 1-0.001+realized, a.Balance(bean.BTC), 1e-12)
+	assert.Equal(t, 0.001, a.Fees(bean.BTC))
+
+	paid := a.ApplyFunding(perp, 0.0001, 8000)
+	assert.InDelta(t, 7500/8000.0*0.0001, paid, 1e-12)
+	assert.Equal(t, paid, a.FundingPaid(bean.BTC))
+
+	mkt := func(p bean.Position) (float64, float64, float64) { return 8000, 8000, 0.8 }
+	equity := a.CoinEquity(asof, mkt, bean.BTC)
+	assert.InDelta(t, a.Balance(bean.BTC)+7500*(1/6000.0-1/8000.0), equity, 1e-12)
+	assert.InDelta(t, equity*8000, a.Equity(asof, mkt, map[bean.Coin]float64{bean.BTC: 8000, bean.USD: 1}), 1e-6)
+	assert.InDelta(t, 7500/8000.0*0.01, a.InitialMargin(asof, mkt)[bean.BTC], 1e-12)
+	assert.False(t, a.MarginCall(asof, mkt, bean.BTC))
+
+	// selling options uses margin
+	assert.NoError(t, a.CheckMargin(asof, mkt, call, -1, 0.05))
+	assert.True(t, errors.Is(a.CheckMargin(asof, mkt, call, -10, 0.05), bean.ErrInsufficientFunds))
+	a.Fill(call, -1, 0.05, 0)
+	assert.Len(t, a.Positions(), 2)
+	settled := a.Settle(call, 7000, bean.FeeRate{})
+	assert.InDelta(t, 0.05-1000/7000.0, settled, 1e-12)
+	assert.Len(t, a.Positions(), 1)
+
+	a.RecordEquity(asof, equity)
+	assert.Len(t, a.EquityCurve(), 1)
+}
+
+func TestRiskLimits(t *testing.T) {
+	asof := time.Date(2019, 6, 1, 8, 0, 0, 0, time.UTC)
+	btc := bean.Pair{Coin: bean.BTC, Base: bean.USD}
+	fut, _ := bean.ContractFromName("BTC-28JUN19")
+	call, _ := bean.ContractFromName("BTC-28JUN19-8000-C")
+	mkt := func(p bean.Position) (float64, float64, float64) { return 8000, 8000, 0.8 }
+
+	limits := bean.RiskLimits{}
+	limits.SetUnderlying(btc, bean.RiskLimit{Delta: 1})
+	limits.SetExpiry(btc, fut.Expiry(), bean.RiskLimit{Vega: 100})
+	r := bean.NewRiskChecker(limits, mkt)
+
+	unit := r.Exposures(asof, []bean.Position{bean.NewPosition(fut, 1, 8000)})[bean.RiskBucket{Underlying: btc}].Delta
+	assert.InDelta(t, 10.0/8000, unit, 1e-9)
+
+	// futures resized to the delta limit, risk reducing orders allowed
+	qty, err := r.Check(asof, nil, fut, 1000)
+	assert.NoError(t, err)
+	assert.InDelta(t, 800, qty, 1e-6)
+	long := []bean.Position{bean.NewPosition(fut, 1000, 8000)}
+	qty, err = r.Check(asof, long, fut, 10)
+	assert.True(t, errors.Is(err, bean.ErrRiskLimit))
+	assert.Equal(t, 0.0, qty)
+	qty, err = r.Check(asof, long, fut, -100)
+	assert.NoError(t, err)
+	assert.Equal(t, -100.0, qty)
+	qty, _ = r.Check(asof, long, fut, -3000)
+	assert.InDelta(t, -1800, qty, 1e-6, "may cross to the other side of the limit")
+
+	// option vega limit on the expiry bucket
+	limits.SetUnderlying(btc, bean.RiskLimit{})
+	vega := r.Exposures(asof, []bean.Position{bean.NewPosition(call, 1, 0)})[bean.RiskBucket{Underlying: btc, Expiry: fut.Expiry()}].Vega
+	qty, _ = r.Check(asof, nil, call, -1000)
+	assert.InDelta(t, -100/vega, qty, 1e-6)
+	r.RejectOnly = true
+	_, err = r.Check(asof, nil, call, -1000)
+	assert.True(t, errors.Is(err, bean.ErrRiskLimit))
+	r.RejectOnly = false
+	limits.SetUnderlying(btc, bean.RiskLimit{Delta: 1})
+
+	q := bean.Quote{Contract: fut,
+		Bids: []bean.Order{{Price: 7990, Amount: 500}, {Price: 7980, Amount: 500}, {Price: 7970, Amount: 500}},
+		Asks: []bean.Order{{Price: 8010, Amount: 500}}}
+	q = r.LimitQuote(asof, nil, q)
+	assert.Len(t, q.Bids, 2)
+	assert.InDelta(t, 300, q.Bids[1].Amount, 1e-6)
+	assert.Equal(t, 500.0, q.Asks[0].Amount)
+}
+
+func TestContractMultiplier(t *testing.T) {
+	asof := time.Date(2019, 5, 1, 8, 0, 0, 0, time.UTC)
+	btcPerp := bean.PerpContract(bean.Pair{Coin: bean.BTC, Base: bean.USD})
+	ethPerp := bean.PerpContract(bean.Pair{Coin: bean.ETH, Base: bean.USD})
+	call := bean.OptContract(bean.Pair{Coin: bean.ETH, Base: bean.USD}, time.Date(2019, 5, 31, 8, 0, 0, 0, time.UTC), 200, bean.Call)
+	assert.Equal(t, 10.0, btcPerp.Multiplier())
+	assert.Equal(t, 1.0, ethPerp.Multiplier())
+	assert.Equal(t, 1.0, call.Multiplier())
+
+	// 1000 ETH contracts are worth $1000
+	pos := bean.NewPosition(ethPerp, 1000, 200)
+	assert.InDelta(t, 1000*(1/200.0-1/250.0)*250, pos.PV(asof, 250, 250, 0), 1e-9)
+	a := bean.NewAccount(bean.DeribitMarginSchedule())
+	a.Fill(ethPerp, 1000, 200, 0)
+	assert.InDelta(t, 1000*(1/200.0-1/250.0), a.Fill(ethPerp, -1000, 250, 0), 1e-12)
+
+	// a registered spec overrides the default
+	fut := bean.FutContract(bean.Pair{Coin: bean.ETH, Base: bean.USD}, time.Date(2019, 6, 28, 8, 0, 0, 0, time.UTC))
+	bean.SetInstrumentSpec(fut.Name(), bean.InstrumentSpec{TickSize: 0.05, LotSize: 1, MinAmount: 1, Multiplier: 10})
+	defer bean.SetInstrumentSpec(fut.Name(), bean.DeribitSpec(fut))
+	assert.Equal(t, 10.0, fut.Multiplier())
+	pos = bean.NewPosition(fut, 100, 200)
+	assert.InDelta(t, 1000*(1/200.0-1/250.0)*250, pos.PV(asof, 250, 250, 0), 1e-9)
+}
+
+func TestGreeksByUnderlying(t *testing.T) {
+	asof := time.Date(2019, 5, 1, 8, 0, 0, 0, time.UTC)
+	btc := bean.Pair{Coin: bean.BTC, Base: bean.USD}
+	eth := bean.Pair{Coin: bean.ETH, Base: bean.USD}
+	m := bean.NewMarket(asof)
+	m.SetSpot(btc, 5000)
+	m.SetSpot(eth, 250)
+	m.SetVolSurface(btc, bean.FlatVol(0.8))
+	m.SetVolSurface(eth, bean.FlatVol(0.9))
+
+	rates := m.ConversionRates(bean.BTC)
+	assert.Equal(t, 1.0, rates[bean.BTC])
+	assert.InDelta(t, 0.05, rates[bean.ETH], 1e-12)
+	assert.InDelta(t, 0.0002, rates[bean.USD], 1e-12)
+
+	p := bean.NewPortfolio()
+	p.AddPosition(bean.NewPosition(bean.OptContract(btc, asof.AddDate(0, 0, 30), 6000, bean.Call), 10, 0.02))
+	p.AddPosition(bean.NewPosition(bean.OptContract(eth, asof.AddDate(0, 0, 30), 300, bean.Call), -100, 0.02))
+	report, err := p.GreeksByUnderlying(asof, m.Params, rates)
+	assert.NoError(t, err)
+
+	for _, pos := range p.Positions() {
+		g := pos.GreeksMarket(m)
+		u := report.Underlying(pos.Underlying())
+		coin, base := rates[pos.Underlying().Coin], rates[pos.Underlying().Base]
+		assert.InDelta(t, g.Delta*coin, u.Delta, 1e-6)
+		assert.InDelta(t, g.PV*base, u.PV, 1e-9)
+		assert.InDelta(t, g.Vega*base, u.Vega, 1e-9)
+		cash := report[bean.GreeksKey{Underlying: pos.Underlying(), Bucket: bean.CashBucket}]
+		assert.InDelta(t, pos.BucketDeltaMarket(m)[bean.CashBucket]*coin, cash.Delta, 1e-9)
+	}
+	total := report.Total()
+	assert.InDelta(t, report.Underlying(btc).Delta+report.Underlying(eth).Delta, total.Delta, 1e-12)
+
+	_, err = p.GreeksByUnderlying(asof, m.Params, map[bean.Coin]float64{bean.BTC: 1})
+	assert.True(t, errors.Is(err, bean.ErrNoRate))
+}
+
+func TestScenarios(t *testing.T) {
+	asof := time.Date(2019, 5, 1, 8, 0, 0, 0, time.UTC)
+	btc := bean.Pair{Coin: bean.BTC, Base: bean.USD}
+	eth := bean.Pair{Coin: bean.ETH, Base: bean.USD}
+	crash, ok := bean.ScenarioByName("crash-1d")
+	assert.True(t, ok)
+	assert.Equal(t, -0.3, crash.Spot)
+	names := make([]string, 0)
+	for _, s := range bean.Scenarios() {
+		names = append(names, s.Name)
+	}
+	assert.Contains(t, names, "funding-spike")
+	assert.Contains(t, names, "basis-collapse")
+
+	perp := bean.NewPosition(bean.PerpContract(btc), 1000, 5000)
+	mkt := func(pos bean.Position) (float64, float64, float64) { return 5000, 5000, 0.8 }
+	res := crash.PnL(asof, []bean.Position{perp}, mkt)
+	assert.InDelta(t, perp.PV(asof, 3500, 3500, 0.8)-perp.PV(asof, 5000, 5000, 0.8), res.PnL, 1e-9)
+	assert.True(t, res.PnL < 0)
+
+	funding, _ := bean.ScenarioByName("funding-spike")
+	res = funding.PnL(asof, []bean.Position{perp}, mkt)
+	// 9 payments of 0.3% on a 10000 USD notional
+	assert.InDelta(t, -0.003*9*10000, res.Funding, 1e-9)
+	assert.InDelta(t, res.Funding, res.PnL, 1e-9)
+
+	fut := bean.NewPosition(bean.FutContract(btc, asof.AddDate(0, 3, 0)), 100, 5100)
+	collapse, _ := bean.ScenarioByName("basis-collapse")
+	shocked := collapse.Market(func(bean.Position) (float64, float64, float64) { return 5000, 5100, 0.8 })
+	spot, f, _ := shocked(fut)
+	assert.Equal(t, 5000.0, spot)
+	assert.InDelta(t, 5000, f, 1e-9)
+
+	bean.RegisterScenario(bean.Scenario{Name: "eth-only", Spot: -0.5, Underlyings: []bean.Pair{eth}})
+	custom, ok := bean.ScenarioByName("eth-only")
+	assert.True(t, ok)
+	assert.Equal(t, 0.0, custom.PnL(asof, []bean.Position{perp}, mkt).PnL)
+
+	p := bean.NewPortfolio()
+	p.AddPosition(perp)
+	results := p.Stress(asof, mkt)
+	assert.Len(t, results, len(bean.Scenarios()))
+	assert.Equal(t, "crash-1d", p.Stress(asof, mkt, crash)[0].Scenario)
+}
+
+func TestTradesNetOfFees(t *testing.T) {
+	t0 := time.Date(2019, 5, 1, 8, 0, 0, 0, time.UTC)
+	pair := bean.Pair{Coin: bean.BTC, Base: bean.USDT}
+	trades := bean.TradeLogS{
+		{Pair: pair, Price: 100, Quantity: 1, Commission: 0.1, CommissionAsset: bean.USDT, Side: bean.BUY, Time: t0},
+		{Pair: pair, Price: 110, Quantity: 1, Commission: 0.001, CommissionAsset: bean.BTC, Side: bean.SELL, Time: t0.Add(time.Minute)},
+	}
+	txns := trades.ToTransactions()
+	assert.InDelta(t, 0.1, txns[0].Fee, 1e-12)
+	assert.InDelta(t, 0.11, txns[1].Fee, 1e-12)
+
+	snaps := bean.GenerateSnapshotTS(txns, bean.NewPortfolio())
+	last := snaps[len(snaps)-1].Port
+	assert.InDelta(t, 0, last.Balance(bean.BTC), 1e-12)
+	assert.InDelta(t, 10-0.1-0.11, last.Balance(bean.USDT), 1e-12)
+}
+
+func TestLiquidationValuation(t *testing.T) {
+	btc := bean.Pair{Coin: bean.BTC, Base: bean.USD}
+	fut, _ := bean.ContractFromName("BTC-28JUN19")
+	opt, _ := bean.ContractFromName("BTC-28JUN19-6000-C")
+	futBook := bean.NewOrderBook(
+		[]bean.Order{{Price: 5100, Amount: 500}, {Price: 5050, Amount: 1000}},
+		[]bean.Order{{Price: 5150, Amount: 800}})
+	optBook := bean.NewOrderBook(
+		[]bean.Order{{Price: 0.04, Amount: 5}, {Price: 0.035, Amount: 20}},
+		[]bean.Order{{Price: 0.045, Amount: 10}})
+	spot := 5120.0
+
+	long := bean.NewPosition(fut, 1000, 5000)
+	assert.Equal(t, 5125.0, long.ClosePrice(futBook, bean.MidValuation))
+	assert.Equal(t, 5050.0, long.ClosePrice(futBook, bean.LiquidationValuation), "longs close down the bids")
+	short := bean.NewPosition(fut, -1000, 5000)
+	assert.Equal(t, 5150.0, short.ClosePrice(futBook, bean.LiquidationValuation), "the rest beyond the book at its last level")
+	assert.True(t, math.IsNaN(long.ClosePrice(bean.NewOrderBook(nil, nil), bean.MidValuation)))
+
+	assert.InDelta(t, (1/5000.0-1/5050.0)*spot*1000*10, long.BookValue(spot, futBook, bean.LiquidationValuation), 1e-9)
+	assert.InDelta(t, (1/5000.0-1/5125.0)*spot*1000*10, long.BookValue(spot, futBook, bean.MidValuation), 1e-9)
+	assert.True(t, long.LiquidityCost(spot, futBook) > 0)
+	assert.True(t, short.LiquidityCost(spot, futBook) > 0)
+
+	call := bean.NewPosition(opt, 10, 0.05)
+	assert.InDelta(t, (0.035-0.05)*spot*10, call.BookValue(spot, optBook, bean.LiquidationValuation), 1e-9)
+	assert.InDelta(t, (0.0425-0.05)*spot*10, call.BookValue(spot, optBook, bean.MidValuation), 1e-9)
+
+	p := bean.NewPortfolio()
+	p.SetPositions([]bean.Position{long, call})
+	books := map[string]bean.OrderBook{fut.Name(): futBook, opt.Name(): optBook}
+	spots := map[bean.Pair]float64{btc: spot}
+	assert.InDelta(t, long.BookValue(spot, futBook, bean.LiquidationValuation)+call.BookValue(spot, optBook, bean.LiquidationValuation),
+		p.BookValue(books, spots, bean.LiquidationValuation), 1e-9)
+	assert.True(t, p.BookValue(books, spots, bean.LiquidationValuation) < p.BookValue(books, spots, bean.MidValuation))
+	delete(books, opt.Name())
+	assert.True(t, math.IsNaN(p.BookValue(books, spots, bean.MidValuation)), "NaN without a book for every position")
+	assert.Equal(t, "LIQUIDATION", bean.LiquidationValuation.String())
+}
+
+func TestPortfolioSettle(t *testing.T) {
+	expiry := time.Date(2019, 5, 31, 8, 0, 0, 0, time.UTC)
+	btc := bean.Pair{Coin: bean.BTC, Base: bean.USD}
+	eth := bean.Pair{Coin: bean.ETH, Base: bean.USD}
+	fut := bean.FutContract(btc, expiry)
+	positions := []bean.Position{
+		bean.NewPosition(bean.OptContract(btc, expiry, 5000, bean.Call), 2, 0.05),
+		bean.NewPosition(bean.OptContract(btc, expiry, 5000, bean.Put), -1, 0.02),
+		bean.NewPosition(fut, 100, 5500),
+		bean.NewPosition(bean.FutContract(btc, expiry.AddDate(0, 0, 28)), 50, 5600),
+		bean.NewPosition(bean.PerpContract(btc), -30, 5800),
+		bean.NewPosition(bean.OptContract(eth, expiry, 200, bean.Call), 10, 0.1),
+	}
+	newPortfolio := func() bean.Portfolio {
+		p := bean.NewPortfolio()
+		p.AddBalance(bean.BTC, 1)
+		p.SetPositions(positions)
+		return p
+	}
+	prices := map[bean.Pair]float64{btc: 6000}
+
+	p := newPortfolio()
+	settled, err := p.Settle(expiry, prices)
+	assert.Error(t, err, "the eth option has no settlement price")
+	assert.Contains(t, err.Error(), positions[5].Name())
+	assert.Equal(t, positions[:3], settled)
+	assert.Equal(t, positions[3:], p.Positions(), "unexpired, perpetual and unpriced positions stay live")
+	callValue := (1000/6000.0 - 0.05) * 2
+	putValue := 0.02
+	futValue := (1/5500.0 - 1/6000.0) * 100 * fut.Multiplier()
+	assert.InDelta(t, 1+callValue+putValue+futValue, p.Balance(bean.BTC), 1e-12)
+
+	// nothing more to settle before the next expiry
+	settled, err = p.Settle(expiry.AddDate(0, 0, 1), prices)
+	assert.Error(t, err)
+	assert.Empty(t, settled)
+	assert.Equal(t, positions[3:], p.Positions())
+
+	// settlement fees on the deribit schedule: 1.5bp on options capped at 12.5% of the settled value, so nothing on
+	// the worthless put, and 2.5bp delivery on the future notional
+	p = newPortfolio()
+	p.SetFeeSchedule(bean.DefaultFeeSchedule(), bean.NameDeribit)
+	settled, _ = p.Settle(expiry, prices)
+	assert.Len(t, settled, 3)
+	fees := 2*1.5e-4 + 100*fut.Multiplier()/6000*2.5e-4
+	assert.InDelta(t, 1+callValue+putValue+futValue-fees, p.Balance(bean.BTC), 1e-12)
+}
+
+func TestRho(t *testing.T) {
+	asof := time.Date(2019, 5, 1, 8, 0, 0, 0, time.UTC)
+	btc := bean.Pair{Coin: bean.BTC, Base: bean.USD}
+	may := time.Date(2019, 5, 31, 8, 0, 0, 0, time.UTC)
+	june := time.Date(2019, 6, 28, 8, 0, 0, 0, time.UTC)
+	call := bean.OptContract(btc, may, 5000, bean.Call)
+	put := bean.OptContract(btc, may, 5000, bean.Put)
+
+	// a higher rate lifts the forward with spot unchanged, so calls and long futures gain and puts lose
+	rho := func(c *bean.Contract, qty float64) float64 {
+		return bean.NewPosition(c, qty, 0.05).Rho(asof, 5000, 5000, 0.8)
+	}
+	assert.True(t, rho(call, 1) > 0, "long call")
+	assert.True(t, rho(call, -1) < 0, "short call")
+	assert.True(t, rho(put, 1) < 0, "long put")
+	assert.True(t, rho(put, -1) > 0, "short put")
+	assert.InDelta(t, 10*rho(call, 1), rho(call, 10), 1e-12, "rho scales with the quantity")
+	assert.InDelta(t, -rho(call, 3), rho(call, -3), 1e-12)
+	fut := bean.FutContract(btc, june)
+	assert.True(t, bean.NewPosition(fut, 100, 5000).Rho(asof, 5000, 5000, 0) > 0, "long future")
+	assert.Equal(t, 0.0, bean.NewPosition(bean.PerpContract(btc), 100, 5000).Rho(asof, 5000, 5000, 0))
+	assert.Equal(t, 0.0, bean.NewPosition(bean.IndexContract(btc), 100, 5000).Rho(asof, 5000, 5000, 0))
+	assert.Equal(t, 0.0, bean.NewPosition(call, 1, 0.05).Rho(may, 5000, 5000, 0.8), "no rate exposure at expiry")
+
+	p := bean.NewPortfolio()
+	p.AddPosition(bean.NewPosition(call, 2, 0.05))
+	p.AddPosition(bean.NewPosition(put, 1, 0.05))
+	p.AddPosition(bean.NewPosition(fut, 100, 5000))
+	p.AddPosition(bean.NewPosition(bean.PerpContract(btc), -100, 5000))
+	p.AddPosition(bean.NewPosition(bean.IndexContract(btc), 1, 5000))
+	mkt := func(pos bean.Position) (float64, float64, float64) { return 5000, 5000, 0.8 }
+	buckets := p.RhoBuckets(asof, mkt)
+	assert.Len(t, buckets, 2, "only the may and june expiries, no perpetual or spot bucket")
+	assert.InDelta(t, rho(call, 2)+rho(put, 1), buckets[call.ExpiryStr()], 1e-12)
+	assert.InDelta(t, bean.NewPosition(fut, 100, 5000).Rho(asof, 5000, 5000, 0.8), buckets[fut.ExpiryStr()], 1e-12)
+}
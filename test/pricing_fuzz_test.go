@@ -0,0 +1,47 @@
+package test
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"bean"
+)
+
+func FuzzOptPrice(f *testing.F) {
+	f.Add(5000.0, 5100.0, 5000.0, 0.8, 30.0, true)
+	f.Add(5000.0, 5000.0, 5000.0, 0.0, 30.0, true)
+	f.Add(5000.0, 5000.0, 5000.0, 0.8, 0.0, false)
+	f.Add(5000.0, 5000.0, 0.0, 0.8, 30.0, false)
+	f.Add(5000.0, 0.0, 5000.0, 0.8, 30.0, true)
+	f.Add(5000.0, 5000.0, 5000.0, -0.1, -1.0, true)
+	f.Add(math.NaN(), 5000.0, 5000.0, math.Inf(1), 30.0, false)
+	f.Add(1e-300, 1e300, 1e-300, 1e-300, 1e-9, true)
+
+	asof := time.Date(2019, 5, 1, 8, 0, 0, 0, time.UTC)
+	btc := bean.Pair{Coin: bean.BTC, Base: bean.USD}
+	f.Fuzz(func(t *testing.T, spot, fut, strike, vol, days float64, call bool) {
+		if math.IsNaN(days) || math.Abs(days) > 1e5 {
+			return
+		}
+		cp := bean.Put
+		if call {
+			cp = bean.Call
+		}
+		c := bean.OptContract(btc, asof.Add(time.Duration(days*24*float64(time.Hour))), strike, cp)
+		price, err := c.OptPrice(asof, spot, fut, vol)
+		if err != nil {
+			if !errors.Is(err, bean.ErrInvalidInput) {
+				t.Fatalf("unexpected error %v", err)
+			}
+			return
+		}
+		if math.IsNaN(price) || math.IsInf(price, 0) || price < 0 {
+			t.Fatalf("price %v for spot %v future %v strike %v vol %v days %v", price, spot, fut, strike, vol, days)
+		}
+		if g := bean.NewGreeksEngine(asof).Greeks(c, spot, fut, vol); math.IsNaN(g.PV) || math.IsNaN(g.Delta) {
+			t.Fatalf("greeks %+v for spot %v future %v strike %v vol %v days %v", g, spot, fut, strike, vol, days)
+		}
+	})
+}
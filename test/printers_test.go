@@ -0,0 +1,54 @@
+package test
+
+import (
+	"testing"
+	"time"
+
+	"bean"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrinters(t *testing.T) {
+	asof := time.Date(2019, 5, 1, 8, 0, 0, 0, time.UTC)
+	btc := bean.Pair{Coin: bean.BTC, Base: bean.USD}
+	call := bean.OptContract(btc, time.Date(2019, 5, 31, 8, 0, 0, 0, time.UTC), 5000, bean.Call)
+	fut := bean.FutContract(btc, time.Date(2019, 6, 28, 8, 0, 0, 0, time.UTC))
+
+	assert.Equal(t, "BTC-31MAY19-5000-C", call.String())
+	assert.Equal(t, "BTC-28JUN19", fut.String())
+	assert.Equal(t, "BTC-31MAY19-5000-C -2.5@0.065", bean.NewPosition(call, -2.5, 0.065).String())
+	assert.Equal(t, "0.25@5000.5", bean.Order{Price: 5000.5, Amount: 0.25}.String())
+
+	ob := bean.NewOrderBook(
+		[]bean.Order{{Price: 4999.5, Amount: 100}, {Price: 4999, Amount: 2500}, {Price: 4998, Amount: 10}},
+		[]bean.Order{{Price: 5000, Amount: 50}, {Price: 5000.5, Amount: 1.25}})
+	ladder := "           AMT            BID | ASK            AMT\n" +
+		"           100         4999.5 | 5000           50\n" +
+		"          2500           4999 | 5000.5         1.25\n" +
+		"            10           4998 |                \n"
+	assert.Equal(t, ladder, ob.String())
+	assert.Equal(t, "2019-05-01T08:00:00Z\n"+ladder, bean.OrderBookT{OrderBook: ob, Time: asof}.String())
+	assert.Equal(t, "depth:2 bestBid:4999.5 bestAsk:5000", ob.Brief())
+
+	defer func(depth int) { bean.LadderDepth = depth }(bean.LadderDepth)
+	bean.LadderDepth = 2
+	assert.Equal(t, ""+
+		"           AMT            BID | ASK            AMT\n"+
+		"           100         4999.5 | 5000           50\n"+
+		"          2500           4999 | 5000.5         1.25\n",
+		ob.String(), "the ladder is cut at LadderDepth")
+
+	// the zero book renders the header only
+	assert.Equal(t, "           AMT            BID | ASK            AMT\n", bean.OrderBook{}.String())
+	assert.Equal(t, "empty orderbook", bean.OrderBook{}.Brief())
+	assert.Equal(t, "empty orderbook", bean.NewOrderBook(nil, nil).Brief())
+
+	mkt := func(bean.Position) (float64, float64, float64) { return 5000, 5100, 0.8 }
+	table := bean.PositionsTable([]bean.Position{bean.NewPosition(call, 2, 0.05), bean.NewPosition(fut, -1000, 5050)}, asof, mkt)
+	assert.Equal(t, ""+
+		"CONTRACT                        QTY        ENTRY         MARK            PNL      DELTA      GAMMA         VEGA        THETA\n"+
+		"BTC-31MAY19-5000-C                2         0.05     0.100543         505.43     1.0593     0.0341        11.21       -15.07\n"+
+		"BTC-28JUN19                   -1000         5050         5100         -97.07    -1.9802     0.0000         0.00         0.00\n"+
+		"TOTAL                                                                 408.36    -0.9209     0.0341        11.21       -15.07\n",
+		table)
+}
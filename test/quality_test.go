@@ -0,0 +1,56 @@
+package test
+
+import (
+	"testing"
+	"time"
+
+	"bean"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQualityCheck(t *testing.T) {
+	t0 := date("2019-06-01 10:00")
+	book := func(s int, id int64, bid, ask float64) bean.OrderBookT {
+		var bids, asks []bean.Order
+		if bid > 0 {
+			bids = []bean.Order{{Price: bid, Amount: 1}}
+		}
+		if ask > 0 {
+			asks = []bean.Order{{Price: ask, Amount: 1}}
+		}
+		return bean.OrderBookT{OrderBook: bean.NewOrderBook(bids, asks), Time: t0.Add(time.Duration(s) * time.Second), ChangeId: id}
+	}
+	books := bean.OrderBookTS{
+		book(0, 1, 99, 101),
+		book(1, 2, 99, 101),
+		book(2, 5, 102, 101), // gap, crossed
+		book(3, 6, 100, 100), // locked
+		book(60, 7, 99, 0),   // stale, one sided
+		book(59, 6, 99, 101), // out of order twice
+	}
+	trades := bean.Transactions{
+		{Price: 100, Amount: 1, TimeStamp: t0, TxnID: "1"},
+		{Price: 100, Amount: 1, TimeStamp: t0.Add(time.Second), TxnID: "1"},
+		{Price: 150, Amount: 1, TimeStamp: t0.Add(2 * time.Second), TxnID: "2"},
+		{Price: 0, Amount: 1, TimeStamp: t0.Add(3 * time.Second), TxnID: "3"},
+	}
+	q := bean.QualityCheck{MaxStale: 30 * time.Second, ConsecutiveIds: true, MaxJump: 0.1}
+	r := q.Scan(books, trades)
+	assert.False(t, r.OK())
+	assert.Equal(t, 6, r.Books)
+	assert.Equal(t, 1, r.Count(bean.IssueSequenceGap))
+	assert.Equal(t, 1, r.Count(bean.IssueCrossed))
+	assert.Equal(t, 1, r.Count(bean.IssueLocked))
+	assert.Equal(t, 1, r.Count(bean.IssueStale))
+	assert.Equal(t, 1, r.Count(bean.IssueOneSided))
+	assert.Equal(t, 2, r.Count(bean.IssueOutOfOrder))
+	assert.Equal(t, 1, r.Count(bean.IssueDuplicate))
+	assert.Equal(t, 1, r.Count(bean.IssuePriceJump))
+	assert.Equal(t, 1, r.Count(bean.IssueBadLevel))
+	assert.Equal(t, 57*time.Second, r.StaleTime)
+	assert.Equal(t, t0.Add(time.Minute), r.End)
+	assert.Contains(t, r.String(), "crossed=1")
+
+	clean := q.Scan(books[:2], trades[:1])
+	assert.True(t, clean.OK(), clean.String())
+}
@@ -0,0 +1,91 @@
+package test
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"bean"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQuoter(t *testing.T) {
+	c, _ := bean.ContractFromName("BTC-28JUN19-8000-C")
+	q := bean.NewQuoter(bean.QuoteParams{
+		HalfWidth: 0.01, Levels: 2, LevelSpacing: 0.01, Size: 1, SizeGrowth: 2, TickSize: 0.5,
+		SkewPerUnit: 0.001, MaxInventory: 10, MinInterval: time.Second, RequoteThreshold: 0.001,
+	})
+
+	quote := q.Quote(c, time.Time{}, 1000, 0)
+	assert.Equal(t, []bean.Order{{Price: 990, Amount: 1}, {Price: 980, Amount: 2}}, quote.Bids)
+	assert.Equal(t, []bean.Order{{Price: 1010, Amount: 1}, {Price: 1020, Amount: 2}}, quote.Asks)
+
+	// long inventory skews both sides down, at the limit only asks are quoted
+	quote = q.Quote(c, time.Time{}, 1000, 5)
+	assert.Equal(t, 985.0, quote.Bids[0].Price)
+	assert.Equal(t, 1005.0, quote.Asks[0].Price)
+	quote = q.Quote(c, time.Time{}, 1000, 10)
+	assert.Empty(t, quote.Bids)
+	assert.Len(t, quote.Asks, 2)
+
+	t0 := time.Date(2019, 6, 1, 0, 0, 0, 0, time.UTC)
+	book := func(dt time.Duration, bid, ask float64) bean.OrderBookT {
+		return bean.OrderBookT{Time: t0.Add(dt), OrderBook: bean.NewOrderBook(
+			[]bean.Order{{Price: bid, Amount: 1}}, []bean.Order{{Price: ask, Amount: 1}})}
+	}
+	quote, ok := q.OnBook(c, book(0, 995, 1005), 1000, 0)
+	assert.True(t, ok)
+	_, ok = q.OnBook(c, book(2*time.Second, 995, 1005), 1000.5, 0)
+	assert.False(t, ok, "same top of book and theo within threshold")
+	_, ok = q.OnBook(c, book(2500*time.Millisecond, 996, 1005), 1000, 0)
+	assert.True(t, ok, "top of book moved")
+	_, ok = q.OnBook(c, book(3*time.Second, 997, 1005), 1000, 0)
+	assert.False(t, ok, "rate limited")
+	_, ok = q.OnBook(c, book(4*time.Second, 997, 1005), 1000, 0)
+	assert.True(t, ok, "pending requote after the interval")
+
+	// quotes do not cross the book
+	quote, ok = q.OnBook(c, book(10*time.Second, 1012, 1013), 1000, 0)
+	assert.True(t, ok)
+	assert.Equal(t, 1012.5, quote.Asks[0].Price)
+	last, _ := q.Last(c)
+	assert.Equal(t, quote, last)
+}
+
+func TestFairValue(t *testing.T) {
+	t0 := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	ob := bean.NewOrderBook([]bean.Order{{Price: 100, Amount: 3}}, []bean.Order{{Price: 102, Amount: 1}})
+	assert.Equal(t, 101.5, ob.MicroPrice())
+	ob2 := bean.NewOrderBook([]bean.Order{{Price: 200, Amount: 3}}, []bean.Order{{Price: 202, Amount: 1}})
+
+	f := bean.NewFairValue(bean.DefaultFairValueParams())
+	assert.True(t, math.IsNaN(f.Value()))
+	assert.Equal(t, 101.5, f.OnBook(t0, &ob))
+	// trade weight 0.3 with alpha 0.5
+	assert.InDelta(t, 101.5+0.15*1.5, f.OnTrade(bean.Transaction{Price: 103, Amount: 1, TimeStamp: t0.Add(time.Second)}), 1e-9)
+	assert.Equal(t, t0.Add(time.Second), f.Time())
+	// outlier ignored
+	v := f.Value()
+	assert.Equal(t, v, f.Observe(t0.Add(2*time.Second), 200, bean.SourceReference))
+	// until the price has stayed away for MaxRejections observations
+	for i := 2; i < f.MaxRejections; i++ {
+		assert.Equal(t, v, f.OnBook(t0.Add(2*time.Second), &ob2))
+	}
+	assert.Equal(t, 201.5, f.OnBook(t0.Add(3*time.Second), &ob2))
+	assert.Equal(t, t0.Add(3*time.Second), f.Time())
+	// reference prices are aged against the latest observation, not the wall clock
+	f.MaxReferenceAge = time.Minute
+	assert.Equal(t, 201.5, f.OnReference(t0.Add(-time.Minute), 202))
+	assert.InDelta(t, 201.5+0.1*0.5, f.OnReference(t0, 202), 1e-9)
+	f.Reset()
+	assert.True(t, math.IsNaN(f.Value()))
+
+	k := bean.NewFairValue(bean.FairValueParams{Method: bean.FairValueKalman, ProcessVar: 0.5, BookVar: 1, TradeVar: 1})
+	k.Observe(t0, 100, bean.SourceBook)
+	assert.Equal(t, 1.0, k.Variance())
+	// reference ignored without a variance
+	assert.Equal(t, 100.0, k.OnReference(t0, 110))
+	// variance grows to 2 over 2s, so the trade gets a weight of 2/3
+	assert.InDelta(t, 102.0, k.OnTrade(bean.Transaction{Price: 103, TimeStamp: t0.Add(2 * time.Second)}), 1e-9)
+	assert.InDelta(t, 2.0/3, k.Variance(), 1e-9)
+}
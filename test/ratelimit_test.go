@@ -0,0 +1,47 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"bean"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiter(t *testing.T) {
+	clock := bean.NewSimClock(date("2019-06-01 10:00"))
+	m := bean.NewMetrics()
+	bean.SetMetrics(m)
+	defer bean.SetMetrics(nil)
+
+	deribit, err := bean.NewExchangeRateLimiter("deribit")
+	assert.NoError(t, err)
+	deribit.Clock = clock
+	for i := 0; i < 100; i++ {
+		assert.True(t, deribit.Allow(bean.DeribitRequestCost))
+	}
+	assert.False(t, deribit.Allow(bean.DeribitRequestCost), "burst spent")
+	assert.Equal(t, 50*time.Millisecond, deribit.Delay(bean.DeribitRequestCost))
+	clock.Advance(50 * time.Millisecond)
+	assert.True(t, deribit.Allow(bean.DeribitRequestCost))
+	clock.Advance(time.Hour)
+	assert.Equal(t, float64(bean.DeribitCredits), deribit.Available(), "refill capped at the burst")
+	assert.True(t, errors.Is(deribit.Wait(context.Background(), bean.DeribitCredits+1), bean.ErrRateLimit))
+
+	clock.Set(date("2019-06-01 12:00").Add(30 * time.Second))
+	binance, _ := bean.NewExchangeRateLimiter(bean.NameBinance)
+	binance.Clock = clock
+	assert.True(t, binance.Allow(1000))
+	assert.False(t, binance.Allow(300))
+	assert.Equal(t, 30*time.Second, binance.Delay(300))
+	binance.Sync(1150)
+	assert.Equal(t, 50.0, binance.Available())
+	clock.Advance(30 * time.Second)
+	assert.Equal(t, 1200.0, binance.Available(), "new window")
+
+	snap := m.Snapshot()
+	assert.Equal(t, 104.0, snap[bean.MetricRateLimitRequests])
+	assert.Equal(t, 2.0, snap[bean.MetricRateLimitThrottled])
+}
@@ -0,0 +1,80 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"bean"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReconciler(t *testing.T) {
+	perp, _ := bean.ContractFromName("BTC-PERPETUAL")
+	acct := bean.NewAccount(bean.DeribitMarginSchedule())
+	acct.Deposit(bean.BTC, 1)
+	acct.Fill(perp, 100, 10000, 0)
+	btc := bean.Pair{Coin: bean.BTC, Base: bean.USDT}
+	blotter := bean.NewBlotter()
+	blotter.Add(bean.TradeLog{Pair: btc, Price: 30000, Quantity: 0.01, Side: bean.BUY})
+
+	state := bean.ExchangeState{
+		Balances:      map[bean.Coin]float64{bean.BTC: 1.0000001},
+		Positions:     []bean.PositionState{{Instrument: "BTC-PERPETUAL", Qty: 100, Price: 10000}},
+		PairPositions: []bean.PairPosition{{Pair: btc, Qty: 0.01, Price: 30000}},
+	}
+	var fetchErr error
+	fetch := func(ctx context.Context) (bean.ExchangeState, error) { return state, fetchErr }
+	r := bean.NewReconciler(fetch, acct, blotter, 1e-6)
+	var reported [][]bean.Break
+	r.OnBreaks(func(b []bean.Break) { reported = append(reported, b) })
+
+	breaks, err := r.Reconcile(context.Background())
+	assert.NoError(t, err)
+	assert.Empty(t, breaks, "within tolerance")
+	assert.Empty(t, reported)
+
+	// a missed fill on each side and an ETH position unknown locally
+	state.Balances = map[bean.Coin]float64{bean.BTC: 0.999}
+	state.Positions = []bean.PositionState{{Instrument: "BTC-PERPETUAL", Qty: 150, Price: 10000},
+		{Instrument: "ETH-PERPETUAL", Qty: -3, Price: 2000}}
+	state.PairPositions = []bean.PairPosition{{Pair: btc, Qty: 0.015, Price: 31000}}
+	breaks, err = r.Reconcile(context.Background())
+	assert.NoError(t, err)
+	if assert.Len(t, breaks, 4) {
+		assert.Equal(t, bean.BreakBalance, breaks[0].Kind)
+		assert.Equal(t, "BTC", breaks[0].Key)
+		assert.InDelta(t, -0.001, breaks[0].Diff(), 1e-12)
+		assert.Equal(t, bean.BreakPairPosition, breaks[1].Kind)
+		assert.InDelta(t, 0.005, breaks[1].Diff(), 1e-12)
+		assert.Equal(t, bean.Break{Kind: bean.BreakPosition, Key: "BTC-PERPETUAL", Local: 100, Exchange: 150,
+			Time: breaks[2].Time}, breaks[2])
+		assert.Equal(t, "ETH-PERPETUAL", breaks[3].Key)
+		assert.False(t, breaks[3].Adopted)
+	}
+	assert.Len(t, reported, 1)
+	last, _ := r.Last()
+	assert.Equal(t, breaks, last)
+	assert.InDelta(t, 0.01, blotter.Position(btc), 1e-12, "not adopted")
+
+	// adopting the exchange state clears the breaks
+	r.Adopt = true
+	breaks, err = r.Reconcile(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, breaks, 4)
+	assert.True(t, breaks[0].Adopted)
+	assert.Equal(t, 0.999, acct.Balance(bean.BTC))
+	pos, ok := acct.Position("ETH-PERPETUAL")
+	assert.True(t, ok)
+	assert.Equal(t, -3.0, pos.Qty())
+	assert.InDelta(t, 0.015, blotter.Position(btc), 1e-12)
+	assert.InDelta(t, 10, blotter.PnL(btc, 31000), 1e-9, "adjusted at the reported price")
+	breaks, err = r.Reconcile(context.Background())
+	assert.NoError(t, err)
+	assert.Empty(t, breaks)
+	assert.Len(t, reported, 2)
+
+	fetchErr = errors.New("down")
+	_, err = r.Reconcile(context.Background())
+	assert.Error(t, err)
+}
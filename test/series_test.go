@@ -0,0 +1,144 @@
+package test
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"bean"
+	"bean/db/series"
+	"github.com/influxdata/influxdb/client/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSeriesSink(t *testing.T) {
+	var m sync.Mutex
+	var lines []string
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.Lock()
+		defer m.Unlock()
+		calls++
+		if calls == 1 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		assert.Equal(t, "/write", r.URL.Path)
+		assert.Equal(t, "md", r.URL.Query().Get("db"))
+		body, _ := io.ReadAll(r.Body)
+		lines = append(lines, strings.Split(strings.TrimSpace(string(body)), "\n")...)
+	}))
+	defer srv.Close()
+
+	w, err := series.NewInfluxWriter(client.HTTPConfig{Addr: srv.URL}, "md")
+	if !assert.NoError(t, err) {
+		return
+	}
+	sink := series.NewSink(w, 2)
+	sink.RetryBackoff = time.Millisecond
+	t0 := time.Unix(1559383200, 0)
+	ob := bean.OrderBookT{Time: t0, OrderBook: bean.NewOrderBook(
+		[]bean.Order{{Price: 99, Amount: 1}}, []bean.Order{{Price: 101, Amount: 2}})}
+	sink.TopOfBook("BTC-PERPETUAL", ob)
+	sink.Trades("BTC PERP", bean.Transactions{{Price: 100, Amount: -1, TimeStamp: t0, Maker: bean.Buyer}})
+	c, _ := bean.ContractFromName("BTC-PERPETUAL")
+	tk := bean.TickerFromOrderBook(c, ob)
+	sink.Ticker(tk)
+	assert.Equal(t, 3, sink.Pending())
+
+	assert.NoError(t, sink.Flush(context.Background()))
+	assert.Equal(t, 0, sink.Pending())
+	assert.Equal(t, []string{
+		"top_of_book,instrument=BTC-PERPETUAL ask=101,ask_amount=2,bid=99,bid_amount=1,mid=100 1559383200000000000",
+		`trade,instrument=BTC\ PERP,side=sell amount=1,price=100 1559383200000000000`,
+		"ticker,instrument=BTC-PERPETUAL ask=101,ask_amount=2,bid=99,bid_amount=1 1559383200000000000",
+	}, lines)
+	assert.Equal(t, 3, calls, "one retry then two batches")
+
+	// a failing database keeps the points
+	w, _ = series.NewInfluxWriter(client.HTTPConfig{Addr: "http://127.0.0.1:1"}, "md")
+	sink = series.NewSink(w, 10)
+	sink.MaxRetries = 0
+	sink.TopOfBook("BTC-PERPETUAL", ob)
+	assert.Error(t, sink.Flush(context.Background()))
+	assert.Equal(t, 1, sink.Pending())
+}
+
+// recordDriver is a database/sql driver recording the statements executed and their arguments, standing in for
+// postgres which is not vendored
+type recordDriver struct {
+	m       sync.Mutex
+	execs   []string
+	args    [][]driver.Value
+	commits int
+}
+
+type recordConn struct{ d *recordDriver }
+type recordStmt struct {
+	d     *recordDriver
+	query string
+}
+
+func (d *recordDriver) Open(string) (driver.Conn, error) { return recordConn{d}, nil }
+func (c recordConn) Prepare(query string) (driver.Stmt, error) {
+	return recordStmt{c.d, query}, nil
+}
+func (c recordConn) Close() error              { return nil }
+func (c recordConn) Begin() (driver.Tx, error) { return c, nil }
+func (c recordConn) Commit() error {
+	c.d.m.Lock()
+	defer c.d.m.Unlock()
+	c.d.commits++
+	return nil
+}
+func (c recordConn) Rollback() error { return nil }
+func (s recordStmt) Close() error    { return nil }
+func (s recordStmt) NumInput() int   { return -1 }
+func (s recordStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.d.m.Lock()
+	defer s.d.m.Unlock()
+	s.d.execs = append(s.d.execs, strings.Join(strings.Fields(s.query), " "))
+	s.d.args = append(s.d.args, args)
+	return driver.RowsAffected(1), nil
+}
+func (s recordStmt) Query([]driver.Value) (driver.Rows, error) { return nil, driver.ErrSkip }
+
+func TestTimescaleWriter(t *testing.T) {
+	d := &recordDriver{}
+	sql.Register("record-timescale", d)
+	db, _ := sql.Open("record-timescale", "")
+	defer db.Close()
+	ctx := context.Background()
+
+	_, err := series.NewTimescaleWriter(ctx, db, "md; DROP TABLE x")
+	assert.Error(t, err)
+	w, err := series.NewTimescaleWriter(ctx, db, "md")
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Len(t, d.execs, 3)
+	assert.True(t, strings.HasPrefix(d.execs[0], "CREATE TABLE IF NOT EXISTS md ("))
+	assert.Equal(t, "SELECT create_hypertable('md', 'time', if_not_exists => TRUE)", d.execs[1])
+
+	t0 := time.Unix(1559383200, 0)
+	d.execs, d.args = nil, nil
+	assert.NoError(t, w.Write(ctx, []series.Point{
+		{Measurement: "trade", Tags: map[string]string{"instrument": "BTC-PERPETUAL"},
+			Fields: map[string]float64{"price": 100, "amount": 1, "bad": math.NaN()}, Time: t0},
+	}))
+	assert.Equal(t, 1, d.commits)
+	// one row per finite field in key order
+	if assert.Len(t, d.args, 2) {
+		assert.Equal(t, []driver.Value{"2019-06-01T10:00:00Z", "trade", `{"instrument":"BTC-PERPETUAL"}`, "amount", 1.0}, d.args[0])
+		assert.Equal(t, "price", d.args[1][3])
+	}
+	assert.Equal(t, "INSERT INTO md (time, measurement, tags, field, value) VALUES ($1, $2, $3, $4, $5)", d.execs[0])
+}
@@ -0,0 +1,207 @@
+package test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/rpc"
+	"strings"
+	"testing"
+	"time"
+
+	"bean"
+	"bean/event"
+	beanrpc "bean/rpc"
+	"bean/server"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServer(t *testing.T) {
+	s := server.New()
+	s.SetBook("BTC-PERPETUAL", bean.NewOrderBookCOW([]bean.Order{{Price: 9000, Amount: 10}}, []bean.Order{{Price: 9001, Amount: 5}}))
+	ts := httptest.NewServer(s.Handler())
+	defer ts.Close()
+
+	asof := time.Date(2019, 6, 1, 8, 0, 0, 0, time.UTC)
+	req := server.PricingRequest{Instrument: "BTC-28JUN19-9000-C", Asof: asof, Spot: 9000, Future: 9050, Vol: 0.8}
+	body, _ := json.Marshal(req)
+	resp, err := http.Post(ts.URL+"/v1/price", "application/json", bytes.NewReader(body))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	var priced server.PricingResponse
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&priced))
+	resp.Body.Close()
+
+	c, _ := bean.ContractFromName("BTC-28JUN19-9000-C")
+	want, _ := c.OptPrice(asof, 9000, 9050, 0.8)
+	assert.InDelta(t, want, priced.Price, 1e-9)
+	assert.InDelta(t, want/9000, priced.PriceCoin, 1e-12)
+	assert.True(t, priced.Delta > 0 && priced.Vega > 0)
+
+	// round trip through the implied vol
+	implied, err := s.Price(server.PricingRequest{Instrument: req.Instrument, Asof: asof, Spot: 9000, Future: 9050, Price: priced.PriceCoin})
+	assert.NoError(t, err)
+	assert.InDelta(t, 0.8, implied.Vol, 1e-6)
+
+	body, _ = json.Marshal(server.PricingRequest{Instrument: "BTC-28JUN19-9000-C", Asof: asof, Spot: -1, Future: 9050, Vol: 0.8})
+	resp, _ = http.Post(ts.URL+"/v1/price", "application/json", bytes.NewReader(body))
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp.Body.Close()
+
+	resp, _ = http.Get(ts.URL + "/v1/books/BTC-PERPETUAL")
+	var book server.BookResponse
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&book))
+	resp.Body.Close()
+	assert.Equal(t, []bean.Order{{Price: 9000, Amount: 10}}, book.Bids)
+	resp, _ = http.Get(ts.URL + "/v1/books/ETH-PERPETUAL")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	resp.Body.Close()
+}
+
+func TestRPCPricing(t *testing.T) {
+	s := server.New()
+	s.SetBook("BTC-PERPETUAL", bean.NewOrderBookCOW([]bean.Order{{Price: 9000, Amount: 10}}, []bean.Order{{Price: 9001, Amount: 5}}))
+	rs := rpc.NewServer()
+	assert.NoError(t, beanrpc.NewRPCPricingD(s).Register(rs))
+	ts := httptest.NewServer(rs)
+	defer ts.Close()
+	c, err := beanrpc.NewRPCPricingC("tcp", strings.TrimPrefix(ts.URL, "http://"))
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer c.Close()
+
+	asof := time.Date(2019, 6, 1, 8, 0, 0, 0, time.UTC)
+	req := server.PricingRequest{Instrument: "BTC-28JUN19-9000-C", Asof: asof, Spot: 9000, Future: 9050, Vol: 0.8}
+	want, _ := s.Price(req)
+	priced, err := c.Price(req)
+	assert.NoError(t, err)
+	assert.Equal(t, want, priced)
+	req.Spot = -1
+	_, err = c.Price(req)
+	assert.Error(t, err)
+
+	instruments, err := c.Instruments()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"BTC-PERPETUAL"}, instruments)
+	book, err := c.Book("BTC-PERPETUAL")
+	assert.NoError(t, err)
+	assert.Equal(t, []bean.Order{{Price: 9001, Amount: 5}}, book.Asks)
+	_, err = c.Book("ETH-PERPETUAL")
+	assert.Error(t, err)
+}
+
+func TestFanout(t *testing.T) {
+	f := server.NewFanout(16, 1)
+	ts := httptest.NewServer(f)
+	defer ts.Close()
+	url := "ws" + strings.TrimPrefix(ts.URL, "http")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	c, err := server.DialFanout(ctx, url, "book.*", "ticker.BTC-PERPETUAL")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer c.Close()
+	next := func() server.Message {
+		select {
+		case msg := <-c.Messages():
+			return msg
+		case <-ctx.Done():
+			t.Fatal("no message")
+		}
+		return server.Message{}
+	}
+	ack := next()
+	assert.Equal(t, "subscribe", ack.Op)
+	assert.Equal(t, []string{"book.*", "ticker.BTC-PERPETUAL"}, ack.Topics)
+	assert.Equal(t, 1, f.Clients())
+
+	asof := time.Date(2019, 6, 1, 8, 0, 0, 0, time.UTC)
+	f.OnTrade("BTC-PERPETUAL", bean.Transaction{Price: 9000, Amount: 1, TimeStamp: asof})
+	ob := bean.NewOrderBook([]bean.Order{{Price: 9000, Amount: 10}, {Price: 8999, Amount: 1}}, []bean.Order{{Price: 9001, Amount: 5}})
+	f.OnBook("BTC-PERPETUAL", bean.OrderBookT{OrderBook: ob, Time: asof})
+	perp := bean.PerpContract(bean.Pair{Coin: bean.BTC, Base: bean.USD})
+	f.OnTicker(bean.TickerFromOrderBook(perp, bean.OrderBookT{OrderBook: ob, Time: asof}))
+
+	book := next()
+	assert.Equal(t, "book.BTC-PERPETUAL", book.Topic)
+	assert.Equal(t, []bean.Order{{Price: 9000, Amount: 10}}, book.Bids)
+	assert.True(t, book.Time.Equal(asof))
+	ticker := next()
+	assert.Equal(t, server.Float(9001), ticker.Ticker.BestAsk)
+	assert.True(t, math.IsNaN(float64(ticker.Ticker.MarkPrice)))
+
+	// the client turns the feed back into events
+	assert.NoError(t, c.Unsubscribe("ticker.BTC-PERPETUAL"))
+	assert.Equal(t, []string{"book.*"}, next().Topics)
+	events := c.Events(ctx)
+	f.OnBook("ETH-PERPETUAL", bean.OrderBookT{OrderBook: ob, Time: asof})
+	e := <-events
+	assert.Equal(t, event.BookEvent, e.Kind)
+	assert.Equal(t, "ETH-PERPETUAL", e.Instrument)
+	assert.Equal(t, 9000.0, e.Book.BestBid().Price)
+}
+
+// rawFanoutConn completes a websocket handshake on a bare TCP connection, to send frames the client would not
+func rawFanoutConn(t *testing.T, addr string) (net.Conn, *bufio.Reader) {
+	conn, err := net.Dial("tcp", addr)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	conn.Write([]byte("GET / HTTP/1.1\r\nHost: " + addr + "\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n"))
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if !assert.NoError(t, err) || !assert.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode) {
+		t.FailNow()
+	}
+	return conn, br
+}
+
+// maskedFrame is a final client text frame, masked with a zero key
+func maskedFrame(payload string) []byte {
+	return append([]byte{0x81, 0x80 | byte(len(payload)), 0, 0, 0, 0}, payload...)
+}
+
+func TestFanoutDropsClients(t *testing.T) {
+	f := server.NewFanout(1, 0)
+	ts := httptest.NewServer(f)
+	defer ts.Close()
+	addr := strings.TrimPrefix(ts.URL, "http://")
+	clients := func(n int) bool {
+		deadline := time.Now().Add(5 * time.Second)
+		for f.Clients() != n && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		return f.Clients() == n
+	}
+
+	// unmasked client frames are a protocol error
+	conn, _ := rawFanoutConn(t, addr)
+	defer conn.Close()
+	assert.True(t, clients(1))
+	conn.Write([]byte{0x81, 2, '{', '}'})
+	assert.True(t, clients(0))
+
+	// a client which stops reading is disconnected instead of blocking the fan-out
+	conn, br := rawFanoutConn(t, addr)
+	defer conn.Close()
+	conn.Write(maskedFrame(`{"op":"subscribe","topics":["trade.*"]}`))
+	ack, err := br.Peek(2)
+	assert.NoError(t, err)
+	assert.Equal(t, byte(0x81), ack[0])
+	assert.Equal(t, 1, f.Clients())
+	txn := bean.Transaction{Price: 9000, Amount: 1, TimeStamp: time.Date(2019, 6, 1, 8, 0, 0, 0, time.UTC)}
+	deadline := time.Now().Add(5 * time.Second)
+	for f.Clients() > 0 && time.Now().Before(deadline) {
+		f.OnTrade(strings.Repeat("X", 1<<16), txn)
+	}
+	assert.Equal(t, 0, f.Clients())
+}
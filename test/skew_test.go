@@ -0,0 +1,77 @@
+package test
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"bean"
+	"github.com/stretchr/testify/assert"
+)
+
+// skewSurface has vols falling with log moneyness, puts over calls
+type skewSurface struct {
+	atm, slope float64
+}
+
+func (s skewSurface) Vol(expiry time.Time, strike, forward float64) float64 {
+	return s.atm + s.slope*math.Log(strike/forward)
+}
+
+func TestSkewMetrics(t *testing.T) {
+	asof := time.Date(2019, 5, 1, 8, 0, 0, 0, time.UTC)
+	expiry := asof.AddDate(0, 0, 30)
+	years := 30.0 / 365
+
+	strike, vol := bean.DeltaStrike(bean.FlatVol(0.8), asof, expiry, 5000, 0.25)
+	assert.Equal(t, 0.8, vol)
+	sd := vol * math.Sqrt(years)
+	d1 := (math.Log(5000/strike) + sd*sd/2) / sd
+	assert.InDelta(t, 0.25, 0.5*math.Erfc(-d1/math.Sqrt2), 1e-9)
+	put, _ := bean.DeltaStrike(bean.FlatVol(0.8), asof, expiry, 5000, -0.25)
+	assert.True(t, put < 5000 && strike > 5000)
+
+	m, err := bean.SkewMetrics(bean.FlatVol(0.8), asof, expiry, 5000)
+	assert.NoError(t, err)
+	assert.Equal(t, 0.8, m.ATM)
+	assert.InDelta(t, 0, m.RR25, 1e-12)
+	assert.InDelta(t, 0, m.BF25, 1e-12)
+
+	m, err = bean.SkewMetrics(skewSurface{0.8, -0.3}, asof, expiry, 5000)
+	assert.NoError(t, err)
+	assert.InDelta(t, 0.8, m.ATM, 1e-12)
+	assert.True(t, m.RR25 < 0)
+	assert.True(t, m.Put25 > m.ATM && m.Call25 < m.ATM)
+
+	_, err = bean.SkewMetrics(bean.FlatVol(0.8), asof, asof, 5000)
+	assert.True(t, errors.Is(err, bean.ErrInvalidInput))
+	_, err = bean.SkewMetrics(bean.FlatVol(math.NaN()), asof, expiry, 5000)
+	assert.True(t, errors.Is(err, bean.ErrInvalidInput))
+}
+
+func TestSkewTracker(t *testing.T) {
+	asof := time.Date(2019, 5, 1, 8, 0, 0, 0, time.UTC)
+	btc := bean.Pair{Coin: bean.BTC, Base: bean.USD}
+	week, month := 7*24*time.Hour, 30*24*time.Hour
+	tr := bean.NewSkewTracker(month, week)
+	assert.Equal(t, []time.Duration{week, month}, tr.Tenors)
+
+	for i, atm := range []float64{0.6, 0.62, 0.58, 0.6, 0.61, 0.8} {
+		day := asof.AddDate(0, 0, i)
+		assert.NoError(t, tr.Update(skewSurface{atm, -0.2}, day, bean.NewFuturesCurve(btc, day, 5000)))
+	}
+	ms := tr.Metrics(month)
+	assert.Len(t, ms, 6)
+	assert.Equal(t, 0.8, ms.ATMs()[5].Value)
+	assert.Len(t, ms.RiskReversals(), 6)
+	assert.Equal(t, []float64{0.8, 0.8}, tr.TermStructure())
+
+	// the last vol is far above the others
+	r := tr.Richness(month, 0)
+	assert.True(t, r.ATM > 1.5)
+	assert.InDelta(t, r.ATM, tr.Metrics(month).ATMs().RollingZScore(6)[0].Value, 1e-12)
+	assert.Len(t, tr.Metrics(month).ATMs().RollingZScore(3), 4)
+
+	assert.Error(t, tr.Update(bean.FlatVol(0.5), asof, bean.NewFuturesCurve(btc, asof, math.NaN())))
+}
@@ -0,0 +1,89 @@
+package test
+
+import (
+	"testing"
+	"time"
+
+	"bean"
+	"bean/stats"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPerformanceStats(t *testing.T) {
+	start := time.Date(2019, 5, 1, 0, 0, 0, 0, time.UTC)
+	values := []float64{0, 2, 1, 4, 3, -1, 2}
+	var pnl bean.TimeSeries
+	for i, v := range values {
+		pnl = append(pnl, bean.TimePoint{Time: start.AddDate(0, 0, i), Value: v})
+	}
+	dd, peak, trough := stats.MaxDrawdown(pnl)
+	assert.Equal(t, 5.0, dd)
+	assert.Equal(t, start.AddDate(0, 0, 3), peak)
+	assert.Equal(t, start.AddDate(0, 0, 5), trough)
+	assert.InDelta(t, 0.5, stats.HitRate(pnl), 1e-12)
+	assert.InDelta(t, 365.0, stats.PeriodsPerYear(pnl), 1e-9)
+	assert.True(t, stats.Sortino(pnl) > stats.Sharpe(pnl))
+
+	positions := bean.TimeSeries{
+		{Time: start, Value: 0},
+		{Time: start.AddDate(0, 0, 1), Value: -2},
+		{Time: start.AddDate(0, 0, 3), Value: 0},
+		{Time: start.AddDate(0, 0, 4), Value: 0},
+	}
+	inMarket, avg, max := stats.Exposure(positions)
+	assert.InDelta(t, 0.5, inMarket, 1e-12)
+	assert.InDelta(t, 1.0, avg, 1e-12)
+	assert.Equal(t, 2.0, max)
+}
+
+func TestCovariance(t *testing.T) {
+	btc := []float64{0.01, -0.02, 0.03, 0.0, -0.01, 0.02}
+	eth := make([]float64, len(btc))
+	for i, r := range btc {
+		eth[i] = 2 * r
+	}
+	m := stats.Covariance(map[bean.Coin][]float64{bean.BTC: btc, bean.ETH: eth}, 0)
+	assert.InDelta(t, 1.0, m.Corr(bean.BTC, bean.ETH), 1e-12)
+	assert.InDelta(t, 2.0, m.HedgeRatio(bean.ETH, bean.BTC), 1e-12)
+	assert.InDelta(t, 4*m.Get(bean.BTC, bean.BTC), m.Get(bean.ETH, bean.ETH), 1e-15)
+
+	ewma := stats.Covariance(map[bean.Coin][]float64{bean.BTC: btc, bean.ETH: eth}, 0.94)
+	assert.InDelta(t, 1.0, ewma.Corr(bean.BTC, bean.ETH), 1e-12)
+
+	rolling := stats.RollingCovariance(map[bean.Coin][]float64{bean.BTC: btc, bean.ETH: eth}, 4, 0)
+	assert.Equal(t, 3, len(rolling))
+}
+
+func TestOrderFlowFeatures(t *testing.T) {
+	t0 := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	books := bean.OrderBookTS{
+		{Time: t0, OrderBook: bean.NewOrderBook([]bean.Order{{Price: 99, Amount: 2}}, []bean.Order{{Price: 101, Amount: 4}})},
+		// bid queue depleted by 1
+		{Time: t0.Add(10 * time.Second), OrderBook: bean.NewOrderBook([]bean.Order{{Price: 99, Amount: 1}}, []bean.Order{{Price: 101, Amount: 4}})},
+		// new better bid
+		{Time: t0.Add(20 * time.Second), OrderBook: bean.NewOrderBook([]bean.Order{{Price: 100, Amount: 3}}, []bean.Order{{Price: 101, Amount: 4}})},
+	}
+	trades := bean.Transactions{
+		{Price: 101, Amount: 1, TimeStamp: t0.Add(5 * time.Second), Maker: bean.Seller},
+		{Price: 99, Amount: 2, TimeStamp: t0.Add(15 * time.Second), Maker: bean.Buyer},
+	}
+	windows := []time.Duration{30 * time.Second, 10 * time.Second}
+	fs := stats.OrderFlowFeatures(books, trades, 30*time.Second, windows...)
+	assert.Len(t, fs, 1)
+	f := fs[0]
+	assert.Equal(t, t0.Add(30*time.Second), f.Time)
+	w := f.Windows[0]
+	assert.Equal(t, 2.0, w.OFI)
+	assert.Equal(t, -1.0, w.SignedVolume)
+	assert.Equal(t, 3.0, w.Volume)
+	assert.InDelta(t, 1.0/30, w.BidDepletion, 1e-12)
+	assert.Equal(t, 0.0, w.AskDepletion)
+	assert.InDelta(t, 2.0/30, w.TradeIntensity, 1e-12)
+	assert.InDelta(t, 2.0/30, w.UpdateIntensity, 1e-12)
+	assert.Equal(t, stats.FlowWindow{}, f.Windows[1])
+
+	names := stats.FlowFeatureNames(windows)
+	assert.Len(t, f.Vector(), len(names))
+	assert.Equal(t, "ofi_30s", names[0])
+	assert.Equal(t, "update_intensity_10s", names[len(names)-1])
+}
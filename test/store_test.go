@@ -0,0 +1,135 @@
+package test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"bean"
+	"bean/db/store"
+	"bean/db/store/sqlite"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemStorage(t *testing.T) {
+	testStorage(t, store.NewMemStorage())
+}
+
+func TestSQLStorage(t *testing.T) {
+	s, err := sqlite.Open(filepath.Join(t.TempDir(), "bean.db"))
+	if assert.NoError(t, err) {
+		testStorage(t, s)
+	}
+}
+
+// testStorage runs the round trips of a storage, closing it
+func testStorage(t *testing.T, s store.Storage) {
+	defer s.Close()
+	t0 := date("2019-06-01 10:00")
+	btc := bean.Pair{Coin: bean.BTC, Base: bean.USD}
+
+	ob := bean.NewOrderBook([]bean.Order{{Price: 99, Amount: 1}}, []bean.Order{{Price: 101, Amount: 2}})
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, s.SaveBookSnapshot("BTC-PERPETUAL", bean.OrderBookT{OrderBook: ob, Time: t0.Add(time.Duration(i) * time.Minute)}))
+	}
+	ob.InsertBid(bean.Order{Price: 100, Amount: 1}) // not seen by the saved snapshots
+	assert.NoError(t, s.SaveTrades("BTC-PERPETUAL", bean.Transactions{
+		{Pair: btc, Price: 100, Amount: 1, TimeStamp: t0.Add(90 * time.Second)},
+		{Pair: btc, Price: 101, Amount: 2, TimeStamp: t0.Add(30 * time.Second)},
+	}))
+
+	r, err := s.LoadRange("BTC-PERPETUAL", t0.Add(time.Second), t0.Add(2*time.Minute))
+	assert.NoError(t, err)
+	assert.Len(t, r.Books, 1)
+	assert.Equal(t, 99.0, r.Books[0].BestBid().Price)
+	assert.Equal(t, []float64{101, 100}, []float64{r.Trades[0].Price, r.Trades[1].Price})
+	r, _ = s.LoadRange("ETH-PERPETUAL", t0, t0.Add(time.Hour))
+	assert.Empty(t, r.Books)
+
+	fut, _ := bean.ContractFromName("BTC-28JUN19")
+	assert.NoError(t, s.SavePositions("main", t0, []bean.Position{bean.NewPosition(fut, 10, 8000)}))
+	assert.NoError(t, s.SavePositions("main", t0.Add(time.Hour), []bean.Position{bean.NewPosition(fut, 20, 8100)}))
+	pos, at, err := s.LoadPositions("main", t0.Add(30*time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, t0, at)
+	assert.Equal(t, 10.0, pos[0].Qty())
+	pos, _, _ = s.LoadPositions("main", t0.Add(-time.Minute))
+	assert.Empty(t, pos)
+
+	// closing all positions is a snapshot of its own
+	assert.NoError(t, s.SavePositions("main", t0.Add(2*time.Hour), nil))
+	pos, at, err = s.LoadPositions("main", t0.Add(3*time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, t0.Add(2*time.Hour), at)
+	assert.Empty(t, pos, "not the positions before")
+	pos, _, _ = s.LoadPositions("main", t0.Add(90*time.Minute))
+	assert.Equal(t, 20.0, pos[0].Qty())
+}
+
+func TestJSONLAudit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	a, err := store.OpenJSONLAudit(path)
+	assert.NoError(t, err)
+	t0 := date("2019-06-01 10:00")
+	o := bean.OrderStatus{OrderID: "1", Instrument: "BTC-PERPETUAL", State: bean.ALIVE}
+	assert.NoError(t, a.Append(bean.OrderRecord(t0, o)))
+	assert.NoError(t, a.Append(bean.ExecutionRecord(bean.ExecutionReport{OrderID: "1", Instrument: "BTC-PERPETUAL", Time: t0.Add(time.Minute), Price: 10000, Qty: 10})))
+	o.State = bean.FILLED
+	assert.NoError(t, a.Append(bean.OrderRecord(t0.Add(time.Minute), o)))
+	assert.NoError(t, a.Append(bean.OrderRecord(t0.Add(2*time.Minute), bean.OrderStatus{OrderID: "2", Instrument: "ETH-PERPETUAL", State: bean.ALIVE})))
+
+	recs, err := a.Query(bean.AuditQuery{OrderID: "1"})
+	assert.NoError(t, err)
+	if assert.Len(t, recs, 3) {
+		assert.Equal(t, []int64{1, 2, 3}, []int64{recs[0].Seq, recs[1].Seq, recs[2].Seq})
+		assert.Equal(t, bean.AuditExecution, recs[1].Kind)
+		assert.Equal(t, 10000.0, recs[1].Execution.Price)
+		assert.Equal(t, bean.FILLED, recs[2].State)
+	}
+	recs, err = a.Query(bean.AuditQuery{Instrument: "BTC-PERPETUAL", Start: t0.Add(time.Minute), End: t0.Add(2 * time.Minute)})
+	assert.NoError(t, err)
+	assert.Len(t, recs, 2)
+	assert.NoError(t, a.Close())
+
+	// reopened, the log is appended to
+	a, err = store.OpenJSONLAudit(path)
+	assert.NoError(t, err)
+	defer a.Close()
+	assert.NoError(t, a.Append(bean.OrderRecord(t0.Add(3*time.Minute), bean.OrderStatus{OrderID: "2", Instrument: "ETH-PERPETUAL", State: bean.CANCELLED})))
+	recs, err = a.Query(bean.AuditQuery{Instrument: "ETH-PERPETUAL"})
+	assert.NoError(t, err)
+	if assert.Len(t, recs, 2) {
+		assert.Equal(t, int64(5), recs[1].Seq)
+	}
+}
+
+func TestJSONLAuditTornLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	a, err := store.OpenJSONLAudit(path)
+	assert.NoError(t, err)
+	t0 := date("2019-06-01 10:00")
+	assert.NoError(t, a.Append(bean.OrderRecord(t0, bean.OrderStatus{OrderID: "1", Instrument: "BTC-PERPETUAL", State: bean.ALIVE})))
+	assert.NoError(t, a.Close())
+
+	// a crash in the middle of a record
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	assert.NoError(t, err)
+	f.Write([]byte(`{"seq":2,"time":"2019-06-01T10:01:00Z","kind":"OR`))
+	f.Close()
+	a, err = store.OpenJSONLAudit(path)
+	assert.NoError(t, err, "the torn record is cut off")
+	defer a.Close()
+	assert.NoError(t, a.Append(bean.OrderRecord(t0.Add(time.Minute), bean.OrderStatus{OrderID: "1", Instrument: "BTC-PERPETUAL", State: bean.FILLED})))
+	recs, err := a.Query(bean.AuditQuery{})
+	assert.NoError(t, err)
+	if assert.Len(t, recs, 2) {
+		assert.Equal(t, int64(2), recs[1].Seq)
+		assert.Equal(t, bean.FILLED, recs[1].State)
+	}
+
+	// corruption before the last record is an error
+	assert.NoError(t, os.WriteFile(path, []byte("{bad\n"+`{"seq":1,"kind":"ORDER"}`+"\n"), 0644))
+	_, err = store.OpenJSONLAudit(path)
+	assert.Error(t, err)
+}
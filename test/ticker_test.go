@@ -0,0 +1,53 @@
+package test
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"bean"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestContractTicker(t *testing.T) {
+	t0 := time.Date(2019, 5, 1, 8, 0, 0, 0, time.UTC)
+	perp, _ := bean.ContractFromName("BTC-PERPETUAL")
+	ob := bean.NewOrderBook(
+		[]bean.Order{{Price: 9990, Amount: 100}, {Price: 9980, Amount: 200}},
+		[]bean.Order{{Price: 10010, Amount: 50}})
+	tk := bean.TickerFromOrderBook(perp, bean.OrderBookT{OrderBook: ob, Time: t0})
+	assert.Equal(t, 9990.0, tk.BestBid)
+	assert.Equal(t, 100.0, tk.BestBidAmount)
+	assert.Equal(t, 10010.0, tk.BestAsk)
+	assert.Equal(t, 50.0, tk.BestAskAmount)
+	assert.Equal(t, 10000.0, tk.Mid())
+	assert.Equal(t, 20.0, tk.Spread())
+	assert.True(t, tk.Valid())
+	assert.True(t, math.IsNaN(tk.MarkPrice))
+	assert.True(t, math.IsNaN(tk.LastPrice))
+
+	// one sided books are not valid, their mid is the quoted side
+	bidOnly := bean.TickerFromOrderBook(perp, bean.OrderBookT{OrderBook: bean.NewOrderBook(
+		[]bean.Order{{Price: 9990, Amount: 100}}, nil), Time: t0})
+	assert.False(t, bidOnly.Valid())
+	assert.Equal(t, 9990.0, bidOnly.Mid())
+
+	ts := bean.ContractTickerTS{
+		{Contract: perp, BestBid: 101, BestAsk: 103, MarkPrice: 102.5, Time: t0.Add(2 * time.Minute)},
+		{Contract: perp, BestBid: 99, BestAsk: 101, MarkPrice: 100.5, Time: t0},
+		{Contract: perp, BestBid: 100, BestAsk: 102, MarkPrice: 101.5, Time: t0.Add(time.Minute)},
+	}.Sort()
+	_, ok := ts.GetTicker(t0.Add(-time.Second))
+	assert.False(t, ok, "no ticker before the first")
+	last, ok := ts.GetTicker(t0.Add(90 * time.Second))
+	assert.True(t, ok)
+	assert.Equal(t, t0.Add(time.Minute), last.Time, "last ticker at or before the time")
+	last, _ = ts.GetTicker(t0.Add(time.Minute))
+	assert.Equal(t, 101.0, last.Mid())
+
+	mids := ts.Mids()
+	assert.Equal(t, []float64{100, 101, 102}, []float64{mids[0].Value, mids[1].Value, mids[2].Value})
+	marks := ts.Marks()
+	assert.Equal(t, 102.5, marks[2].Value)
+	assert.Equal(t, t0.Add(2*time.Minute), marks[2].Time)
+}
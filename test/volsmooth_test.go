@@ -0,0 +1,123 @@
+package test
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"bean"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSmoothVols(t *testing.T) {
+	asof := time.Date(2019, 5, 1, 8, 0, 0, 0, time.UTC)
+	btc, _ := bean.ContractFromName("BTC-PERPETUAL")
+	near, far := asof.AddDate(0, 0, 30), asof.AddDate(0, 0, 60)
+	quote := func(expiry time.Time, strike float64, vol float64) bean.StrikeIV {
+		cp := bean.Call
+		if strike < 5000 {
+			cp = bean.Put
+		}
+		return bean.StrikeIV{Contract: bean.OptContract(btc.Underlying(), expiry, strike, cp), Forward: 5000, MidIV: vol}
+	}
+	ivs := []bean.StrikeIV{
+		quote(near, 4000, 0.8), quote(near, 4500, 0.8), quote(near, 5000, 0.8),
+		quote(near, 5500, 1.6), // a spike above the chord of its neighbours
+		quote(near, 6000, 0.8), quote(near, 6500, 0.8),
+		quote(far, 4500, 0.5), quote(far, 5000, 0.5), quote(far, 5500, 0.5),
+	}
+	bad := quote(far, 6000, 0.5)
+	bad.Quality = bean.IVOneSided
+	ivs = append(ivs, bad)
+
+	vs, adj := bean.SmoothVols(asof, ivs)
+	assert.Equal(t, []time.Time{near, far}, vs.Expiries())
+	kinds := make(map[bean.VolArbKind]int)
+	for _, a := range adj {
+		kinds[a.Kind]++
+	}
+	assert.True(t, kinds[bean.VolButterfly] > 0)
+	assert.Equal(t, 3, kinds[bean.VolCalendar])
+	assert.Equal(t, 1, kinds[bean.VolBadQuote])
+	last := adj[len(adj)-1]
+	assert.Equal(t, bad.Contract, last.Contract)
+	assert.True(t, last.Dropped())
+
+	// the spike is smoothed and near call prices are convex at the strikes quoted
+	assert.True(t, vs.Vol(near, 5500, 5000) < 1.2)
+	convex := func(expiry time.Time, from, to float64) {
+		prev, slope := math.NaN(), -1.0
+		for k := from; k <= to; k += 500 {
+			c := bean.OptContract(btc.Underlying(), expiry, k, bean.Call)
+			price, _ := c.OptPrice(asof, 5000, 5000, vs.Vol(expiry, k, 5000))
+			if !math.IsNaN(prev) {
+				s := (price - prev) / 500
+				assert.True(t, s >= slope-1e-9 && s <= 1e-9, "strike %v", k)
+				slope = s
+			}
+			prev = price
+		}
+	}
+	convex(near, 4000, 6500)
+	// and so are far ones once raised to the near total variance
+	convex(far, 4500, 5500)
+	// far total variance is raised to the near one
+	assert.InDelta(t, 0.8*math.Sqrt(0.5), vs.Vol(far, 5000, 5000), 1e-9)
+	assert.InDelta(t, 0.8, vs.Vol(near.Add(-24*time.Hour), 4500, 5000), 1e-9)
+	assert.True(t, math.IsNaN(vs.Vol(asof, 5000, 5000)))
+
+	// raising a far smile to the total variance of a near one between its strikes must keep it convex
+	vs, adj = bean.SmoothVols(asof, []bean.StrikeIV{
+		quote(near, 4000, 1.2), quote(near, 5000, 1.7), quote(near, 6000, 1.3),
+		quote(far, 4500, 0.55), quote(far, 5000, 0.54), quote(far, 5500, 0.75),
+	})
+	assert.NotEmpty(t, adj)
+	convex(far, 4500, 5500)
+}
+
+func TestVolEvents(t *testing.T) {
+	asof := time.Date(2019, 5, 1, 8, 0, 0, 0, time.UTC)
+	btc, _ := bean.ContractFromName("BTC-PERPETUAL")
+	under := btc.Underlying()
+	near, far := asof.AddDate(0, 0, 30), asof.AddDate(0, 0, 60)
+	event := asof.AddDate(0, 0, 45)
+	var ivs []bean.StrikeIV
+	for _, e := range []struct {
+		expiry time.Time
+		vol    float64
+	}{{near, 0.6}, {far, 0.8}} {
+		for _, strike := range []float64{4000, 5000, 6000} {
+			cp := bean.Call
+			if strike < 5000 {
+				cp = bean.Put
+			}
+			ivs = append(ivs, bean.StrikeIV{Contract: bean.OptContract(under, e.expiry, strike, cp), Forward: 5000, MidIV: e.vol})
+		}
+	}
+
+	// without events the forward variance is the same on every day between expiries
+	vs, _ := bean.SmoothVols(asof, ivs)
+	before := bean.ForwardVol(vs, asof, near, event.Add(-24*time.Hour), 5000, 5000)
+	around := bean.ForwardVol(vs, asof, event.Add(-24*time.Hour), event.Add(24*time.Hour), 5000, 5000)
+	assert.InDelta(t, before, around, 1e-9)
+
+	assert.True(t, errors.Is(bean.AddVolEvents(under, bean.VolEvent{Name: "FOMC", Time: event, Weight: 10},
+		bean.VolEvent{Name: "calm", Time: event, Weight: -1}), bean.ErrInvalidInput))
+	assert.Empty(t, bean.VolEvents(under))
+	assert.NoError(t, bean.AddVolEvents(under, bean.VolEvent{Name: "FOMC", Time: event, Weight: 10}))
+	defer bean.ClearVolEvents(under)
+	assert.Len(t, bean.VolEvents(under), 1)
+	assert.InDelta(t, 70.0/365, bean.EventYears(under, asof, far), 1e-12)
+	assert.InDelta(t, 30.0/365, bean.EventYears(under, asof, near), 1e-12)
+
+	// with the event most of the variance between expiries is around it, the expiries still repriced
+	vs, _ = bean.SmoothVols(asof, ivs)
+	assert.InDelta(t, 0.6, vs.Vol(near, 5000, 5000), 1e-9)
+	assert.InDelta(t, 0.8, vs.Vol(far, 5000, 5000), 1e-9)
+	evBefore := bean.ForwardVol(vs, asof, near, event.Add(-24*time.Hour), 5000, 5000)
+	evAround := bean.ForwardVol(vs, asof, event.Add(-24*time.Hour), event.Add(24*time.Hour), 5000, 5000)
+	assert.True(t, evBefore < before)
+	// two days and the event, 12 days of variance, over two days
+	assert.InDelta(t, 6*evBefore*evBefore, evAround*evAround, 1e-9)
+}
@@ -1,6 +1,10 @@
 package bean
 
-import "time"
+import (
+	"math"
+	"sort"
+	"time"
+)
 
 type Ticker struct {
 	BestBid       float64
@@ -21,3 +25,111 @@ type BookTicker struct {
 	BestAskAmount float64
 	UpdatedTime   time.Time
 }
+
+// ContractTicker is the normalised ticker channel data of a contract: best bid/offer, last trade, mark and index prices
+type ContractTicker struct {
+	Contract      *Contract
+	BestBid       float64
+	BestAsk       float64
+	BestBidAmount float64
+	BestAskAmount float64
+	LastPrice     float64
+	MarkPrice     float64
+	IndexPrice    float64
+	OpenInterest  float64 // in contracts
+	Volume24H     float64 // contracts traded over the last 24 hours
+	Time          time.Time
+}
+
+// ContractTickerTS is a timeseries of tickers of a single contract
+type ContractTickerTS []ContractTicker
+
+// TickerFromOrderBook takes the top of book of an orderbook as a ticker. Last, mark and index prices are left as NaN
+func TickerFromOrderBook(c *Contract, ob OrderBookT) ContractTicker {
+	bid := ob.BestBid()
+	ask := ob.BestAsk()
+	return ContractTicker{
+		Contract:      c,
+		BestBid:       bid.Price,
+		BestAsk:       ask.Price,
+		BestBidAmount: bid.Amount,
+		BestAskAmount: ask.Amount,
+		LastPrice:     math.NaN(),
+		MarkPrice:     math.NaN(),
+		IndexPrice:    math.NaN(),
+		OpenInterest:  math.NaN(),
+		Volume24H:     math.NaN(),
+		Time:          ob.Time,
+	}
+}
+
+// Mid returns the mid of the best bid and offer, or the one available side
+func (t ContractTicker) Mid() float64 {
+	if math.IsNaN(t.BestAsk) {
+		return t.BestBid
+	}
+	if math.IsNaN(t.BestBid) {
+		return t.BestAsk
+	}
+	return (t.BestBid + t.BestAsk) / 2.0
+}
+
+func (t ContractTicker) Spread() float64 {
+	return t.BestAsk - t.BestBid
+}
+
+// Valid is true when both sides are quoted
+func (t ContractTicker) Valid() bool {
+	return !math.IsNaN(t.BestBid) && !math.IsNaN(t.BestAsk)
+}
+
+// Sort sorts the tickers by time
+func (ts ContractTickerTS) Sort() ContractTickerTS {
+	sort.Slice(ts, func(i, j int) bool { return ts[i].Time.Before(ts[j].Time) })
+	return ts
+}
+
+// GetTicker returns the last ticker at or before t, assuming ts is sorted. Returns false if there is none
+func (ts ContractTickerTS) GetTicker(t time.Time) (ContractTicker, bool) {
+	i := sort.Search(len(ts), func(i int) bool { return ts[i].Time.After(t) })
+	if i == 0 {
+		return ContractTicker{}, false
+	}
+	return ts[i-1], true
+}
+
+// Mids returns the timeseries of mid prices
+func (ts ContractTickerTS) Mids() TimeSeries {
+	res := make(TimeSeries, len(ts))
+	for i, t := range ts {
+		res[i] = TimePoint{Time: t.Time, Value: t.Mid()}
+	}
+	return res
+}
+
+// OpenInterests returns the timeseries of open interests
+func (ts ContractTickerTS) OpenInterests() TimeSeries {
+	res := make(TimeSeries, len(ts))
+	for i, t := range ts {
+		res[i] = TimePoint{Time: t.Time, Value: t.OpenInterest}
+	}
+	return res
+}
+
+// Volumes returns the timeseries of 24 hour volumes
+func (ts ContractTickerTS) Volumes() TimeSeries {
+	res := make(TimeSeries, len(ts))
+	for i, t := range ts {
+		res[i] = TimePoint{Time: t.Time, Value: t.Volume24H}
+	}
+	return res
+}
+
+// Marks returns the timeseries of mark prices
+func (ts ContractTickerTS) Marks() TimeSeries {
+	res := make(TimeSeries, len(ts))
+	for i, t := range ts {
+		res[i] = TimePoint{Time: t.Time, Value: t.MarkPrice}
+	}
+	return res
+}
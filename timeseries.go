@@ -3,6 +3,7 @@ package bean
 import (
 	"encoding/csv"
 	"fmt"
+	"math"
 	"os"
 	"time"
 )
@@ -38,3 +39,41 @@ func (ts TimeSeries) ToCSV(filename string) {
 	csvWriter.WriteAll(data)
 	csvWriter.Flush()
 }
+
+// ZScore returns the number of standard deviations of the last value from the mean of the last window values,
+// all values for a window of zero. NaN with fewer than 2 values or no dispersion
+func (ts TimeSeries) ZScore(window int) float64 {
+	if window > 0 && window < len(ts) {
+		ts = ts[len(ts)-window:]
+	}
+	n := float64(len(ts))
+	if n < 2 {
+		return math.NaN()
+	}
+	mean := 0.0
+	for _, p := range ts {
+		mean += p.Value
+	}
+	mean /= n
+	variance := 0.0
+	for _, p := range ts {
+		variance += (p.Value - mean) * (p.Value - mean)
+	}
+	sd := math.Sqrt(variance / (n - 1))
+	if sd == 0 {
+		return math.NaN()
+	}
+	return (ts[len(ts)-1].Value - mean) / sd
+}
+
+// RollingZScore returns the z-score of each value over the window ending at it, from the window-th value onwards
+func (ts TimeSeries) RollingZScore(window int) TimeSeries {
+	var res TimeSeries
+	for i := window - 1; i < len(ts); i++ {
+		if i < 1 {
+			continue
+		}
+		res = append(res, TimePoint{Time: ts[i].Time, Value: ts[:i+1].ZScore(window)})
+	}
+	return res
+}
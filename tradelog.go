@@ -215,6 +215,7 @@ func (trds1 TradeLogS) Minus(trds2 TradeLogS) (res TradeLogS) {
 	return
 }
 
+// ToTransactions converts the trades to transactions, with the commissions in Coin or Base as fees in Base
 func (trades TradeLogS) ToTransactions() (txns Transactions) {
 	for _, trd := range trades {
 		sign := 1.0
@@ -231,6 +232,12 @@ func (trades TradeLogS) ToTransactions() (txns Transactions) {
 			Maker:     maker,
 			TxnID:     trd.OrderID,
 		}
+		switch trd.CommissionAsset {
+		case trd.Pair.Base:
+			txn.Fee = trd.Commission
+		case trd.Pair.Coin:
+			txn.Fee = trd.Commission * trd.Price
+		}
 		txns = append(txns, txn)
 	}
 	return txns
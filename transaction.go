@@ -24,6 +24,7 @@ type Transaction struct {
 	TimeStamp time.Time
 	Maker     TraderType // buyer or seller
 	TxnID     string
+	Fee       float64 // fee paid in Base on our own fills, zero for market trades
 }
 
 type ContractTXN struct {
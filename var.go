@@ -0,0 +1,247 @@
+package bean
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// VaRMethod selects how VaR is computed
+type VaRMethod int
+
+const (
+	// HistoricalVaR fully revalues the positions under each historical return of their underlyings
+	HistoricalVaR VaRMethod = iota
+	// DeltaGammaVaR approximates the PnL distribution from the delta and gamma of the positions and the
+	// covariance of the historical returns, assumed normal
+	DeltaGammaVaR
+)
+
+// VaRResult holds the VaR and expected shortfall as positive losses in RHS coin, with the contribution of each
+// position (in the order of Positions) to both. Contributions sum to the totals
+type VaRResult struct {
+	VaR, ES         float64
+	VaRContribution []float64
+	ESContribution  []float64
+}
+
+// VaR returns the value at risk and expected shortfall of the positions at confidence (e.g. 0.99) over horizon
+// periods of the historical returns. returns holds the log returns of each underlying per period, aligned in time
+// and of the same length. Underlyings without returns are not shocked
+func (p *portfolio) VaR(asof time.Time, mkt PositionMarket, confidence float64, horizon int, returns map[Pair][]float64,
+	method VaRMethod) VaRResult {
+	if horizon < 1 {
+		horizon = 1
+	}
+	if method == DeltaGammaVaR {
+		return p.deltaGammaVaR(asof, mkt, confidence, horizon, returns)
+	}
+	return p.historicalVaR(asof, mkt, confidence, horizon, returns)
+}
+
+// horizonReturns sums the returns over overlapping windows of horizon periods
+func horizonReturns(rets []float64, horizon int) []float64 {
+	if len(rets) < horizon {
+		return nil
+	}
+	res := make([]float64, len(rets)-horizon+1)
+	sum := 0.0
+	for i, r := range rets {
+		sum += r
+		if i >= horizon {
+			sum -= rets[i-horizon]
+		}
+		if i >= horizon-1 {
+			res[i-horizon+1] = sum
+		}
+	}
+	return res
+}
+
+func (p *portfolio) historicalVaR(asof time.Time, mkt PositionMarket, confidence float64, horizon int,
+	returns map[Pair][]float64) (res VaRResult) {
+	shocks := make(map[Pair][]float64)
+	n := -1
+	for pair, rets := range returns {
+		shocks[pair] = horizonReturns(rets, horizon)
+		if n < 0 || len(shocks[pair]) < n {
+			n = len(shocks[pair])
+		}
+	}
+	res.VaRContribution = make([]float64, len(p.positions))
+	res.ESContribution = make([]float64, len(p.positions))
+	if n <= 0 {
+		return
+	}
+
+	// losses[k][i] is the loss of position i in scenario k
+	losses := make([][]float64, n)
+	totals := make([]float64, n)
+	for k := range losses {
+		losses[k] = make([]float64, len(p.positions))
+	}
+	for i, pos := range p.positions {
+		spot, fut, vol := mkt(pos)
+		base := pos.PV(asof, spot, fut, vol)
+		s, ok := shocks[pos.Underlying()]
+		for k := 0; k < n; k++ {
+			if !ok {
+				continue
+			}
+			move := math.Exp(s[k])
+			losses[k][i] = base - pos.PV(asof, spot*move, fut*move, vol)
+			totals[k] += losses[k][i]
+		}
+	}
+
+	order := make([]int, n)
+	for k := range order {
+		order[k] = k
+	}
+	sort.SliceStable(order, func(a, b int) bool { return totals[order[a]] > totals[order[b]] })
+	tail := int(math.Ceil(float64(n) * (1 - confidence)))
+	if tail < 1 {
+		tail = 1
+	}
+	varScenario := order[tail-1]
+	res.VaR = totals[varScenario]
+	copy(res.VaRContribution, losses[varScenario])
+	for _, k := range order[:tail] {
+		res.ES += totals[k] / float64(tail)
+		for i := range p.positions {
+			res.ESContribution[i] += losses[k][i] / float64(tail)
+		}
+	}
+	return
+}
+
+func (p *portfolio) deltaGammaVaR(asof time.Time, mkt PositionMarket, confidence float64, horizon int,
+	returns map[Pair][]float64) (res VaRResult) {
+	var pairs []Pair
+	index := make(map[Pair]int)
+	for pair := range returns {
+		pairs = append(pairs, pair)
+	}
+	sort.Slice(pairs, func(i, j int) bool { return pairs[i].String() < pairs[j].String() })
+	for i, pair := range pairs {
+		index[pair] = i
+	}
+	cov := covariance(pairs, returns)
+	h := float64(horizon)
+
+	// cash delta and gamma per unit log return, by position and summed by underlying
+	n := len(p.positions)
+	under := make([]int, n)
+	dd := make([]float64, n)
+	gg := make([]float64, n)
+	totD := make([]float64, len(pairs))
+	totG := make([]float64, len(pairs))
+	for i, pos := range p.positions {
+		j, ok := index[pos.Underlying()]
+		under[i] = j
+		if !ok {
+			under[i] = -1
+			continue
+		}
+		spot, fut, vol := mkt(pos)
+		g := pos.Greeks(asof, spot, fut, vol)
+		dd[i] = g.Delta * spot
+		gg[i] = g.Gamma * spot / 0.01
+		totD[j] += dd[i]
+		totG[j] += gg[i]
+	}
+
+	// PnL = D.r + 1/2 G r^2 with r ~ N(0, h cov): mean 1/2 G var, variance D'CD h + 1/2 sum G_i G_j C_ij^2 h^2
+	variance := 0.0
+	for a := range pairs {
+		for b := range pairs {
+			variance += totD[a]*totD[b]*cov[a][b]*h + 0.5*totG[a]*totG[b]*cov[a][b]*cov[a][b]*h*h
+		}
+	}
+	sd := math.Sqrt(variance)
+	z := math.Sqrt2 * math.Erfinv(2*confidence-1)
+	esz := math.Exp(-z*z/2) / math.Sqrt(2*math.Pi) / (1 - confidence)
+
+	res.VaRContribution = make([]float64, n)
+	res.ESContribution = make([]float64, n)
+	for i := range p.positions {
+		j := under[i]
+		if j < 0 {
+			continue
+		}
+		mean := 0.5 * gg[i] * cov[j][j] * h
+		// Euler allocation of the standard deviation, which is homogeneous of degree one in position sizes
+		dvar := 0.0
+		for b := range pairs {
+			dvar += dd[i]*totD[b]*cov[j][b]*h + 0.5*gg[i]*totG[b]*cov[j][b]*cov[j][b]*h*h
+		}
+		sdi := 0.0
+		if sd > 0 {
+			sdi = dvar / sd
+		}
+		res.VaRContribution[i] = z*sdi - mean
+		res.ESContribution[i] = esz*sdi - mean
+		res.VaR += res.VaRContribution[i]
+		res.ES += res.ESContribution[i]
+	}
+	return
+}
+
+// covariance returns the sample covariance matrix of the returns of the pairs over their common length
+func covariance(pairs []Pair, returns map[Pair][]float64) [][]float64 {
+	n := -1
+	for _, pair := range pairs {
+		if n < 0 || len(returns[pair]) < n {
+			n = len(returns[pair])
+		}
+	}
+	series := make([][]float64, len(pairs))
+	for a, pair := range pairs {
+		series[a] = returns[pair][:n]
+	}
+	return Covariance(series, 0)
+}
+
+// Covariance returns the covariance matrix of return series of the same length. lambda in (0, 1) weights the
+// returns exponentially (EWMA, e.g. 0.94) with the last return weighted most, lambda = 0 weights them equally
+func Covariance(series [][]float64, lambda float64) [][]float64 {
+	cov := make([][]float64, len(series))
+	for i := range cov {
+		cov[i] = make([]float64, len(series))
+	}
+	if len(series) == 0 || len(series[0]) < 2 {
+		return cov
+	}
+	n := len(series[0])
+	weights := make([]float64, n)
+	total := 0.0
+	for k := range weights {
+		weights[k] = 1.0
+		if lambda > 0 && lambda < 1 {
+			weights[k] = math.Pow(lambda, float64(n-1-k))
+		}
+		total += weights[k]
+	}
+	means := make([]float64, len(series))
+	for i, s := range series {
+		for k, r := range s {
+			means[i] += weights[k] * r / total
+		}
+	}
+	// unbiased for equal weights, the EWMA estimate is left as the plain weighted average
+	norm := total
+	if lambda <= 0 || lambda >= 1 {
+		norm = float64(n - 1)
+	}
+	for i := range series {
+		for j := i; j < len(series); j++ {
+			v := 0.0
+			for k := 0; k < n; k++ {
+				v += weights[k] * (series[i][k] - means[i]) * (series[j][k] - means[j])
+			}
+			cov[i][j] = v / norm
+			cov[j][i] = cov[i][j]
+		}
+	}
+	return cov
+}
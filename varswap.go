@@ -0,0 +1,152 @@
+package bean
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// VolIndexTenor is the constant maturity of DVOL and VIX style vol indices
+const VolIndexTenor = 30 * 24 * time.Hour
+
+// VarianceStrike is the model free variance swap strike of an expiry, replicated from the strip of out of the money
+// options as in the VIX white paper
+type VarianceStrike struct {
+	Expiry   time.Time
+	Years    float64
+	Forward  float64
+	K0       float64 // the strike at or below the forward splitting puts and calls
+	Strikes  int     // strikes in the strip
+	Variance float64 // annualized
+}
+
+// Vol returns the square root of the variance, the vol index of the expiry
+func (v VarianceStrike) Vol() float64 {
+	return math.Sqrt(v.Variance)
+}
+
+// stripMid returns the mid of a quote in LHS coin, false without a bid or with a crossed book
+func stripMid(q ContractTicker) (float64, bool) {
+	if !validPrice(q.BestBid) || !validPrice(q.BestAsk) || q.BestBid > q.BestAsk {
+		return 0, false
+	}
+	return (q.BestBid + q.BestAsk) / 2, true
+}
+
+// VarianceStrike returns the variance swap strike of an expiry from the mids of its options. Puts below K0 and calls
+// above it are used, their average at K0, stopping after two strikes in a row without a bid. Prices are undiscounted
+// as rates on the coin are zero. Fails with ErrInvalidInput for an expired expiry or one without a strip
+func (ch *OptionChain) VarianceStrike(expiry time.Time) (VarianceStrike, error) {
+	v := VarianceStrike{Expiry: expiry, Years: expiry.Sub(ch.Asof).Hours() / 24 / 365, Forward: ch.Forward(expiry)}
+	if !(v.Years > 0) || !validPrice(v.Forward) {
+		return v, fmt.Errorf("%w: no variance strike for %s expiring %s", ErrInvalidInput, ch.Underlying,
+			expiry.Format(time.RFC3339))
+	}
+	strikes := ch.Strikes(expiry)
+	k0 := -1
+	for i, k := range strikes {
+		if k <= v.Forward {
+			k0 = i
+		}
+	}
+	if k0 < 0 {
+		return v, fmt.Errorf("%w: no %s strike below the forward %v expiring %s", ErrInvalidInput, ch.Underlying,
+			v.Forward, expiry.Format(time.RFC3339))
+	}
+	v.K0 = strikes[k0]
+
+	// forward value of the out of the money option of each strike used
+	prices := make(map[int]float64)
+	call, put, _ := ch.CallPut(expiry, v.K0)
+	cm, okCall := stripMid(call)
+	pm, okPut := stripMid(put)
+	switch {
+	case okCall && okPut:
+		prices[k0] = (cm + pm) / 2 * v.Forward
+	case okCall:
+		prices[k0] = cm * v.Forward
+	case okPut:
+		prices[k0] = pm * v.Forward
+	}
+	walk := func(from, step int, cp CallOrPut) {
+		missing := 0
+		for i := from; i >= 0 && i < len(strikes) && missing < 2; i += step {
+			call, put, _ := ch.CallPut(expiry, strikes[i])
+			q := call
+			if cp == Put {
+				q = put
+			}
+			mid, ok := stripMid(q)
+			if !ok {
+				missing++
+				continue
+			}
+			missing = 0
+			prices[i] = mid * v.Forward
+		}
+	}
+	walk(k0-1, -1, Put)
+	walk(k0+1, 1, Call)
+	if len(prices) < 2 {
+		return v, fmt.Errorf("%w: fewer than 2 quoted %s strikes expiring %s", ErrInvalidInput, ch.Underlying,
+			expiry.Format(time.RFC3339))
+	}
+
+	var used []int
+	for i := range strikes {
+		if _, ok := prices[i]; ok {
+			used = append(used, i)
+		}
+	}
+	sum := 0.0
+	for j, i := range used {
+		var dk float64
+		switch {
+		case j == 0:
+			dk = strikes[used[1]] - strikes[i]
+		case j == len(used)-1:
+			dk = strikes[i] - strikes[used[j-1]]
+		default:
+			dk = (strikes[used[j+1]] - strikes[used[j-1]]) / 2
+		}
+		sum += dk / (strikes[i] * strikes[i]) * prices[i]
+	}
+	v.Strikes = len(used)
+	v.Variance = (2*sum - math.Pow(v.Forward/v.K0-1, 2)) / v.Years
+	return v, nil
+}
+
+// VolIndex returns the vol of a constant tenor, VolIndexTenor for a DVOL style index. The total variance of the
+// expiries either side of the tenor is interpolated linearly in time, and the variance of the nearest one is used
+// when the tenor is outside the expiries. Expiries within a day, whose strips are too short, are skipped. Fails
+// with ErrInvalidInput when no expiry has a variance strike
+func (ch *OptionChain) VolIndex(tenor time.Duration) (float64, error) {
+	target := ch.Asof.Add(tenor)
+	var near, next *VarianceStrike
+	for _, expiry := range ch.Expiries() {
+		if expiry.Sub(ch.Asof) < 24*time.Hour {
+			continue
+		}
+		v, err := ch.VarianceStrike(expiry)
+		if err != nil {
+			continue
+		}
+		if !expiry.After(target) {
+			near = &v
+		} else if next == nil {
+			next = &v
+		}
+	}
+	years := tenor.Hours() / 24 / 365
+	switch {
+	case near == nil && next == nil:
+		return math.NaN(), fmt.Errorf("%w: no %s variance strike", ErrInvalidInput, ch.Underlying)
+	case near == nil:
+		return next.Vol(), nil
+	case next == nil:
+		return near.Vol(), nil
+	}
+	w := (years - near.Years) / (next.Years - near.Years)
+	variance := (near.Variance*near.Years*(1-w) + next.Variance*next.Years*w) / years
+	return math.Sqrt(variance), nil
+}
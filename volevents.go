@@ -0,0 +1,87 @@
+package bean
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// VolEvent is a date expected to move an underlying more than a normal day, such as an ETF decision, a halving or
+// an FOMC meeting. Its weight is the variance it adds in days of normal variance: an event moving the price like
+// three normal days has a weight of 2
+type VolEvent struct {
+	Name   string
+	Time   time.Time
+	Weight float64
+}
+
+var (
+	volEventsLock sync.RWMutex
+	volEvents     = make(map[Pair][]VolEvent)
+)
+
+// AddVolEvents registers events of an underlying, weighting the time interpolation of the surfaces built after.
+// None is registered if a weight is negative or not a number, as variance time would then run backwards
+func AddVolEvents(p Pair, events ...VolEvent) error {
+	for _, e := range events {
+		if !(e.Weight >= 0) || math.IsInf(e.Weight, 0) {
+			return fmt.Errorf("%w: weight %v of vol event %s", ErrInvalidInput, e.Weight, e.Name)
+		}
+	}
+	volEventsLock.Lock()
+	defer volEventsLock.Unlock()
+	evs := append(volEvents[p], events...)
+	sort.SliceStable(evs, func(i, j int) bool { return evs[i].Time.Before(evs[j].Time) })
+	volEvents[p] = evs
+	return nil
+}
+
+// ClearVolEvents removes the events of an underlying
+func ClearVolEvents(p Pair) {
+	volEventsLock.Lock()
+	defer volEventsLock.Unlock()
+	delete(volEvents, p)
+}
+
+// VolEvents returns the events of an underlying in time order
+func VolEvents(p Pair) []VolEvent {
+	volEventsLock.RLock()
+	defer volEventsLock.RUnlock()
+	return append([]VolEvent(nil), volEvents[p]...)
+}
+
+// EventYears returns the variance time from one time to another in years: the calendar time plus the weights of
+// the events of the underlying after from and up to to
+func EventYears(p Pair, from, to time.Time) float64 {
+	return eventYears(VolEvents(p), from, to)
+}
+
+func eventYears(events []VolEvent, from, to time.Time) float64 {
+	days := to.Sub(from).Hours() / 24
+	for _, e := range events {
+		if e.Time.After(from) && !e.Time.After(to) {
+			days += e.Weight
+		}
+	}
+	return days / 365
+}
+
+// ForwardVol returns the vol implied by a surface between two expiries at a strike, from the difference of their
+// total variances. NaN if the variance falls, a calendar arbitrage of the surface
+func ForwardVol(vs VolSurface, asof, from, to time.Time, strike, forward float64) float64 {
+	t1, t2 := from.Sub(asof).Hours()/24/365, to.Sub(asof).Hours()/24/365
+	if !(t2 > t1) {
+		return math.NaN()
+	}
+	w2 := math.Pow(vs.Vol(to, strike, forward), 2) * t2
+	w1 := 0.0
+	if t1 > 0 {
+		w1 = math.Pow(vs.Vol(from, strike, forward), 2) * t1
+	}
+	if w2 < w1 {
+		return math.NaN()
+	}
+	return math.Sqrt((w2 - w1) / (t2 - t1))
+}
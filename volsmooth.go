@@ -0,0 +1,291 @@
+package bean
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// VolArbKind is why a quote was changed by SmoothVols
+type VolArbKind string
+
+const (
+	VolBadQuote  VolArbKind = "BAD_QUOTE" // no two sided mid vol, dropped
+	VolButterfly VolArbKind = "BUTTERFLY" // call prices not convex in strike
+	VolCalendar  VolArbKind = "CALENDAR"  // total variance below that of an earlier expiry
+)
+
+// VolAdjustment is a quote changed or dropped by SmoothVols
+type VolAdjustment struct {
+	Contract *Contract
+	Kind     VolArbKind
+	Vol      float64 // mid vol quoted
+	Smoothed float64 // NaN if dropped
+}
+
+// Dropped is true if the quote is not part of the surface
+func (a VolAdjustment) Dropped() bool {
+	return math.IsNaN(a.Smoothed)
+}
+
+func (a VolAdjustment) String() string {
+	if a.Dropped() {
+		return fmt.Sprintf("%s %s %.4f dropped", a.Contract.Name(), a.Kind, a.Vol)
+	}
+	return fmt.Sprintf("%s %s %.4f -> %.4f", a.Contract.Name(), a.Kind, a.Vol, a.Smoothed)
+}
+
+// volSlice is the total variance of an expiry by log moneyness ln(K/F), in increasing order of moneyness
+type volSlice struct {
+	expiry    time.Time
+	years     float64
+	varYears  float64 // weighted by the events of the underlying
+	moneyness []float64
+	variance  []float64
+}
+
+// at returns the total variance at a log moneyness, linear between points and flat beyond them
+func (s volSlice) at(k float64) float64 {
+	n := len(s.moneyness)
+	i := sort.SearchFloat64s(s.moneyness, k)
+	switch {
+	case i == 0:
+		return s.variance[0]
+	case i == n:
+		return s.variance[n-1]
+	}
+	w := (k - s.moneyness[i-1]) / (s.moneyness[i] - s.moneyness[i-1])
+	return s.variance[i-1] + w*(s.variance[i]-s.variance[i-1])
+}
+
+// SmoothVolSurface is a vol surface built by SmoothVols, free of static arbitrage at the strikes quoted. Total
+// variance is interpolated linearly in log moneyness within an expiry and in variance time between expiries, the
+// calendar time plus the weights of the VolEvents of the underlying registered when the surface is built. The
+// variance per unit of variance time is flat before the first expiry and after the last
+type SmoothVolSurface struct {
+	asof   time.Time
+	events []VolEvent
+	slices []volSlice
+}
+
+func (s *SmoothVolSurface) Vol(expiry time.Time, strike, forward float64) float64 {
+	years := expiry.Sub(s.asof).Hours() / 24 / 365
+	if len(s.slices) == 0 || !(years > 0) || !(strike > 0) || !(forward > 0) {
+		return math.NaN()
+	}
+	k := math.Log(strike / forward)
+	t := eventYears(s.events, s.asof, expiry)
+	i := sort.Search(len(s.slices), func(i int) bool { return s.slices[i].varYears >= t })
+	var w float64
+	switch {
+	case i == 0:
+		w = s.slices[0].at(k) * t / s.slices[0].varYears
+	case i == len(s.slices):
+		last := s.slices[i-1]
+		w = last.at(k) * t / last.varYears
+	default:
+		lo, hi := s.slices[i-1], s.slices[i]
+		f := (t - lo.varYears) / (hi.varYears - lo.varYears)
+		w = lo.at(k) + f*(hi.at(k)-lo.at(k))
+	}
+	return math.Sqrt(w / years)
+}
+
+// Expiries returns the expiries of the surface in time order
+func (s *SmoothVolSurface) Expiries() []time.Time {
+	res := make([]time.Time, len(s.slices))
+	for i, sl := range s.slices {
+		res[i] = sl.expiry
+	}
+	return res
+}
+
+// smilePoint is the out of the money quote of a strike
+type smilePoint struct {
+	iv     StrikeIV
+	strike float64
+	vol    float64
+}
+
+// SmoothVols builds a vol surface from the mid vols of a chain, as solved by SolveChainIVs, adjusting the fewest
+// quotes it can to remove static arbitrage. The out of the money option of each strike is used. Within an expiry
+// call prices are made convex and decreasing in strike by the least total change, and quotes whose price must go
+// below intrinsic are dropped. Across expiries the total variance of the previous expiry at the same moneyness is
+// a floor of the same program, so the smile stays convex once raised to it. When both cannot be met the floor wins
+// and the smile is left as the butterfly pass made it. Returns the surface and the quotes adjusted or dropped, in
+// the order of ivs
+func SmoothVols(asof time.Time, ivs []StrikeIV) (*SmoothVolSurface, []VolAdjustment) {
+	var adjustments []VolAdjustment
+	byExpiry := make(map[time.Time]map[float64]smilePoint)
+	var expiries []time.Time
+	for _, iv := range ivs {
+		c := iv.Contract
+		if c == nil || !c.IsOption() {
+			continue
+		}
+		otm := (c.CallPut() == Call) == (c.Strike() >= iv.Forward)
+		if !otm {
+			continue
+		}
+		if !iv.OK() || !(iv.MidIV > 0) || !validPrice(iv.Forward) || !(c.ExpiryYears(asof) > 0) {
+			adjustments = append(adjustments, VolAdjustment{Contract: c, Kind: VolBadQuote, Vol: iv.MidIV, Smoothed: math.NaN()})
+			continue
+		}
+		points, ok := byExpiry[c.Expiry()]
+		if !ok {
+			points = make(map[float64]smilePoint)
+			byExpiry[c.Expiry()] = points
+			expiries = append(expiries, c.Expiry())
+		}
+		points[c.Strike()] = smilePoint{iv: iv, strike: c.Strike(), vol: iv.MidIV}
+	}
+	sort.Slice(expiries, func(i, j int) bool { return expiries[i].Before(expiries[j]) })
+
+	s := &SmoothVolSurface{asof: asof}
+	if len(expiries) > 0 {
+		for _, p := range byExpiry[expiries[0]] {
+			s.events = VolEvents(p.iv.Contract.Underlying())
+			break
+		}
+	}
+	for _, expiry := range expiries {
+		smile := make([]smilePoint, 0, len(byExpiry[expiry]))
+		for _, p := range byExpiry[expiry] {
+			smile = append(smile, p)
+		}
+		sort.Slice(smile, func(i, j int) bool { return smile[i].strike < smile[j].strike })
+		years := smile[0].iv.Contract.ExpiryYears(asof)
+		var floor []float64
+		if n := len(s.slices); n > 0 {
+			floor = make([]float64, len(smile))
+			for i, p := range smile {
+				floor[i] = s.slices[n-1].at(math.Log(p.strike / p.iv.Forward))
+			}
+		}
+		smile, adj, err := smoothButterfly(smile, years, floor)
+		if err != nil && floor != nil {
+			Log().Warnf("vol smoothing of %s cannot meet the calendar floor convexly: %v", expiry.Format(time.RFC3339), err)
+			smile, adj, err = smoothButterfly(smile, years, nil)
+		}
+		if err != nil {
+			// intrinsic values always meet the constraints, keep the quotes unchanged if the solver fails
+			Log().Warnf("vol smoothing failed for %s: %v", expiry.Format(time.RFC3339), err)
+		}
+		adjustments = append(adjustments, adj...)
+		if len(smile) == 0 {
+			continue
+		}
+
+		sl := volSlice{expiry: expiry, years: years, varYears: eventYears(s.events, asof, expiry)}
+		for i, p := range smile {
+			k := math.Log(p.strike / p.iv.Forward)
+			w := p.vol * p.vol * years
+			if n := len(s.slices); n > 0 {
+				if prev := s.slices[n-1].at(k); w < prev-1e-12 {
+					w = prev
+					smile[i].vol = math.Sqrt(w / years)
+					adjustments = append(adjustments, VolAdjustment{Contract: p.iv.Contract, Kind: VolCalendar,
+						Vol: p.iv.MidIV, Smoothed: smile[i].vol})
+				}
+			}
+			sl.moneyness = append(sl.moneyness, k)
+			sl.variance = append(sl.variance, w)
+		}
+		s.slices = append(s.slices, sl)
+	}
+
+	order := make(map[*Contract]int, len(ivs))
+	for i, iv := range ivs {
+		order[iv.Contract] = i
+	}
+	sort.SliceStable(adjustments, func(i, j int) bool {
+		return order[adjustments[i].Contract] < order[adjustments[j].Contract]
+	})
+	return s, adjustments
+}
+
+// smoothButterfly makes the forward call prices of a smile, in increasing order of strikes, convex and decreasing
+// by the least sum of absolute changes, solved as a linear program on the prices in units of the forward. The
+// prices are kept above those of the floor total variances of the strikes when floor is set. The smile is returned
+// unchanged with the error of the solver
+func smoothButterfly(smile []smilePoint, years float64, floor []float64) ([]smilePoint, []VolAdjustment, error) {
+	n := len(smile)
+	if n < 2 && floor == nil {
+		return smile, nil, nil
+	}
+	fwd := smile[0].iv.Forward
+	k := make([]float64, n)
+	price := make([]float64, n)
+	lower := make([]float64, n)
+	for i, p := range smile {
+		k[i] = p.strike / fwd
+		price[i] = forwardOptionPrice(years, k[i], 1, p.vol, Call)
+		lower[i] = math.Max(1-k[i], 0)
+		if floor != nil && floor[i] > 0 {
+			lower[i] = math.Max(lower[i], forwardOptionPrice(years, k[i], 1, math.Sqrt(floor[i]/years), Call))
+		}
+	}
+
+	// variables are the rises u and falls v of each price, the new price being price + u - v
+	c := make([]float64, 2*n)
+	for i := range c {
+		c[i] = 1
+	}
+	var A [][]float64
+	var b []float64
+	// coef.(price + u - v) <= bound
+	add := func(coef map[int]float64, bound float64) {
+		row := make([]float64, 2*n)
+		for i, a := range coef {
+			row[i], row[n+i] = a, -a
+			bound -= a * price[i]
+		}
+		A, b = append(A, row), append(b, bound)
+	}
+	for i := 0; i < n; i++ {
+		add(map[int]float64{i: -1}, -lower[i]) // above intrinsic and the floor
+		add(map[int]float64{i: 1}, 1)          // below the forward
+	}
+	// slopes above -1, increasing, and below 0
+	if n >= 2 {
+		add(map[int]float64{0: 1 / (k[1] - k[0]), 1: -1 / (k[1] - k[0])}, 1)
+		for i := 1; i < n-1; i++ {
+			lo, hi := 1/(k[i]-k[i-1]), 1/(k[i+1]-k[i])
+			add(map[int]float64{i - 1: -lo, i: lo + hi, i + 1: -hi}, 0)
+		}
+		add(map[int]float64{n - 2: -1 / (k[n-1] - k[n-2]), n - 1: 1 / (k[n-1] - k[n-2])}, 0)
+	}
+
+	x, err := solveLP(c, A, b)
+	if err != nil {
+		return smile, nil, err
+	}
+	var res []smilePoint
+	var adjustments []VolAdjustment
+	for i, p := range smile {
+		change := x[i] - x[n+i]
+		if math.Abs(change) < 1e-7 {
+			res = append(res, p)
+			continue
+		}
+		kind := VolButterfly
+		if floor != nil && floor[i] > 0 && lower[i] > math.Max(1-k[i], 0) && price[i]+change-lower[i] < 1e-7 {
+			kind = VolCalendar // raised to the floor
+		}
+		vol, quality := solveForwardIV(price[i]+change, 1, k[i], years, Call)
+		if quality != 0 || !(vol > 0) {
+			adjustments = append(adjustments, VolAdjustment{Contract: p.iv.Contract, Kind: kind, Vol: p.iv.MidIV,
+				Smoothed: math.NaN()})
+			continue
+		}
+		if floor != nil {
+			vol = math.Max(vol, math.Sqrt(floor[i]/years)) // solver tolerance
+		}
+		p.vol = vol
+		res = append(res, p)
+		adjustments = append(adjustments, VolAdjustment{Contract: p.iv.Contract, Kind: kind, Vol: p.iv.MidIV,
+			Smoothed: vol})
+	}
+	return res, adjustments, nil
+}